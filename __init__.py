@@ -192,6 +192,13 @@ def scene_load(context):
     ):  # factory_start is used in bg blender runs, but we want to run for tests in background mode
         preferences = bpy.context.preferences.addons[__package__].preferences
         preferences.login_attempt = False
+    timer.update_download_dirs()
+
+
+@persistent
+def scene_save(context):
+    """Project subdirectory of the local download directory moves with the .blend file."""
+    timer.update_download_dirs()
 
 
 conditions = (
@@ -1837,6 +1844,7 @@ def fix_subdir(self, context):
             " It's a directory BlenderKit creates where your .blend is \n "
             "and uses it for storing assets.",
         )
+    timer.update_download_dirs()
 
 
 def update_unpack(self, context):
@@ -1936,7 +1944,7 @@ class BlenderKitAddonPreferences(AddonPreferences):
         description="Global storage for your assets, will use subdirectories for the contents. Client will place its files in subdirectory 'client'",
         subtype="DIR_PATH",
         default=default_global_dict,
-        update=utils.save_prefs,
+        update=timer.save_prefs_and_update_download_dirs,
     )
 
     project_subdir: StringProperty(
@@ -2026,7 +2034,7 @@ class BlenderKitAddonPreferences(AddonPreferences):
         ),
         description="Secure communication between BlenderKit-client and blenderkit.com server by SSL",
         default="ENABLED",
-        update=timer.save_prefs_cancel_all_tasks_and_restart_daemon,
+        update=timer.save_prefs_and_reload_network_settings,
     )
 
     proxy_which: EnumProperty(
@@ -2058,10 +2066,16 @@ class BlenderKitAddonPreferences(AddonPreferences):
                 "The add-on will use specified custom proxy settings; system's and environment proxy settings will be ignored. "
                 'Please set the address in the "Custom proxy address" field below. ',
             ),
+            (
+                "PAC",
+                "PAC: use proxy auto-configuration script",
+                "The add-on will download the proxy auto-configuration (PAC) script from the URL set below "
+                "and use the proxy it decides for BlenderKit servers. If the script fails, connections go directly.",
+            ),
         ),
         description="Configure proxy settings for all outgoing HTTPS requests",
         default="SYSTEM",
-        update=timer.save_prefs_cancel_all_tasks_and_restart_daemon,
+        update=timer.save_prefs_and_reload_network_settings,
     )
 
     proxy_address: StringProperty(
@@ -2073,7 +2087,21 @@ If you use simple HTTP proxy, set in format http://ip:port, or http://username:p
 HTTPS proxies are not supported! We wait for support in Python 3.11 and in aiohttp module. You can specify the HTTPS proxy with https:// prefix for hacking around and development purposes, but functionality cannot be guaranteed.
 In this case you should also set path to your system CA bundle containing proxy's certificates in the field "Custom CA certificates path" below""",
         default="",
-        update=timer.save_prefs_cancel_all_tasks_and_restart_daemon,
+        update=timer.save_prefs_and_reload_network_settings,
+    )
+
+    proxy_pac_url: StringProperty(
+        name="Proxy auto-configuration URL",
+        description="URL of the proxy auto-configuration (PAC) script, e.g. http://wpad.example.com/proxy.pac or file:///path/to/proxy.pac",
+        default="",
+        update=timer.save_prefs_and_reload_network_settings,
+    )
+
+    proxy_bypass: StringProperty(
+        name="Bypass proxy for",
+        description="Comma separated hosts which are connected directly even when a proxy is used, e.g. *.s3.amazonaws.com if the proxy blocks uploads. Localhost and hosts from NO_PROXY environment variable are always connected directly",
+        default="",
+        update=timer.save_prefs_and_reload_network_settings,
     )
 
     trusted_ca_certs: StringProperty(
@@ -2121,7 +2149,7 @@ In this case you should also set path to your system CA bundle containing proxy'
         ),
         description="Determines the locations used for storing downloaded asset data.",
         default="BOTH",
-        update=utils.save_prefs,
+        update=timer.save_prefs_and_update_download_dirs,
     )
 
     thumbnail_use_gpu: BoolProperty(
@@ -2292,6 +2320,10 @@ In this case you should also set path to your system CA bundle containing proxy'
         network_settings.prop(self, "proxy_which")
         if self.proxy_which == "CUSTOM":
             network_settings.prop(self, "proxy_address")
+        if self.proxy_which == "PAC":
+            network_settings.prop(self, "proxy_pac_url")
+        if self.proxy_which != "NONE":
+            network_settings.prop(self, "proxy_bypass")
         network_settings.prop(self, "trusted_ca_certs")
 
         # UPDATER SETTINGS
@@ -2409,6 +2441,7 @@ def register():
     timer.register_timers()
 
     bpy.app.handlers.load_post.append(scene_load)
+    bpy.app.handlers.save_post.append(scene_save)
     # detect if the user just enabled the addon in preferences, thus enable to run
     for w in bpy.context.window_manager.windows:
         for a in w.screen.areas:
@@ -2477,3 +2510,4 @@ def unregister():
     bpy.utils.unregister_class(BlenderKitAddonPreferences)
 
     bpy.app.handlers.load_post.remove(scene_load)
+    bpy.app.handlers.save_post.remove(scene_save)
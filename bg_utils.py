@@ -52,6 +52,11 @@ def download_asset_file(asset_data, resolution="blend", api_key=""):
     response = daemon_lib.blocking_file_download(
         str(res_file_info["url"]), filepath=file_name, api_key=api_key
     )
+    if not response.ok:
+        bk_logger.error(
+            f"Download of {file_name} failed: {response.status_code} {response.text}"
+        )
+        return None
     return file_name
 
 
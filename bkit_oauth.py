@@ -30,7 +30,15 @@ from webbrowser import open_new_tab
 import bpy
 from bpy.props import BoolProperty
 
-from . import daemon_lib, daemon_tasks, global_vars, reports, tasks_queue, utils
+from . import (
+    daemon_lib,
+    daemon_tasks,
+    global_vars,
+    paths,
+    reports,
+    tasks_queue,
+    utils,
+)
 
 
 CLIENT_ID = "IdFRwa3SGA8eMpzhRVFMg5Ts8sPK93xBjif93x0F"
@@ -57,6 +65,14 @@ def handle_login_task(task: daemon_tasks.Task):
         reports.add_report(task.message, 5, "ERROR")
 
 
+def handle_manual_login_task(task: daemon_tasks.Task):
+    """Handle incoming task of type login/manual. Tokens are written by handle_login_task, here only the failure is reported."""
+    if task.status == "error":
+        preferences = bpy.context.preferences.addons[__package__].preferences
+        preferences.login_attempt = False
+        reports.add_report(task.message, 5, "ERROR")
+
+
 def handle_token_refresh_task(task: daemon_tasks.Task):
     """Handle incoming task of type token_refresh. If the new token is meant for the current user, calls handle_login_task.
     Otherwise it ignores the incoming task.
@@ -97,6 +113,15 @@ def handle_logout_task(task: daemon_tasks.Task):
         clean_login_data()
 
 
+def handle_server_changed_task(task: daemon_tasks.Task):
+    """Handle server_changed task, BlenderKit-Client now talks to another server.
+    Tokens are valid only on the previous server, so the user has to log in again."""
+    global_vars.SERVER = task.result["server"]
+    paths.update_server_urls()
+    clean_login_data()
+    reports.add_report(task.message, 5, "INFO")
+
+
 def clean_login_data():
     preferences = bpy.context.preferences.addons[__package__].preferences
     preferences.login_attempt = False
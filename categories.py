@@ -50,6 +50,9 @@ def filter_categories(categories):
 
 def get_category_path(categories, category):
     """finds the category in all possible subcategories and returns the path to it"""
+    entry = global_vars.DATA.get("bkit_categories_index", {}).get(category)
+    if entry is not None:
+        return entry["path"].split("/")
     category_path = []
     check_categories = categories[:]
     parents = {}
@@ -74,6 +77,9 @@ def get_category_path(categories, category):
 
 def get_category_name_path(categories, category):
     """finds the category in all possible subcategories and returns the path to it"""
+    index = global_vars.DATA.get("bkit_categories_index", {})
+    if category in index:
+        return [index[slug]["name"] for slug in index[category]["path"].split("/")]
     category_path = []
     check_categories = categories[:]
     parents = {}
@@ -132,14 +138,16 @@ def handle_categories_task(task: daemon_tasks.Task):
         "BRUSH": "brush",
     }
     if task.status == "finished":
-        global_vars.DATA["bkit_categories"] = task.result
+        global_vars.DATA["bkit_categories"] = task.result["categories"]
+        global_vars.DATA["bkit_categories_index"] = task.result["index"]
         with open(categories_filepath, "w", encoding="utf-8") as file:
             json.dump(
-                task.result, file, ensure_ascii=False, indent=4
+                task.result["categories"], file, ensure_ascii=False, indent=4
             )  # TODO: do this in Client, just saving the file so next time it is updated even without internet
         return
 
     bk_logger.warning(task.message)
+    global_vars.DATA.pop("bkit_categories_index", None)  # tree from the file is walked instead
     if not os.path.exists(categories_filepath):
         source_path = paths.get_addon_file(subpath="data" + os.sep + "categories.json")
         try:
@@ -0,0 +1,57 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/blenderkit/blenderkit/client/internal/api"
+)
+
+// serverAPI returns the typed client of the current server with the headers of the task.
+// GET requests are repeated while the server is under maintenance and the task is informed about it.
+// Task can be nil, then the maintenance is not reported.
+func serverAPI(meta RequestMeta, task *Task) *api.Client {
+	return &api.Client{
+		BaseURL:    currentServer(),
+		HTTPClient: ClientAPI,
+		Header:     getHeaders(meta),
+		Do: func(client *http.Client, req *http.Request) (*http.Response, error) {
+			resp, err := doAPI(client, req)
+			return resp, checkClockSkew(err)
+		},
+		ParseError: func(resp *http.Response) (string, string) {
+			respJSON, respString, _ := ParseFailedHTTPResponse(resp)
+			return ResponseDetail(respJSON, respString), respString
+		},
+		CheckJSON: RespIsJSON,
+		Retry: api.RetryPolicy{
+			MaxRetries: MaintenanceMaxRetries,
+			Delay:      MaintenanceRetryDelay,
+			Statuses:   []int{http.StatusServiceUnavailable},
+			OnRetry: func(_ int, delay time.Duration) {
+				if task == nil {
+					return
+				}
+				reportMaintenanceWait(task, delay)
+			},
+		},
+	}
+}
@@ -0,0 +1,108 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrCodeInvalidAPIKey is the error code of tasks which were not sent to the server, because the API key is malformed.
+const ErrCodeInvalidAPIKey = "invalid_api_key"
+
+// Shape of API keys and OAuth tokens accepted by the server, anything else would only get 401.
+const (
+	APIKeyMinLength = 16
+	APIKeyMaxLength = 512
+)
+
+// apiKeyPasteArtifacts are trimmed from both ends of the API key: whitespace and line endings, quotes added
+// by the copy button of the website and zero-width characters and BOM which come with text copied from web pages.
+const apiKeyPasteArtifacts = " \t\r\n\"'`\u200b\ufeff"
+
+// InvalidAPIKeyError is returned for requests with an API key which the server would surely refuse.
+type InvalidAPIKeyError struct {
+	Reason string
+}
+
+func (e *InvalidAPIKeyError) Error() string {
+	return fmt.Sprintf("API key is invalid (%s), please log in again or paste the API key again", e.Reason)
+}
+
+// apiKeyCleanupLogged makes sure the warning about the cleaned API key is logged only once.
+var apiKeyCleanupLogged atomic.Bool
+
+// sanitizeAPIKey removes paste artifacts from the API key. Returns the cleaned key and the error if the cleaned key
+// does not have the shape of an API key. Empty key is valid, it is used by anonymous users.
+func sanitizeAPIKey(key string) (string, error) {
+	cleaned := strings.Trim(key, apiKeyPasteArtifacts)
+	if len(cleaned) > len("Bearer ") && strings.EqualFold(cleaned[:len("Bearer ")], "Bearer ") {
+		cleaned = strings.Trim(cleaned[len("Bearer "):], apiKeyPasteArtifacts)
+	}
+	if cleaned != key && apiKeyCleanupLogged.CompareAndSwap(false, true) {
+		BKLog.Printf("%s API key contains whitespace, quotes or other paste artifacts, using the cleaned key", EmoWarning)
+	}
+	return cleaned, validateAPIKeyShape(cleaned)
+}
+
+// validateAPIKeyShape checks the length and characters of the key, allowed are the characters of RFC 6750 bearer tokens.
+func validateAPIKeyShape(key string) error {
+	if key == "" {
+		return nil
+	}
+	if len(key) < APIKeyMinLength || len(key) > APIKeyMaxLength {
+		return &InvalidAPIKeyError{Reason: fmt.Sprintf("length %d is not between %d and %d", len(key), APIKeyMinLength, APIKeyMaxLength)}
+	}
+	for _, c := range key {
+		if !isAPIKeyChar(c) {
+			return &InvalidAPIKeyError{Reason: fmt.Sprintf("contains character %q", c)}
+		}
+	}
+	return nil
+}
+
+func isAPIKeyChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	default:
+		return strings.ContainsRune("-._~+/=", c)
+	}
+}
+
+// checkAuthorization refuses the request if its bearer token is not a valid API key, so it does not reach the server.
+func checkAuthorization(req *http.Request) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	return validateAPIKeyShape(token)
+}
+
+// apiKeyErrorCode returns ErrCodeInvalidAPIKey if err was caused by malformed API key, empty string otherwise.
+func apiKeyErrorCode(err error) string {
+	var keyErr *InvalidAPIKeyError
+	if errors.As(err, &keyErr) {
+		return ErrCodeInvalidAPIKey
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSanitizeAPIKey(t *testing.T) {
+	const key = "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k"
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"clean", key, key, false},
+		{"empty", "", "", false},
+		{"trailing newline", key + "\n", key, false},
+		{"CRLF", key + "\r\n", key, false},
+		{"surrounding spaces and tab", "  \t" + key + " ", key, false},
+		{"double quotes", `"` + key + `"`, key, false},
+		{"single quotes with newline", "'" + key + "'\n", key, false},
+		{"zero-width space and BOM", "\ufeff" + key + "\u200b", key, false},
+		{"bearer prefix", "Bearer " + key, key, false},
+		{"too short", "abc123", "abc123", true},
+		{"only quotes", `""`, "", false},
+		{"space inside", "Xk3fP9qLm2Rt 7vWz1aBc4dEf6gHj8k", "Xk3fP9qLm2Rt 7vWz1aBc4dEf6gHj8k", true},
+		{"newline inside", "Xk3fP9qLm2Rt\n7vWz1aBc4dEf6gHj8k", "Xk3fP9qLm2Rt\n7vWz1aBc4dEf6gHj8k", true},
+		{"non-ASCII", "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8ž", "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8ž", true},
+		{"too long", strings.Repeat("a", APIKeyMaxLength+1), strings.Repeat("a", APIKeyMaxLength+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeAPIKey(tt.input)
+			if got != tt.want {
+				t.Errorf("sanitizeAPIKey(%q) = %q; want %q", tt.input, got, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sanitizeAPIKey(%q) error = %v; wantErr %v", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSanitizeAPIKeyWarnsOnce(t *testing.T) {
+	var logged bytes.Buffer
+	defer func(l *log.Logger) { BKLog = l }(BKLog)
+	BKLog = log.New(&logged, "", 0)
+	apiKeyCleanupLogged.Store(false)
+
+	headers := getHeaders(RequestMeta{APIKey: "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k\r\n"})
+	getHeaders(RequestMeta{APIKey: `"Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k"`})
+	if auth := headers.Get("Authorization"); auth != "Bearer Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k" {
+		t.Errorf("Authorization = %q; want the cleaned key", auth)
+	}
+	if count := strings.Count(logged.String(), "paste artifacts"); count != 1 {
+		t.Errorf("cleaned API key warning logged %d times; want once, log: %s", count, logged.String())
+	}
+	if strings.Contains(logged.String(), "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k") {
+		t.Errorf("API key leaked to the log: %s", logged.String())
+	}
+}
+
+func TestInvalidAPIKeyNotSent(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &serverStatusTransport{base: http.DefaultTransport}}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header = getHeaders(RequestMeta{APIKey: "my api key"})
+	_, err := client.Do(req)
+	var keyErr *InvalidAPIKeyError
+	if !errors.As(err, &keyErr) {
+		t.Fatalf("request error = %v; want *InvalidAPIKeyError", err)
+	}
+	if code := apiKeyErrorCode(err); code != ErrCodeInvalidAPIKey {
+		t.Errorf("apiKeyErrorCode() = %q; want %q", code, ErrCodeInvalidAPIKey)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("server got %d requests; want none", n)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	req.Header = getHeaders(RequestMeta{APIKey: "Xk3fP9qLm2Rt7vWz1aBc4dEf6gHj8k\n"})
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request with cleaned API key failed: %v", err)
+	}
+	resp.Body.Close()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("server got %d requests; want 1", n)
+	}
+}
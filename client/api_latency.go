@@ -0,0 +1,259 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	APILatencyBucketWidth = 5 * time.Minute // Requests to the server are counted in buckets of this width
+	APILatencyHistory     = 24 * time.Hour  // Older buckets are dropped
+	APIQualityWindow      = time.Hour       // Window of the connection quality summary in the client_status task
+)
+
+// APILatencyBoundsMS are upper bounds of the latency histogram, the last bucket counts the slower requests.
+var APILatencyBoundsMS = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// latencyBucket counts the requests to one endpoint started within APILatencyBucketWidth.
+type latencyBucket struct {
+	start    time.Time
+	requests int64
+	errors   int64
+	counts   []int64 // by APILatencyBoundsMS, one more for slower requests
+}
+
+func newLatencyBucket(start time.Time) *latencyBucket {
+	return &latencyBucket{start: start, counts: make([]int64, len(APILatencyBoundsMS)+1)}
+}
+
+func (b *latencyBucket) add(other *latencyBucket) {
+	b.requests += other.requests
+	b.errors += other.errors
+	for i, count := range other.counts {
+		b.counts[i] += count
+	}
+}
+
+// percentileMS returns the histogram bound under which the q-th quantile of the latencies lies.
+// Requests slower than all bounds are reported as the highest bound.
+func (b *latencyBucket) percentileMS(q float64) int64 {
+	var total int64
+	for _, count := range b.counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for i, count := range b.counts {
+		seen += count
+		if seen >= rank && i < len(APILatencyBoundsMS) {
+			return APILatencyBoundsMS[i]
+		}
+	}
+	return APILatencyBoundsMS[len(APILatencyBoundsMS)-1]
+}
+
+// APILatencyStats keeps latency histograms and error counts of the requests to the server, per endpoint.
+type APILatencyStats struct {
+	mux       sync.Mutex
+	endpoints map[string][]*latencyBucket // oldest bucket first
+}
+
+var APILatency = &APILatencyStats{endpoints: map[string][]*latencyBucket{}}
+
+func (s *APILatencyStats) record(endpoint string, elapsed time.Duration, failed bool, now time.Time) {
+	start := now.Truncate(APILatencyBucketWidth)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	buckets := s.endpoints[endpoint]
+	cutoff := start.Add(-APILatencyHistory)
+	for len(buckets) > 0 && !buckets[0].start.After(cutoff) {
+		buckets = buckets[1:]
+	}
+	if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+		buckets = append(buckets, newLatencyBucket(start))
+	}
+	bucket := buckets[len(buckets)-1]
+	bucket.requests++
+	if failed {
+		bucket.errors++
+	}
+	i := 0
+	for i < len(APILatencyBoundsMS) && elapsed.Milliseconds() > APILatencyBoundsMS[i] {
+		i++
+	}
+	bucket.counts[i]++
+	s.endpoints[endpoint] = buckets
+}
+
+// merged sums the buckets of the matching endpoints which started within the window before now.
+func (s *APILatencyStats) merged(now time.Time, window time.Duration, match func(endpoint string) bool) *latencyBucket {
+	total := newLatencyBucket(now)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for endpoint, buckets := range s.endpoints {
+		if !match(endpoint) {
+			continue
+		}
+		for _, bucket := range buckets {
+			if now.Sub(bucket.start) < window {
+				total.add(bucket)
+			}
+		}
+	}
+	return total
+}
+
+// LatencyBucketMetrics are the requests to one endpoint within one bucket, reported in /metrics.
+type LatencyBucketMetrics struct {
+	Start    time.Time `json:"start"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	P50MS    int64     `json:"p50_ms"`
+	P95MS    int64     `json:"p95_ms"`
+}
+
+// EndpointLatencyMetrics are the requests to one endpoint within APILatencyHistory, reported in /metrics.
+type EndpointLatencyMetrics struct {
+	Requests  int64                  `json:"requests"`
+	Errors    int64                  `json:"errors"`
+	P50MS     int64                  `json:"p50_ms"`
+	P95MS     int64                  `json:"p95_ms"`
+	Histogram []int64                `json:"histogram"` // counts by bounds_ms, the last one for slower requests
+	Buckets   []LatencyBucketMetrics `json:"buckets"`
+}
+
+// APILatencyMetrics is the api_latency section of /metrics.
+type APILatencyMetrics struct {
+	BoundsMS  []int64                           `json:"bounds_ms"`
+	Endpoints map[string]EndpointLatencyMetrics `json:"endpoints"`
+}
+
+func (s *APILatencyStats) metrics(now time.Time) APILatencyMetrics {
+	metrics := APILatencyMetrics{BoundsMS: APILatencyBoundsMS, Endpoints: map[string]EndpointLatencyMetrics{}}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for endpoint, buckets := range s.endpoints {
+		total := newLatencyBucket(now)
+		endpointMetrics := EndpointLatencyMetrics{}
+		for _, bucket := range buckets {
+			if now.Sub(bucket.start) >= APILatencyHistory {
+				continue
+			}
+			total.add(bucket)
+			endpointMetrics.Buckets = append(endpointMetrics.Buckets, LatencyBucketMetrics{
+				Start:    bucket.start,
+				Requests: bucket.requests,
+				Errors:   bucket.errors,
+				P50MS:    bucket.percentileMS(0.5),
+				P95MS:    bucket.percentileMS(0.95),
+			})
+		}
+		if total.requests == 0 {
+			continue
+		}
+		endpointMetrics.Requests, endpointMetrics.Errors = total.requests, total.errors
+		endpointMetrics.P50MS, endpointMetrics.P95MS = total.percentileMS(0.5), total.percentileMS(0.95)
+		endpointMetrics.Histogram = total.counts
+		metrics.Endpoints[endpoint] = endpointMetrics
+	}
+	return metrics
+}
+
+// APIQuality summarizes the requests to the server within APIQualityWindow, so the add-on can hint at bad connection.
+type APIQuality struct {
+	Requests    int64   `json:"requests"`
+	ErrorRate   float64 `json:"error_rate"` // failed requests to all endpoints, 0-1
+	SearchP50MS int64   `json:"search_p50_ms"`
+	SearchP95MS int64   `json:"search_p95_ms"`
+	Summary     string  `json:"summary"`
+}
+
+const searchEndpoint = "/api/v1/search/"
+
+// quality returns nil if there were no requests to the server within APIQualityWindow.
+func (s *APILatencyStats) quality(now time.Time) *APIQuality {
+	all := s.merged(now, APIQualityWindow, func(string) bool { return true })
+	if all.requests == 0 {
+		return nil
+	}
+	search := s.merged(now, APIQualityWindow, func(endpoint string) bool { return endpoint == searchEndpoint })
+	quality := &APIQuality{
+		Requests:    all.requests,
+		ErrorRate:   float64(all.errors) / float64(all.requests),
+		SearchP50MS: search.percentileMS(0.5),
+		SearchP95MS: search.percentileMS(0.95),
+	}
+	errors := fmt.Sprintf("%.1f%% errors of %d requests", 100*quality.ErrorRate, quality.Requests)
+	if search.requests == 0 {
+		quality.Summary = "no searches, " + errors
+	} else {
+		quality.Summary = fmt.Sprintf("search p50 %s, p95 %s, %s", formatLatency(quality.SearchP50MS), formatLatency(quality.SearchP95MS), errors)
+	}
+	return quality
+}
+
+func formatLatency(ms int64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%d ms", ms)
+	}
+	return fmt.Sprintf("%.1f s", float64(ms)/1000)
+}
+
+var idSegment = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// endpointTemplate replaces IDs in the path, so requests for different assets are counted as one endpoint.
+func endpointTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isServerRequest reports whether the request goes to the BlenderKit server, other hosts are not recorded.
+func isServerRequest(req *http.Request) bool {
+	server, err := url.Parse(currentServer())
+	return err == nil && server.Host != "" && req.URL.Host == server.Host
+}
+
+// doAPI performs the request and records its latency and failure to APILatency.
+// Used by all calls of the server API, failed are requests without response and with 5xx status.
+func doAPI(client *http.Client, req *http.Request) (*http.Response, error) {
+	if !isServerRequest(req) {
+		return client.Do(req)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	APILatency.record(endpointTemplate(req.URL.Path), time.Since(start), failed, time.Now())
+	return resp, err
+}
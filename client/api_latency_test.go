@@ -0,0 +1,115 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEndpointTemplate(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/api/v1/search/": "/api/v1/search/",
+		"/api/v1/assets/0992088b-fb84-4c69-bb6e-426272970c8b/download/": "/api/v1/assets/{id}/download/",
+		"/api/v1/comments/assets-uuidasset/1234/":                       "/api/v1/comments/assets-uuidasset/{id}/",
+		"/api/v1/downloads/5a3f9e0c1b2d4e6f7a8b/":                       "/api/v1/downloads/{id}/",
+		"/api/v1/ratings/quality/":                                      "/api/v1/ratings/quality/",
+	} {
+		if actual := endpointTemplate(path); actual != expected {
+			t.Errorf("endpointTemplate(%q) = %q; want %q", path, actual, expected)
+		}
+	}
+}
+
+func TestAPILatencyStats(t *testing.T) {
+	stats := &APILatencyStats{endpoints: map[string][]*latencyBucket{}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	stats.record(searchEndpoint, 80*time.Millisecond, false, now.Add(-25*time.Hour)) // dropped with the next record
+	for i := 0; i < 18; i++ {
+		stats.record(searchEndpoint, 80*time.Millisecond, false, now.Add(-10*time.Minute))
+	}
+	stats.record(searchEndpoint, 3*time.Second, false, now)
+	stats.record(searchEndpoint, time.Minute, true, now)
+	stats.record("/api/v1/me/", 40*time.Millisecond, true, now.Add(-2*time.Hour)) // outside of the quality window
+
+	metrics := stats.metrics(now)
+	search := metrics.Endpoints[searchEndpoint]
+	if search.Requests != 20 || search.Errors != 1 || len(search.Buckets) != 2 {
+		t.Fatalf("search metrics: %d requests, %d errors in %d buckets; want 20, 1 in 2", search.Requests, search.Errors, len(search.Buckets))
+	}
+	if search.P50MS != 100 || search.P95MS != 5000 {
+		t.Errorf("search percentiles: p50 %d ms, p95 %d ms; want 100, 5000", search.P50MS, search.P95MS)
+	}
+	if last := search.Histogram[len(search.Histogram)-1]; last != 1 {
+		t.Errorf("requests slower than all bounds: %d; want 1", last)
+	}
+	if me := metrics.Endpoints["/api/v1/me/"]; me.Requests != 1 || me.Errors != 1 {
+		t.Errorf("/api/v1/me/ metrics: %+v", me)
+	}
+
+	quality := stats.quality(now)
+	if quality == nil || quality.Requests != 20 || quality.ErrorRate != 0.05 || quality.SearchP95MS != 5000 {
+		t.Fatalf("quality: %+v; want 20 requests, 5%% errors, search p95 5000 ms", quality)
+	}
+	if expected := "search p50 100 ms, p95 5.0 s, 5.0% errors of 20 requests"; quality.Summary != expected {
+		t.Errorf("quality summary %q; want %q", quality.Summary, expected)
+	}
+	if quality := stats.quality(now.Add(3 * time.Hour)); quality != nil {
+		t.Errorf("quality without recent requests: %+v; want nil", quality)
+	}
+}
+
+func TestDoAPIRecordsServerRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/broken/" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	APILatency.mux.Lock()
+	APILatency.endpoints = map[string][]*latencyBucket{}
+	APILatency.mux.Unlock()
+
+	for _, rawURL := range []string{
+		server.URL + "/api/v1/search/?query=chair",
+		server.URL + "/api/v1/broken/",
+		other.URL + "/api/v1/search/",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		resp, err := doAPI(server.Client(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", rawURL, err)
+		}
+		resp.Body.Close()
+	}
+
+	metrics := APILatency.metrics(time.Now())
+	if search := metrics.Endpoints[searchEndpoint]; search.Requests != 1 || search.Errors != 0 {
+		t.Errorf("search: %d requests, %d errors; want only the request to the server", search.Requests, search.Errors)
+	}
+	if broken := metrics.Endpoints["/api/v1/broken/"]; broken.Requests != 1 || broken.Errors != 1 {
+		t.Errorf("5xx response: %d requests, %d errors; want counted as error", broken.Requests, broken.Errors)
+	}
+}
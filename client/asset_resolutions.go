@@ -0,0 +1,199 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AssetResolutionsData is expected from the add-on on /asset/resolutions.
+// Files of the asset are sent directly, or fetched from the server by AssetBaseID if Files is empty.
+type AssetResolutionsData struct {
+	AppID           int         `json:"app_id"`
+	APIKey          string      `json:"api_key"`
+	AddonVersion    string      `json:"addon_version"`
+	PlatformVersion string      `json:"platform_version"`
+	RequestID       string      `json:"request_id"`
+	AssetBaseID     string      `json:"asset_base_id"`
+	ID              string      `json:"id"`   // ID of the asset version, needed to find the files on disk
+	Name            string      `json:"name"` // name of the asset, needed to find the files on disk
+	Files           []AssetFile `json:"files"`
+	DownloadDirs    []string    `json:"download_dirs"`
+}
+
+// AssetResolution is one downloadable file of the asset in the response of /asset/resolutions.
+type AssetResolution struct {
+	FileType  string `json:"file_type"`  // "blend" or "resolution_2K" etc., as in files of the asset
+	Label     string `json:"label"`      // "Original", "2K" etc.
+	FileSize  int64  `json:"file_size"`  // 0 if unknown
+	SizeLabel string `json:"size_label"` // e.g. "48.0 MB", empty if size is unknown
+	Cached    bool   `json:"cached"`
+	FilePath  string `json:"file_path,omitempty"` // path of the file on disk if cached
+}
+
+// placeholderFileID stands for the ID of the file in the server filename, which is known only from the download URL.
+// It has the length of a real ID, so downloadFilepath() shortens the path the same way as for the real file.
+const placeholderFileID = "00000000-0000-0000-0000-000000000000"
+
+// AssetResolutionsHandler lists the downloadable files of the asset with their sizes and whether they are on disk,
+// so the add-on can show them in the resolution selector.
+func AssetResolutionsHandler(w http.ResponseWriter, r *http.Request) {
+	var data AssetResolutionsData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateAppID(data.AppID); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if len(data.Files) == 0 {
+		if err := validateUUID("asset_base_id", data.AssetBaseID); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		asset, err := fetchAssetByBaseID(data)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "error fetching asset: "+err.Error())
+			return
+		}
+		data.ID, data.Name, data.Files = asset.ID, asset.Name, asset.Files
+	}
+	if len(data.DownloadDirs) > 0 {
+		if err := validateUUID("id", data.ID); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"resolutions": assetResolutions(data)})
+}
+
+// assetResolutions returns the non-thumbnail files ordered from the smallest resolution, original file last.
+func assetResolutions(data AssetResolutionsData) []AssetResolution {
+	resolutions := []AssetResolution{}
+	for _, file := range data.Files {
+		if file.FileType == "thumbnail" || file.FileType == "" {
+			continue
+		}
+		res := AssetResolution{FileType: file.FileType, Label: resolutionLabel(file.FileType), FileSize: file.FileSize.Int64()}
+		if res.FileSize > 0 {
+			res.SizeLabel = formatBytes(res.FileSize)
+		}
+		for _, dir := range data.DownloadDirs {
+			if path, ok := findResolutionFile(dir, data.Name, data.ID, file.FileType); ok {
+				res.Cached, res.FilePath = true, path
+				break
+			}
+		}
+		resolutions = append(resolutions, res)
+	}
+	sort.SliceStable(resolutions, func(i, j int) bool {
+		return resolutionOrder(resolutions[i].FileType) < resolutionOrder(resolutions[j].FileType)
+	})
+	return resolutions
+}
+
+// resolutionLabel converts the file type to the label shown in the add-on: "resolution_0_5K" > "0.5K", "blend" > "Original".
+func resolutionLabel(fileType string) string {
+	if fileType == "blend" {
+		return "Original"
+	}
+	if _, ok := resolutionPixels[fileType]; ok {
+		return strings.Replace(strings.TrimPrefix(fileType, "resolution_"), "_", ".", 1)
+	}
+	return fileType
+}
+
+// resolutionOrder sorts resolution files by their size in pixels, other files after them and the original last.
+func resolutionOrder(fileType string) int {
+	if pixels, ok := resolutionPixels[fileType]; ok {
+		return pixels
+	}
+	if fileType == "blend" {
+		return 1 << 30
+	}
+	return 1 << 29
+}
+
+// findResolutionFile looks for the file of the resolution in the download dir. Path is built by downloadFilepath()
+// like in GetDownloadFilepaths(), but the ID of the file is not known without asking the server for the download URL,
+// so any file with a valid ID in its place matches. Partial downloads are ignored.
+func findResolutionFile(dir, assetName, assetID, fileType string) (string, bool) {
+	serverFilename := fmt.Sprintf("%s_%s.blend", fileType, placeholderFileID)
+	filePath, _, ok := downloadFilepath(dir, assetName, assetID, serverFilename, downloadPathLimit())
+	if !ok {
+		return "", false
+	}
+	prefix := strings.TrimSuffix(filepath.Base(filePath), placeholderFileID+".blend")
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, ".part") {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if len(rest) <= len(placeholderFileID) || rest[len(placeholderFileID)] != '.' {
+			continue
+		}
+		if _, err := uuid.Parse(rest[:len(placeholderFileID)]); err != nil {
+			continue
+		}
+		return filepath.Join(filepath.Dir(filePath), name), true
+	}
+	return "", false
+}
+
+// fetchAssetByBaseID fetches the latest version of the asset from the search API.
+func fetchAssetByBaseID(data AssetResolutionsData) (Asset, error) {
+	query := url.Values{"query": {"asset_base_id:" + data.AssetBaseID}}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/search/?%s", currentServer(), query.Encode()), nil)
+	if err != nil {
+		return Asset{}, err
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	resp, err := doAPI(ClientAPI, req)
+	if err != nil {
+		return Asset{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		return Asset{}, fmt.Errorf("%s (%s)", respString, resp.Status)
+	}
+	var results SearchResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return Asset{}, fmt.Errorf("decoding response: %w", err)
+	}
+	if len(results.Results) == 0 {
+		return Asset{}, fmt.Errorf("asset %s not found", data.AssetBaseID)
+	}
+	return results.Results[0], nil
+}
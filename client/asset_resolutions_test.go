@@ -0,0 +1,107 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssetResolutions(t *testing.T) {
+	const assetID = "d5368c9d-092e-4319-afe1-dd765de6da01"
+	projectDir, globalDir := t.TempDir(), t.TempDir()
+	data := DownloadData{DownloadDirs: []string{projectDir, globalDir}, DownloadAssetData: DownloadAssetData{Name: "Wooden Chair", ID: assetID}}
+	write := func(dir, serverFilename string) string {
+		data.DownloadDirs = []string{dir}
+		filePaths, _, _ := GetDownloadFilepaths(data, serverFilename)
+		if err := os.WriteFile(filePaths[0], []byte("blend"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePaths[0]
+	}
+	cached2K := write(globalDir, "resolution_2K_0992088b-fb84-4c69-bb6e-426272970c8b.blend")
+	cachedOriginal := write(projectDir, "blend_0992088b-fb84-4c69-bb6e-426272970c8c.blend")
+	write(projectDir, "resolution_4K_0992088b-fb84-4c69-bb6e-426272970c8d.blend.part") // unfinished download
+
+	resolutions := assetResolutions(AssetResolutionsData{
+		ID:           assetID,
+		Name:         "Wooden Chair",
+		DownloadDirs: []string{projectDir, globalDir},
+		Files: []AssetFile{
+			{FileType: "thumbnail"},
+			{FileType: "blend", FileSize: "104857600"},
+			{FileType: "resolution_4K", FileSize: "52428800"},
+			{FileType: "resolution_2K"},
+			{FileType: "resolution_0_5K", FileSize: "524288"},
+		},
+	})
+	want := []AssetResolution{
+		{FileType: "resolution_0_5K", Label: "0.5K", FileSize: 524288, SizeLabel: "512 KB"},
+		{FileType: "resolution_2K", Label: "2K", Cached: true, FilePath: cached2K},
+		{FileType: "resolution_4K", Label: "4K", FileSize: 52428800, SizeLabel: "50.0 MB"},
+		{FileType: "blend", Label: "Original", FileSize: 104857600, SizeLabel: "100.0 MB", Cached: true, FilePath: cachedOriginal},
+	}
+	if !reflect.DeepEqual(resolutions, want) {
+		t.Errorf("assetResolutions() =\n%+v\nwant\n%+v", resolutions, want)
+	}
+}
+
+func TestAssetResolutionsHandlerFetchesAsset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") != "asset_base_id:0992088b-fb84-4c69-bb6e-426272970c8b" {
+			t.Errorf("unexpected search query %q", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count": 1, "results": [{"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "name": "Chair",
+			"files": [{"fileType": "blend", "fileSize": 2048}, {"fileType": "resolution_1K", "fileSize": null}]}]}`))
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	body := `{"app_id": 643, "asset_base_id": "0992088b-fb84-4c69-bb6e-426272970c8b", "download_dirs": ["` + filepath.ToSlash(t.TempDir()) + `"]}`
+	req := httptest.NewRequest(http.MethodPost, "/asset/resolutions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	AssetResolutionsHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200, body: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Resolutions []AssetResolution `json:"resolutions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []AssetResolution{
+		{FileType: "resolution_1K", Label: "1K"},
+		{FileType: "blend", Label: "Original", FileSize: 2048, SizeLabel: "2 KB"},
+	}
+	if !reflect.DeepEqual(response.Resolutions, want) {
+		t.Errorf("resolutions = %+v; want %+v", response.Resolutions, want)
+	}
+}
@@ -0,0 +1,254 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// bkclientjs endpoints are called cross-origin by the website, e.g. by its "Get this asset in Blender" button.
+const (
+	SoftwareReportTimeout = 30 * time.Second // software which has not reported for longer is not offered to the website
+	BkclientjsRateLimit   = 10               // max requests of the website per BkclientjsRateWindow
+	BkclientjsRateWindow  = time.Minute
+
+	ErrCodeNoSoftware  = "no_software"
+	ErrCodeRateLimited = "rate_limited"
+)
+
+// Software is an app connected to the Client, e.g. Blender with the add-on, registered on each /report.
+type Software struct {
+	AppID           int       `json:"app_id"`
+	AddonVersion    string    `json:"addon_version"`
+	PlatformVersion string    `json:"platform_version"`
+	LastReport      time.Time `json:"last_report"`
+	apiKey          string    // from the last report, never sent to the website; replay of the offline queue uses it
+}
+
+var (
+	AvailableSoftwares    = make(map[int]*Software)
+	AvailableSoftwaresMux sync.Mutex
+
+	bkclientjsRequests    []time.Time // times of the requests in the current window of the rate limit
+	bkclientjsRequestsMux sync.Mutex
+)
+
+// WebGetAssetData is the request of /bkclientjs/get_asset.
+type WebGetAssetData struct {
+	AssetBaseID   string `json:"asset_base_id"`
+	APIKey        string `json:"api_key"` // Optional, needed for private assets
+	SoftwareAppID int    `json:"software_app_id"`
+}
+
+// registerSoftware records the report of the software, so the website can send assets to it.
+func registerSoftware(data MinimalTaskData) {
+	AvailableSoftwaresMux.Lock()
+	defer AvailableSoftwaresMux.Unlock()
+	AvailableSoftwares[data.AppID] = &Software{
+		AppID:           data.AppID,
+		AddonVersion:    data.AddonVersion,
+		PlatformVersion: data.PlatformVersion,
+		LastReport:      time.Now(),
+		apiKey:          data.APIKey,
+	}
+}
+
+// unregisterSoftware removes the software which unsubscribed from the Client.
+func unregisterSoftware(appID int) {
+	AvailableSoftwaresMux.Lock()
+	defer AvailableSoftwaresMux.Unlock()
+	delete(AvailableSoftwares, appID)
+}
+
+// availableSoftwares returns the softwares which reported within SoftwareReportTimeout, ordered by AppID.
+func availableSoftwares() []Software {
+	AvailableSoftwaresMux.Lock()
+	defer AvailableSoftwaresMux.Unlock()
+	softwares := []Software{}
+	for _, software := range AvailableSoftwares {
+		if time.Since(software.LastReport) <= SoftwareReportTimeout {
+			softwares = append(softwares, *software)
+		}
+	}
+	sort.Slice(softwares, func(i, j int) bool { return softwares[i].AppID < softwares[j].AppID })
+	return softwares
+}
+
+// BkclientjsAnyOrigin allows any origin to call bkclientjs endpoints, set by flag for developers testing local frontends.
+var BkclientjsAnyOrigin = false
+
+// bkclientjsOrigins are the origins of the website allowed to call bkclientjs endpoints,
+// the origin of the -server is allowed too, so the staging website works with the staging server.
+func bkclientjsOrigins() []string {
+	origins := []string{"https://blenderkit.com", "https://www.blenderkit.com"}
+	if u, err := url.Parse(currentServer()); err == nil && u.Scheme != "" && u.Host != "" {
+		origins = append(origins, fmt.Sprintf("%s://%s", u.Scheme, u.Host))
+	}
+	return origins
+}
+
+// bkclientjsOriginAllowed reports whether the website on origin may call bkclientjs endpoints.
+func bkclientjsOriginAllowed(origin string) bool {
+	if BkclientjsAnyOrigin {
+		return true
+	}
+	for _, allowed := range bkclientjsOrigins() {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders allows the website to call the endpoint with the methods. Returns false for a request from a foreign page,
+// requests without Origin come from other local programs than a browser and are allowed.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, methods string) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !bkclientjsOriginAllowed(origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", methods)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "600")
+		if r.Header.Get("Access-Control-Request-Private-Network") == "true" { // website calling localhost in Chrome
+			w.Header().Set("Access-Control-Allow-Private-Network", "true")
+		}
+	}
+	return true
+}
+
+// allowBkclientjsRequest counts the request into the rate limit, returns false and time to wait when the limit is reached.
+func allowBkclientjsRequest(now time.Time) (bool, time.Duration) {
+	bkclientjsRequestsMux.Lock()
+	defer bkclientjsRequestsMux.Unlock()
+	recent := bkclientjsRequests[:0]
+	for _, t := range bkclientjsRequests {
+		if now.Sub(t) < BkclientjsRateWindow {
+			recent = append(recent, t)
+		}
+	}
+	bkclientjsRequests = recent
+	if len(recent) >= BkclientjsRateLimit {
+		return false, BkclientjsRateWindow - now.Sub(recent[0])
+	}
+	bkclientjsRequests = append(bkclientjsRequests, now)
+	return true, 0
+}
+
+// bkclientjsPreamble sets CORS headers, answers the preflight and rejects foreign pages.
+// Returns false if the request was already responded.
+func bkclientjsPreamble(w http.ResponseWriter, r *http.Request, methods string) bool {
+	if !setCORSHeaders(w, r, methods) {
+		writeJSONError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("origin %s is not allowed", r.Header.Get("Origin")))
+		return false
+	}
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	return true
+}
+
+// BkclientjsStatusHandler reports the Client and the connected softwares to the website.
+func BkclientjsStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !bkclientjsPreamble(w, r, "GET") {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"client_version": ClientVersion,
+		"softwares":      availableSoftwares(),
+	})
+}
+
+// BkclientjsGetAssetHandler sends the asset from the website to the connected software as "web_get_asset" task,
+// the add-on downloads it with its own preferences on the next report.
+func BkclientjsGetAssetHandler(w http.ResponseWriter, r *http.Request) {
+	if !bkclientjsPreamble(w, r, "POST") {
+		return
+	}
+	if ok, wait := allowBkclientjsRequest(time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeJSONError(w, http.StatusTooManyRequests, ErrCodeRateLimited, fmt.Sprintf("too many requests, max %d per %s", BkclientjsRateLimit, BkclientjsRateWindow))
+		return
+	}
+
+	var data WebGetAssetData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(
+		validateUUID("asset_base_id", data.AssetBaseID),
+		validateUTF8("api_key", data.APIKey),
+	); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	softwares := availableSoftwares()
+	if len(softwares) == 0 {
+		writeJSONError(w, http.StatusConflict, ErrCodeNoSoftware, "no software is connected to BlenderKit-Client, start Blender with BlenderKit add-on and try again")
+		return
+	}
+	var software *Software
+	for i := range softwares {
+		if softwares[i].AppID == data.SoftwareAppID {
+			software = &softwares[i]
+		}
+	}
+	if software == nil {
+		writeValidationError(w, &FieldError{Field: "software_app_id", Message: fmt.Sprintf("%d is not connected, use app_id of one of /bkclientjs/status softwares", data.SoftwareAppID)})
+		return
+	}
+
+	asset, err := fetchAssetByBaseID(AssetResolutionsData{
+		AppID:           software.AppID,
+		APIKey:          data.APIKey,
+		AddonVersion:    software.AddonVersion,
+		PlatformVersion: software.PlatformVersion,
+		AssetBaseID:     data.AssetBaseID,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "error fetching asset: "+err.Error())
+		return
+	}
+
+	taskID := uuid.New().String()
+	task := NewTask(data, software.AppID, taskID, "web_get_asset")
+	task.Finish(fmt.Sprintf("%s requested from the website", asset.DisplayName))
+	task.Result = map[string]interface{}{"asset_data": asset}
+	sendTask(AddTaskCh, task)
+	BKLog.Printf("%s Website requested asset %s for app %d", EmoDownload, asset.DisplayName, software.AppID)
+
+	writeJSON(w, map[string]interface{}{"task_id": taskID, "app_id": software.AppID, "asset_name": asset.DisplayName})
+}
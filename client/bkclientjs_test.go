@@ -0,0 +1,190 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBkclientjsGetAsset(t *testing.T) {
+	const assetBaseID = "0992088b-fb84-4c69-bb6e-426272970c8b"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") != "asset_base_id:"+assetBaseID {
+			fmt.Fprint(w, `{"results": []}`)
+			return
+		}
+		fmt.Fprintf(w, `{"results": [{"assetBaseId": "%s", "displayName": "Kitten", "assetType": "model"}]}`, assetBaseID)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+	reset := func() {
+		AvailableSoftwares = make(map[int]*Software)
+		bkclientjsRequests = nil
+	}
+	reset() // other tests report their apps
+	defer reset()
+
+	post := func(origin, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/bkclientjs/get_asset", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		BkclientjsGetAssetHandler(rec, req)
+		return rec
+	}
+	body := func(appID int, assetBaseID string) string {
+		return fmt.Sprintf(`{"asset_base_id": "%s", "software_app_id": %d}`, assetBaseID, appID)
+	}
+
+	rec := post(serverURL, body(6501, assetBaseID))
+	if code := errorCode(t, rec); rec.Code != http.StatusConflict || code != ErrCodeNoSoftware {
+		t.Errorf("without software: status %d %s; want %d %s", rec.Code, code, http.StatusConflict, ErrCodeNoSoftware)
+	}
+
+	registerSoftware(MinimalTaskData{AppID: 6501, AddonVersion: "3.14.0"})
+	AvailableSoftwares[6502] = &Software{AppID: 6502, LastReport: time.Now().Add(-2 * SoftwareReportTimeout)}
+	for _, tt := range []struct {
+		name   string
+		origin string
+		body   string
+		status int
+		code   string
+	}{
+		{"foreign origin", "https://example.com", body(6501, assetBaseID), http.StatusForbidden, ErrCodeForbidden},
+		{"unknown software", serverURL, body(6503, assetBaseID), http.StatusBadRequest, ErrCodeInvalidField},
+		{"stale software", serverURL, body(6502, assetBaseID), http.StatusBadRequest, ErrCodeInvalidField},
+		{"invalid asset base id", serverURL, body(6501, "kitten"), http.StatusBadRequest, ErrCodeInvalidField},
+		{"missing asset", serverURL, body(6501, "d5368c9d-092e-4319-afe1-dd765de6da01"), http.StatusBadGateway, ErrCodeUpstream},
+	} {
+		rec := post(tt.origin, tt.body)
+		if code := errorCode(t, rec); rec.Code != tt.status || code != tt.code {
+			t.Errorf("%s: status %d %s; want %d %s", tt.name, rec.Code, code, tt.status, tt.code)
+		}
+	}
+
+	bkclientjsRequests = nil
+	rec = post(serverURL, body(6501, assetBaseID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != serverURL {
+		t.Errorf("Access-Control-Allow-Origin = %q; want %q", got, serverURL)
+	}
+	select {
+	case task := <-AddTaskCh:
+		result, _ := task.Result.(map[string]interface{})
+		asset, _ := result["asset_data"].(Asset)
+		if task.AppID != 6501 || task.TaskType != "web_get_asset" || task.Status != "finished" || asset.AssetBaseID != assetBaseID {
+			t.Errorf("task %d %s %s with asset %q; want finished web_get_asset for 6501 with %s", task.AppID, task.TaskType, task.Status, asset.AssetBaseID, assetBaseID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("web_get_asset task was not created")
+	}
+}
+
+func TestBkclientjsCORS(t *testing.T) {
+	defer func(any bool) { BkclientjsAnyOrigin = any }(BkclientjsAnyOrigin)
+	serverURL := "https://staging.blenderkit.com"
+	defer setServer(setServer(serverURL))
+	router := newRouter(clientRoutes())
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		anyOrigin bool
+		status    int
+		allowed   string // expected Access-Control-Allow-Origin
+		methods   string // expected Access-Control-Allow-Methods
+	}{
+		{"status from website", http.MethodGet, "/bkclientjs/status", "https://www.blenderkit.com", false, http.StatusOK, "https://www.blenderkit.com", ""},
+		{"status from staging", http.MethodGet, "/bkclientjs/status", "https://staging.blenderkit.com", false, http.StatusOK, "https://staging.blenderkit.com", ""},
+		{"status without origin", http.MethodGet, "/bkclientjs/status", "", false, http.StatusOK, "", ""},
+		{"status from foreign page", http.MethodGet, "/bkclientjs/status", "https://example.com", false, http.StatusForbidden, "", ""},
+		{"status over http", http.MethodGet, "/bkclientjs/status", "http://www.blenderkit.com", false, http.StatusForbidden, "", ""},
+		{"preflight of status", http.MethodOptions, "/bkclientjs/status", "https://blenderkit.com", false, http.StatusNoContent, "https://blenderkit.com", "GET"},
+		{"preflight of get_asset", http.MethodOptions, "/bkclientjs/get_asset", "https://www.blenderkit.com", false, http.StatusNoContent, "https://www.blenderkit.com", "POST"},
+		{"preflight from foreign page", http.MethodOptions, "/bkclientjs/get_asset", "https://example.com", false, http.StatusForbidden, "", ""},
+		{"preflight from local frontend", http.MethodOptions, "/bkclientjs/get_asset", "http://localhost:3000", true, http.StatusNoContent, "http://localhost:3000", "POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			BkclientjsAnyOrigin = tt.anyOrigin
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Private-Network", "true")
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d; want %d, body: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowed {
+				t.Errorf("Access-Control-Allow-Origin = %q; want %q", got, tt.allowed)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.methods {
+				t.Errorf("Access-Control-Allow-Methods = %q; want %q", got, tt.methods)
+			}
+			if tt.methods != "" && rec.Header().Get("Access-Control-Allow-Private-Network") != "true" {
+				t.Errorf("preflight does not allow the private network request")
+			}
+		})
+	}
+}
+
+func TestBkclientjsRateLimit(t *testing.T) {
+	defer func() { bkclientjsRequests = nil }()
+	bkclientjsRequests = nil
+	now := time.Now()
+	for i := 0; i < BkclientjsRateLimit; i++ {
+		if ok, _ := allowBkclientjsRequest(now); !ok {
+			t.Fatalf("request %d was limited; want %d allowed", i+1, BkclientjsRateLimit)
+		}
+	}
+	if ok, wait := allowBkclientjsRequest(now.Add(time.Second)); ok || wait != BkclientjsRateWindow-time.Second {
+		t.Errorf("request over the limit allowed=%t wait=%s; want limited for %s", ok, wait, BkclientjsRateWindow-time.Second)
+	}
+	if ok, _ := allowBkclientjsRequest(now.Add(BkclientjsRateWindow)); !ok {
+		t.Errorf("request after the window was limited")
+	}
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp.Error.Code
+}
@@ -0,0 +1,121 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MinBlenderVersion is the oldest Blender which can run the background pack and unpack scripts, same as in bl_info.
+var MinBlenderVersion = BlenderVersion{Major: 3, Minor: 0, Patch: 0}
+
+const blenderVersionTimeout = 30 * time.Second
+
+var blenderVersionRegex = regexp.MustCompile(`(?m)^Blender (\d+)\.(\d+)(?:\.(\d+))?`)
+
+type blenderBinaryCheck struct {
+	modTime time.Time
+	version *BlenderVersion
+	err     error
+}
+
+var (
+	blenderBinaryChecks    = map[string]blenderBinaryCheck{} // results of checkBlenderBinary per path, redone when the binary changes
+	blenderBinaryChecksMux sync.Mutex
+)
+
+// checkBlenderBinary verifies that path is executable Blender at least MinBlenderVersion, before it is used for background Blender.
+// Field names the setting from which the path comes, so the error tells the user what to fix.
+// Result is cached per path until the file is modified.
+func checkBlenderBinary(path, field string) (*BlenderVersion, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path to Blender is empty, set %s to the Blender executable", field)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Blender executable %q does not exist, set %s to the Blender executable: %w", path, field, err)
+	}
+	if !info.Mode().IsRegular() || !isExecutable(info) {
+		return nil, fmt.Errorf("%q is not an executable file, set %s to the Blender executable", path, field)
+	}
+
+	blenderBinaryChecksMux.Lock()
+	defer blenderBinaryChecksMux.Unlock()
+	if check, ok := blenderBinaryChecks[path]; ok && check.modTime.Equal(info.ModTime()) {
+		return check.version, check.err
+	}
+	version, err := blenderBinaryVersion(path, field)
+	blenderBinaryChecks[path] = blenderBinaryCheck{modTime: info.ModTime(), version: version, err: err}
+	return version, err
+}
+
+// blenderBinaryVersion runs the binary with --version and checks the printed version.
+func blenderBinaryVersion(path, field string) (*BlenderVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), blenderVersionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%q is not Blender, set %s to the Blender executable (--version failed: %w)", path, field, err)
+	}
+	version, ok := parseBlenderVersion(string(out))
+	if !ok {
+		return nil, fmt.Errorf("%q is not Blender, set %s to the Blender executable (e.g. not a launcher)", path, field)
+	}
+	if blenderVersionLess(*version, MinBlenderVersion) {
+		return version, fmt.Errorf("Blender %d.%d.%d at %q is not supported, set %s to Blender %d.%d or newer",
+			version.Major, version.Minor, version.Patch, path, field, MinBlenderVersion.Major, MinBlenderVersion.Minor)
+	}
+	return version, nil
+}
+
+// parseBlenderVersion finds the version in the output of `blender --version`, e.g. "Blender 4.1.1".
+func parseBlenderVersion(output string) (*BlenderVersion, bool) {
+	m := blenderVersionRegex.FindStringSubmatch(output)
+	if m == nil {
+		return nil, false
+	}
+	version := &BlenderVersion{}
+	version.Major, _ = strconv.Atoi(m[1])
+	version.Minor, _ = strconv.Atoi(m[2])
+	version.Patch, _ = strconv.Atoi(m[3]) // missing patch in old versions is 0
+	return version, true
+}
+
+func blenderVersionLess(a, b BlenderVersion) bool {
+	if a.Major != b.Major {
+		return a.Major < b.Major
+	}
+	if a.Minor != b.Minor {
+		return a.Minor < b.Minor
+	}
+	return a.Patch < b.Patch
+}
+
+// isExecutable reports whether the file has execute permission, on Windows every file is accepted.
+func isExecutable(info os.FileInfo) bool {
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0111 != 0
+}
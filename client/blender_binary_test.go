@@ -0,0 +1,91 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckBlenderBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	dir := t.TempDir()
+	fake := func(name, script string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), perm); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    *BlenderVersion
+		wantErr string
+	}{
+		{"supported", fake("blender41", "echo 'Blender 4.1.1'\necho '\tbuild date: 2024-04-16'\n", 0755), &BlenderVersion{4, 1, 1}, ""},
+		{"minimum", fake("blender30", "echo 'Blender 3.0.0'\n", 0755), &BlenderVersion{3, 0, 0}, ""},
+		{"too old", fake("blender283", "echo 'Blender 2.83.20'\n", 0755), nil, "Blender 2.83.20"},
+		{"launcher", fake("blender-launcher", "echo 'Blender Launcher 1.15.1'\n", 0755), nil, "is not Blender"},
+		{"fails", fake("broken", "exit 3\n", 0755), nil, "--version failed"},
+		{"not executable", fake("blender-noexec", "echo 'Blender 4.1.1'\n", 0644), nil, "not an executable"},
+		{"missing", filepath.Join(dir, "missing"), nil, "does not exist"},
+		{"directory", dir, nil, "not an executable"},
+		{"empty", "", nil, "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := checkBlenderBinary(tt.path, "PREFS.binary_path")
+			if tt.wantErr == "" {
+				if err != nil || version == nil || *version != *tt.want {
+					t.Errorf("checkBlenderBinary() = %v, %v; want %v", version, err, tt.want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "PREFS.binary_path") {
+				t.Errorf("error = %v; want error containing %q and the setting name", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckBlenderBinaryCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	path := filepath.Join(t.TempDir(), "blender")
+	counter := path + ".runs"
+	os.WriteFile(path, []byte("#!/bin/sh\necho run >> '"+counter+"'\necho 'Blender 4.2.0'\n"), 0755)
+
+	for i := 0; i < 3; i++ {
+		if _, err := checkBlenderBinary(path, "PREFS.binary_path"); err != nil {
+			t.Fatalf("checkBlenderBinary: %v", err)
+		}
+	}
+	runs, _ := os.ReadFile(counter)
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("binary ran %d times; want 1 thanks to the cache", n)
+	}
+}
@@ -0,0 +1,274 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const clientSettingsFilename = "client_settings.json" // settings changed by /settings/update, in GetSafeTempPath()
+
+// MaxDownloadConcurrency is the highest download_concurrency accepted by /settings/update.
+const MaxDownloadConcurrency = 16
+
+// ClientSettings are the process-level settings which /settings/update changes without restart of the Client.
+// Nil fields are not changed by the update, the response and the settings_changed task have all fields set.
+// Running tasks read the settings meanwhile, so they are kept in atomics and read once into a local where used twice.
+type ClientSettings struct {
+	ThumbnailCacheCapMB         *int64 `json:"thumbnail_cache_cap_mb,omitempty"`
+	GravatarCacheCapMB          *int64 `json:"gravatar_cache_cap_mb,omitempty"`
+	DownloadConcurrency         *int   `json:"download_concurrency,omitempty"`
+	BackgroundDownloadRateLimit *int   `json:"background_download_rate_limit,omitempty"` // bytes per second, 0 disables throttling
+	TraceRequests               *bool  `json:"trace_requests,omitempty"`                 // log timings of the requests, the verbose log level
+
+	// DownloadDirs are the directories of the add-on, e.g. global_dir and the project dir of the open .blend file,
+	// into which background Blender downloads with blocking_file_download. Nil keeps the dirs, empty list removes them.
+	// They are kept per add-on and not stored, the response has the union of the dirs of all add-ons.
+	DownloadDirs []string `json:"download_dirs,omitempty"`
+}
+
+// SettingsUpdateData is the request of /settings/update.
+type SettingsUpdateData struct {
+	MinimalTaskData
+	ClientSettings
+}
+
+var (
+	clientSettingsMux  sync.Mutex
+	clientSettingsPath string
+)
+
+// LoadClientSettings applies the settings persisted by earlier /settings/update.
+// Settings whose flag was set on the command line are skipped, explicit flags of the add-on win over the stored values.
+func LoadClientSettings(explicitFlags map[string]bool) error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	clientSettingsMux.Lock()
+	defer clientSettingsMux.Unlock()
+	clientSettingsPath = filepath.Join(tempDir, clientSettingsFilename)
+	stored, err := readClientSettings(clientSettingsPath)
+	if err != nil {
+		return err
+	}
+	stored = stored.without(explicitFlags)
+	stored.DownloadDirs = nil // stored by older Clients, the add-ons of this run send their own
+	if err := stored.validate(); err != nil {
+		return fmt.Errorf("stored settings %s: %w", clientSettingsPath, err)
+	}
+	stored.apply(0)
+	return nil
+}
+
+// newAtomicInt64 returns the atomic holding a setting with its default value.
+func newAtomicInt64(value int64) *atomic.Int64 {
+	setting := new(atomic.Int64)
+	setting.Store(value)
+	return setting
+}
+
+func readClientSettings(path string) (ClientSettings, error) {
+	var settings ClientSettings
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return settings, fmt.Errorf("corrupted settings %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// UpdateClientSettings validates all changed settings before applying any of them, stores them and returns the effective settings.
+// Only validation errors are returned, failure to store the settings is logged.
+func UpdateClientSettings(appID int, update ClientSettings) (ClientSettings, error) {
+	clientSettingsMux.Lock()
+	defer clientSettingsMux.Unlock()
+	if err := update.validate(); err != nil {
+		return ClientSettings{}, err
+	}
+	update.apply(appID)
+	if clientSettingsPath != "" {
+		stored, err := readClientSettings(clientSettingsPath)
+		if err != nil {
+			BKLog.Printf("%s Replacing unreadable settings: %v", EmoWarning, err)
+		}
+		if err := writeClientSettings(clientSettingsPath, stored.merge(update)); err != nil {
+			BKLog.Printf("%s Settings applied, but not saved for the next start: %v", EmoWarning, err)
+		}
+	}
+	return effectiveClientSettings(), nil
+}
+
+func writeClientSettings(path string, settings ClientSettings) error {
+	raw, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// effectiveClientSettings returns the current values of all settings.
+func effectiveClientSettings() ClientSettings {
+	thumbnailCap, gravatarCap := ThumbnailCacheCap.Load()/1024/1024, GravatarCacheCap.Load()/1024/1024
+	downloadQueuesMux.Lock()
+	concurrency := DownloadConcurrency
+	downloadQueuesMux.Unlock()
+	rateLimit, trace := int(BackgroundDownloadRateLimit.Load()), TraceRequests.Load()
+	return ClientSettings{
+		ThumbnailCacheCapMB:         &thumbnailCap,
+		GravatarCacheCapMB:          &gravatarCap,
+		DownloadConcurrency:         &concurrency,
+		BackgroundDownloadRateLimit: &rateLimit,
+		TraceRequests:               &trace,
+		DownloadDirs:                PathRoots.Configured(),
+	}
+}
+
+func (s ClientSettings) validate() error {
+	if s.ThumbnailCacheCapMB != nil && *s.ThumbnailCacheCapMB < 1 {
+		return &FieldError{Field: "thumbnail_cache_cap_mb", Message: "must be at least 1"}
+	}
+	if s.GravatarCacheCapMB != nil && *s.GravatarCacheCapMB < 1 {
+		return &FieldError{Field: "gravatar_cache_cap_mb", Message: "must be at least 1"}
+	}
+	if s.DownloadConcurrency != nil && (*s.DownloadConcurrency < 1 || *s.DownloadConcurrency > MaxDownloadConcurrency) {
+		return &FieldError{Field: "download_concurrency", Message: fmt.Sprintf("must be between 1 and %d", MaxDownloadConcurrency)}
+	}
+	if s.BackgroundDownloadRateLimit != nil && *s.BackgroundDownloadRateLimit < 0 {
+		return &FieldError{Field: "background_download_rate_limit", Message: "must not be negative"}
+	}
+	return resolveDownloadRoots(s.DownloadDirs)
+}
+
+// apply sets the changed settings, must be called after validate which resolves the download dirs of the add-on appID.
+func (s ClientSettings) apply(appID int) {
+	if s.ThumbnailCacheCapMB != nil {
+		ThumbnailCacheCap.Store(*s.ThumbnailCacheCapMB * 1024 * 1024)
+	}
+	if s.GravatarCacheCapMB != nil {
+		GravatarCacheCap.Store(*s.GravatarCacheCapMB * 1024 * 1024)
+	}
+	if s.DownloadConcurrency != nil {
+		setDownloadConcurrency(*s.DownloadConcurrency)
+	}
+	if s.BackgroundDownloadRateLimit != nil {
+		BackgroundDownloadRateLimit.Store(int64(*s.BackgroundDownloadRateLimit))
+	}
+	if s.TraceRequests != nil {
+		TraceRequests.Store(*s.TraceRequests)
+	}
+	if s.DownloadDirs != nil {
+		PathRoots.Set(appID, s.DownloadDirs)
+	}
+}
+
+// merge returns s with the fields set in update replaced, download dirs belong to the add-ons of this run and are dropped.
+func (s ClientSettings) merge(update ClientSettings) ClientSettings {
+	if update.ThumbnailCacheCapMB != nil {
+		s.ThumbnailCacheCapMB = update.ThumbnailCacheCapMB
+	}
+	if update.GravatarCacheCapMB != nil {
+		s.GravatarCacheCapMB = update.GravatarCacheCapMB
+	}
+	if update.DownloadConcurrency != nil {
+		s.DownloadConcurrency = update.DownloadConcurrency
+	}
+	if update.BackgroundDownloadRateLimit != nil {
+		s.BackgroundDownloadRateLimit = update.BackgroundDownloadRateLimit
+	}
+	if update.TraceRequests != nil {
+		s.TraceRequests = update.TraceRequests
+	}
+	s.DownloadDirs = nil
+	return s
+}
+
+// without returns s without the settings of the flags, JSON names of the settings are the names of their flags.
+func (s ClientSettings) without(flags map[string]bool) ClientSettings {
+	if flags["thumbnail_cache_cap_mb"] {
+		s.ThumbnailCacheCapMB = nil
+	}
+	if flags["gravatar_cache_cap_mb"] {
+		s.GravatarCacheCapMB = nil
+	}
+	if flags["download_concurrency"] {
+		s.DownloadConcurrency = nil
+	}
+	if flags["background_download_rate_limit"] {
+		s.BackgroundDownloadRateLimit = nil
+	}
+	if flags["trace_requests"] {
+		s.TraceRequests = nil
+	}
+	return s
+}
+
+// SettingsUpdateHandler applies the Client settings and responds with the effective ones,
+// other connected add-ons learn about the change from settings_changed task. Web pages are rejected by allowSettingsRequest.
+func SettingsUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var data SettingsUpdateData
+	if !allowSettingsRequest(w, r) || !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateAppID(data.AppID); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	effective, err := UpdateClientSettings(data.AppID, data.ClientSettings)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	changed, _ := json.Marshal(data.ClientSettings)
+	BKLog.Printf("%s Settings updated by %d: %s", EmoInfo, data.AppID, changed)
+
+	TasksMux.Lock()
+	appIDs := make([]int, 0, len(Tasks))
+	for appID := range Tasks {
+		if appID != data.AppID {
+			appIDs = append(appIDs, appID)
+		}
+	}
+	TasksMux.Unlock()
+	for _, appID := range appIDs {
+		task := NewTask(nil, appID, uuid.New().String(), "settings_changed")
+		task.Finish("Client settings changed")
+		task.Result = effective
+		sendTask(AddTaskCh, task)
+	}
+	writeJSON(w, effective)
+}
@@ -0,0 +1,169 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveClientSettings restores the settings changed by the test.
+func saveClientSettings(t *testing.T) {
+	thumbnailCap, gravatarCap, concurrency := ThumbnailCacheCap.Load(), GravatarCacheCap.Load(), DownloadConcurrency
+	rateLimit, trace, path := BackgroundDownloadRateLimit.Load(), TraceRequests.Load(), clientSettingsPath
+	savePathRoots(t)
+	t.Cleanup(func() {
+		ThumbnailCacheCap.Store(thumbnailCap)
+		GravatarCacheCap.Store(gravatarCap)
+		BackgroundDownloadRateLimit.Store(rateLimit)
+		TraceRequests.Store(trace)
+		DownloadConcurrency, clientSettingsPath = concurrency, path
+	})
+}
+
+func TestSettingsUpdate(t *testing.T) {
+	saveClientSettings(t)
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	if err := LoadClientSettings(nil); err != nil {
+		t.Fatal(err)
+	}
+	TasksMux.Lock()
+	Tasks[6541], Tasks[6542] = map[string]*Task{}, map[string]*Task{}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 6541)
+		delete(Tasks, 6542)
+		TasksMux.Unlock()
+	}()
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/settings/update", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		SettingsUpdateHandler(rec, req)
+		return rec
+	}
+
+	before := GravatarCacheCap.Load()
+	for _, header := range []map[string]string{{"Content-Type": "text/plain"}, {"Content-Type": "application/json", "Origin": "https://attacker.example"}} {
+		req := httptest.NewRequest(http.MethodPost, "/settings/update", strings.NewReader(`{"app_id": 6541, "gravatar_cache_cap_mb": 1}`))
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		SettingsUpdateHandler(rec, req)
+		if rec.Code != http.StatusUnsupportedMediaType && rec.Code != http.StatusForbidden {
+			t.Errorf("update with %v: status %d; want rejected", header, rec.Code)
+		}
+	}
+	if gravatarCap := GravatarCacheCap.Load(); gravatarCap != before {
+		t.Fatalf("gravatar cap changed to %d by web page", gravatarCap)
+	}
+	rec := update(`{"app_id": 6541, "gravatar_cache_cap_mb": 50, "download_concurrency": 100}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "download_concurrency") {
+		t.Errorf("invalid update: status %d %s; want 400 for download_concurrency", rec.Code, rec.Body.String())
+	}
+	if gravatarCap := GravatarCacheCap.Load(); gravatarCap != before {
+		t.Errorf("gravatar cap changed to %d by rejected update; want no setting applied", gravatarCap)
+	}
+
+	rec = update(`{"app_id": 6541, "gravatar_cache_cap_mb": 50, "download_concurrency": 4, "trace_requests": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200, body: %s", rec.Code, rec.Body.String())
+	}
+	var effective ClientSettings
+	if err := json.Unmarshal(rec.Body.Bytes(), &effective); err != nil {
+		t.Fatal(err)
+	}
+	if *effective.GravatarCacheCapMB != 50 || *effective.DownloadConcurrency != 4 || !*effective.TraceRequests || *effective.ThumbnailCacheCapMB != ThumbnailCacheCap.Load()/1024/1024 {
+		t.Errorf("effective settings %s; want changed gravatar cap, concurrency and tracing", rec.Body.String())
+	}
+	if GravatarCacheCap.Load() != 50*1024*1024 || DownloadConcurrency != 4 || !TraceRequests.Load() {
+		t.Errorf("settings were not applied")
+	}
+
+	select {
+	case task := <-AddTaskCh:
+		if task.AppID != 6542 || task.TaskType != "settings_changed" || task.Status != "finished" {
+			t.Errorf("task %s %s for %d; want finished settings_changed for the other app 6542", task.TaskType, task.Status, task.AppID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("settings_changed task was not sent")
+	}
+	select {
+	case task := <-AddTaskCh:
+		t.Errorf("unexpected task %s for %d, the app which changed the settings knows them from the response", task.TaskType, task.AppID)
+	default:
+	}
+
+	for _, dirs := range []string{`["/"]`, `["/home/user/../../etc"]`} {
+		if rec := update(`{"app_id": 6541, "download_dirs": ` + dirs + `}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("download_dirs %s: status %d; want 400", dirs, rec.Code)
+		}
+	}
+	globalDir := t.TempDir()
+	if rec := update(`{"app_id": 6541, "download_dirs": [` + strconv.Quote(globalDir) + `]}`); rec.Code != http.StatusOK {
+		t.Fatalf("download_dirs: status %d; want 200, body: %s", rec.Code, rec.Body.String())
+	}
+	<-AddTaskCh // settings_changed of 6542
+	if !PathRoots.Allows(filepath.Join(globalDir, "models", "chair.blend")) {
+		t.Errorf("configured download dir %s is not allowed", globalDir)
+	}
+
+	stored, err := os.ReadFile(clientSettingsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stored), "thumbnail_cache_cap_mb") || strings.Contains(string(stored), "download_dirs") || !strings.Contains(string(stored), `"download_concurrency": 4`) {
+		t.Errorf("stored settings %s; want only the changed ones", stored)
+	}
+}
+
+func TestLoadClientSettings(t *testing.T) {
+	saveClientSettings(t)
+	resetTempPath(t)
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(safeTemp, clientSettingsFilename), []byte(`{"download_concurrency": 3, "background_download_rate_limit": 1024}`), 0600)
+
+	DownloadConcurrency = 2
+	BackgroundDownloadRateLimit.Store(512 * 1024)
+	if err := LoadClientSettings(map[string]bool{"download_concurrency": true}); err != nil {
+		t.Fatal(err)
+	}
+	if DownloadConcurrency != 2 {
+		t.Errorf("download concurrency = %d; want 2 from the explicit flag", DownloadConcurrency)
+	}
+	if rateLimit := BackgroundDownloadRateLimit.Load(); rateLimit != 1024 {
+		t.Errorf("background rate limit = %d; want 1024 from the stored settings", rateLimit)
+	}
+}
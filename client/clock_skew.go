@@ -0,0 +1,122 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrCodeClockSkew is the error code of tasks which failed because the system clock is wrong.
+const ErrCodeClockSkew = "clock_skew"
+
+// ClockSkewThreshold is how far the local clock can be from the server's, before failing certificate checks are blamed on it.
+var ClockSkewThreshold = 5 * time.Minute
+
+// ClockSkewError is a certificate error caused by the system clock being off.
+type ClockSkewError struct {
+	Skew time.Duration // positive when the local clock is ahead of the server
+	Err  error
+}
+
+func (e *ClockSkewError) Error() string {
+	direction := "ahead of"
+	if e.Skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Sprintf("system clock is %s %s the server time, fix the date and time settings of this computer (%v)",
+		humanDuration(e.Skew.Abs()), direction, e.Err)
+}
+
+func (e *ClockSkewError) Unwrap() error {
+	return e.Err
+}
+
+// isTimeRelatedTLSError reports whether err is certificate validation error which wrong system time can cause:
+// certificate expired or not yet valid from the point of view of the local clock.
+func isTimeRelatedTLSError(err error) bool {
+	var certErr x509.CertificateInvalidError
+	return errors.As(err, &certErr) && certErr.Reason == x509.Expired
+}
+
+// checkClockSkew measures the clock skew when err is a time related certificate error.
+// Returns *ClockSkewError if the skew exceeds ClockSkewThreshold, otherwise err unchanged.
+func checkClockSkew(err error) error {
+	if !isTimeRelatedTLSError(err) {
+		return err
+	}
+	skew, measureErr := measureClockSkew(currentServer())
+	if measureErr != nil {
+		BKLog.Printf("%s Could not measure clock skew: %v", EmoWarning, measureErr)
+		return err
+	}
+	if skew.Abs() <= ClockSkewThreshold {
+		return err
+	}
+	BKLog.Printf("%s System clock is off by %v", EmoWarning, skew)
+	return &ClockSkewError{Skew: skew, Err: err}
+}
+
+// clockSkewErrorCode returns ErrCodeClockSkew if err was caused by wrong system clock, empty string otherwise.
+func clockSkewErrorCode(err error) string {
+	var skewErr *ClockSkewError
+	if errors.As(err, &skewErr) {
+		return ErrCodeClockSkew
+	}
+	return ""
+}
+
+// measureClockSkew compares the local clock with the Date header of the server's response.
+// Certificate is not verified, as the verification may fail exactly because of the wrong clock; no credentials are sent.
+func measureClockSkew(server string) (time.Duration, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ClientAPI != nil { // same proxy as the API requests
+		base := ClientAPI.Transport
+		if statusTransport, ok := base.(*serverStatusTransport); ok {
+			base = statusTransport.base
+		}
+		if reloadable, ok := base.(*reloadableTransport); ok {
+			base = reloadable.current.Load()
+		}
+		if apiTransport, ok := base.(*http.Transport); ok {
+			transport.Proxy = apiTransport.Proxy
+		}
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	start := time.Now()
+	resp, err := client.Head(server)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("server response has no valid Date header: %w", err)
+	}
+	localTime := start.Add(rtt / 2) // server stamped the response somewhere in the middle of the round trip
+	return localTime.Sub(serverTime).Round(time.Second), nil
+}
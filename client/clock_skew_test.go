@@ -0,0 +1,73 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	var serverOffset time.Duration
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(serverOffset).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+
+	expired := &url.Error{Op: "Get", URL: serverURL, Err: &tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}}}
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		err      error
+		wantSkew time.Duration // 0 means the error is returned unchanged
+	}{
+		{"clock ahead", -2 * time.Hour, expired, 2 * time.Hour},
+		{"clock behind", 3 * time.Hour, expired, -3 * time.Hour},
+		{"clock within threshold", time.Minute, expired, 0},
+		{"not certificate error", -2 * time.Hour, errors.New("connection refused"), 0},
+		{"other certificate error", -2 * time.Hour, x509.CertificateInvalidError{Reason: x509.NameMismatch}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverOffset = tt.offset
+			err := checkClockSkew(tt.err)
+			var skewErr *ClockSkewError
+			if !errors.As(err, &skewErr) {
+				if tt.wantSkew != 0 || err != tt.err {
+					t.Errorf("checkClockSkew() = %v, expected skew %v", err, tt.wantSkew)
+				}
+				return
+			}
+			if diff := (skewErr.Skew - tt.wantSkew).Abs(); diff > 2*time.Second {
+				t.Errorf("skew = %v, expected %v", skewErr.Skew, tt.wantSkew)
+			}
+			if clockSkewErrorCode(err) != ErrCodeClockSkew || !errors.Is(err, tt.err) {
+				t.Errorf("error %v lost its code or cause", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CommentDuplicateWindow is how long an identical comment on the same asset is treated as a duplicate, e.g. of a double-click.
+var CommentDuplicateWindow = 30 * time.Second
+
+// recentComment is a comment being created or created within CommentDuplicateWindow.
+type recentComment struct {
+	taskID string
+	at     time.Time
+}
+
+var (
+	recentComments    = make(map[string]recentComment) // by commentKey()
+	commentAssetLocks = make(map[string]*sync.Mutex)   // by asset ID
+	recentCommentsMux sync.Mutex
+)
+
+// commentKey identifies the comment by what the user wrote and where.
+func commentKey(data CreateCommentData) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", data.AssetID, data.CommentText, data.ReplyToID)))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimComment registers the comment for the task. If identical comment was claimed within CommentDuplicateWindow,
+// it returns ID of the task which claimed it and false.
+func claimComment(key, taskID string) (string, bool) {
+	recentCommentsMux.Lock()
+	defer recentCommentsMux.Unlock()
+	for k, c := range recentComments {
+		if time.Since(c.at) > CommentDuplicateWindow {
+			delete(recentComments, k)
+		}
+	}
+	if original, ok := recentComments[key]; ok {
+		return original.taskID, false
+	}
+	recentComments[key] = recentComment{taskID: taskID, at: time.Now()}
+	return "", true
+}
+
+// releaseComment forgets the claim of the task, so the comment which failed to be created can be posted again.
+func releaseComment(key, taskID string) {
+	recentCommentsMux.Lock()
+	defer recentCommentsMux.Unlock()
+	if recentComments[key].taskID == taskID {
+		delete(recentComments, key)
+	}
+}
+
+// lockCommentAsset serializes creation of comments on the asset, so the security hash from GET is not reused by two POSTs.
+// Returns the unlock function.
+func lockCommentAsset(assetID string) func() {
+	recentCommentsMux.Lock()
+	lock, ok := commentAssetLocks[assetID]
+	if !ok {
+		lock = &sync.Mutex{}
+		commentAssetLocks[assetID] = lock
+	}
+	recentCommentsMux.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
@@ -0,0 +1,119 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateCommentDuplicates(t *testing.T) {
+	const assetID = "0992088b-fb84-4c69-bb6e-426272970c8b"
+	var mux sync.Mutex
+	posts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"form": {"timestamp": "1760529600", "securityHash": "hash"}, "results": []}`)
+			return
+		}
+		var comment CommentPostData
+		json.NewDecoder(r.Body).Decode(&comment)
+		mux.Lock()
+		posts[comment.Comment]++
+		mux.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		if comment.Comment == "server fails" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"detail": "Internal server error"}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id": 1}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	// collect waits for count create comment tasks and returns their messages,
+	// it also waits for the refresh of comments after each created comment, so no task is left in the channels
+	collect := func(count int) (messages []string) {
+		created, refreshed := 0, 0
+		timeout := time.After(5 * time.Second)
+		for len(messages) < count || refreshed < created {
+			select {
+			case <-AddTaskCh:
+			case f := <-TaskFinishCh:
+				switch f.Message {
+				case "comments downloaded":
+					refreshed++
+				case "Comment created":
+					created++
+					fallthrough
+				default:
+					messages = append(messages, f.Message)
+				}
+			case e := <-TaskErrorCh:
+				messages = append(messages, e.Error.Error())
+			case <-timeout:
+				t.Fatalf("create comment did not finish, got %v", messages)
+			}
+		}
+		return messages
+	}
+
+	data := CreateCommentData{AppID: 619, AssetID: assetID, CommentText: "Great chair!"}
+	go CreateComment(data)
+	go CreateComment(data)
+	messages := collect(2)
+	if posts["Great chair!"] != 1 || countDuplicates(messages) != 1 {
+		t.Errorf("got %d POSTs with messages %v, expected one POST and one duplicate", posts["Great chair!"], messages)
+	}
+
+	reply := data
+	reply.ReplyToID = 42
+	go CreateComment(reply)
+	if collect(1)[0] != "Comment created" {
+		t.Error("reply with the same text was treated as duplicate")
+	}
+
+	failing := CreateCommentData{AppID: 619, AssetID: assetID, CommentText: "server fails"}
+	CreateComment(failing)
+	CreateComment(failing)
+	collect(2)
+	if posts["server fails"] != 2 {
+		t.Errorf("comment which failed to be created was not posted again, got %d POSTs", posts["server fails"])
+	}
+}
+
+func countDuplicates(messages []string) (count int) {
+	for _, m := range messages {
+		if m == "Duplicate comment ignored" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,127 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/blenderkit/blenderkit/client/internal/api"
+)
+
+const (
+	CommentsPageSize    = 20 // page size of comments when the add-on does not send page_size
+	MaxCommentsPageSize = 100
+)
+
+// validateCommentsPaging checks the optional page, page_size and since of GetCommentsData.
+func validateCommentsPaging(data GetCommentsData) error {
+	if data.Page < 0 {
+		return &FieldError{Field: "page", Message: "must not be negative"}
+	}
+	if data.PageSize < 0 || data.PageSize > MaxCommentsPageSize {
+		return &FieldError{Field: "page_size", Message: fmt.Sprintf("must be between 1 and %d", MaxCommentsPageSize)}
+	}
+	if data.Since != "" {
+		if _, err := time.Parse(time.RFC3339, data.Since); err != nil {
+			return &FieldError{Field: "since", Message: fmt.Sprintf("is not a RFC 3339 timestamp: %q", data.Since)}
+		}
+	}
+	return nil
+}
+
+// commentsPaging returns the requested page and page size, the first page of CommentsPageSize if not set.
+func commentsPaging(data GetCommentsData) (page, pageSize int) {
+	page, pageSize = data.Page, data.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = CommentsPageSize
+	}
+	return page, pageSize
+}
+
+// commentsPath is the API path of the requested page of comments on the asset, only newer than since if set.
+func commentsPath(data GetCommentsData) string {
+	page, pageSize := commentsPaging(data)
+	query := url.Values{"page": {strconv.Itoa(page)}, "page_size": {strconv.Itoa(pageSize)}}
+	if data.Since != "" {
+		query.Set("since", data.Since)
+	}
+	return fmt.Sprintf("/api/v1/comments/assets-uuidasset/%s/?%s", data.AssetID, query.Encode())
+}
+
+// pageComments adds count, page, page_size and next_page (null on the last page) to the comments response.
+// Server which ignored the paging returns the whole thread without count, it is cut to the requested page here,
+// so the add-on gets the first page also from such a server.
+func pageComments(respData map[string]interface{}, page, pageSize int) map[string]interface{} {
+	results, _ := respData["results"].([]interface{})
+	count, paged := respData["count"].(float64)
+	hasNext := false
+	if paged {
+		next, _ := respData["next"].(string)
+		hasNext = next != ""
+	} else {
+		count = float64(len(results))
+		start := min((page-1)*pageSize, len(results))
+		end := min(start+pageSize, len(results))
+		hasNext = end < len(results)
+		results = results[start:end]
+	}
+
+	respData["results"] = results
+	respData["count"] = int(count)
+	respData["page"] = page
+	respData["page_size"] = pageSize
+	respData["next_page"] = nil
+	if hasNext {
+		respData["next_page"] = page + 1
+	}
+	return respData
+}
+
+// recordServerDate makes the client store the Date header of its responses into date,
+// so polled_at is the time of the server which compares it with since, not of the local clock.
+func recordServerDate(client *api.Client, date *time.Time) {
+	do := client.Do
+	if do == nil {
+		do = (*http.Client).Do
+	}
+	client.Do = func(c *http.Client, req *http.Request) (*http.Response, error) {
+		resp, err := do(c, req)
+		if resp != nil {
+			if serverTime, parseErr := http.ParseTime(resp.Header.Get("Date")); parseErr == nil {
+				*date = serverTime
+			}
+		}
+		return resp, err
+	}
+}
+
+// commentsPolledAt formats the server time of the response as polled_at, the local time if the server sent no Date.
+func commentsPolledAt(serverDate time.Time) string {
+	if serverDate.IsZero() {
+		serverDate = time.Now()
+	}
+	return serverDate.UTC().Format(time.RFC3339)
+}
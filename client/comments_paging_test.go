@@ -0,0 +1,137 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateCommentsPaging(t *testing.T) {
+	tests := []struct {
+		data  GetCommentsData
+		field string
+	}{
+		{GetCommentsData{}, ""},
+		{GetCommentsData{Page: 3, PageSize: MaxCommentsPageSize, Since: "2026-10-15T10:00:00Z"}, ""},
+		{GetCommentsData{Page: -1}, "page"},
+		{GetCommentsData{PageSize: MaxCommentsPageSize + 1}, "page_size"},
+		{GetCommentsData{Since: "yesterday"}, "since"},
+	}
+	for _, tt := range tests {
+		err := validateCommentsPaging(tt.data)
+		field := ""
+		if fieldErr, ok := err.(*FieldError); ok {
+			field = fieldErr.Field
+		}
+		if field != tt.field {
+			t.Errorf("validateCommentsPaging(%+v) = %v, expected error of field %q", tt.data, err, tt.field)
+		}
+	}
+}
+
+func TestPageComments(t *testing.T) {
+	thread := func(n int) []interface{} {
+		comments := make([]interface{}, n)
+		for i := range comments {
+			comments[i] = float64(i + 1)
+		}
+		return comments
+	}
+	tests := []struct {
+		name           string
+		resp           map[string]interface{}
+		page, pageSize int
+		results        []interface{}
+		count          int
+		nextPage       interface{}
+	}{
+		{"server paged", map[string]interface{}{"count": float64(45), "next": "https://server/page=2", "results": thread(20)}, 1, 20, thread(20), 45, 2},
+		{"server last page", map[string]interface{}{"count": float64(45), "next": nil, "results": thread(5)}, 3, 20, thread(5), 45, nil},
+		{"whole thread cut to first page", map[string]interface{}{"results": thread(45)}, 1, 20, thread(20), 45, 2},
+		{"whole thread cut to last page", map[string]interface{}{"results": thread(45)}, 3, 20, thread(45)[40:], 45, nil},
+		{"page past the end", map[string]interface{}{"results": thread(3)}, 2, 20, []interface{}{}, 3, nil},
+		{"no comments", map[string]interface{}{}, 1, 20, []interface{}{}, 0, nil},
+	}
+	for _, tt := range tests {
+		got := pageComments(tt.resp, tt.page, tt.pageSize)
+		results, _ := got["results"].([]interface{})
+		if len(results) != len(tt.results) || (len(results) > 0 && !reflect.DeepEqual(results, tt.results)) {
+			t.Errorf("%s: results %v, expected %v", tt.name, results, tt.results)
+		}
+		if got["count"] != tt.count || got["next_page"] != tt.nextPage || got["page"] != tt.page || got["page_size"] != tt.pageSize {
+			t.Errorf("%s: count %v, next_page %v, page %v, page_size %v", tt.name, got["count"], got["next_page"], got["page"], got["page_size"])
+		}
+	}
+}
+
+func TestGetCommentsPaging(t *testing.T) {
+	const assetID = "5a1b8e0c-3f0e-4c1e-9a43-7c9d5a6e2b10"
+	queries := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		w.Header().Set("Date", "Thu, 15 Oct 2026 10:30:00 GMT") // server clock differs from the local one
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"count": 1, "next": null, "results": [{"id": 7, "comment": "Nice"}]}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	tests := []struct {
+		data  GetCommentsData
+		query string
+	}{
+		{GetCommentsData{AppID: 673, AssetID: assetID}, fmt.Sprintf("page=1&page_size=%d", CommentsPageSize)},
+		{GetCommentsData{AppID: 673, AssetID: assetID, Page: 2, PageSize: 50, Since: "2026-10-15T10:00:00Z"}, "page=2&page_size=50&since=2026-10-15T10%3A00%3A00Z"},
+	}
+	for _, tt := range tests {
+		go GetComments(tt.data)
+		<-AddTaskCh
+		var finish *TaskFinish
+		select {
+		case finish = <-TaskFinishCh:
+		case e := <-TaskErrorCh:
+			t.Fatalf("get comments failed: %v", e.Error)
+		case <-time.After(5 * time.Second):
+			t.Fatal("get comments did not finish")
+		}
+		if query := <-queries; query != tt.query {
+			t.Errorf("server got query %q, expected %q", query, tt.query)
+		}
+
+		result, _ := json.Marshal(finish.Result)
+		var paging struct {
+			Count    int    `json:"count"`
+			NextPage *int   `json:"next_page"`
+			PolledAt string `json:"polled_at"`
+		}
+		json.Unmarshal(result, &paging)
+		if paging.Count != 1 || paging.NextPage != nil || paging.PolledAt != "2026-10-15T10:30:00Z" {
+			t.Errorf("result %s has wrong paging", result)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions of /link/open_asset deep links.
+const (
+	LinkActionSearch   = "search"   // shows the asset in the asset bar, task "web_search_asset"
+	LinkActionDownload = "download" // downloads the asset to the 3D cursor, task "web_get_asset" as from /bkclientjs/get_asset
+)
+
+// LinkOpenAssetData is the task data of an asset sent by the deep link.
+type LinkOpenAssetData struct {
+	AssetBaseID string `json:"asset_base_id"`
+	Action      string `json:"action"`
+}
+
+// linkOrigin returns the origin of the page with the deep link from Origin header, or from Referer if the browser sent no Origin.
+// Returns "" when the browser sent neither.
+func linkOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
+
+// mostRecentSoftware returns the connected software which reported last, likely the Blender the user worked in. Nil if none.
+func mostRecentSoftware() *Software {
+	var recent *Software
+	for _, software := range availableSoftwares() {
+		if recent == nil || software.LastReport.After(recent.LastReport) {
+			recent = &software
+		}
+	}
+	return recent
+}
+
+// writeLinkPage renders the result of the deep link for the browser tab, the page of the login result is reused.
+func writeLinkPage(w http.ResponseWriter, status int, title, message string) {
+	writeLoginPage(w, status, loginPage{Success: status == http.StatusOK, Title: title, Message: message})
+}
+
+// LinkOpenAssetHandler is the target of deep links of the website like "edit in Blender", opened in a browser tab:
+// GET /link/open_asset?asset_base_id=...&action=search|download.
+// The asset is sent to the most recently active software as a task and the page tells the user to switch to it.
+// Only links from the BlenderKit website are followed, so other pages cannot push assets into Blender.
+func LinkOpenAssetHandler(w http.ResponseWriter, r *http.Request) {
+	if origin := linkOrigin(r); !bkclientjsOriginAllowed(origin) {
+		BKLog.Printf("%s Deep link from origin %q rejected", EmoWarning, origin)
+		writeLinkPage(w, http.StatusForbidden, "Link not opened", "Only links on the BlenderKit website can open assets in Blender.")
+		return
+	}
+	if ok, wait := allowBkclientjsRequest(time.Now()); !ok {
+		writeLinkPage(w, http.StatusTooManyRequests, "Too many links", fmt.Sprintf("Please wait %s and try again.", wait.Round(time.Second)))
+		return
+	}
+
+	query := r.URL.Query()
+	data := LinkOpenAssetData{AssetBaseID: query.Get("asset_base_id"), Action: query.Get("action")}
+	if data.Action == "" {
+		data.Action = LinkActionSearch
+	}
+	var actionErr error
+	if data.Action != LinkActionSearch && data.Action != LinkActionDownload {
+		actionErr = &FieldError{Field: "action", Message: fmt.Sprintf("must be %s or %s", LinkActionSearch, LinkActionDownload)}
+	}
+	if err := firstError(validateUUID("asset_base_id", data.AssetBaseID), actionErr); err != nil {
+		writeLinkPage(w, http.StatusBadRequest, "Invalid link", err.Error())
+		return
+	}
+
+	software := mostRecentSoftware()
+	if software == nil {
+		writeLinkPage(w, http.StatusConflict, "Blender is not running",
+			"Start Blender with the BlenderKit add-on enabled, wait until it connects to BlenderKit-Client and click the link again.")
+		return
+	}
+
+	asset, err := fetchAssetByBaseID(AssetResolutionsData{
+		AppID:           software.AppID,
+		AddonVersion:    software.AddonVersion,
+		PlatformVersion: software.PlatformVersion,
+		AssetBaseID:     data.AssetBaseID,
+	})
+	if err != nil {
+		BKLog.Printf("%s Deep link to asset %s failed: %v", EmoWarning, data.AssetBaseID, err)
+		writeLinkPage(w, http.StatusBadGateway, "Asset not found", "BlenderKit-Client could not get the asset from the server, please try again later.")
+		return
+	}
+
+	taskType := "web_search_asset"
+	if data.Action == LinkActionDownload {
+		taskType = "web_get_asset"
+	}
+	task := NewTask(data, software.AppID, uuid.New().String(), taskType)
+	task.Finish(fmt.Sprintf("%s opened from the website", asset.DisplayName))
+	task.Result = map[string]interface{}{"asset_data": asset}
+	sendTask(AddTaskCh, task)
+	BKLog.Printf("%s Deep link sent asset %s to app %d (%s)", EmoDownload, asset.DisplayName, software.AppID, data.Action)
+
+	writeLinkPage(w, http.StatusOK, "Sent to Blender", fmt.Sprintf("%s was sent to Blender, switch to the application.", asset.DisplayName))
+}
@@ -0,0 +1,136 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appTask returns the next task of the app sent to AddTaskCh, nil after wait.
+// Tasks of other apps are skipped, handlers of other tests may still be sending them.
+func appTask(appID int, wait time.Duration) *Task {
+	timeout := time.After(wait)
+	for {
+		select {
+		case task := <-AddTaskCh:
+			if task.AppID == appID {
+				return task
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func TestLinkOpenAsset(t *testing.T) {
+	const assetBaseID = "0992088b-fb84-4c69-bb6e-426272970c8b"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") != "asset_base_id:"+assetBaseID {
+			fmt.Fprint(w, `{"results": []}`)
+			return
+		}
+		fmt.Fprintf(w, `{"results": [{"assetBaseId": "%s", "displayName": "Kitten", "assetType": "model"}]}`, assetBaseID)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+	reset := func() {
+		AvailableSoftwares = make(map[int]*Software)
+		bkclientjsRequests = nil
+	}
+	reset() // other tests report their apps
+	defer reset()
+
+	open := func(query string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/link/open_asset?"+query, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		LinkOpenAssetHandler(rec, req)
+		return rec
+	}
+	fromWebsite := map[string]string{"Referer": serverURL + "/asset-gallery-detail/kitten/"}
+	query := "asset_base_id=" + assetBaseID + "&action=download"
+
+	rec := open(query, fromWebsite)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "Start Blender") {
+		t.Errorf("without software: status %d; want %d with instructions, body: %s", rec.Code, http.StatusConflict, rec.Body.String())
+	}
+
+	AvailableSoftwares[6591] = &Software{AppID: 6591, LastReport: time.Now().Add(-10 * time.Second)}
+	AvailableSoftwares[6592] = &Software{AppID: 6592, LastReport: time.Now()}
+	AvailableSoftwares[6593] = &Software{AppID: 6593, LastReport: time.Now().Add(-2 * SoftwareReportTimeout)} // no longer connected
+	for _, tt := range []struct {
+		name    string
+		query   string
+		headers map[string]string
+		status  int
+	}{
+		{"no referer", query, nil, http.StatusForbidden},
+		{"foreign referer", query, map[string]string{"Referer": "https://example.com/page"}, http.StatusForbidden},
+		{"foreign origin", query, map[string]string{"Origin": "https://example.com", "Referer": serverURL + "/"}, http.StatusForbidden},
+		{"invalid asset base id", "asset_base_id=kitten&action=download", fromWebsite, http.StatusBadRequest},
+		{"unknown action", "asset_base_id=" + assetBaseID + "&action=delete", fromWebsite, http.StatusBadRequest},
+		{"missing asset", "asset_base_id=d5368c9d-092e-4319-afe1-dd765de6da01", fromWebsite, http.StatusBadGateway},
+	} {
+		rec := open(tt.query, tt.headers)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d; want %d, body: %s", tt.name, rec.Code, tt.status, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%s: Content-Type %q; want HTML page for the browser tab", tt.name, ct)
+		}
+		if task := appTask(6592, 10*time.Millisecond); task != nil {
+			t.Errorf("%s: task %s was created", tt.name, task.TaskType)
+		}
+	}
+
+	for _, tt := range []struct {
+		query    string
+		headers  map[string]string
+		taskType string
+	}{
+		{query, fromWebsite, "web_get_asset"},
+		{"asset_base_id=" + assetBaseID, map[string]string{"Origin": serverURL}, "web_search_asset"},
+	} {
+		bkclientjsRequests = nil
+		rec := open(tt.query, tt.headers)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Sent to Blender") {
+			t.Fatalf("%s: status %d; want %d with Sent to Blender, body: %s", tt.query, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		task := appTask(6592, time.Second)
+		if task == nil {
+			t.Fatalf("%s task was not created", tt.taskType)
+		}
+		result, _ := task.Result.(map[string]interface{})
+		asset, _ := result["asset_data"].(Asset)
+		if task.AppID != 6592 || task.TaskType != tt.taskType || task.Status != "finished" || asset.AssetBaseID != assetBaseID {
+			t.Errorf("task %d %s %s with asset %q; want finished %s for the most recent app 6592 with %s",
+				task.AppID, task.TaskType, task.Status, asset.AssetBaseID, tt.taskType, assetBaseID)
+		}
+	}
+}
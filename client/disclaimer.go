@@ -0,0 +1,150 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const disclaimersSeenFilename = "disclaimers_seen.json" // acknowledged disclaimers in GetSafeTempPath()
+
+// DisclaimersSeenStore keeps slugs of disclaimers acknowledged by the user with the time of acknowledgement,
+// persisted in a JSON file so the same disclaimer is not shown again in the next session.
+type DisclaimersSeenStore struct {
+	mux  sync.Mutex
+	path string
+	seen map[string]time.Time
+}
+
+var DisclaimersSeen = &DisclaimersSeenStore{}
+
+// LoadDisclaimersSeen reads the acknowledged disclaimers from the safe temp path.
+func LoadDisclaimersSeen() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return DisclaimersSeen.load(filepath.Join(tempDir, disclaimersSeenFilename))
+}
+
+func (s *DisclaimersSeenStore) load(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.path = path
+	s.seen = map[string]time.Time{}
+	seen, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(seen, &s.seen); err != nil {
+		return fmt.Errorf("corrupted seen disclaimers %s: %w", path, err)
+	}
+	return nil
+}
+
+// Acknowledge marks the disclaimer as seen, so it is not delivered to the add-on anymore.
+func (s *DisclaimersSeenStore) Acknowledge(slug string, at time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	s.seen[slug] = at
+	if s.path == "" {
+		return nil
+	}
+	seen, err := json.Marshal(s.seen)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, seen, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// IsSeen reports whether the disclaimer was acknowledged.
+func (s *DisclaimersSeenStore) IsSeen(slug string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ok := s.seen[slug]
+	return ok
+}
+
+// filterDisclaimers keeps the disclaimers which are valid at now and were not acknowledged yet, highest priority first.
+// Validity bounds which are empty or cannot be parsed do not limit the disclaimer.
+func filterDisclaimers(data DisclaimerData, now time.Time) DisclaimerData {
+	results := make([]Disclaimer, 0, len(data.Results))
+	for _, disclaimer := range data.Results {
+		if from, err := time.Parse(time.RFC3339, disclaimer.ValidFrom); err == nil && now.Before(from) {
+			continue
+		}
+		disclaimer.RemainingS = 0
+		if to, err := time.Parse(time.RFC3339, disclaimer.ValidTo); err == nil {
+			if !now.Before(to) {
+				continue
+			}
+			disclaimer.RemainingS = int64(to.Sub(now).Seconds())
+		}
+		if disclaimer.Slug != "" && DisclaimersSeen.IsSeen(disclaimer.Slug) {
+			continue
+		}
+		results = append(results, disclaimer)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Priority > results[j].Priority
+	})
+	data.Results = results
+	data.Count = len(results)
+	return data
+}
+
+type DisclaimerAcknowledgeData struct {
+	AppID int    `json:"app_id"`
+	Slug  string `json:"slug"`
+}
+
+// DisclaimerAcknowledgeHandler marks the disclaimer shown to the user as seen.
+func DisclaimerAcknowledgeHandler(w http.ResponseWriter, r *http.Request) {
+	var data DisclaimerAcknowledgeData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateNotEmpty("slug", data.Slug)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	if err := DisclaimersSeen.Acknowledge(data.Slug, time.Now()); err != nil {
+		BKLog.Printf("%s Failed to save seen disclaimers: %v", EmoWarning, err)
+	}
+	writeJSON(w, map[string]string{"slug": data.Slug})
+}
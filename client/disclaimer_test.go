@@ -0,0 +1,93 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterDisclaimers(t *testing.T) {
+	defer func(s *DisclaimersSeenStore) { DisclaimersSeen = s }(DisclaimersSeen)
+	DisclaimersSeen = &DisclaimersSeenStore{}
+	if err := DisclaimersSeen.load(filepath.Join(t.TempDir(), disclaimersSeenFilename)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	if err := DisclaimersSeen.Acknowledge("seen", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := DisclaimerData{Count: 6, Results: []Disclaimer{
+		{Slug: "low", Priority: 1},
+		{Slug: "expired", Priority: 5, ValidTo: "2026-10-15T11:00:00Z"},
+		{Slug: "future", Priority: 5, ValidFrom: "2026-10-16T00:00:00Z"},
+		{Slug: "seen", Priority: 9},
+		{Slug: "high", Priority: 7, ValidFrom: "2026-10-01T00:00:00Z", ValidTo: "2026-10-15T14:00:00.000000Z"},
+		{Slug: "unparsable", Priority: 3, ValidTo: "next week"},
+	}}
+	filtered := filterDisclaimers(data, now)
+	var slugs []string
+	for _, d := range filtered.Results {
+		slugs = append(slugs, d.Slug)
+	}
+	if expected := "high,unparsable,low"; strings.Join(slugs, ",") != expected || filtered.Count != 3 {
+		t.Errorf("filterDisclaimers() = %v (count %d), expected %s", slugs, filtered.Count, expected)
+	}
+	if filtered.Results[0].RemainingS != 7200 || filtered.Results[2].RemainingS != 0 {
+		t.Errorf("remaining validity = %d, %d; expected 7200, 0", filtered.Results[0].RemainingS, filtered.Results[2].RemainingS)
+	}
+
+	reloaded := &DisclaimersSeenStore{}
+	if err := reloaded.load(DisclaimersSeen.path); err != nil || !reloaded.IsSeen("seen") || reloaded.IsSeen("low") {
+		t.Errorf("acknowledged disclaimers were not persisted: %v %v", reloaded.seen, err)
+	}
+}
+
+func TestDisclaimerAcknowledgeHandler(t *testing.T) {
+	defer func(s *DisclaimersSeenStore) { DisclaimersSeen = s }(DisclaimersSeen)
+	DisclaimersSeen = &DisclaimersSeenStore{}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"acknowledged", `{"app_id": 614, "slug": "summer-sale"}`, http.StatusOK},
+		{"missing slug", `{"app_id": 614}`, http.StatusBadRequest},
+		{"missing app_id", `{"slug": "summer-sale"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/disclaimer/acknowledge", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			DisclaimerAcknowledgeHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, expected %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+	if !DisclaimersSeen.IsSeen("summer-sale") {
+		t.Error("disclaimer was not acknowledged")
+	}
+}
@@ -20,7 +20,10 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -30,122 +33,266 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/gookit/color"
 )
 
+const (
+	WindowsPathLimit    = 260 // MAX_PATH on Windows, including the terminating null character
+	minShortenedSlugLen = 8   // Shorter slugs are replaced by a hash of the asset name
+)
+
+// Layouts of the downloaded asset files, DownloadData.Layout.
+const (
+	DownloadLayoutBlenderDual = "blender_dual" // global and project dir of the add-on, synced and unpacked: dir/slug_assetID/file
+	DownloadLayoutSingleDir   = "single_dir"   // one dir inside the project of non-Blender software, e.g. Godot: dir/slug/file
+)
+
 func assetDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body: "+err.Error(), http.StatusInternalServerError)
+	body, ok := readJSONBody(w, r)
+	if !ok {
 		return
 	}
-	// Ensure the body is closed after reading
-	defer r.Body.Close()
 
 	var downloadData DownloadData
-	err = json.Unmarshal(body, &downloadData)
+	err := json.Unmarshal(body, &downloadData)
 	if err != nil {
-		fmt.Println(">> Error parsing DownloadRequest:", err)
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "error parsing JSON: "+err.Error())
 		return
 	}
-
-	var rJSON map[string]interface{}
-	err = json.Unmarshal(body, &rJSON)
-	if err != nil {
-		fmt.Println(">> Error parsing JSON:", err)
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if err := firstError(validateDownloadData(downloadData), resolveDownloadDirs(downloadData.DownloadDirs)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
 	taskID := uuid.New().String()
-	go doAssetDownload(rJSON, downloadData, taskID)
+	go runTask(downloadData.AppID, taskID, func() { doAssetDownload(body, downloadData, taskID) })
 
 	// Response to add-on
-	resData := map[string]string{"task_id": taskID}
-	responseJSON, err := json.Marshal(resData)
+	writeJSON(w, map[string]string{"task_id": taskID})
+}
+
+// validateDownloadData checks the fields without which the download would fail right away.
+func validateDownloadData(data DownloadData) error {
+	if len(data.DownloadDirs) == 0 {
+		return &FieldError{Field: "download_dirs", Message: "must not be empty"}
+	}
+	if len(data.Files) == 0 {
+		return &FieldError{Field: "asset_data.files", Message: "must not be empty"}
+	}
+	for i, dir := range data.DownloadDirs {
+		if err := validateUTF8(fmt.Sprintf("download_dirs[%d]", i), dir); err != nil {
+			return err
+		}
+	}
+	if data.Priority != "" && data.Priority != DownloadPriorityInteractive && data.Priority != DownloadPriorityBackground {
+		return &FieldError{Field: "priority", Message: fmt.Sprintf("must be %q or %q", DownloadPriorityInteractive, DownloadPriorityBackground)}
+	}
+	if err := validateDownloadLayout(data); err != nil {
+		return err
+	}
+	return firstError(
+		validateAppID(data.AppID),
+		validateUUID("asset_data.id", data.DownloadAssetData.ID),
+		validateUTF8("asset_data.name", data.DownloadAssetData.Name),
+		validateUTF8("PREFS.project_subdir", data.PREFS.ProjectSubdir),
+		validateUTF8("PREFS.global_dir", data.PREFS.GlobalDir),
+	)
+}
+
+// resolveDownloadDirs replaces the download dirs with absolute paths, dirs with ".." are rejected.
+func resolveDownloadDirs(dirs []string) error {
+	for i := range dirs {
+		if err := resolvePath(fmt.Sprintf("download_dirs[%d]", i), &dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDownloadLayout checks the layout, single_dir needs exactly one download dir inside the assets_path.
+func validateDownloadLayout(data DownloadData) error {
+	switch data.Layout {
+	case "", DownloadLayoutBlenderDual:
+		return nil
+	case DownloadLayoutSingleDir:
+	default:
+		return &FieldError{Field: "layout", Message: fmt.Sprintf("must be %q or %q", DownloadLayoutBlenderDual, DownloadLayoutSingleDir)}
+	}
+	if len(data.DownloadDirs) != 1 {
+		return &FieldError{Field: "download_dirs", Message: fmt.Sprintf("must contain exactly one dir for %q layout", DownloadLayoutSingleDir)}
+	}
+	if err := validateUTF8("assets_path", data.AssetsPath); err != nil {
+		return err
+	}
+	if data.AssetsPath == "" {
+		return nil
+	}
+	if _, err := assetsRelativePath(data.AssetsPath, data.DownloadDirs[0]); err != nil {
+		return &FieldError{Field: "assets_path", Message: err.Error()}
+	}
+	return nil
+}
+
+// assetsRelativePath returns the slash separated path of filePath relative to assetsPath,
+// e.g. "addons/blenderkit_assets/kitten/kitten_2K_d5368c9d.blend" which Godot resolves as res://addons/...
+func assetsRelativePath(assetsPath, filePath string) (string, error) {
+	rel, err := filepath.Rel(assetsPath, filePath)
 	if err != nil {
-		http.Error(w, "Error converting to JSON: "+err.Error(), http.StatusInternalServerError)
-		return
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside %s", filePath, assetsPath)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseJSON)
+	return filepath.ToSlash(rel), nil
 }
 
-func doAssetDownload(origJSON map[string]interface{}, data DownloadData, taskID string) {
+// doAssetDownload downloads the asset, origJSON is the request of the add-on reported back as task data.
+func doAssetDownload(origJSON json.RawMessage, data DownloadData, taskID string) {
+	if data.RequestID == "" {
+		data.RequestID = taskID
+	}
 	TasksMux.Lock()
-	task := NewTask(origJSON, data.AppID, taskID, "asset_download")
+	task := NewTask(origJSON, data.AppID, taskID, "asset_download").WithRequestID(data.RequestID)
 	task.Message = "Getting download URL"
 	Tasks[task.AppID][taskID] = task
 	TasksMux.Unlock()
+	TaskJournal.Record(task, origJSON)
 
-	// GET URL FOR BLEND FILE WITH CORRECT RESOLUTION
-	canDownload, downloadURL, err := GetDownloadURL(data)
+	if !acquireDownloadSlot(task, data.Priority != DownloadPriorityBackground) {
+		return // cancelled while queued
+	}
+	defer releaseDownloadSlot(task)
+
+	filePaths, note, taskErr := downloadAssetFiles(task, data)
+	if taskErr != nil {
+		sendTask(TaskErrorCh, taskErr)
+		return
+	}
+	message := "Asset downloaded and ready"
+	if note != "" {
+		message = fmt.Sprintf("Asset ready, %s", note)
+	}
+	result, err := assetDownloadResult(data, filePaths)
 	if err != nil {
-		TaskErrorCh <- &TaskError{
-			AppID:  data.AppID,
-			TaskID: taskID,
-			Error:  err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
 		return
 	}
-	if !canDownload {
-		TaskErrorCh <- &TaskError{
+	sendTask(TaskFinishCh, &TaskFinish{
+		AppID:   data.AppID,
+		TaskID:  taskID,
+		Message: message,
+		Result:  result,
+	})
+}
+
+// assetDownloadResult is the result of the finished "asset_download" task, relative_path is set only for DownloadLayoutSingleDir with assets_path.
+func assetDownloadResult(data DownloadData, filePaths []string) (map[string]interface{}, error) {
+	result := map[string]interface{}{"file_paths": filePaths}
+	if data.Layout == DownloadLayoutSingleDir && data.AssetsPath != "" {
+		relPath, err := assetsRelativePath(data.AssetsPath, filePaths[0])
+		if err != nil {
+			return nil, err
+		}
+		result["relative_path"] = relPath
+	}
+	return result, nil
+}
+
+// downloadAssetFiles gets the download URL, downloads the file unless it is already on disk and unpacks it if requested.
+// DownloadLayoutSingleDir is never synced nor unpacked, there is no Blender to unpack it and only one dir.
+// Files already on disk are verified by verifyCachedFiles() and downloaded again if corrupted.
+// Progress is reported on the task, returns paths of the asset file in the download dirs and the cacheNote().
+func downloadAssetFiles(task *Task, data DownloadData) ([]string, string, *TaskError) {
+	taskID := task.TaskID
+	// GET URL FOR BLEND FILE WITH CORRECT RESOLUTION
+	_, downloadURL, err := GetDownloadURL(data)
+	var urlErr *DownloadURLError
+	if errors.As(err, &urlErr) && urlErr.Code != "" {
+		return nil, "", &TaskError{
+			AppID:     data.AppID,
+			TaskID:    taskID,
+			Error:     errors.New(urlErr.Message),
+			Result:    urlErr.Payload,
+			ErrorCode: urlErr.Code}
+	}
+	if err != nil {
+		return nil, "", &TaskError{
 			AppID:  data.AppID,
 			TaskID: taskID,
-			Error:  fmt.Errorf("user cannot download this file")}
-		return
+			Error:  err}
 	}
 
 	// EXTRACT FILENAME FROM URL
-	TaskProgressUpdateCh <- &TaskProgressUpdate{
+	sendTaskProgress(&TaskProgressUpdate{
 		AppID:    data.AppID,
 		TaskID:   taskID,
 		Progress: 0,
 		Message:  "Extracting filename",
-	}
+	})
 	fileName, err := ExtractFilenameFromURL(downloadURL)
+	fileName = localAssetFilename(fileName)
 	if err != nil {
-		TaskErrorCh <- &TaskError{
+		return nil, "", &TaskError{
 			AppID:  data.AppID,
 			TaskID: taskID,
 			Error:  err,
 		}
-		return
 	}
 	// GET FILEPATHS TO WHICH WE DOWNLOAD
-	TaskProgressUpdateCh <- &TaskProgressUpdate{
+	sendTaskProgress(&TaskProgressUpdate{
 		AppID:    data.AppID,
 		TaskID:   taskID,
 		Progress: 0,
 		Message:  "Getting filepaths",
+	})
+	downloadFilePaths, shortened, err := GetDownloadFilepaths(data, fileName)
+	if err != nil {
+		return nil, "", &TaskError{
+			AppID:  data.AppID,
+			TaskID: taskID,
+			Error:  err,
+		}
+	}
+	if shortened {
+		sendTaskMessage(&TaskMessageUpdate{
+			AppID:           data.AppID,
+			TaskID:          taskID,
+			Message:         "Download path too long, file name was shortened",
+			MessageDetailed: fmt.Sprintf("Paths longer than %d characters are not supported on Windows, shortened paths: %v", WindowsPathLimit-1, downloadFilePaths),
+		})
 	}
-	downloadFilePaths := GetDownloadFilepaths(data, fileName)
 
 	// CHECK IF FILE EXISTS ON HARD DRIVE
-	TaskProgressUpdateCh <- &TaskProgressUpdate{
+	sendTaskProgress(&TaskProgressUpdate{
 		AppID:    data.AppID,
 		TaskID:   taskID,
 		Progress: 0,
 		Message:  "Checking files on disk",
-	}
+	})
+	corrupted := verifyCachedFiles(downloadFilePaths)
 	existingFiles := 0
 	for _, filePath := range downloadFilePaths {
 		exists, info, err := FileExists(filePath)
+		if err != nil && info != nil && info.IsDir() {
+			fmt.Println("Deleting directory:", filePath)
+			err = os.RemoveAll(filePath)
+			if err == nil {
+				continue
+			}
+		}
 		if err != nil {
-			if info.IsDir() {
-				fmt.Println("Deleting directory:", filePath)
-				err := os.RemoveAll(filePath)
-				if err != nil {
-					fmt.Println("Error deleting directory:", err)
-				}
-			} else {
-				fmt.Println("Error checking if file exists:", err)
+			return nil, "", &TaskError{
+				AppID:  data.AppID,
+				TaskID: taskID,
+				Error:  fmt.Errorf("checking %s: %w", filePath, err),
 			}
-			continue
 		}
 		if exists {
 			existingFiles++
@@ -158,7 +305,6 @@ func doAssetDownload(origJSON map[string]interface{}, data DownloadData, taskID
 	} else if existingFiles == 2 { // Both files exist -> skip download
 		action = "place"
 	} else if existingFiles == 1 && len(downloadFilePaths) == 2 { // One file exists, but there are two download paths -> sync the missing file
-		// TODO: sync the missing file
 		action = "sync"
 	} else if existingFiles == 1 && len(downloadFilePaths) == 1 { // One file exists, and there is only one download path -> skip download
 		action = "place"
@@ -178,57 +324,65 @@ func doAssetDownload(origJSON map[string]interface{}, data DownloadData, taskID
 		err = downloadAsset(downloadURL, fp, data, taskID, task.Ctx)
 		if err != nil {
 			e := fmt.Errorf("error downloading asset: %w", err)
-			TaskErrorCh <- &TaskError{
+			return nil, "", &TaskError{
+				AppID:           data.AppID,
+				TaskID:          taskID,
+				Error:           e,
+				MessageDetailed: TraceDetail(e),
+			}
+		}
+	} else if action == "sync" && data.Layout != DownloadLayoutSingleDir {
+		src, dst := downloadFilePaths[1], downloadFilePaths[0]
+		if exists, _, _ := FileExists(dst); exists {
+			src, dst = dst, src
+		}
+		err := copyAssetFile(src, dst)
+		if err == nil { // textures and other files of the asset
+			err = syncDirs(filepath.Dir(src), filepath.Dir(dst), "")
+		}
+		if err != nil {
+			return nil, "", &TaskError{
 				AppID:  data.AppID,
 				TaskID: taskID,
-				Error:  e,
+				Error:  fmt.Errorf("error syncing asset to %s: %w", filepath.Dir(dst), err),
 			}
-			return
 		}
 	} else {
 		fmt.Println("PLACING THE FILE")
 	}
 
 	// UNPACKING
-	if data.UnpackFiles {
+	if data.UnpackFiles && data.Layout != DownloadLayoutSingleDir {
 		err := UnpackAsset(fp, data, taskID)
 		if err != nil {
 			e := fmt.Errorf("error unpacking asset: %w", err)
-			TaskErrorCh <- &TaskError{
-				AppID:  data.AppID,
-				TaskID: taskID,
-				Error:  e,
-			}
-			return
+			return nil, "", subprocessTaskError(data.AppID, taskID, e)
 		}
 	}
 
-	result := map[string]interface{}{"file_paths": downloadFilePaths}
-	TaskFinishCh <- &TaskFinish{
-		AppID:   data.AppID,
-		TaskID:  taskID,
-		Message: "Asset downloaded and ready",
-		Result:  result,
-	}
+	return downloadFilePaths, cacheNote(action, corrupted), nil
 }
 
 // UnpackAsset unpacks the downloaded asset (.blend file).
 // It skips unpacking for HDRi files and returns nil immediately.
 func UnpackAsset(blendPath string, data DownloadData, taskID string) error {
 	if data.AssetType == "hdr" { // Skip unpacking for HDRi files
-		TaskMessageCh <- &TaskMessageUpdate{
+		sendTaskMessage(&TaskMessageUpdate{
 			AppID:   data.AppID,
 			TaskID:  taskID,
 			Message: "HDRi file doesn't need unpacking",
-		}
+		})
 		return nil
 	}
 
-	TaskMessageCh <- &TaskMessageUpdate{
+	if _, err := checkBlenderBinary(data.BinaryPath, "PREFS.binary_path"); err != nil {
+		return err
+	}
+	sendTaskMessage(&TaskMessageUpdate{
 		AppID:   data.AppID,
 		TaskID:  taskID,
 		Message: "Unpacking files",
-	}
+	})
 	blenderUserScripts := filepath.Dir(filepath.Dir(data.PREFS.AddonDir)) // e.g.: /Users/username/Library/Application Support/Blender/4.1/scripts"
 	unpackScriptPath := filepath.Join(data.PREFS.AddonDir, "unpack_asset_bg.py")
 	dataFile := filepath.Join(os.TempDir(), "resdata.json")
@@ -261,7 +415,7 @@ func UnpackAsset(blendPath string, data DownloadData, taskID string) error {
 		dataFile,
 	)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("BLENDER_USER_SCRIPTS=%v", blenderUserScripts))
-	out, err := cmd.CombinedOutput()
+	out, err := runBlenderScript(cmd, "unpack_asset_bg.py")
 	color.FgGray.Println("(Background) Unpacking logs:\n", string(out))
 	if err != nil {
 		return err
@@ -270,83 +424,134 @@ func UnpackAsset(blendPath string, data DownloadData, taskID string) error {
 	return nil
 }
 
+// downloadAsset downloads the file into filePath+".part", which is renamed to filePath once the download is complete.
+// Part file left by interrupted download (e.g. the Client was killed) is resumed with a Range request.
 func downloadAsset(url, filePath string, data DownloadData, taskID string, ctx context.Context) error {
-	TaskProgressUpdateCh <- &TaskProgressUpdate{
+	sendTaskProgress(&TaskProgressUpdate{
 		AppID:    data.AppID,
 		TaskID:   taskID,
 		Progress: 0,
 		Message:  "Downloading",
-	}
+	})
 
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+	partPath := filePath + ".part"
+	TaskJournal.SetPartPath(taskID, partPath)
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && info.Mode().IsRegular() {
+		offset = info.Size()
 	}
-	defer file.Close()
 
+	ctx, cancel, client := withRequestTimeout(ctx, ClientDownloads, data.TimeoutS)
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header = getHeaders("", *SystemID, data.AddonVersion, data.PlatformVersion) // download needs no API key in headers
-	resp, err := ClientDownloads.Do(req)
+	req.Header = getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID}) // download needs no API key in headers
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	trace := NewRequestTrace("download")
+	req = trace.Attach(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		e := DeleteFile(filePath)
-		if e != nil {
-			return fmt.Errorf("request failed: %w, failed to delete file: %w", err, e)
-		}
-		return err
+		return trace.Wrap(err) // .part is kept, next download of the file resumes it
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent
+	if resp.StatusCode != http.StatusOK && !resumed {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
 		err := fmt.Errorf("server returned non-OK status (%d): %s", resp.StatusCode, respString)
-		e := DeleteFile(filePath)
-		if e != nil {
-			return fmt.Errorf("%w, failed to delete file: %w", err, e)
+		if e := deletePartFile(partPath); e != nil {
+			return trace.Wrap(fmt.Errorf("%w, failed to delete file: %w", err, e))
 		}
-		return err
+		return trace.Wrap(err)
+	}
+	trace.Finish()
+
+	encoding := downloadEncoding(resp, url)
+	if encoding != "" && resumed { // .part holds decompressed data, compressed stream cannot be resumed from its size
+		resp.Body.Close()
+		BKLog.Printf("%s Compressed download of %s cannot be resumed, downloading again", EmoWarning, filepath.Base(filePath))
+		if err := deletePartFile(partPath); err != nil {
+			return err
+		}
+		return downloadAsset(url, filePath, data, taskID, ctx)
+	}
+	body := io.Reader(resp.Body)
+	var compressed *countingReader
+	if encoding != "" {
+		compressed = &countingReader{r: resp.Body}
+		decoder, err := decompressDownload(compressed, encoding)
+		if err != nil {
+			if e := deletePartFile(partPath); e != nil {
+				return fmt.Errorf("%w, failed to delete file: %w", err, e)
+			}
+			return err
+		}
+		defer decoder.Close()
+		body = decoder
 	}
 
 	totalLength := resp.Header.Get("Content-Length")
 	if totalLength == "" {
-		e := DeleteFile(filePath)
-		if e != nil {
-			return fmt.Errorf("request failed: %w, failed to delete file: %w", err, e)
+		if e := deletePartFile(partPath); e != nil {
+			return fmt.Errorf("Content-Length header is missing, failed to delete file: %w", e)
 		}
 		return fmt.Errorf("Content-Length header is missing")
 	}
 
 	fileSize, err := strconv.ParseInt(totalLength, 10, 64)
 	if err != nil {
-		e := DeleteFile(filePath)
-		if e != nil {
+		if e := deletePartFile(partPath); e != nil {
 			return fmt.Errorf("length conversion failed: %w, failed to delete file: %w", err, e)
 		}
 		return err
 	}
 
+	var downloaded int64 = 0
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC // server ignoring Range sends the whole file
+	if resumed {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		downloaded = offset
+		fileSize += offset
+		BKLog.Printf("%s Resuming download of %s from %d bytes", EmoDownload, filepath.Base(filePath), offset)
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
 	// Setup for monitoring progress and cancellation
 	sizeInMB := float64(fileSize) / 1024 / 1024
-	var downloaded int64 = 0
+	sizeNote := ""
+	if compressed != nil { // fileSize is the compressed size, progress is reported by compressed bytes read
+		sizeNote = " compressed"
+	}
 	progress := make(chan int64)
 	go func() {
 		var downloadMessage string
+		rate := &transferRate{}
 		for p := range progress {
+			speed, eta := rate.update(time.Now(), p, fileSize)
 			progress := int(100 * p / fileSize)
 			if sizeInMB < 1 { // If the size is less than 1MB, show in KB
-				downloadMessage = fmt.Sprintf("Downloading %dkB (%d%%)", int(sizeInMB*1024), progress)
+				downloadMessage = fmt.Sprintf("Downloading %dkB%s (%d%%)", int(sizeInMB*1024), sizeNote, progress)
 			} else { // If the size is not a whole number, show one decimal place
-				downloadMessage = fmt.Sprintf("Downloading %.1fMB (%d%%)", sizeInMB, progress)
-			}
-			TaskProgressUpdateCh <- &TaskProgressUpdate{
-				AppID:    data.AppID,
-				TaskID:   taskID,
-				Progress: progress,
-				Message:  downloadMessage,
+				downloadMessage = fmt.Sprintf("Downloading %.1fMB%s (%d%%)", sizeInMB, sizeNote, progress)
 			}
+			sendTaskProgress(&TaskProgressUpdate{
+				AppID:      data.AppID,
+				TaskID:     taskID,
+				Progress:   progress,
+				Message:    downloadMessage,
+				SpeedBPS:   speed,
+				ETASeconds: eta,
+			})
 		}
 	}()
 
@@ -355,94 +560,254 @@ func downloadAsset(url, filePath string, data DownloadData, taskID string, ctx c
 		select {
 		case <-ctx.Done():
 			close(progress)
-			err = DeleteFile(filePath)
+			file.Close()
+			err = deletePartFile(partPath)
 			if err != nil {
 				return fmt.Errorf("%w, failed to delete file: %w", ctx.Err(), err)
 			}
 			return ctx.Err()
 		default:
-			n, readErr := resp.Body.Read(buffer)
+			n, readErr := body.Read(buffer)
 			if n > 0 {
 				_, writeErr := file.Write(buffer[:n])
 				if writeErr != nil {
 					close(progress)
-					err = DeleteFile(filePath) // Clean up; ignore error from DeleteFile to focus on writeErr
+					file.Close()
+					err = deletePartFile(partPath) // Clean up; ignore error from DeleteFile to focus on writeErr
 					if err != nil {
 						return fmt.Errorf("%w, failed to delete file: %w", writeErr, err)
 					}
 					return writeErr
 				}
 				downloaded += int64(n)
+				if compressed != nil {
+					downloaded = compressed.n
+				}
 				progress <- downloaded
+				if data.Priority == DownloadPriorityBackground {
+					throttleBackgroundDownload(ctx, data.AppID, n)
+				}
 			}
 			if readErr != nil {
 				close(progress)
-				if readErr == io.EOF {
-					return nil // Download completed successfully
+				if readErr == io.EOF { // Download completed successfully
+					if err := file.Close(); err != nil {
+						return err
+					}
+					return os.Rename(partPath, filePath)
 				}
-				err := DeleteFile(filePath) // Clean up; ignore error from DeleteFile to focus on readErr
-				if err != nil {
-					return fmt.Errorf("%w, failed to delete file: %w", readErr, err)
+				if compressed != nil { // partially decompressed file cannot be resumed
+					file.Close()
+					if err := deletePartFile(partPath); err != nil {
+						return fmt.Errorf("%w, failed to delete file: %w", readErr, err)
+					}
 				}
-				return readErr
+				return readErr // .part is kept, next download of the file resumes it
 			}
 		}
 	}
 }
 
+// deletePartFile removes the part file of the download, missing file is not an error.
+func deletePartFile(partPath string) error {
+	if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // should return ['/Users/ag/blenderkit_data/models/kitten_0992088b-fb84-4c69-bb6e-426272970c8b/kitten_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend']
-func GetDownloadFilepaths(data DownloadData, filename string) []string {
+// On Windows, paths which would exceed WindowsPathLimit are shortened by downloadFilepath(), second return value reports it.
+// Asset directories are created, error is returned if one cannot be created or no download dir can be used.
+// Overlapping download dirs are collapsed into the first one, see normalizeDownloadDirs().
+func GetDownloadFilepaths(data DownloadData, filename string) ([]string, bool, error) {
+	limit := downloadPathLimit()
 	filePaths := []string{}
-	filename = ServerToLocalFilename(filename, data.DownloadAssetData.Name)
-	assetDirName := fmt.Sprintf("%s_%s", Slugify(data.DownloadAssetData.Name), data.DownloadAssetData.ID)
-	for _, dir := range data.DownloadDirs {
-		assetDirPath := filepath.Join(dir, assetDirName)
-		if _, err := os.Stat(assetDirPath); os.IsNotExist(err) {
-			os.MkdirAll(assetDirPath, os.ModePerm)
-		}
-		filePath := filepath.Join(assetDirPath, filename)
+	shortened := false
+	dirs, overlaps := normalizeDownloadDirs(data.DownloadDirs)
+	warnOverlappingDirs(data.AppID, overlaps)
+	for _, dir := range dirs {
+		var filePath string
+		short, ok := false, true
+		if data.Layout == DownloadLayoutSingleDir {
+			filePath = singleDirFilepath(dir, data.DownloadAssetData.Name, filename)
+		} else {
+			filePath, short, ok = downloadFilepath(dir, data.DownloadAssetData.Name, data.DownloadAssetData.ID, filename, limit)
+		}
+		if !ok {
+			BKLog.Printf("%s Skipping download dir, path would be too long even when shortened: %s", EmoWarning, dir)
+			continue
+		}
+		if !isPathInside(filepath.Clean(dir), filePath) {
+			BKLog.Printf("%s Skipping download dir, asset file %s would be outside of it: %s", EmoWarning, filename, dir)
+			continue
+		}
+		shortened = shortened || short
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return nil, false, fmt.Errorf("creating asset directory: %w", err)
+		}
 		filePaths = append(filePaths, filePath)
 	}
+	if len(filePaths) == 0 {
+		return nil, false, fmt.Errorf("no usable download directory in %v", data.DownloadDirs)
+	}
+	return filePaths, shortened, nil
+}
+
+// downloadPathLimit is the max length of the download path, 0 for no limit.
+func downloadPathLimit() int {
+	if runtime.GOOS == "windows" {
+		return WindowsPathLimit
+	}
+	return 0 // Mac and Linux have no practical limit
+}
+
+// singleDirFilepath returns path of the asset file in DownloadLayoutSingleDir: dir/slug/slug_resolution_fileID.blend.
+func singleDirFilepath(dir, assetName, serverFilename string) string {
+	slug := Slugify(assetName)
+	return filepath.Join(dir, slug, ServerToLocalFilename(serverFilename, slug))
+}
+
+// downloadFilepath returns path of the asset file in the dir: dir/slug_assetID/slug_resolution_fileID.blend.
+// If the path is not shorter than limit, it is shortened deterministically, so the same asset always gets the same path:
+// first the slug of the asset name is truncated, if that is not enough the slug is replaced by a short hash of the name
+// and left out from the directory name. Resolution and IDs are always kept, so the file stays identifiable.
+// Limit 0 means no limit. Returns the path, whether it was shortened and false if even the shortest path is too long.
+func downloadFilepath(dir, assetName, assetID, serverFilename string, limit int) (string, bool, bool) {
+	slug := Slugify(assetName)
+	build := func(slug string, slugDir bool) string {
+		assetDirName := assetID
+		if slugDir {
+			assetDirName = fmt.Sprintf("%s_%s", slug, assetID)
+		}
+		return filepath.Join(dir, assetDirName, ServerToLocalFilename(serverFilename, slug))
+	}
 
-	// For Mac and Linux, we can return the file paths as they are.
-	if runtime.GOOS != "windows" {
-		return filePaths
+	filePath := build(slug, true)
+	if limit == 0 || len(filePath) < limit {
+		return filePath, false, true
 	}
 
-	// On Windows we need to check if path is not too long
-	var filteredWinPaths []string
-	for _, filePath := range filePaths {
-		if len(filePath) < 259 {
-			filteredWinPaths = append(filteredWinPaths, filePath)
+	// Slug is both in the directory and file name
+	slugLen := (limit - 1 - (len(filePath) - 2*len(slug))) / 2
+	if slugLen >= minShortenedSlugLen {
+		if short := strings.Trim(slug[:slugLen], "-"); short != "" {
+			return build(short, true), true, true
 		}
 	}
-	return filteredWinPaths
+
+	hash := sha256.Sum256([]byte(assetName))
+	filePath = build(hex.EncodeToString(hash[:])[:6], false)
+	if len(filePath) < limit {
+		return filePath, true, true
+	}
+	return "", false, false
+}
+
+// Error codes of denied downloads, so the add-on can show login button or upgrade link.
+const (
+	ErrCodeLoginRequired  = "login_required"
+	ErrCodePlanRequired   = "plan_required"
+	ErrCodePrivateAsset   = "private_asset"
+	ErrCodeDownloadDenied = "download_denied"
+)
+
+// DownloadURLError is returned by GetDownloadURL when the server refuses to give the download URL.
+type DownloadURLError struct {
+	StatusCode int
+	Message    string          // detail from the server, e.g. "User is anonymous"
+	Code       string          // ErrCodeLoginRequired, ErrCodePlanRequired, ErrCodePrivateAsset or ErrCodeDownloadDenied if access was denied
+	Payload    json.RawMessage // error response of the server, nil if it was not JSON
+}
+
+// downloadDenial is the error response of the download endpoint. Detail is a string, DRF style {"detail": "Invalid token."}.
+// Object detail with messages and type is assumed, not confirmed from the server, so the message words stay the main classification.
+type downloadDenial struct {
+	Detail   json.RawMessage `json:"detail"`
+	Messages []string        `json:"messages"`
+	Type     string          `json:"type"`
+}
+
+// parseDownloadDenial returns the human text and the error code of the error response.
+func parseDownloadDenial(statusCode int, respJSON json.RawMessage, respString string) (message, code string) {
+	var denial downloadDenial
+	if json.Unmarshal(respJSON, &denial) == nil {
+		var detail string
+		if json.Unmarshal(denial.Detail, &detail) != nil {
+			json.Unmarshal(denial.Detail, &denial)
+		}
+		if len(denial.Messages) > 0 {
+			detail = strings.Join(denial.Messages, " ")
+		}
+		if detail != "" {
+			respString = detail
+		}
+	}
+	if statusCode != http.StatusUnauthorized && statusCode != http.StatusForbidden {
+		return respString, ""
+	}
+
+	reason := denial.Type // the message is classified only when the server sent no type
+	if reason == "" {
+		reason = respString
+	}
+	switch {
+	case statusCode == http.StatusUnauthorized || hasWord(reason, "anonymous"):
+		code = ErrCodeLoginRequired
+	case hasWord(reason, "plan", "subscription"):
+		code = ErrCodePlanRequired
+	case hasWord(reason, "private"):
+		code = ErrCodePrivateAsset
+	default:
+		code = ErrCodeDownloadDenied
+	}
+	return respString, code
+}
+
+// hasWord reports whether the text contains one of the lowercase words as a whole word,
+// e.g. "plan" in "user_plan" or "Full Plan." but not in "explanation".
+func hasWord(text string, words ...string) bool {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	for _, field := range fields {
+		if slices.Contains(words, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *DownloadURLError) Error() string {
+	return fmt.Sprintf("server returned non-OK status (%d): %s", e.StatusCode, e.Message)
 }
 
 // Get the download URL for the asset file.
-// Returns: canDownload, downloadURL, error.
+// Returns: canDownload, downloadURL, error. If server refuses the download, error is *DownloadURLError.
 func GetDownloadURL(data DownloadData) (bool, string, error) {
 	reqData := url.Values{}
 	reqData.Set("scene_uuid", data.SceneID)
 
 	file, _ := GetResolutionFile(data.Files, data.PREFS.Resolution)
+	if file.DownloadURL == "" {
+		return false, "", fmt.Errorf("no downloadable file for resolution %s", data.PREFS.Resolution)
+	}
 
 	req, err := http.NewRequest("GET", file.DownloadURL, nil)
 	if err != nil {
 		return false, "", err
 	}
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
 	req.URL.RawQuery = reqData.Encode()
 
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		return false, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		return false, "", fmt.Errorf("server returned non-OK status (%d): %s", resp.StatusCode, respString)
+		respJSON, respString, _ := ParseFailedHTTPResponse(resp)
+		message, code := parseDownloadDenial(resp.StatusCode, respJSON, respString)
+		return false, "", &DownloadURLError{StatusCode: resp.StatusCode, Message: message, Code: code, Payload: respJSON}
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -464,20 +829,39 @@ func GetDownloadURL(data DownloadData) (bool, string, error) {
 	return true, url, nil
 }
 
-func GetResolutionFile(files []AssetFile, targetRes string) (AssetFile, string) {
-	resolutionsMap := map[string]int{
-		"resolution_0_5K": 512,
-		"resolution_1K":   1024,
-		"resolution_2K":   2048,
-		"resolution_4K":   4096,
-		"resolution_8K":   8192,
+// GetDownloadFileSize returns size of the file at the download URL from HEAD request, 0 if it cannot be found out.
+func GetDownloadFileSize(downloadURL string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", downloadURL, nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := ClientDownloads.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0
 	}
+	return resp.ContentLength
+}
+
+// resolutionPixels maps file types of the resolution files to their size in pixels.
+var resolutionPixels = map[string]int{
+	"resolution_0_5K": 512,
+	"resolution_1K":   1024,
+	"resolution_2K":   2048,
+	"resolution_4K":   4096,
+	"resolution_8K":   8192,
+}
+
+func GetResolutionFile(files []AssetFile, targetRes string) (AssetFile, string) {
 	var originalFile, closest AssetFile
-	var targetResInt, mindist = resolutionsMap[targetRes], 100000000
+	var targetResInt, mindist = resolutionPixels[targetRes], 100000000
 
-	fmt.Println(">> Target resolution:", targetRes)
 	for _, f := range files {
-		fmt.Println(">> File type:", f.FileType)
 		if f.FileType == "thumbnail" {
 			continue
 		}
@@ -488,14 +872,12 @@ func GetResolutionFile(files []AssetFile, targetRes string) (AssetFile, string)
 			}
 		}
 
-		r := strconv.Itoa(resolutionsMap[f.FileType])
-		if r == targetRes {
+		if f.FileType == targetRes {
 			return f, f.FileType // exact match found, return.
 		}
 
-		// TODO: check if this works properly
 		// find closest resolution if the exact match won't be found
-		rval, ok := resolutionsMap[f.FileType]
+		rval, ok := resolutionPixels[f.FileType]
 		if ok && targetResInt != 0 {
 			rdiff := abs(targetResInt - rval)
 			if rdiff < mindist {
@@ -0,0 +1,125 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MinCachedBlendSize is the smallest asset file on disk which is trusted without download, smaller is surely truncated.
+var MinCachedBlendSize int64 = 1024
+
+// blendMagics are the headers of .blend files: uncompressed, gzip compressed (Blender < 3.0) and zstd compressed.
+var blendMagics = [][]byte{
+	[]byte("BLENDER"),
+	{0x1f, 0x8b},
+	{0x28, 0xb5, 0x2f, 0xfd},
+}
+
+const blendMagicMaxLen = 7 // length of the longest of blendMagics
+
+// Notes on the cached asset files, added to the message of the finished download task.
+const (
+	cacheVerified   = "verified cached file"
+	cacheRedownload = "re-downloaded corrupted cache"
+	cacheRepaired   = "replaced corrupted cache with verified copy"
+)
+
+// checkCachedFile cheaply checks the asset file on disk: it must have MinCachedBlendSize and .blend files
+// must start with the blend header. Returns size of the file.
+func checkCachedFile(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < MinCachedBlendSize {
+		return info.Size(), fmt.Errorf("%s has only %d bytes", path, info.Size())
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".blend") { // e.g. HDR images
+		return info.Size(), nil
+	}
+	header := make([]byte, blendMagicMaxLen)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return info.Size(), fmt.Errorf("reading header of %s: %w", path, err)
+	}
+	for _, magic := range blendMagics {
+		if bytes.HasPrefix(header, magic) {
+			return info.Size(), nil
+		}
+	}
+	return info.Size(), fmt.Errorf("%s is not a blend file, header %q", path, header)
+}
+
+// verifyCachedFiles checks the asset files which exist in the download paths and removes the corrupted ones,
+// so they are downloaded or synced again. If the copies in local and global directory differ in size, both are removed,
+// as it cannot be told cheaply which one is complete. Returns true if any file was removed.
+func verifyCachedFiles(filePaths []string) bool {
+	var existing []string
+	var sizes []int64
+	corrupted := false
+	for _, path := range filePaths {
+		if exists, _, _ := FileExists(path); !exists {
+			continue
+		}
+		size, err := checkCachedFile(path)
+		if err != nil {
+			BKLog.Printf("%s Corrupted cached asset file: %v", EmoWarning, err)
+			corrupted = true
+			if err := DeleteFile(path); err != nil {
+				BKLog.Printf("%s Error deleting corrupted file: %v", EmoWarning, err)
+			}
+			continue
+		}
+		existing, sizes = append(existing, path), append(sizes, size)
+	}
+	if len(existing) == 2 && sizes[0] != sizes[1] {
+		BKLog.Printf("%s Cached asset files differ in size (%d and %d bytes): %v", EmoWarning, sizes[0], sizes[1], existing)
+		corrupted = true
+		for _, path := range existing {
+			if err := DeleteFile(path); err != nil {
+				BKLog.Printf("%s Error deleting corrupted file: %v", EmoWarning, err)
+			}
+		}
+	}
+	return corrupted
+}
+
+// cacheNote describes what was done with the cached files for the action of the download, empty if there was no cache.
+func cacheNote(action string, corrupted bool) string {
+	switch {
+	case corrupted && action == "download":
+		return cacheRedownload
+	case corrupted:
+		return cacheRepaired
+	case action == "place":
+		return cacheVerified
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,126 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCachedFile writes a fabricated asset file with the header padded to the size.
+func writeCachedFile(t *testing.T, path string, header []byte, size int) string {
+	t.Helper()
+	content := append(append([]byte{}, header...), bytes.Repeat([]byte{0}, size-len(header))...)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckCachedFile(t *testing.T) {
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte("BLENDER-v402"))
+	zw.Close()
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"uncompressed blend", writeCachedFile(t, filepath.Join(dir, "plain.blend"), []byte("BLENDER-v402RENDH"), 4096), ""},
+		{"gzip blend", writeCachedFile(t, filepath.Join(dir, "gzip.blend"), gzipped.Bytes(), 4096), ""},
+		{"zstd blend", writeCachedFile(t, filepath.Join(dir, "zstd.blend"), []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, 4096), ""},
+		{"truncated", writeCachedFile(t, filepath.Join(dir, "truncated.blend"), []byte("BLENDER-v402"), 100), "has only 100 bytes"},
+		{"empty", writeCachedFile(t, filepath.Join(dir, "empty.blend"), nil, 0), "has only 0 bytes"},
+		{"HTML error page", writeCachedFile(t, filepath.Join(dir, "html.blend"), []byte("<html><body>Access denied"), 4096), "is not a blend file"},
+		{"zeroed", writeCachedFile(t, filepath.Join(dir, "zeroed.blend"), nil, 4096), "is not a blend file"},
+		{"HDR image has no blend header", writeCachedFile(t, filepath.Join(dir, "sky.exr"), []byte{0x76, 0x2f, 0x31, 0x01}, 4096), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkCachedFile(tt.path)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkCachedFile() error = %v; want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkCachedFile() error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCachedFiles(t *testing.T) {
+	blend := []byte("BLENDER-v402")
+	tests := []struct {
+		name          string
+		sizes         []int // size of the file in project and global dir, 0 for missing, -1 for corrupted header
+		wantCorrupted bool
+		wantKept      []bool
+		wantNote      string
+	}{
+		{"both good", []int{4096, 4096}, false, []bool{true, true}, cacheVerified},
+		{"one good", []int{0, 4096}, false, []bool{false, true}, ""},
+		{"none", []int{0, 0}, false, []bool{false, false}, ""},
+		{"one truncated", []int{4096, 200}, true, []bool{true, false}, cacheRepaired},
+		{"one not blend", []int{-1, 4096}, true, []bool{false, true}, cacheRepaired},
+		{"size mismatch", []int{4096, 8192}, true, []bool{false, false}, cacheRedownload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := []string{filepath.Join(dir, "project", "chair_2K.blend"), filepath.Join(dir, "global", "chair_2K.blend")}
+			for i, size := range tt.sizes {
+				switch {
+				case size > 0:
+					writeCachedFile(t, paths[i], blend, size)
+				case size < 0:
+					writeCachedFile(t, paths[i], []byte("garbage"), 4096)
+				}
+			}
+
+			corrupted := verifyCachedFiles(paths)
+			if corrupted != tt.wantCorrupted {
+				t.Errorf("verifyCachedFiles() = %v; want %v", corrupted, tt.wantCorrupted)
+			}
+			existing := 0
+			for i, path := range paths {
+				exists, _, _ := FileExists(path)
+				if exists != tt.wantKept[i] {
+					t.Errorf("%s exists = %v; want %v", path, exists, tt.wantKept[i])
+				}
+				if exists {
+					existing++
+				}
+			}
+			action := map[int]string{0: "download", 1: "sync", 2: "place"}[existing]
+			if note := cacheNote(action, corrupted); note != tt.wantNote {
+				t.Errorf("cacheNote(%q, %v) = %q; want %q", action, corrupted, note, tt.wantNote)
+			}
+		})
+	}
+}
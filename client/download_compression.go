@@ -0,0 +1,114 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// downloadDecoders decompress downloaded asset files by their encoding. Only these encodings are accepted from the server.
+var downloadDecoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip": func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"zstd": func(r io.Reader) (io.ReadCloser, error) {
+		// one download is decoded by one goroutine, frames of .blend files fit the default window of the decoder
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+// compressionSuffixes maps suffixes of compressed server filenames to their encoding.
+var compressionSuffixes = map[string]string{
+	".gz":  "gzip",
+	".zst": "zstd",
+}
+
+// acceptEncoding is the Accept-Encoding header of asset downloads. Setting it also stops the transport
+// from decompressing gzip on its own, which would drop Content-Length needed for the progress.
+func acceptEncoding() string {
+	encodings := make([]string, 0, len(downloadDecoders)+1)
+	for encoding := range downloadDecoders {
+		encodings = append(encodings, encoding)
+	}
+	sort.Strings(encodings)
+	return strings.Join(append(encodings, "identity"), ", ")
+}
+
+// localAssetFilename strips the compression suffix from the server filename, the file is stored decompressed
+// under the name which ServerToLocalFilename() and the add-on expect, e.g. "resolution_2K_<id>.blend.gz" > "resolution_2K_<id>.blend".
+func localAssetFilename(serverFilename string) string {
+	if _, ok := compressionSuffixes[path.Ext(serverFilename)]; ok {
+		return strings.TrimSuffix(serverFilename, path.Ext(serverFilename))
+	}
+	return serverFilename
+}
+
+// downloadEncoding returns the encoding of the downloaded file from Content-Encoding, or from the suffix of the URL
+// if the header is missing. Empty string means the file is not compressed.
+func downloadEncoding(resp *http.Response, url string) string {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	if encoding != "" {
+		return encoding
+	}
+	if filename, err := ExtractFilenameFromURL(url); err == nil {
+		return compressionSuffixes[path.Ext(filename)]
+	}
+	return ""
+}
+
+// decompressDownload wraps the body of the download into the decoder of the encoding, the decoder must be closed.
+func decompressDownload(body io.Reader, encoding string) (io.ReadCloser, error) {
+	if encoding == "" {
+		return io.NopCloser(body), nil
+	}
+	decoder, ok := downloadDecoders[encoding]
+	if !ok {
+		return nil, fmt.Errorf("download is compressed by unsupported %s", encoding)
+	}
+	r, err := decoder(body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s compressed download: %w", encoding, err)
+	}
+	return r, nil
+}
+
+// countingReader counts bytes read through it, used to report download progress against the compressed size.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,179 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDownloadAssetDecompresses(t *testing.T) {
+	content := "BLENDER-v300" + strings.Repeat("0123456789", 10000)
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		compressed []byte
+	}{
+		{"gzip content encoding", "/asset.blend", "gzip", gzipped(t, content)},
+		{"gzip filename suffix", "/asset.blend.gz", "", gzipped(t, content)},
+		{"zstd content encoding", "/asset.blend", "zstd", zstdCompressed(t, content)},
+		{"zstd filename suffix", "/asset.blend.zst", "", zstdCompressed(t, content)},
+		{"identity", "/asset.blend", "identity", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accepted string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accepted = r.Header.Get("Accept-Encoding")
+				body := tt.compressed
+				if tt.header == "identity" {
+					body = []byte(content)
+				}
+				if tt.header != "" {
+					w.Header().Set("Content-Encoding", tt.header)
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write(body)
+			}))
+			defer server.Close()
+			defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+			ClientDownloads = server.Client()
+
+			filePath := filepath.Join(t.TempDir(), "asset_2K.blend")
+			if err := downloadAsset(server.URL+tt.path, filePath, DownloadData{AppID: 1}, "compressed-task", context.Background()); err != nil {
+				t.Fatalf("downloadAsset: %v", err)
+			}
+			downloaded, _ := os.ReadFile(filePath)
+			if string(downloaded) != content {
+				t.Errorf("downloaded %d bytes; want %d bytes of the decompressed content", len(downloaded), len(content))
+			}
+			if accepted != "gzip, zstd, identity" {
+				t.Errorf("Accept-Encoding = %q; want %q", accepted, "gzip, zstd, identity")
+			}
+			TaskUpdates.take()
+		})
+	}
+}
+
+func TestDownloadAssetCompressedNotResumed(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	compressed := gzipped(t, content)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("Content-Encoding", "gzip")
+		body := compressed
+		if r.Header.Get("Range") != "" {
+			body = compressed[100:]
+			w.Header().Set("Content-Range", "bytes 100-"+strconv.Itoa(len(compressed)-1)+"/"+strconv.Itoa(len(compressed)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+	ClientDownloads = server.Client()
+
+	filePath := filepath.Join(t.TempDir(), "asset_2K.blend")
+	os.WriteFile(filePath+".part", []byte(content[:100]), 0644)
+	if err := downloadAsset(server.URL, filePath, DownloadData{AppID: 1}, "gzip-resume-task", context.Background()); err != nil {
+		t.Fatalf("downloadAsset: %v", err)
+	}
+	downloaded, _ := os.ReadFile(filePath)
+	if string(downloaded) != content {
+		t.Errorf("downloaded %d bytes; want %d bytes of the decompressed content", len(downloaded), len(content))
+	}
+	if len(ranges) != 2 || ranges[1] != "" {
+		t.Errorf("requested ranges %q; want the resumed request followed by the full download", ranges)
+	}
+	TaskUpdates.take()
+}
+
+func TestDownloadAssetUnsupportedEncoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Header().Set("Content-Length", "4")
+		w.Write([]byte{0x0b, 0x02, 0x80, 0x00})
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+	ClientDownloads = server.Client()
+
+	filePath := filepath.Join(t.TempDir(), "asset_2K.blend")
+	err := downloadAsset(server.URL, filePath, DownloadData{AppID: 1}, "br-task", context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unsupported br") {
+		t.Errorf("downloadAsset error = %v; want unsupported br", err)
+	}
+	if _, err := os.Stat(filePath + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part file was left behind")
+	}
+	TaskUpdates.take()
+}
+
+func TestLocalAssetFilename(t *testing.T) {
+	tests := map[string]string{
+		"blend_d5368c9d.blend":     "blend_d5368c9d.blend",
+		"blend_d5368c9d.blend.gz":  "blend_d5368c9d.blend",
+		"blend_d5368c9d.blend.zst": "blend_d5368c9d.blend",
+		"hdr_d5368c9d.exr":         "hdr_d5368c9d.exr",
+	}
+	for in, want := range tests {
+		if got := localAssetFilename(in); got != want {
+			t.Errorf("localAssetFilename(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DirOverlap is the download dir dropped because it is the same as, or nested with, the kept one.
+type DirOverlap struct {
+	Kept    string
+	Dropped string
+}
+
+var (
+	overlapsWarned    = map[DirOverlap]bool{} // overlaps the add-on was already warned about
+	overlapsWarnedMux sync.Mutex
+)
+
+// canonicalDir resolves the symlinks in dir. Dirs which do not exist yet are resolved through their closest existing parent.
+func canonicalDir(dir string) string {
+	dir = filepath.Clean(dir)
+	rest := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Join(dir, rest)
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// normalizeDownloadDirs drops the download dirs which are the same as an earlier dir, or one is inside the other,
+// after resolving symlinks. This happens when the project subdir is set to the global dir: the asset would be
+// "synced" onto itself. The first dir is kept, as the asset is downloaded into it.
+func normalizeDownloadDirs(dirs []string) ([]string, []DirOverlap) {
+	var kept, canonical []string
+	var overlaps []DirOverlap
+	for _, dir := range dirs {
+		resolved := canonicalDir(dir)
+		overlapping := -1
+		for i, keptDir := range canonical {
+			if isPathInside(keptDir, resolved) || isPathInside(resolved, keptDir) {
+				overlapping = i
+				break
+			}
+		}
+		if overlapping >= 0 {
+			overlaps = append(overlaps, DirOverlap{Kept: kept[overlapping], Dropped: dir})
+			continue
+		}
+		kept = append(kept, dir)
+		canonical = append(canonical, resolved)
+	}
+	return kept, overlaps
+}
+
+// warnOverlappingDirs tells the user once per overlap that their directory preferences overlap.
+func warnOverlappingDirs(appID int, overlaps []DirOverlap) {
+	for _, overlap := range overlaps {
+		overlapsWarnedMux.Lock()
+		warned := overlapsWarned[overlap]
+		overlapsWarned[overlap] = true
+		overlapsWarnedMux.Unlock()
+		if warned {
+			continue
+		}
+
+		message := "Global and project download directories overlap, please set different directories in BlenderKit preferences"
+		BKLog.Printf("%s %s: %s and %s", EmoWarning, message, overlap.Kept, overlap.Dropped)
+		task := NewTask(nil, appID, uuid.New().String(), "download_dirs_overlap")
+		task.Message = message
+		task.MessageDetailed = fmt.Sprintf("%s and %s are the same directory or one is inside the other, assets are downloaded only to %s", overlap.Kept, overlap.Dropped, overlap.Kept)
+		task.Status = "finished"
+		task.Result = map[string]string{"kept": overlap.Kept, "dropped": overlap.Dropped}
+		sendTask(AddTaskCh, task)
+	}
+}
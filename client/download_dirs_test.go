@@ -0,0 +1,94 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDownloadDirs(t *testing.T) {
+	root := t.TempDir()
+	global := filepath.Join(root, "blenderkit_data")
+	project := filepath.Join(root, "project", "assets")
+	for _, dir := range []string{global, project} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(root, "link_to_global")
+	if err := os.Symlink(global, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	models := filepath.Join(global, "models")
+
+	tests := []struct {
+		name     string
+		dirs     []string
+		kept     []string
+		overlaps []DirOverlap
+	}{
+		{"different dirs", []string{models, filepath.Join(project, "models")}, []string{models, filepath.Join(project, "models")}, nil},
+		{"same dir", []string{models, models}, []string{models}, []DirOverlap{{models, models}}},
+		{"same dir through symlink", []string{models, filepath.Join(link, "models")}, []string{models}, []DirOverlap{{models, filepath.Join(link, "models")}}},
+		{"nested dir", []string{models, filepath.Join(models, "models")}, []string{models}, []DirOverlap{{models, filepath.Join(models, "models")}}},
+		{"parent dir through symlink", []string{filepath.Join(link, "models", "models"), models}, []string{filepath.Join(link, "models", "models")}, []DirOverlap{{filepath.Join(link, "models", "models"), models}}},
+		{"sibling with common prefix", []string{models, models + "_old"}, []string{models, models + "_old"}, nil},
+	}
+	for _, test := range tests {
+		kept, overlaps := normalizeDownloadDirs(test.dirs)
+		if !reflect.DeepEqual(kept, test.kept) || !reflect.DeepEqual(overlaps, test.overlaps) {
+			t.Errorf("%s: normalizeDownloadDirs(%v) = %v, %v; want %v, %v", test.name, test.dirs, kept, overlaps, test.kept, test.overlaps)
+		}
+	}
+}
+
+func TestGetDownloadFilepathsWarnsAboutOverlap(t *testing.T) {
+	global := filepath.Join(t.TempDir(), "blenderkit_data")
+	if err := os.MkdirAll(global, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "project")
+	if err := os.Symlink(global, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	data := DownloadData{AppID: 670, DownloadDirs: []string{filepath.Join(global, "models"), filepath.Join(link, "models")}}
+	data.DownloadAssetData.ID = "d5368c9d-092e-4319-afe1-dd765de6da01"
+	data.DownloadAssetData.Name = "Chair"
+
+	for i := 0; i < 2; i++ {
+		paths, _, err := GetDownloadFilepaths(data, "chair_2K_d5368c9d.blend")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 1 || !isPathInside(filepath.Join(global, "models"), paths[0]) {
+			t.Errorf("GetDownloadFilepaths() = %v; want single path in the global dir", paths)
+		}
+	}
+	task := appTask(670, time.Second)
+	if task == nil || task.TaskType != "download_dirs_overlap" || task.Message == "" {
+		t.Fatalf("got task %+v; want download_dirs_overlap warning", task)
+	}
+	if task := appTask(670, 50*time.Millisecond); task != nil {
+		t.Errorf("overlap warned again with task %s", task.TaskType)
+	}
+}
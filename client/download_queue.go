@@ -0,0 +1,232 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priorities of the asset download, DownloadData.Priority.
+const (
+	DownloadPriorityInteractive = "interactive" // user is waiting for the asset, e.g. dragged it into the scene
+	DownloadPriorityBackground  = "background"  // e.g. resolution swap of assets already in the scene
+)
+
+// DownloadConcurrency is how many asset downloads of one add-on instance run at once, set by flag.
+// Further downloads wait in FIFO queue, so the first requested asset finishes first.
+// Interactive downloads are queued ahead of background ones and have one extra slot, which background downloads cannot use.
+var DownloadConcurrency = 2
+
+// BackgroundDownloadRateLimit is the speed in bytes per second to which background downloads slow down
+// while an interactive download of the same add-on instance is running, 0 disables the throttling. Changed by /settings/update.
+var BackgroundDownloadRateLimit = newAtomicInt64(512 * 1024)
+
+type queuedDownload struct {
+	task        *Task
+	interactive bool
+	ready       chan struct{} // closed when the download can start
+}
+
+// appDownloadQueue holds downloads of one add-on instance (AppID).
+type appDownloadQueue struct {
+	running map[string]bool // task IDs of running downloads, value is true for interactive ones
+	queued  []*queuedDownload
+}
+
+// DownloadQueueStatus is returned by /downloads/queue for each AppID.
+type DownloadQueueStatus struct {
+	Concurrency int      `json:"concurrency"`
+	Running     []string `json:"running"`
+	Queued      []string `json:"queued"` // in order in which they will start
+}
+
+var (
+	downloadQueues    = map[int]*appDownloadQueue{}
+	downloadQueuesMux sync.Mutex
+)
+
+// acquireDownloadSlot blocks until the download task may start. While waiting the task is in "queued" status
+// with its position in the message. Returns false if the task was cancelled while queued, then no slot is taken.
+// Each successful call must be followed by releaseDownloadSlot.
+func acquireDownloadSlot(task *Task, interactive bool) bool {
+	downloadQueuesMux.Lock()
+	queue := downloadQueues[task.AppID]
+	if queue == nil {
+		queue = &appDownloadQueue{running: map[string]bool{}}
+		downloadQueues[task.AppID] = queue
+	}
+	entry := &queuedDownload{task: task, interactive: interactive, ready: make(chan struct{})}
+	position := len(queue.queued)
+	if interactive { // ahead of all background downloads
+		for position > 0 && !queue.queued[position-1].interactive {
+			position--
+		}
+	}
+	queue.queued = append(queue.queued[:position], append([]*queuedDownload{entry}, queue.queued[position:]...)...)
+	startNextDownload(queue)
+	select {
+	case <-entry.ready:
+		downloadQueuesMux.Unlock()
+		return true
+	default:
+	}
+	downloadQueuesMux.Unlock()
+
+	select {
+	case <-entry.ready:
+		setDownloadQueueStatus(task, "created", "Getting download URL")
+		return true
+	case <-task.Ctx.Done():
+	}
+
+	downloadQueuesMux.Lock()
+	defer downloadQueuesMux.Unlock()
+	select {
+	case <-entry.ready: // got the slot at the same time as it was cancelled
+		delete(queue.running, task.TaskID)
+		startNextDownload(queue)
+	default:
+		for i, e := range queue.queued {
+			if e == entry {
+				queue.queued = append(queue.queued[:i], queue.queued[i+1:]...)
+				break
+			}
+		}
+		updateQueuePositions(queue)
+	}
+	return false
+}
+
+// releaseDownloadSlot frees the slot of finished download and starts the next queued one.
+func releaseDownloadSlot(task *Task) {
+	downloadQueuesMux.Lock()
+	defer downloadQueuesMux.Unlock()
+	queue := downloadQueues[task.AppID]
+	if queue == nil {
+		return
+	}
+	delete(queue.running, task.TaskID)
+	startNextDownload(queue)
+	if len(queue.running) == 0 && len(queue.queued) == 0 {
+		delete(downloadQueues, task.AppID)
+	}
+}
+
+// setDownloadConcurrency changes DownloadConcurrency at runtime, queued downloads start at once if the limit was raised.
+func setDownloadConcurrency(concurrency int) {
+	downloadQueuesMux.Lock()
+	defer downloadQueuesMux.Unlock()
+	DownloadConcurrency = concurrency
+	for _, queue := range downloadQueues {
+		startNextDownload(queue)
+	}
+}
+
+// startNextDownload starts queued downloads while there are free slots, must be called with downloadQueuesMux locked.
+// Status messages of the downloads which stay queued are updated with their positions.
+func startNextDownload(queue *appDownloadQueue) {
+	for len(queue.queued) > 0 {
+		next := queue.queued[0]
+		limit := DownloadConcurrency
+		if next.interactive {
+			limit++ // slot reserved for interactive downloads
+		}
+		if len(queue.running) >= limit {
+			break
+		}
+		queue.queued = queue.queued[1:]
+		queue.running[next.task.TaskID] = next.interactive
+		close(next.ready)
+	}
+	updateQueuePositions(queue)
+}
+
+// updateQueuePositions refreshes the position in the message of queued tasks, must be called with downloadQueuesMux locked.
+func updateQueuePositions(queue *appDownloadQueue) {
+	for i, e := range queue.queued {
+		message := fmt.Sprintf("Waiting in download queue, position %d", i+1)
+		TasksMux.Lock()
+		unchanged := e.task.Status == "queued" && e.task.Message == message
+		TasksMux.Unlock()
+		if !unchanged {
+			setDownloadQueueStatus(e.task, "queued", message)
+		}
+	}
+}
+
+// interactiveDownloadRunning reports whether an interactive download of the add-on instance is running.
+func interactiveDownloadRunning(appID int) bool {
+	downloadQueuesMux.Lock()
+	defer downloadQueuesMux.Unlock()
+	if queue := downloadQueues[appID]; queue != nil {
+		for _, interactive := range queue.running {
+			if interactive {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// throttleBackgroundDownload slows down background download after reading n bytes,
+// if an interactive download of the same add-on instance is running. Returns early when ctx is done.
+func throttleBackgroundDownload(ctx context.Context, appID, n int) {
+	rateLimit := BackgroundDownloadRateLimit.Load()
+	if rateLimit <= 0 || !interactiveDownloadRunning(appID) {
+		return
+	}
+	select {
+	case <-time.After(time.Duration(n) * time.Second / time.Duration(rateLimit)):
+	case <-ctx.Done():
+	}
+}
+
+// setDownloadQueueStatus is set directly, not through channels, so it cannot be reordered with the start of the download.
+func setDownloadQueueStatus(task *Task, status, message string) {
+	TasksMux.Lock()
+	if task.Status != "cancelled" {
+		task.Status = status
+		task.Message = message
+	}
+	TasksMux.Unlock()
+	TaskJournal.SetStatus(task.TaskID, status)
+	ChanLog.Printf("%s %s (%s): %s\n", EmoInfo, task.TaskType, task.LogID(), message)
+}
+
+// DownloadQueueHandler reports running and queued downloads of each add-on instance, for debugging.
+func DownloadQueueHandler(w http.ResponseWriter, r *http.Request) {
+	downloadQueuesMux.Lock()
+	status := map[string]DownloadQueueStatus{}
+	for appID, queue := range downloadQueues {
+		s := DownloadQueueStatus{Concurrency: DownloadConcurrency, Running: []string{}, Queued: []string{}}
+		for taskID := range queue.running {
+			s.Running = append(s.Running, taskID)
+		}
+		for _, e := range queue.queued {
+			s.Queued = append(s.Queued, e.task.TaskID)
+		}
+		status[fmt.Sprint(appID)] = s
+	}
+	downloadQueuesMux.Unlock()
+	writeJSON(w, status)
+}
@@ -0,0 +1,165 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadQueue(t *testing.T) {
+	defer func(n int) { DownloadConcurrency = n }(DownloadConcurrency)
+	DownloadConcurrency = 1
+	const appID = 4242
+	tasks := []*Task{
+		NewTask(nil, appID, "first", "asset_download"),
+		NewTask(nil, appID, "second", "asset_download"),
+		NewTask(nil, appID, "third", "asset_download"),
+	}
+	message := func(task *Task) string {
+		TasksMux.Lock()
+		defer TasksMux.Unlock()
+		return task.Status + ": " + task.Message
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for queue state")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if !acquireDownloadSlot(tasks[0], false) {
+		t.Fatal("first download did not start right away")
+	}
+	results := make(chan string, 2)
+	for _, task := range tasks[1:] {
+		go func(task *Task) {
+			if acquireDownloadSlot(task, false) {
+				results <- task.TaskID + " started"
+			} else {
+				results <- task.TaskID + " cancelled"
+			}
+		}(task)
+		waitFor(func() bool { return message(task) != "created: " })
+	}
+	if got := message(tasks[2]); got != "queued: Waiting in download queue, position 2" {
+		t.Errorf("third task = %q; want position 2", got)
+	}
+
+	rec := httptest.NewRecorder()
+	DownloadQueueHandler(rec, httptest.NewRequest(http.MethodGet, "/downloads/queue", nil))
+	var status map[string]DownloadQueueStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if s := status["4242"]; len(s.Running) != 1 || s.Running[0] != "first" || len(s.Queued) != 2 || s.Queued[0] != "second" {
+		t.Errorf("queue status = %+v; want first running, second and third queued", s)
+	}
+
+	tasks[1].Cancel()
+	if got := <-results; got != "second cancelled" {
+		t.Errorf("got %q; want second cancelled", got)
+	}
+	if got := message(tasks[2]); got != "queued: Waiting in download queue, position 1" {
+		t.Errorf("third task after cancel = %q; want position 1", got)
+	}
+
+	releaseDownloadSlot(tasks[0])
+	if got := <-results; got != "third started" {
+		t.Errorf("got %q; want third started", got)
+	}
+	if got := message(tasks[2]); got != "created: Getting download URL" {
+		t.Errorf("third task after start = %q", got)
+	}
+	releaseDownloadSlot(tasks[2])
+	downloadQueuesMux.Lock()
+	defer downloadQueuesMux.Unlock()
+	if _, exists := downloadQueues[appID]; exists {
+		t.Errorf("empty queue was not removed")
+	}
+}
+
+func TestDownloadQueuePriority(t *testing.T) {
+	defer func(n int) { DownloadConcurrency = n }(DownloadConcurrency)
+	DownloadConcurrency = 1
+	const appID = 4243
+	started := make(chan string, 10)
+	acquire := func(taskID string, interactive bool) *Task {
+		task := NewTask(nil, appID, taskID, "asset_download")
+		go func() {
+			if acquireDownloadSlot(task, interactive) {
+				started <- taskID
+			}
+		}()
+		return task
+	}
+	expectStarted := func(want string) {
+		select {
+		case got := <-started:
+			if got != want {
+				t.Errorf("started %s; want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not start", want)
+		}
+	}
+	expectNothingStarted := func() {
+		select {
+		case got := <-started:
+			t.Errorf("%s started; want it queued", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	background1 := acquire("background-1", false)
+	expectStarted("background-1")
+	acquire("background-2", false)
+	expectNothingStarted()
+	if interactiveDownloadRunning(appID) {
+		t.Errorf("interactive download reported while only background ones run")
+	}
+
+	interactive1 := acquire("interactive-1", true) // uses the reserved slot
+	expectStarted("interactive-1")
+	if !interactiveDownloadRunning(appID) {
+		t.Errorf("interactive download not reported")
+	}
+	acquire("interactive-2", true) // no free slot, but goes ahead of background-2
+	expectNothingStarted()
+
+	releaseDownloadSlot(background1)
+	expectStarted("interactive-2")
+	releaseDownloadSlot(interactive1)
+	expectNothingStarted() // interactive-2 is running, background-2 cannot use the reserved slot
+	downloadQueuesMux.Lock()
+	queue := downloadQueues[appID]
+	running, queued := len(queue.running), len(queue.queued)
+	downloadQueuesMux.Unlock()
+	if running != 1 || queued != 1 {
+		t.Errorf("running %d, queued %d; want 1 and 1", running, queued)
+	}
+
+	releaseDownloadSlot(NewTask(nil, appID, "interactive-2", "asset_download"))
+	expectStarted("background-2")
+	releaseDownloadSlot(NewTask(nil, appID, "background-2", "asset_download"))
+}
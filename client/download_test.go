@@ -0,0 +1,339 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadFilepath(t *testing.T) {
+	const (
+		assetID  = "0992088b-fb84-4c69-bb6e-426272970c8b"
+		server   = "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+		fileTail = "2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+	)
+	longName := "Very Detailed Victorian Wooden Chair With Carved Ornaments And Cushion"
+	dir := func(length int) string { return "/" + strings.Repeat("d", length-1) }
+
+	tests := []struct {
+		name      string
+		dir       string
+		assetName string
+		limit     int
+		shortened bool
+		ok        bool
+		expected  string // empty means only the generic checks are done
+	}{
+		{"no limit", dir(200), longName, 0, false, true, ""},
+		{"short path", "/projects", "Kitten", WindowsPathLimit, false, true,
+			filepath.Join("/projects", "kitten_"+assetID, "kitten_"+fileTail)},
+		{"truncated slug", dir(120), longName, WindowsPathLimit, true, true, ""},
+		{"hash instead of slug", dir(160), longName, WindowsPathLimit, true, true,
+			filepath.Join(dir(160), assetID, "3186f9_"+fileTail)},
+		{"too long even when shortened", dir(250), longName, WindowsPathLimit, false, false, ""},
+	}
+	for _, test := range tests {
+		actual, shortened, ok := downloadFilepath(test.dir, test.assetName, assetID, server, test.limit)
+		if ok != test.ok || shortened != test.shortened {
+			t.Errorf("%s: downloadFilepath() shortened=%t ok=%t; want shortened=%t ok=%t", test.name, shortened, ok, test.shortened, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if test.expected != "" && actual != test.expected {
+			t.Errorf("%s: downloadFilepath() = %q; want %q", test.name, actual, test.expected)
+		}
+		if test.limit > 0 && len(actual) >= test.limit {
+			t.Errorf("%s: path has %d characters; want less than %d", test.name, len(actual), test.limit)
+		}
+		if !strings.HasSuffix(actual, fileTail) || !strings.Contains(actual, assetID) {
+			t.Errorf("%s: path %q lost resolution or IDs", test.name, actual)
+		}
+		again, _, _ := downloadFilepath(test.dir, test.assetName, assetID, server, test.limit)
+		if again != actual {
+			t.Errorf("%s: downloadFilepath() is not deterministic: %q != %q", test.name, again, actual)
+		}
+	}
+}
+
+func TestGetDownloadURLWrapper(t *testing.T) {
+	const fileName = "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download/anonymous":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"detail": "User is anonymous"}`)
+		case "/download/ok":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"filePath": "%s/files/%s"}`, server.URL, fileName)
+		case "/files/" + fileName: // presigned URLs refuse HEAD requests
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(api, downloads *http.Client) { ClientAPI, ClientDownloads = api, downloads }(ClientAPI, ClientDownloads)
+	ClientAPI, ClientDownloads = server.Client(), server.Client()
+
+	tests := []struct {
+		name string
+		path string
+		want DownloadURLResponse
+	}{
+		{"anonymous", "/download/anonymous", DownloadURLResponse{CanDownload: false, Reason: "User is anonymous", ErrorCode: ErrCodeLoginRequired, Resolution: "resolution_2K"}},
+		{"ok", "/download/ok", DownloadURLResponse{CanDownload: true, DownloadURL: server.URL + "/files/" + fileName, Filename: fileName, FileSize: 1234, Resolution: "resolution_2K"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"app_id": 1234, "asset_data": {"files": [{"fileType": "blend", "downloadUrl": "%[1]s/none", "fileSize": 5678}, {"fileType": "resolution_2K", "downloadUrl": "%[1]s%[2]s", "fileSize": 1234}]},
+				"PREFS": {"scene_id": "0992088b-fb84-4c69-bb6e-426272970c8b", "resolution": "resolution_2K"}}`, server.URL, tt.path)
+			req := httptest.NewRequest(http.MethodPost, "/wrappers/get_download_url", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			GetDownloadURLWrapper(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d; want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+			var got DownloadURLResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("response = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Error responses of the download endpoint. These are assumptions, not captured 403 bodies: only "Invalid token."
+// is a detail known from the add-on, which matches it in timer.py. Most fixtures are DRF style string details,
+// classified by the whole words of the message, which is the path the Client relies on. The typed ones assume
+// an object with messages and type, which wins over the message when the server sends it.
+var downloadDenialFixtures = map[string]struct {
+	status int
+	body   string
+}{
+	"anonymous":     {http.StatusForbidden, `{"detail": "User is anonymous"}`},
+	"invalid_token": {http.StatusUnauthorized, `{"detail": "Invalid token."}`},
+	"plan_message":  {http.StatusForbidden, `{"detail": "This asset is available only with Full Plan."}`},
+	"private":       {http.StatusForbidden, `{"detail": "You do not have permission to download this private asset."}`},
+	"limit":         {http.StatusForbidden, `{"detail": "Daily download limit reached."}`},
+	"explanation":   {http.StatusForbidden, `{"detail": "Download denied, see the explanation in your profile."}`},
+	"server_error":  {http.StatusInternalServerError, `{"detail": "Internal server error"}`},
+
+	// Assumed typed shape.
+	"typed_plan":    {http.StatusForbidden, `{"detail": {"messages": ["This asset is available only with Full Plan."], "type": "plan_required"}}`},
+	"typed_private": {http.StatusForbidden, `{"messages": ["Upgrade your plan to download private assets."], "type": "private_asset"}`},
+}
+
+func TestGetDownloadURLDenied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := downloadDenialFixtures[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(fixture.status)
+		fmt.Fprint(w, fixture.body)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+
+	tests := []struct {
+		fixture     string
+		wantCode    string
+		wantMessage string
+	}{
+		{"anonymous", ErrCodeLoginRequired, "User is anonymous"},
+		{"invalid_token", ErrCodeLoginRequired, "Invalid token."},
+		{"plan_message", ErrCodePlanRequired, "This asset is available only with Full Plan."},
+		{"private", ErrCodePrivateAsset, "You do not have permission to download this private asset."},
+		{"limit", ErrCodeDownloadDenied, "Daily download limit reached."},
+		{"explanation", ErrCodeDownloadDenied, "Download denied, see the explanation in your profile."},
+		{"server_error", "", "Internal server error"},
+		{"typed_plan", ErrCodePlanRequired, "This asset is available only with Full Plan."},
+		{"typed_private", ErrCodePrivateAsset, "Upgrade your plan to download private assets."},
+	}
+	for _, test := range tests {
+		data := DownloadData{
+			DownloadAssetData: DownloadAssetData{Files: []AssetFile{{FileType: "blend", DownloadURL: server.URL + "/download/" + test.fixture}}},
+			PREFS:             PREFS{Resolution: "blend"},
+		}
+		_, _, err := GetDownloadURL(data)
+		var urlErr *DownloadURLError
+		if !errors.As(err, &urlErr) {
+			t.Errorf("%s: error = %v; want *DownloadURLError", test.fixture, err)
+			continue
+		}
+		if urlErr.Code != test.wantCode || urlErr.Message != test.wantMessage {
+			t.Errorf("%s: code %q, message %q; want %q, %q", test.fixture, urlErr.Code, urlErr.Message, test.wantCode, test.wantMessage)
+		}
+		if string(urlErr.Payload) != downloadDenialFixtures[test.fixture].body {
+			t.Errorf("%s: payload = %s; want the server response", test.fixture, urlErr.Payload)
+		}
+	}
+}
+
+func TestDownloadSingleDirLayout(t *testing.T) {
+	const fileName = "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+	content := "BLENDER-v300" + strings.Repeat("0123456789", 200)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			fmt.Fprintf(w, `{"filePath": "%s/files/%s"}`, server.URL, fileName)
+		case "/files/" + fileName:
+			fmt.Fprint(w, content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(api, downloads *http.Client) { ClientAPI, ClientDownloads = api, downloads }(ClientAPI, ClientDownloads)
+	ClientAPI, ClientDownloads = server.Client(), server.Client()
+
+	project := t.TempDir()
+	data := DownloadData{
+		AppID:        1,
+		DownloadDirs: []string{filepath.Join(project, "addons", "blenderkit_assets")},
+		Layout:       DownloadLayoutSingleDir,
+		AssetsPath:   project,
+		DownloadAssetData: DownloadAssetData{
+			Name:  "Kitten",
+			ID:    "0992088b-fb84-4c69-bb6e-426272970c8b",
+			Files: []AssetFile{{FileType: "resolution_2K", DownloadURL: server.URL + "/download"}},
+		},
+		PREFS: PREFS{Resolution: "resolution_2K", UnpackFiles: true, BinaryPath: "/nonexistent/blender"},
+	}
+	if err := validateDownloadData(data); err != nil {
+		t.Fatalf("validateDownloadData: %v", err)
+	}
+	task := NewTask(nil, data.AppID, "single-dir-task", "asset_download")
+	filePaths, _, taskErr := downloadAssetFiles(task, data)
+	if taskErr != nil {
+		t.Fatalf("downloadAssetFiles: %v", taskErr.Error)
+	}
+	want := filepath.Join(project, "addons", "blenderkit_assets", "kitten", "kitten_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend")
+	if len(filePaths) != 1 || filePaths[0] != want {
+		t.Fatalf("file paths = %v; want [%s]", filePaths, want)
+	}
+	rel, err := assetsRelativePath(data.AssetsPath, filePaths[0])
+	if err != nil || rel != "addons/blenderkit_assets/kitten/kitten_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend" {
+		t.Errorf("relative path = %q, %v; want addons/blenderkit_assets/kitten/...", rel, err)
+	}
+	TaskUpdates.take()
+}
+
+func TestDownloadReportsSpeedAndETA(t *testing.T) {
+	const appID, chunk, chunks = 6571, 10 * 1024, 100
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(chunk*chunks))
+		data := []byte(strings.Repeat("x", chunk))
+		for i := 0; i < chunks; i++ {
+			w.Write(data)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release: // the rest at once
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+	ClientDownloads = server.Client()
+
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{"download": NewTask(nil, appID, "download", "asset_download")}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, appID)
+		TasksMux.Unlock()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- downloadAsset(server.URL, filepath.Join(t.TempDir(), "asset.blend"), DownloadData{AppID: appID}, "download", context.Background())
+	}()
+
+	// the add-on reads the new fields from /report of the download in flight
+	var reported map[string]interface{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		applyTaskUpdates()
+		req := httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(`{"app_id": 6571, "addon_version": "3.12.0"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		reportHandler(rec, req)
+		var tasks []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("decoding report: %v, body: %s", err, rec.Body.String())
+		}
+		for _, task := range tasks {
+			if task["task_id"] == "download" && task["speed_bps"] != nil && task["eta_s"] != nil {
+				reported = task
+			}
+		}
+		if reported != nil {
+			break
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if reported == nil {
+		t.Fatal("report of the download in flight has no speed_bps and eta_s")
+	}
+	if speed := reported["speed_bps"].(float64); speed <= 0 {
+		t.Errorf("speed_bps = %v; want positive", speed)
+	}
+	if eta := reported["eta_s"].(float64); eta < 1 {
+		t.Errorf("eta_s = %v; want at least 1 second left", eta)
+	}
+}
+
+func TestGetDownloadFilepathsUnwritableDir(t *testing.T) {
+	data := DownloadData{DownloadDirs: []string{t.TempDir(), unwritableDir(t)}, DownloadAssetData: DownloadAssetData{Name: "Wooden Chair", ID: "d5368c9d-092e-4319-afe1-dd765de6da01"}}
+	filePaths, _, err := GetDownloadFilepaths(data, "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend")
+	if err == nil || !strings.Contains(err.Error(), "creating asset directory") {
+		t.Errorf("GetDownloadFilepaths() = %v, %v; want error creating asset directory", filePaths, err)
+	}
+
+	data.DownloadDirs = nil
+	if _, _, err := GetDownloadFilepaths(data, "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"); err == nil {
+		t.Error("GetDownloadFilepaths() without download dirs succeeded")
+	}
+}
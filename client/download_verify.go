@@ -0,0 +1,329 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Issues found by /downloads/verify between the asset directories.
+const (
+	VerifyIssueMissing            = "missing"             // file is in the other dir, but not in this one
+	VerifyIssueSizeMismatch       = "size_mismatch"       // file differs in size between the dirs or from the server
+	VerifyIssueOrphanedResolution = "orphaned_resolution" // asset file of other than the requested resolution
+)
+
+// AssetFileDiff is one difference found in the asset directories.
+type AssetFileDiff struct {
+	Path         string `json:"path"` // relative to the asset directory
+	Dir          string `json:"dir"`  // asset directory in which the issue is
+	Issue        string `json:"issue"`
+	Size         int64  `json:"size"`                    // size in Dir, 0 if missing
+	ExpectedSize int64  `json:"expected_size,omitempty"` // size in the other dir or on the server
+}
+
+// DownloadVerifyResult is the result of downloads/verify task.
+type DownloadVerifyResult struct {
+	Resolution string          `json:"resolution"`
+	Filename   string          `json:"filename"`   // local name of the asset file of the resolution
+	AssetDirs  []string        `json:"asset_dirs"` // directories of the asset in the download dirs, usually project and global
+	Diff       []AssetFileDiff `json:"diff"`
+	InSync     bool            `json:"in_sync"`  // no missing files or size mismatches, orphaned resolutions are fine
+	Repaired   []string        `json:"repaired"` // what was done by repair, e.g. "copied textures/wood.png to /project/assets/..."
+}
+
+// DownloadVerifyData is DownloadData of /download_asset with the repair flag.
+type DownloadVerifyData struct {
+	DownloadData
+	Repair bool `json:"repair"`
+}
+
+// DownloadVerifyHandler compares the asset directories in the download dirs and optionally repairs them.
+func DownloadVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var data DownloadVerifyData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateDownloadData(data.DownloadData); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	taskID := uuid.New().String()
+	go runTask(data.AppID, taskID, func() { VerifyAssetDownload(data, taskID) })
+	writeJSON(w, map[string]string{"task_id": taskID})
+}
+
+// VerifyAssetDownload compares the asset directories from GetDownloadFilepaths. With Repair the file of the requested
+// resolution is copied from the directory where it is complete, mismatched copies are deleted if none is complete,
+// so the next download fetches it again. Orphaned resolutions are only reported.
+func VerifyAssetDownload(data DownloadVerifyData, taskID string) {
+	if data.RequestID == "" {
+		data.RequestID = taskID
+	}
+	task := NewTask(data, data.AppID, taskID, "downloads/verify").WithRequestID(data.RequestID)
+	task.Message = "Getting download URL"
+	TasksMux.Lock()
+	Tasks[task.AppID][taskID] = task
+	TasksMux.Unlock()
+
+	_, downloadURL, err := GetDownloadURL(data.DownloadData)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("verify download: %w", err)})
+		return
+	}
+	fileName, err := ExtractFilenameFromURL(downloadURL)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+		return
+	}
+	fileName = localAssetFilename(fileName)
+	filePaths, _, err := GetDownloadFilepaths(data.DownloadData, fileName)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("verify download: %w", err)})
+		return
+	}
+	_, resolution := GetResolutionFile(data.Files, data.PREFS.Resolution)
+	result := DownloadVerifyResult{Resolution: resolution, Filename: filepath.Base(filePaths[0]), Diff: []AssetFileDiff{}, Repaired: []string{}}
+	for _, filePath := range filePaths {
+		result.AssetDirs = append(result.AssetDirs, filepath.Dir(filePath))
+	}
+
+	sendTaskProgress(&TaskProgressUpdate{AppID: data.AppID, TaskID: taskID, Progress: 30, Message: "Comparing asset directories"})
+	result.Diff, err = diffAssetDirs(result.AssetDirs, result.Filename, GetDownloadFileSize(downloadURL))
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("verify download: %w", err)})
+		return
+	}
+	result.InSync = assetDirsInSync(result.Diff)
+
+	if data.Repair && !result.InSync {
+		sendTaskProgress(&TaskProgressUpdate{AppID: data.AppID, TaskID: taskID, Progress: 60, Message: "Repairing asset directories"})
+		result.Repaired, err = repairAssetDirs(result.AssetDirs, result.Filename, result.Diff)
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("repair download: %w", err), Result: result})
+			return
+		}
+		result.Diff, err = diffAssetDirs(result.AssetDirs, result.Filename, GetDownloadFileSize(downloadURL))
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("verify download: %w", err)})
+			return
+		}
+		result.InSync = assetDirsInSync(result.Diff)
+	}
+
+	message := "Asset directories are in sync"
+	if !result.InSync {
+		message = fmt.Sprintf("Asset directories differ in %d files", len(result.Diff))
+	} else if len(result.Repaired) > 0 {
+		message = fmt.Sprintf("Asset directories repaired, %d files fixed", len(result.Repaired))
+	}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: message, Result: result})
+}
+
+// listAssetDir returns sizes of all files in the dir by their slash separated relative paths, empty if dir does not exist.
+func listAssetDir(dir string) (map[string]int64, error) {
+	files := map[string]int64{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".part") { // unfinished download is not part of the asset
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// diffAssetDirs compares the asset dirs. Asset file assetFilename is expected in all of them with expectedSize (0 if unknown),
+// other .blend files in the top level are orphaned resolutions. Other files should match the dir with complete asset file.
+func diffAssetDirs(dirs []string, assetFilename string, expectedSize int64) ([]AssetFileDiff, error) {
+	listings := make([]map[string]int64, len(dirs))
+	all := map[string]bool{assetFilename: true}
+	for i, dir := range dirs {
+		files, err := listAssetDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		listings[i] = files
+		for path := range files {
+			all[path] = true
+		}
+	}
+	authority := 0 // dir with complete asset file, its other files are taken as the correct ones
+	for i := range dirs {
+		if size, ok := listings[i][assetFilename]; ok && (expectedSize <= 0 || size == expectedSize) {
+			authority = i
+			break
+		}
+	}
+
+	paths := make([]string, 0, len(all))
+	for path := range all {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	diff := []AssetFileDiff{}
+	for _, path := range paths {
+		if path != assetFilename && isOrphanedResolution(path) {
+			for i, dir := range dirs {
+				if size, ok := listings[i][path]; ok {
+					diff = append(diff, AssetFileDiff{Path: path, Dir: dir, Issue: VerifyIssueOrphanedResolution, Size: size})
+				}
+			}
+			continue
+		}
+
+		reference, ok := listings[authority][path] // size the file should have
+		if path == assetFilename && expectedSize > 0 {
+			reference, ok = expectedSize, true
+		}
+		for i := 0; !ok && i < len(dirs); i++ {
+			reference, ok = listings[i][path]
+		}
+		for i, dir := range dirs {
+			size, ok := listings[i][path]
+			switch {
+			case !ok:
+				diff = append(diff, AssetFileDiff{Path: path, Dir: dir, Issue: VerifyIssueMissing, ExpectedSize: reference})
+			case size != reference:
+				diff = append(diff, AssetFileDiff{Path: path, Dir: dir, Issue: VerifyIssueSizeMismatch, Size: size, ExpectedSize: reference})
+			}
+		}
+	}
+	return diff, nil
+}
+
+func isOrphanedResolution(path string) bool {
+	return !strings.Contains(path, "/") && strings.HasSuffix(path, ".blend")
+}
+
+func assetDirsInSync(diff []AssetFileDiff) bool {
+	for _, d := range diff {
+		if d.Issue != VerifyIssueOrphanedResolution {
+			return false
+		}
+	}
+	return true
+}
+
+// repairAssetDirs copies the missing and mismatched files from a dir in which they are complete.
+// Mismatched files without complete copy are deleted, missing asset file is then downloaded by the next download.
+func repairAssetDirs(dirs []string, assetFilename string, diff []AssetFileDiff) ([]string, error) {
+	broken := map[string]map[string]bool{} // path -> dirs where it is missing or mismatched
+	for _, d := range diff {
+		if d.Issue == VerifyIssueOrphanedResolution {
+			continue
+		}
+		if broken[d.Path] == nil {
+			broken[d.Path] = map[string]bool{}
+		}
+		broken[d.Path][d.Dir] = true
+	}
+
+	repaired := []string{}
+	for path, brokenDirs := range broken {
+		source := ""
+		for _, dir := range dirs {
+			if !brokenDirs[dir] {
+				source = dir
+				break
+			}
+		}
+		for _, dir := range dirs {
+			if !brokenDirs[dir] {
+				continue
+			}
+			target := filepath.Join(dir, filepath.FromSlash(path))
+			if source == "" { // no complete copy, the next download fetches the file again
+				if err := os.Remove(target); err != nil {
+					if os.IsNotExist(err) {
+						continue
+					}
+					return repaired, err
+				}
+				repaired = append(repaired, fmt.Sprintf("deleted incomplete %s", target))
+				continue
+			}
+			if err := copyAssetFile(filepath.Join(source, filepath.FromSlash(path)), target); err != nil {
+				return repaired, err
+			}
+			repaired = append(repaired, fmt.Sprintf("copied %s from %s", target, source))
+		}
+	}
+	sort.Strings(repaired)
+	return repaired, nil
+}
+
+// syncDirs copies files of the asset which are missing or differ in size from src to dst asset directory,
+// except the .blend files of other resolutions than assetFilename. Empty assetFilename skips all .blend files.
+func syncDirs(src, dst, assetFilename string) error {
+	srcFiles, err := listAssetDir(src)
+	if err != nil {
+		return err
+	}
+	dstFiles, err := listAssetDir(dst)
+	if err != nil {
+		return err
+	}
+	for path, size := range srcFiles {
+		if path != assetFilename && isOrphanedResolution(path) {
+			continue
+		}
+		if dstSize, ok := dstFiles[path]; ok && dstSize == size {
+			continue
+		}
+		if err := copyAssetFile(filepath.Join(src, filepath.FromSlash(path)), filepath.Join(dst, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAssetFile copies through saveToFile(), so interrupted copy never looks like a complete asset file.
+func copyAssetFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return saveToFile(dst, in)
+}
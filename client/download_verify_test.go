@@ -0,0 +1,117 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyAssetDownload(t *testing.T) {
+	const (
+		appID     = 610
+		assetID   = "d5368c9d-092e-4319-afe1-dd765de6da01"
+		blend2K   = "resolution_2K_0992088b-fb84-4c69-bb6e-426272970c8b.blend"
+		content2K = "2K blend file"
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download/2K":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"filePath": "%s/files/%s"}`, server.URL, blend2K)
+		case "/files/" + blend2K:
+			w.Header().Set("Content-Length", fmt.Sprint(len(content2K)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(api, downloads *http.Client) { ClientAPI, ClientDownloads = api, downloads }(ClientAPI, ClientDownloads)
+	ClientAPI, ClientDownloads = server.Client(), server.Client()
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{}
+	TasksMux.Unlock()
+
+	projectDir, globalDir := t.TempDir(), t.TempDir()
+	data := DownloadVerifyData{DownloadData: DownloadData{
+		AppID:             appID,
+		DownloadDirs:      []string{projectDir, globalDir},
+		DownloadAssetData: DownloadAssetData{Name: "Wooden Chair", ID: assetID, Files: []AssetFile{{FileType: "resolution_2K", DownloadURL: server.URL + "/download/2K"}}},
+		PREFS:             PREFS{Resolution: "resolution_2K"},
+	}}
+	fileName, _ := ExtractFilenameFromURL(server.URL + "/files/" + blend2K)
+	filePaths, _, _ := GetDownloadFilepaths(data.DownloadData, fileName)
+	projectAsset, globalAsset := filepath.Dir(filePaths[0]), filepath.Dir(filePaths[1])
+	write := func(path, content string) {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// project has 1K resolution and its textures, global has the requested 2K
+	write(filepath.Join(projectAsset, "wooden-chair_1K_0992088b-fb84-4c69-bb6e-426272970c8c.blend"), "1K blend")
+	write(filepath.Join(projectAsset, "textures", "wood.png"), "1K")
+	write(filepath.Join(globalAsset, filepath.Base(filePaths[1])), content2K)
+	write(filepath.Join(globalAsset, "textures", "wood.png"), "2K png")
+
+	run := func(taskID string) DownloadVerifyResult {
+		go VerifyAssetDownload(data, taskID)
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case f := <-TaskFinishCh:
+				if f.TaskID == taskID {
+					return f.Result.(DownloadVerifyResult)
+				}
+			case e := <-TaskErrorCh:
+				t.Fatalf("verify failed: %v", e.Error)
+			case <-timeout:
+				t.Fatal("verify not finished")
+			}
+		}
+	}
+
+	result := run("verify")
+	want := []AssetFileDiff{
+		{Path: "textures/wood.png", Dir: projectAsset, Issue: VerifyIssueSizeMismatch, Size: 2, ExpectedSize: 6},
+		{Path: "wooden-chair_1K_0992088b-fb84-4c69-bb6e-426272970c8c.blend", Dir: projectAsset, Issue: VerifyIssueOrphanedResolution, Size: 8},
+		{Path: filepath.Base(filePaths[0]), Dir: projectAsset, Issue: VerifyIssueMissing, ExpectedSize: int64(len(content2K))},
+	}
+	if result.InSync || fmt.Sprint(result.Diff) != fmt.Sprint(want) {
+		t.Errorf("diff = %+v, in sync %t\nwant %+v", result.Diff, result.InSync, want)
+	}
+
+	data.Repair = true
+	result = run("repair")
+	if !result.InSync || len(result.Repaired) != 2 {
+		t.Errorf("after repair: in sync %t, repaired %v, diff %+v; want in sync after 2 copies", result.InSync, result.Repaired, result.Diff)
+	}
+	if texture, _ := os.ReadFile(filepath.Join(projectAsset, "textures", "wood.png")); string(texture) != "2K png" {
+		t.Errorf("project texture = %q; want copied from global dir", texture)
+	}
+	if asset, _ := os.ReadFile(filePaths[0]); string(asset) != content2K {
+		t.Errorf("project asset file = %q; want copied from global dir", asset)
+	}
+}
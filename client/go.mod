@@ -3,12 +3,18 @@ module github.com/blenderkit/blenderkit/client
 go 1.22
 
 require (
+	github.com/dop251/goja v0.0.0-20241024094426-79f3a7efcdbd
 	github.com/google/uuid v1.6.0
 	github.com/gookit/color v1.5.4
+	github.com/klauspost/compress v1.17.11
 	github.com/rapid7/go-get-proxied v0.0.0-20240311092404-798791728c56
 )
 
 require (
+	github.com/dlclark/regexp2 v1.11.4 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	golang.org/x/sys v0.14.0 // indirect
+	golang.org/x/text v0.3.8 // indirect
 )
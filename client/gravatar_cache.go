@@ -0,0 +1,272 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pruning of author avatars downloaded into gravatar_dirname in GetSafeTempPath().
+var (
+	GravatarCacheCap           = newAtomicInt64(100 * 1024 * 1024) // Max total size of the avatars, set by flag and /settings/update
+	GravatarCacheMinAge        = 24 * time.Hour                    // Newer avatars are never pruned, they are likely still shown
+	GravatarMaxUnseen          = 90 * 24 * time.Hour               // Avatars of authors not seen in search results for longer are deleted
+	GravatarCachePruneInterval = 24 * time.Hour
+
+	gravatarCacheMux sync.Mutex // Prevents pruning runs from overlapping
+)
+
+const authorsSeenFilename = "authors_seen.json" // when the authors were last seen, in GetSafeTempPath()
+
+// GravatarCacheStatus is the avatars part of /cache/status.
+type GravatarCacheStatus struct {
+	TotalSize int64 `json:"total_size"`
+	Cap       int64 `json:"cap"`
+	Files     int   `json:"files"`
+}
+
+// AuthorsSeenStore remembers when each author last appeared in search results, key is the author ID.
+// Persisted in a JSON file, so avatars of authors not seen for GravatarMaxUnseen can be deleted.
+type AuthorsSeenStore struct {
+	mux  sync.Mutex
+	path string
+	seen map[string]time.Time
+}
+
+var AuthorsSeen = &AuthorsSeenStore{seen: map[string]time.Time{}}
+
+// LoadAuthorsSeen reads the author cache from the safe temp path.
+func LoadAuthorsSeen() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return AuthorsSeen.load(filepath.Join(tempDir, authorsSeenFilename))
+}
+
+func (s *AuthorsSeenStore) load(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.path = path
+	s.seen = map[string]time.Time{}
+	seen, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(seen, &s.seen); err != nil {
+		s.seen = map[string]time.Time{}
+		return fmt.Errorf("corrupted author cache %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes the author cache, must be called with s.mux locked.
+func (s *AuthorsSeenStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	seen, err := json.Marshal(s.seen)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, seen, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// Record marks the authors as seen at now. The file is written only if some author was not seen for a day,
+// so repeated searches do not rewrite it.
+func (s *AuthorsSeenStore) Record(authorIDs []string, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	changed := false
+	for _, id := range authorIDs {
+		if id == "" {
+			continue
+		}
+		if last, ok := s.seen[id]; ok && now.Sub(last) < 24*time.Hour {
+			continue
+		}
+		s.seen[id] = now
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if err := s.save(); err != nil {
+		BKLog.Printf("%s Failed to save author cache: %v", EmoWarning, err)
+	}
+}
+
+// Unseen returns the authors not seen since now-maxUnseen. Unknown authors are recorded as seen now,
+// so avatars downloaded before the author cache existed get the whole period.
+func (s *AuthorsSeenStore) Unseen(authorIDs []string, maxUnseen time.Duration, now time.Time) map[string]bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	unseen := map[string]bool{}
+	changed := false
+	for _, id := range authorIDs {
+		last, ok := s.seen[id]
+		if !ok {
+			s.seen[id] = now
+			changed = true
+			continue
+		}
+		if now.Sub(last) > maxUnseen {
+			unseen[id] = true
+			delete(s.seen, id)
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			BKLog.Printf("%s Failed to save author cache: %v", EmoWarning, err)
+		}
+	}
+	return unseen
+}
+
+// searchAuthorIDs returns IDs of the authors of the search results.
+func searchAuthorIDs(searchResult SearchResults) []string {
+	ids := make([]string, 0, len(searchResult.Results))
+	for _, result := range searchResult.Results {
+		ids = append(ids, string(result.Author.ID))
+	}
+	return ids
+}
+
+// gravatarAuthorID returns the author ID from the avatar file name, which is {author ID}.jpg, empty for other files.
+func gravatarAuthorID(path string) string {
+	id, ok := strings.CutSuffix(filepath.Base(path), ".jpg")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// scanGravatarCache lists the avatars in dir, missing dir has no avatars.
+func scanGravatarCache(dir string) ([]thumbnailCacheFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []thumbnailCacheFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed in the meantime
+		}
+		files = append(files, thumbnailCacheFile{
+			path:     filepath.Join(dir, entry.Name()),
+			dir:      gravatar_dirname,
+			size:     info.Size(),
+			accessed: fileAccessTime(info),
+		})
+	}
+	return files, nil
+}
+
+func gravatarCacheStatus(dir string) (GravatarCacheStatus, error) {
+	status := GravatarCacheStatus{Cap: GravatarCacheCap.Load()}
+	files, err := scanGravatarCache(dir)
+	if err != nil {
+		return status, err
+	}
+	for _, f := range files {
+		status.TotalSize += f.size
+	}
+	status.Files = len(files)
+	return status, nil
+}
+
+// pruneGravatarCache deletes avatars of authors not seen for maxUnseen, then least recently accessed avatars
+// until the total size is under maxSize. Avatars accessed after now-minAge are never deleted.
+func pruneGravatarCache(dir string, maxSize int64, minAge, maxUnseen time.Duration, seen *AuthorsSeenStore, now time.Time) (ThumbnailCacheCleanResult, error) {
+	var result ThumbnailCacheCleanResult
+	files, err := scanGravatarCache(dir)
+	if err != nil {
+		return result, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		if id := gravatarAuthorID(f.path); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	unseen := seen.Unseen(ids, maxUnseen, now)
+
+	kept := files[:0]
+	for _, f := range files {
+		if !unseen[gravatarAuthorID(f.path)] || now.Sub(f.accessed) < minAge {
+			kept = append(kept, f)
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			kept = append(kept, f)
+			continue
+		}
+		result.Removed++
+		result.Freed += f.size
+	}
+	capped := pruneLeastAccessed(kept, maxSize, minAge, nil, now)
+	result.Removed += capped.Removed
+	result.Freed += capped.Freed
+	return result, nil
+}
+
+func cleanGravatarCache() (ThumbnailCacheCleanResult, error) {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return ThumbnailCacheCleanResult{}, err
+	}
+	gravatarCacheMux.Lock()
+	defer gravatarCacheMux.Unlock()
+	return pruneGravatarCache(filepath.Join(tempDir, gravatar_dirname), GravatarCacheCap.Load(), GravatarCacheMinAge, GravatarMaxUnseen, AuthorsSeen, time.Now())
+}
+
+// monitorGravatarCache prunes the avatars at startup and then every GravatarCachePruneInterval.
+func monitorGravatarCache() {
+	for {
+		result, err := cleanGravatarCache()
+		if err != nil {
+			BKLog.Printf("%s Avatar cache pruning failed: %v", EmoWarning, err)
+		} else if result.Removed > 0 {
+			BKLog.Printf("%s Avatar cache pruned: removed %d files, freed %d MB", EmoInfo, result.Removed, result.Freed/1024/1024)
+		}
+		time.Sleep(GravatarCachePruneInterval)
+	}
+}
@@ -0,0 +1,110 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneGravatarCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), gravatar_dirname)
+	os.MkdirAll(dir, 0700)
+	now := time.Now()
+	seen := &AuthorsSeenStore{seen: map[string]time.Time{
+		"1": now.Add(-100 * 24 * time.Hour), // unseen for too long
+		"2": now.Add(-100 * 24 * time.Hour), // unseen, but the avatar was just shown
+		"3": now.Add(-time.Hour),
+		"4": now.Add(-10 * 24 * time.Hour),
+		"5": now.Add(-time.Hour),
+	}}
+	files := []struct {
+		name     string
+		age      time.Duration
+		expected bool // should still exist after pruning
+	}{
+		{"1.jpg", 30 * 24 * time.Hour, false},
+		{"2.jpg", time.Hour, true},
+		{"3.jpg", 20 * 24 * time.Hour, true},
+		{"4.jpg", 5 * 24 * time.Hour, true},
+		{"5.jpg", 2 * 24 * time.Hour, true},
+		{"6.jpg", 40 * 24 * time.Hour, false}, // unknown author is recorded as seen now, but is least recently accessed
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		os.WriteFile(path, make([]byte, 100), 0600)
+		accessed := now.Add(-f.age)
+		os.Chtimes(path, accessed, accessed)
+	}
+
+	status, err := gravatarCacheStatus(dir)
+	if err != nil || status.TotalSize != 600 || status.Files != 6 {
+		t.Errorf("gravatarCacheStatus() = %+v, %v; want 6 files of 600 bytes", status, err)
+	}
+
+	// after 1.jpg of the unseen author, 6.jpg is deleted to fit the cap
+	result, err := pruneGravatarCache(dir, 400, 24*time.Hour, 90*24*time.Hour, seen, now)
+	if err != nil {
+		t.Fatalf("pruneGravatarCache() error: %v", err)
+	}
+	for _, f := range files {
+		exists, _, _ := FileExists(filepath.Join(dir, f.name))
+		if exists != f.expected {
+			t.Errorf("%s exists=%t; want %t", f.name, exists, f.expected)
+		}
+	}
+	if result.Removed != 2 || result.Freed != 200 {
+		t.Errorf("pruneGravatarCache() = %+v; want 2 files and 200 bytes removed", result)
+	}
+	if _, ok := seen.seen["1"]; ok {
+		t.Errorf("unseen author 1 was kept in the author cache")
+	}
+	if last := seen.seen["6"]; !last.Equal(now) {
+		t.Errorf("unknown author 6 recorded as seen at %v; want now", last)
+	}
+
+	if _, err := scanGravatarCache(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("missing avatar directory: %v", err)
+	}
+}
+
+func TestAuthorsSeenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), authorsSeenFilename)
+	store := &AuthorsSeenStore{}
+	if err := store.load(path); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	store.Record([]string{"7", "", "8"}, now)
+	info, _ := os.Stat(path)
+	store.Record([]string{"7"}, now.Add(time.Hour)) // seen within a day, not written
+	if after, _ := os.Stat(path); !after.ModTime().Equal(info.ModTime()) || !store.seen["7"].Equal(now) {
+		t.Errorf("author seen again within a day rewrote the cache")
+	}
+
+	reloaded := &AuthorsSeenStore{}
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.seen) != 2 || !reloaded.seen["8"].Equal(now) {
+		t.Errorf("reloaded author cache %v; want authors 7 and 8 seen at %v", reloaded.seen, now)
+	}
+}
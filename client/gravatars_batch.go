@@ -0,0 +1,96 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const GravatarWorkers = 4 // Concurrent avatar downloads after one search
+
+// searchAuthors returns the unique authors of the search results which have avatar, in the order of the results.
+func searchAuthors(searchResult SearchResults) []FetchGravatarData {
+	seen := map[int]bool{}
+	var authors []FetchGravatarData
+	for _, result := range searchResult.Results {
+		author := result.Author
+		id, err := strconv.Atoi(string(author.ID))
+		if err != nil || seen[id] || (author.Avatar128 == "" && author.GravatarHash == "") {
+			continue
+		}
+		seen[id] = true
+		authors = append(authors, FetchGravatarData{ID: id, Avatar128: author.Avatar128, GravatarHash: author.GravatarHash})
+	}
+	return authors
+}
+
+// fetchSearchGravatars downloads avatars of the authors of the search results with at most GravatarWorkers at once,
+// so a page of results by many authors does not flood the servers. Avatars already on disk are not downloaded again.
+// One gravatars_batch task reports the paths of all the avatars by author ID, failed downloads are only logged.
+func fetchSearchGravatars(searchResult SearchResults, data SearchTaskData, searchTaskID string) {
+	authors := searchAuthors(searchResult)
+	if len(authors) == 0 {
+		return
+	}
+	taskID := uuid.New().String()
+	task := NewTask(map[string]interface{}{"search_task_id": searchTaskID}, data.AppID, taskID, "gravatars_batch").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	paths := map[string]string{}
+	var mux sync.Mutex
+	found := func(id int, path string) {
+		mux.Lock()
+		paths[strconv.Itoa(id)] = path
+		mux.Unlock()
+	}
+
+	queue := make(chan FetchGravatarData)
+	var wg sync.WaitGroup
+	for i := 0; i < GravatarWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for author := range queue {
+				path, _, err := fetchGravatarImage(author, task.RequestID)
+				if err != nil {
+					BKLog.Printf("%s Avatar of author %d: %v", EmoWarning, author.ID, err)
+					continue
+				}
+				found(author.ID, path)
+			}
+		}()
+	}
+	for _, author := range authors {
+		if path, err := gravatarFilePath(author.ID); err == nil {
+			if exists, _, _ := FileExists(path); exists {
+				found(author.ID, path)
+				continue
+			}
+		}
+		author.AddonVersion, author.PlatformVersion = data.AddonVersion, data.PlatformVersion
+		queue <- author
+	}
+	close(queue)
+	wg.Wait()
+
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: "Avatars fetched", Result: paths})
+}
@@ -0,0 +1,103 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchSearchGravatars(t *testing.T) {
+	var downloads, inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/avatar/6689/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("avatar"))
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientSmallThumbs = c }(ClientSmallThumbs)
+	defer setServer(setServer(server.URL))
+	ClientSmallThumbs = server.Client()
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(safeTemp, gravatar_dirname, "6680.jpg")
+	if err := os.MkdirAll(filepath.Dir(cached), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cached, []byte("avatar"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Asset
+	for id := 6680; id < 6690; id++ {
+		author := Author{ID: JSONNumber(fmt.Sprint(id)), Avatar128: fmt.Sprintf("/avatar/%d/", id)}
+		results = append(results, Asset{Author: author}, Asset{Author: author})
+	}
+	results = append(results, Asset{Author: Author{ID: "6690"}}) // no avatar
+
+	const appID = 668
+	fetchSearchGravatars(SearchResults{Results: results}, SearchTaskData{AppID: appID}, "search-task")
+	task := appTask(appID, time.Second)
+	if task == nil || task.TaskType != "gravatars_batch" {
+		t.Fatalf("got task %+v; want gravatars_batch", task)
+	}
+	var finish *TaskFinish
+	for finish == nil {
+		select {
+		case f := <-TaskFinishCh:
+			if f.AppID == appID {
+				finish = f
+			}
+		case <-time.After(time.Second):
+			t.Fatal("gravatars_batch task not finished")
+		}
+	}
+	paths, _ := finish.Result.(map[string]string)
+	if len(paths) != 9 || paths["6680"] != cached || paths["6681"] != filepath.Join(safeTemp, gravatar_dirname, "6681.jpg") || paths["6689"] != "" {
+		t.Errorf("gravatars_batch result = %v; want 9 avatars without the failed one", paths)
+	}
+	if n := downloads.Load(); n != 9 {
+		t.Errorf("%d downloads; want 9, one per author not on disk", n)
+	}
+	if n := maxInFlight.Load(); n > GravatarWorkers {
+		t.Errorf("%d concurrent downloads; want at most %d", n, GravatarWorkers)
+	}
+}
@@ -0,0 +1,208 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const MaxRequestBodySize = 10 * 1024 * 1024 // 10 MB, largest request bodies from add-on are uploads with metadata
+
+// Error codes used in ErrorResponse, add-on can switch on them.
+const (
+	ErrCodeInvalidJSON          = "invalid_json"
+	ErrCodeBodyTooLarge         = "body_too_large"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeInvalidField         = "invalid_field"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeMethodNotAllowed     = "method_not_allowed"
+	ErrCodeUpstream             = "upstream_error"
+	ErrCodeInternal             = "internal_error"
+)
+
+// ErrorResponse is the JSON envelope returned by all handlers for 4xx and 5xx responses:
+// {"error": {"code": "invalid_field", "message": "app_id must be positive"}}
+type ErrorResponse struct {
+	Error ErrorResponseBody `json:"error"`
+}
+
+type ErrorResponseBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// FieldError is returned by validation functions, it is reported with ErrCodeInvalidField.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// writeJSONError writes the ErrorResponse envelope with given status code.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	responseJSON, err := json.Marshal(ErrorResponse{Error: ErrorResponseBody{Code: code, Message: message}})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// decodeJSONBody decodes request body into dst, limiting the size of the body and checking the Content-Type.
+// Missing Content-Type is tolerated as older add-ons do not always send it.
+// On failure it writes the error response and returns false, handler should just return then.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || mediaType != "application/json" {
+			writeJSONError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, fmt.Sprintf("expected Content-Type application/json, got %s", ct))
+			return false
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit))
+		return false
+	}
+	writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "error parsing JSON: "+err.Error())
+	return false
+}
+
+// clientOrigin reports whether origin is a page served by the Client itself.
+func clientOrigin(origin string) bool {
+	if Port == nil {
+		return false
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if strings.EqualFold(origin, fmt.Sprintf("http://%s:%s", host, *Port)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowSettingsRequest guards the endpoints changing settings of the Client against web pages open in the browser.
+// Request must declare Content-Type application/json, which a page cannot send cross-origin without CORS preflight,
+// and must not come from a page on another origin than the Client. Add-on sends no Origin.
+// On failure it writes the error response and returns false.
+func allowSettingsRequest(w http.ResponseWriter, r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" && !clientOrigin(origin) {
+		writeJSONError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("origin %s is not allowed to change settings", origin))
+		return false
+	}
+	ct := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, fmt.Sprintf("expected Content-Type application/json, got %q", ct))
+		return false
+	}
+	return true
+}
+
+// readJSONBody reads the whole request body with the same size and Content-Type checks as decodeJSONBody.
+// On failure it writes the error response and returns false.
+func readJSONBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var raw json.RawMessage
+	if !decodeJSONBody(w, r, &raw) {
+		return nil, false
+	}
+	return raw, true
+}
+
+// writeValidationError writes 400 response for FieldError, or 500 for any other error.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidField, fieldErr.Error())
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+}
+
+// writeJSON writes data as JSON response with status 200.
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	responseJSON, err := json.Marshal(data)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "error converting to JSON: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
+
+func validateAppID(appID int) error {
+	if appID <= 0 {
+		return &FieldError{Field: "app_id", Message: "must be positive"}
+	}
+	return nil
+}
+
+func validateNotEmpty(field, value string) error {
+	if value == "" {
+		return &FieldError{Field: field, Message: "must not be empty"}
+	}
+	return nil
+}
+
+func validateUUID(field, value string) error {
+	if _, err := uuid.Parse(value); err != nil {
+		return &FieldError{Field: field, Message: fmt.Sprintf("is not a valid UUID: %q", value)}
+	}
+	return nil
+}
+
+// validateUTF8 rejects values which were not valid UTF-8 in the request, encoding/json replaces such bytes with U+FFFD.
+func validateUTF8(field, value string) error {
+	if strings.ContainsRune(value, utf8.RuneError) {
+		return &FieldError{Field: field, Message: "contains invalid UTF-8"}
+	}
+	return nil
+}
+
+// firstError returns first non-nil error, used to chain validations.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
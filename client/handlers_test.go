@@ -0,0 +1,117 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlersErrorEnvelope(t *testing.T) {
+	bigBody := `{"app_id": 1, "urlquery": "` + strings.Repeat("a", MaxRequestBodySize) + `"}`
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"search invalid JSON", assetSearchHandler, "application/json", `{"app_id": `, http.StatusBadRequest, ErrCodeInvalidJSON},
+		{"search missing app_id", assetSearchHandler, "application/json", `{"urlquery": "https://www.blenderkit.com/api/v1/search/"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"search empty urlquery", assetSearchHandler, "application/json", `{"app_id": 1234}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"search invalid result fields", assetSearchHandler, "application/json", `{"app_id": 1234, "urlquery": "https://www.blenderkit.com/api/v1/search/", "result_fields": "tiny"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"search wrong content type", assetSearchHandler, "text/plain", `{"app_id": 1234}`, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{"search invalid UTF-8 urlquery", assetSearchHandler, "application/json", "{\"app_id\": 1234, \"urlquery\": \"https://www.blenderkit.com/api/v1/search/?query=\xff\xfe\"}", http.StatusBadRequest, ErrCodeInvalidField},
+		{"search body too large", assetSearchHandler, "application/json", bigBody, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge},
+		{"download no download dirs", assetDownloadHandler, "application/json", `{"app_id": 1234, "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download invalid asset id", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp"], "asset_data": {"id": "not-uuid", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download invalid UTF-8 dir", assetDownloadHandler, "application/json", "{\"app_id\": 1234, \"download_dirs\": [\"/tmp/\xc3\x28\"], \"asset_data\": {\"id\": \"d5368c9d-092e-4319-afe1-dd765de6da01\", \"files\": [{\"fileType\": \"blend\"}]}}", http.StatusBadRequest, ErrCodeInvalidField},
+		{"download invalid UTF-8 name", assetDownloadHandler, "application/json", "{\"app_id\": 1234, \"download_dirs\": [\"/tmp\"], \"asset_data\": {\"id\": \"d5368c9d-092e-4319-afe1-dd765de6da01\", \"name\": \"chair \xff\", \"files\": [{\"fileType\": \"blend\"}]}}", http.StatusBadRequest, ErrCodeInvalidField},
+		{"download invalid layout", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp"], "layout": "flat", "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download single dir layout with two dirs", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp/a", "/tmp/b"], "layout": "single_dir", "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download single dir outside assets path", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp/assets"], "layout": "single_dir", "assets_path": "/home/project", "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download invalid priority", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp"], "priority": "urgent", "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download dir with traversal", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["/tmp/../home/user/.config/autostart"], "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download relative dir with traversal", assetDownloadHandler, "application/json", `{"app_id": 1234, "download_dirs": ["..\\..\\Startup"], "asset_data": {"id": "d5368c9d-092e-4319-afe1-dd765de6da01", "files": [{"fileType": "blend"}]}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"search tempdir with traversal", assetSearchHandler, "application/json", `{"app_id": 1234, "urlquery": "https://www.blenderkit.com/api/v1/search/", "tempdir": "/tmp/bktemp/../../etc"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"upload temp dir outside temp", assetUploadHandler, "application/json", `{"app_id": 1234, "export_data": {"temp_dir": "/etc/cron.d"}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"upload thumbnail with traversal", assetUploadHandler, "application/json", `{"app_id": 1234, "export_data": {"thumbnail_path": "/tmp/../root/.ssh/id_rsa"}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"resolutions no files nor asset base id", AssetResolutionsHandler, "application/json", `{"app_id": 1234}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"resolutions download dirs without asset id", AssetResolutionsHandler, "application/json", `{"app_id": 1234, "files": [{"fileType": "blend"}], "download_dirs": ["/tmp"]}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"rating invalid asset id", GetRatingHandler, "application/json", `{"app_id": 1234, "asset_id": "abc"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"comment empty text", CreateCommentHandler, "application/json", `{"app_id": 1234, "asset_id": "d5368c9d-092e-4319-afe1-dd765de6da01"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"cancel invalid task id", CancelDownloadHandler, "", `{"app_id": 1234, "task_id": ""}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download URL no files", GetDownloadURLWrapper, "application/json", `{"app_id": 1234, "PREFS": {"scene_id": "d5368c9d-092e-4319-afe1-dd765de6da01"}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"download URL invalid scene id", GetDownloadURLWrapper, "application/json", `{"app_id": 1234, "asset_data": {"files": [{"fileType": "blend"}]}, "PREFS": {"scene_id": "scene"}}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"report usages invalid scene", ReportUsagesHandler, "application/json", `{"app_id": 1234, "scene": "", "assetusageSet": [{"asset": "d5368c9d-092e-4319-afe1-dd765de6da01", "usageCount": 1}]}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"report usages empty set", ReportUsagesHandler, "application/json", `{"app_id": 1234, "scene": "d5368c9d-092e-4319-afe1-dd765de6da01"}`, http.StatusBadRequest, ErrCodeInvalidField},
+		{"report old add-on", reportHandler, "application/json", `{"app_id": 1234}`, http.StatusForbidden, ErrCodeForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", rec.Code, tt.wantStatus)
+			}
+			var envelope ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("response is not JSON error envelope: %v, body: %s", err, rec.Body.String())
+			}
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("error code = %q; want %q", envelope.Error.Code, tt.wantCode)
+			}
+			if envelope.Error.Message == "" {
+				t.Errorf("error message is empty")
+			}
+		})
+	}
+}
+
+// benchmarkHandler measures decoding of the request body, the body fails validation so no task is started.
+func benchmarkHandler(b *testing.B, handler http.HandlerFunc, body string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkAssetSearchHandler(b *testing.B) {
+	query := strings.Repeat("chair+wooden+", 200)
+	body := `{"urlquery": "https://www.blenderkit.com/api/v1/search/?query=` + query + `", "tempdir": "/tmp/bkit_temp", "asset_type": "model", "blender_version": "4.2"}`
+	benchmarkHandler(b, assetSearchHandler, body)
+}
+
+func BenchmarkAssetDownloadHandler(b *testing.B) {
+	description := strings.Repeat("Wooden chair with cushion. ", 200)
+	body := `{"app_id": 1234, "download_dirs": ["/tmp/a", "/tmp/b"], "asset_data": {"id": "not-uuid", "name": "Chair", "description": "` + description + `", "files": [{"fileType": "blend", "downloadUrl": "https://www.blenderkit.com/api/v1/downloads/1/"}]}, "PREFS": {"scene_id": "d5368c9d-092e-4319-afe1-dd765de6da01"}}`
+	benchmarkHandler(b, assetDownloadHandler, body)
+}
@@ -0,0 +1,279 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blenderkit/blenderkit/client/internal/mockserver"
+)
+
+// testClient is the Client served by httptest against the mock API, with channels handled as in main.
+// It is driven only through its HTTP endpoints, like by the add-on.
+type testClient struct {
+	t     *testing.T
+	URL   string
+	api   *mockserver.Server
+	appID int
+	tasks map[string]Task // every task seen in reports, /report prunes finished tasks
+}
+
+func startTestClient(t *testing.T, appID int) *testClient {
+	api := mockserver.New()
+	oldServer := setServer(api.URL)
+	oldClients := []*http.Client{ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs}
+	ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs = api.Client(), api.Client(), api.Client(), api.Client(), api.Client()
+
+	done := make(chan struct{})
+	go handleChannelsUntil(done)
+	client := httptest.NewServer(newRouter(clientRoutes()))
+	oldPort := Port
+	port := client.URL[strings.LastIndex(client.URL, ":")+1:]
+	Port = &port
+	t.Cleanup(func() {
+		client.Close()
+		Port = oldPort
+		close(done)
+		api.Close()
+		setServer(oldServer)
+		ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs = oldClients[0], oldClients[1], oldClients[2], oldClients[3], oldClients[4]
+		TasksMux.Lock()
+		delete(Tasks, appID)
+		TasksMux.Unlock()
+	})
+	c := &testClient{t: t, URL: client.URL, api: api, appID: appID, tasks: map[string]Task{}}
+	c.report()
+	return c
+}
+
+func (c *testClient) request(method, path string, body interface{}) *http.Response {
+	c.t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// post starts a task through the endpoint and returns its task_id.
+func (c *testClient) post(path string, body map[string]interface{}) string {
+	c.t.Helper()
+	body["app_id"] = c.appID
+	body["addon_version"] = "3.12.0"
+	resp := c.request(http.MethodPost, path, body)
+	defer resp.Body.Close()
+	var result map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("POST %s got %s, error %v", path, resp.Status, err)
+	}
+	return result["task_id"]
+}
+
+// report requests /report as the add-on does and remembers the reported tasks.
+func (c *testClient) report() {
+	c.t.Helper()
+	resp := c.request(http.MethodGet, "/report", map[string]interface{}{"app_id": c.appID, "addon_version": "3.12.0"})
+	defer resp.Body.Close()
+	var tasks []Task
+	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+		c.t.Fatalf("/report got %s: %v", resp.Status, err)
+	}
+	for _, task := range tasks {
+		c.tasks[task.TaskID] = task
+	}
+}
+
+// wait reports until all tasks matching the filter are done and at least count of them exist.
+func (c *testClient) wait(count int, match func(Task) bool) []Task {
+	c.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		c.report()
+		var matched []Task
+		pending := false
+		for _, task := range c.tasks {
+			if match(task) {
+				matched = append(matched, task)
+				pending = pending || (task.Status != "finished" && task.Status != "error")
+			}
+		}
+		if len(matched) >= count && !pending {
+			return matched
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("got %d matching tasks (pending %t), expected %d done", len(matched), pending, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitTask reports until the task is done.
+func (c *testClient) waitTask(taskID string) Task {
+	c.t.Helper()
+	return c.wait(1, func(task Task) bool { return task.TaskID == taskID })[0]
+}
+
+// searchTempDir returns the directory for thumbnails of a search, searches accept only dirs inside GetSafeTempPath().
+func searchTempDir(t *testing.T) string {
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(safeTemp, "model_search")
+}
+
+func (c *testClient) search(tempDir string) string {
+	return c.post("/blender/asset_search", map[string]interface{}{
+		"urlquery":        c.api.URL + "/api/v1/search/?query=chair+asset_type:model",
+		"asset_type":      "model",
+		"tempdir":         tempDir,
+		"blender_version": "4.2.0",
+	})
+}
+
+// TestIntegrationSearch reports while the search and its thumbnails change the tasks,
+// CI runs it with -race to catch tasks changed outside of handleChannels and TasksMux.
+func TestIntegrationSearch(t *testing.T) {
+	c := startTestClient(t, 639)
+	tempDir := searchTempDir(t)
+
+	search := c.waitTask(c.search(tempDir))
+	if search.Status != "finished" {
+		t.Fatalf("search %s: %s", search.Status, search.Message)
+	}
+	results := search.Result.(map[string]interface{})["results"].([]interface{})
+	if len(results) != len(c.api.Assets) {
+		t.Fatalf("got %d results, expected %d", len(results), len(c.api.Assets))
+	}
+
+	thumbnails := c.wait(2*len(c.api.Assets), func(task Task) bool { return task.TaskType == "thumbnail_download" })
+	for _, task := range thumbnails {
+		imagePath := task.Data.(map[string]interface{})["image_path"].(string)
+		if task.Status != "finished" || filepath.Dir(imagePath) != tempDir {
+			t.Errorf("thumbnail %s %s: %s", imagePath, task.Status, task.Message)
+		}
+		if _, err := os.Stat(imagePath); err != nil {
+			t.Errorf("thumbnail not on disk: %v", err)
+		}
+	}
+}
+
+func TestIntegrationSearchRateLimited(t *testing.T) {
+	c := startTestClient(t, 639)
+	c.api.Inject("/api/v1/search/", mockserver.Fault{Status: http.StatusTooManyRequests, RetryAfter: "60"})
+
+	search := c.waitTask(c.search(searchTempDir(t)))
+	if search.Status != "error" || !strings.Contains(search.Message, "429") {
+		t.Errorf("search %s: %q, expected error with 429", search.Status, search.Message)
+	}
+	if n := c.api.Requests("/api/v1/search/"); n != 1 {
+		t.Errorf("search requested %d times, expected 1", n)
+	}
+}
+
+func (c *testClient) download(asset mockserver.Asset, downloadDir string) string {
+	return c.post("/blender/asset_download", map[string]interface{}{
+		"download_dirs": []string{downloadDir},
+		"asset_data": map[string]interface{}{
+			"id":        asset.ID,
+			"name":      asset.Name,
+			"assetType": asset.AssetType,
+			"files":     []map[string]interface{}{{"fileType": "blend", "downloadUrl": c.api.URL + "/api/v1/downloads/" + asset.ID + "/"}},
+		},
+		"PREFS": map[string]interface{}{"resolution": "ORIGINAL"},
+	})
+}
+
+func downloadedFile(t *testing.T, task Task) []byte {
+	t.Helper()
+	paths := task.Result.(map[string]interface{})["file_paths"].([]interface{})
+	content, err := os.ReadFile(paths[0].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestIntegrationDownload(t *testing.T) {
+	c := startTestClient(t, 639)
+	asset := c.api.Assets[0]
+
+	download := c.waitTask(c.download(asset, t.TempDir()))
+	if download.Status != "finished" {
+		t.Fatalf("download %s: %s", download.Status, download.Message)
+	}
+	if content := downloadedFile(t, download); !bytes.Equal(content, asset.File) {
+		t.Errorf("downloaded %q, expected %q", content, asset.File)
+	}
+}
+
+func TestIntegrationDownloadTruncated(t *testing.T) {
+	c := startTestClient(t, 639)
+	asset := c.api.Assets[1]
+	c.api.Inject("/files/", mockserver.Fault{Truncate: true, Times: 1})
+	downloadDir := t.TempDir()
+
+	download := c.waitTask(c.download(asset, downloadDir))
+	if download.Status != "error" || !strings.Contains(download.Message, "error downloading asset") {
+		t.Fatalf("truncated download %s: %q, expected error", download.Status, download.Message)
+	}
+
+	download = c.waitTask(c.download(asset, downloadDir)) // resumes the .part file kept by the failed download
+	if download.Status != "finished" {
+		t.Fatalf("download after truncation %s: %s", download.Status, download.Message)
+	}
+	if content := downloadedFile(t, download); !bytes.Equal(content, asset.File) {
+		t.Errorf("downloaded %q, expected %q", content, asset.File)
+	}
+}
+
+func TestIntegrationSearchStructuredQuery(t *testing.T) {
+	c := startTestClient(t, 639)
+	taskID := c.post("/blender/asset_search", map[string]interface{}{
+		"query":   map[string]interface{}{"text": "chair", "asset_type": "model", "page_size": 2},
+		"tempdir": searchTempDir(t),
+	})
+	search := c.waitTask(taskID)
+	urlQuery := search.Data.(map[string]interface{})["urlquery"].(string)
+	if search.Status != "finished" || !strings.HasPrefix(urlQuery, c.api.URL+"/api/v1/search/?query=chair+asset_type:model") {
+		t.Fatalf("search of %s %s: %s", urlQuery, search.Status, search.Message)
+	}
+	// thumbnails read the port and server which the cleanup restores
+	results := search.Result.(map[string]interface{})["results"].([]interface{})
+	c.wait(2*len(results), func(task Task) bool { return task.TaskType == "thumbnail_download" })
+}
@@ -0,0 +1,246 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK ##### */
+
+// Package api is a typed client of the BlenderKit server API. It builds the requests, sends the common headers,
+// checks the response status, retries idempotent requests and decodes JSON responses, so the fetchers of the Client
+// only say what they want. Error responses are never decoded into the result.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client sends requests to one server. It is cheap to create, so it can be created per task with the headers of the task.
+type Client struct {
+	BaseURL    string       // e.g. https://www.blenderkit.com, paths of the requests are appended to it
+	HTTPClient *http.Client // http.DefaultClient if nil
+	Header     http.Header  // sent with every request, e.g. Authorization
+
+	// Do performs the request, e.g. with recording of metrics. HTTPClient.Do if nil.
+	Do func(client *http.Client, req *http.Request) (*http.Response, error)
+	// ParseError reads the body of failed response and returns human readable message and the raw body.
+	// The body is used as the message if nil.
+	ParseError func(resp *http.Response) (message, body string)
+	// CheckJSON checks that the successful response is JSON before it is decoded. Content-Type is checked if nil.
+	CheckJSON func(resp *http.Response) error
+
+	Retry RetryPolicy
+}
+
+// RetryPolicy retries GET requests which got one of the Statuses, e.g. 503 during server maintenance.
+// Other methods are never retried, the server could have applied them already.
+type RetryPolicy struct {
+	MaxRetries int
+	Delay      time.Duration
+	Statuses   []int
+	OnRetry    func(status int, delay time.Duration) // called before waiting for the retry, e.g. to inform the user
+}
+
+func (p RetryPolicy) retries(method string, status, attempt int) bool {
+	if method != http.MethodGet || attempt >= p.MaxRetries {
+		return false
+	}
+	for _, s := range p.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is the response of the server with non-2xx status.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string // e.g. "404 Not Found"
+	Message    string // from ParseError
+	Body       string // raw body of the response
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Status)
+}
+
+// StatusCode returns the status of the failed response in the error chain, 0 if the request got no response.
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// RequestError is the request which got no response. It keeps the request, so it can be sent again later.
+type RequestError struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// GetJSON decodes the response of GET request into out.
+func (c *Client) GetJSON(ctx context.Context, path string, out interface{}) error {
+	return c.Call(ctx, http.MethodGet, path, nil, out)
+}
+
+// PostJSON sends in as JSON body and decodes the response into out. Both can be nil.
+func (c *Client) PostJSON(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPost, path, in, out)
+}
+
+// PutJSON sends in as JSON body and decodes the response into out. Both can be nil.
+func (c *Client) PutJSON(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPut, path, in, out)
+}
+
+// Patch sends in as JSON body and decodes the response into out. Both can be nil.
+func (c *Client) Patch(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodPatch, path, in, out)
+}
+
+// Delete sends in as JSON body, if not nil, and decodes the response into out, if not nil and there is any.
+func (c *Client) Delete(ctx context.Context, path string, in, out interface{}) error {
+	return c.Call(ctx, http.MethodDelete, path, in, out)
+}
+
+// Call sends the request with in encoded as JSON body and decodes the JSON response into out.
+// Response without content, 204 or empty body, leaves out untouched. Returns *RequestError when the request got no response,
+// *Error for non-2xx response and other errors for requests which could not be built or responses which could not be decoded.
+func (c *Client) Call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+	}
+	url := c.BaseURL + path
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, url, body)
+		if err != nil {
+			return err
+		}
+		if c.Retry.retries(method, resp.StatusCode, attempt) {
+			resp.Body.Close()
+			if c.Retry.OnRetry != nil {
+				c.Retry.OnRetry(resp.StatusCode, c.Retry.Delay)
+			}
+			select {
+			case <-time.After(c.Retry.Delay):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		defer resp.Body.Close()
+		return c.readResponse(method, url, resp, out)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("making %s request: %w", method, err)
+	}
+	req.Header = c.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	do := c.Do
+	if do == nil {
+		do = (*http.Client).Do
+	}
+	resp, err := do(client, req)
+	if err != nil {
+		return nil, &RequestError{Method: method, URL: url, Header: req.Header, Body: body, Err: err}
+	}
+	return resp, nil
+}
+
+func (c *Client) readResponse(method, url string, resp *http.Response, out interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{Method: method, URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+		if c.ParseError != nil {
+			apiErr.Message, apiErr.Body = c.ParseError(resp)
+		} else {
+			raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			apiErr.Message, apiErr.Body = string(raw), string(raw)
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body) // connection is reused only when the body was read
+		return nil
+	}
+
+	checkJSON := c.CheckJSON
+	if checkJSON == nil {
+		checkJSON = checkContentType
+	}
+	if err := checkJSON(resp); err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+func checkContentType(resp *http.Response) error {
+	if contentType := resp.Header.Get("Content-Type"); !strings.Contains(contentType, "application/json") {
+		return fmt.Errorf("expected application/json, got %q", contentType)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type profile struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+func TestGetJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v1/me/" {
+			t.Errorf("request %s %s; want GET /api/v1/me/", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			t.Errorf("Authorization = %q; want %q", auth, "Bearer key")
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"id": 42, "username": "bk"}`)
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, Header: http.Header{"Authorization": {"Bearer key"}}}
+	var out profile
+	if err := client.GetJSON(context.Background(), "/api/v1/me/", &out); err != nil {
+		t.Fatalf("GetJSON() failed: %v", err)
+	}
+	if out != (profile{ID: 42, Username: "bk"}) {
+		t.Errorf("GetJSON() decoded %+v", out)
+	}
+	if client.Header.Get("Content-Type") != "" {
+		t.Error("Call() modified the headers of the client")
+	}
+}
+
+func TestCallSendsJSONBody(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if r.Method != method || string(body) != `{"score":5}` || r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s request: got %s %s with Content-Type %q", method, r.Method, body, r.Header.Get("Content-Type"))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"score": 5}`)
+		}))
+		client := &Client{BaseURL: server.URL}
+		out := map[string]interface{}{}
+		in := map[string]int{"score": 5}
+		var err error
+		switch method {
+		case http.MethodPost:
+			err = client.PostJSON(context.Background(), "/", in, &out)
+		case http.MethodPut:
+			err = client.PutJSON(context.Background(), "/", in, &out)
+		case http.MethodPatch:
+			err = client.Patch(context.Background(), "/", in, &out)
+		case http.MethodDelete:
+			err = client.Delete(context.Background(), "/", in, &out)
+		}
+		if err != nil || out["score"] != 5.0 {
+			t.Errorf("%s: got %v, %v", method, out, err)
+		}
+		server.Close()
+	}
+}
+
+func TestCallWithoutContent(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"no content": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+		"empty body": func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Content-Type", "application/json") },
+		"nil out": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "<html>not decoded</html>")
+		},
+	} {
+		server := httptest.NewServer(handler)
+		client := &Client{BaseURL: server.URL}
+		out := map[string]interface{}{"kept": true}
+		var err error
+		if name == "nil out" {
+			err = client.Delete(context.Background(), "/", nil, nil)
+		} else {
+			err = client.Delete(context.Background(), "/", nil, &out)
+		}
+		if err != nil || out["kept"] != true {
+			t.Errorf("%s: got %v, %v; want untouched result", name, out, err)
+		}
+		server.Close()
+	}
+}
+
+func TestCallError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"detail": "Invalid token."}`)
+	}))
+	defer server.Close()
+
+	out := map[string]interface{}{}
+	err := (&Client{BaseURL: server.URL}).GetJSON(context.Background(), "/api/v1/me/", &out)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetJSON() = %v; want *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden || apiErr.Method != http.MethodGet || apiErr.URL != server.URL+"/api/v1/me/" || apiErr.Body != `{"detail": "Invalid token."}` {
+		t.Errorf("GetJSON() error = %+v", apiErr)
+	}
+	if len(out) != 0 {
+		t.Errorf("error response was decoded into the result: %v", out)
+	}
+	if StatusCode(fmt.Errorf("wrapped: %w", err)) != http.StatusForbidden {
+		t.Errorf("StatusCode() of wrapped error = %d; want 403", StatusCode(err))
+	}
+	if StatusCode(errors.New("no response")) != 0 {
+		t.Error("StatusCode() of other error is not 0")
+	}
+
+	client := &Client{
+		BaseURL: server.URL,
+		ParseError: func(resp *http.Response) (string, string) {
+			body, _ := io.ReadAll(resp.Body)
+			return "Invalid token.", string(body)
+		},
+	}
+	err = client.GetJSON(context.Background(), "/", &out)
+	if err == nil || err.Error() != "Invalid token. (403 Forbidden)" {
+		t.Errorf("error with ParseError = %v; want %q", err, "Invalid token. (403 Forbidden)")
+	}
+}
+
+func TestCallChecksJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html>error code: 1015</html>")
+	}))
+	defer server.Close()
+
+	var out map[string]interface{}
+	err := (&Client{BaseURL: server.URL}).GetJSON(context.Background(), "/", &out)
+	if err == nil || !strings.Contains(err.Error(), "text/html") {
+		t.Errorf("GetJSON() of HTML = %v; want Content-Type error", err)
+	}
+
+	rateLimited := errors.New("rate limited")
+	client := &Client{BaseURL: server.URL, CheckJSON: func(*http.Response) error { return rateLimited }}
+	if err := client.GetJSON(context.Background(), "/", &out); !errors.Is(err, rateLimited) {
+		t.Errorf("GetJSON() with CheckJSON = %v; want %v", err, rateLimited)
+	}
+}
+
+func TestCallRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"body": %q}`, body)
+	}))
+	defer server.Close()
+
+	var retries []int
+	client := &Client{
+		BaseURL: server.URL,
+		Retry: RetryPolicy{
+			MaxRetries: 3,
+			Delay:      time.Millisecond,
+			Statuses:   []int{http.StatusServiceUnavailable},
+			OnRetry:    func(status int, _ time.Duration) { retries = append(retries, status) },
+		},
+	}
+	var out map[string]string
+	if err := client.GetJSON(context.Background(), "/", &out); err != nil {
+		t.Fatalf("GetJSON() failed: %v", err)
+	}
+	if requests.Load() != 3 || len(retries) != 2 || retries[0] != http.StatusServiceUnavailable {
+		t.Errorf("GetJSON() made %d requests with retries %v; want 3 requests and 2 retries", requests.Load(), retries)
+	}
+
+	requests.Store(0)
+	client.Retry.MaxRetries = 1
+	if err := client.GetJSON(context.Background(), "/", &out); StatusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("GetJSON() after exhausted retries = %v; want 503", err)
+	}
+
+	requests.Store(0)
+	client.Retry.MaxRetries = 3
+	if err := client.PostJSON(context.Background(), "/", map[string]int{"a": 1}, &out); StatusCode(err) != http.StatusServiceUnavailable || requests.Load() != 1 {
+		t.Errorf("PostJSON() = %v after %d requests; want 503 without retry", err, requests.Load())
+	}
+}
+
+func TestCallRetryCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{
+		BaseURL: server.URL,
+		Retry: RetryPolicy{
+			MaxRetries: 5,
+			Delay:      time.Hour,
+			Statuses:   []int{http.StatusServiceUnavailable},
+			OnRetry:    func(int, time.Duration) { cancel() },
+		},
+	}
+	if err := client.GetJSON(ctx, "/", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetJSON() = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestCallRequestError(t *testing.T) {
+	failure := errors.New("connection refused")
+	var performed atomic.Bool
+	client := &Client{
+		BaseURL: "https://www.blenderkit.com",
+		Header:  http.Header{"Authorization": {"Bearer key"}},
+		Do: func(_ *http.Client, req *http.Request) (*http.Response, error) {
+			performed.Store(true)
+			return nil, failure
+		},
+	}
+	err := client.PostJSON(context.Background(), "/api/v1/comments/feedback/", map[string]string{"flag": "like"}, nil)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || !errors.Is(err, failure) || !performed.Load() {
+		t.Fatalf("PostJSON() = %v; want *RequestError wrapping %v", err, failure)
+	}
+	if reqErr.Method != http.MethodPost || reqErr.URL != "https://www.blenderkit.com/api/v1/comments/feedback/" ||
+		string(reqErr.Body) != `{"flag":"like"}` || reqErr.Header.Get("Authorization") != "Bearer key" {
+		t.Errorf("RequestError = %+v; want the failed request", reqErr)
+	}
+	if StatusCode(err) != 0 {
+		t.Errorf("StatusCode() of request error = %d; want 0", StatusCode(err))
+	}
+
+	performed.Store(false)
+	if err := client.PostJSON(context.Background(), "/", make(chan int), nil); err == nil || performed.Load() {
+		t.Errorf("PostJSON() of unencodable body = %v; want encoding error", err)
+	}
+}
@@ -0,0 +1,347 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK ##### */
+
+// Package mockserver is a fake BlenderKit API for end-to-end tests of the Client.
+// It serves canned search results, thumbnails, asset files, uploads, assets and OAuth2 tokens,
+// all linking back to the mock server, and can inject faults into any of its endpoints.
+package mockserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault changes the response of the endpoints matching its path prefix.
+type Fault struct {
+	Latency    time.Duration // response is delayed, the request context can cancel the wait
+	Status     int           // response has this status and an error body instead, e.g. 429
+	RetryAfter string        // Retry-After header sent with Status
+	Truncate   bool          // only half of the body is sent, with Content-Length of the whole body
+	Times      int           // how many requests get the fault, 0 for all
+}
+
+// Asset is one of the canned assets returned by the search.
+type Asset struct {
+	ID          string
+	AssetBaseID string
+	Name        string
+	AssetType   string
+	File        []byte // content of the blend file, served at /files/<ID>.blend
+}
+
+// Server is the mock API. Its URL is used as the server of the Client, e.g. Server = &mock.URL.
+type Server struct {
+	*httptest.Server
+	Assets []Asset
+
+	mux      sync.Mutex
+	faults   map[string][]*Fault // by path prefix
+	requests map[string]int      // by path
+	uploads  map[string][]byte   // by upload ID, files PUT to the fake S3
+}
+
+// New starts the mock server with two model assets.
+// Categories and disclaimers fetched when the add-on connects are served too, so tests see no failed requests.
+func New() *Server {
+	s := &Server{
+		Assets: []Asset{
+			{ID: "2d4e8a52-8b1b-4bd4-8df3-41c6a5f7c4a1", AssetBaseID: "c9b7b0f2-5f4f-4f55-9f7e-0d1d9a3a8e11", Name: "Wooden Chair", AssetType: "model", File: []byte("BLENDER-v300 wooden chair")},
+			{ID: "7a0f3c6e-1e1d-4c2e-a7a4-52f0f1a6b9d2", AssetBaseID: "0f6f8c0d-3a5b-4f0c-8e7b-2b8d7f1c6e22", Name: "Office Chair", AssetType: "model", File: []byte("BLENDER-v300 office chair")},
+		},
+		faults:   make(map[string][]*Fault),
+		requests: make(map[string]int),
+		uploads:  make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/search/", s.search)
+	mux.HandleFunc("GET /api/v1/downloads/{id}/", s.download)
+	mux.HandleFunc("/files/{name}", s.file)
+	mux.HandleFunc("GET /thumbs/{name}", s.thumbnail)
+	mux.HandleFunc("POST /api/v1/uploads/", s.createUpload)
+	mux.HandleFunc("PUT /s3/{id}", s.s3Put)
+	mux.HandleFunc("POST /api/v1/uploads_s3/{id}/upload-file/", s.uploadDone)
+	mux.HandleFunc("POST /api/v1/assets/", s.createAsset)
+	mux.HandleFunc("PATCH /api/v1/assets/{id}/", s.updateAsset)
+	mux.HandleFunc("POST /o/token/", s.token)
+	mux.HandleFunc("GET /api/v1/categories", s.categories)
+	mux.HandleFunc("GET /api/v1/disclaimer/active/", s.disclaimers)
+	s.Server = httptest.NewServer(s.withFaults(mux))
+	return s
+}
+
+// Inject adds the fault to requests whose path starts with prefix, e.g. "/api/v1/search/".
+func (s *Server) Inject(prefix string, fault Fault) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.faults[prefix] = append(s.faults[prefix], &fault)
+}
+
+// Requests returns how many requests were made to the path.
+func (s *Server) Requests(path string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.requests[path]
+}
+
+// Uploaded returns the content of the file uploaded to the fake S3 with the upload ID, nil if none.
+func (s *Server) Uploaded(id string) []byte {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.uploads[id]
+}
+
+// fault returns the first active fault for the path and uses one of its Times.
+func (s *Server) fault(path string) *Fault {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests[path]++
+	for prefix, faults := range s.faults {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		for _, f := range faults {
+			if f.Times < 0 {
+				continue
+			}
+			if f.Times > 0 {
+				f.Times--
+				if f.Times == 0 {
+					f.Times = -1 // used up
+				}
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) withFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := s.fault(r.URL.Path)
+		if f == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.Status != 0 {
+			if f.RetryAfter != "" {
+				w.Header().Set("Retry-After", f.RetryAfter)
+			}
+			writeJSON(w, f.Status, map[string]string{"detail": http.StatusText(f.Status)})
+			return
+		}
+		if !f.Truncate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		for key, values := range rec.Header() {
+			w.Header()[key] = values
+		}
+		body := rec.Body.Bytes()
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.Code)
+		w.Write(body[:len(body)/2])
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		panic(http.ErrAbortHandler) // closes the connection, so the client sees the body cut short
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// searchResult is the asset as in the response of /api/v1/search/.
+func (s *Server) searchResult(a Asset) map[string]interface{} {
+	thumb := func(size string) string { return fmt.Sprintf("%s/thumbs/%s_%s.png", s.URL, a.ID, size) }
+	return map[string]interface{}{
+		"id":                     a.ID,
+		"assetBaseId":            a.AssetBaseID,
+		"name":                   a.Name,
+		"displayName":            a.Name,
+		"assetType":              a.AssetType,
+		"verificationStatus":     "validated",
+		"thumbnailSmallUrl":      thumb("small"),
+		"thumbnailMiddleUrl":     thumb("middle"),
+		"thumbnailSmallUrlWebp":  thumb("small"),
+		"thumbnailMiddleUrlWebp": thumb("middle"),
+		"files": []map[string]interface{}{
+			{"fileType": "blend", "downloadUrl": fmt.Sprintf("%s/api/v1/downloads/%s/", s.URL, a.ID)},
+		},
+		"author": map[string]interface{}{"id": 1, "fullName": "Mock Author"},
+	}
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	results := make([]map[string]interface{}, 0, len(s.Assets))
+	for _, a := range s.Assets {
+		results = append(results, s.searchResult(a))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(results), "next": nil, "previous": nil, "results": results})
+}
+
+func (s *Server) asset(id string) (Asset, bool) {
+	for _, a := range s.Assets {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.asset(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"filePath": fmt.Sprintf("%s/files/%s.blend", s.URL, a.ID)})
+}
+
+func (s *Server) file(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.asset(strings.TrimSuffix(r.PathValue("name"), ".blend"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, r.PathValue("name"), time.Time{}, bytes.NewReader(a.File))
+}
+
+func (s *Server) thumbnail(w http.ResponseWriter, r *http.Request) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request) {
+	var info map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+	s.mux.Lock()
+	id := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+	s.uploads[id] = nil
+	s.mux.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":               id,
+		"assetId":          info["assetId"],
+		"fileType":         info["fileType"],
+		"originalFilename": info["originalFilename"],
+		"s3UploadUrl":      fmt.Sprintf("%s/s3/%s", s.URL, id),
+		"uploadDoneUrl":    fmt.Sprintf("%s/api/v1/uploads_s3/%s/upload-file/", s.URL, id),
+	})
+}
+
+func (s *Server) s3Put(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mux.Lock()
+	s.uploads[r.PathValue("id")] = body
+	s.mux.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) uploadDone(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"detail": "ok"})
+}
+
+func (s *Server) assetResponse(id string, metadata map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 id,
+		"assetBaseId":        "base-" + id,
+		"name":               metadata["name"],
+		"displayName":        metadata["displayName"],
+		"assetType":          metadata["assetType"],
+		"verificationStatus": "uploading",
+	}
+}
+
+func (s *Server) createAsset(w http.ResponseWriter, r *http.Request) {
+	var metadata map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&metadata)
+	s.mux.Lock()
+	id := fmt.Sprintf("00000000-0000-4000-8000-%012d", s.requests["/api/v1/assets/"])
+	s.mux.Unlock()
+	writeJSON(w, http.StatusCreated, s.assetResponse(id, metadata))
+}
+
+func (s *Server) updateAsset(w http.ResponseWriter, r *http.Request) {
+	var metadata map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&metadata)
+	writeJSON(w, http.StatusOK, s.assetResponse(r.PathValue("id"), metadata))
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.PostForm.Get("grant_type") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  "mock-access-token",
+		"refresh_token": "mock-refresh-token",
+		"token_type":    "Bearer",
+		"expires_in":    36000,
+		"scope":         "read write",
+	})
+}
+
+func (s *Server) categories(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"count":   1,
+		"results": []map[string]interface{}{{"name": "Model", "slug": "model", "assetCount": len(s.Assets), "children": []interface{}{}}},
+	})
+}
+
+func (s *Server) disclaimers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": 0, "results": []interface{}{}})
+}
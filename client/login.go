@@ -19,7 +19,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -27,6 +29,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -45,9 +48,11 @@ type OAuth2VerificationData struct {
 // This func later checks the response against code_verifier and state parameters.
 func OAuth2VerificationDataHandler(w http.ResponseWriter, r *http.Request) {
 	var data OAuth2VerificationData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateNotEmpty("state", data.State), validateNotEmpty("code_verifier", data.CodeVerifier)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
@@ -64,13 +69,13 @@ func consumerExchangeHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 	authCode := queryParams.Get("code")
 	state := queryParams.Get("state")
-	redirectURL := *Server + "/oauth-landing/"
+	landingURL := currentServer() + "/oauth-landing/"
 	if authCode == "" {
-		http.Error(w, "Authorization Failed. OAuth2 authorization code was not provided.", http.StatusBadRequest)
+		writeLoginError(w, http.StatusBadRequest, "OAuth2 authorization code was not provided.", "")
 		return
 	}
 	if state == "" {
-		http.Error(w, "Authorization Failed. OAuth2 state was not provided.", http.StatusBadRequest)
+		writeLoginError(w, http.StatusBadRequest, "OAuth2 state was not provided.", "")
 		return
 	}
 
@@ -78,46 +83,144 @@ func consumerExchangeHandler(w http.ResponseWriter, r *http.Request) {
 	verificationData := OAuth2Sessions[state]
 	OAuth2SessionsMux.Unlock()
 	if verificationData.State != state {
-		http.Error(w, "Authorization Failed. OAuth2 state does not match.", http.StatusBadRequest)
+		writeLoginError(w, http.StatusBadRequest, "OAuth2 state does not match.", "")
 		return
 	}
 
-	responseJSON, status, error := GetTokens(authCode, "", verificationData)
-	if status == -1 {
-		text := "Authorization Failed. Server is not reachable. Response: " + error
-		http.Error(w, text, http.StatusBadRequest)
+	ctx, cancel := context.WithTimeout(r.Context(), ConsumerExchangeTimeout)
+	defer cancel()
+	exchange, started, err := exchangeCodeOnce(ctx, state, authCode, verificationData)
+	if err != nil {
+		writeLoginError(w, http.StatusGatewayTimeout, "Login is taking too long.", err.Error())
 		return
 	}
 
-	if status != 200 {
-		text := fmt.Sprintf("Authorization Failed. Retrieval of tokens failed (status code: %d) Response: %v", status, error)
-		http.Error(w, text, status)
+	status, err := exchange.status, exchange.err
+	if exchange.ok() {
+		if !started { // repeated by the back button or reload, the add-ons already got the tokens
+			BKLog.Printf("%s OAuth2 code for state %s already exchanged, showing the result again", EmoIdentity, state)
+		}
+		writeLoginSuccess(w, landingURL)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeLoginError(w, http.StatusGatewayTimeout, "Server did not respond in time.", err.Error())
+		return
+	}
+	if clockSkewErrorCode(err) != "" {
+		writeLoginError(w, http.StatusBadRequest, "Wrong date or time on this computer.", err.Error())
+		return
+	}
+	if status == -1 {
+		writeLoginError(w, http.StatusBadRequest, "Server is not reachable.", err.Error())
 		return
 	}
+	if status == http.StatusOK { // tokens could not be read from the response
+		status = http.StatusBadGateway
+	}
+	writeLoginError(w, status, fmt.Sprintf("Retrieval of tokens failed (status code: %d).", status), err.Error())
+}
 
+// emitLoginTasks delivers the tokens of the exchanged authorization code to all add-ons.
+func emitLoginTasks(tokens map[string]interface{}) {
 	TasksMux.Lock()
+	defer TasksMux.Unlock()
 	for appID := range Tasks {
 		taskID := uuid.New().String()
 		task := NewTask(make(map[string]interface{}), appID, taskID, "login")
-		task.Result = responseJSON
+		task.Result = tokens
 		task.Finish("Tokens obtained")
 		Tasks[appID][task.TaskID] = task
 	}
-	TasksMux.Unlock()
+}
+
+func writeLoginSuccess(w http.ResponseWriter, landingURL string) {
+	writeLoginPage(w, http.StatusOK, loginPage{
+		Success:    true,
+		Title:      "Login successful",
+		Message:    "You can close this tab and return to Blender.",
+		LandingURL: landingURL,
+	})
+}
+
+// ConsumerExchangeTimeout bounds the exchange of the authorization code, so a hanging token endpoint does not keep the browser waiting.
+var ConsumerExchangeTimeout = 30 * time.Second
+
+// CodeExchangeTTL is how long a successful exchange is kept for reloads of the redirect page, later reloads send the used code to the server which rejects it.
+var CodeExchangeTTL = 10 * time.Minute
+
+// codeExchange is the exchange of the authorization code of one OAuth2 state, shared by repeated loads of the redirect page.
+type codeExchange struct {
+	done     chan struct{} // closed once the fields below are set
+	result   map[string]interface{}
+	status   int
+	err      error
+	finished time.Time
+}
 
-	http.Redirect(w, r, redirectURL, http.StatusPermanentRedirect)
+func (e *codeExchange) ok() bool {
+	return e.err == nil && e.status == http.StatusOK
 }
 
-// GetTokens sends a request to the server to get tokens. It returns the response JSON, status code and error message as string.
+var (
+	codeExchanges    = make(map[string]*codeExchange) // by OAuth2 state, successful ones are kept as used states
+	codeExchangesMux sync.Mutex
+)
+
+// exchangeCodeOnce calls GetTokens once per OAuth2 state. Browser back button or reload sends the code again,
+// the server rejects a used code, so repeats wait for the exchange in flight or get the successful one without a request.
+// The exchange runs within ConsumerExchangeTimeout also when the browser which started it goes away, ctx only bounds the wait.
+// Successful exchange emits the login tasks once and is kept for CodeExchangeTTL. Failed exchanges are forgotten, the next load tries again.
+// Returns whether this call started the exchange, error if ctx ended before the exchange did.
+func exchangeCodeOnce(ctx context.Context, state, authCode string, verificationData OAuth2VerificationData) (*codeExchange, bool, error) {
+	codeExchangesMux.Lock()
+	for s, e := range codeExchanges {
+		if !e.finished.IsZero() && time.Since(e.finished) > CodeExchangeTTL {
+			delete(codeExchanges, s)
+		}
+	}
+	e, inFlight := codeExchanges[state]
+	if !inFlight {
+		e = &codeExchange{done: make(chan struct{})}
+		codeExchanges[state] = e
+		timeout := ConsumerExchangeTimeout
+		go func() {
+			exchangeCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			result, status, err := GetTokens(exchangeCtx, authCode, "", verificationData)
+			codeExchangesMux.Lock()
+			e.result, e.status, e.err, e.finished = result, status, err, time.Now()
+			if !e.ok() {
+				delete(codeExchanges, state)
+			}
+			codeExchangesMux.Unlock()
+			if e.ok() { // also when the browser which started the exchange went away
+				emitLoginTasks(result)
+			}
+			close(e.done)
+		}()
+	}
+	codeExchangesMux.Unlock()
+
+	select {
+	case <-e.done:
+		return e, !inFlight, nil
+	case <-ctx.Done():
+		return nil, !inFlight, fmt.Errorf("waiting for the login: %w", ctx.Err())
+	}
+}
+
+// GetTokens sends a request to the server to get tokens. It returns the response JSON, status code and error.
+// Status code is -1 if the server was not reached, error is *ClockSkewError if that was due to wrong system clock.
 // Parameter authCode is the authorization code - if it's not empty, it's used to get the tokens in grant_type "authorization_code".
 // Parameter refreshToken is the refresh token - if it's not empty, it's used to get the tokens in grant_type "refresh_token".
-// Must be called with either authCode or refreshToken, not both.
-func GetTokens(authCode string, refreshToken string, verificationData OAuth2VerificationData) (map[string]interface{}, int, string) {
+// Must be called with either authCode or refreshToken, not both. The request is aborted when ctx ends.
+func GetTokens(ctx context.Context, authCode string, refreshToken string, verificationData OAuth2VerificationData) (map[string]interface{}, int, error) {
 	if authCode == "" && refreshToken == "" {
-		return nil, -1, "No authCode or refreshToken provided"
+		return nil, -1, errors.New("no authCode or refreshToken provided")
 	}
 	if authCode != "" && refreshToken != "" {
-		return nil, -1, "Both authCode and refreshToken provided"
+		return nil, -1, errors.New("both authCode and refreshToken provided")
 	}
 	data := url.Values{}
 
@@ -129,7 +232,7 @@ func GetTokens(authCode string, refreshToken string, verificationData OAuth2Veri
 		if verificationData.CodeVerifier != "" {
 			data.Set("code_verifier", verificationData.CodeVerifier)
 		} else {
-			return nil, -1, "Could not find code_verifier."
+			return nil, -1, errors.New("could not find code_verifier")
 		}
 	}
 
@@ -143,41 +246,42 @@ func GetTokens(authCode string, refreshToken string, verificationData OAuth2Veri
 	data.Set("scopes", "read write")
 	data.Set("redirect_uri", fmt.Sprintf("http://localhost:%s/consumer/exchange/", *Port))
 
-	url := fmt.Sprintf("%s/o/token/", *Server)
-	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
+	url := fmt.Sprintf("%s/o/token/", currentServer())
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(data.Encode()))
 	if err != nil {
 		log.Fatalf("Error creating request: %v", err)
-		return nil, -1, "Failed to create request"
+		return nil, -1, errors.New("failed to create request")
 	}
 
-	req.Header = getHeaders("", *SystemID, verificationData.AddonVersion, verificationData.PlatformVersion) // Does not make sense to send old API key here
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")                                     // Overwrite Content-Type to "application/x-www-form-urlencoded"
-	resp, err := ClientAPI.Do(req)
+	// Does not make sense to send old API key here, Content-Type is overwritten for the form
+	req.Header = getHeaders(RequestMeta{AddonVersion: verificationData.AddonVersion, PlatformVersion: verificationData.PlatformVersion})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		log.Printf("Error making request: %v", err)
-		return nil, -1, "Failed to make request"
+		return nil, -1, fmt.Errorf("failed to make request: %w", checkClockSkew(err))
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Error reading response: %v", err)
-		return nil, resp.StatusCode, "Failed to read response"
+		return nil, resp.StatusCode, errors.New("failed to read response")
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Error response from server: %s", string(body))
-		return nil, resp.StatusCode, "Failed to retrieve tokens"
+		return nil, resp.StatusCode, errors.New("failed to retrieve tokens")
 	}
 
 	var respJSON map[string]interface{}
 	if err := json.Unmarshal(body, &respJSON); err != nil {
 		log.Printf("Error decoding response JSON: %v", err)
-		return nil, resp.StatusCode, "Failed to decode response JSON"
+		return nil, resp.StatusCode, errors.New("failed to decode response JSON")
 	}
 
 	BKLog.Printf("%s Token retrieval OK (grant type: %s)", EmoIdentity, data.Get("grant_type"))
-	return respJSON, resp.StatusCode, ""
+	return respJSON, resp.StatusCode, nil
 }
 
 type RefreshTokenData struct {
@@ -189,27 +293,88 @@ type RefreshTokenData struct {
 // It parses the request body, calls goroutine RefreshToken and returns StatusOK.
 // If the request body is invalid, it returns StatusBadRequest.
 func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body: "+err.Error(), http.StatusInternalServerError)
+	var data RefreshTokenData
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	defer r.Body.Close()
-
-	var data RefreshTokenData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if err := validateNotEmpty("refresh_token", data.RefreshToken); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	go RefreshToken(data)
+	go runTask(data.AppID, "", func() { RefreshToken(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
+// TokenRefreshGracePeriod is how long the result of successful refresh is reused for requests with the already used refresh token.
+var TokenRefreshGracePeriod = time.Minute
+
+// tokenRefresh is one refresh of tokens on the server, shared by all requests with the same refresh token.
+type tokenRefresh struct {
+	done     chan struct{} // closed once the fields below are set
+	result   map[string]interface{}
+	status   int
+	err      error
+	finished time.Time
+}
+
+func (r *tokenRefresh) ok() bool {
+	return r.err == nil && r.status == http.StatusOK
+}
+
+var (
+	tokenRefreshes    = make(map[string]*tokenRefresh) // by refresh token
+	tokenRefreshesMux sync.Mutex
+)
+
+// forgetLoginState drops the OAuth2 sessions, code exchanges and token refreshes, which are valid only on the server they were made with.
+func forgetLoginState() {
+	OAuth2SessionsMux.Lock()
+	OAuth2Sessions = make(map[string]OAuth2VerificationData)
+	OAuth2SessionsMux.Unlock()
+	codeExchangesMux.Lock()
+	codeExchanges = make(map[string]*codeExchange)
+	codeExchangesMux.Unlock()
+	tokenRefreshesMux.Lock()
+	tokenRefreshes = make(map[string]*tokenRefresh)
+	tokenRefreshesMux.Unlock()
+}
+
+// refreshTokensOnce calls GetTokens once for concurrent requests with the same refresh token.
+// Server invalidates the refresh token on first use, so requests arriving within TokenRefreshGracePeriod
+// after a successful refresh get its result instead of failing. Returns whether this call did the refresh.
+func refreshTokensOnce(refreshToken string, verificationData OAuth2VerificationData) (*tokenRefresh, bool) {
+	tokenRefreshesMux.Lock()
+	for token, r := range tokenRefreshes {
+		if !r.finished.IsZero() && time.Since(r.finished) > TokenRefreshGracePeriod {
+			delete(tokenRefreshes, token)
+		}
+	}
+	if r, ok := tokenRefreshes[refreshToken]; ok {
+		tokenRefreshesMux.Unlock()
+		<-r.done
+		return r, false
+	}
+	r := &tokenRefresh{done: make(chan struct{})}
+	tokenRefreshes[refreshToken] = r
+	tokenRefreshesMux.Unlock()
+
+	result, status, err := GetTokens(context.Background(), "", refreshToken, verificationData)
+	tokenRefreshesMux.Lock()
+	r.result, r.status, r.err, r.finished = result, status, err, time.Now()
+	if !r.ok() {
+		delete(tokenRefreshes, refreshToken) // failures are not reused, next request tries again
+	}
+	tokenRefreshesMux.Unlock()
+	close(r.done)
+	return r, true
+}
+
 // RefreshToken refreshes the access token using the refresh token. It calls GetTokens with the refresh token and "refresh_token" grant type.
 // If the request is successful, it creates Tasks with status finished for all appIDs with the response JSON -> refreshing the token in all add-ons.
 // If the request fails, it creates Tasks with status error for all appIDs -> logout in all add-ons.
+// Concurrent and shortly repeated refreshes with the same refresh token share one request, see refreshTokensOnce;
+// those only deliver the successful result to the requesting add-on, all add-ons got it from the first refresh.
 func RefreshToken(data RefreshTokenData) {
 	verificationData := OAuth2VerificationData{
 		State:        "",
@@ -221,14 +386,21 @@ func RefreshToken(data RefreshTokenData) {
 			PlatformVersion: data.PlatformVersion,
 		},
 	}
-	rJSON, status, errMsg := GetTokens("", data.RefreshToken, verificationData)
+	refresh, refreshed := refreshTokensOnce(data.RefreshToken, verificationData)
+	if !refreshed && !refresh.ok() {
+		return
+	}
 	TasksMux.Lock()
 	for appID := range Tasks {
+		if !refreshed && appID != data.AppID {
+			continue
+		}
 		taskID := uuid.New().String()
 		task := NewTask(make(map[string]interface{}), appID, taskID, "login")
-		task.Result = rJSON
-		if errMsg != "" || status != http.StatusOK {
-			task.Message = fmt.Sprintf("Failed to refresh token: %v", errMsg)
+		task.Result = refresh.result
+		if !refresh.ok() {
+			task.Message = fmt.Sprintf("Failed to refresh token: %v", refresh.err)
+			task.ErrorCode = clockSkewErrorCode(refresh.err)
 			task.Status = "error"
 		} else {
 			task.Message = "Refreshed tokens obtained"
@@ -239,30 +411,85 @@ func RefreshToken(data RefreshTokenData) {
 	TasksMux.Unlock()
 }
 
-// OAuth2LogoutHandler handles the request signaling that the user has logged out.
-// It devalidates the
-func OAuth2LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body: "+err.Error(), http.StatusInternalServerError)
+// ManualAPIKeyExpiresIn is expires_in reported for API key pasted by the user.
+// The key is permanent and is never refreshed, as it comes without refresh token.
+const ManualAPIKeyExpiresIn = 365 * 24 * 60 * 60
+
+// ManualLoginHandler handles login with API key pasted by the user, for machines which cannot open the browser for OAuth2.
+// The key is validated in goroutine ManualLogin, result comes as task "login/manual".
+func ManualLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var data MinimalTaskData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateNotEmpty("api_key", data.APIKey)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	defer r.Body.Close()
+	go runTask(data.AppID, "", func() { ManualLogin(data) })
+	w.WriteHeader(http.StatusOK)
+}
 
+// ManualLogin validates the API key by fetching profile of its owner.
+// On success all add-ons get the same "login" task as from OAuth2 flow, with access_token set to the key and no refresh token.
+// Failure is reported only to the requesting add-on, so other logged in add-ons are not logged out.
+func ManualLogin(data MinimalTaskData) {
+	taskUUID := uuid.New().String()
+	task := NewTask(nil, data.AppID, taskUUID, "login/manual").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	profile, taskErr := fetchUserProfile(data, task)
+	if taskErr != nil {
+		if taskErr.ErrorCode == ErrCodeLoginRequired {
+			taskErr.Error = fmt.Errorf("API key was not accepted by the server, check it was copied whole")
+		} else {
+			taskErr.Error = fmt.Errorf("login with API key failed: %w", taskErr.Error)
+		}
+		sendTask(TaskErrorCh, taskErr)
+		return
+	}
+
+	result := manualLoginResult(data.APIKey, profile)
+	TasksMux.Lock()
+	for appID := range Tasks {
+		taskID := uuid.New().String()
+		task := NewTask(make(map[string]interface{}), appID, taskID, "login")
+		task.Result = result
+		task.Finish("Logged in with API key")
+		Tasks[appID][task.TaskID] = task
+	}
+	TasksMux.Unlock()
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "API key is valid", Result: profile})
+	emitUserQuota(data.AppID, data.RequestID, profile)
+}
+
+// manualLoginResult is the result of the "login" task after manual login, shaped as the OAuth2 token response the add-on reads.
+func manualLoginResult(apiKey string, profile map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"access_token":  apiKey,
+		"refresh_token": "",
+		"token_type":    "Bearer",
+		"expires_in":    ManualAPIKeyExpiresIn,
+		"profile":       profile,
+	}
+}
+
+// OAuth2LogoutHandler handles the request signaling that the user has logged out.
+// It devalidates the
+func OAuth2LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	var data RefreshTokenData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	go OAuth2Logout(data)
+	go runTask(data.AppID, "", func() { OAuth2Logout(data) })
 
 	w.WriteHeader(http.StatusOK)
 }
 
 // OAuth2Logout sends revocation request to the server to revoke the tokens.
-// It logs out the user from all add-ons.
+// It logs out the user from all add-ons and drops the queued offline operations of the user.
 func OAuth2Logout(data RefreshTokenData) {
+	Offline.Clear("user logged out")
 	var wg sync.WaitGroup
 	var ch = make(chan error, 2)
 	wg.Add(2)
@@ -294,7 +521,7 @@ func OAuth2Logout(data RefreshTokenData) {
 		task.Message = message
 		task.Status = status
 		task.Error = fmt.Errorf(message) // just to print it into console
-		AddTaskCh <- task
+		sendTask(AddTaskCh, task)
 	}
 }
 
@@ -314,15 +541,15 @@ func RevokeOAuth2Token(data RefreshTokenData, tokenType string, ch chan error, w
 		return
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/o/revoke_token/", *Server), strings.NewReader(rData.Encode()))
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/o/revoke_token/", currentServer()), strings.NewReader(rData.Encode()))
 	if err != nil {
 		ch <- fmt.Errorf("'%v: %w'", tokenType, err)
 		return
 	}
 
-	req.Header = getHeaders("", *SystemID, data.AddonVersion, data.PlatformVersion)
+	req.Header = getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion})
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded") // Overwrite Content-Type to "application/x-www-form-urlencoded"
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		ch <- fmt.Errorf("'%v: %w'", tokenType, err)
 		return
@@ -0,0 +1,86 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// loginPage is the page shown in the browser at the end of the OAuth2 login.
+// It is served by the Client itself, so the user sees the result even when the server is not reachable from the browser.
+type loginPage struct {
+	Success    bool
+	Title      string
+	Message    string
+	Detail     string
+	LandingURL string // optional link to the server's landing page, never redirected to
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>BlenderKit - {{.Title}}</title>
+<style>
+  body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center;
+    background: #1f1f1f; color: #e6e6e6; font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; }
+  main { max-width: 32rem; padding: 2.5rem; border-radius: 12px; background: #2b2b2b; text-align: center;
+    box-shadow: 0 8px 32px rgba(0, 0, 0, 0.4); }
+  .logo { font-size: 1.1rem; font-weight: 700; letter-spacing: 0.05em; color: #ff8a2b; }
+  h1 { margin: 1rem 0 0.5rem; font-size: 1.6rem; }
+  h1.success { color: #7bd88f; }
+  h1.error { color: #ff6b6b; }
+  p { line-height: 1.5; }
+  pre { white-space: pre-wrap; word-break: break-word; text-align: left; padding: 0.75rem; border-radius: 6px;
+    background: #1f1f1f; color: #b0b0b0; font-size: 0.85rem; }
+  a { color: #ff8a2b; }
+</style>
+</head>
+<body>
+<main>
+  <div class="logo">BlenderKit</div>
+  <h1 class="{{if .Success}}success{{else}}error{{end}}">{{.Title}}</h1>
+  <p>{{.Message}}</p>
+  {{if .Detail}}<pre>{{.Detail}}</pre>{{end}}
+  {{if .LandingURL}}<p><a href="{{.LandingURL}}">Continue to BlenderKit.com</a></p>{{end}}
+</main>
+</body>
+</html>
+`))
+
+// writeLoginPage renders the login result page with the status code.
+func writeLoginPage(w http.ResponseWriter, status int, page loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginPageTemplate.Execute(w, page); err != nil {
+		BKLog.Printf("%s Failed to render login page: %v", EmoWarning, err)
+	}
+}
+
+// writeLoginError renders the page for failed login.
+func writeLoginError(w http.ResponseWriter, status int, message, detail string) {
+	writeLoginPage(w, status, loginPage{
+		Title:   "Authorization failed",
+		Message: message + " Please try to log in again from Blender.",
+		Detail:  detail,
+	})
+}
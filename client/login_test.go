@@ -0,0 +1,347 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumerExchangeHandler(t *testing.T) {
+	var tokenRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		r.ParseForm()
+		if r.URL.Path != "/o/token/" || r.PostForm.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	OAuth2SessionsMux.Lock()
+	OAuth2Sessions["state-615"] = OAuth2VerificationData{CodeVerifier: "verifier", State: "state-615"}
+	OAuth2SessionsMux.Unlock()
+	TasksMux.Lock()
+	Tasks[615] = map[string]*Task{}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 615)
+		TasksMux.Unlock()
+	}()
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		contains string
+	}{
+		{"missing code", "state=state-615", http.StatusBadRequest, "authorization code was not provided"},
+		{"unknown state", "code=good-code&state=other", http.StatusBadRequest, "state does not match"},
+		{"tokens refused", "code=bad-code&state=state-615", http.StatusBadRequest, "Retrieval of tokens failed"},
+		{"success", "code=good-code&state=state-615", http.StatusOK, serverURL + "/oauth-landing/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			consumerExchangeHandler(rec, httptest.NewRequest(http.MethodGet, "/consumer/exchange/?"+tt.query, nil))
+			body := rec.Body.String()
+			if rec.Code != tt.status || !strings.Contains(body, tt.contains) {
+				t.Errorf("status = %d, expected %d with %q in body:\n%s", rec.Code, tt.status, tt.contains, body)
+			}
+			if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+				t.Errorf("Content-Type = %q, expected HTML page", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	// back button sends the used code again, the result is shown without another token request
+	requests := tokenRequests.Load()
+	rec := httptest.NewRecorder()
+	consumerExchangeHandler(rec, httptest.NewRequest(http.MethodGet, "/consumer/exchange/?code=good-code&state=state-615", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Login successful") {
+		t.Errorf("repeated exchange: status = %d, expected success page:\n%s", rec.Code, rec.Body.String())
+	}
+	if tokenRequests.Load() != requests {
+		t.Errorf("repeated exchange requested tokens again")
+	}
+
+	TasksMux.Lock()
+	defer TasksMux.Unlock()
+	if len(Tasks[615]) != 1 {
+		t.Fatalf("got %d login tasks, expected 1", len(Tasks[615]))
+	}
+	for _, task := range Tasks[615] {
+		if task.TaskType != "login" || task.Status != "finished" {
+			t.Errorf("login task = %s %s, expected finished login", task.TaskType, task.Status)
+		}
+	}
+}
+
+func TestConsumerExchangeConcurrentAndTimeout(t *testing.T) {
+	var tokenRequests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		r.ParseForm()
+		if r.PostForm.Get("code") == "hanging-code" {
+			<-r.Context().Done()
+			return
+		}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client, timeout, ttl time.Duration) {
+		ClientAPI, ConsumerExchangeTimeout, CodeExchangeTTL = c, timeout, ttl
+	}(ClientAPI, ConsumerExchangeTimeout, CodeExchangeTTL)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+	OAuth2SessionsMux.Lock()
+	OAuth2Sessions["state-6611"] = OAuth2VerificationData{CodeVerifier: "verifier", State: "state-6611"}
+	OAuth2Sessions["state-6612"] = OAuth2VerificationData{CodeVerifier: "verifier", State: "state-6612"}
+	OAuth2SessionsMux.Unlock()
+
+	// reload while the first exchange is in flight waits for it
+	exchange := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		consumerExchangeHandler(rec, httptest.NewRequest(http.MethodGet, "/consumer/exchange/?"+query, nil))
+		return rec
+	}
+	results := make(chan *httptest.ResponseRecorder, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- exchange("code=slow-code&state=state-6611") }()
+	}
+	for tokenRequests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // second load would request the tokens by now
+	close(release)
+	for i := 0; i < 2; i++ {
+		if rec := <-results; rec.Code != http.StatusOK {
+			t.Errorf("concurrent exchange: status = %d, expected success", rec.Code)
+		}
+	}
+	if n := tokenRequests.Load(); n != 1 {
+		t.Errorf("concurrent exchanges made %d token requests, expected 1", n)
+	}
+
+	ConsumerExchangeTimeout = 50 * time.Millisecond
+	CodeExchangeTTL = 0
+	start := time.Now()
+	rec := exchange("code=hanging-code&state=state-6612")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("hanging token endpoint: status = %d, expected %d:\n%s", rec.Code, http.StatusGatewayTimeout, rec.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hanging token endpoint kept the browser waiting for %s", elapsed)
+	}
+	// the exchange runs on after the browser gave up, it must end before the globals are restored
+	codeExchangesMux.Lock()
+	hanging, expired := codeExchanges["state-6612"], codeExchanges["state-6611"]
+	codeExchangesMux.Unlock()
+	if hanging != nil {
+		<-hanging.done
+	}
+	if expired != nil {
+		t.Error("successful exchange was kept after CodeExchangeTTL")
+	}
+}
+
+func TestManualLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/me/" || r.Header.Get("Authorization") != "Bearer permanent-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"detail": "Invalid token."}`)
+			return
+		}
+		fmt.Fprint(w, `{"user": {"id": 53917, "email": "jana@example.com"}}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	TasksMux.Lock()
+	Tasks[616], Tasks[617] = map[string]*Task{}, map[string]*Task{}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 616)
+		delete(Tasks, 617)
+		TasksMux.Unlock()
+	}()
+	loginTasks := func(appID int) []*Task {
+		TasksMux.Lock()
+		defer TasksMux.Unlock()
+		var tasks []*Task
+		for _, task := range Tasks[appID] {
+			if task.TaskType == "login" {
+				tasks = append(tasks, task)
+			}
+		}
+		return tasks
+	}
+
+	tests := []struct {
+		name    string
+		apiKey  string
+		wantErr string
+	}{
+		{"invalid key", "mistyped-key", "API key was not accepted"},
+		{"valid key", "permanent-key", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			go ManualLogin(MinimalTaskData{AppID: 616, APIKey: tt.apiKey})
+			timeout := time.After(5 * time.Second)
+			for { // tasks of other apps are skipped, thumbnails of earlier tests may still finish
+				select {
+				case e := <-TaskErrorCh:
+					if e.AppID != 616 {
+						continue
+					}
+					if tt.wantErr == "" || !strings.Contains(e.Error.Error(), tt.wantErr) || e.ErrorCode != ErrCodeLoginRequired {
+						t.Errorf("error = %v (%s), expected %q", e.Error, e.ErrorCode, tt.wantErr)
+					}
+				case f := <-TaskFinishCh:
+					if f.AppID != 616 {
+						continue
+					}
+					if tt.wantErr != "" {
+						t.Errorf("manual login finished with %q, expected error %q", f.Message, tt.wantErr)
+					}
+				case <-timeout:
+					t.Fatal("manual login did not finish")
+				}
+				return
+			}
+		})
+	}
+
+	for _, appID := range []int{616, 617} {
+		tasks := loginTasks(appID)
+		if len(tasks) != 1 {
+			t.Fatalf("app %d got %d login tasks, expected 1", appID, len(tasks))
+		}
+		result := tasks[0].Result.(map[string]interface{})
+		if result["access_token"] != "permanent-key" || result["refresh_token"] != "" || result["profile"] == nil || tasks[0].Status != "finished" {
+			t.Errorf("app %d got login task %s with result %v", appID, tasks[0].Status, result)
+		}
+	}
+}
+
+func TestRefreshTokenSingleFlight(t *testing.T) {
+	var mux sync.Mutex
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		token := r.PostForm.Get("refresh_token")
+		mux.Lock()
+		calls[token]++
+		first := calls[token] == 1
+		mux.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		if token != "refresh-618" || !first { // server invalidates the refresh token on first use
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "new-access", "refresh_token": "new-refresh", "expires_in": 3600}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	apps := []int{6181, 6182, 6183, 6184}
+	TasksMux.Lock()
+	for _, appID := range apps {
+		Tasks[appID] = map[string]*Task{}
+	}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		for _, appID := range apps {
+			delete(Tasks, appID)
+		}
+		TasksMux.Unlock()
+	}()
+	loginStatuses := func(appID int) []string {
+		TasksMux.Lock()
+		defer TasksMux.Unlock()
+		var statuses []string
+		for _, task := range Tasks[appID] {
+			statuses = append(statuses, task.Status)
+		}
+		Tasks[appID] = map[string]*Task{}
+		return statuses
+	}
+
+	var wg sync.WaitGroup
+	for _, appID := range apps[:3] {
+		wg.Add(1)
+		go func(appID int) {
+			defer wg.Done()
+			RefreshToken(RefreshTokenData{MinimalTaskData: MinimalTaskData{AppID: appID}, RefreshToken: "refresh-618"})
+		}(appID)
+	}
+	wg.Wait()
+	RefreshToken(RefreshTokenData{MinimalTaskData: MinimalTaskData{AppID: apps[3]}, RefreshToken: "refresh-618"}) // seconds later, within grace period
+
+	if calls["refresh-618"] != 1 {
+		t.Errorf("server got %d refreshes, expected 1", calls["refresh-618"])
+	}
+	for _, appID := range apps {
+		for _, status := range loginStatuses(appID) {
+			if status != "finished" {
+				t.Errorf("app %d got login task %s, expected only finished", appID, status)
+			}
+		}
+	}
+
+	RefreshToken(RefreshTokenData{MinimalTaskData: MinimalTaskData{AppID: apps[0]}, RefreshToken: "revoked"})
+	RefreshToken(RefreshTokenData{MinimalTaskData: MinimalTaskData{AppID: apps[0]}, RefreshToken: "revoked"})
+	if calls["revoked"] != 2 {
+		t.Errorf("failed refresh was reused, server got %d refreshes, expected 2", calls["revoked"])
+	}
+	if statuses := loginStatuses(apps[1]); len(statuses) != 2 || statuses[0] != "error" {
+		t.Errorf("failed refreshes delivered %v to other app, expected two errors", statuses)
+	}
+}
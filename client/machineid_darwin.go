@@ -0,0 +1,40 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+var platformUUIDRegex = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// readMachineID returns the hardware UUID of the Mac.
+func readMachineID() (string, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", err
+	}
+	match := platformUUIDRegex.FindSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("IOPlatformUUID not found")
+	}
+	return string(match[1]), nil
+}
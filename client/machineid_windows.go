@@ -0,0 +1,43 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"syscall"
+)
+
+var machineGUIDRegex = regexp.MustCompile(`MachineGuid\s+REG_SZ\s+(\S+)`)
+
+// readMachineID returns the MachineGuid generated by Windows during installation.
+func readMachineID() (string, error) {
+	cmd := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	match := machineGUIDRegex.FindSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("MachineGuid not found")
+	}
+	return string(match[1]), nil
+}
@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -30,11 +31,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/blenderkit/blenderkit/client/internal/api"
 	"github.com/google/uuid"
 	"github.com/gookit/color"
 )
@@ -68,9 +72,13 @@ const (
 
 var (
 	ClientVersion = "0.0.0" // Version of this BlenderKit-client binary, set from file client/VERSION with -ldflags during build in dev.py
-	SystemID      *string   // Unique ID of the current system (string of 15 integers)
+	SystemID      *string   // Unique ID of the current system (string of 15 integers), nil until resolved by systemID()
 	Port          *string
-	Server        *string
+
+	DefaultAddonVersion string // Version of the add-on which started the Client, for requests whose task data has none
+
+	UserAgent       string // Overrides the User-Agent sent to the server, for debugging
+	AddonModuleName string // Module name of the add-on, tells extension and legacy installs apart in server logs
 
 	OAuth2Sessions    map[string]OAuth2VerificationData // Map of OAuth2 sessions, key is the state string
 	OAuth2SessionsMux sync.Mutex
@@ -81,14 +89,13 @@ var (
 	ActiveAppsMux sync.Mutex
 	ActiveApps    []int
 
-	Tasks                map[int]map[string]*Task
-	TasksMux             sync.Mutex
-	AddTaskCh            chan *Task
-	TaskProgressUpdateCh chan *TaskProgressUpdate
-	TaskMessageCh        chan *TaskMessageUpdate
-	TaskFinishCh         chan *TaskFinish
-	TaskErrorCh          chan *TaskError
-	TaskCancelCh         chan *TaskCancel
+	Tasks        map[int]map[string]*Task
+	TasksMux     sync.Mutex
+	AddTaskCh    chan *Task // send with sendTask, progress and messages go through sendTaskProgress and sendTaskMessage
+	TaskFinishCh chan *TaskFinish
+	TaskErrorCh  chan *TaskError
+	TaskCancelCh chan *TaskCancel
+	TaskQueuedCh chan *TaskQueued
 
 	ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs *http.Client
 
@@ -97,15 +104,9 @@ var (
 )
 
 func init() {
-	SystemID = getSystemID()
 	OAuth2Sessions = make(map[string]OAuth2VerificationData)
 	Tasks = make(map[int]map[string]*Task)
-	AddTaskCh = make(chan *Task, 1000)
-	TaskProgressUpdateCh = make(chan *TaskProgressUpdate, 1000)
-	TaskMessageCh = make(chan *TaskMessageUpdate, 1000)
-	TaskFinishCh = make(chan *TaskFinish, 1000)
-	TaskCancelCh = make(chan *TaskCancel, 1000)
-	TaskErrorCh = make(chan *TaskError, 1000)
+	makeTaskChannels(TaskChannelCapacity)
 
 	BKLog = log.New(os.Stdout, "⬡  ", log.LstdFlags)   // Hexagon like BlenderKit logo
 	ChanLog = log.New(os.Stdout, "<- ", log.LstdFlags) // Same symbols as channel in Go
@@ -113,52 +114,35 @@ func init() {
 
 // Endless loop to handle channels
 func handleChannels() {
+	handleChannelsUntil(nil)
+}
+
+// handleChannelsUntil handles the channels until done is closed, with nil done it never returns.
+func handleChannelsUntil(done <-chan struct{}) {
 	for {
 		select {
+		case <-done:
+			return
 		case task := <-AddTaskCh:
-			TasksMux.Lock()
-			if Tasks[task.AppID] == nil {
-				BKLog.Printf("%s Unexpected: AppID %d not in Tasks! Add-on should first make report requst, then shedule tasks, fix this!", EmoWarning, task.AppID)
-				data := MinimalTaskData{AppID: task.AppID}
-				SubscribeNewApp(data)
-			}
-			Tasks[task.AppID][task.TaskID] = task
-			TasksMux.Unlock()
-			// Task can be created directly with status "finished" or "error"
-			if task.Status == "error" {
-				ChanLog.Printf("%s %s (%s): %v\n", EmoError, task.TaskType, task.TaskID, task.Error)
-			}
-			if task.Status == "finished" {
-				ChanLog.Printf("%s %s (%s)\n", EmoOK, task.TaskType, task.TaskID)
-			}
-		case u := <-TaskProgressUpdateCh:
-			if u.Message != "" {
-				ChanLog.Printf("%s progress on task %s (%d) - %d%%: %s\n", EmoUpdate, u.TaskID, u.AppID, u.Progress, u.Message)
-			} else {
-				ChanLog.Printf("%s progress on task %s (%d) - %d%%\n", EmoUpdate, u.TaskID, u.AppID, u.Progress)
-			}
-			TasksMux.Lock()
-			task := Tasks[u.AppID][u.TaskID]
-			task.Progress = u.Progress
-			if u.Message != "" {
-				task.Message = u.Message
-			}
-			if u.MessageDetailed != "" {
-				task.MessageDetailed = u.MessageDetailed
-			}
-			TasksMux.Unlock()
-		case m := <-TaskMessageCh:
-			TasksMux.Lock()
-			task := Tasks[m.AppID][m.TaskID]
-			task.Message = m.Message
-			if m.MessageDetailed != "" {
-				task.MessageDetailed = m.MessageDetailed
-			}
-			TasksMux.Unlock()
-			ChanLog.Printf("%s %s (%s): %s\n", EmoInfo, task.TaskType, task.TaskID, m.Message)
+			addTask(task)
+		case <-TaskUpdates.ready:
+			drainAddedTasks()
+			applyTaskUpdates()
+		case <-serverStatusReady:
+			drainAddedTasks()
+			publishServerStatus()
 		case f := <-TaskFinishCh:
+			drainAddedTasks()
+			applyTaskUpdates()
+			TaskJournal.Remove(f.TaskID)
+			resultBytes := estimateResultBytes(f.Result)
 			TasksMux.Lock()
 			task := Tasks[f.AppID][f.TaskID]
+			if task == nil {
+				TasksMux.Unlock()
+				ChanLog.Printf("%s ignored finish of unknown task %s of app %d\n", EmoWarning, f.TaskID, f.AppID)
+				continue
+			}
 			task.Status = "finished"
 			task.Result = f.Result
 			if f.Message != "" {
@@ -167,97 +151,225 @@ func handleChannels() {
 			if f.MessageDetailed != "" {
 				task.MessageDetailed = f.MessageDetailed
 			}
+			markCompleted(task, resultBytes)
 			TasksMux.Unlock()
-			ChanLog.Printf("%s %s (%s)\n", EmoOK, task.TaskType, task.TaskID)
+			ChanLog.Printf("%s %s (%s)\n", EmoOK, task.TaskType, task.LogID())
 		case e := <-TaskErrorCh:
+			drainAddedTasks()
+			applyTaskUpdates()
+			TaskJournal.Remove(e.TaskID)
+			resultBytes := estimateResultBytes(e.Result)
 			TasksMux.Lock()
 			task := Tasks[e.AppID][e.TaskID]
+			if task == nil { // error came before the task was added, e.g. panic recovered by runTask
+				if Tasks[e.AppID] == nil {
+					TasksMux.Unlock()
+					ChanLog.Printf("%s ignored error of task %s of unknown app %d: %v\n", EmoWarning, e.TaskID, e.AppID, e.Error)
+					continue
+				}
+				task = NewTask(nil, e.AppID, e.TaskID, "unknown")
+				Tasks[e.AppID][e.TaskID] = task
+			}
 			if task.Status == "cancelled" {
 				delete(Tasks[e.AppID], e.TaskID)
 				TasksMux.Unlock()
-				ChanLog.Printf("%s ignored on %s (%s): %s, task in cancelled status\n", EmoCancel, task.TaskType, task.TaskID, e.Error)
+				ChanLog.Printf("%s ignored on %s (%s): %s, task in cancelled status\n", EmoCancel, task.TaskType, task.LogID(), e.Error)
+				continue
+			}
+			if task.ErrorCode == ErrCodeTaskTimeout { // error caused by cancel of the reaper, task already reports the timeout
+				TasksMux.Unlock()
+				ChanLog.Printf("%s ignored on %s (%s): %s, task timed out\n", EmoCancel, task.TaskType, task.LogID(), e.Error)
 				continue
 			}
 			task.Message = fmt.Sprintf("%v", e.Error)
 			if e.Result != nil {
 				task.Result = e.Result
+			} else {
+				resultBytes = task.ResultBytes // of the result the task was added with
 			}
 			if e.MessageDetailed != "" {
 				task.MessageDetailed = e.MessageDetailed
 			}
+			task.ErrorCode = e.ErrorCode
+			if task.ErrorCode == "" {
+				task.ErrorCode = clockSkewErrorCode(e.Error)
+			}
+			if task.ErrorCode == "" {
+				task.ErrorCode = apiKeyErrorCode(e.Error)
+			}
 			task.Status = "error"
+			markCompleted(task, resultBytes)
 			TasksMux.Unlock()
-			ChanLog.Printf("%s in %s (%s): %v\n", EmoError, task.TaskType, task.TaskID, e.Error)
+			ChanLog.Printf("%s in %s (%s): %v\n", EmoError, task.TaskType, task.LogID(), e.Error)
+		case q := <-TaskQueuedCh:
+			drainAddedTasks()
+			applyTaskUpdates()
+			TasksMux.Lock()
+			task := Tasks[q.AppID][q.TaskID]
+			if task == nil {
+				TasksMux.Unlock()
+				ChanLog.Printf("%s ignored queueing of unknown task %s of app %d\n", EmoWarning, q.TaskID, q.AppID)
+				continue
+			}
+			task.Status = "queued"
+			task.Message = q.Message
+			task.Updated = time.Now()
+			TasksMux.Unlock()
+			ChanLog.Printf("%s %s (%s): %s\n", EmoNetwork, task.TaskType, task.LogID(), q.Message)
 		case k := <-TaskCancelCh:
+			drainAddedTasks()
+			applyTaskUpdates()
+			TaskJournal.Remove(k.TaskID)
 			TasksMux.Lock()
 			task := Tasks[k.AppID][k.TaskID]
+			if task == nil {
+				TasksMux.Unlock()
+				ChanLog.Printf("%s ignored cancel of unknown task %s of app %d\n", EmoWarning, k.TaskID, k.AppID)
+				continue
+			}
 			task.Status = "cancelled"
 			task.Cancel()
 			TasksMux.Unlock()
-			ChanLog.Printf("%s %s (%s), reason: %s\n", EmoCancel, task.TaskType, task.TaskID, k.Reason)
+			ChanLog.Printf("%s %s (%s), reason: %s\n", EmoCancel, task.TaskType, task.LogID(), k.Reason)
+		}
+	}
+}
+
+// addTask puts the task from AddTaskCh into Tasks, from then on it is changed only under TasksMux.
+func addTask(task *Task) {
+	resultBytes := estimateResultBytes(task.Result)
+	TasksMux.Lock()
+	if Tasks[task.AppID] == nil {
+		BKLog.Printf("%s Unexpected: AppID %d not in Tasks! Add-on should first make report requst, then shedule tasks, fix this!", EmoWarning, task.AppID)
+		data := MinimalTaskData{AppID: task.AppID}
+		SubscribeNewApp(data)
+	}
+	if task.Created.IsZero() {
+		task.Created = time.Now()
+	}
+	Tasks[task.AppID][task.TaskID] = task
+	task.ResultBytes = resultBytes
+	if isTaskCompleted(task.Status) {
+		markCompleted(task, resultBytes)
+	}
+	TasksMux.Unlock()
+	// Task can be created directly with status "finished" or "error"
+	if task.Status == "error" {
+		ChanLog.Printf("%s %s (%s): %v\n", EmoError, task.TaskType, task.LogID(), task.Error)
+	}
+	if task.Status == "finished" {
+		ChanLog.Printf("%s %s (%s)\n", EmoOK, task.TaskType, task.LogID())
+	}
+}
+
+// drainAddedTasks adds the tasks waiting in AddTaskCh before an update is applied. Select picks ready channels at random,
+// so without it an update sent right after the task could come first and be dropped as update of an unknown task.
+func drainAddedTasks() {
+	for {
+		select {
+		case task := <-AddTaskCh:
+			addTask(task)
+		default:
+			return
 		}
 	}
 }
 
 func main() {
 	Port = flag.String("port", "62485", "port to listen on")
-	Server = flag.String("server", server_default, "server to connect to")
+	server := flag.String("server", server_default, "server to connect to")
 	ssl_context := flag.String("ssl_context", "DEFAULT", "SSL context to use") // possible values: "DEFAULT", "PRECONFIGURED", "DISABLED"
 	proxy_which := flag.String("proxy_which", "SYSTEM", "proxy to use")        // possible values: "SYSTEM", "NONE", "CUSTOM"
 	proxy_address := flag.String("proxy_address", "", "proxy address")
+	flag.StringVar(&ProxyBypass, "proxy_bypass", "", "hosts connected directly even when proxy is set, in NO_PROXY format, e.g. *.s3.amazonaws.com")
+	flag.StringVar(&ProxyPACURL, "proxy_pac_url", "", "URL of proxy auto-configuration script used with proxy_which=PAC")
 	trusted_ca_certs := flag.String("trusted_ca_certs", "", "trusted CA certificates")
-	addon_version := flag.String("version", "", "addon version")
+	flag.StringVar(&DefaultAddonVersion, "version", "", "addon version")
+	flag.StringVar(&AddonModuleName, "addon_module_name", "", "module name of the add-on, sent as X-BK-Addon header")
+	flag.StringVar(&UserAgent, "user_agent", "", "override the User-Agent sent to the server, for debugging")
+	traceRequests := flag.Bool("trace_requests", false, "log DNS/connect/TLS/first byte timings of traced requests")
+	flag.IntVar(&APIMaxIdleConnsPerHost, "api_max_idle_conns", APIMaxIdleConnsPerHost, "max idle connections per host kept by API, download and upload clients")
+	flag.IntVar(&SmallThumbsMaxIdleConnsPerHost, "small_thumbs_max_conns", SmallThumbsMaxIdleConnsPerHost, "max connections per host of the small thumbnails client")
+	flag.IntVar(&BigThumbsMaxIdleConnsPerHost, "big_thumbs_max_conns", BigThumbsMaxIdleConnsPerHost, "max connections per host of the full thumbnails client")
+	flag.DurationVar(&IdleConnTimeout, "idle_conn_timeout", IdleConnTimeout, "how long idle connections are kept open for reuse")
+	thumbnailCacheCapMB := flag.Int64("thumbnail_cache_cap_mb", ThumbnailCacheCap.Load()/1024/1024, "max size of downloaded thumbnails in MB, older are pruned daily")
+	gravatarCacheCapMB := flag.Int64("gravatar_cache_cap_mb", GravatarCacheCap.Load()/1024/1024, "max size of downloaded author avatars in MB, older are pruned daily")
+	wrapperMaxResponseMB := flag.Int64("wrapper_max_response_mb", WrapperMaxResponseSize/1024/1024, "max size in MB of responses returned by the request wrappers")
+	flag.BoolVar(&BkclientjsAnyOrigin, "bkclientjs_any_origin", false, "allow websites on any origin to call /bkclientjs endpoints, for testing local frontends")
+	flag.BoolVar(&KeepUploadTemp, "keep_upload_temp", false, "keep packed blend and data.json of uploads in the export temp dir, for debugging")
+	flag.IntVar(&DownloadConcurrency, "download_concurrency", DownloadConcurrency, "how many asset downloads of one Blender instance run at once, others are queued")
+	flag.IntVar(&SearchPageSize, "search_page_size", SearchPageSize, "page size of searches sent as structured query without page_size")
+	flag.IntVar(&TaskChannelCapacity, "task_channel_capacity", TaskChannelCapacity, "buffer size of the task channels, see blocked_sends in /metrics")
+	flag.Float64Var(&TimeoutMultiplier, "timeout_multiplier", TimeoutMultiplier, "multiplier of the default HTTP client timeouts, raise for very slow connections")
 	flag.Parse()
+	setServer(*server)
+	TraceRequests.Store(*traceRequests)
+	ThumbnailCacheCap.Store(*thumbnailCacheCapMB * 1024 * 1024)
+	GravatarCacheCap.Store(*gravatarCacheCapMB * 1024 * 1024)
+	WrapperMaxResponseSize = *wrapperMaxResponseMB * 1024 * 1024
+	if DownloadConcurrency < 1 {
+		DownloadConcurrency = 1
+	}
+	if SearchPageSize < 1 {
+		SearchPageSize = 1
+	}
+	if TaskChannelCapacity < 1 {
+		TaskChannelCapacity = 1
+	}
+	makeTaskChannels(TaskChannelCapacity)
 	fmt.Print("\n\n")
-	BKLog.Printf("BlenderKit-Client v%s starting from add-on v%s\n   port=%s\n   server=%s\n   proxy_which=%s\n   proxy_address=%s\n   trusted_ca_certs=%s\n   ssl_context=%s",
-		ClientVersion, *addon_version, *Port, *Server, *proxy_which, *proxy_address, *trusted_ca_certs, *ssl_context)
+	BKLog.Printf("BlenderKit-Client v%s starting from add-on v%s\n   port=%s\n   server=%s\n   proxy_which=%s\n   proxy_address=%s\n   proxy_pac_url=%s\n   proxy_bypass=%s\n   trusted_ca_certs=%s\n   ssl_context=%s",
+		ClientVersion, DefaultAddonVersion, *Port, currentServer(), *proxy_which, *proxy_address, ProxyPACURL, ProxyBypass, *trusted_ca_certs, *ssl_context)
+
+	if running := checkRunningClient(*Port); running != nil {
+		BKLog.Printf("%s BlenderKit-Client v%s already running (pid %d) on port %s, exiting", EmoInfo, running.Version, running.PID, *Port)
+		os.Exit(ExitAlreadyRunning)
+	}
 
 	CreateHTTPClients(*proxy_address, *proxy_which, *ssl_context, *trusted_ca_certs)
+	if _, err := ResolveTempPath(); err != nil {
+		BKLog.Printf("%s Failed to resolve temp path: %v", EmoWarning, err)
+	}
+	if err := LoadOfflineQueue(); err != nil {
+		BKLog.Printf("%s Failed to load offline queue: %v", EmoWarning, err)
+	}
+	if err := LoadThumbnailSources(); err != nil {
+		BKLog.Printf("%s Failed to load thumbnail index: %v", EmoWarning, err)
+	}
+	if err := LoadTaskJournal(); err != nil {
+		BKLog.Printf("%s Failed to load task journal: %v", EmoWarning, err)
+	}
+	if err := LoadUploadHistory(); err != nil {
+		BKLog.Printf("%s Failed to load upload history: %v", EmoWarning, err)
+	}
+	if err := LoadSearchHistory(); err != nil {
+		BKLog.Printf("%s Failed to load search history: %v", EmoWarning, err)
+	}
+	if err := LoadUploadSpeed(); err != nil {
+		BKLog.Printf("%s Failed to load upload speed: %v", EmoWarning, err)
+	}
+	if err := LoadAuthorsSeen(); err != nil {
+		BKLog.Printf("%s Failed to load author cache: %v", EmoWarning, err)
+	}
+	if err := LoadDisclaimersSeen(); err != nil {
+		BKLog.Printf("%s Failed to load seen disclaimers: %v", EmoWarning, err)
+	}
+	if err := LoadResponseCache(); err != nil {
+		BKLog.Printf("%s Failed to prepare response cache: %v", EmoWarning, err)
+	}
+	explicitFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicitFlags[f.Name] = true })
+	if err := LoadClientSettings(explicitFlags); err != nil {
+		BKLog.Printf("%s Failed to load client settings: %v", EmoWarning, err)
+	}
 	go monitorReportAccess()
 	go handleChannels()
+	go watchConnectivity()
+	go monitorThumbnailCache()
+	go monitorGravatarCache()
+	go monitorStaleTasks()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", indexHandler)
-	mux.HandleFunc("/report", reportHandler)
-	mux.HandleFunc("/shutdown", shutdownHandler)
-	mux.HandleFunc("/debug", DebugNetworkHandler)
-
-	// LOGIN
-	mux.HandleFunc("/consumer/exchange/", consumerExchangeHandler)
-	mux.HandleFunc("/refresh_token", RefreshTokenHandler)
-	mux.HandleFunc("/oauth2/verification_data", OAuth2VerificationDataHandler)
-	mux.HandleFunc("/oauth2/logout", OAuth2LogoutHandler)
-
-	// BLENDER SPECIFIC HANDLERS
-	mux.HandleFunc("/blender/unsubscribe_addon", blenderUnsubscribeAddonHandler)
-	mux.HandleFunc("/blender/cancel_download", CancelDownloadHandler)
-	mux.HandleFunc("/blender/asset_download", assetDownloadHandler)
-	mux.HandleFunc("/blender/asset_search", assetSearchHandler)
-	mux.HandleFunc("/blender/asset_upload", assetUploadHandler)
-
-	// API HANDLERS
-	mux.HandleFunc("/profiles/download_gravatar_image", DownloadGravatarImageHandler)
-	mux.HandleFunc("/profiles/get_user_profile", GetUserProfileHandler)
-
-	mux.HandleFunc("/comments/get_comments", GetCommentsHandler)
-	mux.HandleFunc("/comments/create_comment", CreateCommentHandler)
-	mux.HandleFunc("/comments/feedback_comment", FeedbackCommentHandler)
-	mux.HandleFunc("/comments/mark_comment_private", MarkCommentPrivateHandler)
-
-	mux.HandleFunc("/notifications/mark_notification_read", MarkNotificationReadHandler)
-
-	mux.HandleFunc("/ratings/get_bookmarks", GetBookmarksHandler)
-	mux.HandleFunc("/ratings/get_rating", GetRatingHandler)
-	mux.HandleFunc("/ratings/send_rating", SendRatingHandler)
-
-	// WRAPPERS
-	mux.HandleFunc("/wrappers/get_download_url", GetDownloadURLWrapper)
-	mux.HandleFunc("/wrappers/complete_upload_file_blocking", CompleteUploadFileBlocking)
-	mux.HandleFunc("/wrappers/blocking_file_download", BlockingFileDownloadHandler)
-	mux.HandleFunc("/wrappers/blocking_request", BlockingRequestHandler)
-	mux.HandleFunc("/wrappers/nonblocking_request", NonblockingRequestHandler)
-
-	StartClient(mux)
+	StartClient(newRouter(clientRoutes()))
 }
 
 // Start Client server on localhost, if this address cannot be used then it falls back to IPv4 127.0.0.1.
@@ -295,9 +407,48 @@ func monitorReportAccess() {
 	}
 }
 
+// IndexStatus is the JSON answer of the index to Accept: application/json, a lighter liveness probe than /report.
+type IndexStatus struct {
+	PID                int    `json:"pid"`
+	Version            string `json:"version"`
+	Port               string `json:"port"`
+	ConnectedSoftwares int    `json:"connected_softwares"`
+}
+
+// indexHandler responds with PID of the Client, browsers get a page with links for debugging
+// and Accept: application/json gets IndexStatus. HEAD gets only the headers.
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 	pid := os.Getpid()
-	fmt.Fprintf(w, "%d", pid)
+	w.Header().Set(ClientVersionHeader, ClientVersion)
+	accept := r.Header.Get("Accept")
+	html := strings.Contains(accept, "text/html")
+	jsonStatus := !html && strings.Contains(accept, "application/json")
+	switch {
+	case html:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case jsonStatus:
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch {
+	case html:
+		fmt.Fprintf(w, `<!DOCTYPE html><html><body><h1>BlenderKit-Client v%s</h1><p>PID %d</p><ul><li><a href="/tasks?all=true&amp;format=html">Tasks of all apps</a></li><li><a href="/debug">Network debug</a></li></ul></body></html>`, ClientVersion, pid)
+	case jsonStatus:
+		writeJSON(w, IndexStatus{
+			PID:                pid,
+			Version:            ClientVersion,
+			Port:               *Port,
+			ConnectedSoftwares: len(availableSoftwares()),
+		})
+	default:
+		fmt.Fprintf(w, "%d", pid)
+	}
 }
 
 func shutdownHandler(w http.ResponseWriter, r *http.Request) {
@@ -306,63 +457,93 @@ func shutdownHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func reportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead { // probe of monitoring, neither keeps the Client alive nor serializes tasks
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	lastReportAccessMux.Lock()
 	lastReportAccess = time.Now()
 	lastReportAccessMux.Unlock()
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer r.Body.Close()
-
 	var data MinimalTaskData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		BKLog.Println("Error parsing ReportData:", err)
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		BKLog.Printf("%s Error parsing ReportData", EmoWarning)
 		return
 	}
 
 	if data.AddonVersion == "" {
 		msg := fmt.Sprintf("BlenderKit-Client running on port %s", *Port)
 		BKLog.Printf("%v Add-on (probably v3.11 or less) requesting /report rejected.", EmoWarning)
-		http.Error(w, msg, http.StatusForbidden) // 403
+		writeJSONError(w, http.StatusForbidden, ErrCodeForbidden, msg) // 403
+		return
+	}
+	if err := validateAppID(data.AppID); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
+	registerSoftware(data)
+	tempPathStatus, tempPathErr := ResolveTempPath()
 	TasksMux.Lock()
 	if Tasks[data.AppID] == nil { // New add-on connected
 		SubscribeNewApp(data)
 	}
+	emitRestoredTasks(data)
 
 	taskID := uuid.New().String()
 	reportTask := NewTask(nil, data.AppID, taskID, "client_status")
 	reportTask.Finish("Client is running")
+	status := map[string]interface{}{}
+	if tempPathErr == nil {
+		status["temp_path"] = tempPathStatus
+	}
+	if quality := APILatency.quality(time.Now()); quality != nil {
+		status["api_quality"] = quality
+	}
+	if len(status) > 0 {
+		reportTask.Result = status
+	}
 
 	toReport := make([]*Task, 0, len(Tasks[data.AppID]))
 	toReport = append(toReport, reportTask)
+	var completed []*Task
 	for _, task := range Tasks[data.AppID] {
 		if task.AppID != data.AppID {
 			continue
 		}
+		if isTaskCompleted(task.Status) {
+			completed = append(completed, task)
+			continue
+		}
+		toReport = append(toReport, task)
+	}
+	reported, deferred := splitReport(completed)
+	pruned := make(map[string]bool)
+	for _, task := range reported {
 		toReport = append(toReport, task)
-		if task.Status == "finished" || task.Status == "error" {
+		delete(Tasks[data.AppID], task.TaskID)
+		pruned[task.TaskID] = true
+	}
+	if len(deferred) > 0 {
+		TaskResults.Deferred.Add(int64(len(deferred)))
+		BKLog.Printf("%s Report of app %d over %s, %d completed tasks left for the next report", EmoInfo, data.AppID, formatBytes(int64(MaxReportResultBytes)), len(deferred))
+	}
+	delete(retentionWarned, data.AppID)
+	for _, task := range Tasks[data.AppID] { // sub-tasks go with their parent, also those which never started
+		if parentID := task.ParentTaskID(); parentID != "" && pruned[parentID] {
 			delete(Tasks[data.AppID], task.TaskID)
 		}
 	}
+	report, err := json.Marshal(toReport) // under the lock, handleChannels changes the tasks
 	TasksMux.Unlock()
-
-	responseJSON, err := json.Marshal(toReport)
 	if err != nil {
-		http.Error(w, "Error converting to JSON: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "error encoding report: "+err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseJSON)
+	writeJSON(w, json.RawMessage(report))
 }
 
 // SubscribeNewApp adds new App into Tasks[AppID].
@@ -371,12 +552,12 @@ func SubscribeNewApp(data MinimalTaskData) {
 	BKLog.Printf("%s New add-on connected: %d", EmoNewConnection, data.AppID)
 	Tasks[data.AppID] = make(map[string]*Task)
 
-	go FetchDisclaimer(data)
-	go FetchCategories(data)
+	go runTask(data.AppID, "", func() { FetchDisclaimer(data) })
+	go runTask(data.AppID, "", func() { FetchCategories(data) })
 	if data.APIKey != "" {
-		go FetchUnreadNotifications(data)
-		go GetBookmarks(data)
-		go GetUserProfile(data)
+		go runTask(data.AppID, "", func() { FetchUnreadNotifications(data) })
+		go runTask(data.AppID, "", func() { GetBookmarks(data) })
+		go runTask(data.AppID, "", func() { GetUserProfile(data) })
 	}
 }
 
@@ -384,6 +565,31 @@ func (t *Task) Finish(message string) {
 	t.Status = "finished"
 	t.Message = message
 }
+
+// WithRequestID sets the request ID sent by the add-on. Empty requestID keeps the default, which is the task ID.
+func (t *Task) WithRequestID(requestID string) *Task {
+	if requestID != "" {
+		t.RequestID = requestID
+	}
+	return t
+}
+
+// ParentTaskID returns TaskID of the task this sub-task belongs to, empty if the task is not a sub-task.
+func (t *Task) ParentTaskID() string {
+	if data, ok := t.Data.(UploadFileTaskData); ok {
+		return data.ParentTaskID
+	}
+	return ""
+}
+
+// LogID identifies the task in log lines, request ID is included when the add-on provided its own.
+func (t *Task) LogID() string {
+	if t.RequestID == "" || t.RequestID == t.TaskID {
+		return t.TaskID
+	}
+	return fmt.Sprintf("%s, request %s", t.TaskID, t.RequestID)
+}
+
 func NewTask(data interface{}, appID int, taskID, taskType string) *Task {
 	if data == nil { // so it is not returned as None, but as empty dict{}
 		data = make(map[string]interface{})
@@ -393,6 +599,7 @@ func NewTask(data interface{}, appID int, taskID, taskType string) *Task {
 		Data:            data,
 		AppID:           appID,
 		TaskID:          taskID,
+		RequestID:       taskID,
 		TaskType:        taskType,
 		Message:         "",
 		MessageDetailed: "",
@@ -400,6 +607,7 @@ func NewTask(data interface{}, appID int, taskID, taskType string) *Task {
 		Status:          "created",
 		Result:          make(map[string]interface{}),
 		Error:           nil,
+		Created:         time.Now(),
 		Ctx:             ctx,
 		Cancel:          cancel,
 	}
@@ -407,9 +615,11 @@ func NewTask(data interface{}, appID int, taskID, taskType string) *Task {
 
 func blenderUnsubscribeAddonHandler(w http.ResponseWriter, r *http.Request) {
 	var data ReportData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 	BKLog.Printf("%s Add-on unsubscribed: %d", EmoDisconnecting, data.AppID)
@@ -422,6 +632,8 @@ func blenderUnsubscribeAddonHandler(w http.ResponseWriter, r *http.Request) {
 		delete(Tasks, data.AppID)
 	}
 	TasksMux.Unlock()
+	unregisterSoftware(data.AppID)
+	PathRoots.Remove(data.AppID)
 
 	if len(Tasks) == 0 {
 		BKLog.Printf("%s No add-ons left, shutting down...", EmoWarning)
@@ -437,409 +649,517 @@ func delayedExit(t float64) {
 }
 
 func assetSearchHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading search request body: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer r.Body.Close()
-
 	var data SearchTaskData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-
-	var rJSON map[string]interface{}
-	err = json.Unmarshal(body, &rJSON)
+	err := firstError(
+		validateAppID(data.AppID),
+		validateUTF8("urlquery", data.URLQuery),
+		validateUTF8("tempdir", data.TempDir),
+		validateUTF8("api_key", data.APIKey),
+		validateResultFields(data.ResultFields),
+		resolveSearchURL(&data),
+		resolveSearchTempDir(&data),
+	)
 	if err != nil {
-		fmt.Println(">> Error parsing JSON:", err)
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+		writeValidationError(w, err)
 		return
 	}
 
 	taskID := uuid.New().String()
-	go doAssetSearch(data, taskID)
-
-	resData := map[string]string{"task_id": taskID}
-	responseJSON, err := json.Marshal(resData)
-	if err != nil {
-		http.Error(w, "Error converting to JSON: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+	go runTask(data.AppID, taskID, func() { doAssetSearch(data, taskID) })
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseJSON)
+	writeJSON(w, map[string]string{"task_id": taskID})
 }
 
 func doAssetSearch(data SearchTaskData, taskUUID string) {
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "search")
-
-	req, err := http.NewRequest("GET", data.URLQuery, nil)
-	if err != nil {
-		err = fmt.Errorf("search - creating request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
+	if data.RequestID == "" { // thumbnail tasks inherit the request ID of the search
+		data.RequestID = taskUUID
 	}
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	task := NewTask(data, data.AppID, taskUUID, "search").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("search - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if searchResult, ok := takeSearchPrefetch(task.Ctx, data.AppID, data.URLQuery); ok {
+		BKLog.Printf("%s Search page served from prefetch: %s", EmoNetwork, data.URLQuery)
+		finishSearch(data, taskUUID, *searchResult, nil)
+		SearchHistory.Record(data, *searchResult)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("search: %s, status (%s), query: %v", respString, resp.Status, data.URLQuery)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	flight, leader := joinSearchFlight(data)
+	if leader {
+		go flight.run(data, task)
+	}
+	select {
+	case <-flight.done:
+	case <-task.Ctx.Done():
+		flight.leave()
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("search: %w", task.Ctx.Err())})
 		return
 	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("search: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if flight.err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: flight.err, MessageDetailed: flight.detailed})
 		return
 	}
+	finishSearch(data, taskUUID, flight.result, flight)
+	SearchHistory.Record(data, flight.result)
+}
 
+// decodeSearchResults decodes the body of successful search response.
+// Body which is not a complete search response is an error, so broken response never finishes as empty search.
+func decodeSearchResults(body io.Reader) (SearchResults, error) {
 	var searchResult SearchResults
-	if err := json.NewDecoder(resp.Body).Decode(&searchResult); err != nil {
-		err = fmt.Errorf("search - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
+	decoder := json.NewDecoder(body)
+	if err := decoder.Decode(&searchResult); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return searchResult, fmt.Errorf("unexpected response, field %q cannot be %s (offset %d)", typeErr.Field, typeErr.Value, typeErr.Offset)
+		}
+		return searchResult, err
 	}
+	if decoder.More() {
+		return searchResult, fmt.Errorf("unexpected data after the search response")
+	}
+	if searchResult.Results == nil {
+		return searchResult, fmt.Errorf("unexpected response, results are missing")
+	}
+	return searchResult, nil
+}
 
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Result: searchResult}
-	go parseThumbnails(searchResult, data)
+// finishSearch delivers the results and schedules their thumbnails, once per TempDir of the flight if the search was shared.
+func finishSearch(data SearchTaskData, taskUUID string, searchResult SearchResults, flight *searchFlight) {
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Result: searchTaskResult(searchResult, data)})
+	AuthorsSeen.Record(searchAuthorIDs(searchResult), time.Now())
+	AuthorProfiles.Remember(searchResult)
+	go flight.parseThumbnails(searchResult, data, taskUUID)
+	if !data.SkipGravatars {
+		go runTask(data.AppID, "", func() { fetchSearchGravatars(searchResult, data, taskUUID) })
+	}
+	if data.PrefetchNext && searchResult.NextURL != "" {
+		startSearchPrefetch(data, searchResult.NextURL)
+	}
 }
 
-func parseThumbnails(searchResults SearchResults, data SearchTaskData) {
+// parseThumbnails creates thumbnail tasks for the results of the search task and downloads them, returns once all are done.
+func parseThumbnails(searchResults SearchResults, data SearchTaskData, searchTaskID string) {
 	var smallThumbsTasks, fullThumbsTasks []*Task
 	blVer, _ := StringToBlenderVersion(data.BlenderVersion)
 
-	for i, result := range searchResults.Results { // TODO: Should be a function parseThumbnail() to avaid nesting
-		useWebp := false
-		if result.WebpGeneratedTimestamp > 0 {
-			useWebp = true
+	for i, result := range searchResults.Results {
+		smallThumbURL, fullThumbURL, fullThumbSize := thumbnailURLs(result, blVer)
+		if smallThumbURL == "" || fullThumbURL == "" {
+			logMissingThumbnail(result)
 		}
-		if blVer.Major < 3 || (blVer.Major == 3 && blVer.Minor < 4) {
-			useWebp = false
+		if smallThumbURL != "" {
+			smallThumbsTasks = append(smallThumbsTasks, newThumbnailTask(data, searchTaskID, result, i, "small", thumbnailSmall, smallThumbURL))
 		}
-
-		var smallThumbURL, fullThumbURL string
-		if useWebp {
-			smallThumbURL = result.ThumbnailSmallURLWebp
-			if result.AssetType == "hdr" {
-				fullThumbURL = result.ThumbnailLargeURLNonsquaredWebp
-			} else {
-				fullThumbURL = result.ThumbnailMiddleURLWebp
-			}
-		} else {
-			smallThumbURL = result.ThumbnailSmallURL
-			if result.AssetType == "hdr" {
-				fullThumbURL = result.ThumbnailLargeURLNonsquared
-			} else {
-				fullThumbURL = result.ThumbnailMiddleURL
-			}
+		if fullThumbURL != "" {
+			fullThumbsTasks = append(fullThumbsTasks, newThumbnailTask(data, searchTaskID, result, i, "full", fullThumbSize, fullThumbURL))
 		}
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		downloadImageBatch(smallThumbsTasks, true)
+	}()
+	go func() {
+		defer wg.Done()
+		downloadImageBatch(fullThumbsTasks, true)
+	}()
+	wg.Wait()
+}
+
+// newThumbnailTask creates the task downloading the thumbnail of the search result into the TempDir of the search.
+func newThumbnailTask(data SearchTaskData, searchTaskID string, result Asset, index int, thumbnailType, size, url string) *Task {
+	imgName, imgNameErr := ExtractFilenameFromURL(url)
+	taskData := DownloadThumbnailData{
+		AddonVersion:    data.AddonVersion,
+		PlatformVersion: data.PlatformVersion,
+		RequestID:       data.RequestID,
+		ThumbnailType:   thumbnailType,
+		ThumbnailSize:   size,
+		ImagePath:       filepath.Join(data.TempDir, imgName),
+		ImageURL:        url,
+		AssetBaseID:     result.AssetBaseID,
+		AssetType:       result.AssetType,
+		Index:           index,
+		SearchTaskID:    searchTaskID,
+	}
+	task := NewTask(taskData, data.AppID, uuid.New().String(), "thumbnail_download").WithRequestID(data.RequestID)
+	if imgNameErr != nil {
+		task.Error = fmt.Errorf("error extracting filename from URL: %v, for asset: %s", imgNameErr, result.DisplayName)
+	}
+	return task
+}
+
+// missingThumbnailsLogged holds base IDs of assets logged by logMissingThumbnail.
+var missingThumbnailsLogged sync.Map
+
+// logMissingThumbnail logs the asset without thumbnail URL in both formats, once per asset base ID.
+func logMissingThumbnail(result Asset) {
+	if _, logged := missingThumbnailsLogged.LoadOrStore(result.AssetBaseID, true); logged {
+		return
+	}
+	BKLog.Printf("%s Asset %s (%s) has no thumbnail URL, preview skipped", EmoWarning, result.DisplayName, result.AssetBaseID)
+}
+
+// Sizes of thumbnails, sent in thumbnail_size of the thumbnail task so the add-on knows which aspect ratio to expect.
+const (
+	thumbnailSmall           = "small"
+	thumbnailMiddle          = "middle"
+	thumbnailLargeNonsquared = "large_nonsquared"
+	thumbnailSmallNonsquared = "small_nonsquared"
+)
 
-		smallImgName, smallImgNameErr := ExtractFilenameFromURL(smallThumbURL)
-		smallImgPath := filepath.Join(data.TempDir, smallImgName)
-		smallTaskData := DownloadThumbnailData{
-			AddonVersion:  data.AddonVersion,
-			ThumbnailType: "small",
-			ImagePath:     smallImgPath,
-			ImageURL:      smallThumbURL,
-			AssetBaseID:   result.AssetBaseID,
-			Index:         i,
+// fullThumbnailSizes maps the asset type to the size of its full thumbnail, other types use thumbnailMiddle.
+var fullThumbnailSizes = map[string]string{
+	"model":    thumbnailMiddle,
+	"material": thumbnailMiddle,
+	"hdr":      thumbnailLargeNonsquared,
+	"scene":    thumbnailLargeNonsquared,
+	"brush":    thumbnailSmallNonsquared,
+}
+
+// thumbnailURLs returns URLs of small and full thumbnail of the asset and the size of the full one.
+// WEBP is used if the Blender supports it. If URL of the preferred format is missing, which happens on older assets
+// with webpGeneratedTimestamp but empty WEBP URLs, the other format is used. URL is empty only if both are missing.
+func thumbnailURLs(result Asset, blVer *BlenderVersion) (small, full, fullSize string) {
+	useWebp := result.WebpGeneratedTimestamp.Float64() > 0
+	if blVer == nil || blVer.Major < 3 || (blVer.Major == 3 && blVer.Minor < 4) {
+		useWebp = false
+	}
+
+	fullSize, ok := fullThumbnailSizes[result.AssetType]
+	if !ok {
+		fullSize = thumbnailMiddle
+	}
+	return thumbnailFallbackURL(result, thumbnailSmall, useWebp), thumbnailFallbackURL(result, fullSize, useWebp), fullSize
+}
+
+// thumbnailFallbackURL returns URL of the thumbnail of the size in the preferred format, or in the other one if missing.
+func thumbnailFallbackURL(result Asset, size string, webp bool) string {
+	if url := thumbnailSizeURL(result, size, webp); url != "" {
+		return url
+	}
+	return thumbnailSizeURL(result, size, !webp)
+}
+
+// thumbnailSizeURL returns URL of the thumbnail of the size, WEBP or not.
+func thumbnailSizeURL(result Asset, size string, webp bool) string {
+	switch size {
+	case thumbnailSmall:
+		if webp {
+			return result.ThumbnailSmallURLWebp
 		}
-		smallTaskUUID := uuid.New().String()
-		smallTask := NewTask(smallTaskData, data.AppID, smallTaskUUID, "thumbnail_download")
-		if smallImgNameErr != nil {
-			smallTask.Error = fmt.Errorf("error extracting filename from URL: %v, for asset: %s ", smallImgNameErr, result.DisplayName)
+		return result.ThumbnailSmallURL
+	case thumbnailLargeNonsquared:
+		if webp {
+			return result.ThumbnailLargeURLNonsquaredWebp
 		}
-		smallThumbsTasks = append(smallThumbsTasks, smallTask)
-
-		fullImgName, fullImgNameErr := ExtractFilenameFromURL(fullThumbURL)
-		fullImgPath := filepath.Join(data.TempDir, fullImgName)
-		fullTaskData := DownloadThumbnailData{
-			AddonVersion:  data.AddonVersion,
-			ThumbnailType: "full",
-			ImagePath:     fullImgPath,
-			ImageURL:      fullThumbURL,
-			AssetBaseID:   result.AssetBaseID,
-			Index:         i,
+		return result.ThumbnailLargeURLNonsquared
+	case thumbnailSmallNonsquared:
+		if webp {
+			return result.ThumbnailSmallURLNonsquaredWebp
 		}
-		fullTaskUUID := uuid.New().String()
-		fullTask := NewTask(fullTaskData, data.AppID, fullTaskUUID, "thumbnail_download")
-		if fullImgNameErr != nil {
-			fullTask.Error = fmt.Errorf("error extracting filename from URL: %v, for asset: %s", fullImgNameErr, result.DisplayName)
+		return result.ThumbnailSmallURLNonsquared
+	default:
+		if webp {
+			return result.ThumbnailMiddleURLWebp
 		}
-		fullThumbsTasks = append(fullThumbsTasks, fullTask)
+		return result.ThumbnailMiddleURL
 	}
-	go downloadImageBatch(smallThumbsTasks, true)
-	go downloadImageBatch(fullThumbsTasks, true)
 }
 
 func downloadImageBatch(tasks []*Task, block bool) {
 	wg := new(sync.WaitGroup)
 	for _, task := range tasks {
 		wg.Add(1)
-		go DownloadThumbnail(task, wg)
+		go runTask(task.AppID, task.TaskID, func() { DownloadThumbnail(task, wg) })
 	}
 	if block {
 		wg.Wait()
 	}
 }
 
+// DownloadThumbnail downloads the thumbnail of the task created by newThumbnailTask().
+// The task is added first and then changed only through TaskFinishCh and TaskErrorCh, like other tasks.
 func DownloadThumbnail(t *Task, wg *sync.WaitGroup) {
 	defer wg.Done()
-	if t.Error != nil { // error from ExtractFilenameFromURL() in parseThumbnails()
-		t.Status = "error"
-		AddTaskCh <- t
+	nameErr := t.Error // error from ExtractFilenameFromURL() in parseThumbnails(), read before the task is shared
+	t.Error = nil
+	sendTask(AddTaskCh, t)
+	fail := func(err error) {
+		sendTask(TaskErrorCh, &TaskError{AppID: t.AppID, TaskID: t.TaskID, Error: err})
+	}
+	if nameErr != nil {
+		fail(nameErr)
 		return
 	}
 
 	data, ok := t.Data.(DownloadThumbnailData)
 	if !ok {
-		t.Status = "error"
-		t.Error = fmt.Errorf("invalid data type")
-		AddTaskCh <- t
+		fail(fmt.Errorf("invalid data type"))
 		return
 	}
 
-	if _, err := os.Stat(data.ImagePath); err == nil {
-		t.Status = "finished"
-		t.Message = "thumbnail on disk"
-		AddTaskCh <- t
+	_, statErr := os.Stat(data.ImagePath)
+	onDisk := statErr == nil
+	source, stale := ThumbnailSources.isStale(data.ImagePath, data.ImageURL)
+	if onDisk && !stale {
+		sendTask(TaskFinishCh, &TaskFinish{AppID: t.AppID, TaskID: t.TaskID, Message: "thumbnail on disk", Result: thumbnailResult(data)})
 		return
 	}
 
 	req, err := http.NewRequest("GET", data.ImageURL, nil)
 	if err != nil {
-		t.Status = "error"
-		t.Error = err
-		AddTaskCh <- t
+		fail(err)
 		return
 	}
 
-	headers := getHeaders("", *SystemID, data.AddonVersion, data.PlatformVersion)
+	headers := getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: t.RequestID})
+	revalidate := onDisk && source.ETag != "" // URL changed, but the file may be the same
+	if revalidate {
+		headers.Set("If-None-Match", source.ETag)
+	}
 	req.Header = headers
-	resp, err := ClientBigThumbs.Do(req)
+	pool, client := thumbnailClient(data.ThumbnailType)
+	resp, err := client.Do(req)
 	if err != nil {
-		t.Message = "Error performing request to download thumbnail"
-		t.Status = "error"
-		t.Error = err
-		AddTaskCh <- t
+		fail(fmt.Errorf("Error performing request to download thumbnail: %w", err))
 		return
 	}
 	defer resp.Body.Close()
-	//MARK: TODO check status code
+	if revalidate && resp.StatusCode == http.StatusNotModified {
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			etag = source.ETag
+		}
+		ThumbnailSources.Set(data.ImagePath, ThumbnailSource{URL: data.ImageURL, ETag: etag})
+		sendTask(TaskFinishCh, &TaskFinish{AppID: t.AppID, TaskID: t.TaskID, Message: "thumbnail on disk is fresh", Result: thumbnailResult(data)})
+		return
+	}
 	if resp.StatusCode != http.StatusOK {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
-		t.Message = fmt.Sprintf("search: %s, status (%s), url: %v", respString, resp.Status, data.ImageURL)
-		t.Status = "error"
-		AddTaskCh <- t
+		fail(fmt.Errorf("search: %s, status (%s), url: %v", respString, resp.Status, data.ImageURL))
 		return
 	}
 
-	// Open the file for writing
-	file, err := os.Create(data.ImagePath)
-	if err != nil {
-		t.Message = "Error creating file for thumbnail"
-		t.Status = "error"
-		t.Error = err
-		AddTaskCh <- t
+	if err := saveToFile(data.ImagePath, resp.Body); err != nil {
+		fail(fmt.Errorf("Error saving thumbnail: %w", err))
 		return
 	}
-	defer file.Close()
+	ThumbnailSources.Set(data.ImagePath, ThumbnailSource{URL: data.ImageURL, ETag: resp.Header.Get("ETag")})
 
-	// Copy the response body to the file
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		t.Message = "Error copying thumbnail response body to file"
-		t.Status = "error"
-		t.Error = err
-		AddTaskCh <- t
-		return
+	ThumbnailPools.served(pool)
+	sendTask(TaskFinishCh, &TaskFinish{AppID: t.AppID, TaskID: t.TaskID, Message: "thumbnail downloaded", Result: thumbnailResult(data)})
+}
+
+// thumbnailClient returns the name of the pool and the client which downloads thumbnails of the type, small or full.
+func thumbnailClient(thumbnailType string) (string, *http.Client) {
+	if thumbnailType == "small" {
+		return "small", ClientSmallThumbs
 	}
+	return "full", ClientBigThumbs
+}
 
-	t.Status = "finished"
-	t.Message = "thumbnail downloaded"
-	AddTaskCh <- t
+// ThumbnailPoolMetrics counts thumbnails downloaded by each thumbnail client, shown by /metrics.
+type ThumbnailPoolMetrics struct {
+	Small atomic.Int64
+	Full  atomic.Int64
+}
+
+// ThumbnailPools counts thumbnails served by the small and full thumbnail clients.
+var ThumbnailPools = &ThumbnailPoolMetrics{}
+
+func (m *ThumbnailPoolMetrics) served(pool string) {
+	if pool == "small" {
+		m.Small.Add(1)
+	} else {
+		m.Full.Add(1)
+	}
 }
 
 // Fetch categories from the server: https://www.blenderkit.com/api/v1/categories/
 // API documentation: https://www.blenderkit.com/api/v1/docs/#operation/categories_list
 func FetchCategories(data MinimalTaskData) {
-	url := *Server + "/api/v1/categories"
+	url := currentServer() + "/api/v1/categories"
 	taskUUID := uuid.New().String()
-	task := NewTask(nil, data.AppID, taskUUID, "categories_update")
-	AddTaskCh <- task
+	task := NewTask(nil, data.AppID, taskUUID, "categories_update").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	headers := getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	headers := getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID})
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		err = fmt.Errorf("categories - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 
 	req.Header = headers
-	resp, err := ClientAPI.Do(req)
+	cached := ResponseCache.Get("categories", url)
+	cached.SetConditionalHeaders(req)
+	resp, err := doIdempotent(ClientAPI, req, task)
 	if err != nil {
 		err = fmt.Errorf("categories - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		var respData CategoriesData
+		if err := json.Unmarshal(cached.Body, &respData); err != nil {
+			ResponseCache.Remove("categories")
+			err = fmt.Errorf("categories - decoding cached response: %w", err)
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
+			return
+		}
+		sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Categories up to date", Result: newCategoriesResult(respData.Results)})
+		return
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("categories: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		respJSON, respString, _ := ParseFailedHTTPResponse(resp)
+		err := fmt.Errorf("categories failed: %s (%s)", ResponseDetail(respJSON, respString), resp.Status)
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err, MessageDetailed: respString})
 		return
 	}
 
 	err = RespIsJSON(resp)
 	if err != nil {
 		err = fmt.Errorf("categories: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("categories - reading response: %w", err)
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
+		return
+	}
 	var respData CategoriesData
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+	if err := json.Unmarshal(body, &respData); err != nil {
 		err = fmt.Errorf("categories - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
+	ResponseCache.Put("categories", url, resp.Header, body)
 
-	fix_category_counts(respData.Results)
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Categories updated", Result: newCategoriesResult(respData.Results)})
+}
 
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Categories updated", Result: respData.Results}
+// newCategoriesResult fixes the cumulative counts of the categories and indexes them by slug.
+func newCategoriesResult(categories []Category) CategoriesResult {
+	fix_category_counts(categories)
+	return CategoriesResult{Categories: categories, Index: categoryIndex(categories)}
 }
 
 // Fetch disclaimer from the server: https://www.blenderkit.com/api/v1/disclaimer/active/.
 // API documentation:  https://www.blenderkit.com/api/v1/docs/#operation/disclaimer_active_list
 func FetchDisclaimer(data MinimalTaskData) {
-	url := *Server + "/api/v1/disclaimer/active/"
+	url := currentServer() + "/api/v1/disclaimer/active/"
 	taskUUID := uuid.New().String()
-	task := NewTask(nil, data.AppID, taskUUID, "disclaimer")
-	AddTaskCh <- task
+	task := NewTask(nil, data.AppID, taskUUID, "disclaimer").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	headers := getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	headers := getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID})
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		err = fmt.Errorf("disclaimer - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 	req.Header = headers
-	resp, err := ClientAPI.Do(req)
+	cached := ResponseCache.Get("disclaimer", url)
+	cached.SetConditionalHeaders(req)
+	resp, err := doIdempotent(ClientAPI, req, task)
 	if err != nil {
 		err = fmt.Errorf("disclaimer - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		var respData DisclaimerData
+		if err := json.Unmarshal(cached.Body, &respData); err != nil {
+			ResponseCache.Remove("disclaimer")
+			err = fmt.Errorf("disclaimer - decoding cached response: %w", err)
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
+			return
+		}
+		sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Disclaimer up to date", Result: filterDisclaimers(respData, time.Now())})
+		return
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
 		err := fmt.Errorf("disclaimer: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 
 	err = RespIsJSON(resp)
 	if err != nil {
 		err = fmt.Errorf("disclaimer: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("disclaimer - reading response: %w", err)
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
+		return
+	}
 	var respData DisclaimerData
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+	if err := json.Unmarshal(body, &respData); err != nil {
 		err = fmt.Errorf("disclaimer - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err})
 		return
 	}
+	ResponseCache.Put("disclaimer", url, resp.Header, body)
 
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Disclaimer fetched", Result: respData}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Disclaimer fetched", Result: filterDisclaimers(respData, time.Now())})
 }
 
 // Fetch unread notifications from the server: https://www.blenderkit.com/api/v1/notifications/unread/.
 // API documentation: https://www.blenderkit.com/api/v1/docs/#operation/notifications_unread_list
 func FetchUnreadNotifications(data MinimalTaskData) {
-	url := *Server + "/api/v1/notifications/unread/"
 	taskUUID := uuid.New().String()
-	task := NewTask(nil, data.AppID, taskUUID, "notifications")
-	AddTaskCh <- task
-
-	headers := getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("notifications - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	req.Header = headers
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("notifications - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("notifications: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("notifications: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	task := NewTask(nil, data.AppID, taskUUID, "notifications").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	var respData NotificationData
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("notifications - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.GetJSON(task.Ctx, "/api/v1/notifications/unread/", &respData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("notifications: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Notifications fetched", Result: respData}
+	data.RequestID = task.RequestID
+	result := NotificationsResult{NotificationData: respData, ActorAvatars: fetchNotificationAvatars(respData.Results, data)}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskUUID, Message: "Notifications fetched", Result: result})
 }
 
 func CancelDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Error reading request body: "+err.Error(), http.StatusInternalServerError)
+	var data CancelDownloadData
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	defer r.Body.Close()
-
-	var data CancelDownloadData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if err := firstError(validateAppID(data.AppID), validateUUID("task_id", data.TaskID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	TaskCancelCh <- &TaskCancel{
+	sendTask(TaskCancelCh, &TaskCancel{
 		AppID:  data.AppID,
 		TaskID: data.TaskID,
 		Reason: "cancelled by user",
-	}
+	})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -847,51 +1167,60 @@ func CancelDownloadHandler(w http.ResponseWriter, r *http.Request) {
 // Returns the results directly so it is a blocking on add-on side (as add-on uses blocking Requests for this).
 // It adds filename to the response, because BG scripts need it.
 func GetDownloadURLWrapper(w http.ResponseWriter, r *http.Request) {
-	data := DownloadData{}
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	var data DownloadData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if len(data.Files) == 0 {
+		writeValidationError(w, &FieldError{Field: "asset_data.files", Message: "must not be empty"})
+		return
+	}
+	if err := validateUUID("PREFS.scene_id", data.SceneID); err != nil { // server would refuse it with 403
+		writeValidationError(w, err)
 		return
 	}
 
+	file, resolution := GetResolutionFile(data.Files, data.PREFS.Resolution)
 	canDownload, URL, err := GetDownloadURL(data)
-	if err != nil {
-		http.Error(w, "Error getting download URL: "+err.Error(), http.StatusInternalServerError)
+	var urlErr *DownloadURLError
+	if errors.As(err, &urlErr) && (urlErr.StatusCode == http.StatusUnauthorized || urlErr.StatusCode == http.StatusForbidden) {
+		writeJSON(w, DownloadURLResponse{CanDownload: false, Reason: urlErr.Message, ErrorCode: urlErr.Code, Resolution: resolution})
 		return
 	}
-
-	fileName, err := ExtractFilenameFromURL(URL)
 	if err != nil {
-		http.Error(w, "Error extracting filename from URL: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "error getting download URL: "+err.Error())
 		return
 	}
 
-	responseJSON, err := json.Marshal(map[string]interface{}{
-		"can_download": canDownload,
-		"download_url": URL,
-		"filename":     fileName,
-	})
+	fileName, err := ExtractFilenameFromURL(URL)
+	fileName = localAssetFilename(fileName)
 	if err != nil {
-		http.Error(w, "Error converting to JSON: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, "error extracting filename from URL: "+err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(responseJSON)
+	writeJSON(w, DownloadURLResponse{
+		CanDownload: canDownload,
+		DownloadURL: URL,
+		Filename:    fileName,
+		FileSize:    file.FileSize.Int64(), // no HEAD request, presigned URLs refuse it and the add-on waits for the response
+		Resolution:  resolution,
+	})
 }
 
 // FetchGravatarImageHandler is a handler for the /profiles/fetch_gravatar_image endpoint.
 // It is used to fetch the Gravatar image for the user.
 func DownloadGravatarImageHandler(w http.ResponseWriter, r *http.Request) {
 	var data FetchGravatarData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	go DownloadGravatarImage(data)
+	go runTask(data.AppID, "", func() { DownloadGravatarImage(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -900,450 +1229,324 @@ func DownloadGravatarImageHandler(w http.ResponseWriter, r *http.Request) {
 // It preferes to download the image from the server using the Avatar128 parameter,
 // but if it is not available, it tries to download it from Gravatar using gravatarHash.
 func DownloadGravatarImage(data FetchGravatarData) {
+	taskID := uuid.New().String()
+	task := NewTask(data, data.AppID, taskID, "profiles/fetch_gravatar_image").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	gravatarPath, onDisk, err := fetchGravatarImage(data, task.RequestID)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+		return
+	}
+	message := "Downloaded"
+	if onDisk {
+		message = "Found on disk"
+	}
+	sendTask(TaskFinishCh, &TaskFinish{
+		AppID:   data.AppID,
+		TaskID:  taskID,
+		Message: message,
+		Result:  map[string]string{"gravatar_path": gravatarPath},
+	})
+}
+
+// gravatarFilePath returns path where the avatar of the author is stored.
+func gravatarFilePath(authorID int) (string, error) {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(tempDir, gravatar_dirname, fmt.Sprintf("%d.jpg", authorID)), nil
+}
+
+// fetchGravatarImage returns path of the avatar of the author data.ID, downloading it if it is not on disk yet.
+func fetchGravatarImage(data FetchGravatarData, requestID string) (gravatarPath string, onDisk bool, err error) {
 	var url string
 	if data.Avatar128 != "" {
-		url = *Server + data.Avatar128
+		url = currentServer() + data.Avatar128
 	} else {
 		url = fmt.Sprintf("https://www.gravatar.com/avatar/%v?d=404", data.GravatarHash)
 	}
 
-	taskID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskID, "profiles/fetch_gravatar_image")
-
-	filename := fmt.Sprintf("%d.jpg", data.ID)
-	tempDir, err := GetSafeTempPath()
+	AuthorsSeen.Record([]string{strconv.Itoa(data.ID)}, time.Now()) // also avatars of profiles, which are not in search results
+	gravatarPath, err = gravatarFilePath(data.ID)
 	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
+		return "", false, err
 	}
-
-	gravatarPath := filepath.Join(tempDir, gravatar_dirname, filename)
 	exists, _, _ := FileExists(gravatarPath)
 	if exists {
-		TaskFinishCh <- &TaskFinish{
-			AppID:   data.AppID,
-			TaskID:  taskID,
-			Message: "Found on disk",
-			Result:  map[string]string{"gravatar_path": gravatarPath},
-		}
-		return
+		return gravatarPath, true, nil
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
+		return "", false, err
 	}
 
-	headers := getHeaders("", *SystemID, data.AddonVersion, data.PlatformVersion)
+	headers := getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: requestID})
 	req.Header = headers
 	resp, err := ClientSmallThumbs.Do(req)
 	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
+		return "", false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("gravatar image download: %s, status (%s), query: %v", respString, resp.Status, url)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
+		return "", false, fmt.Errorf("gravatar image download: %s, status (%s), query: %v", respString, resp.Status, url)
 	}
 
-	// Open the file for writing
-	err = os.MkdirAll(filepath.Dir(gravatarPath), os.ModePerm)
-	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
-	}
-	file, err := os.Create(gravatarPath)
-	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
-	}
-
-	// Copy the response body to the file
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
-		return
-	}
-
-	TaskFinishCh <- &TaskFinish{
-		AppID:   data.AppID,
-		TaskID:  taskID,
-		Message: "Downloaded",
-		Result:  map[string]string{"gravatar_path": gravatarPath},
+	if err := saveToFile(gravatarPath, resp.Body); err != nil {
+		return "", false, fmt.Errorf("saving gravatar image: %w", err)
 	}
+	return gravatarPath, false, nil
 }
 
 func GetUserProfileHandler(w http.ResponseWriter, r *http.Request) {
 	var data MinimalTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	go GetUserProfile(data)
+	if err := firstError(validateAppID(data.AppID)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	go runTask(data.AppID, "", func() { GetUserProfile(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
 func GetUserProfile(data MinimalTaskData) {
-	url := *Server + "/api/v1/me/"
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "profiles/get_user_profile")
-
-	headers := getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("get profile - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	req.Header = headers
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("get profile - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
+	task := NewTask(data, data.AppID, taskUUID, "profiles/get_user_profile").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("get profile: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("get profile: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	respData, taskErr := fetchUserProfile(data, task)
+	if taskErr != nil {
+		sendTask(TaskErrorCh, taskErr)
 		return
 	}
 
-	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("get profile - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "data suceessfully fetched",
 		Result:  respData,
+	})
+	emitUserQuota(data.AppID, data.RequestID, respData)
+}
+
+// fetchUserProfile gets the profile of the owner of data.APIKey from https://www.blenderkit.com/api/v1/me/.
+// Rejected API key is reported with ErrCodeLoginRequired.
+func fetchUserProfile(data MinimalTaskData, task *Task) (map[string]interface{}, *TaskError) {
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
+	var respData map[string]interface{}
+	err := client.GetJSON(task.Ctx, "/api/v1/me/", &respData)
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		taskErr := &TaskError{AppID: task.AppID, TaskID: task.TaskID, Error: fmt.Errorf("get profile failed: %w", err), MessageDetailed: apiErr.Body}
+		if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+			taskErr.ErrorCode = ErrCodeLoginRequired
+		}
+		return nil, taskErr
 	}
+	if err != nil {
+		return nil, &TaskError{AppID: task.AppID, TaskID: task.TaskID, Error: fmt.Errorf("get profile: %w", err)}
+	}
+	return respData, nil
 }
 
 func GetRatingHandler(w http.ResponseWriter, r *http.Request) {
 	var data GetRatingData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	go GetRating(data)
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	go runTask(data.AppID, "", func() { GetRating(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
 // GetRating is a function for fetching the rating of the asset.
 // Re-implements: file://daemon/daemon_ratings.py : get_rating()
 func GetRating(data GetRatingData) {
-	url := fmt.Sprintf("%s/api/v1/assets/%s/rating/", *Server, data.AssetID)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "ratings/get_rating")
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("get rating - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("get rating - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("get rating: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("get rating: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	task := NewTask(data, data.AppID, taskUUID, "ratings/get_rating").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("get rating - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.GetJSON(task.Ctx, fmt.Sprintf("/api/v1/assets/%s/rating/", data.AssetID), &respData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("get rating: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "Rating data obtained",
 		Result:  respData,
-	}
+	})
 }
 
 func SendRatingHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method not allowed")
 		return
 	}
 	var data SendRatingData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID), validateNotEmpty("rating_type", data.RatingType)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	go SendRating(data)
+	go runTask(data.AppID, "", func() { SendRating(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
 // SendRating is a function for sending the user's rating of the asset.
 // API documentation: https://www.blenderkit.com/api/v1/docs/#operation/assets_rating_update
 func SendRating(data SendRatingData) {
-	url := fmt.Sprintf("%s/api/v1/assets/%s/rating/%s/", *Server, data.AssetID, data.RatingType)
+	path := fmt.Sprintf("/api/v1/assets/%s/rating/%s/", data.AssetID, data.RatingType)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "ratings/send_rating")
+	task := NewTask(data, data.AppID, taskUUID, "ratings/send_rating").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	reqData := map[string]interface{}{"score": data.RatingValue}
-	reqBody, err := json.Marshal(reqData)
-	if err != nil {
-		err = fmt.Errorf("send rating - encoding: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	key := fmt.Sprintf("rating/%s/%s", data.AssetID, data.RatingType)
 
-	var method string
 	if data.RatingValue == 0 {
-		method = http.MethodDelete
-	} else {
-		method = http.MethodPut
-	}
-
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		err = fmt.Errorf("send rating - making %v request: %w", method, err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("send rating - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if method == http.MethodDelete {
-		if resp.StatusCode != http.StatusNoContent {
-			_, respString, _ := ParseFailedHTTPResponse(resp)
-			err := fmt.Errorf("remove rating - response (%v): %s at URL: %v", resp.Status, respString, url)
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		err := client.Delete(task.Ctx, path, reqData, nil)
+		if queueOffline(key, task, err) {
 			return
 		}
-
-		TaskFinishCh <- &TaskFinish{
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("remove rating: %w", err)})
+			return
+		}
+		sendTask(TaskFinishCh, &TaskFinish{
 			AppID:   data.AppID,
 			TaskID:  taskUUID,
 			Message: fmt.Sprintf("Removed %s rating successfully", data.RatingType),
 			Result:  map[string]string{},
-		}
+		})
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("send rating: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	var respData map[string]interface{}
+	err := client.PutJSON(task.Ctx, path, reqData, &respData)
+	if queueOffline(key, task, err) {
 		return
 	}
-
-	err = RespIsJSON(resp)
 	if err != nil {
-		err = fmt.Errorf("send rating: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("send rating: %w", err)})
 		return
 	}
 
-	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("send rating - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: fmt.Sprintf("Rated %s=%.1f successfully", data.RatingType, data.RatingValue),
 		Result:  respData,
-	}
+	})
 }
 
 func GetBookmarksHandler(w http.ResponseWriter, r *http.Request) {
 	var data MinimalTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-
-	go GetBookmarks(data)
-	w.WriteHeader(http.StatusOK)
-}
-
-// GetBookmarks is a function for fetching the user's bookmarks.
-func GetBookmarks(data MinimalTaskData) {
-	url := fmt.Sprintf("%s/api/v1/search/?query=bookmarks_rating:1", *Server)
-	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "ratings/get_bookmarks")
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("get boomarks - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := firstError(validateAppID(data.AppID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("get bookmarks - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("get bookmarks: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("get bookmarks: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	go runTask(data.AppID, "", func() { GetBookmarks(data) })
+	w.WriteHeader(http.StatusOK)
+}
 
+// GetBookmarks is a function for fetching the user's bookmarks.
+func GetBookmarks(data MinimalTaskData) {
+	taskUUID := uuid.New().String()
+	task := NewTask(data, data.AppID, taskUUID, "ratings/get_bookmarks").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("get bookmarks - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.GetJSON(task.Ctx, "/api/v1/search/?query=bookmarks_rating:1", &respData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("get bookmarks: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "Bookmarks data obtained",
 		Result:  respData,
-	}
+	})
 }
 
 func GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
 	var data GetCommentsData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID), validateCommentsPaging(data)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	go GetComments(data)
+	go runTask(data.AppID, "", func() { GetComments(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
-// GetComments fetches all comments on the given asset.
+// GetComments fetches a page of comments on the given asset, the first one by default.
+// Result has paging metadata of pageComments and polled_at, which the add-on sends as since to poll for new comments.
+// Paging metadata of a poll with since counts only the new comments.
 //
 // API documentation: https://www.blenderkit.com/api/v1/docs/#operation/comments_read
 func GetComments(data GetCommentsData) {
-	url := fmt.Sprintf("%s/api/v1/comments/assets-uuidasset/%s/", *Server, data.AssetID)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "comments/get_comments")
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("get comments - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("get comments - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("get comments: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("get comments: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	task := NewTask(data, data.AppID, taskUUID, "comments/get_comments").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
+	var serverDate time.Time
+	recordServerDate(client, &serverDate)
 	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("get comments - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.GetJSON(task.Ctx, commentsPath(data), &respData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("get comments: %w", err)})
 		return
 	}
+	if respData == nil {
+		respData = map[string]interface{}{}
+	}
+	page, pageSize := commentsPaging(data)
+	respData = pageComments(respData, page, pageSize)
+	respData["polled_at"] = commentsPolledAt(serverDate)
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "comments downloaded",
 		Result:  respData,
-	}
+	})
 }
 
 func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var data CreateCommentData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID), validateNotEmpty("comment_text", data.CommentText)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	go CreateComment(data)
+	go runTask(data.AppID, "", func() { CreateComment(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1355,46 +1558,33 @@ func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
 //
 // API docs POST: https://www.blenderkit.com/api/v1/docs/#operation/comments_comment_create
 func CreateComment(data CreateCommentData) {
-	get_url := fmt.Sprintf("%s/api/v1/comments/asset-comment/%s/", *Server, data.AssetID)
-	post_url := fmt.Sprintf("%s/api/v1/comments/comment/", *Server)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "comments/create_comment")
-
-	req, err := http.NewRequest("GET", get_url, nil)
-	if err != nil {
-		err = fmt.Errorf("create comment - making GET request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	headers := getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	req.Header = headers
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("create comment - performing GET request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("create comment - GET: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	task := NewTask(data, data.AppID, taskUUID, "comments/create_comment").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("create comment - GET: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	key := commentKey(data)
+	if originalTaskID, ok := claimComment(key, taskUUID); !ok {
+		sendTask(TaskFinishCh, &TaskFinish{
+			AppID:   data.AppID,
+			TaskID:  taskUUID,
+			Message: "Duplicate comment ignored",
+			Result:  map[string]string{"duplicate_of": originalTaskID},
+		})
 		return
 	}
+	created := false
+	defer func() {
+		if !created {
+			releaseComment(key, taskUUID)
+		}
+	}()
+	unlock := lockCommentAsset(data.AssetID)
+	defer unlock()
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	var commentsData GetCommentsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&commentsData); err != nil {
-		err = fmt.Errorf("create comment - decoding GET response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.GetJSON(task.Ctx, fmt.Sprintf("/api/v1/comments/asset-comment/%s/", data.AssetID), &commentsData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("create comment - GET: %w", err)})
 		return
 	}
 
@@ -1411,74 +1601,44 @@ func CreateComment(data CreateCommentData) {
 		SecurityHash: commentsData.Form.SecurityHash,
 		Comment:      data.CommentText,
 	}
-	uploadDataJSON, err := json.Marshal(uploadData)
-	if err != nil {
-		err = fmt.Errorf("create comment - encoding POST data: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	post_req, err := http.NewRequest("POST", post_url, bytes.NewBuffer(uploadDataJSON))
-	if err != nil {
-		err = fmt.Errorf("create comment - making POST request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	post_req.Header = headers
-	post_resp, err := ClientAPI.Do(post_req)
-	if err != nil {
-		err = fmt.Errorf("create comment - performing POST request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("create comment - POST: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("create comment - POST: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
 	var respData map[string]interface{}
-	if err := json.NewDecoder(post_resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("create comment - decoding POST response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	err := client.PostJSON(task.Ctx, "/api/v1/comments/comment/", uploadData, &respData)
+	// Comment exists on the server when the response was successful, even if it could not be decoded.
+	// Repeating it within CommentDuplicateWindow is then a duplicate.
+	var apiErr *api.Error
+	var reqErr *api.RequestError
+	created = !errors.As(err, &apiErr) && !errors.As(err, &reqErr)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("create comment - POST: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "Comment created",
 		Result:  respData,
-	}
+	})
 
-	go GetComments(GetCommentsData{
-		AppID:   data.AppID,
-		APIKey:  data.APIKey,
-		AssetID: data.AssetID,
+	go runTask(data.AppID, "", func() {
+		GetComments(GetCommentsData{
+			AppID:   data.AppID,
+			APIKey:  data.APIKey,
+			AssetID: data.AssetID,
+		})
 	})
 }
 
 func FeedbackCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var data FeedbackCommentTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
 		return
 	}
-	go FeedbackComment(data)
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID), validateNotEmpty("flag", data.Flag)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	go runTask(data.AppID, "", func() { FeedbackComment(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1487,82 +1647,50 @@ func FeedbackCommentHandler(w http.ResponseWriter, r *http.Request) {
 //
 // API docs: https://www.blenderkit.com/api/v1/docs/#operation/comments_feedback_create
 func FeedbackComment(data FeedbackCommentTaskData) {
-	url := fmt.Sprintf("%s/api/v1/comments/feedback/", *Server)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "comments/feedback_comment")
+	task := NewTask(data, data.AppID, taskUUID, "comments/feedback_comment").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	upload_data := FeedbackCommentData{
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
+	uploadData := FeedbackCommentData{
 		CommentID: data.CommentID,
 		Flag:      data.Flag,
 	}
-
-	JSON, err := json.Marshal(upload_data)
-	if err != nil {
-		err = fmt.Errorf("comment feedback - encoding data: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(JSON))
-	if err != nil {
-		err = fmt.Errorf("comment feedback - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("comment feedback - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("comment feedback: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	var respData map[string]interface{}
+	err := client.PostJSON(task.Ctx, "/api/v1/comments/feedback/", uploadData, &respData)
+	if queueOffline(fmt.Sprintf("comment_feedback/%d", data.CommentID), task, err) {
 		return
 	}
-
-	err = RespIsJSON(resp)
 	if err != nil {
-		err = fmt.Errorf("comment feedback: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("comment feedback - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("comment feedback: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "flag uploaded",
 		Result:  respData,
-	}
-	go GetComments(GetCommentsData{
-		AppID:   data.AppID,
-		APIKey:  data.APIKey,
-		AssetID: data.AssetID,
+	})
+	go runTask(data.AppID, "", func() {
+		GetComments(GetCommentsData{
+			AppID:   data.AppID,
+			APIKey:  data.APIKey,
+			AssetID: data.AssetID,
+		})
 	})
 }
 
 func MarkCommentPrivateHandler(w http.ResponseWriter, r *http.Request) {
 	var data MarkCommentPrivateTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	go MarkCommentPrivate(data)
+	go runTask(data.AppID, "", func() { MarkCommentPrivate(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1570,78 +1698,43 @@ func MarkCommentPrivateHandler(w http.ResponseWriter, r *http.Request) {
 //
 // API docs: # https://www.blenderkit.com/api/v1/docs/#operation/comments_is_private_create
 func MarkCommentPrivate(data MarkCommentPrivateTaskData) {
-	url := fmt.Sprintf("%s/api/v1/comments/is_private/%d/", *Server, data.CommentID)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "comments/mark_comment_private")
+	task := NewTask(data, data.AppID, taskUUID, "comments/mark_comment_private").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
 	uploadData := MarkCommentPrivateData{IsPrivate: data.IsPrivate}
-	JSON, err := json.Marshal(uploadData)
-	if err != nil {
-		err = fmt.Errorf("comment privacy - encoding data: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(JSON))
-	if err != nil {
-		err = fmt.Errorf("comment privacy - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("comment privacy - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("comment privacy: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	err = RespIsJSON(resp)
-	if err != nil {
-		err = fmt.Errorf("comment privacy: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
 	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("comment privacy - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	if err := client.PostJSON(task.Ctx, fmt.Sprintf("/api/v1/comments/is_private/%d/", data.CommentID), uploadData, &respData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("comment privacy: %w", err)})
 		return
 	}
 
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "comment visibility updated",
 		Result:  respData,
-	}
-	go GetComments(GetCommentsData{
-		AppID:   data.AppID,
-		APIKey:  data.APIKey,
-		AssetID: data.AssetID,
+	})
+	go runTask(data.AppID, "", func() {
+		GetComments(GetCommentsData{
+			AppID:   data.AppID,
+			APIKey:  data.APIKey,
+			AssetID: data.AssetID,
+		})
 	})
 }
 
 func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
 	var data MarkNotificationReadTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	go MarkNotificationRead(data)
+	go runTask(data.AppID, "", func() { MarkNotificationRead(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1649,77 +1742,85 @@ func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
 //
 // API docs: https://www.blenderkit.com/api/v1/docs/#operation/notifications_mark-as-read_read
 func MarkNotificationRead(data MarkNotificationReadTaskData) {
-	url := fmt.Sprintf("%s/api/v1/notifications/mark-as-read/%d/", *Server, data.Notification)
 	taskUUID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskUUID, "notifications/mark_notification_read")
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		err = fmt.Errorf("mark notification read - making request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
+	task := NewTask(data, data.AppID, taskUUID, "notifications/mark_notification_read").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
 
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		err = fmt.Errorf("mark notification read - performing request: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		err := fmt.Errorf("mark notification read: %s (%s)", respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
+	var respData map[string]interface{}
+	err := client.GetJSON(task.Ctx, fmt.Sprintf("/api/v1/notifications/mark-as-read/%d/", data.Notification), &respData)
+	if queueOffline(fmt.Sprintf("notification_read/%d", data.Notification), task, err) {
 		return
 	}
-
-	err = RespIsJSON(resp)
 	if err != nil {
-		err = fmt.Errorf("mark notification read: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
-		return
-	}
-
-	var respData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
-		err = fmt.Errorf("mark notification read - decoding response: %w", err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: fmt.Errorf("mark notification read: %w", err)})
 		return
 	}
-	TaskFinishCh <- &TaskFinish{
+	sendTask(TaskFinishCh, &TaskFinish{
 		AppID:   data.AppID,
 		TaskID:  taskUUID,
 		Message: "notification marked as read",
 		Result:  respData,
-	}
+	})
 }
 
 func assetUploadHandler(w http.ResponseWriter, r *http.Request) {
 	var data AssetUploadRequestData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		BKLog.Printf("%s AssetUploadHandler - %v", EmoError, es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), resolveUploadPaths(&data.ExportData)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if data.DryRun {
+		go runTask(data.AppID, "", func() { doDryRunUpload(data) })
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	go doAssetUpload(data)
+	go runTask(data.AppID, "", func() { doAssetUpload(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
 func doAssetUpload(data AssetUploadRequestData) {
 	taskID := uuid.New().String()
-	AddTaskCh <- &Task{
-		AppID:    data.AppID,
-		TaskID:   taskID,
-		Data:     data,
-		Result:   make(map[string]interface{}),
-		TaskType: "asset_upload",
-		Message:  "Upload initiated",
+	if data.RequestID == "" {
+		data.RequestID = taskID
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &Task{
+		AppID:     data.AppID,
+		TaskID:    taskID,
+		RequestID: data.RequestID,
+		Data:      data,
+		Result:    make(map[string]interface{}),
+		TaskType:  "asset_upload",
+		Message:   "Upload initiated",
+		Ctx:       ctx,
+		Cancel:    cancel,
+	}
+	sendTask(AddTaskCh, task)
+	TaskJournal.Record(task, data)
+	server := currentServer() // all requests of the upload go to the server it started with
+
+	var err error
+	history := UploadHistoryEntry{
+		TaskID:      taskID,
+		AssetName:   data.UploadData.DisplayName,
+		AssetType:   data.UploadData.AssetType,
+		AssetBaseID: data.ExportData.AssetBaseID,
+		AssetID:     data.ExportData.ID,
+		UploadSet:   data.UploadSet,
+		Files:       []string{},
+		Status:      "error",
+	}
+	defer func() {
+		if err != nil {
+			history.Error = err.Error()
+		}
+		history.Timestamp = time.Now()
+		UploadHistory.Add(history)
+	}()
 
 	isMainFileUpload, isMetadataUpload, isThumbnailUpload := false, false, false
 	for _, file := range data.UploadSet {
@@ -1735,18 +1836,37 @@ func doAssetUpload(data AssetUploadRequestData) {
 	}
 	BKLog.Printf("%s Asset Upload Started - isMainFileUpload=%t isMetadataUpload=%t isThumbnailUpload=%t", EmoUpload, isMainFileUpload, isMetadataUpload, isThumbnailUpload)
 
+	if isThumbnailUpload { // fail fast, before the metadata upload and packing
+		thumbnailPath, converted, warning, thumbErr := checkUploadThumbnail(data.ExportData.ThumbnailPath, data.ExportData.TempDir, !data.UploadData.IsPrivate)
+		if thumbErr != nil {
+			err = thumbErr
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+			return
+		}
+		if warning != "" {
+			BKLog.Printf("%s %s: %s", EmoWarning, warning, data.ExportData.ThumbnailPath)
+			sendTaskMessage(&TaskMessageUpdate{AppID: data.AppID, TaskID: taskID, Message: warning})
+		}
+		if converted {
+			defer os.Remove(thumbnailPath)
+			msg := fmt.Sprintf("Thumbnail over %.0fMB converted to JPEG", megabytes(ThumbnailConvertAboveSize))
+			BKLog.Printf("%s %s: %s", EmoUpload, msg, thumbnailPath)
+			sendTaskMessage(&TaskMessageUpdate{AppID: data.AppID, TaskID: taskID, Message: msg})
+			data.ExportData.ThumbnailPath = thumbnailPath
+		}
+	}
+
 	// 1. METADATA UPLOAD
 	var metadataResp *AssetsCreateResponse
-	var err error
 	metadataID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, metadataID, "asset_metadata_upload")
+	sendTask(AddTaskCh, NewTask(data, data.AppID, metadataID, "asset_metadata_upload").WithRequestID(data.RequestID))
 
 	if data.ExportData.AssetBaseID == "" { // 1.A NEW ASSET
 		var respErrorJSON json.RawMessage
-		metadataResp, respErrorJSON, err = CreateMetadata(data)
+		metadataResp, respErrorJSON, err = CreateMetadata(server, data)
 		if err != nil {
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: respErrorJSON}
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: metadataID, Error: err, Result: respErrorJSON}
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: respErrorJSON})
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: metadataID, Error: err, Result: respErrorJSON})
 			return
 		}
 	} else { // 1.B UPDATE OF ASSET
@@ -1754,32 +1874,72 @@ func doAssetUpload(data AssetUploadRequestData) {
 			data.UploadData.VerificationStatus = "uploading"
 		}
 		var respErrorJSON json.RawMessage
-		metadataResp, respErrorJSON, err = UpdateMetadata(data)
+		metadataResp, respErrorJSON, err = UpdateMetadata(server, data)
 		if err != nil {
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: respErrorJSON}
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: metadataID, Error: err, Result: respErrorJSON}
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: respErrorJSON})
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: metadataID, Error: err, Result: respErrorJSON})
 			return
 		}
 		metadataResp = FixAssetsUpdateResponse(metadataResp, data.ExportData.ID, data.UploadData.AssetType)
 	}
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: metadataID, Result: metadataResp} // Assigns AssetID and AssetBaseID on the asset in Blender
+	metadataResult := MetadataUploadResult{AssetsCreateResponse: *metadataResp}
+	if sourceFiles := uploadSourceFiles(data); len(sourceFiles) > 0 {
+		estimate := UploadSpeed.Estimate(uploadFilesSize(sourceFiles), data.ConfirmUploadAboveS)
+		estimate.FromSourceFiles = true
+		metadataResult.UploadEstimate = &estimate
+	}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: metadataID, Result: metadataResult}) // Assigns AssetID and AssetBaseID on the asset in Blender
+	history.AssetBaseID, history.AssetID, history.VerificationStatus = metadataResp.AssetBaseID, metadataResp.ID, metadataResp.VerificationStatus
 
 	// 2. PACKING
-	filesToUpload, err := PackBlendFile(data, *metadataResp, isMainFileUpload)
+	defer cleanupPackedUpload(packedUploadFiles(data, *metadataResp, isMainFileUpload)) // runs after the upload closed the files
+	filesToUpload, packReport, err := PackBlendFile(data, *metadataResp, isMainFileUpload)
 	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err}
+		sendTask(TaskErrorCh, packTaskError(data.AppID, taskID, err))
 		return
 	}
+	uploadResult := UploadResult{AssetsCreateResponse: *metadataResp, PackWarnings: packReport.packWarnings()}
+	packDetail := packIssuesDetail("Packing warnings:", uploadResult.PackWarnings)
+	if packDetail != "" {
+		BKLog.Printf("%s Asset %s packed with %d warnings", EmoWarning, metadataResp.ID, len(uploadResult.PackWarnings))
+	}
 
 	// 3. UPLOAD
-	errJSON, err := UploadAssetData(filesToUpload, data, *metadataResp, isMainFileUpload, taskID)
+	if taskErr := checkPrivateQuota(data, filesToUpload, task); taskErr != nil {
+		err = taskErr.Error
+		sendTask(TaskErrorCh, taskErr)
+		return
+	}
+	if len(filesToUpload) > 0 {
+		estimate := UploadSpeed.Estimate(uploadFilesSize(filesToUpload), data.ConfirmUploadAboveS)
+		BKLog.Printf("%s %s", EmoUpload, estimate)
+		sendTaskMessage(&TaskMessageUpdate{AppID: data.AppID, TaskID: taskID, Message: estimate.String()})
+	}
+	errJSON, err := UploadAssetData(task.Ctx, server, filesToUpload, data, *metadataResp, isMainFileUpload, taskID)
+	if errors.Is(err, ErrUploadConfirmationPending) {
+		BKLog.Printf("%s Asset %s: %v, retrying in background", EmoWarning, metadataResp.ID, err)
+		for _, file := range filesToUpload {
+			history.Files = append(history.Files, file.Type)
+		}
+		history.Status, err = "uploaded", nil
+		result := UploadConfirmationPendingResult{UploadResult: uploadResult, ConfirmationPending: true}
+		sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Result: result, Message: "Files uploaded, confirmation pending", MessageDetailed: packDetail})
+		go runTask(data.AppID, "", func() { retryUploadConfirmation(server, data, *metadataResp) })
+		go runTask(data.AppID, "", func() { refreshUserQuota(uploadProfileData(data)) })
+		return
+	}
 	if err != nil {
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: errJSON}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err, Result: errJSON, MessageDetailed: TraceDetail(err)})
 		return
 	}
 
 	// 4. COMPLETE
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskID, Result: *metadataResp, Message: "Upload successful!"}
+	for _, file := range filesToUpload {
+		history.Files = append(history.Files, file.Type)
+	}
+	history.Status = "uploaded"
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Result: uploadResult, Message: "Upload successful!", MessageDetailed: packDetail})
+	go runTask(data.AppID, "", func() { refreshUserQuota(uploadProfileData(data)) })
 }
 
 type CompleteUploadFileBlockingData struct {
@@ -1787,6 +1947,7 @@ type CompleteUploadFileBlockingData struct {
 	APIKey          string `json:"api_key"`
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 
 	AssetID          string `json:"assetId"`
 	FileType         string `json:"fileType"`
@@ -1798,15 +1959,18 @@ type CompleteUploadFileBlockingData struct {
 // Complete upload file in one blocking request. Used by background scripts.
 func CompleteUploadFileBlocking(w http.ResponseWriter, r *http.Request) {
 	var data CompleteUploadFileBlockingData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateNotEmpty("assetId", data.AssetID), validateNotEmpty("filePath", data.FilePath)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 	var minimalData = MinimalTaskData{
 		APIKey:          data.APIKey,
 		AddonVersion:    data.AddonVersion,
 		PlatformVersion: data.PlatformVersion,
+		RequestID:       data.RequestID,
 	}
 
 	fileData := UploadFile{
@@ -1815,16 +1979,17 @@ func CompleteUploadFileBlocking(w http.ResponseWriter, r *http.Request) {
 		FilePath: data.FilePath,
 	}
 
-	uploadInfo, err := get_S3_upload_JSON(fileData, minimalData, data.AssetID)
+	uploadInfo, err := get_S3_upload_JSON(currentServer(), fileData, minimalData, data.AssetID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, err.Error())
 		return
 	}
 
 	fmt.Println("CompleteUploadFileBlocking uploading file to S3")
-	err = uploadFileToS3(fileData, uploadInfo, 0, "", data.APIKey, data.AddonVersion, data.PlatformVersion)
+	err = uploadFileToS3(fileData, uploadInfo, 0, "", data.APIKey, data.AddonVersion, data.PlatformVersion, data.RequestID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, err.Error())
+		return
 	}
 
 	fmt.Println("CompleteUploadFileBlocking S3 upload complete")
@@ -1832,82 +1997,51 @@ func CompleteUploadFileBlocking(w http.ResponseWriter, r *http.Request) {
 }
 
 // AssetUploadData uploads asset data to S3. If response is not OK, it will return the JSON of the error response and error.
-func UploadAssetData(files []UploadFile, data AssetUploadRequestData, metadataResp AssetsCreateResponse, isMainFileUpload bool, taskID string) (json.RawMessage, error) {
-	for _, file := range files { // will be empty if only metadata is uploaded
+// Each file is reported by its own asset_upload_file sub-task, the asset_upload task taskID shows how many files are done.
+func UploadAssetData(ctx context.Context, server string, files []UploadFile, data AssetUploadRequestData, metadataResp AssetsCreateResponse, isMainFileUpload bool, taskID string) (json.RawMessage, error) {
+	fileTaskIDs := make([]string, len(files))
+	for i, file := range files {
+		fileTaskIDs[i] = uuid.New().String()
+		fileData := UploadFileTaskData{ParentTaskID: taskID, FileType: file.Type, FilePath: file.FilePath, FileIndex: file.Index}
+		fileTask := NewTask(fileData, data.AppID, fileTaskIDs[i], "asset_upload_file").WithRequestID(data.RequestID)
+		fileTask.Message = "Waiting for upload"
+		sendTask(AddTaskCh, fileTask)
+	}
+
+	for i, file := range files { // will be empty if only metadata is uploaded
 		var minimalTaskData = MinimalTaskData{
 			AppID:           data.AppID,
 			APIKey:          data.Preferences.APIKey,
 			AddonVersion:    data.UploadData.AddonVersion,
 			PlatformVersion: data.UploadData.PlatformVersion,
+			RequestID:       data.RequestID,
 		}
-		upload_info_json, err := get_S3_upload_JSON(file, minimalTaskData, metadataResp.ID)
-		if err != nil {
-			return nil, err
+		upload_info_json, err := get_S3_upload_JSON(server, file, minimalTaskData, metadataResp.ID)
+		if err == nil {
+			err = uploadFileToS3(file, upload_info_json, data.AppID, fileTaskIDs[i], data.Preferences.APIKey, data.UploadData.AddonVersion, data.UploadData.PlatformVersion, data.RequestID)
 		}
-
-		err = uploadFileToS3(file, upload_info_json, data.AppID, taskID, data.Preferences.APIKey, data.UploadData.AddonVersion, data.UploadData.PlatformVersion)
 		if err != nil {
+			err = fmt.Errorf("upload of %s failed: %w", file.Type, err)
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: fileTaskIDs[i], Error: err, MessageDetailed: TraceDetail(err)})
 			return nil, err
 		}
+		sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: fileTaskIDs[i], Message: fmt.Sprintf("Uploaded %s", file.Type)})
+		sendTaskProgress(&TaskProgressUpdate{
+			AppID:    data.AppID,
+			TaskID:   taskID,
+			Progress: (i + 1) * 100 / len(files),
+			Message:  fmt.Sprintf("Uploaded %d of %d files", i+1, len(files)),
+		})
 	}
 
-	// Check the status if only thumbnail or metadata gets reuploaded.
-	// the logic is that on hold assets might be switched to uploaded state for validators,
-	// if the asset was put on hold because of thumbnail only.
-	set_uploaded_status := false
-	if !isMainFileUpload {
-		if metadataResp.VerificationStatus == "on_hold" {
-			set_uploaded_status = true
-		}
-		if metadataResp.VerificationStatus == "deleted" {
-			set_uploaded_status = true
-		}
-		if metadataResp.VerificationStatus == "rejected" {
-			set_uploaded_status = true
-		}
-	}
-
-	if isMainFileUpload {
-		set_uploaded_status = true
-	}
-
-	if !set_uploaded_status {
+	status, shouldPatch := resolveVerificationTransition(metadataResp.VerificationStatus, isMainFileUpload)
+	if !shouldPatch {
 		return nil, nil
 	}
-
-	// mark on server as uploaded
-	confirm_data := map[string]string{"verificationStatus": "uploaded"}
-	confirm_data_json, err := json.Marshal(confirm_data)
-	if err != nil {
-		return nil, err
-	}
-
-	url := fmt.Sprintf("%s/api/v1/assets/%s/", *Server, metadataResp.ID)
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(confirm_data_json))
-	if err != nil {
-		return nil, err
-	}
-	req.Header = getHeaders(data.Preferences.APIKey, *SystemID, data.UploadData.AddonVersion, data.UploadData.PlatformVersion)
-
-	resp, err := ClientAPI.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		msg := "asset status update failed"
-		respJSON, respString, respErr := ParseFailedHTTPResponse(resp)
-		if respErr != nil || respJSON == nil {
-			return nil, fmt.Errorf("%s (%s): failed parsing error response (%v), [URL: %v]", msg, resp.Status, respString, url)
-		}
-		return respJSON, fmt.Errorf("%s (%s)", msg, resp.Status)
-	}
-
-	return nil, nil
+	return patchVerificationStatus(ctx, server, data, metadataResp.ID, status, UploadConfirmMaxRetries, UploadConfirmRetryDelay)
 }
 
-func get_S3_upload_JSON(file UploadFile, data MinimalTaskData, assetID string) (S3UploadInfoResponse, error) {
+func get_S3_upload_JSON(server string, file UploadFile, data MinimalTaskData, assetID string) (S3UploadInfoResponse, error) {
 	var resp_JSON S3UploadInfoResponse
 	upload_info := map[string]interface{}{
 		"assetId":          assetID,
@@ -1920,15 +2054,15 @@ func get_S3_upload_JSON(file UploadFile, data MinimalTaskData, assetID string) (
 		return resp_JSON, err
 	}
 
-	url := fmt.Sprintf("%s/api/v1/uploads/", *Server)
+	url := fmt.Sprintf("%s/api/v1/uploads/", server)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(upload_info_json))
 	if err != nil {
 		return resp_JSON, err
 	}
-	req.Header = getHeaders(data.APIKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		return resp_JSON, err
 	}
@@ -1958,9 +2092,10 @@ type ProgressReader struct {
 	r          io.Reader // The underlying reader
 	n          int64     // Number of bytes already read
 	total      int64     // Total byte size of the file
-	appID      int       // which app is this for - used for sending progress updates via TaskMessageCh
-	taskID     string    // which task is this for - used for sending progress updates via TaskMessageCh
+	appID      int       // which app is this for - used for sending progress updates via sendTaskProgress
+	taskID     string    // which task is this for - used for sending progress updates via sendTaskProgress
 	preMessage string    // message to prepend to the progress message
+	rate       transferRate
 }
 
 // Read reads data into p, tracking bytes read to report progress.
@@ -1969,11 +2104,12 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 	pr.n += int64(read)
 
 	// Calculate and send the progress percentage
-	percentage := float64(pr.n) / float64(pr.total) * 100
-	msg := fmt.Sprintf("%s: %d%%", pr.preMessage, int(percentage))
+	percentage := int(float64(pr.n) / float64(pr.total) * 100)
+	msg := fmt.Sprintf("%s: %d%%", pr.preMessage, percentage)
 
-	if pr.appID != 0 || pr.taskID != "" { // bg_scripts don't have access to TaskMessageCh now, TODO: implement Task to be used with BG_scripts
-		TaskMessageCh <- &TaskMessageUpdate{AppID: pr.appID, TaskID: pr.taskID, Message: msg}
+	if pr.appID != 0 || pr.taskID != "" { // bg_scripts don't have tasks now, TODO: implement Task to be used with BG_scripts
+		speed, eta := pr.rate.update(time.Now(), pr.n, pr.total)
+		sendTaskProgress(&TaskProgressUpdate{AppID: pr.appID, TaskID: pr.taskID, Progress: percentage, Message: msg, SpeedBPS: speed, ETASeconds: eta})
 	}
 
 	return read, err
@@ -1983,7 +2119,7 @@ type UploadValidationResponse struct {
 	Detail string `json:"detail"`
 }
 
-func uploadFileToS3(file UploadFile, uploadInfo S3UploadInfoResponse, appID int, taskID, apiKey, addonVersion, platformVersion string) error {
+func uploadFileToS3(file UploadFile, uploadInfo S3UploadInfoResponse, appID int, taskID, apiKey, addonVersion, platformVersion, requestID string) error {
 	fileInfo, err := os.Stat(file.FilePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
@@ -2011,26 +2147,31 @@ func uploadFileToS3(file UploadFile, uploadInfo S3UploadInfoResponse, appID int,
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.ContentLength = fileSize
+	trace := NewRequestTrace(fmt.Sprintf("S3 upload of %s", file.Type))
+	req = trace.Attach(req)
 
+	start := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
+		return trace.Wrap(fmt.Errorf("failed to upload to S3: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
-		return fmt.Errorf("S3 upload failed (%d): %s", resp.StatusCode, respString)
+		return trace.Wrap(fmt.Errorf("S3 upload failed (%d): %s", resp.StatusCode, respString))
 	}
+	trace.Finish()
+	UploadSpeed.Record(fileSize, time.Since(start))
 
 	// UPLOAD VALIDATION
 	valReq, err := http.NewRequest("POST", uploadInfo.UploadDoneURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create upload validation request: %w", err)
 	}
-	valReq.Header = getHeaders(apiKey, *SystemID, addonVersion, platformVersion)
+	valReq.Header = getHeaders(RequestMeta{APIKey: apiKey, AddonVersion: addonVersion, PlatformVersion: platformVersion, RequestID: requestID})
 
-	valResp, err := ClientAPI.Do(valReq)
+	valResp, err := doAPI(ClientAPI, valReq)
 	if err != nil {
 		return fmt.Errorf("failed to validate upload with server: %w", err)
 	}
@@ -2052,8 +2193,9 @@ func uploadFileToS3(file UploadFile, uploadInfo S3UploadInfoResponse, appID int,
 	return nil
 }
 
-func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, isMainFileUpload bool) ([]UploadFile, error) {
+func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, isMainFileUpload bool) ([]UploadFile, *PackReport, error) {
 	files := []UploadFile{}
+	var report *PackReport
 	addon_path := data.Preferences.AddonDir
 	blenderUserScripts := filepath.Dir(filepath.Dir(addon_path)) // e.g.: /Users/username/Library/Application Support/Blender/4.1/scripts"
 	script_path := filepath.Join(addon_path, "upload_bg.py")
@@ -2076,6 +2218,9 @@ func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, i
 			fpath = export_data.HDRFilepath
 		} else {
 			fpath = filepath.Join(export_data.TempDir, export_data.AssetBaseID+".blend")
+			if _, err := checkBlenderBinary(export_data.BinaryPath, "export_data.binary_path"); err != nil {
+				return files, nil, err
+			}
 			data := PackingData{
 				ExportData: export_data,
 				UploadData: upload_data,
@@ -2093,6 +2238,7 @@ func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, i
 			if err != nil {
 				log.Fatal(err)
 			}
+			os.Remove(packReportPath(export_data.TempDir)) // stale report of previous packing
 			log.Println("Running asset packing")
 			cmd := exec.Command(
 				export_data.BinaryPath,
@@ -2109,21 +2255,24 @@ func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, i
 			)
 
 			cmd.Env = append(os.Environ(), fmt.Sprintf("BLENDER_USER_SCRIPTS=%v", blenderUserScripts))
-			out, err := cmd.CombinedOutput()
+			out, err := runBlenderScript(cmd, "upload_bg.py")
 			color.FgGray.Println("(Background) Packing logs:\n", string(out))
+			var reportErr error
+			report, reportErr = readPackReport(export_data.TempDir)
+			if reportErr != nil {
+				BKLog.Printf("%s Ignoring pack report: %v", EmoWarning, reportErr)
+			}
 			if err != nil {
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					exitCode := exitErr.ExitCode()
-					return files, fmt.Errorf("command exited with code %d\nOutput: %s", exitCode, out)
-				} else {
-					return files, fmt.Errorf("command execution failed: %w\nOutput: %s", err, out)
-				}
+				return files, report, err
+			}
+			if report != nil && len(report.Errors) > 0 {
+				return files, report, &PackReportError{Report: report}
 			}
 		}
 
 		exists, _, _ := FileExists(fpath)
 		if !exists {
-			return files, fmt.Errorf("packed file (%s) does not exist, please try manual packing first", fpath)
+			return files, report, fmt.Errorf("packed file (%s) does not exist, please try manual packing first", fpath)
 		}
 	}
 
@@ -2150,14 +2299,14 @@ func PackBlendFile(data AssetUploadRequestData, metadata AssetsCreateResponse, i
 
 	}
 
-	return files, nil
+	return files, report, nil
 }
 
 // CreateMetadata creates metadata on the server, so it can be saved inside the current file.
 // API docs: https://www.blenderkit.com/api/v1/docs/#tag/assets/operation/assets_create
-func CreateMetadata(data AssetUploadRequestData) (*AssetsCreateResponse, json.RawMessage, error) {
-	url := fmt.Sprintf("%s/api/v1/assets/", *Server)
-	headers := getHeaders(data.Preferences.APIKey, *SystemID, data.UploadData.AddonVersion, data.UploadData.PlatformVersion)
+func CreateMetadata(server string, data AssetUploadRequestData) (*AssetsCreateResponse, json.RawMessage, error) {
+	url := fmt.Sprintf("%s/api/v1/assets/", server)
+	headers := getHeaders(RequestMeta{APIKey: data.Preferences.APIKey, AddonVersion: data.UploadData.AddonVersion, PlatformVersion: data.UploadData.PlatformVersion, RequestID: data.RequestID})
 
 	parameters, ok := data.UploadData.Parameters.(map[string]interface{})
 	if !ok {
@@ -2176,7 +2325,7 @@ func CreateMetadata(data AssetUploadRequestData) (*AssetsCreateResponse, json.Ra
 	}
 
 	req.Header = headers
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -2201,9 +2350,9 @@ func CreateMetadata(data AssetUploadRequestData) (*AssetsCreateResponse, json.Ra
 
 // UploadMetadata uploads metadata to the server, so it can be saved inside the current file.
 // API docs: https://www.blenderkit.com/api/v1/docs/#tag/assets/operation/assets_update
-func UpdateMetadata(data AssetUploadRequestData) (*AssetsCreateResponse, json.RawMessage, error) {
-	url := fmt.Sprintf("%s/api/v1/assets/%s/", *Server, data.ExportData.ID)
-	headers := getHeaders(data.Preferences.APIKey, *SystemID, data.UploadData.AddonVersion, data.UploadData.PlatformVersion)
+func UpdateMetadata(server string, data AssetUploadRequestData) (*AssetsCreateResponse, json.RawMessage, error) {
+	url := fmt.Sprintf("%s/api/v1/assets/%s/", server, data.ExportData.ID)
+	headers := getHeaders(RequestMeta{APIKey: data.Preferences.APIKey, AddonVersion: data.UploadData.AddonVersion, PlatformVersion: data.UploadData.PlatformVersion, RequestID: data.RequestID})
 
 	parameters, ok := data.UploadData.Parameters.(map[string]interface{})
 	if !ok {
@@ -2222,7 +2371,7 @@ func UpdateMetadata(data AssetUploadRequestData) (*AssetsCreateResponse, json.Ra
 	}
 
 	req.Header = headers
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(ClientAPI, req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -2267,6 +2416,11 @@ func ParseFailedHTTPResponse(resp *http.Response) (json.RawMessage, string, erro
 			return nil, msg, fmt.Errorf("invalid json")
 		}
 
+		// Server maintenance, no need to show the whole HTML page
+		if isMaintenancePage(resp.StatusCode, bodyString) {
+			return nil, ErrServerMaintenance.Error(), fmt.Errorf("invalid json")
+		}
+
 		// General error
 		BKLog.Printf("%v Failed request on %v, error response: %v", EmoWarning, resp.Request.URL, bodyString)
 		return nil, bodyString, fmt.Errorf("invalid json")
@@ -2282,37 +2436,98 @@ func ParseFailedHTTPResponse(resp *http.Response) (json.RawMessage, string, erro
 	return JSON, bodyString, nil
 }
 
+// ResponseDetail returns human readable summary of the error response parsed by ParseFailedHTTPResponse.
+// Like the Python daemon it prefers the "detail" of the response, then "messages" and then field errors
+// as "field: message". Non-JSON responses or responses without any message are returned as respString.
+func ResponseDetail(respJSON json.RawMessage, respString string) string {
+	var body interface{}
+	if respJSON == nil || json.Unmarshal(respJSON, &body) != nil {
+		return respString
+	}
+	if detail := responseDetail(body); detail != "" {
+		return detail
+	}
+	return respString
+}
+
+func responseDetail(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if part := responseDetail(item); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return strings.Join(parts, " ")
+	case map[string]interface{}:
+		for _, key := range []string{"detail", "messages", "message"} {
+			if detail := responseDetail(v[key]); detail != "" {
+				return detail
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			if key != "code" && key != "type" && key != "statusCode" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if detail := responseDetail(v[key]); detail != "" {
+				parts = append(parts, key+": "+detail)
+			}
+		}
+		return strings.Join(parts, "; ")
+	}
+	return ""
+}
+
 // DictToParams (in Python terminology) converts a map of inputs into a slice of parameter objects.
 // This is used to convert the parameters from the add-on to the format expected by the API.
 // e.g. {"a": "1", "b": "2"} -> [{"parameterType": "a", "value": "1"}, {"parameterType": "b", "value": "2"}]
 func DictToParams(inputs map[string]interface{}) []map[string]string {
 	parameters := make([]map[string]string, 0)
 	for k, v := range inputs {
-		var value string
-		switch v := v.(type) {
-		case []string:
-			for idx, s := range v {
-				value += s
-				if idx < len(v)-1 {
-					value += ","
-				}
-			}
-		case bool:
-			value = fmt.Sprintf("%t", v)
-		case int, int32, int64: // Integers has to be converted via %d to avoid scientific notation
-			value = fmt.Sprintf("%d", v)
-		case float32: // Floats has to be converted via FormatFloat to avoid scientific notation and trailing zeros
-			value = strconv.FormatFloat(float64(v), 'f', -1, 32)
-		case float64:
-			value = strconv.FormatFloat(v, 'f', -1, 64)
-		default:
-			value = fmt.Sprintf("%v", v)
-		}
 		param := map[string]string{
 			"parameterType": k,
-			"value":         value,
+			"value":         paramValue(v),
 		}
 		parameters = append(parameters, param)
 	}
+	sort.Slice(parameters, func(i, j int) bool { // map order is random, payload should be stable
+		return parameters[i]["parameterType"] < parameters[j]["parameterType"]
+	})
 	return parameters
 }
+
+// paramValue formats value of parameter, lists are joined by comma.
+func paramValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}: // lists decoded from JSON
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = paramValue(item)
+		}
+		return strings.Join(values, ",")
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case int, int32, int64: // Integers has to be converted via %d to avoid scientific notation
+		return fmt.Sprintf("%d", v)
+	case float32: // Floats has to be converted via FormatFloat to avoid scientific notation and trailing zeros
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -2,15 +2,32 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// testPort is the port of the Client in tests. Port is set once, tasks which outlive their tests read it.
+const testPort = "62485"
+
+func TestMain(m *testing.M) {
+	port := testPort
+	Port = &port
+	os.Exit(m.Run())
+}
+
 // mockHttpResponse creates a new http.Response from the given body and status code.
 func mockHTTPResponse(body string, statusCode int) *http.Response {
 	return &http.Response{
@@ -161,6 +178,54 @@ func TestDictToParams(t *testing.T) {
 				{"parameterType": "float64", "value": "3.123456789"},
 			},
 		},
+		{
+			name: "Float input - huge, as decoded from JSON",
+			inputs: map[string]interface{}{
+				"faceCount": float64(1200000),
+				"area":      float64(1.5e6 + 0.25),
+			},
+			expected: []map[string]string{
+				{"parameterType": "area", "value": "1500000.25"},
+				{"parameterType": "faceCount", "value": "1200000"},
+			},
+		},
+		{
+			name: "Interface slice input",
+			inputs: map[string]interface{}{
+				"key": []interface{}{"value1", float64(2e6), true},
+			},
+			expected: []map[string]string{
+				{"parameterType": "key", "value": "value1,2000000,true"},
+			},
+		},
+		{
+			name: "Nil input",
+			inputs: map[string]interface{}{
+				"key": nil,
+			},
+			expected: []map[string]string{
+				{"parameterType": "key", "value": ""},
+			},
+		},
+		{
+			name: "Sorted by parameterType",
+			inputs: map[string]interface{}{
+				"modelStyle":   "realistic",
+				"animated":     false,
+				"designYear":   float64(2024),
+				"condition":    "new",
+				"faceCount":    float64(3e6),
+				"manufacturer": "",
+			},
+			expected: []map[string]string{
+				{"parameterType": "animated", "value": "false"},
+				{"parameterType": "condition", "value": "new"},
+				{"parameterType": "designYear", "value": "2024"},
+				{"parameterType": "faceCount", "value": "3000000"},
+				{"parameterType": "manufacturer", "value": ""},
+				{"parameterType": "modelStyle", "value": "realistic"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -172,3 +237,536 @@ func TestDictToParams(t *testing.T) {
 		})
 	}
 }
+
+func TestResponseDetail(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"string detail", `{"detail": "scene_uuid is not a valid UUID", "statusCode": 400}`, "scene_uuid is not a valid UUID"},
+		{"map detail", `{"detail": {"thumbnail": "Invalid image format. Only PNG and JPEG are supported."}}`, "thumbnail: Invalid image format. Only PNG and JPEG are supported."},
+		{"messages", `{"messages": ["Asset is not available for your plan."], "type": "user_plan"}`, "Asset is not available for your plan."},
+		{"field errors", `{"scene_uuid": ["Must be a valid UUID."], "query": ["Too long."]}`, "query: Too long.; scene_uuid: Must be a valid UUID."},
+		{"no message", `{"statusCode": 500}`, `{"statusCode": 500}`},
+		{"not JSON", "Bad Gateway", "Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			respJSON, respString, _ := ParseFailedHTTPResponse(mockHTTPResponse(tt.body, 400))
+			if actual := ResponseDetail(respJSON, respString); actual != tt.expected {
+				t.Errorf("ResponseDetail() = %q, expected %q", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSearchErrorDetail(t *testing.T) {
+	const body = `{"detail": "scene_uuid is not a valid UUID", "statusCode": 400}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+
+	go doAssetSearch(SearchTaskData{AppID: 611, URLQuery: server.URL + "/api/v1/search/"}, "search-error")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-AddTaskCh:
+		case f := <-TaskFinishCh:
+			t.Fatalf("search %s finished; want error", f.TaskID)
+		case e := <-TaskErrorCh:
+			if expected := "search failed: scene_uuid is not a valid UUID (400 Bad Request)"; e.Error.Error() != expected {
+				t.Errorf("error = %q, expected %q", e.Error, expected)
+			}
+			if !strings.HasPrefix(e.MessageDetailed, body) {
+				t.Errorf("MessageDetailed = %q, expected the raw response", e.MessageDetailed)
+			}
+			return
+		case <-timeout:
+			t.Fatal("search did not fail")
+		}
+	}
+}
+
+func TestDecodeSearchResults(t *testing.T) {
+	// Synthesized search response, see testdata/README.md.
+	fixture, err := os.ReadFile(filepath.Join("testdata", "search_results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decodeSearchResults(bytes.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("decodeSearchResults() error = %v", err)
+	}
+	if len(result.Results) != 2 {
+		t.Fatalf("decodeSearchResults() got %d results, expected 2", len(result.Results))
+	}
+	stable, fragile := result.Results[0], result.Results[1]
+	if stable.PK.Int64() != 281734 || stable.VersionNumber.Int64() != 3 || stable.Files[2].Resolution.Int64() != 720 || stable.WebpGeneratedTimestamp.Float64() == 0 {
+		t.Errorf("numbers of %s decoded wrong: %+v", stable.Name, stable)
+	}
+	if fragile.PK.Int64() != 173529 || fragile.Author.ID.Int64() != 80211 || fragile.Score.Float64() != 212.5 || fragile.VersionNumber != "" {
+		t.Errorf("numbers of %s decoded wrong: %+v", fragile.Name, fragile)
+	}
+	encoded, err := json.Marshal(fragile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `"pk":173529`) || !strings.Contains(string(encoded), `"filesSize":0`) {
+		t.Errorf("numbers are not encoded as numbers for the add-on: %s", encoded)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"empty page", `{"count": 0, "results": []}`, ""},
+		{"missing results", `{"detail": "Not found."}`, "results are missing"},
+		{"list instead of object", `[]`, "unexpected response"},
+		{"bool instead of number", `{"count": 1, "results": [{"pk": true}]}`, "invalid number true"},
+		{"wrong type of field", `{"count": 1, "results": [{"tags": "chair"}]}`, `tags" cannot be string`},
+		{"truncated", `{"count": 1, "results": [{"pk": 1}`, "unexpected EOF"},
+		{"trailing data", `{"count": 0, "results": []} <html>`, "unexpected data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeSearchResults(strings.NewReader(tt.body))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("decodeSearchResults() error = %v, expected none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("decodeSearchResults() error = %v, expected containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestThumbnailURLs(t *testing.T) {
+	asset := Asset{
+		WebpGeneratedTimestamp:          "1700000000",
+		ThumbnailSmallURL:               "small.png",
+		ThumbnailSmallURLWebp:           "small.webp",
+		ThumbnailMiddleURL:              "middle.png",
+		ThumbnailMiddleURLWebp:          "middle.webp",
+		ThumbnailLargeURLNonsquared:     "large_ns.png",
+		ThumbnailLargeURLNonsquaredWebp: "large_ns.webp",
+		ThumbnailSmallURLNonsquared:     "small_ns.png",
+		ThumbnailSmallURLNonsquaredWebp: "small_ns.webp",
+	}
+	tests := []struct {
+		assetType string
+		fullSize  string
+		full      string // without extension
+	}{
+		{"model", thumbnailMiddle, "middle"},
+		{"material", thumbnailMiddle, "middle"},
+		{"hdr", thumbnailLargeNonsquared, "large_ns"},
+		{"scene", thumbnailLargeNonsquared, "large_ns"},
+		{"brush", thumbnailSmallNonsquared, "small_ns"},
+		{"printable", thumbnailMiddle, "middle"},
+		{"nodegroup", thumbnailMiddle, "middle"},
+	}
+	versions := []struct {
+		blender string
+		webp    bool
+	}{
+		{"4.2.0", true},
+		{"3.4.0", true},
+		{"3.3.1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		for _, v := range versions {
+			blVer, _ := StringToBlenderVersion(v.blender)
+			for _, generated := range []bool{true, false} {
+				a := asset
+				a.AssetType = tt.assetType
+				if !generated {
+					a.WebpGeneratedTimestamp = "0"
+				}
+				ext := ".png"
+				if v.webp && generated {
+					ext = ".webp"
+				}
+				small, full, fullSize := thumbnailURLs(a, blVer)
+				if small != "small"+ext || full != tt.full+ext || fullSize != tt.fullSize {
+					t.Errorf("%s, Blender %q, webp generated %v: got %s, %s (%s); want small%s, %s%s (%s)",
+						tt.assetType, v.blender, generated, small, full, fullSize, ext, tt.full, ext, tt.fullSize)
+				}
+			}
+		}
+	}
+}
+
+func TestThumbnailURLsFallback(t *testing.T) {
+	// Written by hand with the thumbnail fields of Asset, not captured from the API: the server which had assets
+	// without some thumbnails could not be reached. Replace by a trimmed real response once one is at hand.
+	const cdn = "https://thumbs.example/"
+	fixture := `{"count": 4, "next": null, "previous": null, "results": [
+		{"assetBaseId": "model-webp-and-jpg", "assetType": "model", "name": "Model", "webpGeneratedTimestamp": 1681205612.51,
+			"thumbnailSmallUrl": "` + cdn + `1101_small.jpg", "thumbnailSmallUrlWebp": "` + cdn + `1101_small.webp",
+			"thumbnailMiddleUrl": "` + cdn + `1101_middle.jpg", "thumbnailMiddleUrlWebp": "` + cdn + `1101_middle.webp"},
+		{"assetBaseId": "material-png-only", "assetType": "material", "name": "Material", "webpGeneratedTimestamp": 1652871203.2,
+			"thumbnailSmallUrl": "` + cdn + `0417_small.png", "thumbnailSmallUrlWebp": "",
+			"thumbnailMiddleUrl": "` + cdn + `0417_middle.png", "thumbnailMiddleUrlWebp": ""},
+		{"assetBaseId": "hdr-webp-only", "assetType": "hdr", "name": "HDR", "webpGeneratedTimestamp": 1698312044.87,
+			"thumbnailSmallUrl": "", "thumbnailSmallUrlWebp": "` + cdn + `2230_small.webp",
+			"thumbnailLargeUrlNonsquared": "", "thumbnailLargeUrlNonsquaredWebp": "` + cdn + `2230_large_ns.webp"},
+		{"assetBaseId": "brush-without-thumbnails", "assetType": "brush", "name": "Brush", "webpGeneratedTimestamp": 1599401881.0,
+			"thumbnailSmallUrl": "", "thumbnailSmallUrlWebp": "", "thumbnailSmallUrlNonsquared": "", "thumbnailSmallUrlNonsquaredWebp": ""}]}`
+	result, err := decodeSearchResults(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("decodeSearchResults() error = %v", err)
+	}
+	tests := []struct {
+		blender     string
+		small, full []string // expected per asset of the fixture, without the CDN prefix
+	}{
+		{ // WEBP preferred, falls back to PNG/JPG
+			blender: "4.2.0",
+			small:   []string{"1101_small.webp", "0417_small.png", "2230_small.webp", ""},
+			full:    []string{"1101_middle.webp", "0417_middle.png", "2230_large_ns.webp", ""},
+		},
+		{ // PNG/JPG preferred, falls back to WEBP
+			blender: "3.3.0",
+			small:   []string{"1101_small.jpg", "0417_small.png", "2230_small.webp", ""},
+			full:    []string{"1101_middle.jpg", "0417_middle.png", "2230_large_ns.webp", ""},
+		},
+	}
+	for _, tt := range tests {
+		blVer, _ := StringToBlenderVersion(tt.blender)
+		for i, asset := range result.Results {
+			small, full, _ := thumbnailURLs(asset, blVer)
+			for _, got := range []struct{ url, want string }{{small, tt.small[i]}, {full, tt.full[i]}} {
+				want := got.want
+				if want != "" {
+					want = cdn + want
+				}
+				if got.url != want {
+					t.Errorf("Blender %s, %s: got %q; want %q", tt.blender, asset.Name, got.url, want)
+				}
+			}
+		}
+	}
+
+	missing := result.Results[3]
+	missingThumbnailsLogged.Delete(missing.AssetBaseID)
+	var logged bytes.Buffer
+	defer func(l *log.Logger) { BKLog = l }(BKLog)
+	BKLog = log.New(&logged, "", 0)
+	logMissingThumbnail(missing)
+	logMissingThumbnail(missing)
+	if count := strings.Count(logged.String(), missing.AssetBaseID); count != 1 {
+		t.Errorf("asset without thumbnails logged %d times; want once", count)
+	}
+}
+
+// roundTripFunc lets a function be used as the transport of a client in tests.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// thumbnailOutcome returns the error of the thumbnail task sent by DownloadThumbnail, nil if it finished.
+// Exactly one finish or error is expected after the task was added.
+func thumbnailOutcome(t *testing.T, data DownloadThumbnailData) error {
+	t.Helper()
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	DownloadThumbnail(NewTask(data, 643, "thumbnail", "thumbnail_download"), wg)
+	<-AddTaskCh
+	var err error
+	select {
+	case <-TaskFinishCh:
+	case e := <-TaskErrorCh:
+		err = e.Error
+	}
+	select {
+	case f := <-TaskFinishCh:
+		t.Errorf("second finish %+v of the thumbnail task", f)
+	case e := <-TaskErrorCh:
+		t.Errorf("second error of the thumbnail task: %v", e.Error)
+	default:
+	}
+	return err
+}
+
+func TestDownloadThumbnailDirectory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientSmallThumbs = c }(ClientSmallThumbs)
+	ClientSmallThumbs = server.Client()
+
+	missing := filepath.Join(t.TempDir(), "model_search", "thumb.png")
+	if err := thumbnailOutcome(t, DownloadThumbnailData{ThumbnailType: "small", ImagePath: missing, ImageURL: server.URL + "/thumb.png"}); err != nil {
+		t.Errorf("thumbnail into missing directory failed: %v", err)
+	}
+	if content, _ := os.ReadFile(missing); string(content) != "image" {
+		t.Errorf("thumbnail content %q; want image", content)
+	}
+
+	unwritable := filepath.Join(unwritableDir(t), "thumb.png")
+	if err := thumbnailOutcome(t, DownloadThumbnailData{ThumbnailType: "small", ImagePath: unwritable, ImageURL: server.URL + "/thumb.png"}); err == nil {
+		t.Error("thumbnail into unwritable directory finished; want error")
+	}
+}
+
+func TestDownloadGravatarImageUnwritableDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("avatar"))
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientSmallThumbs = c }(ClientSmallThumbs)
+	defer setServer(setServer(server.URL))
+	ClientSmallThumbs = server.Client()
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gravatar directory links to the unwritable directory
+	if err := os.Symlink(unwritableDir(t), filepath.Join(safeTemp, gravatar_dirname)); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+
+	DownloadGravatarImage(FetchGravatarData{AppID: 644, ID: 6581, Avatar128: "/avatar.jpg"})
+	<-AddTaskCh
+	select {
+	case f := <-TaskFinishCh:
+		t.Fatalf("gravatar task finished %+v; want error", f)
+	case e := <-TaskErrorCh:
+		if !strings.Contains(e.Error.Error(), "saving gravatar image") {
+			t.Errorf("error = %v; want saving gravatar image", e.Error)
+		}
+	}
+	select {
+	case f := <-TaskFinishCh:
+		t.Errorf("finish %+v after the error", f)
+	case e := <-TaskErrorCh:
+		t.Errorf("second error: %v", e.Error)
+	default:
+	}
+}
+
+func TestDownloadThumbnailPools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	defer func(small, big *http.Client) { ClientSmallThumbs, ClientBigThumbs = small, big }(ClientSmallThumbs, ClientBigThumbs)
+	served := map[string][]string{}
+	pool := func(name string) *http.Client {
+		return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			served[name] = append(served[name], r.URL.Path)
+			return http.DefaultTransport.RoundTrip(r)
+		})}
+	}
+	ClientSmallThumbs, ClientBigThumbs = pool("small"), pool("full")
+	small, full := ThumbnailPools.Small.Load(), ThumbnailPools.Full.Load()
+
+	tempDir := t.TempDir()
+	for _, thumbnailType := range []string{"small", "full"} {
+		data := DownloadThumbnailData{ThumbnailType: thumbnailType, ImagePath: filepath.Join(tempDir, thumbnailType+".png"), ImageURL: server.URL + "/" + thumbnailType + ".png",
+			AssetBaseID: "asset-1", AssetType: "model", Index: 1, SearchTaskID: "search-642"}
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		DownloadThumbnail(NewTask(data, 642, thumbnailType, "thumbnail_download"), wg)
+		<-AddTaskCh
+		select {
+		case f := <-TaskFinishCh:
+			if result := f.Result.(ThumbnailResult); result != thumbnailResult(data) || result.SearchTaskID != "search-642" || result.ThumbnailType != thumbnailType {
+				t.Errorf("%s thumbnail result = %+v; want the thumbnail identified by search task and asset", thumbnailType, result)
+			}
+		case e := <-TaskErrorCh:
+			t.Fatalf("%s thumbnail task failed: %v", thumbnailType, e.Error)
+		}
+	}
+
+	want := map[string][]string{"small": {"/small.png"}, "full": {"/full.png"}}
+	if !reflect.DeepEqual(served, want) {
+		t.Errorf("thumbnails served by pools = %v; want %v", served, want)
+	}
+	if ThumbnailPools.Small.Load()-small != 1 || ThumbnailPools.Full.Load()-full != 1 {
+		t.Errorf("pool counters increased by small=%d, full=%d; want 1 each", ThumbnailPools.Small.Load()-small, ThumbnailPools.Full.Load()-full)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var metrics struct {
+		ThumbnailPools map[string]int64 `json:"thumbnail_pools"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("decoding /metrics: %v", err)
+	}
+	if metrics.ThumbnailPools["small"] < 1 || metrics.ThumbnailPools["full"] < 1 {
+		t.Errorf("/metrics thumbnail_pools = %v; want both pools counted", metrics.ThumbnailPools)
+	}
+}
+
+func TestThumbnailClientsTuning(t *testing.T) {
+	defer func(api, downloads, uploads, small, big *http.Client) {
+		ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs = api, downloads, uploads, small, big
+	}(ClientAPI, ClientDownloads, ClientUploads, ClientSmallThumbs, ClientBigThumbs)
+	CreateHTTPClients("", "NONE", "ENABLED", "")
+
+	if ClientSmallThumbs.Timeout >= ClientBigThumbs.Timeout {
+		t.Errorf("small thumbnails timeout %v; want shorter than full thumbnails timeout %v", ClientSmallThumbs.Timeout, ClientBigThumbs.Timeout)
+	}
+	smallConns := ClientSmallThumbs.Transport.(*reloadableTransport).current.Load().MaxConnsPerHost
+	bigConns := ClientBigThumbs.Transport.(*reloadableTransport).current.Load().MaxConnsPerHost
+	if smallConns <= bigConns {
+		t.Errorf("small thumbnails max connections %d; want more than full thumbnails %d", smallConns, bigConns)
+	}
+}
+
+func TestSearchNotJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>Bad gateway</body></html>")
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+
+	go doAssetSearch(SearchTaskData{AppID: 612, URLQuery: server.URL + "/api/v1/search/"}, "search-html")
+	select {
+	case f := <-TaskFinishCh:
+		t.Fatalf("search %s finished; want error", f.TaskID)
+	case e := <-TaskErrorCh:
+		if !strings.Contains(e.Error.Error(), "invalid response Content-Type") {
+			t.Errorf("error = %v, expected complaint about content type", e.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search did not fail")
+	}
+}
+
+func TestUploadAssetDataFileSubtasks(t *testing.T) {
+	var failType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/uploads/":
+			var info map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&info)
+			fileType := info["fileType"].(string)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"s3UploadUrl": "http://%s/s3/%s", "uploadDoneUrl": "http://%s/done/%s"}`, r.Host, fileType, r.Host, fileType)
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			io.Copy(io.Discard, r.Body)
+			if r.URL.Path == "/s3/"+failType {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+
+	dir := t.TempDir()
+	files := []UploadFile{{Type: "thumbnail", FilePath: filepath.Join(dir, "thumb.jpg")}, {Type: "blend", FilePath: filepath.Join(dir, "asset.blend")}}
+	for _, file := range files {
+		os.WriteFile(file.FilePath, []byte("content of "+file.Type), 0644)
+	}
+	data := AssetUploadRequestData{AppID: 6321}
+	metadata := AssetsCreateResponse{ID: "asset-id", VerificationStatus: "uploaded"}
+
+	for _, failType = range []string{"", "thumbnail"} {
+		_, err := UploadAssetData(context.Background(), currentServer(), files, data, metadata, false, "parent")
+		fileTypes := map[string]string{} // task ID -> file type
+		for len(AddTaskCh) > 0 {
+			task := <-AddTaskCh
+			if task.AppID != data.AppID { // left by other tests
+				continue
+			}
+			fileData, ok := task.Data.(UploadFileTaskData)
+			if task.TaskType != "asset_upload_file" || !ok || task.ParentTaskID() != "parent" {
+				t.Errorf("failing %q: unexpected task %s with data %+v", failType, task.TaskType, task.Data)
+				continue
+			}
+			fileTypes[task.TaskID] = fileData.FileType
+		}
+		if len(fileTypes) != 2 {
+			t.Errorf("failing %q: got %d sub-tasks; want one per file", failType, len(fileTypes))
+		}
+		finished, failed := []string{}, []string{}
+		for len(TaskFinishCh) > 0 {
+			if f := <-TaskFinishCh; f.AppID == data.AppID {
+				finished = append(finished, fileTypes[f.TaskID])
+			}
+		}
+		for len(TaskErrorCh) > 0 {
+			e := <-TaskErrorCh
+			if e.AppID != data.AppID {
+				continue
+			}
+			failed = append(failed, fileTypes[e.TaskID])
+			if !strings.Contains(e.Error.Error(), "upload of thumbnail failed") {
+				t.Errorf("failing %q: sub-task error %v does not name the file", failType, e.Error)
+			}
+		}
+		TaskUpdates.take()
+
+		if failType == "" {
+			if err != nil || !reflect.DeepEqual(finished, []string{"thumbnail", "blend"}) || len(failed) != 0 {
+				t.Errorf("all uploaded: error %v, finished %v, failed %v; want both files finished", err, finished, failed)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "upload of thumbnail failed") {
+			t.Errorf("thumbnail failed: error = %v; want naming the thumbnail", err)
+		}
+		if len(finished) != 0 || !reflect.DeepEqual(failed, []string{"thumbnail"}) {
+			t.Errorf("thumbnail failed: finished %v, failed %v; want only thumbnail failed", finished, failed)
+		}
+	}
+}
+
+func TestReportPrunesSubtasksWithParent(t *testing.T) {
+	const appID = 6322
+	parent := NewTask(nil, appID, "parent", "asset_upload")
+	parent.Status = "error"
+	thumbnail := NewTask(UploadFileTaskData{ParentTaskID: "parent", FileType: "thumbnail"}, appID, "thumbnail", "asset_upload_file")
+	thumbnail.Status = "error"
+	blend := NewTask(UploadFileTaskData{ParentTaskID: "parent", FileType: "blend"}, appID, "blend", "asset_upload_file")
+	other := NewTask(UploadFileTaskData{ParentTaskID: "running", FileType: "blend"}, appID, "other", "asset_upload_file")
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{"parent": parent, "thumbnail": thumbnail, "blend": blend, "other": other}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, appID)
+		TasksMux.Unlock()
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(`{"app_id": 6322, "addon_version": "3.12.0"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	reportHandler(rec, req)
+
+	var reported []Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &reported); err != nil {
+		t.Fatalf("decoding report: %v, body: %s", err, rec.Body.String())
+	}
+	if len(reported) != 5 { // client_status and all four tasks
+		t.Errorf("reported %d tasks; want 5", len(reported))
+	}
+	TasksMux.Lock()
+	left := Tasks[appID]
+	TasksMux.Unlock()
+	if len(left) != 1 || left["other"] == nil {
+		t.Errorf("tasks left after report: %v; want only sub-task of the running parent", left)
+	}
+}
@@ -0,0 +1,178 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NonblockingRequestFile is a file sent as part of multipart form by the nonblocking request.
+type NonblockingRequestFile struct {
+	FieldName   string `json:"field_name"`
+	Filepath    string `json:"filepath"`
+	ContentType string `json:"content_type"` // application/octet-stream if empty
+}
+
+// validateRequestFiles checks that all files of the multipart request exist, before the task is created.
+func validateRequestFiles(files []NonblockingRequestFile) error {
+	for i, f := range files {
+		field := fmt.Sprintf("files[%d]", i)
+		if err := firstError(validateNotEmpty(field+".field_name", f.FieldName), validateNotEmpty(field+".filepath", f.Filepath)); err != nil {
+			return err
+		}
+		info, err := os.Stat(f.Filepath)
+		if err != nil || !info.Mode().IsRegular() {
+			return &FieldError{Field: field + ".filepath", Message: fmt.Sprintf("is not an existing file: %q", f.Filepath)}
+		}
+	}
+	return nil
+}
+
+// multipartFormFields converts JSON object into form fields. Strings are sent as they are, other values JSON encoded.
+func multipartFormFields(jsonData json.RawMessage) (map[string]string, error) {
+	fields := map[string]string{}
+	if len(jsonData) == 0 || string(jsonData) == "null" {
+		return fields, nil
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &values); err != nil {
+		return nil, fmt.Errorf("json must be an object to be sent with files: %w", err)
+	}
+	for key, raw := range values {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			fields[key] = s
+		} else {
+			fields[key] = string(raw)
+		}
+	}
+	return fields, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeMultipart writes the form fields and then the files, content of each file is written by writeFile.
+func writeMultipart(mw *multipart.Writer, fields map[string]string, files []NonblockingRequestFile, writeFile func(io.Writer, NonblockingRequestFile) error) error {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := mw.WriteField(key, fields[key]); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(f.FieldName), quoteEscaper.Replace(filepath.Base(f.Filepath))))
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(part, f); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// newMultipartBody returns multipart form body which streams the files from disk, its content type and exact size.
+// Size is computed first without reading the files, so the request is sent with Content-Length.
+func newMultipartBody(jsonData json.RawMessage, files []NonblockingRequestFile) (io.ReadCloser, string, int64, error) {
+	fields, err := multipartFormFields(jsonData)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	counter := &countingWriter{}
+	sizer := multipart.NewWriter(counter)
+	err = writeMultipart(sizer, fields, files, func(_ io.Writer, f NonblockingRequestFile) error {
+		info, err := os.Stat(f.Filepath)
+		if err != nil {
+			return err
+		}
+		counter.n += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	mw.SetBoundary(sizer.Boundary())
+	go func() {
+		err := writeMultipart(mw, fields, files, func(part io.Writer, f NonblockingRequestFile) error {
+			file, err := os.Open(f.Filepath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(part, file)
+			return err
+		})
+		pw.CloseWithError(err)
+	}()
+	return pr, mw.FormDataContentType(), counter.n, nil
+}
+
+// uploadProgressReader reports progress of the upload through TaskProgressUpdateCh whenever the percentage changes.
+type uploadProgressReader struct {
+	io.ReadCloser
+	n, total int64
+	percent  int
+	appID    int
+	taskID   string
+	rate     transferRate
+}
+
+func (r *uploadProgressReader) Read(p []byte) (int, error) {
+	read, err := r.ReadCloser.Read(p)
+	r.n += int64(read)
+	if r.total > 0 {
+		if percent := int(r.n * 100 / r.total); percent != r.percent {
+			r.percent = percent
+			speed, eta := r.rate.update(time.Now(), r.n, r.total)
+			sendTaskProgress(&TaskProgressUpdate{AppID: r.appID, TaskID: r.taskID, Progress: percent, Message: fmt.Sprintf("Uploading: %d%%", percent), SpeedBPS: speed, ETASeconds: eta})
+		}
+	}
+	return read, err
+}
@@ -0,0 +1,110 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewMultipartBody(t *testing.T) {
+	dir := t.TempDir()
+	render := filepath.Join(dir, "render.png")
+	log := filepath.Join(dir, "log.txt")
+	os.WriteFile(render, []byte("PNG image data"), 0644)
+	os.WriteFile(log, []byte("log"), 0644)
+	files := []NonblockingRequestFile{
+		{FieldName: "image", Filepath: render, ContentType: "image/png"},
+		{FieldName: "log", Filepath: log},
+	}
+
+	body, contentType, size, err := newMultipartBody([]byte(`{"comment": "nice", "rating": 5}`), files)
+	if err != nil {
+		t.Fatalf("newMultipartBody: %v", err)
+	}
+	defer body.Close()
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if int64(len(raw)) != size {
+		t.Errorf("size = %d; body has %d bytes", size, len(raw))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("content type = %q; want multipart/form-data", contentType)
+	}
+	expected := []struct{ name, filename, contentType, content string }{
+		{"comment", "", "", "nice"},
+		{"rating", "", "", "5"},
+		{"image", "render.png", "image/png", "PNG image data"},
+		{"log", "log.txt", "application/octet-stream", "log"},
+	}
+	reader := multipart.NewReader(strings.NewReader(string(raw)), params["boundary"])
+	for _, want := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("part %s: %v", want.name, err)
+		}
+		content, _ := io.ReadAll(part)
+		if part.FormName() != want.name || part.FileName() != want.filename || string(content) != want.content {
+			t.Errorf("part = %s %q %q; want %s %q %q", part.FormName(), part.FileName(), content, want.name, want.filename, want.content)
+		}
+		if want.contentType != "" && part.Header.Get("Content-Type") != want.contentType {
+			t.Errorf("part %s Content-Type = %q; want %q", want.name, part.Header.Get("Content-Type"), want.contentType)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected end of form, got %v", err)
+	}
+}
+
+func TestValidateRequestFiles(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "render.png")
+	os.WriteFile(existing, []byte("PNG"), 0644)
+	tests := []struct {
+		name      string
+		files     []NonblockingRequestFile
+		wantField string
+	}{
+		{"no files", nil, ""},
+		{"existing file", []NonblockingRequestFile{{FieldName: "image", Filepath: existing}}, ""},
+		{"missing field name", []NonblockingRequestFile{{Filepath: existing}}, "files[0].field_name"},
+		{"missing file", []NonblockingRequestFile{{FieldName: "image", Filepath: existing}, {FieldName: "log", Filepath: existing + ".missing"}}, "files[1].filepath"},
+		{"directory", []NonblockingRequestFile{{FieldName: "image", Filepath: filepath.Dir(existing)}}, "files[0].filepath"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequestFiles(tt.files)
+			field := ""
+			if fe, ok := err.(*FieldError); ok {
+				field = fe.Field
+			}
+			if field != tt.wantField {
+				t.Errorf("error = %v; want error for field %q", err, tt.wantField)
+			}
+		})
+	}
+}
@@ -19,68 +19,473 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rapid7/go-get-proxied/proxy"
 )
 
+const networkDebugWorkers = 4 // How many network debug probes can run at the same time
+
+// Connection pool settings of the HTTP clients, can be changed by flags for debugging.
+// Thumbnails are downloaded in batches of many parallel requests, so their pools keep more idle connections
+// to avoid a new TLS handshake for every thumbnail on high latency links.
+// Small thumbnails are tiny and shown first, so their pool allows many connections with a short timeout.
+// Full thumbnails are bigger, their pool uses fewer connections so they do not take the bandwidth from the small ones.
+var (
+	APIMaxIdleConnsPerHost         = 4
+	SmallThumbsMaxIdleConnsPerHost = 32
+	BigThumbsMaxIdleConnsPerHost   = 8
+	IdleConnTimeout                = 90 * time.Second
+)
+
+// Timeouts of the thumbnail clients, scaled by TimeoutMultiplier.
+const (
+	SmallThumbsTimeout = 20 * time.Second
+	BigThumbsTimeout   = 2 * time.Minute
+)
+
+// TimeoutMultiplier scales the client-wide timeouts of all HTTP clients, can be raised by flag for very slow links.
+var TimeoutMultiplier = 1.0
+
+// networkProbe is a single combination of settings tested by NetworkDebug().
+type networkProbe struct {
+	ProxyWhich string
+	SSLContext string
+	URL        string
+}
+
+// networkProbeResult holds the outcome of one networkProbe.
+type networkProbeResult struct {
+	networkProbe
+	StatusCode int
+	Latency    time.Duration
+	RemoteAddr string
+	TLSVersion string
+	Err        error
+}
+
+// Reached tells the probe got a response of the server, 5xx is counted as a server problem, not a response.
+func (r networkProbeResult) Reached() bool {
+	return r.Err == nil && r.StatusCode < 500
+}
+
+// OK tells the server accepted the probe.
+func (r networkProbeResult) OK() bool {
+	return r.Reached() && r.StatusCode < 400
+}
+
+// Rejected tells the server was reached but refused the probe with 4xx, e.g. by a firewall page or for the headers.
+func (r networkProbeResult) Rejected() bool {
+	return r.Reached() && !r.OK()
+}
+
+// DebugNetworkHandler runs NetworkDebug() and returns the report as plain text.
+// Add-on version and platform are optional, they are read from MinimalTaskData in request body if present.
 func DebugNetworkHandler(w http.ResponseWriter, r *http.Request) {
-	text := "Network Debug not implemented now."
-	w.Write([]byte(text))
+	var data MinimalTaskData
+	_ = json.NewDecoder(r.Body).Decode(&data) // body is optional, defaults are fine for the debug
+	defer r.Body.Close()
+
+	report := NetworkDebug(data)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(report))
+}
+
+// NetworkDebug probes the connection to the server with a curated set of settings:
+// system proxy vs. no proxy, SSL verification enabled vs. disabled, with one realistic set of headers.
+// Probes run concurrently in a small pool, the report ends with an automated diagnosis.
+func NetworkDebug(data MinimalTaskData) string {
+	server := currentServer()
+	urls := []string{
+		server + "/api/v1/disclaimer/active/",
+		server + "/api/v1/categories/",
+	}
+	proxies := []string{"SYSTEM", "NONE"}
+	sslContexts := []string{"ENABLED", "DISABLED"}
+
+	proxyFuncs := make(map[string]func(*http.Request) (*url.URL, error))
+	for _, p := range proxies {
+		proxyFuncs[p] = GetProxyFunc("", p)
+	}
+	networkSettingsMux.Lock()
+	pacURL := ProxyPACURL
+	networkSettingsMux.Unlock()
+	var pac *pacProxy
+	if pacURL != "" {
+		pac = newPACProxy(pacURL)
+		proxies = append(proxies, "PAC")
+		proxyFuncs["PAC"] = pac.Proxy
+	}
+	tlsConfigs := make(map[string]*tls.Config)
+	for _, s := range sslContexts {
+		tlsConfigs[s] = GetTLSConfig(s)
+		tlsConfigs[s].RootCAs = GetCACertPool("")
+	}
+
+	var probes []networkProbe
+	for _, p := range proxies {
+		for _, s := range sslContexts {
+			for _, u := range urls {
+				probes = append(probes, networkProbe{ProxyWhich: p, SSLContext: s, URL: u})
+			}
+		}
+	}
+
+	results := make([]networkProbeResult, len(probes))
+	jobs := make(chan int)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < networkDebugWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				p := probes[j]
+				results[j] = runNetworkProbe(p, proxyFuncs[p.ProxyWhich], tlsConfigs[p.SSLContext], data)
+			}
+		}()
+	}
+	for i := range probes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	skew, skewErr := measureClockSkew(server)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "BlenderKit-Client v%s network debug, server=%s\n", ClientVersion, server)
+	if skewErr != nil {
+		fmt.Fprintf(&b, "clock skew: unknown (%v)\n\n", skewErr)
+	} else {
+		fmt.Fprintf(&b, "clock skew: %v (local clock minus server time)\n\n", skew)
+	}
+	if pac != nil {
+		fmt.Fprintf(&b, "PAC script: %s\n", pacURL)
+		for _, rawURL := range urls {
+			decision := "DIRECT"
+			u, err := url.Parse(rawURL)
+			if err == nil {
+				decision, err = pac.FindProxy(u)
+			}
+			if err != nil {
+				decision = fmt.Sprintf("ERROR: %v, connecting directly", err)
+			}
+			fmt.Fprintf(&b, "PAC decision for %s: %s\n", rawURL, decision)
+		}
+		b.WriteString("\n")
+	}
+	for _, r := range results {
+		status := fmt.Sprintf("%d", r.StatusCode)
+		if r.Err != nil {
+			status = fmt.Sprintf("ERROR: %v", r.Err)
+		} else if r.Rejected() {
+			status += " (reachable but rejected)"
+		}
+		fmt.Fprintf(&b, "[proxy=%s ssl=%s] %s\n    status=%s latency=%v remote=%s tls=%s\n",
+			r.ProxyWhich, r.SSLContext, r.URL, status, r.Latency.Round(time.Millisecond), r.RemoteAddr, r.TLSVersion)
+	}
+	b.WriteString("\nDIAGNOSIS:\n")
+	for _, line := range diagnoseNetwork(results) {
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+	if skewErr == nil && skew.Abs() > ClockSkewThreshold {
+		fmt.Fprintf(&b, "- system clock is off by %v → certificates and tokens may be rejected, fix the date and time settings\n", skew)
+	}
+	return b.String()
+}
+
+// runNetworkProbe performs one request of the network debug with its own transport, so no connections are reused between probes.
+func runNetworkProbe(p networkProbe, proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config, data MinimalTaskData) networkProbeResult {
+	result := networkProbeResult{networkProbe: p}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	req.Header = getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			result.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	result.StatusCode = resp.StatusCode
+	if resp.TLS != nil {
+		result.TLSVersion = tls.VersionName(resp.TLS.Version)
+	}
+	return result
+}
+
+// diagnoseNetwork compares the results of probes grouped by proxy and SSL settings and returns human readable conclusions.
+// Proxy and certificate problems are found by which probes reached the server, rejected probes are reported separately.
+func diagnoseNetwork(results []networkProbeResult) []string {
+	reachedBy := func(match func(networkProbeResult) bool) (reached, total int) {
+		for _, r := range results {
+			if match(r) {
+				total++
+				if r.Reached() {
+					reached++
+				}
+			}
+		}
+		return reached, total
+	}
+	allReached, all := reachedBy(func(networkProbeResult) bool { return true })
+	systemReached, system := reachedBy(func(r networkProbeResult) bool { return r.ProxyWhich == "SYSTEM" })
+	directReached, direct := reachedBy(func(r networkProbeResult) bool { return r.ProxyWhich == "NONE" })
+	sslReached, ssl := reachedBy(func(r networkProbeResult) bool { return r.SSLContext == "ENABLED" })
+	noSSLReached, _ := reachedBy(func(r networkProbeResult) bool { return r.SSLContext == "DISABLED" })
+	var rejected int
+	for _, r := range results {
+		if r.Rejected() {
+			rejected++
+		}
+	}
+
+	var diagnosis []string
+	switch {
+	case all == 0:
+		return []string{"no probes were run"}
+	case allReached == all && rejected == 0:
+		return []string{"all probes succeeded, connection to the server looks OK"}
+	case allReached == 0:
+		return []string{"all probes failed → server is unreachable, check internet connection, firewall or antivirus"}
+	}
+	if system > 0 && systemReached == 0 && directReached > 0 {
+		diagnosis = append(diagnosis, "all probes with SYSTEM proxy failed, direct succeeded → proxy problem, try setting proxy to NONE")
+	}
+	if direct > 0 && directReached == 0 && systemReached > 0 {
+		diagnosis = append(diagnosis, "all direct probes failed, SYSTEM proxy succeeded → network requires proxy, keep proxy set to SYSTEM")
+	}
+	if ssl > 0 && sslReached == 0 && noSSLReached > 0 {
+		diagnosis = append(diagnosis, "all probes with SSL verification failed, without verification succeeded → certificate problem (antivirus/corporate proxy), set trusted CA certificates or disable SSL verification")
+	}
+	if rejected > 0 {
+		diagnosis = append(diagnosis, fmt.Sprintf("%d of %d probes reached the server but were rejected with 4xx status → requests are refused, e.g. by a firewall or proxy page, check the status of the probes above", rejected, all))
+	}
+	if len(diagnosis) == 0 {
+		diagnosis = append(diagnosis, fmt.Sprintf("%d of %d probes failed without a clear pattern → unstable connection or server problem", all-allReached, all))
+	}
+	return diagnosis
+}
+
+// NetworkSettingsData are the network preferences of the add-on, same as the flags the Client is started with.
+type NetworkSettingsData struct {
+	AppID          int    `json:"app_id"`
+	ProxyWhich     string `json:"proxy_which"`
+	ProxyAddress   string `json:"proxy_address"`
+	ProxyPACURL    string `json:"proxy_pac_url"`
+	ProxyBypass    string `json:"proxy_bypass"`
+	SSLContext     string `json:"ssl_context"`
+	TrustedCACerts string `json:"trusted_ca_certs"`
+}
+
+// ReloadNetworkHandler rebuilds all HTTP clients when the add-on changes the network preferences,
+// so the Client does not have to be restarted. Web pages are rejected by allowSettingsRequest.
+func ReloadNetworkHandler(w http.ResponseWriter, r *http.Request) {
+	var data NetworkSettingsData
+	if !allowSettingsRequest(w, r) || !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateNotEmpty("proxy_which", data.ProxyWhich)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	ReloadNetworkSettings(data)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReloadNetworkSettings swaps the transports of the HTTP clients for new ones created from the settings and informs all
+// connected add-ons. The clients themselves are not replaced, tasks read them without locking. Requests already running
+// finish on the previous transports, whose idle connections are closed so they are not reused.
+func ReloadNetworkSettings(data NetworkSettingsData) {
+	BKLog.Printf("%s Reloading network settings: proxy_which=%s, proxy_address=%s, proxy_pac_url=%s, proxy_bypass=%s, ssl_context=%s, trusted_ca_certs=%s",
+		EmoNetwork, data.ProxyWhich, data.ProxyAddress, data.ProxyPACURL, data.ProxyBypass, data.SSLContext, data.TrustedCACerts)
+	networkSettingsMux.Lock()
+	ProxyPACURL, ProxyBypass = data.ProxyPACURL, data.ProxyBypass
+	next := newClientTransports(data.ProxyAddress, data.ProxyWhich, data.SSLContext, data.TrustedCACerts)
+	if reloadableTransports == nil {
+		setHTTPClients(next)
+	} else {
+		for i, transport := range reloadableTransports {
+			transport.swap(next[i])
+		}
+	}
+	networkSettingsMux.Unlock()
+
+	TasksMux.Lock()
+	appIDs := make([]int, 0, len(Tasks))
+	for appID := range Tasks {
+		appIDs = append(appIDs, appID)
+	}
+	TasksMux.Unlock()
+	for _, appID := range appIDs {
+		task := NewTask(nil, appID, uuid.New().String(), "network_settings_reloaded")
+		task.Message = "Network settings reloaded"
+		task.Status = "finished"
+		task.Result = map[string]interface{}{"proxy_which": data.ProxyWhich, "ssl_context": data.SSLContext}
+		sendTask(AddTaskCh, task)
+	}
+}
+
+var (
+	networkSettingsMux   sync.Mutex             // Serializes reloads of network settings, guards ProxyPACURL and ProxyBypass after the start
+	reloadableTransports []*reloadableTransport // Transports of API, downloads, uploads, big and small thumbnails clients
+)
+
+// reloadableTransport passes the requests to the transport created from the current network settings,
+// ReloadNetworkSettings swaps it while requests run.
+type reloadableTransport struct {
+	current atomic.Pointer[http.Transport]
+}
+
+func newReloadableTransport(transport *http.Transport) *reloadableTransport {
+	t := &reloadableTransport{}
+	t.current.Store(transport)
+	return t
+}
+
+func (t *reloadableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().RoundTrip(req)
+}
+
+// CloseIdleConnections is called by http.Client.CloseIdleConnections.
+func (t *reloadableTransport) CloseIdleConnections() {
+	t.current.Load().CloseIdleConnections()
+}
+
+// swap replaces the transport, idle connections of the previous one are closed.
+func (t *reloadableTransport) swap(next *http.Transport) {
+	if previous := t.current.Swap(next); previous != nil {
+		previous.CloseIdleConnections()
+	}
 }
 
 // CreateHTTPClients creates HTTP clients with proxy settings, assings them to global variables.
+// Called on start, later changes of the settings swap only the transports, see ReloadNetworkSettings.
 // Handles errors gracefully - if any error occurs setting up proxy, it will just default to no proxy.
 func CreateHTTPClients(proxyURL, proxyWhich, sslContext, trustedCACerts string) {
-	proxy := GetProxyFunc(proxyURL, proxyWhich)
+	setHTTPClients(newClientTransports(proxyURL, proxyWhich, sslContext, trustedCACerts))
+}
+
+// newClientTransports creates transports of API, downloads, uploads, big and small thumbnails clients.
+func newClientTransports(proxyURL, proxyWhich, sslContext, trustedCACerts string) []*http.Transport {
+	proxy := withNoProxy(GetProxyFunc(proxyURL, proxyWhich), noProxyList())
 	tlsConfig := GetTLSConfig(sslContext)
 	tlsConfig.RootCAs = GetCACertPool(trustedCACerts)
+	return []*http.Transport{
+		newTransport(proxy, tlsConfig, APIMaxIdleConnsPerHost),
+		newTransport(proxy, tlsConfig, APIMaxIdleConnsPerHost),
+		newTransport(proxy, tlsConfig, APIMaxIdleConnsPerHost),
+		newThumbsTransport(proxy, tlsConfig, BigThumbsMaxIdleConnsPerHost),
+		newThumbsTransport(proxy, tlsConfig, SmallThumbsMaxIdleConnsPerHost),
+	}
+}
 
-	tAPI := http.DefaultTransport.(*http.Transport).Clone()
-	tAPI.TLSClientConfig = tlsConfig
-	tAPI.Proxy = proxy
+func setHTTPClients(transports []*http.Transport) {
+	reloadableTransports = make([]*reloadableTransport, len(transports))
+	for i, transport := range transports {
+		reloadableTransports[i] = newReloadableTransport(transport)
+	}
 	ClientAPI = &http.Client{
-		Transport: tAPI,
-		Timeout:   time.Minute,
+		Transport: &serverStatusTransport{base: reloadableTransports[0]},
+		Timeout:   scaledTimeout(time.Minute),
 	}
-
-	tDownloads := http.DefaultTransport.(*http.Transport).Clone()
-	tDownloads.TLSClientConfig = tlsConfig
-	tDownloads.Proxy = proxy
 	ClientDownloads = &http.Client{
-		Transport: tDownloads,
-		Timeout:   1 * time.Hour,
+		Transport: reloadableTransports[1],
+		Timeout:   scaledTimeout(1 * time.Hour),
 	}
-
-	tUploads := http.DefaultTransport.(*http.Transport).Clone()
-	tUploads.TLSClientConfig = tlsConfig
-	tUploads.Proxy = proxy
 	ClientUploads = &http.Client{
-		Transport: tUploads,
-		Timeout:   24 * time.Hour,
+		Transport: reloadableTransports[2],
+		Timeout:   scaledTimeout(24 * time.Hour),
 	}
-
-	tBigThumbs := http.DefaultTransport.(*http.Transport).Clone()
-	tBigThumbs.TLSClientConfig = tlsConfig
-	tBigThumbs.Proxy = proxy
 	ClientBigThumbs = &http.Client{
-		Transport: tBigThumbs,
-		Timeout:   time.Minute,
+		Transport: reloadableTransports[3],
+		Timeout:   scaledTimeout(BigThumbsTimeout),
 	}
-
-	tSmallThumbs := http.DefaultTransport.(*http.Transport).Clone()
-	tSmallThumbs.TLSClientConfig = tlsConfig
-	tSmallThumbs.Proxy = proxy
 	ClientSmallThumbs = &http.Client{
-		Transport: tSmallThumbs,
-		Timeout:   time.Minute,
+		Transport: reloadableTransports[4],
+		Timeout:   scaledTimeout(SmallThumbsTimeout),
+	}
+}
+
+// scaledTimeout applies TimeoutMultiplier to the default timeout of a client.
+func scaledTimeout(timeout time.Duration) time.Duration {
+	if TimeoutMultiplier <= 0 {
+		return timeout
+	}
+	return time.Duration(float64(timeout) * TimeoutMultiplier)
+}
+
+// withRequestTimeout applies the timeout_s which the add-on can set on a single call.
+// If timeoutS is set, returned context gets that deadline and returned client is a copy without the client-wide timeout,
+// so the per-call timeout replaces the client-wide one instead of being capped by it.
+// The context is derived from ctx, so cancelling the task still aborts the request right away.
+// If timeoutS is not set, ctx and client are returned unchanged. Returned cancel function must always be called.
+func withRequestTimeout(ctx context.Context, client *http.Client, timeoutS float64) (context.Context, context.CancelFunc, *http.Client) {
+	if timeoutS <= 0 {
+		return ctx, func() {}, client
 	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutS*float64(time.Second)))
+	noTimeout := *client
+	noTimeout.Timeout = 0
+	return ctx, cancel, &noTimeout
+}
+
+// newTransport returns a transport with its own connection pool, so idle connections of one client
+// are reused across its requests (e.g. across searches) and not evicted by the other clients.
+func newTransport(proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config, maxIdleConnsPerHost int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.TLSClientConfig = tlsConfig.Clone() // HTTP/2 setup modifies the config, so it cannot be shared between transports
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	if t.MaxIdleConns < maxIdleConnsPerHost {
+		t.MaxIdleConns = maxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = IdleConnTimeout
+	return t
+}
+
+// newThumbsTransport returns a transport of a thumbnail client, which also caps the open connections to maxConnsPerHost,
+// so a batch of thumbnails waits for a free connection instead of opening one per thumbnail.
+func newThumbsTransport(proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config, maxConnsPerHost int) *http.Transport {
+	t := newTransport(proxy, tlsConfig, maxConnsPerHost)
+	t.MaxConnsPerHost = maxConnsPerHost
+	return t
 }
 
 // GetProxyFunc returns a function that can be used as a proxy for HTTP client.
@@ -89,11 +494,14 @@ func GetProxyFunc(proxyURL, proxyWhich string) func(*http.Request) (*url.URL, er
 	switch proxyWhich {
 	case "SYSTEM":
 		BKLog.Printf("%s Using proxy settings from system network settings", EmoOK)
-		p := proxy.NewProvider("").GetProxy("https", "https://blenderkit.com")
-		if p == nil {
-			return noProxy
+		return (&systemProxy{resolve: resolveSystemProxy}).Proxy
+	case "PAC":
+		if ProxyPACURL == "" {
+			BKLog.Printf("%s Defaulting to no proxy, proxy_pac_url is not set for PAC proxy", EmoWarning)
+			break
 		}
-		return http.ProxyURL(p.URL())
+		BKLog.Printf("%s Using proxy auto-configuration from %s", EmoOK, ProxyPACURL)
+		return newPACProxy(ProxyPACURL).Proxy
 	case "ENVIRONMENT":
 		BKLog.Printf("%s Using proxy settings only from environment variables HTTP_PROXY and HTTPS_PROXY", EmoOK)
 		return http.ProxyFromEnvironment
@@ -114,6 +522,129 @@ func GetProxyFunc(proxyURL, proxyWhich string) func(*http.Request) (*url.URL, er
 	return noProxy
 }
 
+// ProxyBypass lists hosts which are connected directly even when a proxy is set, in the format of NO_PROXY
+// environment variable, e.g. "*.s3.amazonaws.com,10.0.0.0/8". Useful when the proxy blocks uploads to S3.
+var ProxyBypass string
+
+// noProxyList returns the hosts which bypass the proxy: always localhost, then NO_PROXY environment variable and ProxyBypass.
+func noProxyList() string {
+	list := []string{"localhost", "127.0.0.1", "::1"}
+	for _, value := range []string{os.Getenv("NO_PROXY"), os.Getenv("no_proxy"), ProxyBypass} {
+		if value != "" {
+			list = append(list, value)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// withNoProxy wraps the proxy func so hosts matching the NO_PROXY style list are connected directly.
+// Entries are hostnames matching also their subdomains ("example.com"), only subdomains (".example.com" or "*.example.com"),
+// IP addresses or CIDR ranges, optionally with a port ("example.com:8080"). Entry "*" bypasses the proxy for all hosts.
+func withNoProxy(proxy func(*http.Request) (*url.URL, error), list string) func(*http.Request) (*url.URL, error) {
+	if proxy == nil {
+		return nil
+	}
+	rules := parseNoProxy(list)
+	return func(req *http.Request) (*url.URL, error) {
+		if matchNoProxy(rules, req.URL.Hostname(), req.URL.Port()) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+type noProxyRule struct {
+	host       string // lowercase hostname or IP, without the leading dot
+	subdomains bool   // only subdomains of host match, not host itself
+	network    *net.IPNet
+	port       string
+}
+
+func parseNoProxy(list string) []noProxyRule {
+	var rules []noProxyRule
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rules = append(rules, noProxyRule{network: network})
+			continue
+		}
+		rule := noProxyRule{host: entry}
+		if host, port, err := net.SplitHostPort(entry); err == nil {
+			rule.host, rule.port = host, port
+		}
+		rule.host = strings.Trim(rule.host, "[]")
+		if strings.HasPrefix(rule.host, "*.") || strings.HasPrefix(rule.host, ".") {
+			rule.host, rule.subdomains = strings.TrimPrefix(strings.TrimPrefix(rule.host, "*"), "."), true
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func matchNoProxy(rules []noProxyRule, host, port string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, rule := range rules {
+		switch {
+		case rule.port != "" && rule.port != port:
+		case rule.host == "*":
+			return true
+		case rule.network != nil:
+			if ip != nil && rule.network.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if ruleIP := net.ParseIP(rule.host); ruleIP != nil && ruleIP.Equal(ip) {
+				return true
+			}
+		case strings.HasSuffix(host, "."+rule.host):
+			return true
+		case host == rule.host && !rule.subdomains:
+			return true
+		}
+	}
+	return false
+}
+
+// SystemProxyTTL is how long the proxy from system network settings is used before it is resolved again,
+// so switching between direct and proxied networks (e.g. docking a laptop) does not need a restart.
+var SystemProxyTTL = 60 * time.Second
+
+// systemProxy is a proxy func caching the proxy from the system network settings for SystemProxyTTL.
+type systemProxy struct {
+	mux      sync.Mutex
+	resolve  func() *url.URL
+	url      *url.URL
+	resolved time.Time
+}
+
+func (p *systemProxy) Proxy(*http.Request) (*url.URL, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.resolved.IsZero() && time.Since(p.resolved) < SystemProxyTTL {
+		return p.url, nil
+	}
+
+	u := p.resolve()
+	if !p.resolved.IsZero() && fmt.Sprint(u) != fmt.Sprint(p.url) {
+		BKLog.Printf("%s System proxy changed from %v to %v", EmoNetwork, p.url, u)
+	}
+	p.url, p.resolved = u, time.Now()
+	return p.url, nil
+}
+
+// resolveSystemProxy returns the proxy from the system network settings, nil if there is none.
+func resolveSystemProxy() *url.URL {
+	p := proxy.NewProvider("").GetProxy("https", "https://blenderkit.com")
+	if p == nil {
+		return nil
+	}
+	return p.URL()
+}
+
 func GetTLSConfig(sslContext string) *tls.Config {
 	switch sslContext {
 	case "ENABLED":
@@ -0,0 +1,365 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiagnoseNetwork(t *testing.T) {
+	probe := func(proxy, ssl string, ok bool) networkProbeResult {
+		r := networkProbeResult{networkProbe: networkProbe{ProxyWhich: proxy, SSLContext: ssl}, StatusCode: 200}
+		if !ok {
+			r.Err = fmt.Errorf("connection refused")
+		}
+		return r
+	}
+	rejected := func(proxy, ssl string) networkProbeResult {
+		return networkProbeResult{networkProbe: networkProbe{ProxyWhich: proxy, SSLContext: ssl}, StatusCode: 403}
+	}
+	tests := []struct {
+		name     string
+		results  []networkProbeResult
+		contains string
+	}{
+		{"all OK", []networkProbeResult{probe("SYSTEM", "ENABLED", true), probe("NONE", "ENABLED", true)}, "all probes succeeded"},
+		{"all failed", []networkProbeResult{probe("SYSTEM", "ENABLED", false), probe("NONE", "ENABLED", false)}, "server is unreachable"},
+		{"proxy problem", []networkProbeResult{probe("SYSTEM", "ENABLED", false), probe("SYSTEM", "DISABLED", false), probe("NONE", "ENABLED", true), probe("NONE", "DISABLED", true)}, "proxy problem"},
+		{"proxy required", []networkProbeResult{probe("SYSTEM", "ENABLED", true), probe("NONE", "ENABLED", false)}, "network requires proxy"},
+		{"certificate problem", []networkProbeResult{probe("SYSTEM", "ENABLED", false), probe("SYSTEM", "DISABLED", true), probe("NONE", "ENABLED", false), probe("NONE", "DISABLED", true)}, "certificate problem"},
+		{"all rejected", []networkProbeResult{rejected("SYSTEM", "ENABLED"), rejected("NONE", "ENABLED")}, "2 of 2 probes reached the server but were rejected"},
+		{"rejected through proxy", []networkProbeResult{rejected("SYSTEM", "ENABLED"), probe("NONE", "ENABLED", false)}, "network requires proxy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnosis := strings.Join(diagnoseNetwork(tt.results), "\n")
+			if !strings.Contains(diagnosis, tt.contains) {
+				t.Errorf("diagnoseNetwork() = %q; want containing %q", diagnosis, tt.contains)
+			}
+		})
+	}
+}
+
+func TestTraceDetail(t *testing.T) {
+	trace := NewRequestTrace("search")
+	err := fmt.Errorf("search - performing request: %w", trace.Wrap(fmt.Errorf("connection attempt failed")))
+	detail := TraceDetail(err)
+	if !strings.HasPrefix(detail, "search request timings:") {
+		t.Errorf("TraceDetail() = %q; want search request timings", detail)
+	}
+	if TraceDetail(fmt.Errorf("plain error")) != "" {
+		t.Errorf("TraceDetail() of untraced error should be empty")
+	}
+}
+
+// TestThumbnailConnectionReuse downloads 50 thumbnails in consecutive parallel batches, same as after a search,
+// and counts how many connections were opened with the default transport and with the tuned one.
+func TestThumbnailConnectionReuse(t *testing.T) {
+	const downloads, batchSize = 50, 10
+	countConnections := func(transport *http.Transport) int64 {
+		var opened int64
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond) // keep the batch in flight at the same time
+			w.Write([]byte("thumbnail"))
+		}))
+		server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt64(&opened, 1)
+			}
+		}
+		server.Start()
+		defer server.Close()
+		defer transport.CloseIdleConnections()
+
+		client := &http.Client{Transport: transport}
+		for done := 0; done < downloads; done += batchSize {
+			var wg sync.WaitGroup
+			for i := 0; i < batchSize; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := client.Get(server.URL)
+					if err != nil {
+						t.Errorf("thumbnail download failed: %v", err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}()
+			}
+			wg.Wait()
+		}
+		return atomic.LoadInt64(&opened)
+	}
+
+	before := countConnections(http.DefaultTransport.(*http.Transport).Clone())
+	after := countConnections(newTransport(nil, nil, SmallThumbsMaxIdleConnsPerHost))
+	t.Logf("connections opened for %d thumbnails: default transport=%d, tuned transport=%d", downloads, before, after)
+	if after > batchSize {
+		t.Errorf("tuned transport opened %d connections; want at most %d (one batch)", after, batchSize)
+	}
+	if after > before {
+		t.Errorf("tuned transport opened more connections (%d) than default transport (%d)", after, before)
+	}
+}
+
+func TestTimeoutMultiplier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second): // slow server
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	defer func(m float64) {
+		TimeoutMultiplier = m
+		CreateHTTPClients("", "NONE", "ENABLED", "")
+	}(TimeoutMultiplier)
+	TimeoutMultiplier = 0.001
+	CreateHTTPClients("", "NONE", "ENABLED", "")
+
+	start := time.Now()
+	resp, err := ClientAPI.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("request to slow server succeeded; want timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request failed after %v; want to fail fast with multiplied timeout", elapsed)
+	}
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte("slow but fine"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		timeoutS float64
+		wantErr  bool
+	}{
+		{"client-wide timeout applies", 0, true},
+		{"longer timeout_s replaces client-wide timeout", 5, false},
+		{"shorter timeout_s", 0.05, true},
+	}
+	for _, test := range tests {
+		client := &http.Client{Timeout: 50 * time.Millisecond}
+		ctx, cancel, c := withRequestTimeout(context.Background(), client, test.timeoutS)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := c.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v; want error %t", test.name, err, test.wantErr)
+		}
+		if client.Timeout != 50*time.Millisecond {
+			t.Errorf("%s: original client timeout changed to %v", test.name, client.Timeout)
+		}
+	}
+}
+
+func TestSystemProxyCache(t *testing.T) {
+	defer func(ttl time.Duration) { SystemProxyTTL = ttl }(SystemProxyTTL)
+	SystemProxyTTL = 50 * time.Millisecond
+
+	docked, _ := url.Parse("http://proxy.office:3128")
+	var current *url.URL
+	resolved := 0
+	p := &systemProxy{resolve: func() *url.URL {
+		resolved++
+		return current
+	}}
+	req := httptest.NewRequest("GET", "https://www.blenderkit.com", nil)
+
+	tests := []struct {
+		name     string
+		system   *url.URL
+		wait     time.Duration
+		expected *url.URL
+		resolved int
+	}{
+		{"direct network", nil, 0, nil, 1},
+		{"docked within TTL uses cached", docked, 0, nil, 1},
+		{"docked after TTL", docked, 60 * time.Millisecond, docked, 2},
+		{"undocked after TTL", nil, 60 * time.Millisecond, nil, 3},
+	}
+	for _, test := range tests {
+		current = test.system
+		time.Sleep(test.wait)
+		u, err := p.Proxy(req)
+		if err != nil || u != test.expected {
+			t.Errorf("%s: Proxy() = %v, %v; want %v", test.name, u, err, test.expected)
+		}
+		if resolved != test.resolved {
+			t.Errorf("%s: system proxy resolved %d times; want %d", test.name, resolved, test.resolved)
+		}
+	}
+}
+
+func TestReloadNetworkHandler(t *testing.T) {
+	defer CreateHTTPClients("", "NONE", "ENABLED", "")
+	CreateHTTPClients("", "NONE", "ENABLED", "")
+	oldAPI := ClientAPI
+	apiTransport := func() *http.Transport {
+		return ClientAPI.Transport.(*serverStatusTransport).base.(*reloadableTransport).current.Load()
+	}
+	oldTransport := apiTransport()
+	TasksMux.Lock()
+	if Tasks[6221] == nil {
+		Tasks[6221] = make(map[string]*Task)
+	}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 6221)
+		TasksMux.Unlock()
+	}()
+	reload := func(body, origin, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/settings/reload_network", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		ReloadNetworkHandler(rr, req)
+		return rr
+	}
+
+	for _, body := range []string{`{"app_id": 6221}`, `{"proxy_which": "NONE"}`} {
+		if rr := reload(body, "", "application/json"); rr.Code != http.StatusBadRequest {
+			t.Errorf("reload with %s = %d; want 400", body, rr.Code)
+		}
+	}
+	body := `{"app_id": 6221, "proxy_which": "CUSTOM", "proxy_address": "http://proxy.office:3128", "ssl_context": "ENABLED"}`
+	if rr := reload(body, "https://evil.example", "application/json"); rr.Code != http.StatusForbidden {
+		t.Errorf("reload from a web page = %d; want 403", rr.Code)
+	}
+	if rr := reload(body, "", "text/plain"); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("reload with text/plain body = %d; want 415", rr.Code)
+	}
+	if apiTransport() != oldTransport {
+		t.Fatal("rejected requests replaced the HTTP transports")
+	}
+
+	if rr := reload(body, "", "application/json"); rr.Code != http.StatusOK {
+		t.Fatalf("reload = %d; want 200", rr.Code)
+	}
+	if ClientAPI != oldAPI {
+		t.Fatal("HTTP clients were replaced, running tasks read them without locking")
+	}
+	if apiTransport() == oldTransport {
+		t.Fatal("HTTP transports were not swapped")
+	}
+	u, err := apiTransport().Proxy(httptest.NewRequest("GET", "https://www.blenderkit.com", nil))
+	if err != nil || u == nil || u.Host != "proxy.office:3128" {
+		t.Errorf("reloaded client proxy = %v, %v; want proxy.office:3128", u, err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case task := <-AddTaskCh:
+			if task.AppID != 6221 {
+				continue
+			}
+			if task.TaskType != "network_settings_reloaded" || task.Status != "finished" {
+				t.Errorf("app got task %s with status %s; want finished network_settings_reloaded", task.TaskType, task.Status)
+			}
+			return
+		case <-timeout:
+			t.Fatal("app was not informed about reloaded network settings")
+		}
+	}
+}
+
+func TestWithNoProxy(t *testing.T) {
+	corpProxy, _ := url.Parse("http://proxy.corp:3128")
+	proxy := withNoProxy(http.ProxyURL(corpProxy), "localhost,127.0.0.1,::1,*.s3.amazonaws.com, .internal.example ,example.org,10.0.0.0/8,registry.example:5000")
+	tests := []struct {
+		url    string
+		direct bool
+	}{
+		{"http://localhost:62485/report", true},
+		{"http://127.0.0.1:62485/report", true},
+		{"http://[::1]:62485/report", true},
+		{"https://blenderkit-uploads.s3.amazonaws.com/file.blend?X-Amz-Signature=abc", true},
+		{"https://s3.amazonaws.com/bucket/file.blend", false},
+		{"https://api.internal.example/", true},
+		{"https://internal.example/", false},
+		{"https://EXAMPLE.org/", true},
+		{"https://www.example.org/", true},
+		{"https://notexample.org/", false},
+		{"http://10.1.2.3/", true},
+		{"http://11.1.2.3/", false},
+		{"https://registry.example:5000/", true},
+		{"https://registry.example/", false},
+		{"https://www.blenderkit.com/api/v1/search/", false},
+	}
+	for _, test := range tests {
+		u, err := proxy(httptest.NewRequest("GET", test.url, nil))
+		if err != nil {
+			t.Errorf("proxy(%s) failed: %v", test.url, err)
+			continue
+		}
+		if direct := u == nil; direct != test.direct {
+			t.Errorf("proxy(%s) = %v; want direct %v", test.url, u, test.direct)
+		}
+	}
+
+	if withNoProxy(nil, "localhost") != nil {
+		t.Error("withNoProxy(nil) returned a proxy func; want nil so no proxy is used")
+	}
+	all := withNoProxy(http.ProxyURL(corpProxy), "*")
+	if u, _ := all(httptest.NewRequest("GET", "https://www.blenderkit.com/", nil)); u != nil {
+		t.Errorf("proxy with NO_PROXY=* = %v; want direct", u)
+	}
+}
+
+func TestNoProxyList(t *testing.T) {
+	defer func(bypass string) { ProxyBypass = bypass }(ProxyBypass)
+	t.Setenv("NO_PROXY", "corp.example")
+	t.Setenv("no_proxy", "")
+	ProxyBypass = "*.s3.amazonaws.com"
+
+	expected := "localhost,127.0.0.1,::1,corp.example,*.s3.amazonaws.com"
+	if list := noProxyList(); list != expected {
+		t.Errorf("noProxyList() = %q; want %q", list, expected)
+	}
+}
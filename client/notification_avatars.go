@@ -0,0 +1,195 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	MaxNotificationAvatars = 20   // Avatars are resolved only for the first unread notifications
+	MaxCachedAuthors       = 1000 // Authors from search results kept in AuthorProfiles
+)
+
+// AuthorProfileStore keeps the authors embedded in search results, so avatars of notification actors can be resolved
+// without fetching their profiles. Oldest authors are forgotten over MaxCachedAuthors.
+type AuthorProfileStore struct {
+	mux     sync.Mutex
+	authors map[string]Author
+	order   []string // IDs in the order they were first remembered
+}
+
+var AuthorProfiles = &AuthorProfileStore{authors: map[string]Author{}}
+
+// Remember stores the authors of the search results.
+func (s *AuthorProfileStore) Remember(searchResult SearchResults) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, result := range searchResult.Results {
+		id := string(result.Author.ID)
+		if id == "" {
+			continue
+		}
+		if _, known := s.authors[id]; !known {
+			s.order = append(s.order, id)
+		}
+		s.authors[id] = result.Author
+	}
+	for len(s.order) > MaxCachedAuthors {
+		delete(s.authors, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Clear forgets all authors, used when the server changes.
+func (s *AuthorProfileStore) Clear() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.authors = map[string]Author{}
+	s.order = nil
+}
+
+// Get returns the remembered author.
+func (s *AuthorProfileStore) Get(id string) (Author, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	author, ok := s.authors[id]
+	return author, ok
+}
+
+// NotificationsResult is the result of the notifications task, the notifications with avatars of their actors.
+type NotificationsResult struct {
+	NotificationData
+	ActorAvatars map[int]string `json:"actor_avatars"` // gravatar path by notification ID
+}
+
+// notificationActorID returns ID of the user who caused the notification, empty if the actor is not a user.
+func notificationActorID(actor NotificationActor) string {
+	if actor.ContentTypeModel != "user" {
+		return ""
+	}
+	switch pk := actor.PK.(type) {
+	case float64:
+		return strconv.FormatInt(int64(pk), 10)
+	case string:
+		return pk
+	}
+	return ""
+}
+
+// fetchAuthor gets the author from any of the assets of the author, the profiles of other users are not public.
+func fetchAuthor(id string, data MinimalTaskData) (Author, error) {
+	query := url.Values{"query": {"author_id:" + id}, "page_size": {"1"}}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/search/?%s", currentServer(), query.Encode()), nil)
+	if err != nil {
+		return Author{}, err
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	resp, err := doAPI(ClientAPI, req)
+	if err != nil {
+		return Author{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		return Author{}, fmt.Errorf("%s (%s)", respString, resp.Status)
+	}
+	var results SearchResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return Author{}, fmt.Errorf("decoding response: %w", err)
+	}
+	AuthorProfiles.Remember(results)
+	if len(results.Results) == 0 {
+		return Author{}, fmt.Errorf("author %s has no public assets", id)
+	}
+	return results.Results[0].Author, nil
+}
+
+// fetchActorAvatar resolves the actor to the author and returns path to the avatar, downloaded if needed.
+func fetchActorAvatar(actorID string, data MinimalTaskData) (string, error) {
+	author, ok := AuthorProfiles.Get(actorID)
+	if !ok {
+		var err error
+		if author, err = fetchAuthor(actorID, data); err != nil {
+			return "", fmt.Errorf("resolving author: %w", err)
+		}
+	}
+	id, err := strconv.Atoi(string(author.ID))
+	if err != nil {
+		return "", fmt.Errorf("invalid author ID %q", author.ID)
+	}
+	gravatar := FetchGravatarData{
+		AddonVersion:    data.AddonVersion,
+		PlatformVersion: data.PlatformVersion,
+		ID:              id,
+		Avatar128:       author.Avatar128,
+		GravatarHash:    author.GravatarHash,
+	}
+	path, _, err := fetchGravatarImage(gravatar, data.RequestID)
+	return path, err
+}
+
+// fetchNotificationAvatars downloads avatars of the actors of the first MaxNotificationAvatars unread notifications.
+// Each actor is fetched once, failures are only logged as the notifications are shown without avatars.
+func fetchNotificationAvatars(notifications []Notification, data MinimalTaskData) map[int]string {
+	byActor := map[string][]int{}
+	var actors []string
+	resolved := 0
+	for _, notification := range notifications {
+		if resolved >= MaxNotificationAvatars {
+			break
+		}
+		actorID := notificationActorID(notification.Actor)
+		if !notification.Unread || actorID == "" {
+			continue
+		}
+		resolved++
+		if byActor[actorID] == nil {
+			actors = append(actors, actorID)
+		}
+		byActor[actorID] = append(byActor[actorID], notification.ID)
+	}
+
+	avatars := map[int]string{}
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for _, actorID := range actors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, err := fetchActorAvatar(actorID, data)
+			if err != nil {
+				BKLog.Printf("%s Avatar of notification actor %s: %v", EmoWarning, actorID, err)
+				return
+			}
+			mux.Lock()
+			for _, notificationID := range byActor[actorID] {
+				avatars[notificationID] = path
+			}
+			mux.Unlock()
+		}()
+	}
+	wg.Wait()
+	return avatars
+}
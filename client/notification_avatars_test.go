@@ -0,0 +1,94 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchNotificationAvatars(t *testing.T) {
+	var profileFetches, avatarFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/search/":
+			profileFetches.Add(1)
+			if r.URL.Query().Get("query") != "author_id:6651" {
+				fmt.Fprint(w, `{"results": []}`)
+				return
+			}
+			fmt.Fprint(w, `{"results": [{"author": {"id": 6651, "avatar128": "/avatar/6651/"}}]}`)
+		default:
+			avatarFetches.Add(1)
+			w.Write([]byte("avatar"))
+		}
+	}))
+	defer server.Close()
+	defer func(api, thumbs *http.Client) { ClientAPI, ClientSmallThumbs = api, thumbs }(ClientAPI, ClientSmallThumbs)
+	defer setServer(setServer(server.URL))
+	ClientAPI, ClientSmallThumbs = server.Client(), server.Client()
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AuthorProfiles.Remember(SearchResults{Results: []Asset{{Author: Author{ID: "6652", Avatar128: "/avatar/6652/"}}}})
+
+	user := func(pk interface{}) NotificationActor { return NotificationActor{PK: pk, ContentTypeModel: "user"} }
+	notifications := []Notification{
+		{ID: 1, Actor: user(float64(6651)), Unread: true}, // profile fetched
+		{ID: 2, Actor: user("6651"), Unread: true},        // same actor, fetched once
+		{ID: 3, Actor: user(float64(6652)), Unread: true}, // author from search results
+		{ID: 4, Actor: user(float64(6653)), Unread: true}, // no public assets
+		{ID: 5, Actor: user(float64(6652))},               // read already
+		{ID: 6, Actor: NotificationActor{PK: float64(6652), ContentTypeModel: "asset"}, Unread: true},
+	}
+	for i := 0; i < MaxNotificationAvatars; i++ {
+		notifications = append(notifications, Notification{ID: 100 + i, Actor: user(float64(6652)), Unread: true})
+	}
+
+	avatars := fetchNotificationAvatars(notifications, MinimalTaskData{AppID: 665})
+	expected := map[int]string{
+		1: filepath.Join(safeTemp, gravatar_dirname, "6651.jpg"),
+		2: filepath.Join(safeTemp, gravatar_dirname, "6651.jpg"),
+		3: filepath.Join(safeTemp, gravatar_dirname, "6652.jpg"),
+	}
+	for i := 0; i < MaxNotificationAvatars-4; i++ { // the first 4 unread notifications of users count to the limit
+		expected[100+i] = filepath.Join(safeTemp, gravatar_dirname, "6652.jpg")
+	}
+	if len(avatars) != len(expected) {
+		t.Errorf("got avatars of %d notifications; want %d: %v", len(avatars), len(expected), avatars)
+	}
+	for id, path := range expected {
+		if avatars[id] != path {
+			t.Errorf("avatar of notification %d = %q; want %q", id, avatars[id], path)
+		}
+	}
+	if n := profileFetches.Load(); n != 2 {
+		t.Errorf("%d profile fetches; want 2 for the actors not in search results", n)
+	}
+	if n := avatarFetches.Load(); n != 2 {
+		t.Errorf("%d avatar downloads; want 2, one per actor", n)
+	}
+}
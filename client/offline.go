@@ -0,0 +1,387 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blenderkit/blenderkit/client/internal/api"
+)
+
+const offlineJournalFilename = "offline_queue.json" // journal of queued operations in GetSafeTempPath()
+
+// OfflineCheckInterval is how often the connectivity watcher checks the server when there are queued operations.
+var OfflineCheckInterval = 30 * time.Second
+
+// OfflineOperation is a write request which failed because the server was unreachable.
+// Only the intent is stored, the journal holds no credentials: the URL is rebuilt from currentServer()
+// and the headers from the credentials of the connected add-ons when the operation is replayed.
+type OfflineOperation struct {
+	Key       string    `json:"key"` // Operations with the same key replace each other, e.g. rating of the same asset
+	AppID     int       `json:"app_id"`
+	TaskID    string    `json:"task_id"`
+	TaskType  string    `json:"task_type"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"` // relative to the server, with the query
+	Body      []byte    `json:"body"`
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+// OfflineQueueStatus is reported in /healthz.
+type OfflineQueueStatus struct {
+	Length        int       `json:"length"`
+	Replaying     bool      `json:"replaying"`
+	LastReplay    time.Time `json:"last_replay"`
+	LastReplayErr string    `json:"last_replay_error,omitempty"`
+}
+
+// OfflineQueue holds the queued operations in order and persists them into a JSON journal,
+// so they survive restart of the Client.
+type OfflineQueue struct {
+	mux           sync.Mutex
+	path          string
+	ops           []OfflineOperation
+	replaying     bool
+	lastReplay    time.Time
+	lastReplayErr string
+}
+
+var Offline = &OfflineQueue{}
+
+// LoadOfflineQueue reads the journal from the safe temp path. Missing journal means empty queue.
+func LoadOfflineQueue() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return Offline.load(filepath.Join(tempDir, offlineJournalFilename))
+}
+
+func (q *OfflineQueue) load(path string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.path = path
+	q.ops = nil
+	journal, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(journal, &q.ops); err != nil {
+		return fmt.Errorf("corrupted offline journal %s: %w", path, err)
+	}
+	ops := q.ops[:0]
+	for _, op := range q.ops {
+		if op.Path == "" { // journal of older Client stored whole requests with the credentials
+			BKLog.Printf("%s Dropping queued %s (%s) of older Client", EmoWarning, op.TaskType, op.TaskID)
+			continue
+		}
+		ops = append(ops, op)
+	}
+	q.ops = ops
+	if len(q.ops) > 0 {
+		BKLog.Printf("%s Loaded %d queued offline operations", EmoNetwork, len(q.ops))
+	}
+	return nil
+}
+
+// save writes the journal, must be called with q.mux locked.
+func (q *OfflineQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	journal, err := json.Marshal(q.ops)
+	if err != nil {
+		return err
+	}
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, journal, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, q.path)
+}
+
+// Add appends the operation to the queue. Earlier operation with the same key is dropped and returned,
+// so only the latest rating of an asset is sent after reconnecting.
+func (q *OfflineQueue) Add(op OfflineOperation) (*OfflineOperation, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var replaced *OfflineOperation
+	for i, queued := range q.ops {
+		if queued.Key == op.Key {
+			replaced = &queued
+			q.ops = append(q.ops[:i], q.ops[i+1:]...)
+			break
+		}
+	}
+	q.ops = append(q.ops, op)
+	return replaced, q.save()
+}
+
+func (q *OfflineQueue) Status() OfflineQueueStatus {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return OfflineQueueStatus{
+		Length:        len(q.ops),
+		Replaying:     q.replaying,
+		LastReplay:    q.lastReplay,
+		LastReplayErr: q.lastReplayErr,
+	}
+}
+
+// Replay sends queued operations in order. It stops at first operation for which the server is still unreachable,
+// that one and all following stay in the queue. Operations rejected by the server are dropped and reported as errors.
+func (q *OfflineQueue) Replay() {
+	q.mux.Lock()
+	if q.replaying || len(q.ops) == 0 {
+		q.mux.Unlock()
+		return
+	}
+	q.replaying = true
+	q.mux.Unlock()
+
+	var replayErr error
+	for {
+		q.mux.Lock()
+		if len(q.ops) == 0 {
+			q.mux.Unlock()
+			break
+		}
+		op := q.ops[0]
+		q.mux.Unlock()
+
+		meta, connected := offlineCredentials(op.AppID)
+		if !connected {
+			replayErr = errors.New("no add-on is connected, queued operations wait for its credentials")
+			break
+		}
+		var result interface{}
+		var err error
+		if meta.APIKey == "" {
+			err = errors.New("user logged out")
+		} else {
+			result, err = op.send(meta)
+		}
+		if isNetworkUnreachable(err) {
+			replayErr = err
+			break
+		}
+
+		q.mux.Lock()
+		if len(q.ops) > 0 && q.ops[0].Key == op.Key && q.ops[0].QueuedAt.Equal(op.QueuedAt) {
+			q.ops = q.ops[1:]
+		}
+		if e := q.save(); e != nil {
+			BKLog.Printf("%s Failed to save offline journal: %v", EmoWarning, e)
+		}
+		q.mux.Unlock()
+
+		if err != nil {
+			replayErr = err
+			BKLog.Printf("%s Queued %s (%s) rejected: %v", EmoError, op.TaskType, op.TaskID, err)
+			if taskExists(op.AppID, op.TaskID) {
+				sendTask(TaskErrorCh, &TaskError{AppID: op.AppID, TaskID: op.TaskID, Error: err})
+			}
+			continue
+		}
+		BKLog.Printf("%s Queued %s (%s) sent", EmoOK, op.TaskType, op.TaskID)
+		if taskExists(op.AppID, op.TaskID) {
+			sendTask(TaskFinishCh, &TaskFinish{AppID: op.AppID, TaskID: op.TaskID, Message: "Sent after reconnecting", Result: result})
+		}
+	}
+
+	q.mux.Lock()
+	q.replaying = false
+	q.lastReplay = time.Now()
+	q.lastReplayErr = ""
+	if replayErr != nil {
+		q.lastReplayErr = replayErr.Error()
+	}
+	q.mux.Unlock()
+}
+
+// Clear drops all queued operations, e.g. after logout, as they would be sent on behalf of whoever logs in next.
+func (q *OfflineQueue) Clear(reason string) {
+	q.mux.Lock()
+	ops := q.ops
+	q.ops = nil
+	if err := q.save(); err != nil {
+		BKLog.Printf("%s Failed to save offline journal: %v", EmoWarning, err)
+	}
+	q.mux.Unlock()
+
+	if len(ops) > 0 {
+		BKLog.Printf("%s Dropped %d queued offline operations: %s", EmoWarning, len(ops), reason)
+	}
+	for _, op := range ops {
+		if taskExists(op.AppID, op.TaskID) {
+			sendTask(TaskErrorCh, &TaskError{AppID: op.AppID, TaskID: op.TaskID, Error: fmt.Errorf("not sent, %s", reason)})
+		}
+	}
+}
+
+// offlineCredentials returns the credentials of the add-on which queued the operation, or of another connected add-on
+// when that one is gone, all add-ons share the login. False is returned when no add-on reported recently.
+func offlineCredentials(appID int) (RequestMeta, bool) {
+	softwares := availableSoftwares()
+	if len(softwares) == 0 {
+		return RequestMeta{}, false
+	}
+	software := softwares[0]
+	for _, s := range softwares {
+		if s.AppID == appID {
+			software = s
+		}
+	}
+	return RequestMeta{APIKey: software.apiKey, AddonVersion: software.AddonVersion, PlatformVersion: software.PlatformVersion}, true
+}
+
+// send performs the stored request with the current server and credentials and returns decoded JSON response if there is any.
+func (op OfflineOperation) send(meta RequestMeta) (interface{}, error) {
+	req, err := http.NewRequest(op.Method, currentServer()+op.Path, bytes.NewReader(op.Body))
+	if err != nil {
+		return nil, err
+	}
+	meta.RequestID = op.RequestID
+	req.Header = getHeaders(meta)
+	resp, err := doAPI(ClientAPI, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		return nil, fmt.Errorf("%s (%s)", respString, resp.Status)
+	}
+	result := map[string]interface{}{}
+	if resp.StatusCode != http.StatusNoContent && RespIsJSON(resp) == nil {
+		json.NewDecoder(resp.Body).Decode(&result)
+	}
+	return result, nil
+}
+
+// queueOffline stores the request which failed with *api.RequestError into the offline queue if it failed
+// because the server is unreachable. Task is then switched to "queued" status and true is returned,
+// so the caller should not report the error.
+func queueOffline(key string, task *Task, err error) bool {
+	var reqErr *api.RequestError
+	if !errors.As(err, &reqErr) || !isNetworkUnreachable(reqErr.Err) {
+		return false
+	}
+	path := strings.TrimPrefix(reqErr.URL, currentServer())
+	if path == reqErr.URL { // server was switched meanwhile
+		reqURL, e := url.Parse(reqErr.URL)
+		if e != nil {
+			return false
+		}
+		path = reqURL.RequestURI()
+	}
+	op := OfflineOperation{
+		Key:       key,
+		AppID:     task.AppID,
+		TaskID:    task.TaskID,
+		TaskType:  task.TaskType,
+		RequestID: task.RequestID,
+		Method:    reqErr.Method,
+		Path:      path,
+		Body:      reqErr.Body,
+		QueuedAt:  time.Now(),
+	}
+	replaced, e := Offline.Add(op)
+	if e != nil {
+		BKLog.Printf("%s Failed to queue %s for later: %v", EmoWarning, task.TaskType, e)
+		return false
+	}
+	if replaced != nil && taskExists(replaced.AppID, replaced.TaskID) {
+		sendTask(TaskFinishCh, &TaskFinish{AppID: replaced.AppID, TaskID: replaced.TaskID, Message: "Replaced by newer queued request"})
+	}
+	sendTask(TaskQueuedCh, &TaskQueued{AppID: task.AppID, TaskID: task.TaskID, Message: "Offline, will be sent when connection returns"})
+	return true
+}
+
+// isNetworkUnreachable reports whether request failed before reaching the server: DNS lookup or connection failure.
+func isNetworkUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func taskExists(appID int, taskID string) bool {
+	TasksMux.Lock()
+	defer TasksMux.Unlock()
+	_, exists := Tasks[appID][taskID]
+	return exists
+}
+
+// watchConnectivity periodically checks whether the server is reachable when there are queued operations,
+// and replays them once it is.
+func watchConnectivity() {
+	for {
+		time.Sleep(OfflineCheckInterval)
+		if Offline.Status().Length == 0 {
+			continue
+		}
+		req, err := http.NewRequest("HEAD", currentServer(), nil)
+		if err != nil {
+			continue
+		}
+		resp, err := doAPI(ClientAPI, req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		BKLog.Printf("%s Server reachable again, replaying queued operations", EmoNetwork)
+		Offline.Replay()
+	}
+}
+
+// HealthzHandler reports that the Client is running together with the state of the offline queue.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"status":         "ok",
+		"client_version": ClientVersion,
+		"offline_queue":  Offline.Status(),
+	})
+}
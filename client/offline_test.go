@@ -0,0 +1,195 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsNetworkUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	closedURL := server.URL
+	server.Close()
+	_, dialErr := http.Get(closedURL)
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"no error", nil, false},
+		{"connection refused", dialErr, true},
+		{"other error", fmt.Errorf("server returned 500"), false},
+	}
+	for _, test := range tests {
+		if actual := isNetworkUnreachable(test.err); actual != test.expected {
+			t.Errorf("isNetworkUnreachable(%s) = %t; want %t", test.name, actual, test.expected)
+		}
+	}
+}
+
+// saveSoftwares disconnects the add-ons registered by other tests.
+func saveSoftwares(t *testing.T) {
+	AvailableSoftwaresMux.Lock()
+	softwares := AvailableSoftwares
+	AvailableSoftwares = make(map[int]*Software)
+	AvailableSoftwaresMux.Unlock()
+	t.Cleanup(func() {
+		AvailableSoftwaresMux.Lock()
+		AvailableSoftwares = softwares
+		AvailableSoftwaresMux.Unlock()
+	})
+}
+
+// registerOfflineSoftware connects the add-on whose credentials are used by the replay.
+func registerOfflineSoftware(appID int, apiKey string) {
+	registerSoftware(MinimalTaskData{AppID: appID, APIKey: apiKey, AddonVersion: "3.14.0"})
+}
+
+func TestOfflineQueueReplay(t *testing.T) {
+	var mux sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mux.Lock()
+		received = append(received, r.URL.Path+" "+string(body)+" "+r.Header.Get("Authorization"))
+		mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"score": 4}`))
+	}))
+	defer server.Close()
+
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = &http.Client{Timeout: 5 * time.Second}
+	defer setServer(setServer(server.URL))
+	saveSoftwares(t)
+
+	journal := filepath.Join(t.TempDir(), offlineJournalFilename)
+	q := &OfflineQueue{}
+	if err := q.load(journal); err != nil {
+		t.Fatalf("loading missing journal: %v", err)
+	}
+	ops := []OfflineOperation{
+		{Key: "rating/a/quality", Method: "PUT", Path: "/a", Body: []byte(`{"score":1}`)},
+		{Key: "notification_read/1", Method: "GET", Path: "/n"},
+		{Key: "rating/a/quality", Method: "PUT", Path: "/a", Body: []byte(`{"score":4}`)}, // must replace the first one
+	}
+	for i, op := range ops {
+		op.QueuedAt = time.Now()
+		op.AppID = 6601
+		op.TaskID = fmt.Sprint(i)
+		if _, err := q.Add(op); err != nil {
+			t.Fatalf("adding operation: %v", err)
+		}
+	}
+
+	reloaded := &OfflineQueue{}
+	if err := reloaded.load(journal); err != nil {
+		t.Fatalf("loading journal: %v", err)
+	}
+	if length := reloaded.Status().Length; length != 2 {
+		t.Fatalf("journal has %d operations; want 2 after duplicate suppression", length)
+	}
+
+	reloaded.Replay()
+	if length := reloaded.Status().Length; length != 2 || len(received) != 0 {
+		t.Fatalf("%d operations left, %d sent without connected add-on; want all waiting for credentials", length, len(received))
+	}
+
+	registerOfflineSoftware(6602, "key-of-other-blender") // the add-on which queued them is gone, the user is the same
+	reloaded.Replay()
+	expected := []string{"/n  Bearer key-of-other-blender", `/a {"score":4} Bearer key-of-other-blender`}
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Errorf("replayed %q; want %q", received, expected)
+	}
+	status := reloaded.Status()
+	if status.Length != 0 || status.LastReplayErr != "" {
+		t.Errorf("status after replay = %+v; want empty queue without error", status)
+	}
+}
+
+// Journal holds only the intent of the request, credentials and the server come from the time of the replay.
+func TestQueueOfflineStoresNoCredentials(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	closedURL := server.URL
+	server.Close()
+	defer setServer(setServer(closedURL))
+	defer func(q *OfflineQueue) { Offline = q }(Offline)
+	Offline = &OfflineQueue{}
+	if err := Offline.load(filepath.Join(t.TempDir(), offlineJournalFilename)); err != nil {
+		t.Fatal(err)
+	}
+
+	task := NewTask(nil, 6603, "rating-task", "ratings/send_rating").WithRequestID("request-1")
+	client := serverAPI(RequestMeta{APIKey: "secret-key-0123456789", RequestID: task.RequestID}, task)
+	err := client.PutJSON(context.Background(), "/api/v1/assets/a/rating/quality/?x=1", map[string]int{"score": 4}, nil)
+	if !queueOffline("rating/a/quality", task, err) {
+		t.Fatalf("request to unreachable server was not queued: %v", err)
+	}
+	<-TaskQueuedCh
+
+	journal, err := os.ReadFile(Offline.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(journal), "secret-key-0123456789") || strings.Contains(string(journal), closedURL) {
+		t.Errorf("journal %s contains the credentials or the server", journal)
+	}
+	if op := Offline.ops[0]; op.Path != "/api/v1/assets/a/rating/quality/?x=1" || op.RequestID != "request-1" {
+		t.Errorf("queued %+v; want the path relative to the server and the request ID", op)
+	}
+}
+
+func TestOfflineQueueDropsAfterLogout(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	defer setServer(setServer(server.URL))
+	saveSoftwares(t)
+
+	q := &OfflineQueue{}
+	if err := q.load(filepath.Join(t.TempDir(), offlineJournalFilename)); err != nil {
+		t.Fatal(err)
+	}
+	q.Add(OfflineOperation{Key: "rating/a/quality", AppID: 6604, Method: "PUT", Path: "/a", QueuedAt: time.Now()})
+	registerOfflineSoftware(6604, "") // add-on reports without API key after the user logged out
+	q.Replay()
+	if status := q.Status(); status.Length != 0 || requests.Load() != 0 {
+		t.Errorf("status %+v after %d requests; want the operation dropped unsent", status, requests.Load())
+	}
+
+	q.Add(OfflineOperation{Key: "rating/b/quality", AppID: 6604, Method: "PUT", Path: "/b", QueuedAt: time.Now()})
+	q.Clear("user logged out")
+	if length := q.Status().Length; length != 0 {
+		t.Errorf("%d operations left after logout", length)
+	}
+}
@@ -0,0 +1,201 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy auto-configuration used with proxy_which=PAC: the PAC script is downloaded from ProxyPACURL
+// and its FindProxyForURL decides which proxy is used for each server. Errors of the PAC fall back to direct connection.
+var (
+	ProxyPACURL      string               // URL of the PAC script, set by flag and by reload of network settings
+	PACCacheTTL      = 5 * time.Minute    // how long the PAC script and its decisions are reused
+	PACMaxScriptSize = int64(1024 * 1024) // PAC scripts are small, larger responses are not a PAC
+)
+
+// pacProxy is a proxy func deciding the proxy by the PAC script, decisions are cached per scheme and host.
+type pacProxy struct {
+	mux       sync.Mutex
+	pacURL    string
+	script    *pacScript
+	scriptErr error
+	fetched   time.Time
+	fetching  chan struct{} // closed when the running download of the script finishes, nil if none runs
+	decisions map[string]pacDecision
+}
+
+type pacDecision struct {
+	proxy *url.URL
+	at    time.Time
+}
+
+func newPACProxy(pacURL string) *pacProxy {
+	return &pacProxy{pacURL: pacURL, decisions: map[string]pacDecision{}}
+}
+
+func (p *pacProxy) Proxy(req *http.Request) (*url.URL, error) {
+	key := req.URL.Scheme + "://" + req.URL.Host
+	p.mux.Lock()
+	decision, ok := p.decisions[key]
+	p.mux.Unlock()
+	if ok && time.Since(decision.at) < PACCacheTTL {
+		return decision.proxy, nil
+	}
+
+	result, err := p.FindProxy(req.URL)
+	var proxy *url.URL
+	if err == nil {
+		proxy, err = parsePACResult(result)
+	}
+	if err != nil {
+		BKLog.Printf("%s PAC failed for %s, connecting directly: %v", EmoWarning, key, err)
+	} else if !ok || fmt.Sprint(proxy) != fmt.Sprint(decision.proxy) {
+		BKLog.Printf("%s PAC decided %q for %s", EmoNetwork, result, key)
+	}
+	p.mux.Lock()
+	p.decisions[key] = pacDecision{proxy: proxy, at: time.Now()}
+	p.mux.Unlock()
+	return proxy, nil
+}
+
+// FindProxy returns the result of FindProxyForURL of the PAC script, e.g. "PROXY proxy:8080; DIRECT".
+// Like browsers, only scheme and host of https URLs are passed to the script.
+func (p *pacProxy) FindProxy(u *url.URL) (string, error) {
+	script, err := p.loadScript()
+	if err != nil {
+		return "", err
+	}
+	rawURL := u.String()
+	if u.Scheme == "https" {
+		rawURL = u.Scheme + "://" + u.Host + "/"
+	}
+	return script.FindProxyForURL(rawURL, u.Hostname())
+}
+
+// loadScript returns the PAC script, downloads it again once it is older than PACCacheTTL.
+// The download runs without the lock, meanwhile the previous script is used, only the first download is waited for.
+// If the new download fails, the previous script is kept.
+func (p *pacProxy) loadScript() (*pacScript, error) {
+	p.mux.Lock()
+	for p.fetching != nil && p.script == nil {
+		fetching := p.fetching
+		p.mux.Unlock()
+		<-fetching
+		p.mux.Lock()
+	}
+	if p.fetching != nil || !p.fetched.IsZero() && time.Since(p.fetched) < PACCacheTTL {
+		defer p.mux.Unlock()
+		return p.script, p.scriptErr
+	}
+	fetching := make(chan struct{})
+	p.fetching = fetching
+	p.mux.Unlock()
+
+	script, err := fetchPACScript(p.pacURL)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	close(fetching)
+	p.fetching = nil
+	p.fetched = time.Now()
+	if err != nil && p.script != nil {
+		BKLog.Printf("%s Failed to refresh PAC script, using the previous one: %v", EmoWarning, err)
+		return p.script, nil
+	}
+	p.script, p.scriptErr = script, err
+	p.decisions = map[string]pacDecision{}
+	return script, err
+}
+
+// fetchPACScript downloads the PAC script directly, without any proxy, or reads it from file:// URL.
+func fetchPACScript(pacURL string) (*pacScript, error) {
+	u, err := url.Parse(pacURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PAC URL: %w", err)
+	}
+
+	var body io.Reader
+	switch u.Scheme {
+	case "file":
+		path := u.Path
+		if len(path) > 2 && path[0] == '/' && path[2] == ':' { // file:///C:/proxy.pac on Windows
+			path = path[1:]
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PAC file: %w", err)
+		}
+		defer f.Close()
+		body = f
+	case "http", "https":
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		defer transport.CloseIdleConnections()
+		client := &http.Client{Transport: transport, Timeout: scaledTimeout(10 * time.Second)}
+		resp, err := client.Get(pacURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download PAC script: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to download PAC script: %s", resp.Status)
+		}
+		body = resp.Body
+	default:
+		return nil, fmt.Errorf("unsupported PAC URL scheme %q", u.Scheme)
+	}
+
+	source, err := io.ReadAll(io.LimitReader(body, PACMaxScriptSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PAC script: %w", err)
+	}
+	if int64(len(source)) > PACMaxScriptSize {
+		return nil, fmt.Errorf("PAC script is larger than %d bytes", PACMaxScriptSize)
+	}
+	return parsePACScript(string(source))
+}
+
+// parsePACResult returns the proxy of the first entry of PAC result like "PROXY proxy:8080; DIRECT", nil for DIRECT.
+// HTTP transport cannot fail over to the next entries, so they are ignored.
+func parsePACResult(result string) (*url.URL, error) {
+	entry := strings.TrimSpace(strings.Split(result, ";")[0])
+	if entry == "" {
+		return nil, nil
+	}
+	fields := strings.Fields(entry)
+	scheme := map[string]string{"DIRECT": "", "PROXY": "http", "HTTP": "http", "HTTPS": "https", "SOCKS": "socks5", "SOCKS5": "socks5"}
+	s, ok := scheme[strings.ToUpper(fields[0])]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unsupported PAC result %q", entry)
+	case s == "":
+		return nil, nil
+	case len(fields) != 2:
+		return nil, fmt.Errorf("PAC result %q has no proxy address", entry)
+	}
+	return url.Parse(s + "://" + fields[1])
+}
@@ -0,0 +1,285 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// PAC scripts run in goja JavaScript runtime with the standard PAC helper functions of Netscape's specification
+// (https://developer.mozilla.org/en-US/docs/Web/HTTP/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file).
+// Microsoft's IPv6 extensions like FindProxyForURLEx are not supported.
+
+const pacMaxCallDepth = 64 // protects against endless recursion of the script
+
+var (
+	pacScriptTimeout = 5 * time.Second // protects against endless loops of the script, shortened in tests
+	pacLookupHost    = net.LookupHost  // resolves hostnames for the PAC helper functions, replaced in tests
+	pacNow           = time.Now        // current time for the date and time helper functions, replaced in tests
+)
+
+// pacScript is the loaded PAC script, its runtime is not safe for concurrent use so calls are serialized.
+type pacScript struct {
+	mux       sync.Mutex
+	vm        *goja.Runtime
+	findProxy goja.Callable
+}
+
+// parsePACScript runs the PAC script with the helper functions defined, the script must define FindProxyForURL.
+func parsePACScript(source string) (*pacScript, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(pacMaxCallDepth)
+	for name, helper := range pacHelpers {
+		if err := vm.Set(name, helper); err != nil {
+			return nil, err
+		}
+	}
+	script := &pacScript{vm: vm}
+	if _, err := script.run(func() (goja.Value, error) { return vm.RunString(source) }); err != nil {
+		return nil, fmt.Errorf("invalid PAC script: %w", err)
+	}
+	findProxy, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("PAC script does not define function FindProxyForURL")
+	}
+	script.findProxy = findProxy
+	return script, nil
+}
+
+// FindProxyForURL calls the function of the same name, returns e.g. "PROXY proxy:8080; DIRECT".
+func (s *pacScript) FindProxyForURL(rawURL, host string) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	result, err := s.run(func() (goja.Value, error) {
+		return s.findProxy(goja.Undefined(), s.vm.ToValue(rawURL), s.vm.ToValue(host))
+	})
+	if err != nil || goja.IsUndefined(result) || goja.IsNull(result) {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// run interrupts the script running longer than pacScriptTimeout.
+func (s *pacScript) run(fn func() (goja.Value, error)) (goja.Value, error) {
+	timer := time.AfterFunc(pacScriptTimeout, func() {
+		s.vm.Interrupt(fmt.Errorf("PAC script is running longer than %v", pacScriptTimeout))
+	})
+	defer s.vm.ClearInterrupt()
+	defer timer.Stop()
+	return fn()
+}
+
+var (
+	pacWeekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+	pacMonths   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+)
+
+// pacHelpers are the functions which PAC scripts can call, goja converts their arguments and results.
+var pacHelpers = map[string]interface{}{
+	"isPlainHostName": func(host string) bool {
+		return !strings.Contains(host, ".")
+	},
+	"dnsDomainIs": func(host, domain string) bool {
+		return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+	},
+	"localHostOrDomainIs": func(host, hostdom string) bool {
+		host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+		return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")
+	},
+	"dnsDomainLevels": func(host string) int {
+		return strings.Count(host, ".")
+	},
+	"shExpMatch": func(str, shexp string) bool {
+		pattern := regexp.QuoteMeta(shexp)
+		pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+		return regexp.MustCompile("^" + pattern + "$").MatchString(str)
+	},
+	"isResolvable": func(host string) bool {
+		return pacResolve(host) != ""
+	},
+	"dnsResolve": func(host string) interface{} {
+		if ip := pacResolve(host); ip != "" {
+			return ip
+		}
+		return nil
+	},
+	"isInNet": func(host, pattern, mask string) bool {
+		ip := net.ParseIP(pacResolve(host))
+		patternIP, maskIP := net.ParseIP(pattern), net.ParseIP(mask)
+		if ip == nil || patternIP == nil || maskIP == nil {
+			return false
+		}
+		ipMask := net.IPMask(maskIP.To4())
+		return ip.To4() != nil && patternIP.To4() != nil && ip.Mask(ipMask).Equal(patternIP.Mask(ipMask))
+	},
+	"myIpAddress": func() string {
+		conn, err := net.Dial("udp", "192.0.2.1:80") // no packet is sent, only the outgoing interface is chosen
+		if err != nil {
+			return "127.0.0.1"
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).IP.String()
+	},
+	"weekdayRange": pacWeekdayRange,
+	"dateRange":    pacDateRange,
+	"timeRange":    pacTimeRange,
+	"alert": func(message string) {
+		BKLog.Printf("%s PAC alert: %s", EmoInfo, message)
+	},
+}
+
+// pacResolve returns the first IPv4 address of the host, or the host itself if it is an IP address.
+func pacResolve(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	addrs, err := pacLookupHost(host)
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr
+		}
+	}
+	return ""
+}
+
+// pacWeekdayRange is weekdayRange(wd1 [, wd2] [, "GMT"]), e.g. weekdayRange("MON", "FRI").
+func pacWeekdayRange(args ...string) bool {
+	args, now := pacTimeArgs(args)
+	if len(args) < 1 || len(args) > 2 {
+		return false
+	}
+	start := slices.Index(pacWeekdays, strings.ToUpper(args[0]))
+	end := slices.Index(pacWeekdays, strings.ToUpper(args[len(args)-1]))
+	return start >= 0 && end >= 0 && pacInRange(int(now.Weekday()), start, end)
+}
+
+// pacDateRange is dateRange with a day, month or year, or a range of them with up to all three on each side,
+// e.g. dateRange(1, 15), dateRange("JUN", "AUG") or dateRange(1, "JUN", 1995, 15, "AUG", 1995, "GMT").
+func pacDateRange(args ...string) bool {
+	args, now := pacTimeArgs(args)
+	if len(args) != 1 && (len(args)%2 != 0 || len(args) > 6) {
+		return false
+	}
+	start, startFields, ok := pacDateKey(args[:(len(args)+1)/2])
+	if !ok {
+		return false
+	}
+	end, endFields, ok := pacDateKey(args[len(args)/2:])
+	if !ok || startFields != endFields {
+		return false
+	}
+	return pacInRange(pacNowKey(now, startFields), start, end)
+}
+
+// Fields of the date given to dateRange.
+const (
+	pacDay = 1 << iota
+	pacMonth
+	pacYear
+)
+
+// pacDateKey returns the date of the day, month and year values as number comparable with pacNowKey of the same fields.
+func pacDateKey(values []string) (key, fields int, ok bool) {
+	year, month, day := 0, 0, 0
+	for _, value := range values {
+		n, err := strconv.Atoi(value)
+		m := slices.Index(pacMonths, strings.ToUpper(value))
+		switch {
+		case m >= 0 && fields&pacMonth == 0:
+			month, fields = m+1, fields|pacMonth
+		case err == nil && n >= 1 && n <= 31 && fields&pacDay == 0:
+			day, fields = n, fields|pacDay
+		case err == nil && n > 31 && fields&pacYear == 0:
+			year, fields = n, fields|pacYear
+		default:
+			return 0, 0, false
+		}
+	}
+	return (year*100+month)*100 + day, fields, true
+}
+
+func pacNowKey(now time.Time, fields int) int {
+	key := 0
+	if fields&pacYear != 0 {
+		key += now.Year() * 10000
+	}
+	if fields&pacMonth != 0 {
+		key += int(now.Month()) * 100
+	}
+	if fields&pacDay != 0 {
+		key += now.Day()
+	}
+	return key
+}
+
+// pacTimeRange is timeRange with an hour, or a range of hours, hours and minutes, or hours, minutes and seconds,
+// e.g. timeRange(12), timeRange(8, 17) or timeRange(8, 30, 17, 0, "GMT"). The end is inclusive like the hour 17 of timeRange(8, 17).
+func pacTimeRange(args ...string) bool {
+	args, now := pacTimeArgs(args)
+	values := make([]int, len(args))
+	for i, arg := range args {
+		value, err := strconv.Atoi(arg)
+		if err != nil {
+			return false
+		}
+		values[i] = value
+	}
+	switch len(values) {
+	case 1:
+		return now.Hour() == values[0]
+	case 2:
+		return pacInRange(now.Hour(), values[0], values[1])
+	case 4:
+		return pacInRange(now.Hour()*60+now.Minute(), values[0]*60+values[1], values[2]*60+values[3])
+	case 6:
+		seconds := (now.Hour()*60+now.Minute())*60 + now.Second()
+		return pacInRange(seconds, (values[0]*60+values[1])*60+values[2], (values[3]*60+values[4])*60+values[5])
+	}
+	return false
+}
+
+// pacTimeArgs returns the arguments without the optional last "GMT" and the current time, in UTC if "GMT" was given.
+func pacTimeArgs(args []string) ([]string, time.Time) {
+	now := pacNow()
+	if len(args) > 0 && strings.ToUpper(args[len(args)-1]) == "GMT" {
+		return args[:len(args)-1], now.UTC()
+	}
+	return args, now.Local()
+}
+
+// pacInRange reports whether the value is in the inclusive range, which wraps around if the start is after the end,
+// e.g. weekdayRange("FRI", "MON") or timeRange(22, 6).
+func pacInRange(value, start, end int) bool {
+	if start <= end {
+		return start <= value && value <= end
+	}
+	return value >= start || value <= end
+}
@@ -0,0 +1,283 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPACScript = `
+// Typical corporate PAC
+var corpProxy = "PROXY proxy.corp:3128";
+
+function isInternal(host) {
+	return isPlainHostName(host) || dnsDomainIs(host, ".corp.example") || isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0");
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (isInternal(host)) {
+		return "DIRECT";
+	} else if (shExpMatch(url, "http://*") && host.indexOf("blenderkit") >= 0) {
+		return "PROXY http-proxy.corp:8080";
+	}
+	/* everything else */
+	if (shExpMatch(host, "*.blenderkit.com")) return corpProxy + "; DIRECT";
+	return dnsDomainLevels(host) > 1 ? "SOCKS socks.corp:1080" : null;
+}
+`
+
+func TestPACScript(t *testing.T) {
+	defer func(lookup func(string) ([]string, error)) { pacLookupHost = lookup }(pacLookupHost)
+	pacLookupHost = func(host string) ([]string, error) {
+		if host == "build.lan.example" {
+			return []string{"::1", "10.1.2.3"}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	script, err := parsePACScript(testPACScript)
+	if err != nil {
+		t.Fatalf("parsePACScript() failed: %v", err)
+	}
+	tests := []struct {
+		url      string
+		host     string
+		expected string
+	}{
+		{"https://intranet/", "intranet", "DIRECT"},
+		{"https://wiki.corp.example/", "WIKI.corp.example", "DIRECT"},
+		{"https://build.lan.example/", "build.lan.example", "DIRECT"},
+		{"http://www.blenderkit.com/api/v1/search/", "www.blenderkit.com", "PROXY http-proxy.corp:8080"},
+		{"https://www.blenderkit.com/", "www.blenderkit.com", "PROXY proxy.corp:3128; DIRECT"},
+		{"https://a.b.example/", "a.b.example", "SOCKS socks.corp:1080"},
+		{"https://example.org/", "example.org", ""},
+	}
+	for _, test := range tests {
+		result, err := script.FindProxyForURL(test.url, test.host)
+		if err != nil || result != test.expected {
+			t.Errorf("FindProxyForURL(%q, %q) = %q, %v; want %q", test.url, test.host, result, err, test.expected)
+		}
+	}
+}
+
+func TestPACScriptErrors(t *testing.T) {
+	defer func(timeout time.Duration) { pacScriptTimeout = timeout }(pacScriptTimeout)
+	pacScriptTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		source string
+		parse  bool // error is expected already from parsing
+	}{
+		{"no FindProxyForURL", `function other() { return "DIRECT"; }`, true},
+		{"unterminated string", `function FindProxyForURL(url, host) { return "DIRECT; }`, true},
+		{"failing top-level code", `var proxy = undefinedFunction(); function FindProxyForURL(url, host) { return proxy; }`, true},
+		{"undefined variable", `function FindProxyForURL(url, host) { return proxy; }`, false},
+		{"endless recursion", `function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }`, false},
+		{"endless loop", `function FindProxyForURL(url, host) { while (true) {} }`, false},
+	}
+	for _, test := range tests {
+		script, err := parsePACScript(test.source)
+		if test.parse {
+			if err == nil {
+				t.Errorf("%s: parsePACScript() succeeded; want error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parsePACScript() failed: %v", test.name, err)
+			continue
+		}
+		if result, err := script.FindProxyForURL("https://www.blenderkit.com/", "www.blenderkit.com"); err == nil {
+			t.Errorf("%s: FindProxyForURL() = %q; want error", test.name, result)
+		}
+	}
+}
+
+func TestPACTimeHelpers(t *testing.T) {
+	defer func(now func() time.Time) { pacNow = now }(pacNow)
+	pacNow = func() time.Time { return time.Date(2024, time.June, 14, 12, 30, 15, 0, time.UTC) } // Friday
+
+	tests := []struct {
+		call     string
+		expected bool
+	}{
+		{`weekdayRange("FRI", "GMT")`, true},
+		{`weekdayRange("MON", "THU", "GMT")`, false},
+		{`weekdayRange("THU", "MON", "GMT")`, true},
+		{`weekdayRange("SAT", "TUE", "GMT")`, false},
+		{`weekdayRange("FRIDAY", "GMT")`, false},
+		{`dateRange(14, "GMT")`, true},
+		{`dateRange("JUN", "GMT")`, true},
+		{`dateRange(2023, "GMT")`, false},
+		{`dateRange(1, 15, "GMT")`, true},
+		{`dateRange(20, 10, "GMT")`, false},
+		{`dateRange("NOV", "FEB", "GMT")`, false},
+		{`dateRange("NOV", "JUL", "GMT")`, true},
+		{`dateRange(15, "JUN", 15, "JUL", "GMT")`, false},
+		{`dateRange(1, "JUN", 2024, 14, "JUN", 2024, "GMT")`, true},
+		{`dateRange("JUN", 15, "GMT")`, false},
+		{`timeRange(12, "GMT")`, true},
+		{`timeRange(8, 11, "GMT")`, false},
+		{`timeRange(22, 12, "GMT")`, true},
+		{`timeRange(12, 30, 12, 30, "GMT")`, true},
+		{`timeRange(12, 30, 16, 12, 30, 59, "GMT")`, false},
+		{`timeRange(12, 30, 0, 12, 30, 15, "GMT")`, true},
+		{`timeRange(1, 2, 3)`, false},
+	}
+	for _, test := range tests {
+		script, err := parsePACScript(`function FindProxyForURL(url, host) { return ` + test.call + ` ? "DIRECT" : "PROXY proxy.corp:3128"; }`)
+		if err != nil {
+			t.Fatalf("parsePACScript() failed: %v", err)
+		}
+		result, err := script.FindProxyForURL("https://www.blenderkit.com/", "www.blenderkit.com")
+		if err != nil || (result == "DIRECT") != test.expected {
+			t.Errorf("%s = %q, %v; want %v", test.call, result, err, test.expected)
+		}
+	}
+}
+
+func TestParsePACResult(t *testing.T) {
+	tests := []struct {
+		result   string
+		expected string // empty for direct connection
+		err      bool
+	}{
+		{"DIRECT", "", false},
+		{"", "", false},
+		{"PROXY proxy.corp:3128; DIRECT", "http://proxy.corp:3128", false},
+		{"  HTTPS secure.corp:443 ", "https://secure.corp:443", false},
+		{"SOCKS5 socks.corp:1080", "socks5://socks.corp:1080", false},
+		{"PROXY", "", true},
+		{"QUIC proxy.corp:443", "", true},
+	}
+	for _, test := range tests {
+		u, err := parsePACResult(test.result)
+		if (err != nil) != test.err {
+			t.Errorf("parsePACResult(%q) error = %v; want error %v", test.result, err, test.err)
+			continue
+		}
+		if actual := fmt.Sprint(u); (u == nil && test.expected != "") || (u != nil && actual != test.expected) {
+			t.Errorf("parsePACResult(%q) = %v; want %q", test.result, u, test.expected)
+		}
+	}
+}
+
+func TestPACProxy(t *testing.T) {
+	var fetches atomic.Int32
+	script := `function FindProxyForURL(url, host) { return dnsDomainIs(host, "blenderkit.com") ? "PROXY proxy.corp:3128" : "DIRECT"; }`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path == "/broken.pac" {
+			w.Write([]byte("function FindProxyForURL(url, host) { return ; ; ) }"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Write([]byte(script))
+	}))
+	defer server.Close()
+
+	p := newPACProxy(server.URL + "/proxy.pac")
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.blenderkit.com/api/v1/search/", "http://proxy.corp:3128"},
+		{"https://www.blenderkit.com/api/v1/categories/", "http://proxy.corp:3128"},
+		{"https://d1.example.net/thumb.png", "<nil>"},
+	}
+	for _, test := range tests {
+		u, err := p.Proxy(httptest.NewRequest("GET", test.url, nil))
+		if err != nil || fmt.Sprint(u) != test.expected {
+			t.Errorf("Proxy(%s) = %v, %v; want %s", test.url, u, err, test.expected)
+		}
+	}
+	if count := fetches.Load(); count != 1 {
+		t.Errorf("PAC script fetched %d times; want once", count)
+	}
+
+	broken := newPACProxy(server.URL + "/broken.pac")
+	u, err := broken.Proxy(httptest.NewRequest("GET", "https://www.blenderkit.com/", nil))
+	if err != nil || u != nil {
+		t.Errorf("Proxy() with broken PAC = %v, %v; want direct connection", u, err)
+	}
+	target, _ := url.Parse("https://www.blenderkit.com/")
+	if _, err := broken.FindProxy(target); err == nil {
+		t.Error("FindProxy() with broken PAC succeeded; want error")
+	}
+}
+
+// Refresh of the PAC script must not block the requests, they use the previous script until it is downloaded.
+func TestPACProxyRefreshOutsideLock(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write([]byte(`function FindProxyForURL(url, host) { return "PROXY proxy.corp:3128"; }`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := newPACProxy(server.URL + "/proxy.pac")
+	target, _ := url.Parse("https://www.blenderkit.com/")
+	if _, err := p.FindProxy(target); err != nil {
+		t.Fatal(err)
+	}
+	p.mux.Lock()
+	p.fetched = time.Now().Add(-2 * PACCacheTTL)
+	p.mux.Unlock()
+
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		p.FindProxy(target)
+	}()
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	for range 3 {
+		done := make(chan string, 1)
+		go func() {
+			result, _ := p.FindProxy(target)
+			done <- result
+		}()
+		select {
+		case result := <-done:
+			if result != "PROXY proxy.corp:3128" {
+				t.Errorf("FindProxy() during refresh = %q; want the previous script used", result)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("FindProxy() waits for the refresh of the PAC script")
+		}
+	}
+	if count := fetches.Load(); count != 2 {
+		t.Errorf("PAC script fetched %d times; want one refresh", count)
+	}
+	release <- struct{}{}
+	<-refreshed
+}
@@ -0,0 +1,121 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packReportFilename is written by upload_bg.py next to data.json in the export TempDir.
+const packReportFilename = "pack_report.json"
+
+// PackIssue is a warning or error found by upload_bg.py while packing, e.g. missing texture.
+type PackIssue struct {
+	Kind    string `json:"kind"`           // e.g. missing_texture, non_power_of_two, oversized
+	File    string `json:"file,omitempty"` // file of the issue, e.g. path of the texture
+	Message string `json:"message"`
+}
+
+func (i PackIssue) String() string {
+	if i.File == "" {
+		return fmt.Sprintf("%s: %s", i.Kind, i.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", i.Kind, i.Message, i.File)
+}
+
+// PackReport is the pack_report.json of upload_bg.py. Errors fail the upload, warnings are reported with its result.
+type PackReport struct {
+	Warnings []PackIssue `json:"warnings"`
+	Errors   []PackIssue `json:"errors"`
+}
+
+// PackReportError is returned by PackBlendFile when the pack report contains errors, even if Blender exited with 0.
+type PackReportError struct {
+	Report *PackReport
+}
+
+func (e *PackReportError) Error() string {
+	if len(e.Report.Errors) == 1 {
+		return fmt.Sprintf("packing failed: %s", e.Report.Errors[0].Message)
+	}
+	return fmt.Sprintf("packing failed with %d errors, first: %s", len(e.Report.Errors), e.Report.Errors[0].Message)
+}
+
+// packReportPath is the path of pack_report.json for the export TempDir.
+func packReportPath(tempDir string) string {
+	return filepath.Join(tempDir, packReportFilename)
+}
+
+// readPackReport reads pack_report.json of the export TempDir. Missing report is no error,
+// upload_bg.py of older add-ons does not write it, in that case nil report is returned.
+func readPackReport(tempDir string) (*PackReport, error) {
+	content, err := os.ReadFile(packReportPath(tempDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report PackReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", packReportFilename, err)
+	}
+	return &report, nil
+}
+
+// packIssuesDetail lists the issues one per line for MessageDetailed.
+func packIssuesDetail(title string, issues []PackIssue) string {
+	if len(issues) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(issues)+1)
+	lines = append(lines, title)
+	for _, issue := range issues {
+		lines = append(lines, "- "+issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// packWarnings returns the warnings of the report, nil report has none.
+func (r *PackReport) packWarnings() []PackIssue {
+	if r == nil {
+		return nil
+	}
+	return r.Warnings
+}
+
+// packTaskError is subprocessTaskError which also lists the errors of the pack report.
+func packTaskError(appID int, taskID string, err error) *TaskError {
+	var reportErr *PackReportError
+	if errors.As(err, &reportErr) {
+		return &TaskError{
+			AppID:           appID,
+			TaskID:          taskID,
+			Error:           err,
+			MessageDetailed: packIssuesDetail("Packing errors:", reportErr.Report.Errors),
+			Result:          reportErr.Report,
+		}
+	}
+	return subprocessTaskError(appID, taskID, err)
+}
@@ -0,0 +1,116 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadPackReport(t *testing.T) {
+	dir := t.TempDir()
+	if report, err := readPackReport(dir); report != nil || err != nil {
+		t.Errorf("missing report = %+v, %v; want nil, nil", report, err)
+	}
+
+	os.WriteFile(packReportPath(dir), []byte(`{"warnings": [{"kind": "non_power_of_two", "file": "//wood.png", "message": "image is 1000x1000"}], "errors": []}`), 0644)
+	report, err := readPackReport(dir)
+	if err != nil || len(report.Warnings) != 1 || report.Warnings[0].File != "//wood.png" || len(report.Errors) != 0 {
+		t.Errorf("report = %+v, %v", report, err)
+	}
+
+	os.WriteFile(packReportPath(dir), []byte(`{"warnings": [`), 0644)
+	if _, err := readPackReport(dir); err == nil {
+		t.Error("truncated report parsed without error")
+	}
+}
+
+func TestPackTaskError(t *testing.T) {
+	report := &PackReport{Errors: []PackIssue{
+		{Kind: "missing_texture", File: "//textures/wood.png", Message: "texture file not found"},
+		{Kind: "oversized", Message: "packed file has 3.2GB"},
+	}}
+	taskErr := packTaskError(677, "task", &PackReportError{Report: report})
+	if taskErr.Error.Error() != "packing failed with 2 errors, first: texture file not found" {
+		t.Errorf("error = %q", taskErr.Error)
+	}
+	want := "Packing errors:\n- missing_texture: texture file not found (//textures/wood.png)\n- oversized: packed file has 3.2GB"
+	if taskErr.MessageDetailed != want || taskErr.Result != report {
+		t.Errorf("detail = %q, result %+v", taskErr.MessageDetailed, taskErr.Result)
+	}
+
+	subErr := &SubprocessError{Script: "upload_bg.py", Output: "Traceback", ExitCode: 1}
+	if taskErr := packTaskError(677, "task", subErr); taskErr.MessageDetailed != "Traceback" {
+		t.Errorf("subprocess error detail = %q", taskErr.MessageDetailed)
+	}
+}
+
+func TestPackBlendFileReport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	dir := t.TempDir()
+	// fake Blender copies the report of the test next to data.json, which is the last argument, and writes the packed file
+	report := filepath.Join(dir, "report.json")
+	blender := filepath.Join(dir, "blender")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = --version ]; then echo 'Blender 4.2.0'; exit 0; fi\n" +
+		"for arg; do data=$arg; done\n" +
+		"out=$(dirname \"$data\")\n" +
+		"[ -f '" + report + "' ] && cp '" + report + "' \"$out/pack_report.json\"\n" +
+		"touch \"$out/base-id.blend\"\n"
+	if err := os.WriteFile(blender, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tempDir := t.TempDir()
+	data := AssetUploadRequestData{
+		UploadSet:  []string{"MAINFILE"},
+		ExportData: AssetUploadExportData{TempDir: tempDir, BinaryPath: blender, AssetBaseID: "base-id", ID: "id"},
+	}
+	metadata := AssetsCreateResponse{AssetType: "model"}
+
+	// older add-on writes no report
+	files, packReport, err := PackBlendFile(data, metadata, true)
+	if err != nil || packReport != nil || len(files) != 1 {
+		t.Fatalf("packing without report = %v, %+v, %v", files, packReport, err)
+	}
+
+	os.WriteFile(report, []byte(`{"warnings": [{"kind": "non_power_of_two", "message": "image is 1000x1000"}]}`), 0644)
+	files, packReport, err = PackBlendFile(data, metadata, true)
+	if err != nil || len(files) != 1 || len(packReport.packWarnings()) != 1 {
+		t.Errorf("packing with warnings = %v, %+v, %v", files, packReport, err)
+	}
+
+	os.WriteFile(report, []byte(`{"errors": [{"kind": "missing_texture", "file": "//wood.png", "message": "texture file not found"}]}`), 0644)
+	_, _, err = PackBlendFile(data, metadata, true)
+	var reportErr *PackReportError
+	if !errors.As(err, &reportErr) || !strings.Contains(err.Error(), "texture file not found") {
+		t.Errorf("packing with errors and exit code 0 = %v; want PackReportError", err)
+	}
+
+	// report of the previous packing is not reused
+	os.Remove(report)
+	if _, packReport, err := PackBlendFile(data, metadata, true); err != nil || packReport != nil {
+		t.Errorf("packing after failed one = %+v, %v; want no report", packReport, err)
+	}
+}
@@ -0,0 +1,285 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// PrefetchBookmarksData is expected from the add-on on /downloads/prefetch_bookmarks.
+type PrefetchBookmarksData struct {
+	AppID           int      `json:"app_id"`
+	APIKey          string   `json:"api_key"`
+	AddonVersion    string   `json:"addon_version"`
+	PlatformVersion string   `json:"platform_version"`
+	RequestID       string   `json:"request_id"`
+	SceneID         string   `json:"scene_id"`      // sent with the download URL requests like in normal downloads
+	DownloadDirs    []string `json:"download_dirs"` // usually just the global_dir
+	AssetTypes      []string `json:"asset_types"`   // e.g. ["model", "material"], empty for all types
+	Resolution      string   `json:"resolution"`    // "ORIGINAL" (default), "resolution_1K", "resolution_2K"...
+	DryRun          bool     `json:"dry_run"`       // only list the assets and their size, download nothing
+}
+
+// PrefetchedAsset is one bookmarked asset in the result of the prefetch_bookmarks task.
+type PrefetchedAsset struct {
+	AssetBaseID string `json:"asset_base_id"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AssetType   string `json:"asset_type"`
+	Resolution  string `json:"resolution"`
+	OnDisk      bool   `json:"on_disk"`
+	FileSize    int64  `json:"file_size"` // from the search results, 0 if on disk or unknown, only in dry run
+	Error       string `json:"error,omitempty"`
+}
+
+// PrefetchBookmarksResult is the result of the prefetch_bookmarks task.
+type PrefetchBookmarksResult struct {
+	Assets    []PrefetchedAsset `json:"assets"`
+	TotalSize int64             `json:"total_size"` // bytes to download, only in dry run
+	Cached    int               `json:"cached"`     // assets on disk, after the prefetch or already before the dry run
+	Failed    int               `json:"failed"`
+}
+
+// PrefetchBookmarksHandler downloads the bookmarked assets, so they are available offline.
+// Responds with task_id of the parent task which reports the aggregate progress.
+func PrefetchBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	var data PrefetchBookmarksData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if data.Resolution == "" {
+		data.Resolution = "ORIGINAL"
+	}
+	if len(data.DownloadDirs) == 0 {
+		writeValidationError(w, &FieldError{Field: "download_dirs", Message: "must not be empty"})
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("scene_id", data.SceneID)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	taskID := uuid.New().String()
+	go runTask(data.AppID, taskID, func() { PrefetchBookmarks(data, taskID) })
+	writeJSON(w, map[string]string{"task_id": taskID})
+}
+
+// PrefetchBookmarks fetches all bookmarks of the user and downloads those of the chosen types which are not on disk
+// yet as background priority asset_prefetch tasks. Dry run looks for the files on disk and sums the sizes
+// from the search results, it asks the server for no download URL.
+func PrefetchBookmarks(data PrefetchBookmarksData, taskID string) {
+	if data.RequestID == "" {
+		data.RequestID = taskID
+	}
+	task := NewTask(data, data.AppID, taskID, "downloads/prefetch_bookmarks").WithRequestID(data.RequestID)
+	task.Message = "Getting bookmarks"
+	TasksMux.Lock()
+	Tasks[task.AppID][taskID] = task
+	TasksMux.Unlock()
+
+	bookmarks, err := fetchBookmarks(data, task)
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+		return
+	}
+	var result PrefetchBookmarksResult
+	var downloads []DownloadData
+	var files []AssetFile
+	for _, asset := range bookmarks {
+		if !prefetchAssetType(asset.AssetType, data.AssetTypes) {
+			continue
+		}
+		file, resolution := GetResolutionFile(asset.Files, data.Resolution)
+		if file.DownloadURL == "" {
+			continue
+		}
+		result.Assets = append(result.Assets, PrefetchedAsset{
+			AssetBaseID: asset.AssetBaseID,
+			ID:          asset.ID,
+			Name:        asset.Name,
+			AssetType:   asset.AssetType,
+			Resolution:  resolution,
+		})
+		downloads = append(downloads, prefetchDownloadData(data, asset))
+		files = append(files, file)
+	}
+
+	if data.DryRun {
+		for i := range result.Assets {
+			if task.Ctx.Err() != nil {
+				return
+			}
+			estimatePrefetch(&result.Assets[i], downloads[i], files[i])
+			result.TotalSize += result.Assets[i].FileSize
+			if result.Assets[i].OnDisk {
+				result.Cached++
+			}
+			if result.Assets[i].Error != "" {
+				result.Failed++
+			}
+		}
+		message := fmt.Sprintf("%d/%d assets cached, %.1fMB to download", result.Cached, len(result.Assets), megabytes(result.TotalSize))
+		sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: message, Result: result})
+		return
+	}
+
+	sendTaskProgress(&TaskProgressUpdate{AppID: data.AppID, TaskID: taskID, Message: fmt.Sprintf("0/%d assets cached", len(downloads))})
+	done := make(chan int, len(downloads))
+	children := make([]*Task, len(downloads))
+	for i, download := range downloads {
+		children[i] = NewTask(download, data.AppID, uuid.New().String(), "asset_prefetch").WithRequestID(data.RequestID)
+		TasksMux.Lock()
+		Tasks[data.AppID][children[i].TaskID] = children[i]
+		TasksMux.Unlock()
+		go func(i int) {
+			result.Assets[i].Error = prefetchAsset(children[i], downloads[i])
+			done <- i
+		}(i)
+	}
+	go func() { // cancelling the prefetch cancels all its downloads
+		<-task.Ctx.Done()
+		for _, child := range children {
+			child.Cancel()
+		}
+	}()
+
+	for finished := 1; finished <= len(downloads); finished++ {
+		i := <-done
+		if result.Assets[i].Error != "" {
+			result.Failed++
+		} else {
+			result.Assets[i].OnDisk = true
+			result.Cached++
+		}
+		progress := finished * 100 / len(downloads)
+		message := fmt.Sprintf("%d/%d assets cached", result.Cached, len(downloads))
+		sendTaskProgress(&TaskProgressUpdate{AppID: data.AppID, TaskID: taskID, Progress: progress, Message: message})
+	}
+	if task.Ctx.Err() != nil {
+		return
+	}
+	task.Cancel() // releases the goroutine waiting for cancellation
+	message := fmt.Sprintf("%d/%d assets cached", result.Cached, len(downloads))
+	if result.Failed > 0 {
+		message += fmt.Sprintf(", %d failed", result.Failed)
+	}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: message, Result: result})
+}
+
+// fetchBookmarks returns all bookmarked assets, following the pages of the search API like GetBookmarks.
+// Next pages are requested from the server of the Client, only the path and query of the next URL are used.
+func fetchBookmarks(data PrefetchBookmarksData, task *Task) ([]Asset, error) {
+	client := serverAPI(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}, task)
+	path := "/api/v1/search/?query=bookmarks_rating:1"
+	var assets []Asset
+	for path != "" {
+		var page SearchResults
+		if err := client.GetJSON(task.Ctx, path, &page); err != nil {
+			return nil, fmt.Errorf("get bookmarks: %w", err)
+		}
+		assets = append(assets, page.Results...)
+		path = ""
+		if page.NextURL != "" {
+			next, err := url.Parse(page.NextURL)
+			if err != nil {
+				return nil, fmt.Errorf("get bookmarks - next page: %w", err)
+			}
+			path = next.RequestURI()
+		}
+	}
+	return assets, nil
+}
+
+func prefetchAssetType(assetType string, assetTypes []string) bool {
+	if len(assetTypes) == 0 {
+		return true
+	}
+	for _, t := range assetTypes {
+		if t == assetType {
+			return true
+		}
+	}
+	return false
+}
+
+func prefetchDownloadData(data PrefetchBookmarksData, asset Asset) DownloadData {
+	return DownloadData{
+		AddonVersion:    data.AddonVersion,
+		PlatformVersion: data.PlatformVersion,
+		RequestID:       data.RequestID,
+		AppID:           data.AppID,
+		DownloadDirs:    data.DownloadDirs,
+		Priority:        DownloadPriorityBackground,
+		DownloadAssetData: DownloadAssetData{
+			Name:       asset.Name,
+			ID:         asset.ID,
+			Files:      asset.Files,
+			AssetType:  asset.AssetType,
+			Resolution: data.Resolution,
+		},
+		PREFS: PREFS{
+			APIKey:     data.APIKey,
+			SceneID:    data.SceneID,
+			AppID:      data.AppID,
+			Resolution: data.Resolution,
+		},
+	}
+}
+
+// prefetchAsset downloads the asset in the download queue, returns the error message if it failed.
+func prefetchAsset(task *Task, data DownloadData) string {
+	if !acquireDownloadSlot(task, false) {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: task.TaskID, Error: task.Ctx.Err()})
+		return "cancelled"
+	}
+	defer releaseDownloadSlot(task)
+
+	filePaths, note, taskErr := downloadAssetFiles(task, data)
+	if taskErr != nil {
+		sendTask(TaskErrorCh, taskErr)
+		return fmt.Sprint(taskErr.Error)
+	}
+	message := "Asset cached"
+	if note != "" {
+		message = fmt.Sprintf("Asset cached, %s", note)
+	}
+	sendTask(TaskFinishCh, &TaskFinish{
+		AppID:   data.AppID,
+		TaskID:  task.TaskID,
+		Message: message,
+		Result:  map[string]interface{}{"file_paths": filePaths},
+	})
+	return ""
+}
+
+// estimatePrefetch finds out whether the file is on disk, the same way as /asset/resolutions does, and the size of the download
+// from the search results. Dry run does not ask the server for the download URLs.
+func estimatePrefetch(asset *PrefetchedAsset, data DownloadData, file AssetFile) {
+	for _, dir := range data.DownloadDirs {
+		if _, ok := findResolutionFile(dir, data.Name, data.ID, file.FileType); ok {
+			asset.OnDisk = true
+			return
+		}
+	}
+	asset.FileSize = file.FileSize.Int64()
+}
@@ -0,0 +1,131 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetchBookmarks(t *testing.T) {
+	const (
+		appID   = 609
+		sceneID = "0992088b-fb84-4c69-bb6e-426272970c8b"
+		content = "blend file content"
+	)
+	fileIDs := map[string]string{ // of the blend files, downloaded files are found by them
+		"chair": "d5368c9d-092e-4319-afe1-dd765de6da01",
+		"wood":  "3c1f6b2e-5a4d-4e8f-9b7a-2d6c8e0f1a3b",
+		"table": "7e9d2c4a-1b3f-4a5e-8c6d-0f2e4a6b8c1d",
+	}
+	var downloadURLs atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/search/":
+			if r.URL.Query().Get("page") == "" {
+				// next page on other host is requested from the server of the Client
+				fmt.Fprintf(w, `{"count": 3, "next": "https://elsewhere.example/api/v1/search/?query=bookmarks_rating:1&page=2", "results": [
+					{"id": "chair", "name": "Chair", "assetType": "model", "files": [{"fileType": "blend", "fileSize": 1000, "downloadUrl": "%[1]s/download/chair"}]},
+					{"id": "wood", "name": "Wood", "assetType": "material", "files": [{"fileType": "blend", "fileSize": 2000, "downloadUrl": "%[1]s/download/wood"}]}]}`, server.URL)
+				return
+			}
+			fmt.Fprintf(w, `{"count": 3, "results": [
+				{"id": "table", "name": "Table", "assetType": "model", "files": [{"fileType": "blend", "fileSize": %d, "downloadUrl": "%s/download/table"}]}]}`, len(content), server.URL)
+		case "/download/chair", "/download/wood", "/download/table":
+			downloadURLs.Add(1)
+			fmt.Fprintf(w, `{"filePath": "%s/files/blend_%s.blend"}`, server.URL, fileIDs[filepath.Base(r.URL.Path)])
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			if r.Method != http.MethodHead {
+				w.Write([]byte(content))
+			}
+		}
+	}))
+	defer server.Close()
+	defer func(api, downloads *http.Client) { ClientAPI, ClientDownloads = api, downloads }(ClientAPI, ClientDownloads)
+	ClientAPI, ClientDownloads = server.Client(), server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{}
+	TasksMux.Unlock()
+
+	dir := t.TempDir()
+	localPath := func(name, id string) string {
+		fileName, _ := ExtractFilenameFromURL(server.URL + "/files/blend_" + fileIDs[id] + ".blend")
+		path, _, _ := downloadFilepath(dir, name, id, fileName, 0)
+		return path
+	}
+	chairPath := localPath("Chair", "chair")
+	os.MkdirAll(filepath.Dir(chairPath), 0755)
+	if err := os.WriteFile(chairPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := PrefetchBookmarksData{AppID: appID, SceneID: sceneID, DownloadDirs: []string{dir}, AssetTypes: []string{"model"}}
+	wait := func(taskID string) PrefetchBookmarksResult {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case f := <-TaskFinishCh:
+				if f.TaskID == taskID {
+					return f.Result.(PrefetchBookmarksResult)
+				}
+			case e := <-TaskErrorCh:
+				if e.TaskID == taskID {
+					t.Fatalf("prefetch failed: %v", e.Error)
+				}
+			case <-timeout:
+				t.Fatal("prefetch not finished")
+			}
+		}
+	}
+
+	data.DryRun = true
+	go PrefetchBookmarks(data, "dry-run")
+	result := wait("dry-run")
+	if len(result.Assets) != 2 || result.Cached != 1 || result.TotalSize != int64(len(content)) {
+		t.Errorf("dry run = %+v; want 2 models, chair cached, table to download", result)
+	}
+	if n := downloadURLs.Load(); n != 0 {
+		t.Errorf("dry run requested %d download URLs; want none", n)
+	}
+	tablePath := localPath("Table", "table")
+	if _, err := os.Stat(tablePath); err == nil {
+		t.Error("dry run downloaded the table")
+	}
+
+	data.DryRun = false
+	go PrefetchBookmarks(data, "prefetch")
+	result = wait("prefetch")
+	if result.Cached != 2 || result.Failed != 0 {
+		t.Errorf("prefetch = %+v; want both models cached", result)
+	}
+	if downloaded, err := os.ReadFile(tablePath); err != nil || string(downloaded) != content {
+		t.Errorf("table file = %q, %v; want downloaded content", downloaded, err)
+	}
+}
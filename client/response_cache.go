@@ -0,0 +1,146 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const responseCacheDirname = "response_cache" // cached API responses in GetSafeTempPath()
+
+// CachedResponse is the body of a successful API response with its validators,
+// so the next request can be conditional and the server can respond with 304 Not Modified.
+type CachedResponse struct {
+	URL          string          `json:"url"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// ResponseCacheStore keeps cached responses of rarely changing endpoints like categories, one JSON file per endpoint.
+type ResponseCacheStore struct {
+	mux sync.Mutex
+	dir string
+}
+
+var ResponseCache = &ResponseCacheStore{}
+
+// LoadResponseCache prepares the response cache directory in the safe temp path.
+func LoadResponseCache() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(tempDir, responseCacheDirname)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ResponseCache.mux.Lock()
+	defer ResponseCache.mux.Unlock()
+	ResponseCache.dir = dir
+	return nil
+}
+
+func (c *ResponseCacheStore) path(name string) string {
+	return filepath.Join(c.dir, name+".json")
+}
+
+// Get returns the cached response of the endpoint, nil if there is none for the url.
+func (c *ResponseCacheStore) Get(name, url string) *CachedResponse {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path(name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			BKLog.Printf("%s Failed to read cached %s: %v", EmoWarning, name, err)
+		}
+		return nil
+	}
+	var cached CachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil || cached.URL != url || len(cached.Body) == 0 {
+		return nil // corrupted or from other server, fetched again in full
+	}
+	return &cached
+}
+
+// Put caches the body of successful response, if the server sent any validators for it.
+func (c *ResponseCacheStore) Put(name, url string, header http.Header, body []byte) {
+	cached := CachedResponse{URL: url, ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Body: body}
+	if cached.ETag == "" && cached.LastModified == "" {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(cached)
+	if err == nil {
+		tmpPath := c.path(name) + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0600); err == nil {
+			err = os.Rename(tmpPath, c.path(name))
+		}
+	}
+	if err != nil {
+		BKLog.Printf("%s Failed to cache %s: %v", EmoWarning, name, err)
+	}
+}
+
+// Clear drops all cached responses, used when the server changes.
+func (c *ResponseCacheStore) Clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir == "" {
+		return
+	}
+	paths, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// Remove drops the cached response, so the next request fetches it in full.
+func (c *ResponseCacheStore) Remove(name string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir != "" {
+		os.Remove(c.path(name))
+	}
+}
+
+// SetConditionalHeaders makes the request conditional on the cached response. Does nothing on nil.
+func (cached *CachedResponse) SetConditionalHeaders(req *http.Request) {
+	if cached == nil {
+		return
+	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+}
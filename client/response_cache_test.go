@@ -0,0 +1,113 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionalFetch(t *testing.T) {
+	const (
+		etag         = `"c4t3g0r135"`
+		lastModified = "Tue, 13 Oct 2026 08:00:00 GMT"
+	)
+	bodies := map[string]string{
+		"/api/v1/categories":         `{"count": 1, "results": [{"name": "Model", "slug": "model", "assetCount": 3, "children": []}]}`,
+		"/api/v1/disclaimer/active/": `{"count": 1, "results": [{"message": "Summer sale", "url": "https://www.blenderkit.com/plans/"}]}`,
+	}
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			conditional = append(conditional, r.URL.Path)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	defer func(c *http.Client, cache *ResponseCacheStore) {
+		ClientAPI, ResponseCache = c, cache
+	}(ClientAPI, ResponseCache)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+	ResponseCache = &ResponseCacheStore{dir: t.TempDir()}
+
+	wait := func() *TaskFinish {
+		for {
+			select {
+			case <-AddTaskCh:
+			case f := <-TaskFinishCh:
+				return f
+			case e := <-TaskErrorCh:
+				t.Fatalf("task failed: %v", e.Error)
+			case <-time.After(5 * time.Second):
+				t.Fatal("task did not finish")
+			}
+		}
+	}
+	tests := []struct {
+		name    string
+		fetch   func(MinimalTaskData)
+		fresh   string
+		current string
+	}{
+		{"categories", FetchCategories, "Categories updated", "Categories up to date"},
+		{"disclaimer", FetchDisclaimer, "Disclaimer fetched", "Disclaimer up to date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditional = nil
+			go tt.fetch(MinimalTaskData{AppID: 613})
+			first := wait()
+			go tt.fetch(MinimalTaskData{AppID: 613})
+			second := wait()
+			if first.Message != tt.fresh || second.Message != tt.current {
+				t.Errorf("messages = %q, %q; expected %q, %q", first.Message, second.Message, tt.fresh, tt.current)
+			}
+			if len(conditional) != 1 {
+				t.Errorf("server got %d conditional requests, expected 1", len(conditional))
+			}
+			if fmt.Sprint(first.Result) != fmt.Sprint(second.Result) {
+				t.Errorf("cached result %v differs from fresh %v", second.Result, first.Result)
+			}
+		})
+	}
+
+	ResponseCache.Put("categories", "https://other.server/api/v1/categories", http.Header{"Etag": {etag}}, []byte(`{}`))
+	if cached := ResponseCache.Get("categories", serverURL+"/api/v1/categories"); cached != nil {
+		t.Errorf("got cached response of other server: %+v", cached)
+	}
+	ResponseCache.Put("empty", serverURL, http.Header{}, []byte(`{}`))
+	if cached := ResponseCache.Get("empty", serverURL); cached != nil {
+		t.Errorf("response without validators was cached: %+v", cached)
+	}
+}
@@ -0,0 +1,173 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// route is an endpoint of the Client. Pattern ending with a slash serves the whole subtree, e.g. /thumbnails/<file>,
+// other patterns are served with and without the trailing slash.
+type route struct {
+	pattern string
+	methods []string // GET allows also HEAD, OPTIONS is answered by the router unless listed
+	handler http.HandlerFunc
+}
+
+var (
+	get  = []string{http.MethodGet}
+	post = []string{http.MethodPost}
+
+	// Cross-origin endpoints of the website answer the CORS preflight themselves.
+	getCORS  = []string{http.MethodGet, http.MethodOptions}
+	postCORS = []string{http.MethodPost, http.MethodOptions}
+)
+
+// clientRoutes are all endpoints of the Client. Handlers which read the JSON body or create tasks are POST,
+// status endpoints are GET, so a GET to a task endpoint does not start a task from an empty body.
+func clientRoutes() []route {
+	return []route{
+		{"/", get, indexHandler},
+		{"/report", get, reportHandler},
+		{"/report_usages", post, ReportUsagesHandler},
+		{"/healthz", get, HealthzHandler},
+
+		// CACHE
+		{"/cache/status", get, CacheStatusHandler},
+		{"/cache/thumbnails/status", get, ThumbnailCacheStatusHandler},
+		{"/cache/thumbnails/clear", post, ThumbnailCacheClearHandler},
+		{"/thumbnails/", get, ThumbnailHandler},
+		{"/shutdown", post, shutdownHandler},
+		{"/debug", get, DebugNetworkHandler},
+		{"/tasks", get, TasksHandler},
+		{"/metrics", get, MetricsHandler},
+		{"/settings/reload_network", post, ReloadNetworkHandler},
+		{"/settings/set_server", post, SetServerHandler},
+		{"/settings/update", post, SettingsUpdateHandler},
+
+		// LOGIN
+		{"/consumer/exchange", get, consumerExchangeHandler}, // redirect_uri of OAuth2, opened by the browser
+		{"/refresh_token", post, RefreshTokenHandler},
+		{"/token/manual_login", post, ManualLoginHandler},
+		{"/oauth2/verification_data", post, OAuth2VerificationDataHandler},
+		{"/oauth2/logout", post, OAuth2LogoutHandler},
+
+		// BLENDER SPECIFIC HANDLERS
+		{"/blender/unsubscribe_addon", post, blenderUnsubscribeAddonHandler},
+		{"/blender/cancel_download", post, CancelDownloadHandler},
+		{"/downloads/queue", get, DownloadQueueHandler},
+		{"/downloads/prefetch_bookmarks", post, PrefetchBookmarksHandler},
+		{"/downloads/verify", post, DownloadVerifyHandler},
+		{"/blender/asset_download", post, assetDownloadHandler},
+		{"/blender/asset_search", post, assetSearchHandler},
+		{"/search_similar", post, searchSimilarHandler},
+		{"/search/history", post, SearchHistory.Handler},
+		{"/search/history/clear", post, SearchHistory.ClearHandler},
+		{"/blender/asset_upload", post, assetUploadHandler},
+		{"/asset/upload/history", get, UploadHistoryHandler},
+		{"/asset/upload/recheck", post, UploadRecheckHandler},
+		{"/asset/resolutions", post, AssetResolutionsHandler},
+		{"/disclaimer/acknowledge", post, DisclaimerAcknowledgeHandler},
+
+		// WEBSITE (bkclientjs)
+		{"/bkclientjs/status", getCORS, BkclientjsStatusHandler},
+		{"/bkclientjs/get_asset", postCORS, BkclientjsGetAssetHandler},
+		{"/link/open_asset", get, LinkOpenAssetHandler},
+
+		// API HANDLERS
+		{"/profiles/download_gravatar_image", post, DownloadGravatarImageHandler},
+		{"/profiles/get_user_profile", post, GetUserProfileHandler},
+
+		{"/comments/get_comments", post, GetCommentsHandler},
+		{"/comments/create_comment", post, CreateCommentHandler},
+		{"/comments/feedback_comment", post, FeedbackCommentHandler},
+		{"/comments/mark_comment_private", post, MarkCommentPrivateHandler},
+
+		{"/notifications/mark_notification_read", post, MarkNotificationReadHandler},
+
+		{"/ratings/get_bookmarks", post, GetBookmarksHandler},
+		{"/ratings/get_rating", post, GetRatingHandler},
+		{"/ratings/send_rating", post, SendRatingHandler},
+
+		// WRAPPERS
+		{"/wrappers/get_download_url", post, GetDownloadURLWrapper},
+		{"/wrappers/complete_upload_file_blocking", post, CompleteUploadFileBlocking},
+		{"/wrappers/blocking_file_download", post, BlockingFileDownloadHandler},
+		{"/wrappers/blocking_file_upload", post, BlockingFileUploadHandler},
+		{"/wrappers/blocking_request", post, BlockingRequestHandler},
+		{"/wrappers/nonblocking_request", post, NonblockingRequestHandler},
+	}
+}
+
+// newRouter registers the routes, unknown paths get 404 and methods not allowed by the route 405 with Allow header.
+func newRouter(routes []route) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		handler := allowMethods(rt.methods, rt.handler)
+		switch {
+		case rt.pattern == "/":
+			mux.Handle("/{$}", handler)
+		case strings.HasSuffix(rt.pattern, "/"):
+			mux.Handle(rt.pattern, handler)
+		default:
+			mux.Handle(rt.pattern, handler)
+			mux.Handle(rt.pattern+"/{$}", handler)
+		}
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("no endpoint %s", r.URL.Path))
+	})
+	return mux
+}
+
+// allowMethods responds 405 with Allow header to requests with other methods than allowed.
+// OPTIONS gets 204 with the Allow header, routes listing OPTIONS answer it themselves (CORS preflight).
+func allowMethods(methods []string, handler http.HandlerFunc) http.HandlerFunc {
+	allowed := append([]string{}, methods...)
+	handlesOptions := false
+	for _, method := range methods {
+		switch method {
+		case http.MethodGet:
+			allowed = append(allowed, http.MethodHead)
+		case http.MethodOptions:
+			handlesOptions = true
+		}
+	}
+	if !handlesOptions {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	allow := strings.Join(allowed, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && !handlesOptions {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		for _, method := range allowed {
+			if r.Method == method {
+				handler(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allow)
+		writeJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Sprintf("%s is not allowed, use %s", r.Method, allow))
+	}
+}
@@ -0,0 +1,157 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterMethods(t *testing.T) {
+	var served string
+	routes := clientRoutes()
+	for i := range routes {
+		pattern := routes[i].pattern
+		routes[i].handler = func(w http.ResponseWriter, r *http.Request) { served = pattern } // real handlers would start tasks or exit
+	}
+	router := newRouter(routes)
+
+	for _, rt := range routes {
+		path := rt.pattern
+		if strings.HasSuffix(path, "/") && path != "/" {
+			path += "file.png"
+		}
+		paths := []string{path}
+		if !strings.HasSuffix(rt.pattern, "/") { // served also with the trailing slash
+			paths = append(paths, path+"/")
+		}
+		for _, p := range paths {
+			for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete} {
+				allowed := false
+				for _, m := range rt.methods {
+					allowed = allowed || m == method || (m == http.MethodGet && method == http.MethodHead)
+				}
+				served = ""
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(method, p, nil))
+				if allowed && served != rt.pattern {
+					t.Errorf("%s %s served by %q, expected %q (status %d)", method, p, served, rt.pattern, rec.Code)
+				}
+				if !allowed && (rec.Code != http.StatusMethodNotAllowed || served != "" || rec.Header().Get("Allow") == "") {
+					t.Errorf("%s %s got %d with Allow %q, expected 405", method, p, rec.Code, rec.Header().Get("Allow"))
+				}
+			}
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	router := newRouter(clientRoutes())
+	for _, path := range []string{"/asset/upload", "/blender", "/report/extra"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), ErrCodeNotFound) {
+			t.Errorf("POST %s got %d: %s, expected 404", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRouterAllowHeader(t *testing.T) {
+	router := newRouter(clientRoutes())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blender/asset_upload", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("GET /blender/asset_upload got %d with Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/report", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("POST /report got %d with Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRouterOptions(t *testing.T) {
+	router := newRouter(clientRoutes())
+	for path, allow := range map[string]string{"/": "GET, HEAD, OPTIONS", "/report": "GET, HEAD, OPTIONS", "/blender/asset_upload": "POST, OPTIONS"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, path, nil))
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != allow || rec.Body.Len() != 0 {
+			t.Errorf("OPTIONS %s got %d with Allow %q, expected 204 with %q", path, rec.Code, rec.Header().Get("Allow"), allow)
+		}
+	}
+}
+
+func TestStatusEndpointsHead(t *testing.T) {
+	lastReportAccessMux.Lock()
+	lastAccess := lastReportAccess
+	lastReportAccessMux.Unlock()
+
+	router := newRouter(clientRoutes())
+	for _, path := range []string{"/", "/healthz", "/report"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, path, strings.NewReader(`{"app_id": 6581, "addon_version": "3.12.0"}`)))
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") == "" {
+			t.Errorf("HEAD %s got %d with %d bytes and Content-Type %q", path, rec.Code, rec.Body.Len(), rec.Header().Get("Content-Type"))
+		}
+	}
+
+	TasksMux.Lock()
+	_, subscribed := Tasks[6581]
+	TasksMux.Unlock()
+	lastReportAccessMux.Lock()
+	accessed := lastReportAccess != lastAccess
+	lastReportAccessMux.Unlock()
+	if subscribed || accessed {
+		t.Errorf("HEAD /report subscribed the app (%v) or counted as report access (%v)", subscribed, accessed)
+	}
+}
+
+func TestIndexNegotiation(t *testing.T) {
+	AvailableSoftwaresMux.Lock()
+	AvailableSoftwares[6582] = &Software{AppID: 6582, LastReport: time.Now()}
+	AvailableSoftwaresMux.Unlock()
+	defer unregisterSoftware(6582)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	indexHandler(rec, req)
+	var status IndexStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("index JSON: %v, body %q", err, rec.Body.String())
+	}
+	if status.PID != os.Getpid() || status.Version != ClientVersion || status.Port != testPort || status.ConnectedSoftwares < 1 {
+		t.Errorf("index JSON got %+v", status)
+	}
+
+	for accept, contentType := range map[string]string{"": "text/plain; charset=utf-8", "text/html,application/json;q=0.9": "text/html; charset=utf-8"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		indexHandler(rec, req)
+		if rec.Header().Get("Content-Type") != contentType {
+			t.Errorf("Accept %q got Content-Type %q, expected %q", accept, rec.Header().Get("Content-Type"), contentType)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/google/uuid"
+)
+
+// ErrCodeInternalPanic is set on tasks which failed on a bug in the Client, so the add-on can ask for a bug report.
+const ErrCodeInternalPanic = "internal_panic"
+
+// runTask runs fn and recovers its panic, so a bug in one task does not kill the Client with all connected add-ons.
+// The panic is logged with the stack trace and reported as error of the task taskID with ErrCodeInternalPanic.
+// If the ID of the task is not known before fn creates it, the panic is reported as a new task of type internal_panic.
+func runTask(appID int, taskID string, fn func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := string(debug.Stack())
+		BKLog.Printf("%s Panic in task %s of app %d: %v\n%s", EmoError, taskID, appID, r, stack)
+		err := fmt.Errorf("internal error of BlenderKit-Client, please report a bug: %v", r)
+		if taskID != "" {
+			sendTask(TaskErrorCh, &TaskError{AppID: appID, TaskID: taskID, Error: err, MessageDetailed: stack, ErrorCode: ErrCodeInternalPanic})
+			return
+		}
+		task := NewTask(nil, appID, uuid.New().String(), "internal_panic")
+		task.Status = "error"
+		task.Error = err
+		task.Message = err.Error()
+		task.MessageDetailed = stack
+		task.ErrorCode = ErrCodeInternalPanic
+		sendTask(AddTaskCh, task)
+	}()
+	fn()
+}
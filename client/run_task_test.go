@@ -0,0 +1,75 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTaskRecoversPanic(t *testing.T) {
+	timeout := time.After(5 * time.Second)
+
+	go runTask(6281, "panicking-search", func() {
+		var results *SearchResults
+		_ = results.Count // nil pointer dereference
+	})
+	select {
+	case e := <-TaskErrorCh:
+		if e.AppID != 6281 || e.TaskID != "panicking-search" || e.ErrorCode != ErrCodeInternalPanic {
+			t.Errorf("TaskError = %+v; want %s of the panicking task", e, ErrCodeInternalPanic)
+		}
+		if !strings.Contains(e.MessageDetailed, "run_task_test.go") {
+			t.Errorf("MessageDetailed = %q; want stack trace of the panic", e.MessageDetailed)
+		}
+	case <-timeout:
+		t.Fatal("panic of task with known ID was not reported")
+	}
+
+	go runTask(6281, "", func() { panic("broken comment") })
+	for {
+		var task *Task
+		select {
+		case task = <-AddTaskCh:
+		case <-timeout:
+			t.Fatal("panic of task with unknown ID was not reported")
+		}
+		if task.AppID != 6281 {
+			continue
+		}
+		if task.TaskType != "internal_panic" || task.Status != "error" || task.ErrorCode != ErrCodeInternalPanic || !strings.Contains(task.Message, "broken comment") {
+			t.Errorf("task = %s %s %s %q; want internal_panic error", task.TaskType, task.Status, task.ErrorCode, task.Message)
+		}
+		break
+	}
+
+	// the Client keeps running tasks after the panics
+	go runTask(6281, "next-task", func() { TaskFinishCh <- &TaskFinish{AppID: 6281, TaskID: "next-task"} })
+	for {
+		select {
+		case f := <-TaskFinishCh:
+			if f.TaskID == "next-task" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("task after the panics did not finish")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExitAlreadyRunning is the exit code used when a compatible Client already serves the port,
+// the add-on recognizes it and attaches to the running instance.
+const ExitAlreadyRunning = 3
+
+// ClientVersionHeader is set by indexHandler, so a starting Client can tell another Client on its port from other software.
+const ClientVersionHeader = "X-BK-Client"
+
+// RunningClientProbeTimeout bounds the check for another Client on the port before binding.
+var RunningClientProbeTimeout = 2 * time.Second
+
+// RunningClient is another BlenderKit-Client answering on the port.
+type RunningClient struct {
+	PID     int
+	Version string
+}
+
+// probeRunningClient calls GET / on the port. It returns nil when nothing listens there
+// or when the responder is not a BlenderKit-Client.
+func probeRunningClient(port string) *RunningClient {
+	client := &http.Client{
+		Timeout:   RunningClientProbeTimeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%s/", port))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	version := resp.Header.Get(ClientVersionHeader)
+	if resp.StatusCode != http.StatusOK || version == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 32))
+	if err != nil {
+		return nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil || pid <= 0 {
+		return nil
+	}
+	return &RunningClient{PID: pid, Version: version}
+}
+
+// compatibleClientVersion reports whether the Client of version other serves the same API as this one,
+// which holds for the same major and minor version.
+func compatibleClientVersion(other string) bool {
+	majorMinor := func(v string) string {
+		parts := strings.SplitN(v, ".", 3)
+		if len(parts) < 2 {
+			return v
+		}
+		return parts[0] + "." + parts[1]
+	}
+	return majorMinor(other) == majorMinor(ClientVersion)
+}
+
+// checkRunningClient returns the compatible Client already running on the port, if any.
+// An incompatible Client or other software on the port is only logged, startup continues with the port fallback.
+func checkRunningClient(port string) *RunningClient {
+	running := probeRunningClient(port)
+	if running == nil {
+		return nil
+	}
+	if !compatibleClientVersion(running.Version) {
+		BKLog.Printf("%s BlenderKit-Client v%s (pid %d) is running on port %s, incompatible with v%s", EmoWarning, running.Version, running.PID, port, ClientVersion)
+		return nil
+	}
+	return running
+}
@@ -0,0 +1,83 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestCheckRunningClient(t *testing.T) {
+	defer func(v string) { ClientVersion = v }(ClientVersion)
+	ClientVersion = "1.4.2"
+
+	occupant := func(handler http.HandlerFunc) (port string, stop func()) {
+		server := httptest.NewServer(handler)
+		u, _ := url.Parse(server.URL)
+		return u.Port(), server.Close
+	}
+	client := func(version, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(ClientVersionHeader, version)
+			fmt.Fprint(w, body)
+		}
+	}
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		pid     int
+	}{
+		{"compatible client", client("1.4.0", "4242"), 4242},
+		{"incompatible client", client("1.3.9", "4242"), 0},
+		{"client without pid", client("1.4.2", "starting"), 0},
+		{"other software", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "4242") }, 0},
+		{"other software failing", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(ClientVersionHeader, "1.4.2")
+			http.Error(w, "4242", http.StatusInternalServerError)
+		}, 0},
+	} {
+		port, stop := occupant(tt.handler)
+		running := checkRunningClient(port)
+		stop()
+		switch {
+		case tt.pid == 0 && running != nil:
+			t.Errorf("%s: detected running Client %+v; want port fallback", tt.name, *running)
+		case tt.pid != 0 && (running == nil || running.PID != tt.pid):
+			t.Errorf("%s: detected %+v; want Client with pid %d", tt.name, running, tt.pid)
+		}
+	}
+
+	port, stop := occupant(func(w http.ResponseWriter, r *http.Request) {})
+	stop()
+	if running := checkRunningClient(port); running != nil {
+		t.Errorf("free port: detected running Client %+v", *running)
+	}
+
+	// the real index of a Client is recognized
+	port, stop = occupant(indexHandler)
+	defer stop()
+	running := checkRunningClient(port)
+	if running == nil || running.PID != os.Getpid() || running.Version != ClientVersion {
+		t.Errorf("indexHandler: detected %+v; want pid %d and version %s", running, os.Getpid(), ClientVersion)
+	}
+}
@@ -0,0 +1,192 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PathRootSet are the directories into which the add-on may ask the Client to write files with blocking_file_download
+// and uploads. GetSafeTempPath() and the system temp directory are always allowed, download dirs are configured
+// by download_dirs of /settings/update. Requests writing the files never add roots, else the caller could allow
+// any directory first and write into it next.
+// Each add-on has its own download dirs, e.g. the project dir of its open .blend file, the union of them is allowed.
+type PathRootSet struct {
+	mux   sync.Mutex
+	roots map[int][]string // by app_id
+}
+
+var PathRoots = &PathRootSet{}
+
+// Set replaces the download dirs of the add-on, dirs must be resolved by resolvePath(). Empty dirs remove them.
+func (s *PathRootSet) Set(appID int, dirs []string) {
+	roots := make([]string, len(dirs))
+	for i, dir := range dirs {
+		roots[i] = filepath.Clean(dir)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(roots) == 0 {
+		delete(s.roots, appID)
+		return
+	}
+	if s.roots == nil {
+		s.roots = make(map[int][]string)
+	}
+	s.roots[appID] = roots
+}
+
+// Remove removes the download dirs of the add-on which unsubscribed.
+func (s *PathRootSet) Remove(appID int) {
+	s.Set(appID, nil)
+}
+
+// Configured returns the sorted union of the download dirs of all add-ons.
+func (s *PathRootSet) Configured() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	union := []string{}
+	for _, dirs := range s.roots {
+		union = append(union, dirs...)
+	}
+	slices.Sort(union)
+	return slices.Compact(union)
+}
+
+// Allows reports whether the resolved path is inside one of the roots.
+func (s *PathRootSet) Allows(path string) bool {
+	roots := []string{os.TempDir()}
+	if safeTemp, err := GetSafeTempPath(); err == nil {
+		roots = append(roots, safeTemp)
+	}
+	for _, root := range append(roots, s.Configured()...) {
+		if isPathInside(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathInside reports whether path is root or is inside it, both must be clean absolute paths.
+func isPathInside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// resolvePath replaces the path from the add-on with the clean absolute path.
+// Paths with ".." are rejected with either separator, even when they would resolve inside the intended directory.
+func resolvePath(field string, path *string) error {
+	if err := validateNotEmpty(field, *path); err != nil {
+		return err
+	}
+	if strings.ContainsRune(*path, 0) {
+		return &FieldError{Field: field, Message: "must not contain NUL character"}
+	}
+	for _, part := range strings.FieldsFunc(*path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return &FieldError{Field: field, Message: fmt.Sprintf("must not contain '..': %q", *path)}
+		}
+	}
+	abs, err := filepath.Abs(*path)
+	if err != nil {
+		return &FieldError{Field: field, Message: err.Error()}
+	}
+	*path = abs
+	return nil
+}
+
+// resolveRootedPath resolves the path like resolvePath and requires it to be inside PathRoots.
+func resolveRootedPath(field string, path *string) error {
+	if err := resolvePath(field, path); err != nil {
+		return err
+	}
+	if !PathRoots.Allows(*path) {
+		return &FieldError{Field: field, Message: fmt.Sprintf("must be inside a download directory or the temp directory: %q", *path)}
+	}
+	return nil
+}
+
+// resolveDownloadRoots resolves the download dirs configured in /settings/update.
+// Roots of file systems are rejected, the whole disk is never a download dir.
+func resolveDownloadRoots(dirs []string) error {
+	for i := range dirs {
+		field := fmt.Sprintf("download_dirs[%d]", i)
+		if err := resolvePath(field, &dirs[i]); err != nil {
+			return err
+		}
+		if filepath.Dir(dirs[i]) == dirs[i] {
+			return &FieldError{Field: field, Message: fmt.Sprintf("must not be the root directory: %q", dirs[i])}
+		}
+	}
+	return nil
+}
+
+// resolveSearchTempDir resolves the directory for thumbnails of the search, it must be inside GetSafeTempPath().
+// Empty TempDir is kept for add-ons which do not download thumbnails.
+func resolveSearchTempDir(data *SearchTaskData) error {
+	if data.TempDir == "" {
+		return nil
+	}
+	if err := resolvePath("tempdir", &data.TempDir); err != nil {
+		return err
+	}
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	if !isPathInside(safeTemp, data.TempDir) {
+		return &FieldError{Field: "tempdir", Message: fmt.Sprintf("must be inside the temp directory %q: %q", safeTemp, data.TempDir)}
+	}
+	return nil
+}
+
+// resolveUploadPaths resolves the paths of the upload. TempDir, where the packed file is written, must be in the temp directory.
+// Thumbnail and source files are only read, so they can be anywhere, e.g. next to the .blend file of the user.
+func resolveUploadPaths(data *AssetUploadExportData) error {
+	if data.TempDir != "" {
+		if err := resolveRootedPath("export_data.temp_dir", &data.TempDir); err != nil {
+			return err
+		}
+	}
+	sources := []struct {
+		field string
+		path  *string
+	}{
+		{"export_data.thumbnail_path", &data.ThumbnailPath},
+		{"export_data.source_filepath", &data.SourceFilePath},
+		{"export_data.hdr_filepath", &data.HDRFilepath},
+	}
+	for _, source := range sources {
+		if *source.path == "" {
+			continue
+		}
+		if err := resolvePath(source.field, source.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
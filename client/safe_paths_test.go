@@ -0,0 +1,236 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResolvePath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"/tmp/bktemp/./model_search/": "/tmp/bktemp/model_search",
+		"/tmp/..hidden/thumbs":        "/tmp/..hidden/thumbs",
+		"/tmp/bktemp/thumbs...":       "/tmp/bktemp/thumbs...",
+		"thumbs":                      filepath.Join(cwd, "thumbs"),
+		"":                            "",
+		"../../etc/passwd":            "",
+		"/tmp/bktemp/../../etc":       "",
+		"/tmp/bktemp/..":              "",
+		`C:\Users\bk\..\..\Windows`:   "",
+		`..\Startup`:                  "",
+		"/tmp/bktemp\x00/../../etc":   "",
+	} {
+		actual := path
+		err := resolvePath("filepath", &actual)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("resolvePath(%q) = %q; want error", path, actual)
+			}
+			continue
+		}
+		if err != nil || actual != expected {
+			t.Errorf("resolvePath(%q) = %q, %v; want %q", path, actual, err, expected)
+		}
+	}
+}
+
+func TestIsPathInside(t *testing.T) {
+	for _, test := range []struct {
+		root, path string
+		expected   bool
+	}{
+		{"/tmp/bk", "/tmp/bk", true},
+		{"/tmp/bk", "/tmp/bk/models/chair.blend", true},
+		{"/tmp/bk", "/tmp/bk2/chair.blend", false},
+		{"/tmp/bk", "/tmp", false},
+		{"/tmp/bk", "/etc/passwd", false},
+		{"/tmp/bk", "relative/chair.blend", false},
+	} {
+		if actual := isPathInside(test.root, test.path); actual != test.expected {
+			t.Errorf("isPathInside(%q, %q) = %t; want %t", test.root, test.path, actual, test.expected)
+		}
+	}
+}
+
+// savePathRoots replaces PathRoots with empty roots for the test.
+func savePathRoots(t *testing.T) {
+	roots := PathRoots
+	PathRoots = &PathRootSet{}
+	t.Cleanup(func() { PathRoots = roots })
+}
+
+func TestPathRoots(t *testing.T) {
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	roots := &PathRootSet{}
+	downloadDir := filepath.Join(string(filepath.Separator), "home", "user", "blenderkit_data", "models")
+	if roots.Allows(filepath.Join(downloadDir, "chair", "chair.blend")) {
+		t.Error("path in unregistered download dir is allowed")
+	}
+	roots.Set(1234, []string{downloadDir + string(filepath.Separator)})
+	for path, expected := range map[string]bool{
+		filepath.Join(downloadDir, "chair", "chair.blend"):      true,
+		filepath.Join(os.TempDir(), "bktemp", "thumb.jpg"):      true,
+		filepath.Join(downloadDir+"_evil", "chair.blend"):       false,
+		filepath.Join(string(filepath.Separator), "etc", "rc"):  false,
+		filepath.Join(filepath.Dir(downloadDir), "chair.blend"): false,
+	} {
+		if actual := roots.Allows(path); actual != expected {
+			t.Errorf("Allows(%q) = %t; want %t", path, actual, expected)
+		}
+	}
+}
+
+// Two add-ons with different download dirs, e.g. two Blenders with different global_dir, or a project dir of the open .blend file.
+func TestPathRootsPerApp(t *testing.T) {
+	saveClientSettings(t)
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	globalDir, otherGlobalDir, projectDir := t.TempDir(), t.TempDir(), t.TempDir()
+	if _, err := UpdateClientSettings(6551, ClientSettings{DownloadDirs: []string{globalDir}}); err != nil {
+		t.Fatal(err)
+	}
+	effective, err := UpdateClientSettings(6552, ClientSettings{DownloadDirs: []string{otherGlobalDir, projectDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effective.DownloadDirs) != 3 {
+		t.Errorf("effective download dirs %v; want the union of both add-ons", effective.DownloadDirs)
+	}
+	for _, dir := range []string{globalDir, otherGlobalDir} {
+		if !PathRoots.Allows(filepath.Join(dir, "models", "chair.blend")) {
+			t.Errorf("download dir %s is not allowed after the other add-on set its dirs", dir)
+		}
+	}
+
+	target := filepath.Join(projectDir, "chair.blend")
+	body := `{"app_id": 6552, "url": "` + server.URL + `/file", "filepath": ` + strconv.Quote(target) + `}`
+	rec := httptest.NewRecorder()
+	BlockingFileDownloadHandler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("blocking download into the project dir: %d %s; want 200", rec.Code, rec.Body.String())
+	}
+	if content, err := os.ReadFile(target); err != nil || string(content) != "payload" {
+		t.Errorf("downloaded file %q, %v; want payload", content, err)
+	}
+
+	PathRoots.Remove(6552)
+	if PathRoots.Allows(target) || !PathRoots.Allows(filepath.Join(globalDir, "chair.blend")) {
+		t.Error("dirs of the unsubscribed add-on are allowed, or the dirs of the other add-on were removed")
+	}
+}
+
+func TestBlockingFileHandlersRejectHostilePaths(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+	home := filepath.Join(string(filepath.Separator), "home", "user")
+	for _, path := range []string{
+		filepath.Join(home, ".bashrc"),
+		filepath.Join(os.TempDir(), "..", "etc", "cron.d", "bk"),
+		"../../.config/autostart/bk.desktop",
+		"",
+	} {
+		for name, handler := range map[string]http.HandlerFunc{"download": BlockingFileDownloadHandler, "upload": BlockingFileUploadHandler} {
+			body := `{"url": "` + server.URL + `/file", "filepath": "` + strings.ReplaceAll(path, `\`, `\\`) + `"}`
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ErrCodeInvalidField) {
+				t.Errorf("blocking %s to %q: %d %s; want 400 %s", name, path, rec.Code, rec.Body.String(), ErrCodeInvalidField)
+			}
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d requests were sent for rejected paths", n)
+	}
+}
+
+// Search must not allow the directories of its request, only the configured download dirs are allowed.
+func TestSearchDoesNotAddPathRoots(t *testing.T) {
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	savePathRoots(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	root := string(filepath.Separator)
+	body := `{"app_id": 1234, "urlquery": "https://www.blenderkit.com/api/v1/search/", "tempdir": ` + strconv.Quote(root) + `, "PREFS": {"global_dir": ` + strconv.Quote(root) + `}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	assetSearchHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("search with tempdir %q: status %d; want 400", root, rec.Code)
+	}
+
+	target := filepath.Join(root, "etc", "bk_test")
+	body = `{"url": "` + server.URL + `/file", "filepath": ` + strconv.Quote(target) + `}`
+	rec = httptest.NewRecorder()
+	BlockingFileDownloadHandler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ErrCodeInvalidField) {
+		t.Errorf("blocking download to %q after the requests: %d %s; want 400 %s", target, rec.Code, rec.Body.String(), ErrCodeInvalidField)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d requests were sent for the rejected path", n)
+	}
+}
+
+func TestResolveSearchTempDir(t *testing.T) {
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	safeTemp, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tempDir, allowed := range map[string]bool{
+		"":                                      true,
+		filepath.Join(safeTemp, "model_search"): true,
+		safeTemp + "_evil":                      false,
+		filepath.Join(string(filepath.Separator), "home"): false,
+		filepath.Dir(safeTemp):                            false,
+	} {
+		data := SearchTaskData{TempDir: tempDir}
+		if err := resolveSearchTempDir(&data); (err == nil) != allowed {
+			t.Errorf("resolveSearchTempDir(%q) = %v; want allowed %t", tempDir, err, allowed)
+		}
+	}
+}
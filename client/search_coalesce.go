@@ -0,0 +1,175 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// searchFlight is one search request shared by identical concurrent searches, e.g. from more Blender instances.
+// Results and error are set before done is closed.
+type searchFlight struct {
+	key         string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	result      SearchResults
+	err         error
+	detailed    string                   // MessageDetailed of the error
+	subscribers int                      // searches waiting for the flight, guarded by searchFlightsMux
+	thumbnails  map[string]chan struct{} // by TempDir, closed once thumbnails are downloaded there
+	mux         sync.Mutex               // guards thumbnails
+}
+
+var (
+	searchFlights    = make(map[string]*searchFlight) // by searchFlightKey()
+	searchFlightsMux sync.Mutex
+)
+
+// forgetSearchFlights makes the next searches start new requests instead of joining the ones in flight,
+// which finish against the server they started with.
+func forgetSearchFlights() {
+	searchFlightsMux.Lock()
+	searchFlights = make(map[string]*searchFlight)
+	searchFlightsMux.Unlock()
+}
+
+func searchFlightKey(data SearchTaskData) string {
+	return data.URLQuery + "\x00" + data.APIKey
+}
+
+// joinSearchFlight subscribes to the flight of identical search in progress, or starts a new one.
+// Returns true if the caller is the first subscriber and has to run the flight.
+func joinSearchFlight(data SearchTaskData) (*searchFlight, bool) {
+	key := searchFlightKey(data)
+	searchFlightsMux.Lock()
+	defer searchFlightsMux.Unlock()
+	if flight, ok := searchFlights[key]; ok {
+		flight.subscribers++
+		return flight, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	flight := &searchFlight{
+		key:         key,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		subscribers: 1,
+		thumbnails:  make(map[string]chan struct{}),
+	}
+	searchFlights[key] = flight
+	return flight, true
+}
+
+// leave unsubscribes cancelled search. The request is cancelled only when no search waits for it anymore.
+func (f *searchFlight) leave() {
+	searchFlightsMux.Lock()
+	defer searchFlightsMux.Unlock()
+	f.subscribers--
+	if f.subscribers > 0 {
+		return
+	}
+	f.cancel()
+	if searchFlights[f.key] == f {
+		delete(searchFlights, f.key) // next identical search starts a new request
+	}
+}
+
+// run performs the search request, task is the search of the first subscriber.
+func (f *searchFlight) run(data SearchTaskData, task *Task) {
+	f.result, f.detailed, f.err = fetchSearch(f.ctx, data, task)
+	searchFlightsMux.Lock()
+	if searchFlights[f.key] == f {
+		delete(searchFlights, f.key)
+	}
+	searchFlightsMux.Unlock()
+	f.cancel()
+	close(f.done)
+}
+
+// parseThumbnails schedules the thumbnails once per TempDir: searches sharing the TempDir wait for the first one
+// and then get the thumbnails from disk. On nil flight, thumbnails are just scheduled.
+func (f *searchFlight) parseThumbnails(searchResult SearchResults, data SearchTaskData, searchTaskID string) {
+	if f == nil {
+		parseThumbnails(searchResult, data, searchTaskID)
+		return
+	}
+	f.mux.Lock()
+	downloaded, scheduled := f.thumbnails[data.TempDir]
+	if !scheduled {
+		downloaded = make(chan struct{})
+		f.thumbnails[data.TempDir] = downloaded
+	}
+	f.mux.Unlock()
+	if scheduled {
+		<-downloaded
+	} else {
+		defer close(downloaded)
+	}
+	parseThumbnails(searchResult, data, searchTaskID)
+}
+
+// fetchSearch performs the search request and decodes the results. On error returns also its detailed message.
+func fetchSearch(ctx context.Context, data SearchTaskData, task *Task) (SearchResults, string, error) {
+	ctx, cancel, client := withRequestTimeout(ctx, ClientAPI, data.TimeoutS)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", data.URLQuery, nil)
+	if err != nil {
+		err = fmt.Errorf("search - creating request: %w", err)
+		return SearchResults{}, "", err
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID})
+	trace := NewRequestTrace("search")
+	req = trace.Attach(req)
+
+	resp, err := doIdempotent(client, req, task)
+	if err != nil {
+		err = trace.Wrap(fmt.Errorf("search - performing request: %w", err))
+		return SearchResults{}, TraceDetail(err), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respJSON, respString, _ := ParseFailedHTTPResponse(resp)
+		err := trace.Wrap(fmt.Errorf("search failed: %s (%s)", ResponseDetail(respJSON, respString), resp.Status))
+		detailed := fmt.Sprintf("%s\nquery: %s", respString, data.URLQuery)
+		if traceDetail := TraceDetail(err); traceDetail != "" {
+			detailed += "\n" + traceDetail
+		}
+		return SearchResults{}, detailed, err
+	}
+	trace.Finish()
+
+	err = RespIsJSON(resp)
+	if err != nil {
+		err = fmt.Errorf("search: %w", err)
+		return SearchResults{}, "", err
+	}
+
+	searchResult, err := decodeSearchResults(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("search - decoding response: %w", err)
+		return SearchResults{}, "", err
+	}
+
+	return searchResult, "", nil
+}
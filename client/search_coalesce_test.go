@@ -0,0 +1,164 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSearchCoalescing(t *testing.T) {
+	var mux sync.Mutex
+	requests := map[string]int{}
+	release := make(chan struct{})
+	searchCancelled := make(chan struct{}, 1)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		requests[r.URL.RequestURI()+" "+r.Header.Get("Authorization")]++
+		mux.Unlock()
+		if r.URL.Path != "/search" {
+			w.Write([]byte("png"))
+			return
+		}
+		block := release
+		if r.URL.Query().Get("query") == "table" {
+			block = nil // never answered, only cancelled
+		}
+		select {
+		case <-block:
+		case <-r.Context().Done():
+			searchCancelled <- struct{}{}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"count": 1, "results": [{"assetBaseId": "a1", "thumbnailSmallUrl": "%[1]s/thumbs/small.png", "thumbnailMiddleUrl": "%[1]s/thumbs/middle.png"}]}`, server.URL)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	// thumbnail downloads of the search outlive the test, so these are not restored
+	ClientSmallThumbs, ClientBigThumbs = server.Client(), server.Client()
+	requestCount := func(key string) int {
+		mux.Lock()
+		defer mux.Unlock()
+		return requests[key]
+	}
+
+	// searches record into the search history after they finish, the test returns once they did
+	var searches sync.WaitGroup
+	defer searches.Wait()
+	tempDir, otherTempDir := t.TempDir(), t.TempDir()
+	search := func(appID int, query, apiKey string) *Task {
+		dir := tempDir
+		if apiKey != "" {
+			dir = otherTempDir
+		}
+		searches.Add(1)
+		go func() {
+			defer searches.Done()
+			doAssetSearch(SearchTaskData{AppID: appID, TempDir: dir, URLQuery: server.URL + query, APIKey: apiKey}, fmt.Sprintf("search-%d", appID))
+		}()
+		for {
+			select {
+			case task := <-AddTaskCh:
+				if task.TaskType == "search" && task.AppID == appID {
+					return task
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("search of app %d was not started", appID)
+			}
+		}
+	}
+	// wait returns results of searches by task ID, thumbnail tasks are left in AddTaskCh for search()
+	// and their results are skipped
+	wait := func(count int) map[string]interface{} {
+		results := map[string]interface{}{}
+		timeout := time.After(5 * time.Second)
+		for len(results) < count {
+			select {
+			case f := <-TaskFinishCh:
+				if strings.HasPrefix(f.TaskID, "search-") {
+					results[f.TaskID] = f.Result
+				}
+			case e := <-TaskErrorCh:
+				if strings.HasPrefix(e.TaskID, "search-") {
+					results[e.TaskID] = e.Error
+				}
+			case <-timeout:
+				t.Fatalf("got %d of %d searches", len(results), count)
+			}
+		}
+		return results
+	}
+
+	// identical searches share one request, cancelled subscriber does not cancel it for the others
+	first := search(6201, "/search?query=chair", "")
+	search(6202, "/search?query=chair", "")
+	search(6203, "/search?query=chair", "")
+	search(6204, "/search?query=chair", "other-user")
+	first.Cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	results := wait(4)
+	if _, ok := results["search-6201"].(error); !ok {
+		t.Errorf("cancelled search got %v, expected error", results["search-6201"])
+	}
+	for _, taskID := range []string{"search-6202", "search-6203", "search-6204"} {
+		if result, ok := results[taskID].(SearchResults); !ok || len(result.Results) != 1 {
+			t.Errorf("%s got %v, expected shared results", taskID, results[taskID])
+		}
+	}
+	if count := requestCount("/search?query=chair "); count != 1 {
+		t.Errorf("server got %d identical searches, expected 1", count)
+	}
+	if count := requestCount("/search?query=chair Bearer other-user"); count != 1 {
+		t.Errorf("server got %d searches of other user, expected 1", count)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for requestCount("/thumbs/middle.png ") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond) // thumbnails of other subscribers would be requested by now
+	if count := requestCount("/thumbs/small.png "); count != 2 {
+		t.Errorf("small thumbnail downloaded %d times, expected once for each TempDir", count)
+	}
+
+	// request is cancelled once all subscribers are cancelled
+	a := search(6205, "/search?query=table", "")
+	b := search(6206, "/search?query=table", "")
+	a.Cancel()
+	select {
+	case <-searchCancelled:
+		t.Fatal("shared search was cancelled while another search waits for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Cancel()
+	select {
+	case <-searchCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared search was not cancelled after all searches were cancelled")
+	}
+	wait(2)
+}
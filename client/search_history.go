@@ -0,0 +1,244 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const searchHistoryFilename = "search_history.json" // recent searches of the users in GetSafeTempPath()
+
+// SearchHistoryMaxEntries is how many recent searches the Client keeps per profile, older ones are dropped.
+const SearchHistoryMaxEntries = 30
+
+// SearchHistoryEntry is a successful search of the user.
+type SearchHistoryEntry struct {
+	Query       string    `json:"query"` // keywords without the filters like asset_type:model
+	AssetType   string    `json:"asset_type"`
+	ResultCount int       `json:"result_count"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SearchHistoryStore keeps recent searches newest first for each profile, persisted in a JSON file
+// so the add-on can offer them after restart of Blender and the Client.
+type SearchHistoryStore struct {
+	mux        sync.Mutex
+	path       string
+	maxEntries int                             // per profile
+	profiles   map[string][]SearchHistoryEntry // by searchProfile()
+}
+
+// SearchHistory is the history of the Client, it is never replaced so searches running in the background can record into it.
+var SearchHistory = NewSearchHistoryStore(SearchHistoryMaxEntries)
+
+// NewSearchHistoryStore returns empty history keeping up to maxEntries searches per profile, not persisted until loaded.
+func NewSearchHistoryStore(maxEntries int) *SearchHistoryStore {
+	return &SearchHistoryStore{maxEntries: maxEntries}
+}
+
+// LoadSearchHistory reads the search history from the safe temp path.
+func LoadSearchHistory() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return SearchHistory.load(filepath.Join(tempDir, searchHistoryFilename))
+}
+
+func (h *SearchHistoryStore) load(path string) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.path = path
+	h.profiles = nil
+	history, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(history, &h.profiles); err != nil {
+		return fmt.Errorf("corrupted search history %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes the history, must be called with h.mux locked.
+func (h *SearchHistoryStore) save() error {
+	if h.path == "" {
+		return nil
+	}
+	history, err := json.Marshal(h.profiles)
+	if err != nil {
+		return err
+	}
+	tmpPath := h.path + ".tmp"
+	if err := os.WriteFile(tmpPath, history, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, h.path)
+}
+
+// searchProfile identifies the user of the history without storing the API key, anonymous users share one history.
+func searchProfile(apiKey string) string {
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Add records the search as the newest entry of the profile. Repeated search replaces its older entry.
+func (h *SearchHistoryStore) Add(profile string, entry SearchHistoryEntry) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.profiles == nil {
+		h.profiles = make(map[string][]SearchHistoryEntry)
+	}
+	entries := []SearchHistoryEntry{entry}
+	for _, e := range h.profiles[profile] {
+		if e.Query != entry.Query || e.AssetType != entry.AssetType {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > h.maxEntries {
+		entries = entries[:h.maxEntries]
+	}
+	h.profiles[profile] = entries
+	if err := h.save(); err != nil {
+		BKLog.Printf("%s Failed to save search history: %v", EmoWarning, err)
+	}
+}
+
+// Recent returns up to limit newest searches of the profile.
+func (h *SearchHistoryStore) Recent(profile string, limit int) []SearchHistoryEntry {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	entries := h.profiles[profile]
+	if limit > len(entries) {
+		limit = len(entries)
+	}
+	return append([]SearchHistoryEntry{}, entries[:limit]...)
+}
+
+// Clear removes the searches of the profile, returns how many were removed.
+func (h *SearchHistoryStore) Clear(profile string) int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	cleared := len(h.profiles[profile])
+	if cleared == 0 {
+		return 0
+	}
+	delete(h.profiles, profile)
+	if err := h.save(); err != nil {
+		BKLog.Printf("%s Failed to save search history: %v", EmoWarning, err)
+	}
+	return cleared
+}
+
+// searchHistoryEntry returns the entry of the search, false for searches which are not typed by the user:
+// next pages, asset_base_id lookups which refetch known assets and searches without keywords.
+func searchHistoryEntry(data SearchTaskData, searchResult SearchResults) (SearchHistoryEntry, bool) {
+	if data.GetNext {
+		return SearchHistoryEntry{}, false
+	}
+	entry := SearchHistoryEntry{AssetType: data.AssetType, ResultCount: searchResult.Count, Timestamp: time.Now()}
+	if data.Query != nil {
+		entry.Query, entry.AssetType = strings.TrimSpace(data.Query.Text), data.Query.AssetType
+		return entry, entry.Query != ""
+	}
+
+	parsed, err := url.Parse(data.URLQuery)
+	if err != nil {
+		return SearchHistoryEntry{}, false
+	}
+	var keywords []string
+	for _, term := range strings.Fields(parsed.Query().Get("query")) {
+		name, value, isFilter := strings.Cut(term, ":")
+		switch {
+		case !isFilter:
+			keywords = append(keywords, term)
+		case name == "asset_base_id":
+			return SearchHistoryEntry{}, false
+		case name == "asset_type" && entry.AssetType == "":
+			entry.AssetType = value
+		}
+	}
+	entry.Query = strings.Join(keywords, " ")
+	return entry, entry.Query != ""
+}
+
+// Record adds the successful search to the history of the user.
+func (h *SearchHistoryStore) Record(data SearchTaskData, searchResult SearchResults) {
+	if entry, ok := searchHistoryEntry(data, searchResult); ok {
+		h.Add(searchProfile(data.APIKey), entry)
+	}
+}
+
+// SearchHistoryData is expected from the add-on on /search/history and /search/history/clear.
+type SearchHistoryData struct {
+	AppID  int    `json:"app_id"`
+	APIKey string `json:"api_key"`
+	Limit  int    `json:"limit"` // all kept searches if 0
+}
+
+// Handler returns recent searches of the user, newest first.
+func (h *SearchHistoryStore) Handler(w http.ResponseWriter, r *http.Request) {
+	var data SearchHistoryData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateAppID(data.AppID); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if data.Limit < 0 {
+		writeValidationError(w, &FieldError{Field: "limit", Message: "must not be negative"})
+		return
+	}
+	limit := data.Limit
+	if limit == 0 {
+		limit = h.maxEntries
+	}
+	writeJSON(w, map[string]interface{}{"searches": h.Recent(searchProfile(data.APIKey), limit)})
+}
+
+// ClearHandler removes recent searches of the user.
+func (h *SearchHistoryStore) ClearHandler(w http.ResponseWriter, r *http.Request) {
+	var data SearchHistoryData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateAppID(data.AppID); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"cleared": h.Clear(searchProfile(data.APIKey))})
+}
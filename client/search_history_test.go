@@ -0,0 +1,136 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchHistoryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), searchHistoryFilename)
+	h := NewSearchHistoryStore(3)
+	if err := h.load(path); err != nil {
+		t.Fatalf("loading missing history: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		h.Add("user", SearchHistoryEntry{Query: fmt.Sprintf("chair %d", i), AssetType: "model"})
+	}
+	h.Add("user", SearchHistoryEntry{Query: "chair 2", AssetType: "model", ResultCount: 7}) // repeated search moves to the top
+	h.Add("user", SearchHistoryEntry{Query: "chair 2", AssetType: "material"})
+	h.Add("other", SearchHistoryEntry{Query: "table", AssetType: "model"})
+
+	reloaded := NewSearchHistoryStore(3)
+	if err := reloaded.load(path); err != nil {
+		t.Fatalf("loading history: %v", err)
+	}
+	history := func(profile string, limit int) string {
+		var entries []string
+		for _, entry := range reloaded.Recent(profile, limit) {
+			entries = append(entries, fmt.Sprintf("%s/%s/%d", entry.Query, entry.AssetType, entry.ResultCount))
+		}
+		return strings.Join(entries, ", ")
+	}
+	if got, want := history("user", 10), "chair 2/material/0, chair 2/model/7, chair 3/model/0"; got != want {
+		t.Errorf("history of user = %q; want %q, oldest evicted", got, want)
+	}
+	if got, want := history("user", 1), "chair 2/material/0"; got != want {
+		t.Errorf("history of user with limit 1 = %q; want %q", got, want)
+	}
+	if got, want := history("other", 10), "table/model/0"; got != want {
+		t.Errorf("history of other = %q; want %q", got, want)
+	}
+
+	if cleared := reloaded.Clear("user"); cleared != 3 {
+		t.Errorf("cleared %d searches; want 3", cleared)
+	}
+	if cleared := reloaded.Clear("user"); cleared != 0 {
+		t.Errorf("cleared %d searches of empty history; want 0", cleared)
+	}
+	if err := h.load(path); err != nil {
+		t.Fatalf("loading cleared history: %v", err)
+	}
+	if n := len(h.Recent("user", 10)); n != 0 {
+		t.Errorf("%d searches of user after clearing; want 0", n)
+	}
+	if n := len(h.Recent("other", 10)); n != 1 {
+		t.Errorf("%d searches of other profile after clearing user; want 1", n)
+	}
+}
+
+func TestSearchHistoryEntry(t *testing.T) {
+	const api = "https://www.blenderkit.com/api/v1/search/"
+	tests := []struct {
+		name      string
+		data      SearchTaskData
+		query     string
+		assetType string
+		recorded  bool
+	}{
+		{"keywords of raw query", SearchTaskData{AssetType: "model", URLQuery: api + "?query=wooden%20chair+asset_type:model+order:_score&page_size=15"}, "wooden chair", "model", true},
+		{"asset type from the query", SearchTaskData{URLQuery: api + "?query=oak+asset_type:material"}, "oak", "material", true},
+		{"structured query", SearchTaskData{URLQuery: api + "?query=chair", Query: &SearchQuery{Text: " chair ", AssetType: "model"}}, "chair", "model", true},
+		{"asset base id lookup", SearchTaskData{AssetType: "model", URLQuery: api + "?query=asset_base_id:0992088b+asset_type:model"}, "", "", false},
+		{"next page", SearchTaskData{AssetType: "model", GetNext: true, URLQuery: api + "?query=chair+asset_type:model"}, "", "", false},
+		{"no keywords", SearchTaskData{AssetType: "model", URLQuery: api + "?query=+asset_type:model+order:-last_blend_upload"}, "", "", false},
+	}
+	for _, tt := range tests {
+		entry, recorded := searchHistoryEntry(tt.data, SearchResults{Count: 12})
+		if recorded != tt.recorded || (recorded && (entry.Query != tt.query || entry.AssetType != tt.assetType || entry.ResultCount != 12)) {
+			t.Errorf("%s: got %+v, recorded %t; want %q of %q, recorded %t", tt.name, entry, recorded, tt.query, tt.assetType, tt.recorded)
+		}
+	}
+}
+
+func TestSearchHistoryHandlers(t *testing.T) {
+	history := NewSearchHistoryStore(SearchHistoryMaxEntries)
+	history.Record(SearchTaskData{APIKey: "key-of-user-1234567", AssetType: "model", URLQuery: "https://www.blenderkit.com/api/v1/search/?query=chair+asset_type:model"}, SearchResults{Count: 3})
+
+	router := newRouter([]route{
+		{"/search/history", post, history.Handler},
+		{"/search/history/clear", post, history.ClearHandler},
+	})
+	post := func(path, body string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("POST %s got %d: %s", path, rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+	user := `{"app_id": 678, "api_key": "key-of-user-1234567"}`
+	if searches := post("/search/history", user)["searches"].([]interface{}); len(searches) != 1 || searches[0].(map[string]interface{})["query"] != "chair" {
+		t.Errorf("history of user = %v", searches)
+	}
+	if searches := post("/search/history", `{"app_id": 678}`)["searches"].([]interface{}); len(searches) != 0 {
+		t.Errorf("anonymous history = %v; want empty", searches)
+	}
+	if cleared := post("/search/history/clear", user)["cleared"]; cleared != float64(1) {
+		t.Errorf("cleared %v; want 1", cleared)
+	}
+	if searches := post("/search/history", user)["searches"].([]interface{}); len(searches) != 0 {
+		t.Errorf("history after clearing = %v", searches)
+	}
+}
@@ -0,0 +1,188 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// searchPrefetch is the next page of search results fetched in background before the add-on asks for it.
+type searchPrefetch struct {
+	url    string
+	cancel context.CancelFunc
+	done   chan struct{} // closed when result or err is set
+	result *SearchResults
+	err    error
+}
+
+// Prefetched search pages, at most one page ahead per add-on instance.
+var (
+	searchPrefetches    = make(map[int]*searchPrefetch)
+	searchPrefetchesMux sync.Mutex
+	searchPrefetchRuns  sync.WaitGroup // prefetches which did not return yet, also the cancelled ones
+)
+
+// startSearchPrefetch fetches the page at url in background, replacing previous prefetch of the app.
+func startSearchPrefetch(data SearchTaskData, url string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &searchPrefetch{url: url, cancel: cancel, done: make(chan struct{})}
+
+	searchPrefetchesMux.Lock()
+	if old := searchPrefetches[data.AppID]; old != nil {
+		old.cancel()
+	}
+	searchPrefetches[data.AppID] = p
+	searchPrefetchesMux.Unlock()
+
+	searchPrefetchRuns.Add(1)
+	go func() {
+		defer searchPrefetchRuns.Done()
+		p.run(ctx, data)
+	}()
+}
+
+// cancelSearchPrefetches drops the prefetched pages of all apps, used when the server changes.
+func cancelSearchPrefetches() {
+	searchPrefetchesMux.Lock()
+	defer searchPrefetchesMux.Unlock()
+	for appID, p := range searchPrefetches {
+		p.cancel()
+		delete(searchPrefetches, appID)
+	}
+}
+
+// takeSearchPrefetch returns prefetched page if it is the one requested by url, waiting for it if still in progress.
+// The prefetch is removed, a prefetch of different page is cancelled as it was superseded by new query.
+func takeSearchPrefetch(ctx context.Context, appID int, url string) (*SearchResults, bool) {
+	searchPrefetchesMux.Lock()
+	p := searchPrefetches[appID]
+	delete(searchPrefetches, appID)
+	searchPrefetchesMux.Unlock()
+	if p == nil {
+		return nil, false
+	}
+	if p.url != url {
+		p.cancel()
+		return nil, false
+	}
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.cancel()
+		return nil, false
+	}
+	p.cancel() // thumbnails are now downloaded for the search task
+	if p.err != nil {
+		return nil, false
+	}
+	return p.result, true
+}
+
+func (p *searchPrefetch) run(ctx context.Context, data SearchTaskData) {
+	p.result, p.err = fetchSearchPage(ctx, data, p.url)
+	close(p.done)
+	if p.err != nil {
+		if ctx.Err() == nil {
+			BKLog.Printf("%s Search prefetch failed: %v", EmoWarning, p.err)
+		}
+		return
+	}
+	prefetchSmallThumbnails(ctx, *p.result, data)
+}
+
+func fetchSearchPage(ctx context.Context, data SearchTaskData, url string) (*SearchResults, error) {
+	ctx, cancel, client := withRequestTimeout(ctx, ClientAPI, data.TimeoutS)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("search prefetch - creating request: %w", err)
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+
+	resp, err := doAPI(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("search prefetch - performing request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		return nil, fmt.Errorf("search prefetch: %s, status (%s), query: %v", respString, resp.Status, url)
+	}
+
+	if err := RespIsJSON(resp); err != nil {
+		return nil, fmt.Errorf("search prefetch: %w", err)
+	}
+
+	searchResult, err := decodeSearchResults(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("search prefetch - decoding response: %w", err)
+	}
+	return &searchResult, nil
+}
+
+// prefetchSmallThumbnails downloads small thumbnails of the page one by one, so they do not compete with thumbnails of the visible page.
+// DownloadThumbnail then finds them on disk, or revalidates them if the URL of the thumbnail changed. Stops when the prefetch is cancelled.
+func prefetchSmallThumbnails(ctx context.Context, searchResult SearchResults, data SearchTaskData) {
+	blVer, _ := StringToBlenderVersion(data.BlenderVersion)
+	for _, result := range searchResult.Results {
+		if ctx.Err() != nil {
+			return
+		}
+		smallThumbURL, _, _ := thumbnailURLs(result, blVer)
+		imgName, err := ExtractFilenameFromURL(smallThumbURL)
+		if err != nil {
+			continue
+		}
+		imgPath := filepath.Join(data.TempDir, imgName)
+		if _, err := os.Stat(imgPath); err == nil {
+			continue
+		}
+		if err := prefetchThumbnail(ctx, smallThumbURL, imgPath, data); err != nil && ctx.Err() == nil {
+			BKLog.Printf("%s Thumbnail prefetch failed: %v", EmoWarning, err)
+		}
+	}
+}
+
+// prefetchThumbnail downloads the thumbnail to imgPath through saveToFile(), partial file is never mistaken for downloaded thumbnail.
+func prefetchThumbnail(ctx context.Context, url, imgPath string, data SearchTaskData) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = getHeaders(RequestMeta{AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	resp, err := ClientSmallThumbs.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := saveToFile(imgPath, resp.Body); err != nil {
+		return err
+	}
+	ThumbnailSources.Set(imgPath, ThumbnailSource{URL: url, ETag: resp.Header.Get("ETag")})
+	return nil
+}
@@ -0,0 +1,135 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSearchPrefetch(t *testing.T) {
+	var mux sync.Mutex
+	requests := map[string]int{}
+	page3Cancelled := make(chan struct{})
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		mux.Lock()
+		requests[r.URL.Path+page]++
+		mux.Unlock()
+		switch {
+		case r.URL.Path == "/thumbs/small.png":
+			w.Write([]byte("png"))
+		case page == "3": // prefetch which gets superseded by a new query
+			<-r.Context().Done()
+			close(page3Cancelled)
+		case page == "1" || page == "2":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"count": 3, "next": "%s/search?page=%d", "results": [{"assetBaseId": "a%s", "thumbnailSmallUrl": "%s/thumbs/small.png", "thumbnailMiddleUrl": "%s/thumbs/small.png"}]}`,
+				server.URL, int(page[0]-'0')+1, page, server.URL, server.URL)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"count": 0, "results": []}`))
+		}
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	// thumbnail downloads of the search outlive the test, so these are not restored
+	ClientSmallThumbs, ClientBigThumbs = server.Client(), server.Client()
+	// prefetches use the server, they are stopped before it is closed
+	defer func() {
+		cancelSearchPrefetches()
+		searchPrefetchRuns.Wait()
+	}()
+
+	tempDir := t.TempDir()
+	data := SearchTaskData{AppID: 607, TempDir: tempDir, PrefetchNext: true, URLQuery: server.URL + "/search?page=1"}
+	waitSearch := func(taskID string) SearchResults {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case f := <-TaskFinishCh:
+				if f.TaskID == taskID {
+					return f.Result.(SearchResults)
+				}
+			case e := <-TaskErrorCh:
+				// thumbnail downloads of earlier runs may fail once their server is closed
+				if strings.HasPrefix(e.TaskID, "search-") {
+					t.Fatalf("task %s failed: %v", e.TaskID, e.Error)
+				}
+			case <-timeout:
+				t.Fatalf("search %s not finished", taskID)
+			}
+		}
+	}
+	requestCount := func(key string) int {
+		mux.Lock()
+		defer mux.Unlock()
+		return requests[key]
+	}
+
+	go doAssetSearch(data, "search-1")
+	waitSearch("search-1")
+	deadline := time.Now().Add(5 * time.Second)
+	for requestCount("/search2") == 0 || requestCount("/thumbs/small.png") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("next page was not prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "small.png")); err != nil {
+		time.Sleep(100 * time.Millisecond) // thumbnail requested, may be still renamed from .part
+	}
+
+	data.URLQuery = server.URL + "/search?page=2"
+	go doAssetSearch(data, "search-2")
+	if result := waitSearch("search-2"); len(result.Results) != 1 || result.Results[0].AssetBaseID != "a2" {
+		t.Errorf("second page = %+v; want asset a2", result)
+	}
+	if count := requestCount("/search2"); count != 1 {
+		t.Errorf("second page requested %d times; want once, by the prefetch", count)
+	}
+
+	for requestCount("/search3") == 0 { // page 3 is prefetched, but never more pages ahead
+		if time.Now().After(deadline) {
+			t.Fatal("third page was not prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data.URLQuery = server.URL + "/search?page=1&query=other"
+	data.PrefetchNext = false
+	go doAssetSearch(data, "search-3")
+	waitSearch("search-3")
+	select {
+	case <-page3Cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("prefetch of the third page was not cancelled by a new query")
+	}
+	if count := requestCount("/search4"); count != 0 {
+		t.Errorf("fourth page requested %d times; want none", count)
+	}
+}
@@ -0,0 +1,138 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SearchPageSize is the page size of searches built from SearchQuery without page_size.
+var SearchPageSize = 40
+
+// SearchQuery is the structured search for add-ons which do not build the search URL themselves.
+// Client builds URLQuery from it as the Blender add-on does in search.query_to_url.
+type SearchQuery struct {
+	Text      string `json:"text"`
+	AssetType string `json:"asset_type"`
+	Category  string `json:"category"`  // slug, searched with its subcategories
+	Order     string `json:"order"`     // e.g. "-created", defaults to the order of the Blender add-on
+	PageSize  int    `json:"page_size"` // SearchPageSize if 0
+	FreeOnly  bool   `json:"free_only"`
+	Engine    string `json:"engine"` // render engine of the scene, the server leaves out assets incompatible with it
+}
+
+// Render engines of SearchQuery.Engine.
+const (
+	SearchEngineCycles = "cycles"
+	SearchEngineEevee  = "eevee"
+)
+
+// normalizeSearchEngine returns the engine of SearchQuery.Engine, which accepts also the Blender identifiers
+// like CYCLES or BLENDER_EEVEE_NEXT. Empty engine stays empty, so the server does not filter by it.
+func normalizeSearchEngine(engine string) (string, error) {
+	switch strings.ToLower(engine) {
+	case "":
+		return "", nil
+	case SearchEngineCycles:
+		return SearchEngineCycles, nil
+	case SearchEngineEevee, "blender_eevee", "blender_eevee_next":
+		return SearchEngineEevee, nil
+	}
+	return "", &FieldError{Field: "query.engine", Message: fmt.Sprintf("must be %q or %q, got %q", SearchEngineCycles, SearchEngineEevee, engine)}
+}
+
+// searchQueryEscape escapes the value of the search parameter. Spaces are %20, because + separates the parameters.
+func searchQueryEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// defaultSearchOrder is the order of the Blender add-on: newest uploads for empty search,
+// best scored in a category and most relevant for keywords.
+func (q SearchQuery) defaultSearchOrder(category string) string {
+	switch {
+	case q.Text == "" && category == "":
+		return "-last_blend_upload"
+	case category != "":
+		return "-score,_score"
+	default:
+		return "_score"
+	}
+}
+
+// searchURL builds the search URL of https://www.blenderkit.com/api/v1/search/ for the query.
+func (q SearchQuery) searchURL(server, addonVersion, blenderVersion, sceneUUID string) string {
+	category := q.Category
+	if category == q.AssetType { // top-level category gives irrelevant results
+		category = ""
+	}
+	order := q.Order
+	if order == "" {
+		order = q.defaultSearchOrder(category)
+	}
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = SearchPageSize
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/api/v1/search/?query=%s", server, searchQueryEscape(q.Text))
+	fmt.Fprintf(&b, "+asset_type:%s", searchQueryEscape(q.AssetType))
+	if category != "" {
+		fmt.Fprintf(&b, "+category_subtree:%s", searchQueryEscape(category))
+	}
+	if q.FreeOnly {
+		b.WriteString("+is_free:true")
+	}
+	fmt.Fprintf(&b, "+order:%s", searchQueryEscape(order))
+	fmt.Fprintf(&b, "&dict_parameters=1&page_size=%d&addon_version=%s", pageSize, url.QueryEscape(addonVersion))
+	if blenderVersion != "" {
+		fmt.Fprintf(&b, "&blender_version=%s", url.QueryEscape(blenderVersion))
+	}
+	if q.Engine != "" {
+		fmt.Fprintf(&b, "&engine=%s", url.QueryEscape(q.Engine))
+	}
+	if sceneUUID != "" {
+		fmt.Fprintf(&b, "&scene_uuid=%s", url.QueryEscape(sceneUUID))
+	}
+	return b.String()
+}
+
+// resolveSearchURL sets URLQuery of the search from its Query, unless the add-on sent URLQuery built by itself.
+// Capabilities of the add-on (Blender version, render engine) are sent only with the structured Query,
+// URLQuery is passed to the server as it is.
+func resolveSearchURL(data *SearchTaskData) error {
+	if data.URLQuery != "" {
+		return nil
+	}
+	if data.Query == nil {
+		return &FieldError{Field: "urlquery", Message: "must not be empty, or query must be set"}
+	}
+	if err := validateNotEmpty("query.asset_type", data.Query.AssetType); err != nil {
+		return err
+	}
+	engine, err := normalizeSearchEngine(data.Query.Engine)
+	if err != nil {
+		return err
+	}
+	data.Query.Engine = engine
+	data.URLQuery = data.Query.searchURL(currentServer(), data.AddonVersion, data.BlenderVersion, data.SceneUUID)
+	return nil
+}
@@ -0,0 +1,138 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchQueryURL(t *testing.T) {
+	defer func(size int) { SearchPageSize = size }(SearchPageSize)
+	SearchPageSize = 40
+	const api = "https://www.blenderkit.com/api/v1/search/"
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  string
+	}{
+		{
+			name:  "empty search orders by last upload",
+			query: SearchQuery{AssetType: "model"},
+			want:  api + "?query=+asset_type:model+order:-last_blend_upload&dict_parameters=1&page_size=40&addon_version=3.12.0&blender_version=4.2.0",
+		},
+		{
+			name:  "keywords are escaped",
+			query: SearchQuery{Text: "wooden chair & table+", AssetType: "model", PageSize: 10},
+			want:  api + "?query=wooden%20chair%20%26%20table%2B+asset_type:model+order:_score&dict_parameters=1&page_size=10&addon_version=3.12.0&blender_version=4.2.0",
+		},
+		{
+			name:  "category orders by score",
+			query: SearchQuery{Text: "oak", AssetType: "material", Category: "wood", FreeOnly: true},
+			want:  api + "?query=oak+asset_type:material+category_subtree:wood+is_free:true+order:-score%2C_score&dict_parameters=1&page_size=40&addon_version=3.12.0&blender_version=4.2.0",
+		},
+		{
+			name:  "top-level category is dropped",
+			query: SearchQuery{AssetType: "hdr", Category: "hdr", Order: "-created"},
+			want:  api + "?query=+asset_type:hdr+order:-created&dict_parameters=1&page_size=40&addon_version=3.12.0&blender_version=4.2.0",
+		},
+		{
+			name:  "engine is sent for server-side filtering",
+			query: SearchQuery{AssetType: "material", Engine: SearchEngineEevee},
+			want:  api + "?query=+asset_type:material+order:-last_blend_upload&dict_parameters=1&page_size=40&addon_version=3.12.0&blender_version=4.2.0&engine=eevee",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.query.searchURL("https://www.blenderkit.com", "3.12.0", "4.2.0", "")
+			if got != tt.want {
+				t.Errorf("searchURL() =\n%s\nexpected\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSearchURL(t *testing.T) {
+	server := "https://www.blenderkit.com"
+	defer setServer(setServer(server))
+
+	raw := SearchTaskData{URLQuery: "https://www.blenderkit.com/api/v1/search/?query=chair"}
+	if err := resolveSearchURL(&raw); err != nil || raw.URLQuery != "https://www.blenderkit.com/api/v1/search/?query=chair" {
+		t.Errorf("raw urlquery changed to %q, error %v", raw.URLQuery, err)
+	}
+
+	structured := SearchTaskData{AddonVersion: "3.12.0", SceneUUID: "scene-1", Query: &SearchQuery{Text: "chair", AssetType: "model"}}
+	if err := resolveSearchURL(&structured); err != nil || !strings.HasSuffix(structured.URLQuery, "&addon_version=3.12.0&scene_uuid=scene-1") {
+		t.Errorf("built urlquery %q, error %v", structured.URLQuery, err)
+	}
+
+	for _, data := range []SearchTaskData{{}, {Query: &SearchQuery{Text: "chair"}}} {
+		if err := resolveSearchURL(&data); err == nil {
+			t.Errorf("resolveSearchURL(%+v) accepted search without urlquery or asset type", data)
+		}
+	}
+}
+
+func TestResolveSearchURLEngine(t *testing.T) {
+	server := "https://www.blenderkit.com"
+	defer setServer(setServer(server))
+
+	tests := []struct {
+		engine string
+		param  string
+		field  string
+	}{
+		{"", "", ""},
+		{"CYCLES", "&engine=cycles", ""},
+		{"BLENDER_EEVEE_NEXT", "&engine=eevee", ""},
+		{"BLENDER_WORKBENCH", "", "query.engine"},
+	}
+	for _, tt := range tests {
+		data := SearchTaskData{BlenderVersion: "4.2.0", Query: &SearchQuery{AssetType: "material", Engine: tt.engine}}
+		err := resolveSearchURL(&data)
+		field := ""
+		if fieldErr, ok := err.(*FieldError); ok {
+			field = fieldErr.Field
+		}
+		if field != tt.field {
+			t.Errorf("engine %q: error %v, expected error of field %q", tt.engine, err, tt.field)
+			continue
+		}
+		if err == nil && (!strings.HasSuffix(data.URLQuery, "&blender_version=4.2.0"+tt.param) || (tt.param == "" && strings.Contains(data.URLQuery, "engine="))) {
+			t.Errorf("engine %q: built urlquery %q", tt.engine, data.URLQuery)
+		}
+	}
+
+	raw := SearchTaskData{URLQuery: server + "/api/v1/search/?query=chair", BlenderVersion: "4.2.0", Query: &SearchQuery{Engine: "CYCLES"}}
+	if err := resolveSearchURL(&raw); err != nil || raw.URLQuery != server+"/api/v1/search/?query=chair" {
+		t.Errorf("raw urlquery changed to %q, error %v", raw.URLQuery, err)
+	}
+}
+
+func TestAssetSearchHandlerStructuredQuery(t *testing.T) {
+	rec := httptest.NewRecorder()
+	body := `{"app_id": 640, "query": {"text": "chair"}}`
+	assetSearchHandler(rec, httptest.NewRequest(http.MethodPost, "/blender/asset_search", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "query.asset_type") {
+		t.Errorf("got %d: %s, expected asset type to be required", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,241 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Limits of the reference image of the similarity search, checked before the upload.
+var (
+	SimilarSearchMaxImageSize    int64 = 10 * 1024 * 1024
+	SimilarSearchPollInterval          = time.Second
+	similarSearchSupportedFormat       = map[string]bool{"png": true, "jpeg": true}
+)
+
+// ErrCodeSimilarSearchUnavailable is the error code of the similar search on a server which does not provide it.
+const ErrCodeSimilarSearchUnavailable = "similar_search_unavailable"
+
+// similarSearchSupport caches whether the server provides the similarity search, by server.
+var similarSearchSupport sync.Map
+
+// SearchSimilarData is the search by reference image. Results are delivered as of the normal search, with its thumbnails.
+type SearchSimilarData struct {
+	SearchTaskData
+	ImagePath string `json:"image_path"`
+}
+
+// searchSimilarHandler handles /search_similar, the image is validated before the task is created.
+func searchSimilarHandler(w http.ResponseWriter, r *http.Request) {
+	var data SearchSimilarData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateNotEmpty("asset_type", data.AssetType), validateSimilarSearchImage(data.ImagePath), resolveSearchTempDir(&data.SearchTaskData)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	taskID := uuid.New().String()
+	go runTask(data.AppID, taskID, func() { doSearchSimilar(data, taskID) })
+	writeJSON(w, map[string]string{"task_id": taskID})
+}
+
+// validateSimilarSearchImage checks the reference image is PNG or JPEG within SimilarSearchMaxImageSize.
+func validateSimilarSearchImage(path string) error {
+	if err := validateNotEmpty("image_path", path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return &FieldError{Field: "image_path", Message: fmt.Sprintf("is not an existing file: %q", path)}
+	}
+	if info.Size() > SimilarSearchMaxImageSize {
+		return &FieldError{Field: "image_path", Message: fmt.Sprintf("has %.1fMB, max is %.1fMB", megabytes(info.Size()), megabytes(SimilarSearchMaxImageSize))}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return &FieldError{Field: "image_path", Message: err.Error()}
+	}
+	defer file.Close()
+	_, format, err := image.DecodeConfig(file)
+	if err != nil || !similarSearchSupportedFormat[format] {
+		return &FieldError{Field: "image_path", Message: fmt.Sprintf("is not a PNG or JPEG image: %q", path)}
+	}
+	return nil
+}
+
+// doSearchSimilar uploads the image to the similarity search and finishes the task as the normal search.
+// Task type is "search", so the add-on shows the results and their thumbnails without changes.
+func doSearchSimilar(data SearchSimilarData, taskUUID string) {
+	if data.RequestID == "" {
+		data.RequestID = taskUUID
+	}
+	task := NewTask(data.SearchTaskData, data.AppID, taskUUID, "search").WithRequestID(data.RequestID)
+	task.Message = "Searching similar assets"
+	sendTask(AddTaskCh, task)
+
+	searchResult, err := fetchSimilarSearch(task.Ctx, data, task)
+	if errors.Is(err, errSimilarSearchUnavailable) {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err, ErrorCode: ErrCodeSimilarSearchUnavailable})
+		return
+	}
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskUUID, Error: err, MessageDetailed: TraceDetail(err)})
+		return
+	}
+	finishSearch(data.SearchTaskData, taskUUID, searchResult, nil)
+}
+
+var errSimilarSearchUnavailable = errors.New("similar search is not available on the server")
+
+// fetchSimilarSearch uploads the image as multipart form to https://www.blenderkit.com/api/v1/search/similar/.
+// Results are either in the response, or the response is 202 Accepted with Location of the results, polled until ready.
+// The endpoint is not provided by all servers, so the image is uploaded only if checkSimilarSearchSupport finds it.
+func fetchSimilarSearch(ctx context.Context, data SearchSimilarData, task *Task) (SearchResults, error) {
+	ctx, cancel, client := withRequestTimeout(ctx, ClientUploads, data.TimeoutS)
+	defer cancel()
+	server := currentServer()
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("similar search - parsing server URL: %w", err)
+	}
+	meta := RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID}
+	if err := checkSimilarSearchSupport(ctx, client, server, meta, task); err != nil {
+		return SearchResults{}, err
+	}
+
+	fields, _ := json.Marshal(map[string]string{"asset_type": data.AssetType})
+	body, contentType, size, err := newMultipartBody(fields, []NonblockingRequestFile{{FieldName: "image", Filepath: data.ImagePath}})
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("similar search - reading image: %w", err)
+	}
+	defer body.Close()
+	req, err := http.NewRequestWithContext(ctx, "POST", server+"/api/v1/search/similar/", body)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("similar search - creating request: %w", err)
+	}
+	req.Header = getHeaders(meta)
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = size
+	trace := NewRequestTrace("similar search")
+	req = trace.Attach(req)
+
+	resp, err := doAPI(client, req)
+	if err != nil {
+		return SearchResults{}, trace.Wrap(fmt.Errorf("similar search - uploading image: %w", err))
+	}
+	defer resp.Body.Close()
+	trace.Finish()
+
+	for resp.StatusCode == http.StatusAccepted {
+		location, err := similarSearchLocation(resp, serverURL)
+		if err != nil {
+			return SearchResults{}, err
+		}
+		resp.Body.Close()
+		resp, err = pollSimilarSearch(ctx, client, location, meta, task)
+		if err != nil {
+			return SearchResults{}, err
+		}
+		defer resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		respJSON, respString, _ := ParseFailedHTTPResponse(resp)
+		return SearchResults{}, fmt.Errorf("similar search failed: %s (%s)", ResponseDetail(respJSON, respString), resp.Status)
+	}
+	if err := RespIsJSON(resp); err != nil {
+		return SearchResults{}, fmt.Errorf("similar search: %w", err)
+	}
+	searchResult, err := decodeSearchResults(resp.Body)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("similar search - decoding response: %w", err)
+	}
+	return searchResult, nil
+}
+
+// checkSimilarSearchSupport asks the server for the options of the similar search endpoint before the image is uploaded.
+// Only 404 Not Found means the server does not provide it, other answers are left to the upload. The answer is cached per server.
+func checkSimilarSearchSupport(ctx context.Context, client *http.Client, server string, meta RequestMeta, task *Task) error {
+	if supported, ok := similarSearchSupport.Load(server); ok {
+		if !supported.(bool) {
+			return errSimilarSearchUnavailable
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, server+"/api/v1/search/similar/", nil)
+	if err != nil {
+		return fmt.Errorf("similar search - creating options request: %w", err)
+	}
+	req.Header = getHeaders(meta)
+	resp, err := doIdempotent(client, req, task)
+	if err != nil {
+		return fmt.Errorf("similar search - checking the server: %w", err)
+	}
+	resp.Body.Close()
+	supported := resp.StatusCode != http.StatusNotFound
+	similarSearchSupport.Store(server, supported)
+	if !supported {
+		return errSimilarSearchUnavailable
+	}
+	return nil
+}
+
+// similarSearchLocation returns the results location of 202 Accepted, resolved against the request.
+// Polls carry the API key of the user, so a location on other scheme or host than the server is refused.
+func similarSearchLocation(resp *http.Response, server *url.URL) (*url.URL, error) {
+	location, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("similar search - accepted without results location: %w", err)
+	}
+	if location.Scheme != server.Scheme || location.Host != server.Host {
+		return nil, fmt.Errorf("similar search - results location %s is not on the server %s", location.Redacted(), server.Host)
+	}
+	return location, nil
+}
+
+// pollSimilarSearch waits SimilarSearchPollInterval and requests the results location, until the task is cancelled.
+func pollSimilarSearch(ctx context.Context, client *http.Client, location *url.URL, meta RequestMeta, task *Task) (*http.Response, error) {
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("similar search: %w", ctx.Err())
+	case <-time.After(SimilarSearchPollInterval):
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", location.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("similar search - creating poll request: %w", err)
+	}
+	req.Header = getHeaders(meta)
+	resp, err := doIdempotent(client, req, task)
+	if err != nil {
+		return nil, fmt.Errorf("similar search - polling results: %w", err)
+	}
+	return resp, nil
+}
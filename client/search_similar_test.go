@@ -0,0 +1,213 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateSimilarSearchImage(t *testing.T) {
+	defer func(size int64) { SimilarSearchMaxImageSize = size }(SimilarSearchMaxImageSize)
+	SimilarSearchMaxImageSize = 10 * 1024
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"png", writeTestImage(t, "reference.png", 16, 16, false), false},
+		{"jpeg", writeTestImage(t, "reference.jpg", 16, 16, false), false},
+		{"gif", writeTestImage(t, "reference.gif", 16, 16, false), true},
+		{"not an image", writeTestImage(t, "reference.txt", 0, 0, false), true},
+		{"too large", writeTestImage(t, "large.png", 256, 256, true), true},
+		{"missing", filepath.Join(t.TempDir(), "missing.png"), true},
+		{"empty path", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSimilarSearchImage(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSimilarSearchImage() = %v, expected error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSearchSimilar(t *testing.T) {
+	defer func(d time.Duration) { SimilarSearchPollInterval = d }(SimilarSearchPollInterval)
+	SimilarSearchPollInterval = 10 * time.Millisecond
+
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/search/similar/":
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Allow", "POST, OPTIONS")
+				return
+			}
+			if r.Method != "POST" || r.FormValue("asset_type") != "model" {
+				t.Errorf("got %s with asset_type %q", r.Method, r.FormValue("asset_type"))
+			}
+			if _, header, err := r.FormFile("image"); err != nil || header.Filename != "reference.png" {
+				t.Errorf("got image %v, error %v", header, err)
+			}
+			w.Header().Set("Location", "/api/v1/search/similar/results/1/")
+			w.WriteHeader(http.StatusAccepted)
+		case "/api/v1/search/similar/results/1/":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				w.Header().Set("Location", r.URL.Path)
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"count": 0, "results": []}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientUploads = c }(ClientUploads)
+	serverURL := server.URL
+	ClientUploads = server.Client()
+	defer setServer(setServer(serverURL))
+
+	data := SearchSimilarData{ImagePath: writeTestImage(t, "reference.png", 16, 16, false)}
+	data.AppID, data.AssetType, data.TempDir = 636, "model", t.TempDir()
+	go doSearchSimilar(data, "similar-search")
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case f := <-TaskFinishCh:
+			if f.AppID != 636 {
+				continue
+			}
+			if result, ok := f.Result.(SearchResults); !ok || result.Results == nil {
+				t.Errorf("got result %v", f.Result)
+			}
+			if atomic.LoadInt32(&polls) != 3 {
+				t.Errorf("results polled %d times, expected 3", polls)
+			}
+			return
+		case e := <-TaskErrorCh:
+			if e.AppID == 636 {
+				t.Fatalf("similar search failed: %v", e.Error)
+			}
+		case <-timeout:
+			t.Fatal("similar search did not finish")
+		}
+	}
+}
+
+func TestSearchSimilarCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/api/v1/search/similar/results/1/")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientUploads = c }(ClientUploads)
+	serverURL := server.URL
+	ClientUploads = server.Client()
+	defer setServer(setServer(serverURL))
+
+	data := SearchSimilarData{ImagePath: writeTestImage(t, "reference.png", 16, 16, false)}
+	data.AppID, data.AssetType = 636, "model"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	task := NewTask(data.SearchTaskData, data.AppID, "similar-search-cancel", "search")
+	_, err := fetchSimilarSearch(ctx, data, task)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetchSimilarSearch() = %v, expected cancellation", err)
+	}
+}
+
+func TestSearchSimilarForeignLocation(t *testing.T) {
+	var foreignRequests int32
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&foreignRequests, 1)
+	}))
+	defer foreign.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", foreign.URL+"/results/1/")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientUploads = c }(ClientUploads)
+	serverURL := server.URL
+	ClientUploads = server.Client()
+	defer setServer(setServer(serverURL))
+
+	data := SearchSimilarData{ImagePath: writeTestImage(t, "reference.png", 16, 16, false)}
+	data.AppID, data.AssetType, data.APIKey = 636, "model", "secret-key"
+	task := NewTask(data.SearchTaskData, data.AppID, "similar-search-foreign", "search")
+	_, err := fetchSimilarSearch(context.Background(), data, task)
+	if err == nil || !strings.Contains(err.Error(), "is not on the server") {
+		t.Errorf("fetchSimilarSearch() = %v, expected refused location", err)
+	}
+	if n := atomic.LoadInt32(&foreignRequests); n != 0 {
+		t.Errorf("other host got %d requests with the API key", n)
+	}
+}
+
+func TestSearchSimilarUnavailable(t *testing.T) {
+	var uploads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			atomic.AddInt32(&uploads, 1)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientUploads = c }(ClientUploads)
+	serverURL := server.URL
+	ClientUploads = server.Client()
+	defer setServer(setServer(serverURL))
+
+	data := SearchSimilarData{ImagePath: writeTestImage(t, "reference.png", 16, 16, false)}
+	data.AppID, data.AssetType, data.TempDir = 6361, "model", t.TempDir()
+	go doSearchSimilar(data, "similar-search-unavailable")
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-TaskErrorCh:
+			if e.AppID != 6361 {
+				continue
+			}
+			if e.ErrorCode != ErrCodeSimilarSearchUnavailable {
+				t.Errorf("error %v with code %q, expected %q", e.Error, e.ErrorCode, ErrCodeSimilarSearchUnavailable)
+			}
+			if n := atomic.LoadInt32(&uploads); n != 0 {
+				t.Errorf("image uploaded %d times to the server without similar search", n)
+			}
+			return
+		case <-timeout:
+			t.Fatal("similar search did not fail")
+		}
+	}
+}
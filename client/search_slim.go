@@ -0,0 +1,92 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import "fmt"
+
+// Projections of the search results returned to the add-on, SearchTaskData.ResultFields.
+const (
+	SearchResultFieldsFull = "full" // default, whole Asset as returned by the server
+	SearchResultFieldsSlim = "slim" // only what the add-on needs to show and download the asset, see slimAsset()
+)
+
+// slimDictParameters are the parameters the add-on reads, e.g. to place the model or show the texture resolution.
+var slimDictParameters = []string{
+	"boundBoxMinX", "boundBoxMinY", "boundBoxMinZ",
+	"boundBoxMaxX", "boundBoxMaxY", "boundBoxMaxZ",
+	"dimensionX", "dimensionY", "dimensionZ",
+	"faceCount", "textureResolutionMax",
+	"modelStyle", "materialStyle",
+}
+
+// validateResultFields checks the result_fields option of the search.
+func validateResultFields(value string) error {
+	if value == "" || value == SearchResultFieldsFull || value == SearchResultFieldsSlim {
+		return nil
+	}
+	return &FieldError{Field: "result_fields", Message: fmt.Sprintf("must be %q or %q", SearchResultFieldsFull, SearchResultFieldsSlim)}
+}
+
+// searchTaskResult is the result of the search task in the projection requested by the add-on.
+func searchTaskResult(searchResult SearchResults, data SearchTaskData) interface{} {
+	if data.ResultFields != SearchResultFieldsSlim {
+		return searchResult
+	}
+	blVer, _ := StringToBlenderVersion(data.BlenderVersion)
+	results := make([]map[string]interface{}, 0, len(searchResult.Results))
+	for _, asset := range searchResult.Results {
+		results = append(results, slimAsset(asset, blVer))
+	}
+	slim := map[string]interface{}{
+		"count":   searchResult.Count,
+		"facets":  searchResult.Facets,
+		"results": results,
+	}
+	if searchResult.NextURL != "" {
+		slim["next"] = searchResult.NextURL
+	}
+	if searchResult.PreviousURL != "" {
+		slim["previous"] = searchResult.PreviousURL
+	}
+	return slim
+}
+
+// slimAsset is the projection of the asset without the thumbnail variants and ratings, which make most of the full payload.
+// Thumbnail URLs are the two chosen by thumbnailURLs() for the Blender version, so they match the thumbnail tasks.
+func slimAsset(asset Asset, blVer *BlenderVersion) map[string]interface{} {
+	small, full, _ := thumbnailURLs(asset, blVer)
+	params := make(map[string]interface{})
+	for _, name := range slimDictParameters {
+		if value, ok := asset.DictParameters[name]; ok {
+			params[name] = value
+		}
+	}
+	return map[string]interface{}{
+		"id":                asset.ID,
+		"assetBaseId":       asset.AssetBaseID,
+		"name":              asset.Name,
+		"assetType":         asset.AssetType,
+		"author":            map[string]interface{}{"id": asset.Author.ID, "fullName": asset.Author.FullName},
+		"canDownload":       asset.CanDownload,
+		"files":             asset.Files,
+		"thumbnailSmallUrl": small,
+		"thumbnailFullUrl":  full,
+		"dictParameters":    params,
+	}
+}
@@ -0,0 +1,72 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlimSearchResult(t *testing.T) {
+	// Synthesized search response, see testdata/README.md.
+	raw, err := os.ReadFile(filepath.Join("testdata", "search_results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searchResult SearchResults
+	if err := json.Unmarshal(raw, &searchResult); err != nil {
+		t.Fatal(err)
+	}
+	data := SearchTaskData{BlenderVersion: "4.2.0"}
+
+	if _, ok := searchTaskResult(searchResult, data).(SearchResults); !ok {
+		t.Errorf("default result is not the full SearchResults")
+	}
+	data.ResultFields = SearchResultFieldsSlim
+	full, _ := json.Marshal(searchResult)
+	slimJSON, _ := json.Marshal(searchTaskResult(searchResult, data))
+	t.Logf("full %d bytes, slim %d bytes (%d%%)", len(full), len(slimJSON), 100*len(slimJSON)/len(full))
+	if len(slimJSON) >= len(full)/2 {
+		t.Errorf("slim result has %d bytes; want less than half of the full %d bytes", len(slimJSON), len(full))
+	}
+
+	var slim struct {
+		Count   int                      `json:"count"`
+		Next    string                   `json:"next"`
+		Results []map[string]interface{} `json:"results"`
+	}
+	if err := json.Unmarshal(slimJSON, &slim); err != nil {
+		t.Fatal(err)
+	}
+	if slim.Count != searchResult.Count || slim.Next != searchResult.NextURL || len(slim.Results) != len(searchResult.Results) {
+		t.Fatalf("slim result count=%d next=%q with %d results; want %d, %q, %d", slim.Count, slim.Next, len(slim.Results), searchResult.Count, searchResult.NextURL, len(searchResult.Results))
+	}
+	blVer, _ := StringToBlenderVersion(data.BlenderVersion)
+	for i, asset := range slim.Results {
+		small, full, _ := thumbnailURLs(searchResult.Results[i], blVer)
+		if asset["assetBaseId"] != searchResult.Results[i].AssetBaseID || asset["thumbnailSmallUrl"] != small || asset["thumbnailFullUrl"] != full {
+			t.Errorf("result %d = %v; want assetBaseId %s and thumbnails %s, %s", i, asset, searchResult.Results[i].AssetBaseID, small, full)
+		}
+		if _, ok := asset["ratingsAverage"]; ok {
+			t.Errorf("result %d has ratings", i)
+		}
+	}
+}
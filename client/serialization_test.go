@@ -0,0 +1,123 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/golden from the current serialization")
+
+// checkGolden compares JSON of v with testdata/golden/<name>.json, so renamed JSON tags which the add-on reads fail the test.
+// After an intended change of the add-on interface run: go test -run TestAddonSerialization -update
+func checkGolden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal %s: %v", name, err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, create it with -update: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("JSON of %s differs from %s, the add-on reads these names:\n%s", name, path, got)
+	}
+}
+
+func TestAddonSerialization(t *testing.T) {
+	var searchResults SearchResults
+	// Synthesized search response, see testdata/README.md.
+	raw, err := os.ReadFile(filepath.Join("testdata", "search_results.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &searchResults); err != nil {
+		t.Fatal(err)
+	}
+
+	thumbnailData := DownloadThumbnailData{
+		AddonVersion:    "3.14.0",
+		PlatformVersion: "4.2.0",
+		RequestID:       "search-1",
+		ThumbnailType:   "small",
+		ThumbnailSize:   "small",
+		ImagePath:       "/tmp/bkit_g/thumbnails/kitten.jpg",
+		ImageURL:        "https://public.blenderkit.com/thumbnails/assets/kitten.jpg",
+		AssetBaseID:     "0992088b-fb84-4c69-bb6e-426272970c8b",
+		AssetType:       "model",
+		Index:           3,
+		SearchTaskID:    "5a8bb6a2-8f5e-4d8c-a4a8-1f0c3d0b7e21",
+	}
+	downloadResult, err := assetDownloadResult(DownloadData{Layout: DownloadLayoutSingleDir, AssetsPath: "/project"},
+		[]string{"/project/addons/blenderkit_assets/kitten/kitten_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]interface{}{
+		"task": Task{
+			Data:            thumbnailData,
+			AppID:           1234,
+			TaskID:          "d5368c9d-092e-4319-afe1-dd765de6da01",
+			RequestID:       "search-1",
+			TaskType:        "thumbnail_download",
+			Message:         "Thumbnail downloaded",
+			MessageDetailed: "",
+			Progress:        100,
+			Status:          "finished",
+			Result:          thumbnailResult(thumbnailData),
+			ErrorCode:       "",
+		},
+		"search_results":      searchResults,
+		"search_results_slim": searchTaskResult(searchResults, SearchTaskData{BlenderVersion: "4.2.0", ResultFields: SearchResultFieldsSlim}),
+		"thumbnail_task_data": thumbnailData,
+		"thumbnail_result":    thumbnailResult(thumbnailData),
+		"download_result":     downloadResult,
+		"download_url":        DownloadURLResponse{CanDownload: true, DownloadURL: "https://www.blenderkit.com/files/kitten.blend", Filename: "kitten.blend", FileSize: 1234, Resolution: "resolution_2K"},
+		"login_result":        manualLoginResult("an-api-key", map[string]interface{}{"user": map[string]interface{}{"id": 1}}),
+		"asset_resolution":    AssetResolution{FileType: "resolution_2K", Label: "2K", FileSize: 2048, SizeLabel: "2.0 kB", Cached: true, FilePath: "/assets/kitten/kitten_2K.blend"},
+		"download_verify":     DownloadVerifyResult{Resolution: "resolution_2K", Filename: "kitten_2K.blend", AssetDirs: []string{"/assets/kitten"}, Diff: []AssetFileDiff{{Path: "textures/fur.png", Dir: "/assets/kitten", Issue: "missing", ExpectedSize: 10}}, InSync: false, Repaired: []string{}},
+		"temp_path_status":    TempPathStatus{Path: "/tmp/bktemp", Fallback: true, Reason: "no space", FreeSpace: 1024},
+		"software":            []Software{{AppID: 1234, AddonVersion: "3.14.0", PlatformVersion: "4.2.0"}},
+		"notifications_result": NotificationsResult{
+			NotificationData: NotificationData{Count: 1, Results: []Notification{{ID: 7, Actor: NotificationActor{PK: 42, ContentTypeModel: "user", String: "Jane"}, Verb: "commented", Unread: true}}},
+			ActorAvatars:     map[int]string{7: "/tmp/bktemp/bkit_g/42.jpg"},
+		},
+	}
+	for name, v := range tests {
+		t.Run(name, func(t *testing.T) { checkGolden(t, name, v) })
+	}
+}
@@ -0,0 +1,226 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrServerMaintenance is returned when the server responds with its maintenance page.
+var ErrServerMaintenance = errors.New("BlenderKit server is under maintenance")
+
+// Retrying of idempotent requests during server maintenance.
+var (
+	MaintenanceRetryDelay = 2 * time.Minute
+	MaintenanceMaxRetries = 5
+)
+
+var (
+	serverMaintenance    bool
+	serverMaintenanceMux sync.Mutex
+
+	serverStatusReady     = make(chan struct{}, 1) // signalled on change of serverMaintenance, handleChannels publishes it
+	serverStatusPublished bool                     // status last sent to the add-ons, used only by handleChannels
+)
+
+// maintenancePeekLimit is how much of a non-JSON response is read to recognize the maintenance page.
+const maintenancePeekLimit = 64 * 1024
+
+// isMaintenancePage reports whether the non-JSON response body is the maintenance page of the server.
+func isMaintenancePage(statusCode int, body string) bool {
+	if statusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	lower := strings.ToLower(body)
+	return strings.Contains(lower, "<html") && strings.Contains(lower, "maintenance")
+}
+
+// setServerMaintenance records whether the server is under maintenance. It is called from the transport of the requests,
+// so it never waits: the change is only signalled and handleChannels publishes it with publishServerStatus.
+func setServerMaintenance(maintenance bool) {
+	serverMaintenanceMux.Lock()
+	changed := serverMaintenance != maintenance
+	serverMaintenance = maintenance
+	serverMaintenanceMux.Unlock()
+	if !changed {
+		return
+	}
+	BKLog.Printf("%s %s", EmoNetwork, serverStatusMessage(maintenance))
+	select {
+	case serverStatusReady <- struct{}{}:
+	default: // already signalled, the current status is published
+	}
+}
+
+func serverStatusMessage(maintenance bool) string {
+	if maintenance {
+		return fmt.Sprintf("BlenderKit server is under maintenance, retrying in %s", humanDuration(MaintenanceRetryDelay))
+	}
+	return "BlenderKit server is available again"
+}
+
+// publishServerStatus adds server_status task to all connected add-ons, so they can show or clear the status.
+// Called by handleChannels, changes back and forth since the last publishing are not sent.
+func publishServerStatus() {
+	serverMaintenanceMux.Lock()
+	maintenance := serverMaintenance
+	serverMaintenanceMux.Unlock()
+	if maintenance == serverStatusPublished {
+		return
+	}
+	serverStatusPublished = maintenance
+
+	TasksMux.Lock()
+	appIDs := make([]int, 0, len(Tasks))
+	for appID := range Tasks {
+		appIDs = append(appIDs, appID)
+	}
+	TasksMux.Unlock()
+	for _, appID := range appIDs {
+		task := NewTask(nil, appID, uuid.New().String(), "server_status")
+		task.Message = serverStatusMessage(maintenance)
+		task.Status = "finished"
+		task.Result = map[string]interface{}{"maintenance": maintenance}
+		addTask(task)
+	}
+}
+
+// isMaintenanceResponse reports whether the response is the maintenance of the server: 503,
+// or the HTML maintenance page, whose beginning is read and put back into resp.Body.
+func isMaintenanceResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return false
+	}
+	peek, err := io.ReadAll(io.LimitReader(resp.Body, maintenancePeekLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+	return err == nil && isMaintenancePage(resp.StatusCode, string(peek))
+}
+
+// humanDuration formats durations like "2 minutes" for messages shown to users.
+func humanDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	if d >= time.Minute && d%time.Minute == 0 {
+		if d == time.Minute {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+	return d.String()
+}
+
+// serverStatusTransport watches the responses of the API client, it is the only place which detects maintenance:
+// 503 or the maintenance page switch the server status to maintenance, any other response which is not a server error clears it.
+// Requests with malformed API key are refused with *InvalidAPIKeyError without contacting the server.
+type serverStatusTransport struct {
+	base http.RoundTripper
+}
+
+func (t *serverStatusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkAuthorization(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if isMaintenanceResponse(resp) {
+		setServerMaintenance(true)
+	} else if resp.StatusCode < 500 {
+		setServerMaintenance(false)
+	}
+	return resp, nil
+}
+
+// doIdempotent performs a request which is safe to repeat (GET without body).
+// While the server is under maintenance, the request is repeated after MaintenanceRetryDelay instead of failing the task,
+// up to MaintenanceMaxRetries times. Waiting ends early if the request context is cancelled.
+func doIdempotent(client *http.Client, req *http.Request, task *Task) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := doAPI(client, req.Clone(req.Context()))
+		if err != nil {
+			return resp, checkClockSkew(err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || attempt >= MaintenanceMaxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		reportMaintenanceWait(task, MaintenanceRetryDelay)
+		select {
+		case <-time.After(MaintenanceRetryDelay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// reportMaintenanceWait informs the task that its request is repeated after the delay because of server maintenance.
+// The message is repeated more often than TaskAliveWindow until the retry, so reapStaleTasks does not take the waiting task as stuck.
+func reportMaintenanceWait(task *Task, delay time.Duration) {
+	update := func() {
+		sendTaskMessage(&TaskMessageUpdate{
+			AppID:   task.AppID,
+			TaskID:  task.TaskID,
+			Message: fmt.Sprintf("BlenderKit server is under maintenance, retrying in %s", humanDuration(delay)),
+		})
+	}
+	update()
+	var cancelled <-chan struct{}
+	if task.Ctx != nil {
+		cancelled = task.Ctx.Done()
+	}
+	interval := TaskAliveWindow / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		retry := time.After(delay)
+		for {
+			select {
+			case <-ticker.C:
+				update()
+			case <-retry:
+				return
+			case <-cancelled:
+				return
+			}
+		}
+	}()
+}
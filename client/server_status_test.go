@@ -0,0 +1,181 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsMaintenancePage(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		expected bool
+	}{
+		{http.StatusServiceUnavailable, "", true},
+		{http.StatusOK, "<html><body><h1>BlenderKit is under Maintenance</h1></body></html>", true},
+		{http.StatusBadGateway, "<html><body>Bad gateway</body></html>", false},
+		{http.StatusOK, `{"detail": "maintenance of asset"}`, false},
+	}
+	for _, test := range tests {
+		if actual := isMaintenancePage(test.status, test.body); actual != test.expected {
+			t.Errorf("isMaintenancePage(%d, %q) = %t; want %t", test.status, test.body, actual, test.expected)
+		}
+	}
+}
+
+func TestDoIdempotentRetriesDuringMaintenance(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("<html>Maintenance</html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	defer func(d time.Duration) { MaintenanceRetryDelay = d }(MaintenanceRetryDelay)
+	MaintenanceRetryDelay = 10 * time.Millisecond
+	client := &http.Client{Transport: &serverStatusTransport{base: http.DefaultTransport}}
+	task := NewTask(nil, 1, "task-1", "search")
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := doIdempotent(client, req, task)
+	if err != nil {
+		t.Fatalf("doIdempotent() error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("doIdempotent() = %d after %d calls; want 200 after 3 calls", resp.StatusCode, calls)
+	}
+	serverMaintenanceMux.Lock()
+	defer serverMaintenanceMux.Unlock()
+	if serverMaintenance {
+		t.Errorf("server status still maintenance after successful request")
+	}
+}
+
+// Maintenance page served with 200 is detected by the transport only, reading the response does not switch the status again.
+func TestServerStatusTransportMaintenancePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body><h1>BlenderKit is under Maintenance</h1></body></html>"))
+	}))
+	defer server.Close()
+	defer drainServerStatus()
+	defer setServerMaintenance(false)
+
+	client := &http.Client{Transport: &serverStatusTransport{base: http.DefaultTransport}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := RespIsJSON(resp); !errors.Is(err, ErrServerMaintenance) {
+		t.Errorf("RespIsJSON() = %v; want ErrServerMaintenance from the whole body", err)
+	}
+	serverMaintenanceMux.Lock()
+	defer serverMaintenanceMux.Unlock()
+	if !serverMaintenance {
+		t.Error("maintenance page did not switch the server status")
+	}
+}
+
+// drainServerStatus takes the signal of setServerMaintenance which no task loop handles in the tests.
+func drainServerStatus() {
+	select {
+	case <-serverStatusReady:
+	default:
+	}
+}
+
+// Requests which see the change of the status must not wait for the task loop, which may itself wait for them.
+func TestServerStatusPublishing(t *testing.T) {
+	defer func(published bool) { serverStatusPublished = published }(serverStatusPublished)
+	serverStatusPublished = false
+	setServerMaintenance(false)
+	drainServerStatus()
+	TasksMux.Lock()
+	Tasks[6281] = map[string]*Task{}
+	changed := make(chan struct{})
+	go func() {
+		setServerMaintenance(true)
+		setServerMaintenance(false)
+		setServerMaintenance(true)
+		close(changed)
+	}()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("setServerMaintenance waits for TasksMux")
+	}
+	TasksMux.Unlock()
+	defer func() {
+		setServerMaintenance(false)
+		drainServerStatus()
+		TasksMux.Lock()
+		delete(Tasks, 6281)
+		TasksMux.Unlock()
+	}()
+
+	select {
+	case <-serverStatusReady:
+	default:
+		t.Error("change of the status was not signalled to the task loop")
+	}
+	publishServerStatus()
+	publishServerStatus() // nothing changed since
+	TasksMux.Lock()
+	defer TasksMux.Unlock()
+	if len(Tasks[6281]) != 1 {
+		t.Fatalf("%d server_status tasks; want one with the current status", len(Tasks[6281]))
+	}
+	for _, task := range Tasks[6281] {
+		if task.TaskType != "server_status" || task.Result.(map[string]interface{})["maintenance"] != true {
+			t.Errorf("task %s with %v; want server_status under maintenance", task.TaskType, task.Result)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{time.Minute, "1 minute"},
+		{5 * time.Minute, "5 minutes"},
+		{time.Hour, "1 hour"},
+		{6 * time.Hour, "6 hours"},
+		{90 * time.Minute, "90 minutes"},
+		{30 * time.Second, "30s"},
+	}
+	for _, test := range tests {
+		if actual := humanDuration(test.input); actual != test.expected {
+			t.Errorf("humanDuration(%v) = %q; want %q", test.input, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// activeServer is the server the requests go to, set from -server flag and by SwitchServer. Read it with currentServer().
+var activeServer atomic.Pointer[string]
+
+// currentServer returns the server the requests go to. Tasks read it once at start and build all their URLs from it,
+// so a task running during SwitchServer finishes against the server it started with.
+func currentServer() string {
+	if server := activeServer.Load(); server != nil {
+		return *server
+	}
+	return ""
+}
+
+// setServer sets the server of the following requests and returns the previous one.
+func setServer(server string) string {
+	if previous := activeServer.Swap(&server); previous != nil {
+		return *previous
+	}
+	return ""
+}
+
+// ServerCheckTimeout bounds the check that the new server is reachable before switching to it.
+var ServerCheckTimeout = 10 * time.Second
+
+type SetServerData struct {
+	AppID  int    `json:"app_id"`
+	Server string `json:"server"` // e.g. https://staging.blenderkit.com
+}
+
+// normalizeServerURL returns the server URL without trailing slash, only https URLs of the server root are accepted.
+func normalizeServerURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", &FieldError{Field: "server", Message: fmt.Sprintf("must be https URL, got %q", rawURL)}
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", &FieldError{Field: "server", Message: fmt.Sprintf("must be URL of the server root, got %q", rawURL)}
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// checkServerAPI verifies the server answers with JSON on /api/v1/, so the add-on does not switch to a typo.
+func checkServerAPI(ctx context.Context, server string) error {
+	ctx, cancel := context.WithTimeout(ctx, ServerCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server+"/api/v1/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header = getHeaders(RequestMeta{})
+	resp, err := ClientAPI.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s/api/v1/ responded with %s", server, resp.Status)
+	}
+	return RespIsJSON(resp)
+}
+
+// SetServerHandler switches the Client to another BlenderKit-compatible server, e.g. staging or enterprise instance,
+// without restarting Blender. Only the add-on may call it, web pages in the browser are rejected by allowSettingsRequest.
+func SetServerHandler(w http.ResponseWriter, r *http.Request) {
+	var data SetServerData
+	if !allowSettingsRequest(w, r) || !decodeJSONBody(w, r, &data) {
+		return
+	}
+	server, err := normalizeServerURL(data.Server)
+	if err = firstError(validateAppID(data.AppID), err); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := checkServerAPI(r.Context(), server); err != nil {
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, fmt.Sprintf("server %s is not reachable: %v", server, err))
+		return
+	}
+
+	SwitchServer(server)
+	writeJSON(w, map[string]string{"server": server})
+}
+
+// SwitchServer sets the server of the following requests and informs all connected add-ons with server_changed task.
+// Tasks in flight read the server at their start and finish against the previous server. Caches of the previous server
+// are cleared and its login state is dropped, the tokens are not valid on the new server.
+func SwitchServer(server string) {
+	previous := setServer(server)
+	if server == previous {
+		return
+	}
+	BKLog.Printf("%s Switching server from %s to %s", EmoNetwork, previous, server)
+
+	ResponseCache.Clear()
+	cancelSearchPrefetches()
+	forgetSearchFlights()
+	AuthorProfiles.Clear()
+	forgetLoginState()
+	setServerMaintenance(false)
+
+	TasksMux.Lock()
+	appIDs := make([]int, 0, len(Tasks))
+	for appID := range Tasks {
+		appIDs = append(appIDs, appID)
+	}
+	TasksMux.Unlock()
+	for _, appID := range appIDs {
+		task := NewTask(nil, appID, uuid.New().String(), "server_changed")
+		task.Message = fmt.Sprintf("Server changed to %s, please log in again", server)
+		task.Status = "finished"
+		task.Result = map[string]interface{}{"server": server, "previous_server": previous}
+		sendTask(AddTaskCh, task)
+	}
+}
@@ -18,7 +18,13 @@
 
 package main
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // MinimalTaskData is minimal data needed from add-on to schedule a task.
 type MinimalTaskData struct {
@@ -26,6 +32,7 @@ type MinimalTaskData struct {
 	APIKey          string `json:"api_key"` // Can be empty for non-logged users
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"` // Optional, defaults to the task ID; sent to the server as X-Request-ID
 }
 
 // TaskStatusUpdate is a struct for updating the status of a task through a channel.
@@ -36,6 +43,8 @@ type TaskProgressUpdate struct {
 	Progress        int
 	Message         string
 	MessageDetailed string
+	SpeedBPS        float64 // Optional transfer speed in bytes per second, 0 when unknown or not a transfer
+	ETASeconds      int     // Optional estimate of seconds left, set together with SpeedBPS
 }
 
 // TaskMessageUpdate is a struct for updating the message of a task through a channel.
@@ -54,6 +63,7 @@ type TaskError struct {
 	Error           error
 	Result          interface{}
 	MessageDetailed string
+	ErrorCode       string // Optional machine readable reason, e.g. ErrCodeLoginRequired
 }
 
 // TaskProgressUpdate is a struct for updating the progress of a task through a channel.
@@ -65,6 +75,13 @@ type TaskFinish struct {
 	Result          interface{}
 }
 
+// TaskQueued is a struct for reporting that a task waits in the offline queue.
+type TaskQueued struct {
+	AppID   int
+	TaskID  string
+	Message string
+}
+
 type TaskCancel struct {
 	AppID  int
 	TaskID string
@@ -74,18 +91,26 @@ type TaskCancel struct {
 // Task is a struct for storing a task in this Client application.
 // Exported fields are used for JSON encoding/decoding and are defined in same in the add-on.
 type Task struct {
-	Data            interface{}        `json:"data"`             // Data for the task, should be a struct like DownloadData, SearchData, etc.
-	AppID           int                `json:"app_id"`           // PID of the Blender running the add-on
-	TaskID          string             `json:"task_id"`          // random UUID for the task
-	TaskType        string             `json:"task_type"`        // search, download, etc.
-	Message         string             `json:"message"`          // Short message for the user
-	MessageDetailed string             `json:"message_detailed"` // Longer message to the console
-	Progress        int                `json:"progress"`         // 0-100
-	Status          string             `json:"status"`           // created, finished, error
-	Result          interface{}        `json:"result"`           // Result to be used by the add-on
-	Error           error              `json:"-"`                // Internal: error in the task, not to be sent to the add-on
-	Ctx             context.Context    `json:"-"`                // Internal: Context for canceling the task, use in long running functions which support it
-	Cancel          context.CancelFunc `json:"-"`                // Internal: Function for canceling the task
+	Data            interface{}        `json:"data"`                // Data for the task, should be a struct like DownloadData, SearchData, etc.
+	AppID           int                `json:"app_id"`              // PID of the Blender running the add-on
+	TaskID          string             `json:"task_id"`             // random UUID for the task
+	RequestID       string             `json:"request_id"`          // ID of the originating add-on call, defaults to TaskID
+	TaskType        string             `json:"task_type"`           // search, download, etc.
+	Message         string             `json:"message"`             // Short message for the user
+	MessageDetailed string             `json:"message_detailed"`    // Longer message to the console
+	Progress        int                `json:"progress"`            // 0-100
+	SpeedBPS        float64            `json:"speed_bps,omitempty"` // Transfer speed of downloads and uploads in bytes per second
+	ETASeconds      int                `json:"eta_s,omitempty"`     // Estimated seconds until the transfer is done
+	Status          string             `json:"status"`              // created, finished, error
+	Result          interface{}        `json:"result"`              // Result to be used by the add-on
+	ErrorCode       string             `json:"error_code"`          // Machine readable reason of the error, so the add-on can offer the right action
+	Created         time.Time          `json:"-"`                   // Internal: when the task was created, shown as age in /tasks
+	Updated         time.Time          `json:"-"`                   // Internal: last change of progress or message, tasks without recent updates can be reaped
+	Completed       time.Time          `json:"-"`                   // Internal: when the task finished or failed, oldest completed tasks are dropped first
+	ResultBytes     int                `json:"-"`                   // Internal: estimated size of the result, limits results kept and reported
+	Error           error              `json:"-"`                   // Internal: error in the task, not to be sent to the add-on
+	Ctx             context.Context    `json:"-"`                   // Internal: Context for canceling the task, use in long running functions which support it
+	Cancel          context.CancelFunc `json:"-"`                   // Internal: Function for canceling the task
 }
 
 // SocialNetworkDetails stores details about a social network.
@@ -110,11 +135,59 @@ type Author struct {
 	FirstName      string          `json:"firstName"`
 	FullName       string          `json:"fullName"`
 	GravatarHash   string          `json:"gravatarHash"`
-	ID             int             `json:"id"`
+	ID             JSONNumber      `json:"id"`
 	LastName       string          `json:"lastName"`
 	SocialNetworks []SocialNetwork `json:"socialNetworks"`
 }
 
+// JSONNumber is a number from the API which decodes also from numeric string and null.
+// Used for the fields of Asset which changed their type in the API before, so one odd value does not fail the whole search.
+// Empty JSONNumber is encoded as 0, as the add-on expects a number.
+type JSONNumber json.Number
+
+func (n *JSONNumber) UnmarshalJSON(data []byte) error {
+	value := strings.TrimSpace(string(data))
+	if value == "null" {
+		*n = ""
+		return nil
+	}
+	if strings.HasPrefix(value, `"`) {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			*n = ""
+			return nil
+		}
+	}
+	var number float64
+	if err := json.Unmarshal([]byte(value), &number); err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*n = JSONNumber(value)
+	return nil
+}
+
+func (n JSONNumber) MarshalJSON() ([]byte, error) {
+	if n == "" {
+		return []byte("0"), nil
+	}
+	return []byte(n), nil
+}
+
+// Int64 returns the number as integer, 0 for empty or non-integer number.
+func (n JSONNumber) Int64() int64 {
+	i, _ := json.Number(n).Int64()
+	return i
+}
+
+// Float64 returns the number as float, 0 for empty number.
+func (n JSONNumber) Float64() float64 {
+	f, _ := json.Number(n).Float64()
+	return f
+}
+
 // Asset is a struct for storing an asset in this Client application.
 // Represents a single asset returned from the search API at: https://www.blenderkit.com/api/v1/search/.
 type Asset struct {
@@ -131,7 +204,7 @@ type Asset struct {
 	DictParameters                   map[string]interface{} `json:"dictParameters"`
 	DisplayName                      string                 `json:"displayName"`
 	Files                            []AssetFile            `json:"files"`
-	FilesSize                        float64                `json:"filesSize"`
+	FilesSize                        JSONNumber             `json:"filesSize"`
 	ID                               string                 `json:"id"`
 	IsFree                           bool                   `json:"isFree"`
 	IsPrivate                        bool                   `json:"isPrivate"`
@@ -144,12 +217,12 @@ type Asset struct {
 	LastZipFileUpload                string                 `json:"lastZipFileUpload"`
 	License                          string                 `json:"license"`
 	Name                             string                 `json:"name"`
-	PK                               int                    `json:"pk"`
+	PK                               JSONNumber             `json:"pk"`
 	RatingsAverage                   map[string]interface{} `json:"ratingsAverage"`
 	RatingsCount                     map[string]interface{} `json:"ratingsCount"`
 	RatingsMedian                    map[string]interface{} `json:"ratingsMedian"`
 	RatingsSum                       map[string]interface{} `json:"ratingsSum"`
-	Score                            float64                `json:"score"`
+	Score                            JSONNumber             `json:"score"`
 	ShowMarketingLabels              bool                   `json:"showMarketingLabels"`
 	SourceAppName                    string                 `json:"sourceAppName"`
 	SourceAppVersion                 string                 `json:"sourceAppVersion"`
@@ -172,8 +245,8 @@ type Asset struct {
 	ThumbnailXlargeURLWebp           string                 `json:"thumbnailXlargeUrlWebp"`
 	URL                              string                 `json:"url"`
 	VerificationStatus               string                 `json:"verificationStatus"`
-	VersionNumber                    int                    `json:"versionNumber"`
-	WebpGeneratedTimestamp           float64                `json:"webpGeneratedTimestamp"`
+	VersionNumber                    JSONNumber             `json:"versionNumber"`
+	WebpGeneratedTimestamp           JSONNumber             `json:"webpGeneratedTimestamp"`
 }
 
 // SearchResults is a struct for storing search results from https://www.blenderkit.com/api/v1/search/.
@@ -203,13 +276,14 @@ type PREFS struct {
 // AssetFile represents a file in an asset.
 // Used in Download (downloading .blend file) and Search (downloading thumbnails).
 type AssetFile struct {
-	Created            string `json:"created"`
-	DownloadURL        string `json:"downloadUrl"`
-	FileThumbnail      string `json:"fileThumbnail"`
-	FileThumbnailLarge string `json:"fileThumbnailLarge"`
-	FileType           string `json:"fileType"`
-	Modified           string `json:"modified"`
-	Resolution         int    `json:"resolution"` // null for asset (resolution) files, thumbnails, but is integer for videos
+	Created            string     `json:"created"`
+	DownloadURL        string     `json:"downloadUrl"`
+	FileThumbnail      string     `json:"fileThumbnail"`
+	FileThumbnailLarge string     `json:"fileThumbnailLarge"`
+	FileType           string     `json:"fileType"`
+	Modified           string     `json:"modified"`
+	Resolution         JSONNumber `json:"resolution"` // null for asset (resolution) files, thumbnails, but is integer for videos
+	FileSize           JSONNumber `json:"fileSize"`   // bytes, empty if the API does not provide it
 }
 
 type DownloadAssetData struct {
@@ -224,12 +298,28 @@ type DownloadAssetData struct {
 type DownloadData struct {
 	AddonVersion      string   `json:"addon_version"`
 	PlatformVersion   string   `json:"platform_version"`
+	RequestID         string   `json:"request_id"`
 	AppID             int      `json:"app_id"`
 	DownloadDirs      []string `json:"download_dirs"`
+	TimeoutS          float64  `json:"timeout_s"`   // Optional timeout of the file download, replaces the client-wide timeout
+	Priority          string   `json:"priority"`    // DownloadPriorityInteractive (default) or DownloadPriorityBackground
+	Layout            string   `json:"layout"`      // DownloadLayoutBlenderDual (default) or DownloadLayoutSingleDir
+	AssetsPath        string   `json:"assets_path"` // Optional root of the relative_path in the result of DownloadLayoutSingleDir, e.g. the Godot project dir
 	DownloadAssetData `json:"asset_data"`
 	PREFS             `json:"PREFS"`
 }
 
+// DownloadURLResponse is returned by /wrappers/get_download_url.
+type DownloadURLResponse struct {
+	CanDownload bool   `json:"can_download"`
+	Reason      string `json:"reason,omitempty"`     // Why the file cannot be downloaded, e.g. "User is anonymous"
+	ErrorCode   string `json:"error_code,omitempty"` // ErrCodeLoginRequired, ErrCodePlanRequired, ErrCodePrivateAsset or ErrCodeDownloadDenied
+	DownloadURL string `json:"download_url"`
+	Filename    string `json:"filename"`
+	FileSize    int64  `json:"file_size"`  // fileSize of the selected file from the asset data, 0 if unknown
+	Resolution  string `json:"resolution"` // file type of the selected file, e.g. "resolution_2K" or "blend"
+}
+
 type Category struct {
 	Name                 string     `json:"name"`
 	Slug                 string     `json:"slug"`
@@ -248,6 +338,21 @@ type Category struct {
 	AssetCountCumulative int        `json:"assetCountCumulative"`
 }
 
+// CategoryIndexEntry is a category in the flat index of categories_update result, so lookups by slug need no tree walk.
+type CategoryIndexEntry struct {
+	Name                 string   `json:"name"`
+	Path                 string   `json:"path"`                 // slugs from the top-level category, e.g. "model/furniture/chairs"
+	AssetCount           int      `json:"assetCount"`           // assets directly in the category
+	AssetCountCumulative int      `json:"assetCountCumulative"` // assets in the category and all its descendants
+	Children             []string `json:"children"`             // slugs of direct children
+}
+
+// CategoriesResult is the result of categories_update task: the category tree and its index by slug.
+type CategoriesResult struct {
+	Categories []Category                    `json:"categories"`
+	Index      map[string]CategoryIndexEntry `json:"index"`
+}
+
 // CategoriesData is a struct for storing the response from the server when fetching https://www.blenderkit.com/api/v1/categories/
 type CategoriesData struct {
 	Count   int        `json:"count"`
@@ -279,6 +384,14 @@ type UploadFile struct {
 	FilePath string
 }
 
+// UploadFileTaskData is the data of asset_upload_file task, which reports upload of one file of the asset_upload task.
+type UploadFileTaskData struct {
+	ParentTaskID string `json:"parent_task_id"` // TaskID of the asset_upload task
+	FileType     string `json:"file_type"`      // blend, thumbnail, resolution_2K, etc.
+	FilePath     string `json:"file_path"`
+	FileIndex    int    `json:"file_index"`
+}
+
 type AssetParameterData struct {
 	Parametertype string `json:"parameterType"`
 	Value         string `json:"value"`
@@ -358,16 +471,21 @@ type AssetUploadData struct {
 // AssetUploadTaskData is expected from the add-on.
 type AssetUploadRequestData struct {
 	AppID       int                   `json:"app_id"`
+	RequestID   string                `json:"request_id"`
 	Preferences PREFS                 `json:"PREFS"`
 	UploadData  AssetUploadData       `json:"upload_data"`
 	ExportData  AssetUploadExportData `json:"export_data"`
 	UploadSet   []string              `json:"upload_set"`
+
+	ConfirmUploadAboveS int  `json:"confirm_upload_above_s"` // upload estimated longer than this is flagged in UploadEstimate, 0 disables
+	DryRun              bool `json:"dry_run"`                // only validate and pack, see doDryRunUpload
 }
 
 // MarkNotificationReadTaskData is expected from the add-on.
 type MarkNotificationReadTaskData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	Notification    int    `json:"notification_id"`
@@ -377,6 +495,7 @@ type MarkNotificationReadTaskData struct {
 type MarkCommentPrivateTaskData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	AssetID         string `json:"asset_id"`
@@ -393,6 +512,7 @@ type MarkCommentPrivateData struct {
 type FeedbackCommentTaskData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	AssetID         string `json:"asset_id"`
@@ -432,6 +552,7 @@ type CommentPostData struct {
 type CreateCommentData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	AssetID         string `json:"asset_id"`
@@ -442,14 +563,19 @@ type CreateCommentData struct {
 type GetCommentsData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	AssetID         string `json:"asset_id"`
+	Page            int    `json:"page"`      // first page if 0
+	PageSize        int    `json:"page_size"` // CommentsPageSize if 0
+	Since           string `json:"since"`     // RFC 3339, only comments newer than this, e.g. polled_at of the previous result
 }
 
 type SendRatingData struct {
 	AddonVersion    string  `json:"addon_version"`
 	PlatformVersion string  `json:"platform_version"`
+	RequestID       string  `json:"request_id"`
 	AppID           int     `json:"app_id"`
 	APIKey          string  `json:"api_key"`
 	AssetID         string  `json:"asset_id"`
@@ -460,6 +586,7 @@ type SendRatingData struct {
 type FetchGravatarData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	ID              int    `json:"id"`
 	Avatar128       string `json:"avatar128"` //e.g.: "/avatar-redirect/ad7c20a8-98ca-4128-9189-f727b2d1e4f3/128/"
@@ -474,6 +601,7 @@ type CancelDownloadData struct {
 type GetRatingData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	AppID           int    `json:"app_id"`
 	APIKey          string `json:"api_key"`
 	AssetID         string `json:"asset_id"`
@@ -544,6 +672,8 @@ type Disclaimer struct {
 	Message   string `json:"message"`
 	URL       string `json:"url"`
 	Slug      string `json:"slug"`
+	// Set by Client: seconds until ValidTo, 0 if the disclaimer has no end, so the add-on can schedule re-display.
+	RemainingS int64 `json:"remaining_s,omitempty"`
 }
 
 type DisclaimerData struct {
@@ -556,27 +686,37 @@ type DisclaimerData struct {
 type DownloadThumbnailData struct {
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	ThumbnailType   string `json:"thumbnail_type"`
+	ThumbnailSize   string `json:"thumbnail_size"` // small, middle, large_nonsquared or small_nonsquared
 	ImagePath       string `json:"image_path"`
 	ImageURL        string `json:"image_url"`
 	AssetBaseID     string `json:"assetBaseId"`
+	AssetType       string `json:"asset_type"`
 	Index           int    `json:"index"`
+	SearchTaskID    string `json:"search_task_id"` // search which results the thumbnail belongs to
 }
 
 type SearchTaskData struct {
 	PREFS           `json:"PREFS"`
-	AddonVersion    string `json:"addon_version"`
-	PlatformVersion string `json:"platform_version"`
-	APIKey          string `json:"api_key"`
-	AppID           int    `json:"app_id"`
-	AssetType       string `json:"asset_type"`
-	BlenderVersion  string `json:"blender_version"`
-	GetNext         bool   `json:"get_next"`
-	NextURL         string `json:"next"`
-	PageSize        int    `json:"page_size"`
-	SceneUUID       string `json:"scene_uuid"`
-	TempDir         string `json:"tempdir"`
-	URLQuery        string `json:"urlquery"`
+	AddonVersion    string       `json:"addon_version"`
+	PlatformVersion string       `json:"platform_version"`
+	RequestID       string       `json:"request_id"`
+	APIKey          string       `json:"api_key"`
+	AppID           int          `json:"app_id"`
+	AssetType       string       `json:"asset_type"`
+	BlenderVersion  string       `json:"blender_version"`
+	GetNext         bool         `json:"get_next"`
+	NextURL         string       `json:"next"`
+	PageSize        int          `json:"page_size"`
+	SceneUUID       string       `json:"scene_uuid"`
+	TempDir         string       `json:"tempdir"`
+	URLQuery        string       `json:"urlquery"`
+	Query           *SearchQuery `json:"query"`          // Structured search from which URLQuery is built if it is empty
+	TimeoutS        float64      `json:"timeout_s"`      // Optional timeout of the search request, replaces the client-wide timeout
+	PrefetchNext    bool         `json:"prefetch_next"`  // Fetch the next page in background, so scrolling to it is instant
+	ResultFields    string       `json:"result_fields"`  // SearchResultFieldsFull (default) or SearchResultFieldsSlim
+	SkipGravatars   bool         `json:"skip_gravatars"` // Do not download avatars of the authors, for clients which do not show them
 }
 
 type ReportData struct {
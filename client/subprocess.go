@@ -0,0 +1,161 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SubprocessError is returned when background Blender running one of the add-on scripts fails.
+// Error() gives short summary for the add-on UI, the full output is in Output and in the log file at LogPath.
+type SubprocessError struct {
+	Script   string // e.g. upload_bg.py
+	ExitCode int    // -1 if the process did not exit normally
+	Summary  string // final exception line with file and line, or known Blender error
+	Output   string
+	LogPath  string // empty if the log could not be saved
+	Err      error
+}
+
+func (e *SubprocessError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Script, e.Summary)
+}
+
+func (e *SubprocessError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	tracebackFrameRegex = regexp.MustCompile(`^\s+File "(.+)", line (\d+)`)
+	// Line which upload_bg.py prints for the exception it catches before sys.exit(1), there is no traceback then.
+	scriptExceptionRegex = regexp.MustCompile(`^Exception <class '([\w.]+)'> in (\w+\.py): (.*)$`)
+	// Lines Blender prints on errors which are not Python exceptions.
+	blenderErrorMarkers = []string{
+		"Error: ",
+		"Segmentation fault",
+		"EXCEPTION_ACCESS_VIOLATION",
+		"Writing: ", // path of the crash log
+		"Error in ",
+		"Killed",
+	}
+)
+
+// parseSubprocessOutput finds the cause of failure in the output of background Blender.
+// Exception caught and printed by the script before exiting wins, it is the failure even when Blender printed
+// tracebacks of other add-ons before. Otherwise the last Python traceback wins, its exception line is returned
+// together with the file and line of its last frame. Without traceback the last line with a known Blender error marker
+// is returned. Empty string if nothing is found.
+func parseSubprocessOutput(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := scriptExceptionRegex.FindStringSubmatch(strings.TrimSpace(lines[i])); m != nil {
+			return fmt.Sprintf("%s: %s (%s)", m[1], m[3], m[2])
+		}
+	}
+
+	start := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "Traceback (most recent call last):") {
+			start = i
+			break
+		}
+	}
+
+	if start >= 0 {
+		var location, exception string
+		for _, line := range lines[start+1:] {
+			if m := tracebackFrameRegex.FindStringSubmatch(line); m != nil {
+				location = fmt.Sprintf("%s:%s", filepath.Base(strings.ReplaceAll(m[1], `\`, "/")), m[2])
+				continue
+			}
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				continue // source lines of frames and carets
+			}
+			exception = strings.TrimSpace(line)
+			break
+		}
+		if exception != "" {
+			if location != "" {
+				return fmt.Sprintf("%s (%s)", exception, location)
+			}
+			return exception
+		}
+	}
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		for _, marker := range blenderErrorMarkers {
+			if strings.HasPrefix(line, marker) {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// runBlenderScript runs the background Blender command and returns its combined output.
+// On failure the output is parsed and saved into a log file in the safe temp path, returned error is *SubprocessError.
+func runBlenderScript(cmd *exec.Cmd, script string) ([]byte, error) {
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return out, nil
+	}
+
+	subErr := &SubprocessError{Script: script, ExitCode: -1, Output: string(out), Err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		subErr.ExitCode = exitErr.ExitCode()
+	}
+	subErr.Summary = parseSubprocessOutput(subErr.Output)
+	if subErr.Summary == "" {
+		subErr.Summary = fmt.Sprintf("command exited with code %d", subErr.ExitCode)
+		if subErr.ExitCode == -1 {
+			subErr.Summary = fmt.Sprintf("command execution failed: %v", err)
+		}
+	}
+
+	if tempDir, e := GetSafeTempPath(); e == nil {
+		logPath := filepath.Join(tempDir, fmt.Sprintf("%s_%s.log", strings.TrimSuffix(script, ".py"), time.Now().Format("20060102-150405")))
+		if e := os.WriteFile(logPath, out, 0600); e == nil {
+			subErr.LogPath = logPath
+		} else {
+			BKLog.Printf("%s Failed to save %s output: %v", EmoWarning, script, e)
+		}
+	}
+	return out, subErr
+}
+
+// subprocessTaskError creates TaskError, which for failed background Blender carries the full output
+// in MessageDetailed and path to the saved log in the result.
+func subprocessTaskError(appID int, taskID string, err error) *TaskError {
+	taskErr := &TaskError{AppID: appID, TaskID: taskID, Error: err}
+	var subErr *SubprocessError
+	if errors.As(err, &subErr) {
+		taskErr.MessageDetailed = subErr.Output
+		taskErr.Result = map[string]interface{}{"log_path": subErr.LogPath, "exit_code": subErr.ExitCode}
+	}
+	return taskErr
+}
@@ -0,0 +1,141 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Outputs of background Blender below are synthesized, not captured from a real run. They follow the format of
+// Python tracebacks, of Blender's own messages and of the line upload_bg.py prints for the exception it catches.
+
+// upload_bg.py catches the exception and prints it before sys.exit(1), the traceback before it is of another add-on
+// which failed to register and is not the cause.
+const uploadExceptionOutput = `Blender 4.1.1 (hash e1743a0317bc built 2024-04-16 00:06:22)
+Traceback (most recent call last):
+  File "/usr/share/blender/4.1/scripts/modules/addon_utils.py", line 364, in enable
+    mod = importlib.import_module(module_name)
+ModuleNotFoundError: No module named 'node_wrangler_extra'
+Read blend: "/home/user/.config/blender/4.1/scripts/addons/blenderkit/blendfiles/cleaned.blend"
+Exception <class 'RuntimeError'> during pack_all(): Error: Unable to pack file, source path '/home/user/textures/wood.png' not found
+Exception <class 'FileNotFoundError'> in upload_bg.py: [Errno 2] No such file or directory: '/tmp/bktemp_user/export/source.blend'
+`
+
+// unpack_asset_bg.py does not catch exceptions, Python prints the traceback and Blender exits with 1.
+const unpackTracebackOutput = `Blender 4.1.1 (hash e1743a0317bc built 2024-04-16 00:06:22)
+Read blend: "/home/user/blenderkit_data/models/chair_2c7d5a8e/chair_2K_2c7d5a8e.blend"
+Traceback (most recent call last):
+  File "/home/user/.config/blender/4.1/scripts/addons/blenderkit/unpack_asset_bg.py", line 160, in <module>
+    unpack_asset(data)
+  File "/home/user/.config/blender/4.1/scripts/addons/blenderkit/unpack_asset_bg.py", line 146, in unpack_asset
+    bpy.ops.wm.save_as_mainfile(filepath=bpy.data.filepath, compress=False)
+RuntimeError: Error: Cannot open file /home/user/blenderkit_data/models/chair_2c7d5a8e/chair_2K_2c7d5a8e.blend@ for writing: Permission denied
+
+Blender quit
+`
+
+// Chained exceptions on Windows, the script and its lines are made up for the parser.
+const chainedTracebackOutput = `Blender 3.6.5 (hash cf1e1ed46b7e built 2023-10-17 23:47:03)
+Read blend: "C:\Users\user\Documents\asset.blend"
+Traceback (most recent call last):
+  File "C:\Users\user\AppData\Roaming\Blender Foundation\Blender\3.6\scripts\addons\example\example_bg.py", line 41, in unpack
+    bpy.ops.file.unpack_all(method="WRITE_LOCAL")
+KeyError: 'bpy_prop_collection[key]: key "Image" not found'
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "C:\Users\user\AppData\Roaming\Blender Foundation\Blender\3.6\scripts\addons\example\example_bg.py", line 98, in <module>
+    unpack(data)
+  File "C:\Users\user\AppData\Roaming\Blender Foundation\Blender\3.6\scripts\addons\example\example_bg.py", line 45, in unpack
+    raise RuntimeError("unpacking failed")
+RuntimeError: unpacking failed
+Error: Python: Traceback (most recent call last):
+
+Blender quit
+`
+
+// Crash of Blender itself, without Python exception.
+const crashOutput = `Blender 4.2.0 (hash a51f293548ad built 2024-07-16 06:27:09)
+Read blend: "/tmp/asset.blend"
+Error: Not freed memory blocks: 1, total unfreed memory 0.000320 MB
+Writing: /tmp/asset.crash.txt
+Segmentation fault (core dumped)
+`
+
+func TestParseSubprocessOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"exception printed by upload_bg.py", uploadExceptionOutput, "FileNotFoundError: [Errno 2] No such file or directory: '/tmp/bktemp_user/export/source.blend' (upload_bg.py)"},
+		{"traceback", unpackTracebackOutput, "RuntimeError: Error: Cannot open file /home/user/blenderkit_data/models/chair_2c7d5a8e/chair_2K_2c7d5a8e.blend@ for writing: Permission denied (unpack_asset_bg.py:146)"},
+		{"chained traceback on Windows", chainedTracebackOutput, "RuntimeError: unpacking failed (example_bg.py:45)"},
+		{"CRLF line endings", strings.ReplaceAll(uploadExceptionOutput, "\n", "\r\n"), "FileNotFoundError: [Errno 2] No such file or directory: '/tmp/bktemp_user/export/source.blend' (upload_bg.py)"},
+		{"crash", crashOutput, "Segmentation fault (core dumped)"},
+		{"Blender error", "Blender 4.1.1\nError: Cannot read file \"/tmp/missing.blend\": No such file or directory\n\nBlender quit\n", `Error: Cannot read file "/tmp/missing.blend": No such file or directory`},
+		{"nothing found", "Blender 4.1.1\nBlender quit\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSubprocessOutput(tt.output); got != tt.want {
+				t.Errorf("parseSubprocessOutput() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBlenderScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	cmd := exec.Command("sh", "-c", "printf '%s' \"$0\"; exit 1", uploadExceptionOutput)
+	out, err := runBlenderScript(cmd, "upload_bg.py")
+	if string(out) != uploadExceptionOutput {
+		t.Errorf("output = %q; want the full output", out)
+	}
+	var subErr *SubprocessError
+	if !errors.As(err, &subErr) {
+		t.Fatalf("error = %v; want *SubprocessError", err)
+	}
+	defer os.Remove(subErr.LogPath)
+	if subErr.ExitCode != 1 {
+		t.Errorf("exit code = %d; want 1", subErr.ExitCode)
+	}
+	if want := "upload_bg.py failed: FileNotFoundError: [Errno 2] No such file or directory: '/tmp/bktemp_user/export/source.blend' (upload_bg.py)"; err.Error() != want {
+		t.Errorf("error = %q; want %q", err.Error(), want)
+	}
+	if log, e := os.ReadFile(subErr.LogPath); e != nil || string(log) != uploadExceptionOutput {
+		t.Errorf("log file %q = %q, %v; want the full output", subErr.LogPath, log, e)
+	}
+
+	taskErr := subprocessTaskError(1234, "task", err)
+	if taskErr.MessageDetailed != uploadExceptionOutput {
+		t.Errorf("MessageDetailed does not contain the full output")
+	}
+	if result, ok := taskErr.Result.(map[string]interface{}); !ok || result["log_path"] != subErr.LogPath {
+		t.Errorf("result = %v; want log_path %s", taskErr.Result, subErr.LogPath)
+	}
+}
@@ -0,0 +1,127 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	systemIDFilename = "system_id"         // cached system ID in GetSafeTempPath()
+	systemIDAppKey   = "blenderkit-client" // machine ID is hashed with this key, so the raw machine ID is never sent
+)
+
+var systemIDRegex = regexp.MustCompile(`^[0-9]{15}$`)
+
+var systemIDMux sync.Mutex
+
+// systemID returns SystemID, resolved by getSystemID on first use. It is not resolved in init,
+// the ID is cached in GetSafeTempPath() which depends on the flags parsed later.
+func systemID() string {
+	systemIDMux.Lock()
+	defer systemIDMux.Unlock()
+	if SystemID == nil {
+		id := getSystemID()
+		SystemID = &id
+	}
+	return *SystemID
+}
+
+// getSystemID returns unique ID of the machine as string of 15 integers, the format expected by the server.
+// The ID is computed from the machine ID of the OS (MAC address as fallback) and cached in the safe temp path,
+// so it stays the same when network adapters are toggled or replaced. Without the temp path it is not cached.
+func getSystemID() string {
+	var cachePath string
+	if tempDir, err := GetSafeTempPath(); err != nil {
+		BKLog.Printf("%s System ID is not cached, temp path is not available: %v", EmoWarning, err)
+	} else {
+		cachePath = filepath.Join(tempDir, systemIDFilename)
+	}
+	return resolveSystemID(cachePath, readMachineID, macSystemID)
+}
+
+// resolveSystemID goes through the fallback chain: cached ID, hashed machine ID, MAC based ID.
+// Newly computed ID is written to cachePath, if cachePath is empty caching is skipped.
+func resolveSystemID(cachePath string, machineID func() (string, error), fallback func() string) string {
+	if cachePath != "" {
+		cached, err := os.ReadFile(cachePath)
+		switch {
+		case err == nil && systemIDRegex.Match(cached):
+			return string(cached)
+		case err == nil:
+			BKLog.Printf("%s Replacing corrupted cached system ID %s", EmoWarning, cachePath)
+		case !errors.Is(err, os.ErrNotExist):
+			BKLog.Printf("%s Failed to read cached system ID: %v", EmoWarning, err)
+		}
+	}
+
+	var systemID string
+	id, err := machineID()
+	if id = strings.TrimSpace(id); err == nil && id != "" {
+		systemID = hashedSystemID(id)
+	} else {
+		if err == nil {
+			err = errors.New("machine ID is empty")
+		}
+		BKLog.Printf("%s System ID derived from MAC address, machine ID is not available: %v", EmoWarning, err)
+		systemID = fallback()
+	}
+
+	if cachePath != "" {
+		if err := os.WriteFile(cachePath, []byte(systemID), 0600); err != nil {
+			BKLog.Printf("%s Failed to cache system ID: %v", EmoWarning, err)
+		}
+	}
+	return systemID
+}
+
+// hashedSystemID derives the 15 digit ID from the first 8 bytes of HMAC-SHA256 of the machine ID keyed by systemIDAppKey,
+// so the raw machine ID never leaves the machine. It differs from machineid.ProtectedID(), which keys by the machine ID
+// and returns hex; changing it would change the system ID of existing installs.
+func hashedSystemID(machineID string) string {
+	mac := hmac.New(sha256.New, []byte(systemIDAppKey))
+	mac.Write([]byte(machineID))
+	return formatSystemID(binary.BigEndian.Uint64(mac.Sum(nil)[:8]))
+}
+
+// macSystemID is the original system ID from the NodeID (MAC address) of the machine.
+// It is the same format as platform.platform() produces in Python.
+func macSystemID() string {
+	var nodeInt uint64
+	for _, b := range uuid.NodeID() {
+		nodeInt = (nodeInt << 8) | uint64(b)
+	}
+	return formatSystemID(nodeInt)
+}
+
+// formatSystemID formats the number as 15 digits, longer numbers are cut to the last 15 digits.
+func formatSystemID(n uint64) string {
+	return fmt.Sprintf("%015d", n%1_000_000_000_000_000)
+}
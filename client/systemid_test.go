@@ -0,0 +1,78 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatSystemID(t *testing.T) {
+	tests := []struct {
+		input    uint64
+		expected string
+	}{
+		{0, "000000000000000"},
+		{123456789, "000000123456789"},
+		{281474976710655, "281474976710655"}, // max 48 bit MAC address
+		{18446744073709551615, "744073709551615"},
+	}
+	for _, test := range tests {
+		if actual := formatSystemID(test.input); actual != test.expected {
+			t.Errorf("formatSystemID(%d) = %q; want %q", test.input, actual, test.expected)
+		}
+	}
+	if a, b := hashedSystemID("abc"), hashedSystemID("abc"); a != b || !systemIDRegex.MatchString(a) {
+		t.Errorf("hashedSystemID() = %q, %q; want the same 15 digits", a, b)
+	}
+}
+
+func TestResolveSystemID(t *testing.T) {
+	machineID := func() (string, error) { return "4c4c4544-0042-3510-8052-b4c04f4e3732\n", nil }
+	noMachineID := func() (string, error) { return "", fmt.Errorf("not available") }
+	fallback := func() string { return "000000000000042" }
+
+	tests := []struct {
+		name      string
+		cached    string
+		machineID func() (string, error)
+		expected  string
+	}{
+		{"machine ID", "", machineID, hashedSystemID("4c4c4544-0042-3510-8052-b4c04f4e3732")},
+		{"fallback to MAC", "", noMachineID, "000000000000042"},
+		{"cached wins", "123456789012345", machineID, "123456789012345"},
+		{"invalid cache ignored", "garbage", noMachineID, "000000000000042"},
+	}
+	for _, test := range tests {
+		cachePath := filepath.Join(t.TempDir(), systemIDFilename)
+		if test.cached != "" {
+			os.WriteFile(cachePath, []byte(test.cached), 0600)
+		}
+		actual := resolveSystemID(cachePath, test.machineID, fallback)
+		if actual != test.expected {
+			t.Errorf("%s: resolveSystemID() = %q; want %q", test.name, actual, test.expected)
+		}
+		cached, _ := os.ReadFile(cachePath)
+		if string(cached) != test.expected {
+			t.Errorf("%s: cached system ID = %q; want %q", test.name, cached, test.expected)
+		}
+	}
+}
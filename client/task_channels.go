@@ -0,0 +1,299 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TaskChannelCapacity is the buffer size of AddTaskCh, TaskFinishCh, TaskErrorCh, TaskCancelCh and TaskQueuedCh.
+var TaskChannelCapacity = 1000
+
+// channelStats records how full a task channel got and how often its senders had to wait for room.
+type channelStats struct {
+	name      string
+	capacity  int
+	length    func() int
+	highWater atomic.Int64
+	blocked   atomic.Int64
+}
+
+func (s *channelStats) observe(length int) {
+	for {
+		current := s.highWater.Load()
+		if int64(length) <= current || s.highWater.CompareAndSwap(current, int64(length)) {
+			return
+		}
+	}
+}
+
+// taskChannelStats are keyed by the channel, filled in makeTaskChannels. Senders read them while tests register their channels.
+var (
+	taskChannelStatsMux sync.RWMutex
+	taskChannelStats    = map[interface{}]*channelStats{}
+)
+
+func registerTaskChannel[T any](name string, ch chan T) {
+	taskChannelStatsMux.Lock()
+	defer taskChannelStatsMux.Unlock()
+	taskChannelStats[ch] = &channelStats{name: name, capacity: cap(ch), length: func() int { return len(ch) }}
+}
+
+func unregisterTaskChannel[T any](ch chan T) {
+	taskChannelStatsMux.Lock()
+	defer taskChannelStatsMux.Unlock()
+	delete(taskChannelStats, ch)
+}
+
+// makeTaskChannels creates the task channels with the capacity and resets their stats.
+// Must be called before handleChannels is started.
+func makeTaskChannels(capacity int) {
+	AddTaskCh = make(chan *Task, capacity)
+	TaskFinishCh = make(chan *TaskFinish, capacity)
+	TaskCancelCh = make(chan *TaskCancel, capacity)
+	TaskErrorCh = make(chan *TaskError, capacity)
+	TaskQueuedCh = make(chan *TaskQueued, capacity)
+	taskChannelStatsMux.Lock()
+	taskChannelStats = map[interface{}]*channelStats{}
+	taskChannelStatsMux.Unlock()
+	registerTaskChannel("add_task", AddTaskCh)
+	registerTaskChannel("finish", TaskFinishCh)
+	registerTaskChannel("cancel", TaskCancelCh)
+	registerTaskChannel("error", TaskErrorCh)
+	registerTaskChannel("queued", TaskQueuedCh)
+}
+
+// sendTask sends v to the task channel ch. If the channel is full, the send is counted as blocked before waiting for room,
+// so stalls caused by slow handleChannels show up in /metrics instead of passing silently.
+func sendTask[T any](ch chan T, v T) {
+	taskChannelStatsMux.RLock()
+	stats := taskChannelStats[ch]
+	taskChannelStatsMux.RUnlock()
+	select {
+	case ch <- v:
+	default:
+		if stats != nil {
+			stats.blocked.Add(1)
+		}
+		ch <- v
+	}
+	if stats != nil {
+		stats.observe(len(ch))
+	}
+}
+
+type taskKey struct {
+	appID  int
+	taskID string
+}
+
+// pendingUpdate is the merged progress and message of one task which was not yet applied by handleChannels.
+type pendingUpdate struct {
+	AppID           int
+	TaskID          string
+	Progress        int
+	HasProgress     bool
+	Message         string
+	HasMessage      bool
+	MessageDetailed string
+	SpeedBPS        float64
+	ETASeconds      int
+	HasRate         bool
+}
+
+// taskUpdates coalesces progress and message updates of tasks, so senders in hot paths never block.
+// A newer update of a task replaces the older one not yet applied, memory is bounded by the number of tasks.
+type taskUpdates struct {
+	mux       sync.Mutex
+	pending   map[taskKey]*pendingUpdate
+	ready     chan struct{} // signals handleChannels that pending updates are waiting
+	highWater int
+	coalesced int64
+}
+
+// TaskUpdates holds the progress and message updates waiting for handleChannels.
+var TaskUpdates = newTaskUpdates()
+
+func newTaskUpdates() *taskUpdates {
+	return &taskUpdates{pending: make(map[taskKey]*pendingUpdate), ready: make(chan struct{}, 1)}
+}
+
+// get returns the pending update of the task, creating it if needed. Must be called with mux held.
+func (t *taskUpdates) get(appID int, taskID string) *pendingUpdate {
+	key := taskKey{appID: appID, taskID: taskID}
+	p := t.pending[key]
+	if p != nil {
+		t.coalesced++
+		return p
+	}
+	p = &pendingUpdate{AppID: appID, TaskID: taskID}
+	t.pending[key] = p
+	if len(t.pending) > t.highWater {
+		t.highWater = len(t.pending)
+	}
+	return p
+}
+
+func (t *taskUpdates) notify() {
+	select {
+	case t.ready <- struct{}{}:
+	default: // handleChannels was already notified
+	}
+}
+
+func (t *taskUpdates) progress(u *TaskProgressUpdate) {
+	t.mux.Lock()
+	p := t.get(u.AppID, u.TaskID)
+	p.Progress = u.Progress
+	p.HasProgress = true
+	if u.Message != "" {
+		p.Message = u.Message
+		p.HasMessage = true
+	}
+	if u.MessageDetailed != "" {
+		p.MessageDetailed = u.MessageDetailed
+	}
+	if u.SpeedBPS > 0 {
+		p.SpeedBPS = u.SpeedBPS
+		p.ETASeconds = u.ETASeconds
+		p.HasRate = true
+	}
+	t.mux.Unlock()
+	t.notify()
+}
+
+func (t *taskUpdates) message(m *TaskMessageUpdate) {
+	t.mux.Lock()
+	p := t.get(m.AppID, m.TaskID)
+	p.Message = m.Message
+	p.HasMessage = true
+	if m.MessageDetailed != "" {
+		p.MessageDetailed = m.MessageDetailed
+	}
+	t.mux.Unlock()
+	t.notify()
+}
+
+// take returns the pending updates and clears them.
+func (t *taskUpdates) take() []*pendingUpdate {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.pending) == 0 {
+		return nil
+	}
+	updates := make([]*pendingUpdate, 0, len(t.pending))
+	for _, p := range t.pending {
+		updates = append(updates, p)
+	}
+	t.pending = make(map[taskKey]*pendingUpdate)
+	return updates
+}
+
+// sendTaskProgress queues the progress update of a task without blocking, replacing its older pending update.
+func sendTaskProgress(u *TaskProgressUpdate) {
+	TaskUpdates.progress(u)
+}
+
+// sendTaskMessage queues the message of a task without blocking, replacing its older pending update.
+func sendTaskMessage(m *TaskMessageUpdate) {
+	TaskUpdates.message(m)
+}
+
+// applyTaskUpdates applies the pending progress and message updates to the tasks.
+// Called by handleChannels, also before finishing, failing or cancelling a task, so its last progress does not overwrite the result.
+func applyTaskUpdates() {
+	updates := TaskUpdates.take()
+	if len(updates) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, u := range updates {
+		TasksMux.Lock()
+		task := Tasks[u.AppID][u.TaskID]
+		if task == nil {
+			TasksMux.Unlock()
+			ChanLog.Printf("%s ignored update of unknown task %s (%d)\n", EmoWarning, u.TaskID, u.AppID)
+			continue
+		}
+		task.Updated = now
+		if u.HasProgress {
+			task.Progress = u.Progress
+		}
+		if u.HasMessage {
+			task.Message = u.Message
+		}
+		if u.MessageDetailed != "" {
+			task.MessageDetailed = u.MessageDetailed
+		}
+		if u.HasRate {
+			task.SpeedBPS = u.SpeedBPS
+			task.ETASeconds = u.ETASeconds
+		}
+		TasksMux.Unlock()
+		switch {
+		case u.HasProgress && u.Message != "":
+			ChanLog.Printf("%s progress on task %s (%d) - %d%%: %s\n", EmoUpdate, task.LogID(), u.AppID, u.Progress, u.Message)
+		case u.HasProgress:
+			ChanLog.Printf("%s progress on task %s (%d) - %d%%\n", EmoUpdate, task.LogID(), u.AppID, u.Progress)
+		default:
+			ChanLog.Printf("%s %s (%s): %s\n", EmoInfo, task.TaskType, task.LogID(), u.Message)
+		}
+	}
+}
+
+// ChannelMetrics is the state of one task channel as shown by /metrics.
+type ChannelMetrics struct {
+	Capacity     int   `json:"capacity"`
+	Length       int   `json:"length"`
+	HighWater    int64 `json:"high_water"`
+	BlockedSends int64 `json:"blocked_sends"`
+}
+
+// UpdateMetrics is the state of the coalesced progress and message updates as shown by /metrics.
+type UpdateMetrics struct {
+	Pending   int   `json:"pending"`
+	HighWater int   `json:"high_water"`
+	Coalesced int64 `json:"coalesced"` // older updates replaced by newer ones before being applied
+}
+
+// MetricsHandler reports fill levels of the task channels, the coalesced task updates, thumbnails served by each pool
+// and completed tasks dropped or deferred by the result limits.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	channels := map[string]ChannelMetrics{}
+	taskChannelStatsMux.RLock()
+	for _, stats := range taskChannelStats {
+		channels[stats.name] = ChannelMetrics{
+			Capacity:     stats.capacity,
+			Length:       stats.length(),
+			HighWater:    stats.highWater.Load(),
+			BlockedSends: stats.blocked.Load(),
+		}
+	}
+	taskChannelStatsMux.RUnlock()
+
+	TaskUpdates.mux.Lock()
+	updates := UpdateMetrics{Pending: len(TaskUpdates.pending), HighWater: TaskUpdates.highWater, Coalesced: TaskUpdates.coalesced}
+	TaskUpdates.mux.Unlock()
+	thumbnails := map[string]int64{"small": ThumbnailPools.Small.Load(), "full": ThumbnailPools.Full.Load()}
+	results := map[string]int64{"dropped": TaskResults.Dropped.Load(), "dropped_bytes": TaskResults.DroppedBytes.Load(), "deferred": TaskResults.Deferred.Load()}
+	writeJSON(w, map[string]interface{}{"channels": channels, "task_updates": updates, "thumbnail_pools": thumbnails, "task_results": results, "api_latency": APILatency.metrics(time.Now())})
+}
@@ -0,0 +1,180 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTaskUpdatesCoalesce(t *testing.T) {
+	updates := newTaskUpdates()
+	updates.progress(&TaskProgressUpdate{AppID: 1, TaskID: "a", Progress: 10, Message: "Downloading"})
+	updates.message(&TaskMessageUpdate{AppID: 1, TaskID: "a", Message: "Unpacking", MessageDetailed: "asset.blend"})
+	updates.progress(&TaskProgressUpdate{AppID: 1, TaskID: "a", Progress: 90})
+	updates.progress(&TaskProgressUpdate{AppID: 2, TaskID: "a", Progress: 5})
+
+	pending := map[int]*pendingUpdate{}
+	for _, p := range updates.take() {
+		pending[p.AppID] = p
+	}
+	if len(pending) != 2 {
+		t.Fatalf("got %d pending updates; want one per task", len(pending))
+	}
+	a := pending[1]
+	if a.Progress != 90 || a.Message != "Unpacking" || a.MessageDetailed != "asset.blend" {
+		t.Errorf("merged update = %+v; want progress 90 with the last message", a)
+	}
+	if updates.coalesced != 2 {
+		t.Errorf("coalesced = %d; want 2", updates.coalesced)
+	}
+	if left := updates.take(); left != nil {
+		t.Errorf("take left %d updates pending", len(left))
+	}
+}
+
+func TestTaskUpdatesStress(t *testing.T) {
+	const tasks, senders, perSender = 20, 50, 1000 // 50k progress updates
+	updates := newTaskUpdates()
+	latest := make(map[string]int)
+	var latestMux sync.Mutex
+
+	stop := make(chan struct{})
+	consumed := make(chan struct{})
+	go func() { // slow consumer like handleChannels busy with other channels
+		defer close(consumed)
+		for {
+			select {
+			case <-updates.ready:
+				time.Sleep(time.Millisecond)
+				for _, p := range updates.take() {
+					latestMux.Lock()
+					latest[p.TaskID] = p.Progress
+					latestMux.Unlock()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			taskID := fmt.Sprintf("task-%d", s%tasks)
+			for i := 0; i < perSender; i++ {
+				updates.progress(&TaskProgressUpdate{AppID: 1, TaskID: taskID, Progress: i * 100 / perSender})
+			}
+		}(s)
+	}
+	sent := make(chan struct{})
+	go func() { wg.Wait(); close(sent) }()
+	select {
+	case <-sent:
+	case <-time.After(10 * time.Second):
+		t.Fatal("senders blocked on progress updates")
+	}
+	close(stop)
+	<-consumed
+	for _, p := range updates.take() {
+		latest[p.TaskID] = p.Progress
+	}
+
+	if updates.highWater > tasks {
+		t.Errorf("up to %d updates were pending; want at most one per task (%d)", updates.highWater, tasks)
+	}
+	if len(latest) != tasks {
+		t.Fatalf("got updates of %d tasks; want %d", len(latest), tasks)
+	}
+	for taskID, progress := range latest {
+		if progress != 99 {
+			t.Errorf("%s: last applied progress %d; want 99", taskID, progress)
+		}
+	}
+}
+
+func TestSendTaskCountsBlockedSends(t *testing.T) {
+	ch := make(chan *TaskFinish, 1)
+	registerTaskChannel("test", ch)
+	defer unregisterTaskChannel(ch)
+
+	sendTask(ch, &TaskFinish{TaskID: "first"})
+	done := make(chan struct{})
+	go func() {
+		sendTask(ch, &TaskFinish{TaskID: "second"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	<-ch
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked send did not complete after the channel got room")
+	}
+
+	taskChannelStatsMux.RLock()
+	stats := taskChannelStats[ch]
+	taskChannelStatsMux.RUnlock()
+	if stats.blocked.Load() != 1 || stats.highWater.Load() != 1 {
+		t.Errorf("blocked = %d, high water = %d; want 1 and 1", stats.blocked.Load(), stats.highWater.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var metrics struct {
+		Channels map[string]ChannelMetrics `json:"channels"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("decoding /metrics: %v", err)
+	}
+	if m := metrics.Channels["test"]; m.Capacity != 1 || m.BlockedSends != 1 {
+		t.Errorf("/metrics test channel = %+v; want capacity 1 with 1 blocked send", m)
+	}
+	if _, ok := metrics.Channels["add_task"]; !ok {
+		t.Errorf("/metrics is missing add_task channel: %s", rec.Body.String())
+	}
+}
+
+func TestApplyTaskUpdatesSkipsUnknownTasks(t *testing.T) {
+	TasksMux.Lock()
+	Tasks[6291] = map[string]*Task{"known": NewTask(nil, 6291, "known", "asset_download")}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 6291)
+		TasksMux.Unlock()
+	}()
+
+	sendTaskProgress(&TaskProgressUpdate{AppID: 6291, TaskID: "known", Progress: 42, Message: "Downloading"})
+	sendTaskMessage(&TaskMessageUpdate{AppID: 6291, TaskID: "gone", Message: "late message"})
+	applyTaskUpdates()
+
+	TasksMux.Lock()
+	task := Tasks[6291]["known"]
+	TasksMux.Unlock()
+	if task.Progress != 42 || task.Message != "Downloading" {
+		t.Errorf("task progress %d, message %q; want 42 and Downloading", task.Progress, task.Message)
+	}
+}
@@ -0,0 +1,276 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const taskJournalFilename = "tasks_journal.json" // journal of running downloads and uploads in GetSafeTempPath()
+
+// TaskJournalEntry is a download or upload which was not finished yet.
+type TaskJournalEntry struct {
+	AppID     int             `json:"app_id"`
+	TaskID    string          `json:"task_id"`
+	TaskType  string          `json:"task_type"`
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`                // request of the add-on which started the task, without the credentials
+	PartPath  string          `json:"part_path,omitempty"` // file being downloaded, download can be resumed from it
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// TaskJournalStore persists the unfinished asset downloads and uploads into a JSON journal,
+// so after a crash or kill of the Client they can be reported to the add-on as interrupted.
+type TaskJournalStore struct {
+	mux     sync.Mutex
+	path    string
+	entries map[string]TaskJournalEntry // key is the task ID
+
+	restored    map[int][]TaskJournalEntry // entries of the previous run, waiting for the report of their add-on
+	restoredGen int                        // incremented by each load, late dropRestored of earlier load is ignored
+	restoredMux sync.Mutex
+}
+
+var TaskJournal = &TaskJournalStore{}
+
+// RestoredTaskTimeout is how long the entries of the previous run wait for their add-on, Blender which is gone never reports.
+var RestoredTaskTimeout = 10 * time.Minute
+
+// resumeDownload restarts interrupted download which has a part file, variable so tests can replace it.
+var resumeDownload = func(origJSON json.RawMessage, data DownloadData, taskID string) {
+	go runTask(data.AppID, taskID, func() { doAssetDownload(origJSON, data, taskID) })
+}
+
+// LoadTaskJournal reads the journal of the previous run from the safe temp path.
+// Its entries are kept aside and reported as interrupted tasks when their add-on reports, see emitRestoredTasks().
+func LoadTaskJournal() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return TaskJournal.load(filepath.Join(tempDir, taskJournalFilename))
+}
+
+func (j *TaskJournalStore) load(path string) error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	j.path = path
+	j.entries = map[string]TaskJournalEntry{}
+	j.restoredMux.Lock()
+	j.restored = map[int][]TaskJournalEntry{}
+	j.restoredGen++
+	generation := j.restoredGen
+	j.restoredMux.Unlock()
+
+	journal, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []TaskJournalEntry
+	if err := json.Unmarshal(journal, &entries); err != nil {
+		os.Remove(path) // would fail on every start
+		return fmt.Errorf("corrupted task journal %s: %w", path, err)
+	}
+	if len(entries) > 0 {
+		BKLog.Printf("%s Found %d tasks interrupted by previous run", EmoWarning, len(entries))
+	}
+	j.restoredMux.Lock()
+	for _, entry := range entries {
+		j.restored[entry.AppID] = append(j.restored[entry.AppID], entry)
+	}
+	j.restoredMux.Unlock()
+	if len(entries) > 0 {
+		time.AfterFunc(RestoredTaskTimeout, func() { j.dropRestored(generation) })
+	}
+	return j.save() // restored entries are journaled again only if they are resumed
+}
+
+// dropRestored forgets the entries of the previous run whose add-on did not report.
+func (j *TaskJournalStore) dropRestored(generation int) {
+	j.restoredMux.Lock()
+	defer j.restoredMux.Unlock()
+	if j.restoredGen != generation {
+		return // journal was loaded again
+	}
+	for appID, entries := range j.restored {
+		BKLog.Printf("%s Dropping %d interrupted tasks of add-on %d which did not return", EmoWarning, len(entries), appID)
+	}
+	j.restored = map[int][]TaskJournalEntry{}
+}
+
+// save writes the journal, must be called with j.mux locked.
+func (j *TaskJournalStore) save() error {
+	if j.path == "" {
+		return nil
+	}
+	entries := make([]TaskJournalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	journal, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmpPath := j.path + ".tmp"
+	if err := os.WriteFile(tmpPath, journal, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, j.path)
+}
+
+func (j *TaskJournalStore) update(taskID string, change func(*TaskJournalEntry) bool) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.entries == nil {
+		j.entries = map[string]TaskJournalEntry{}
+	}
+	entry := j.entries[taskID]
+	if !change(&entry) {
+		return
+	}
+	entry.UpdatedAt = time.Now()
+	j.entries[taskID] = entry
+	if err := j.save(); err != nil {
+		BKLog.Printf("%s Failed to save task journal: %v", EmoWarning, err)
+	}
+}
+
+// Record adds the started task with the request data of the add-on into the journal.
+// API keys and other secrets are stripped from the data, the journal outlives the login.
+func (j *TaskJournalStore) Record(task *Task, data interface{}) {
+	raw, err := journalData(data)
+	if err != nil {
+		BKLog.Printf("%s Cannot journal %s (%s): %v", EmoWarning, task.TaskType, task.LogID(), err)
+		return
+	}
+	status := task.Status
+	if status == "" {
+		status = "created"
+	}
+	j.update(task.TaskID, func(entry *TaskJournalEntry) bool {
+		*entry = TaskJournalEntry{AppID: task.AppID, TaskID: task.TaskID, TaskType: task.TaskType, Status: status, Data: raw}
+		return true
+	})
+}
+
+// journalData converts the request data to JSON without the values of sensitiveKeys.
+func journalData(data interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stripSensitive(value))
+}
+
+func stripSensitive(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveKey(key) {
+				delete(v, key)
+				continue
+			}
+			v[key] = stripSensitive(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stripSensitive(item)
+		}
+	}
+	return value
+}
+
+// SetStatus updates status of journaled task, tasks which are not journaled are ignored.
+func (j *TaskJournalStore) SetStatus(taskID, status string) {
+	j.update(taskID, func(entry *TaskJournalEntry) bool {
+		if entry.TaskID == "" || entry.Status == status {
+			return false
+		}
+		entry.Status = status
+		return true
+	})
+}
+
+// SetPartPath records the file into which the journaled download writes.
+func (j *TaskJournalStore) SetPartPath(taskID, partPath string) {
+	j.update(taskID, func(entry *TaskJournalEntry) bool {
+		if entry.TaskID == "" || entry.PartPath == partPath {
+			return false
+		}
+		entry.PartPath = partPath
+		return true
+	})
+}
+
+// Remove deletes finished, failed or cancelled task from the journal.
+func (j *TaskJournalStore) Remove(taskID string) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if _, exists := j.entries[taskID]; !exists {
+		return
+	}
+	delete(j.entries, taskID)
+	if err := j.save(); err != nil {
+		BKLog.Printf("%s Failed to save task journal: %v", EmoWarning, err)
+	}
+}
+
+// emitRestoredTasks adds tasks interrupted by the previous run of the Client into tasks of the add-on, so they are in its report.
+// Downloads with a part file on disk are resumed instead with the API key of the report, the journal has no credentials.
+// Other tasks get status "interrupted" and the add-on can offer to repeat them.
+// Must be called with TasksMux locked and Tasks[report.AppID] created.
+func emitRestoredTasks(report MinimalTaskData) {
+	appID := report.AppID
+	TaskJournal.restoredMux.Lock()
+	entries := TaskJournal.restored[appID]
+	delete(TaskJournal.restored, appID)
+	TaskJournal.restoredMux.Unlock()
+
+	for _, entry := range entries {
+		origJSON := entry.Data
+		if entry.TaskType == "asset_download" && entry.PartPath != "" {
+			var data DownloadData
+			if _, err := os.Stat(entry.PartPath); err == nil && json.Unmarshal(entry.Data, &data) == nil {
+				data.APIKey = report.APIKey
+				BKLog.Printf("%s Resuming download %s interrupted by previous run", EmoDownload, entry.TaskID)
+				resumeDownload(origJSON, data, entry.TaskID)
+				continue
+			}
+		}
+
+		task := NewTask(origJSON, appID, entry.TaskID, entry.TaskType)
+		task.Status = "interrupted"
+		task.Message = fmt.Sprintf("Interrupted by restart of BlenderKit-Client while %s", entry.Status)
+		Tasks[appID][task.TaskID] = task
+		ChanLog.Printf("%s %s (%s) interrupted by previous run\n", EmoWarning, task.TaskType, task.LogID())
+	}
+}
@@ -0,0 +1,176 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskJournalRestore(t *testing.T) {
+	defer func(j *TaskJournalStore) { TaskJournal = j }(TaskJournal)
+	defer func(f func(json.RawMessage, DownloadData, string)) { resumeDownload = f }(resumeDownload)
+	const appID = 5151
+	dir := t.TempDir()
+	journal := filepath.Join(dir, taskJournalFilename)
+	partPath := filepath.Join(dir, "asset_2K.blend.part")
+	os.WriteFile(partPath, []byte("partial"), 0644)
+
+	// First run of the Client, killed before the tasks ended.
+	TaskJournal = &TaskJournalStore{}
+	if err := TaskJournal.load(journal); err != nil {
+		t.Fatalf("loading missing journal: %v", err)
+	}
+	request := map[string]interface{}{"app_id": appID, "asset_data": map[string]interface{}{"name": "Kitten"}, "PREFS": map[string]interface{}{"api_key": "key-of-previous-login", "api_key_refresh": "refresh-of-previous-login"}}
+	queued := NewTask(request, appID, "queued-download", "asset_download")
+	TaskJournal.Record(queued, request)
+	TaskJournal.SetStatus(queued.TaskID, "queued")
+	resumable := NewTask(request, appID, "resumable-download", "asset_download")
+	TaskJournal.Record(resumable, request)
+	TaskJournal.SetPartPath(resumable.TaskID, partPath)
+	upload := &Task{AppID: appID, TaskID: "upload", TaskType: "asset_upload"}
+	TaskJournal.Record(upload, AssetUploadRequestData{AppID: appID})
+	finished := NewTask(request, appID, "finished-download", "asset_download")
+	TaskJournal.Record(finished, request)
+	TaskJournal.Remove(finished.TaskID)
+	if saved, _ := os.ReadFile(journal); strings.Contains(string(saved), "previous-login") {
+		t.Errorf("journal %s contains the credentials", saved)
+	}
+
+	// Restart.
+	TaskJournal = &TaskJournalStore{}
+	if err := TaskJournal.load(journal); err != nil {
+		t.Fatalf("loading journal: %v", err)
+	}
+	var resumed []string
+	resumeDownload = func(origJSON json.RawMessage, data DownloadData, taskID string) {
+		resumed = append(resumed, fmt.Sprintf("%s %v %s %s", taskID, data.AppID, data.Name, data.APIKey))
+	}
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{} // add-on already subscribed, avoids fetching its data from the server
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, appID)
+		TasksMux.Unlock()
+	}()
+
+	report := func() map[string]map[string]interface{} {
+		body := fmt.Sprintf(`{"app_id": %d, "addon_version": "3.12.0", "api_key": "key-of-current-login"}`, appID)
+		req := httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		reportHandler(rec, req)
+		var tasks []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("invalid report %s: %v", rec.Body.String(), err)
+		}
+		byID := map[string]map[string]interface{}{}
+		for _, task := range tasks {
+			if task["task_type"] != "client_status" {
+				byID[task["task_id"].(string)] = task
+			}
+		}
+		return byID
+	}
+
+	first := report()
+	if len(first) != 2 {
+		t.Errorf("first report has %d tasks; want 2 interrupted tasks: %v", len(first), first)
+	}
+	for _, taskID := range []string{"queued-download", "upload"} {
+		if task := first[taskID]; task == nil || task["status"] != "interrupted" {
+			t.Errorf("task %s = %v; want status interrupted", taskID, task)
+		}
+	}
+	if msg, _ := first["queued-download"]["message"].(string); !strings.Contains(msg, "queued") {
+		t.Errorf("message = %q; want the status before the restart", msg)
+	}
+	if data, _ := first["queued-download"]["data"].(map[string]interface{}); data == nil || data["asset_data"] == nil {
+		t.Errorf("data = %v; want the original request", first["queued-download"]["data"])
+	}
+	if want := fmt.Sprintf("resumable-download %d Kitten key-of-current-login", appID); len(resumed) != 1 || resumed[0] != want {
+		t.Errorf("resumed %v; want [%s]", resumed, want)
+	}
+
+	if second := report(); len(second) != 0 {
+		t.Errorf("second report has %d tasks; want interrupted tasks reported only once", len(second))
+	}
+	saved, _ := os.ReadFile(journal)
+	if string(saved) != "[]" {
+		t.Errorf("journal after restore = %s; want empty", saved)
+	}
+}
+
+// Blender which is gone never reports, its interrupted tasks are not kept for the rest of the run.
+func TestTaskJournalDropsUnclaimed(t *testing.T) {
+	defer func(timeout time.Duration) { RestoredTaskTimeout = timeout }(RestoredTaskTimeout)
+	RestoredTaskTimeout = 10 * time.Millisecond
+	journal := filepath.Join(t.TempDir(), taskJournalFilename)
+	os.WriteFile(journal, []byte(`[{"app_id": 5152, "task_id": "gone", "task_type": "asset_upload", "status": "uploading", "data": {}}]`), 0600)
+
+	store := &TaskJournalStore{}
+	if err := store.load(journal); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	store.restoredMux.Lock()
+	defer store.restoredMux.Unlock()
+	if len(store.restored) != 0 {
+		t.Errorf("restored entries %v are kept after RestoredTaskTimeout", store.restored)
+	}
+}
+
+func TestDownloadAssetResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "asset.blend", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+	ClientDownloads = server.Client()
+
+	filePath := filepath.Join(t.TempDir(), "asset_2K.blend")
+	os.WriteFile(filePath+".part", []byte(content[:12345]), 0644)
+	err := downloadAsset(server.URL, filePath, DownloadData{AppID: 1}, "resumed-task", context.Background())
+	if err != nil {
+		t.Fatalf("downloadAsset: %v", err)
+	}
+	downloaded, _ := os.ReadFile(filePath)
+	if string(downloaded) != content {
+		t.Errorf("downloaded %d bytes; want %d bytes of the content", len(downloaded), len(content))
+	}
+	if _, err := os.Stat(filePath + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part file was not renamed")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=12345-" {
+		t.Errorf("requested ranges %v; want [bytes=12345-]", ranges)
+	}
+	TaskUpdates.take()
+}
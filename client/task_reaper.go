@@ -0,0 +1,98 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrCodeTaskTimeout is set on tasks which were stuck for longer than the max age of their type.
+const ErrCodeTaskTimeout = "task_timeout"
+
+// TaskMaxAges are the ages after which a task of the type which neither finished nor failed is considered stuck.
+// Task types not listed here use DefaultTaskMaxAge.
+var TaskMaxAges = map[string]time.Duration{
+	"search":                       2 * time.Minute,
+	"thumbnail_download":           5 * time.Minute,
+	"asset_download":               6 * time.Hour,
+	"downloads/prefetch_bookmarks": 6 * time.Hour,
+	"downloads/verify":             time.Hour,
+	"asset_upload":                 12 * time.Hour,
+	"asset_upload_file":            12 * time.Hour,
+	"asset_upload_confirmation":    time.Hour,
+}
+
+var (
+	DefaultTaskMaxAge = 30 * time.Minute
+	TaskAliveWindow   = time.Minute      // tasks with progress or message updated within this window are still alive
+	TaskReapInterval  = 30 * time.Second // how often the stuck tasks are looked for
+)
+
+// isTaskActive reports whether the task is still being worked on, so it can get stuck.
+// Queued tasks wait for their turn and are not reaped, tasks created without status are active.
+func isTaskActive(status string) bool {
+	return status == "" || status == "created" || status == "uploading"
+}
+
+func taskMaxAge(taskType string) time.Duration {
+	if maxAge, ok := TaskMaxAges[taskType]; ok {
+		return maxAge
+	}
+	return DefaultTaskMaxAge
+}
+
+// monitorStaleTasks periodically reaps stuck tasks, so they are not reported to the add-on forever.
+func monitorStaleTasks() {
+	for {
+		time.Sleep(TaskReapInterval)
+		for _, task := range reapStaleTasks(time.Now()) {
+			ChanLog.Printf("%s %s (%s): %s\n", EmoError, task.TaskType, task.LogID(), task.Message)
+		}
+	}
+}
+
+// reapStaleTasks cancels the active tasks older than the max age of their type and without recent updates,
+// they are turned into errors with ErrCodeTaskTimeout. Returns the reaped tasks.
+func reapStaleTasks(now time.Time) []*Task {
+	var reaped []*Task
+	TasksMux.Lock()
+	defer TasksMux.Unlock()
+	for _, tasks := range Tasks {
+		for _, task := range tasks {
+			if !isTaskActive(task.Status) || task.Created.IsZero() {
+				continue
+			}
+			maxAge := taskMaxAge(task.TaskType)
+			if now.Sub(task.Created) < maxAge || now.Sub(task.Updated) < TaskAliveWindow {
+				continue
+			}
+			if task.Cancel != nil {
+				task.Cancel()
+			}
+			task.Status = "error"
+			task.Completed = now
+			task.ErrorCode = ErrCodeTaskTimeout
+			task.Message = fmt.Sprintf("Timed out, %s did not finish in %s", task.TaskType, humanDuration(maxAge))
+			TaskJournal.Remove(task.TaskID)
+			reaped = append(reaped, task)
+		}
+	}
+	return reaped
+}
@@ -0,0 +1,161 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReapStaleTasks(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		taskType string
+		status   string
+		age      time.Duration
+		updated  time.Duration // since last update, 0 for never updated
+		reaped   bool
+	}{
+		{"stuck search", "search", "created", 3 * time.Minute, 0, true},
+		{"young search", "search", "created", time.Minute, 0, false},
+		{"finished search", "search", "finished", time.Hour, 0, false},
+		{"stuck thumbnail", "thumbnail_download", "created", 6 * time.Minute, 0, true},
+		{"download with recent progress", "asset_download", "created", 7 * time.Hour, 10 * time.Second, false},
+		{"download without progress", "asset_download", "created", 7 * time.Hour, 2 * time.Hour, true},
+		{"long download within max age", "asset_download", "created", 2 * time.Hour, 0, false},
+		{"queued download", "asset_download", "queued", 7 * time.Hour, 0, false},
+		{"upload without status", "asset_upload", "", 13 * time.Hour, 0, true},
+		{"other type uses default", "ratings/get_rating", "created", DefaultTaskMaxAge + time.Minute, 0, true},
+	}
+
+	TasksMux.Lock()
+	Tasks[6271] = map[string]*Task{}
+	for _, test := range tests {
+		task := NewTask(nil, 6271, test.name, test.taskType)
+		task.Status = test.status
+		task.Created = now.Add(-test.age)
+		if test.updated != 0 {
+			task.Updated = now.Add(-test.updated)
+		}
+		Tasks[6271][test.name] = task
+	}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 6271)
+		TasksMux.Unlock()
+	}()
+
+	reaped := map[string]bool{}
+	for _, task := range reapStaleTasks(now) {
+		reaped[task.TaskID] = true
+	}
+	for _, test := range tests {
+		task := Tasks[6271][test.name]
+		if reaped[test.name] != test.reaped {
+			t.Errorf("%s: reaped = %v; want %v", test.name, reaped[test.name], test.reaped)
+			continue
+		}
+		if !test.reaped {
+			if task.Ctx.Err() != nil || task.Status != test.status {
+				t.Errorf("%s: not reaped task was changed to %s, context %v", test.name, task.Status, task.Ctx.Err())
+			}
+			continue
+		}
+		if task.Status != "error" || task.ErrorCode != ErrCodeTaskTimeout || task.Message == "" {
+			t.Errorf("%s: reaped task status=%s error_code=%s message=%q; want error with %s", test.name, task.Status, task.ErrorCode, task.Message, ErrCodeTaskTimeout)
+		}
+		if task.Ctx.Err() == nil {
+			t.Errorf("%s: context of reaped task was not cancelled", test.name)
+		}
+	}
+
+	if again := reapStaleTasks(now); len(again) != 0 {
+		t.Errorf("reaped %d tasks again; want reaped tasks to stay errors", len(again))
+	}
+}
+
+// Request waiting for the end of server maintenance is longer than TaskAliveWindow, the task must not be reaped meanwhile.
+func TestReapStaleTasksDuringMaintenanceRetry(t *testing.T) {
+	defer func(window, delay time.Duration) { TaskAliveWindow, MaintenanceRetryDelay = window, delay }(TaskAliveWindow, MaintenanceRetryDelay)
+	TaskAliveWindow, MaintenanceRetryDelay = 40*time.Millisecond, 200*time.Millisecond
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	defer setServer(setServer(server.URL))
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = &http.Client{Transport: &serverStatusTransport{base: http.DefaultTransport}}
+	defer setServerMaintenance(false)
+
+	requests := map[string]func(task *Task) error{
+		"api client": func(task *Task) error {
+			return serverAPI(RequestMeta{}, task).GetJSON(task.Ctx, "/api/v1/search/", nil)
+		},
+		"doIdempotent": func(task *Task) error {
+			req, _ := http.NewRequestWithContext(task.Ctx, http.MethodGet, server.URL+"/api/v1/search/", nil)
+			resp, err := doIdempotent(ClientAPI, req, task)
+			if err == nil {
+				resp.Body.Close()
+			}
+			return err
+		},
+	}
+	for name, request := range requests {
+		task := NewTask(nil, 6272, name, "search")
+		task.Created = time.Now().Add(-2 * taskMaxAge("search")) // only the updates keep it alive
+		task.Updated = time.Now()
+		TasksMux.Lock()
+		Tasks[6272] = map[string]*Task{name: task}
+		TasksMux.Unlock()
+
+		done := make(chan error)
+		go func() { done <- request(task) }()
+		var reaped []*Task
+	wait:
+		for {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("%s: request failed: %v", name, err)
+				}
+				break wait
+			case <-time.After(10 * time.Millisecond):
+				applyTaskUpdates()
+				reaped = append(reaped, reapStaleTasks(time.Now())...)
+			}
+		}
+		if len(reaped) != 0 || task.Ctx.Err() != nil {
+			t.Errorf("%s: task waiting for the maintenance retry was reaped: %s", name, task.Message)
+		}
+	}
+	TasksMux.Lock()
+	delete(Tasks, 6272)
+	TasksMux.Unlock()
+}
@@ -0,0 +1,136 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	MaxRetainedTasks       = 200      // completed tasks kept for one app which does not poll /report
+	MaxRetainedResultBytes = 50 << 20 // estimated size of results of the completed tasks kept for one app
+	MaxReportResultBytes   = 10 << 20 // estimated size of results sent in one /report, the rest waits for the next poll
+)
+
+// TaskResultMetrics counts completed tasks dropped or deferred by the limits, shown by /metrics.
+type TaskResultMetrics struct {
+	Dropped      atomic.Int64 // completed tasks dropped before the add-on reported them
+	DroppedBytes atomic.Int64 // estimated size of results of the dropped tasks
+	Deferred     atomic.Int64 // completed tasks left for the next /report
+}
+
+// TaskResults counts completed tasks dropped and deferred by MaxRetainedTasks, MaxRetainedResultBytes and MaxReportResultBytes.
+var TaskResults = &TaskResultMetrics{}
+
+// retentionWarned holds apps already warned about dropped tasks, cleared when the app reports again. Guarded by TasksMux.
+var retentionWarned = map[int]bool{}
+
+// isTaskCompleted reports whether the task finished or failed, so it is removed from Tasks once reported.
+func isTaskCompleted(status string) bool {
+	return status == "finished" || status == "error" || status == "interrupted"
+}
+
+// estimateResultBytes returns the size of the result in JSON, as it is sent to the add-on.
+func estimateResultBytes(result interface{}) int {
+	if result == nil {
+		return 0
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// markCompleted records when the task completed and the size of its result, then applies the retention limits to its app.
+// Must be called under TasksMux, resultBytes are estimated before locking it as encoding large results takes a while.
+func markCompleted(task *Task, resultBytes int) {
+	task.Completed = time.Now()
+	task.ResultBytes = resultBytes
+	retainCompletedTasks(task.AppID)
+}
+
+// byCompletion sorts the tasks from the oldest completed one.
+func byCompletion(tasks []*Task) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].Completed.Equal(tasks[j].Completed) {
+			return tasks[i].Completed.Before(tasks[j].Completed)
+		}
+		return tasks[i].TaskID < tasks[j].TaskID
+	})
+}
+
+// retainCompletedTasks drops the oldest completed tasks of the app while there are more than MaxRetainedTasks of them
+// or their results exceed MaxRetainedResultBytes. This happens when the add-on stops polling /report,
+// e.g. during a modal operator, while background code keeps scheduling searches. Must be called under TasksMux.
+func retainCompletedTasks(appID int) {
+	var completed []*Task
+	resultBytes := 0
+	for _, task := range Tasks[appID] {
+		if isTaskCompleted(task.Status) {
+			completed = append(completed, task)
+			resultBytes += task.ResultBytes
+		}
+	}
+	if len(completed) <= MaxRetainedTasks && resultBytes <= MaxRetainedResultBytes {
+		return
+	}
+
+	byCompletion(completed)
+	dropped := make(map[string]bool)
+	droppedBytes := 0
+	for _, task := range completed {
+		if len(completed)-len(dropped) <= MaxRetainedTasks && resultBytes <= MaxRetainedResultBytes {
+			break
+		}
+		delete(Tasks[appID], task.TaskID)
+		dropped[task.TaskID] = true
+		resultBytes -= task.ResultBytes
+		droppedBytes += task.ResultBytes
+	}
+	for _, task := range Tasks[appID] { // sub-tasks go with their parent, like in /report
+		if parentID := task.ParentTaskID(); parentID != "" && dropped[parentID] {
+			delete(Tasks[appID], task.TaskID)
+		}
+	}
+	TaskResults.Dropped.Add(int64(len(dropped)))
+	TaskResults.DroppedBytes.Add(int64(droppedBytes))
+	if !retentionWarned[appID] {
+		retentionWarned[appID] = true
+		BKLog.Printf("%s App %d does not report, dropped %d oldest completed tasks (%s of results), keeping %d tasks with %s",
+			EmoWarning, appID, len(dropped), formatBytes(int64(droppedBytes)), len(completed)-len(dropped), formatBytes(int64(resultBytes)))
+	}
+}
+
+// splitReport returns the completed tasks which fit into MaxReportResultBytes from the oldest one, and the deferred rest.
+// The oldest task is always reported, so a result bigger than the limit does not block the report forever.
+func splitReport(completed []*Task) (reported, deferred []*Task) {
+	byCompletion(completed)
+	resultBytes := 0
+	for i, task := range completed {
+		if i > 0 && resultBytes+task.ResultBytes > MaxReportResultBytes {
+			return completed[:i], completed[i:]
+		}
+		resultBytes += task.ResultBytes
+	}
+	return completed, nil
+}
@@ -0,0 +1,130 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completedTasks fills Tasks of the app with finished tasks completed one second apart, each with result of the size.
+func completedTasks(t *testing.T, appID, count, resultBytes int) {
+	start := time.Now().Add(-time.Hour)
+	TasksMux.Lock()
+	Tasks[appID] = map[string]*Task{}
+	for i := 0; i < count; i++ {
+		task := NewTask(nil, appID, fmt.Sprintf("task-%d", i), "search")
+		task.Status = "finished"
+		task.Completed = start.Add(time.Duration(i) * time.Second)
+		task.ResultBytes = resultBytes
+		Tasks[appID][task.TaskID] = task
+	}
+	TasksMux.Unlock()
+	t.Cleanup(func() {
+		TasksMux.Lock()
+		delete(Tasks, appID)
+		delete(retentionWarned, appID)
+		TasksMux.Unlock()
+	})
+}
+
+func TestRetainCompletedTasks(t *testing.T) {
+	defer func(tasks, bytes int) { MaxRetainedTasks, MaxRetainedResultBytes = tasks, bytes }(MaxRetainedTasks, MaxRetainedResultBytes)
+	tests := []struct {
+		name        string
+		maxTasks    int
+		maxBytes    int
+		resultBytes int
+		kept        []string
+	}{
+		{"within limits", 5, 1000, 100, []string{"task-0", "task-1", "task-2", "task-3", "task-4", "running"}},
+		{"over task count", 3, 1000, 100, []string{"task-2", "task-3", "task-4", "running"}},
+		{"over result size", 5, 250, 100, []string{"task-3", "task-4", "running"}},
+	}
+	for _, test := range tests {
+		const appID = 6561
+		MaxRetainedTasks, MaxRetainedResultBytes = test.maxTasks, test.maxBytes
+		completedTasks(t, appID, 5, test.resultBytes)
+		TasksMux.Lock()
+		Tasks[appID]["running"] = NewTask(nil, appID, "running", "asset_download")
+		dropped := TaskResults.Dropped.Load()
+		retainCompletedTasks(appID)
+		kept := Tasks[appID]
+		TasksMux.Unlock()
+
+		if len(kept) != len(test.kept) {
+			t.Errorf("%s: kept %d tasks; want %v", test.name, len(kept), test.kept)
+		}
+		for _, taskID := range test.kept {
+			if kept[taskID] == nil {
+				t.Errorf("%s: task %s was dropped", test.name, taskID)
+			}
+		}
+		if got := TaskResults.Dropped.Load() - dropped; got != int64(6-len(test.kept)) {
+			t.Errorf("%s: dropped counter grew by %d; want %d", test.name, got, 6-len(test.kept))
+		}
+	}
+}
+
+func TestReportDefersResultsOverLimit(t *testing.T) {
+	defer func(limit int) { MaxReportResultBytes = limit }(MaxReportResultBytes)
+	MaxReportResultBytes = 250
+	const appID = 6562
+	completedTasks(t, appID, 5, 100)
+
+	report := func() []string {
+		req := httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(`{"app_id": 6562, "addon_version": "3.12.0"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		reportHandler(rec, req)
+		var reported []Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &reported); err != nil {
+			t.Fatalf("decoding report: %v, body: %s", err, rec.Body.String())
+		}
+		var taskIDs []string
+		for _, task := range reported[1:] { // first is client_status
+			taskIDs = append(taskIDs, task.TaskID)
+		}
+		return taskIDs
+	}
+	deferred := TaskResults.Deferred.Load()
+	for i, want := range []string{"task-0 task-1", "task-2 task-3", "task-4", ""} {
+		if got := strings.Join(report(), " "); got != want {
+			t.Errorf("report %d: got tasks %q; want %q", i, got, want)
+		}
+	}
+	if got := TaskResults.Deferred.Load() - deferred; got != 4 { // 3 after the first report, 1 after the second
+		t.Errorf("deferred counter grew by %d; want 4", got)
+	}
+}
+
+func TestReportSendsOversizedResult(t *testing.T) {
+	defer func(limit int) { MaxReportResultBytes = limit }(MaxReportResultBytes)
+	MaxReportResultBytes = 50
+	completed := []*Task{{TaskID: "big", ResultBytes: 100}, {TaskID: "small", ResultBytes: 10, Completed: time.Now()}}
+	reported, deferred := splitReport(completed)
+	if len(reported) != 1 || reported[0].TaskID != "big" || len(deferred) != 1 {
+		t.Errorf("reported %v, deferred %v; want the oldest task reported although over the limit", reported, deferred)
+	}
+}
@@ -0,0 +1,192 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const taskInfoMessageLimit = 200 // messages longer than this are truncated in /tasks
+
+// TaskInfo is the state of one task as shown by /tasks for debugging of stuck operations.
+type TaskInfo struct {
+	AppID     int         `json:"app_id"`
+	TaskID    string      `json:"task_id"`
+	RequestID string      `json:"request_id"`
+	TaskType  string      `json:"task_type"`
+	Status    string      `json:"status"`
+	Progress  int         `json:"progress"`
+	AgeS      float64     `json:"age_s"`
+	Message   string      `json:"message"`
+	ErrorCode string      `json:"error_code,omitempty"`
+	Data      interface{} `json:"data"` // sanitized by sanitizeTaskData, secrets are redacted
+}
+
+// TasksHandler lists current tasks of the app_id, or of all apps with all=true.
+// Responds with JSON, or with HTML table with format=html for viewing in the browser.
+func TasksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	all := query.Get("all") == "true"
+	appID, err := strconv.Atoi(query.Get("app_id"))
+	if !all {
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidField, "app_id: must be a number, or use all=true")
+			return
+		}
+		if err := validateAppID(appID); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+	}
+
+	infos := collectTaskInfos(appID, all, time.Now())
+	if query.Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tasksPageTemplate.Execute(w, infos); err != nil {
+			BKLog.Printf("%s Failed to render tasks page: %v", EmoWarning, err)
+		}
+		return
+	}
+	writeJSON(w, map[string]interface{}{"count": len(infos), "tasks": infos})
+}
+
+// collectTaskInfos returns tasks of the app, or of all apps, the oldest first.
+func collectTaskInfos(appID int, all bool, now time.Time) []TaskInfo {
+	infos := []TaskInfo{}
+	TasksMux.Lock()
+	for id, tasks := range Tasks {
+		if !all && id != appID {
+			continue
+		}
+		for _, task := range tasks {
+			info := TaskInfo{
+				AppID:     task.AppID,
+				TaskID:    task.TaskID,
+				RequestID: task.RequestID,
+				TaskType:  task.TaskType,
+				Status:    task.Status,
+				Progress:  task.Progress,
+				Message:   truncateMessage(task.Message, taskInfoMessageLimit),
+				ErrorCode: task.ErrorCode,
+				Data:      sanitizeTaskData(task.Data),
+			}
+			if !task.Created.IsZero() {
+				info.AgeS = now.Sub(task.Created).Round(time.Second).Seconds()
+			}
+			infos = append(infos, info)
+		}
+	}
+	TasksMux.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].AgeS != infos[j].AgeS {
+			return infos[i].AgeS > infos[j].AgeS
+		}
+		return infos[i].TaskID < infos[j].TaskID
+	})
+	return infos
+}
+
+func truncateMessage(message string, limit int) string {
+	runes := []rune(message)
+	if len(runes) <= limit {
+		return message
+	}
+	return string(runes[:limit]) + "…"
+}
+
+// sensitiveKeys are keys of task data which are never shown, compared in lowercase without underscores and dashes.
+var sensitiveKeys = map[string]bool{
+	"apikey":        true,
+	"apikeyrefresh": true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"token":         true,
+	"authorization": true,
+	"password":      true,
+	"secret":        true,
+	"codeverifier":  true,
+}
+
+func isSensitiveKey(key string) bool {
+	return sensitiveKeys[strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))]
+}
+
+// sanitizeTaskData converts task data to its JSON form with values of sensitive keys replaced by "[redacted]".
+// The task data itself is not modified.
+func sanitizeTaskData(data interface{}) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "[unserializable]"
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "[unserializable]"
+	}
+	return redactSensitive(value)
+}
+
+func redactSensitive(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveKey(key) && item != nil && item != "" {
+				v[key] = "[redacted]"
+				continue
+			}
+			v[key] = redactSensitive(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactSensitive(item)
+		}
+	}
+	return value
+}
+
+var tasksPageTemplate = template.Must(template.New("tasks").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>BlenderKit-Client tasks</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; font-size: 0.9rem; }
+  table { border-collapse: collapse; }
+  th, td { padding: 0.3rem 0.6rem; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+  td.error { color: #c0392b; }
+</style>
+</head>
+<body>
+<h1>Tasks ({{len .}})</h1>
+<table>
+<tr><th>App</th><th>Type</th><th>Status</th><th>Progress</th><th>Age</th><th>Message</th><th>Task / request</th></tr>
+{{range .}}<tr>
+<td>{{.AppID}}</td><td>{{.TaskType}}</td><td{{if eq .Status "error"}} class="error"{{end}}>{{.Status}}{{if .ErrorCode}} ({{.ErrorCode}}){{end}}</td>
+<td>{{.Progress}}%</td><td>{{.AgeS}} s</td><td>{{.Message}}</td><td>{{.TaskID}}<br>{{.RequestID}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,163 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeTaskData(t *testing.T) {
+	search := SearchTaskData{PREFS: PREFS{APIKey: "secret-api-key", APIKeyRefres: "secret-refresh", SceneID: "scene"}, URLQuery: "https://www.blenderkit.com/api/v1/search/"}
+	tests := []struct {
+		name     string
+		data     interface{}
+		redacted []string // JSON paths of redacted values, joined by dots
+		kept     map[string]interface{}
+	}{
+		{
+			name:     "prefs embedded in search data",
+			data:     search,
+			redacted: []string{"PREFS.api_key", "PREFS.api_key_refresh"},
+			kept:     map[string]interface{}{"PREFS.scene_id": "scene", "urlquery": search.URLQuery},
+		},
+		{
+			name: "nested maps and lists",
+			data: map[string]interface{}{
+				"Authorization": "Bearer abc",
+				"result":        map[string]interface{}{"access_token": "at", "refresh_token": "rt", "expires_in": 3600.0},
+				"accounts":      []interface{}{map[string]interface{}{"apiKey": "k", "username": "bob"}},
+			},
+			redacted: []string{"Authorization", "result.access_token", "result.refresh_token", "accounts.0.apiKey"},
+			kept:     map[string]interface{}{"result.expires_in": 3600.0, "accounts.0.username": "bob"},
+		},
+		{
+			name: "empty secrets stay empty",
+			data: map[string]interface{}{"api_key": "", "token": nil},
+			kept: map[string]interface{}{"api_key": "", "token": nil},
+		},
+	}
+
+	for _, test := range tests {
+		sanitized := sanitizeTaskData(test.data)
+		for _, path := range test.redacted {
+			if value := jsonPath(sanitized, path); value != "[redacted]" {
+				t.Errorf("%s: %s = %v; want redacted", test.name, path, value)
+			}
+		}
+		for path, expected := range test.kept {
+			if value := jsonPath(sanitized, path); value != expected {
+				t.Errorf("%s: %s = %v; want %v", test.name, path, value, expected)
+			}
+		}
+		raw, _ := json.Marshal(sanitized)
+		for _, secret := range []string{"secret-api-key", "secret-refresh", "Bearer abc", `"at"`, `"rt"`} {
+			if strings.Contains(string(raw), secret) {
+				t.Errorf("%s: sanitized data %s contains %s", test.name, raw, secret)
+			}
+		}
+	}
+	if search.PREFS.APIKey != "secret-api-key" {
+		t.Error("sanitizeTaskData() modified the task data")
+	}
+}
+
+// jsonPath returns value of decoded JSON at path like "result.items.0.name".
+func jsonPath(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i := int(key[0] - '0')
+			if i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func TestTasksHandler(t *testing.T) {
+	now := time.Now()
+	download := NewTask(DownloadData{PREFS: PREFS{APIKey: "secret-api-key"}}, 6261, "download-1", "asset_download")
+	download.Progress, download.Status = 40, "running"
+	download.Created = now.Add(-time.Hour)
+	download.Message = strings.Repeat("x", taskInfoMessageLimit+50)
+	search := NewTask(nil, 6261, "search-1", "search")
+	other := NewTask(nil, 6262, "login-1", "login")
+	TasksMux.Lock()
+	Tasks[6261] = map[string]*Task{download.TaskID: download, search.TaskID: search}
+	Tasks[6262] = map[string]*Task{other.TaskID: other}
+	TasksMux.Unlock()
+	defer func() {
+		TasksMux.Lock()
+		delete(Tasks, 6261)
+		delete(Tasks, 6262)
+		TasksMux.Unlock()
+	}()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		TasksHandler(rr, httptest.NewRequest("GET", "/tasks?"+query, nil))
+		return rr
+	}
+	for _, query := range []string{"", "app_id=abc", "app_id=0"} {
+		if rr := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("GET /tasks?%s = %d; want 400", query, rr.Code)
+		}
+	}
+
+	var resp struct {
+		Count int        `json:"count"`
+		Tasks []TaskInfo `json:"tasks"`
+	}
+	rr := get("app_id=6261")
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if resp.Count != 2 || len(resp.Tasks) != 2 {
+		t.Fatalf("GET /tasks?app_id=6261 returned %d tasks; want 2", resp.Count)
+	}
+	first := resp.Tasks[0]
+	if first.TaskID != "download-1" || first.Progress != 40 || first.Status != "running" || first.AgeS < 3599 {
+		t.Errorf("first task = %+v; want the hour old download at 40%%", first)
+	}
+	if len([]rune(first.Message)) != taskInfoMessageLimit+1 {
+		t.Errorf("message has %d characters; want truncated to %d and ellipsis", len([]rune(first.Message)), taskInfoMessageLimit)
+	}
+	if strings.Contains(rr.Body.String(), "secret-api-key") {
+		t.Error("response contains the API key")
+	}
+
+	if err := json.NewDecoder(get("all=true").Body).Decode(&resp); err != nil || resp.Count < 3 {
+		t.Errorf("GET /tasks?all=true returned %d tasks, %v; want all 3", resp.Count, err)
+	}
+	html := get("all=true&format=html")
+	if !strings.HasPrefix(html.Header().Get("Content-Type"), "text/html") || !strings.Contains(html.Body.String(), "<td>asset_download</td>") {
+		t.Errorf("HTML format = %s; want table with the tasks", html.Body.String())
+	}
+}
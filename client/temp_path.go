@@ -0,0 +1,168 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// TempPathMinFreeSpace is the free space needed on the volume of the temp path, with less the fallback directory is used.
+var TempPathMinFreeSpace int64 = 200 * 1024 * 1024
+
+// fallbackTempDirname is the directory in the home of the user used when the system temp directory is unusable.
+const fallbackTempDirname = ".blenderkit_client_tmp"
+
+// TempPathStatus describes the directory used for temporary files, reported in the client_status task.
+type TempPathStatus struct {
+	Path      string `json:"path"`
+	Fallback  bool   `json:"fallback"`         // system temp directory was unusable, Path is in the home directory
+	Reason    string `json:"reason,omitempty"` // why the system temp directory was not used
+	FreeSpace int64  `json:"free_space"`       // bytes free on the volume of Path, 0 if unknown
+}
+
+// tempPath caches the resolved temp path, so the directory is probed only once.
+var tempPath struct {
+	mux    sync.Mutex
+	status *TempPathStatus
+}
+
+// GetSafeTempPath returns the directory for thumbnails, gravatars and other temporary files.
+// The directory is resolved by ResolveTempPath() on the first call and cached.
+//
+// Contains dirs: bkit_g, brush_search, hdr_search, material_search, model_search, scene_search
+//
+// Contains files: categories.json.
+func GetSafeTempPath() (string, error) {
+	status, err := ResolveTempPath()
+	if err != nil {
+		return "", err
+	}
+	return status.Path, nil
+}
+
+// ResolveTempPath returns the cached temp path status, or resolves it if called for the first time.
+// The user-specific directory in the system temp directory is used if it can be created, is writable
+// and has TempPathMinFreeSpace free. Otherwise the directory falls back to fallbackTempDirname in the home directory.
+// If the fallback is unusable too, the system temp directory is used if it exists at all. Failures are not cached.
+func ResolveTempPath() (TempPathStatus, error) {
+	tempPath.mux.Lock()
+	defer tempPath.mux.Unlock()
+	if tempPath.status != nil {
+		return *tempPath.status, nil
+	}
+
+	var status TempPathStatus
+	systemPath, err := systemTempPath()
+	if err != nil {
+		status.Reason = fmt.Sprintf("cannot be created: %v", err)
+	} else {
+		status.Path = systemPath
+		status.FreeSpace, status.Reason = checkTempPath(systemPath)
+	}
+	if status.Reason != "" {
+		fallback, fallbackErr := fallbackTempPath()
+		var reason string
+		if fallbackErr == nil {
+			var free int64
+			if free, reason = checkTempPath(fallback); reason == "" {
+				BKLog.Printf("%s System temp directory %s is unusable (%s), using %s", EmoWarning, os.TempDir(), status.Reason, fallback)
+				status.Path, status.Fallback, status.FreeSpace = fallback, true, free
+			}
+		} else {
+			reason = fallbackErr.Error()
+		}
+		if !status.Fallback {
+			BKLog.Printf("%s System temp directory %s is unusable (%s), fallback directory too: %s", EmoWarning, os.TempDir(), status.Reason, reason)
+			if systemPath == "" {
+				return TempPathStatus{}, err
+			}
+		}
+	}
+	tempPath.status = &status
+	return status, nil
+}
+
+// systemTempPath returns a safe, user-specific path in the system's temporary directory and creates it.
+func systemTempPath() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	username := currentUser.Username
+	reg, err := regexp.Compile("[^a-zA-Z0-9]+")
+	if err != nil {
+		return "", err
+	}
+	safeUsername := reg.ReplaceAllString(username, "")
+
+	tempDir := os.TempDir()
+	safeTempPath := filepath.Join(tempDir, "bktemp_"+safeUsername)
+
+	err = os.MkdirAll(safeTempPath, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return safeTempPath, nil
+}
+
+// fallbackTempPath creates fallbackTempDirname in the home directory of the user.
+func fallbackTempPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(home, fallbackTempDirname)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// checkTempPath creates and removes a probe file in the directory and checks the free space of its volume.
+// Returns the free space, 0 if unknown, and the reason why the directory is unusable, empty if it is usable.
+func checkTempPath(path string) (int64, string) {
+	probe, err := os.CreateTemp(path, ".write_probe_*")
+	if err != nil {
+		return 0, fmt.Sprintf("not writable: %v", err)
+	}
+	_, err = probe.Write([]byte("probe"))
+	if closeErr := probe.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(probe.Name())
+	if err != nil {
+		return 0, fmt.Sprintf("not writable: %v", err)
+	}
+
+	free, err := diskFreeSpace(path)
+	if err != nil {
+		return 0, "" // free space cannot be found out, writable directory is good enough
+	}
+	if free < TempPathMinFreeSpace {
+		return free, fmt.Sprintf("only %s free, needs %s", formatBytes(free), formatBytes(TempPathMinFreeSpace))
+	}
+	return free, ""
+}
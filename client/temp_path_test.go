@@ -0,0 +1,104 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resetTempPath clears the cached temp path for the test and restores it after.
+func resetTempPath(t *testing.T) {
+	tempPath.mux.Lock()
+	saved := tempPath.status
+	tempPath.status = nil
+	tempPath.mux.Unlock()
+	t.Cleanup(func() {
+		tempPath.mux.Lock()
+		tempPath.status = saved
+		tempPath.mux.Unlock()
+	})
+}
+
+func TestResolveTempPath(t *testing.T) {
+	resetTempPath(t)
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	status, err := ResolveTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Fallback || status.Reason != "" || !strings.HasPrefix(status.Path, tempDir) {
+		t.Errorf("ResolveTempPath() = %+v; want bktemp directory in %s", status, tempDir)
+	}
+	if status.FreeSpace <= 0 {
+		t.Errorf("FreeSpace = %d; want free space of the volume", status.FreeSpace)
+	}
+	entries, _ := os.ReadDir(status.Path)
+	if len(entries) != 0 {
+		t.Errorf("probe file left in temp path: %v", entries)
+	}
+}
+
+func TestResolveTempPathFallback(t *testing.T) {
+	resetTempPath(t)
+	notDir := filepath.Join(t.TempDir(), "temp")
+	if err := os.WriteFile(notDir, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	home := t.TempDir()
+	t.Setenv("TMPDIR", notDir) // bktemp directory cannot be created in a file
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	status, err := ResolveTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(home, fallbackTempDirname)
+	if status.Path != want || !status.Fallback || !strings.Contains(status.Reason, "cannot be created") {
+		t.Errorf("ResolveTempPath() = %+v; want fallback to %s", status, want)
+	}
+
+	t.Setenv("TMPDIR", t.TempDir())
+	if path, _ := GetSafeTempPath(); path != want {
+		t.Errorf("GetSafeTempPath() = %s; want cached %s", path, want)
+	}
+}
+
+func TestResolveTempPathLowSpace(t *testing.T) {
+	resetTempPath(t)
+	defer func(size int64) { TempPathMinFreeSpace = size }(TempPathMinFreeSpace)
+	TempPathMinFreeSpace = 1 << 62
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	t.Setenv("HOME", t.TempDir())
+
+	status, err := ResolveTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fallback is on the same volume here, so it has no more space and the system temp path is kept
+	if status.Fallback || !strings.HasPrefix(status.Path, tempDir) || !strings.Contains(status.Reason, "free") {
+		t.Errorf("ResolveTempPath() = %+v; want system temp path with reason about free space", status)
+	}
+}
@@ -0,0 +1,325 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pruning of thumbnails downloaded into the *_search directories in GetSafeTempPath().
+var (
+	ThumbnailCacheCap           = newAtomicInt64(2 * 1024 * 1024 * 1024) // Max total size of all thumbnail directories, set by flag and /settings/update
+	ThumbnailCacheMinAge        = 24 * time.Hour                         // Newer thumbnails are never pruned, they are likely still shown
+	ThumbnailCachePruneInterval = 24 * time.Hour
+
+	thumbnailCacheMux sync.Mutex // Prevents the periodic and manual cleaning from running at the same time
+)
+
+// ThumbnailCacheStatus is returned by /cache/thumbnails/status.
+type ThumbnailCacheStatus struct {
+	TotalSize   int64            `json:"total_size"`
+	Cap         int64            `json:"cap"`
+	Directories map[string]int64 `json:"directories"` // Size of each directory, e.g. model_search
+}
+
+// ThumbnailCacheCleanResult is returned by /cache/thumbnails/clear.
+type ThumbnailCacheCleanResult struct {
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+}
+
+type thumbnailCacheFile struct {
+	path     string
+	dir      string
+	size     int64
+	accessed time.Time
+}
+
+// scanThumbnailCache lists all files in the *_search directories of root.
+func scanThumbnailCache(root string) ([]thumbnailCacheFile, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "*_search"))
+	if err != nil {
+		return nil, err
+	}
+	var files []thumbnailCacheFile
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // removed in the meantime
+			}
+			files = append(files, thumbnailCacheFile{
+				path:     filepath.Join(dir, entry.Name()),
+				dir:      filepath.Base(dir),
+				size:     info.Size(),
+				accessed: fileAccessTime(info),
+			})
+		}
+	}
+	return files, nil
+}
+
+// thumbnailCacheStatus sums sizes of the thumbnail directories in root.
+func thumbnailCacheStatus(root string) (ThumbnailCacheStatus, error) {
+	status := ThumbnailCacheStatus{Cap: ThumbnailCacheCap.Load(), Directories: map[string]int64{}}
+	files, err := scanThumbnailCache(root)
+	if err != nil {
+		return status, err
+	}
+	dirs, _ := filepath.Glob(filepath.Join(root, "*_search"))
+	for _, dir := range dirs {
+		status.Directories[filepath.Base(dir)] = 0
+	}
+	for _, f := range files {
+		status.Directories[f.dir] += f.size
+		status.TotalSize += f.size
+	}
+	return status, nil
+}
+
+// pruneThumbnailCache deletes least recently accessed thumbnails until the total size is under maxSize.
+// Files accessed after now-minAge and files in pending are never deleted, so the cap can stay exceeded.
+// With maxSize 0 and minAge 0 everything except pending files is deleted.
+func pruneThumbnailCache(root string, maxSize int64, minAge time.Duration, pending map[string]bool, now time.Time) (ThumbnailCacheCleanResult, error) {
+	files, err := scanThumbnailCache(root)
+	if err != nil {
+		return ThumbnailCacheCleanResult{}, err
+	}
+	return pruneLeastAccessed(files, maxSize, minAge, pending, now), nil
+}
+
+// pruneLeastAccessed deletes least recently accessed files until their total size is under maxSize.
+// Files accessed after now-minAge and files in pending are skipped.
+func pruneLeastAccessed(files []thumbnailCacheFile, maxSize int64, minAge time.Duration, pending map[string]bool, now time.Time) ThumbnailCacheCleanResult {
+	var result ThumbnailCacheCleanResult
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].accessed.Before(files[j].accessed) })
+
+	for _, f := range files {
+		if total <= maxSize {
+			break
+		}
+		if pending[f.path] || now.Sub(f.accessed) < minAge {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue // probably in use, try it next time
+		}
+		total -= f.size
+		result.Removed++
+		result.Freed += f.size
+	}
+	return result
+}
+
+// pendingThumbnailPaths returns image paths of thumbnail tasks which are still being downloaded.
+func pendingThumbnailPaths() map[string]bool {
+	pending := map[string]bool{}
+	TasksMux.Lock()
+	defer TasksMux.Unlock()
+	for _, appTasks := range Tasks {
+		for _, task := range appTasks {
+			if task.TaskType != "thumbnail_download" || task.Status != "created" {
+				continue
+			}
+			if data, ok := task.Data.(DownloadThumbnailData); ok {
+				pending[data.ImagePath] = true
+			}
+		}
+	}
+	return pending
+}
+
+func cleanThumbnailCache(maxSize int64, minAge time.Duration) (ThumbnailCacheCleanResult, error) {
+	root, err := GetSafeTempPath()
+	if err != nil {
+		return ThumbnailCacheCleanResult{}, err
+	}
+	thumbnailCacheMux.Lock()
+	defer thumbnailCacheMux.Unlock()
+	result, err := pruneThumbnailCache(root, maxSize, minAge, pendingThumbnailPaths(), time.Now())
+	ThumbnailSources.Prune()
+	return result, err
+}
+
+// monitorThumbnailCache prunes the thumbnail cache at startup and then every ThumbnailCachePruneInterval.
+func monitorThumbnailCache() {
+	for {
+		result, err := cleanThumbnailCache(ThumbnailCacheCap.Load(), ThumbnailCacheMinAge)
+		if err != nil {
+			BKLog.Printf("%s Thumbnail cache pruning failed: %v", EmoWarning, err)
+		} else if result.Removed > 0 {
+			BKLog.Printf("%s Thumbnail cache pruned: removed %d files, freed %d MB", EmoInfo, result.Removed, result.Freed/1024/1024)
+		}
+		time.Sleep(ThumbnailCachePruneInterval)
+	}
+}
+
+// ThumbnailCacheStatusHandler reports sizes of the thumbnail directories.
+func ThumbnailCacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	root, err := GetSafeTempPath()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	status, err := thumbnailCacheStatus(root)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, status)
+}
+
+// CacheStatusHandler reports sizes of the thumbnail directories and of the author avatars.
+func CacheStatusHandler(w http.ResponseWriter, r *http.Request) {
+	root, err := GetSafeTempPath()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	thumbnails, err := thumbnailCacheStatus(root)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	gravatars, err := gravatarCacheStatus(filepath.Join(root, gravatar_dirname))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"thumbnails": thumbnails, "gravatars": gravatars})
+}
+
+// ThumbnailCacheClearHandler deletes all thumbnails except the ones being downloaded right now.
+func ThumbnailCacheClearHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := cleanThumbnailCache(0, 0)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	BKLog.Printf("%s Thumbnail cache cleared: removed %d files, freed %d MB", EmoInfo, result.Removed, result.Freed/1024/1024)
+	writeJSON(w, result)
+}
+
+// thumbnailURL returns the URL on which the downloaded thumbnail is served by ThumbnailHandler.
+func thumbnailURL(imagePath string) string {
+	return fmt.Sprintf("http://127.0.0.1:%s/thumbnails/%s", *Port, url.PathEscape(filepath.Base(imagePath)))
+}
+
+// ThumbnailResult is the result of finished thumbnail task, add-on which cannot read the path can load the thumbnail from the URL.
+// The preview is identified by the search task and asset type, as assetBaseId and index alone are ambiguous
+// when searches for different asset types run at once.
+type ThumbnailResult struct {
+	ThumbnailPath string `json:"thumbnail_path"`
+	ThumbnailURL  string `json:"thumbnail_url"`
+	ImagePath     string `json:"image_path"`
+	ThumbnailType string `json:"thumbnail_type"`
+	AssetBaseID   string `json:"assetBaseId"`
+	AssetType     string `json:"asset_type"`
+	Index         int    `json:"index"`
+	SearchTaskID  string `json:"search_task_id"`
+}
+
+func thumbnailResult(data DownloadThumbnailData) ThumbnailResult {
+	return ThumbnailResult{
+		ThumbnailPath: data.ImagePath,
+		ThumbnailURL:  thumbnailURL(data.ImagePath),
+		ImagePath:     data.ImagePath,
+		ThumbnailType: data.ThumbnailType,
+		AssetBaseID:   data.AssetBaseID,
+		AssetType:     data.AssetType,
+		Index:         data.Index,
+		SearchTaskID:  data.SearchTaskID,
+	}
+}
+
+// findThumbnail returns path of the thumbnail in the *_search directories of the safe temp path.
+// Only these directories are served, not the tempdir of the search requests, so the handler never reads other files of the user.
+// Filename must be a plain file name, anything which could escape the directories is rejected.
+func findThumbnail(filename string) (string, error) {
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, `/\:`) || filename != filepath.Base(filename) {
+		return "", &FieldError{Field: "filename", Message: "must be a plain file name"}
+	}
+	root, err := GetSafeTempPath()
+	if err != nil {
+		return "", err
+	}
+	dirs, err := filepath.Glob(filepath.Join(root, "*_search"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, filename)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// ThumbnailHandler serves downloaded thumbnails read-only at /thumbnails/{filename}.
+// Add-on running in a sandbox (Snap, Flatpak) cannot always read the temp path of the Client, so it can load thumbnails over HTTP.
+func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "only GET and HEAD are allowed")
+		return
+	}
+	path, err := findThumbnail(strings.TrimPrefix(r.URL.Path, "/thumbnails/"))
+	if err != nil {
+		if _, ok := err.(*FieldError); ok {
+			writeValidationError(w, err)
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "thumbnail not found")
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, ErrCodeNotFound, "thumbnail not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=86400") // thumbnail file names change with new versions of the thumbnail
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,130 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneThumbnailCache(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		path     string
+		age      time.Duration
+		expected bool // should still exist after pruning
+	}{
+		{"model_search/oldest.webp", 10 * 24 * time.Hour, false},
+		{"material_search/old.webp", 5 * 24 * time.Hour, false},
+		{"model_search/pending.webp", 9 * 24 * time.Hour, true},
+		{"model_search/older_but_under_cap.webp", 3 * 24 * time.Hour, true},
+		{"material_search/fresh.webp", time.Hour, true},
+		{"hdr_search/recent.webp", 2 * time.Hour, true},
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+		accessed := now.Add(-f.age)
+		if err := os.Chtimes(path, accessed, accessed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "categories.json"), make([]byte, 1000), 0600) // not a thumbnail
+
+	status, err := thumbnailCacheStatus(root)
+	if err != nil {
+		t.Fatalf("thumbnailCacheStatus() error: %v", err)
+	}
+	if status.TotalSize != 600 || status.Directories["model_search"] != 300 {
+		t.Errorf("thumbnailCacheStatus() = %+v; want total 600, model_search 300", status)
+	}
+
+	pending := map[string]bool{filepath.Join(root, "model_search/pending.webp"): true}
+	result, err := pruneThumbnailCache(root, 400, 24*time.Hour, pending, now)
+	if err != nil {
+		t.Fatalf("pruneThumbnailCache() error: %v", err)
+	}
+	if result.Removed != 2 || result.Freed != 200 {
+		t.Errorf("pruneThumbnailCache() = %+v; want 2 files and 200 bytes removed", result)
+	}
+	for _, f := range files {
+		exists, _, _ := FileExists(filepath.Join(root, f.path))
+		if exists != f.expected {
+			t.Errorf("%s exists=%t; want %t", f.path, exists, f.expected)
+		}
+	}
+
+	result, _ = pruneThumbnailCache(root, 0, 0, pending, now)
+	if result.Removed != 3 {
+		t.Errorf("clearing cache removed %d files; want 3 (all except pending)", result.Removed)
+	}
+}
+
+func TestThumbnailHandler(t *testing.T) {
+	resetTempPath(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	root, err := GetSafeTempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "model_search")
+	os.MkdirAll(dir, 0700)
+	os.WriteFile(filepath.Join(dir, "thumb.webp"), []byte("RIFF0000WEBPVP8 "), 0600)
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0600)
+	os.MkdirAll(filepath.Join(root, "bkit_g"), 0700)
+	os.WriteFile(filepath.Join(root, "bkit_g", "avatar.jpg"), []byte("avatar"), 0600)
+
+	tests := []struct {
+		method      string
+		target      string
+		status      int
+		contentType string
+	}{
+		{"GET", "/thumbnails/thumb.webp", http.StatusOK, "image/webp"},
+		{"HEAD", "/thumbnails/thumb.webp", http.StatusOK, "image/webp"},
+		{"GET", "/thumbnails/missing.webp", http.StatusNotFound, "application/json"},
+		{"GET", "/thumbnails/secret.txt", http.StatusNotFound, "application/json"},
+		{"GET", "/thumbnails/avatar.jpg", http.StatusNotFound, "application/json"},
+		{"GET", "/thumbnails/..%2Fsecret.txt", http.StatusBadRequest, "application/json"},
+		{"GET", "/thumbnails/..%5Csecret.txt", http.StatusBadRequest, "application/json"},
+		{"GET", "/thumbnails/%2E%2E", http.StatusBadRequest, "application/json"},
+		{"GET", "/thumbnails/", http.StatusBadRequest, "application/json"},
+		{"POST", "/thumbnails/thumb.webp", http.StatusMethodNotAllowed, "application/json"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ThumbnailHandler(w, httptest.NewRequest(test.method, test.target, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s returned %d; want %d", test.method, test.target, w.Code, test.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s %s Content-Type = %q; want %q", test.method, test.target, ct, test.contentType)
+		}
+	}
+}
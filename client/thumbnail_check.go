@@ -0,0 +1,116 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+)
+
+// ThumbnailMinSide is the width and height in pixels which the thumbnail of public asset should have at least, the add-on
+// asks for "JPG or PNG format, ensuring at least 1024x1024 pixels" in upload.check_missing_data and offers 1024 as
+// "minimum for public" in autothumb.thumbnail_resolutions. It is only a warning, the rule of the server is not known
+// and e.g. brush icons are smaller. Private assets can have smaller thumbnails.
+var ThumbnailMinSide = 1024
+
+// ThumbnailConvertAboveSize is the size of PNG thumbnail which is converted to JPEG to upload faster.
+// It is not a limit of the server, no size limit of thumbnails is documented, so the thumbnail which stays larger is uploaded as it is.
+var ThumbnailConvertAboveSize int64 = 10 * 1024 * 1024
+
+const thumbnailJPEGQuality = 85
+
+// checkUploadThumbnail verifies the thumbnail is PNG or JPEG, which the server accepts. Thumbnail of public asset
+// smaller than ThumbnailMinSide is uploaded with a warning for the user. PNG larger than ThumbnailConvertAboveSize
+// is converted to JPEG in the tempDir of the export, its path is returned with converted=true.
+func checkUploadThumbnail(path, tempDir string, public bool) (uploadPath string, converted bool, warning string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false, "", fmt.Errorf("thumbnail %s: %w", path, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, "", fmt.Errorf("thumbnail %s: %w", path, err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", false, "", fmt.Errorf("thumbnail %s is not a valid image, only PNG and JPEG are supported: %w", path, err)
+	}
+	if format != "png" && format != "jpeg" {
+		return "", false, "", fmt.Errorf("thumbnail %s is %s, only PNG and JPEG are supported", path, format)
+	}
+	if public && (config.Width < ThumbnailMinSide || config.Height < ThumbnailMinSide) {
+		warning = fmt.Sprintf("Thumbnail is %dx%d pixels, public assets should have at least %dx%d", config.Width, config.Height, ThumbnailMinSide, ThumbnailMinSide)
+	}
+	if format != "png" || info.Size() <= ThumbnailConvertAboveSize {
+		return path, false, warning, nil
+	}
+
+	uploadPath, err = convertThumbnailToJPEG(file, tempDir, info.Size())
+	if err != nil {
+		BKLog.Printf("%s Thumbnail %s has %.1fMB, uploading it as PNG: %v", EmoWarning, path, megabytes(info.Size()), err)
+		return path, false, warning, nil
+	}
+	return uploadPath, true, warning, nil
+}
+
+// convertThumbnailToJPEG encodes the PNG as JPEG into a new file in tempDir, fails if the JPEG is not smaller than the PNG.
+func convertThumbnailToJPEG(file *os.File, tempDir string, pngSize int64) (string, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(file.Name())
+	out, err := os.CreateTemp(tempDir, base[:len(base)-len(filepath.Ext(base))]+"_*.jpg")
+	if err != nil {
+		return "", err
+	}
+	jpegPath := out.Name()
+	err = jpeg.Encode(out, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(jpegPath)
+		return "", err
+	}
+
+	info, err := os.Stat(jpegPath)
+	if err != nil {
+		os.Remove(jpegPath)
+		return "", err
+	}
+	if info.Size() >= pngSize {
+		os.Remove(jpegPath)
+		return "", fmt.Errorf("JPEG would have %.1fMB", megabytes(info.Size()))
+	}
+	return jpegPath, nil
+}
+
+func megabytes(size int64) float64 {
+	return float64(size) / 1024 / 1024
+}
@@ -0,0 +1,129 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestImage(t *testing.T, name string, width, height int, noisy bool) string {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			c := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+			if noisy { // compresses badly in PNG, is smoothed out by JPEG
+				c.R += uint8(random.Intn(4))
+				c.G += uint8(random.Intn(4))
+				c.B += uint8(random.Intn(4))
+			}
+			img.Set(x, y, c)
+		}
+	}
+	path := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	switch filepath.Ext(name) {
+	case ".png":
+		err = png.Encode(file, img)
+	case ".jpg":
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 100})
+	case ".gif":
+		err = gif.Encode(file, img, nil)
+	default:
+		_, err = file.WriteString("not an image")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckUploadThumbnail(t *testing.T) {
+	defer func(size int64, side int) { ThumbnailConvertAboveSize, ThumbnailMinSide = size, side }(ThumbnailConvertAboveSize, ThumbnailMinSide)
+	ThumbnailConvertAboveSize, ThumbnailMinSide = 4096, 64
+
+	tests := []struct {
+		name          string
+		path          string
+		public        bool
+		wantConverted bool
+		wantWarning   string
+		wantErr       string
+	}{
+		{"png", writeTestImage(t, "thumb.png", 64, 64, false), true, false, "", ""},
+		{"jpeg", writeTestImage(t, "thumb.jpg", 64, 64, false), true, false, "", ""},
+		{"gif", writeTestImage(t, "thumb.gif", 64, 64, false), true, false, "", "is gif, only PNG and JPEG"},
+		{"not an image", writeTestImage(t, "thumb.txt", 0, 0, false), true, false, "", "not a valid image"},
+		{"missing", filepath.Join(t.TempDir(), "missing.png"), true, false, "", "no such file"},
+		{"small public", writeTestImage(t, "small.png", 128, 32, false), true, false, "is 128x32 pixels, public assets should have at least 64x64", ""},
+		{"small private", writeTestImage(t, "small.png", 128, 32, false), false, false, "", ""},
+		{"large png", writeTestImage(t, "big.png", 96, 96, true), true, true, "", ""},
+		{"large jpeg", writeTestImage(t, "big.jpg", 128, 128, true), true, false, "", ""},
+	}
+	for _, test := range tests {
+		tempDir := t.TempDir()
+		path, converted, warning, err := checkUploadThumbnail(test.path, tempDir, test.public)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v; want containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if (test.wantWarning == "") != (warning == "") || !strings.Contains(warning, test.wantWarning) {
+			t.Errorf("%s: warning = %q; want %q", test.name, warning, test.wantWarning)
+		}
+		if converted != test.wantConverted {
+			t.Errorf("%s: converted = %t; want %t", test.name, converted, test.wantConverted)
+		}
+		if !converted && path != test.path {
+			t.Errorf("%s: path = %s; want unchanged %s", test.name, path, test.path)
+		}
+		if converted {
+			if filepath.Dir(path) != tempDir {
+				t.Errorf("%s: converted to %s; want in the export temp dir %s", test.name, path, tempDir)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			_, format, err := image.DecodeConfig(file)
+			file.Close()
+			if format != "jpeg" || err != nil {
+				t.Errorf("%s: converted to %q (%v); want jpeg", test.name, format, err)
+			}
+		}
+	}
+}
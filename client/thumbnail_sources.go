@@ -0,0 +1,157 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const thumbnailSourcesFilename = "thumbnail_sources.json" // index of downloaded thumbnails in GetSafeTempPath()
+
+// ThumbnailSourcesSaveDelay batches the index writes of one search page into a single write.
+var ThumbnailSourcesSaveDelay = 2 * time.Second
+
+// ThumbnailSource is the URL from which the thumbnail file was downloaded and the ETag of the response.
+type ThumbnailSource struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag,omitempty"`
+}
+
+// ThumbnailSourceIndex maps the paths of downloaded thumbnails to their sources. Server filenames contain UUID
+// of the file, so URL which differs only in the query maps to the same file, e.g. after the server regenerated the thumbnail.
+// Index is persisted into a JSON sidecar, entries of thumbnails removed from disk are dropped by the pruning job.
+type ThumbnailSourceIndex struct {
+	mux         sync.Mutex
+	path        string
+	sources     map[string]ThumbnailSource
+	savePending bool
+}
+
+var ThumbnailSources = &ThumbnailSourceIndex{sources: map[string]ThumbnailSource{}}
+
+// LoadThumbnailSources reads the index from the safe temp path. Missing or corrupted index means empty index.
+func LoadThumbnailSources() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return ThumbnailSources.load(filepath.Join(tempDir, thumbnailSourcesFilename))
+}
+
+func (x *ThumbnailSourceIndex) load(path string) error {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	x.path = path
+	x.sources = map[string]ThumbnailSource{}
+	index, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(index, &x.sources); err != nil {
+		x.sources = map[string]ThumbnailSource{}
+		return fmt.Errorf("corrupted thumbnail index %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes the index, must be called with x.mux locked.
+func (x *ThumbnailSourceIndex) save() error {
+	if x.path == "" {
+		return nil
+	}
+	index, err := json.Marshal(x.sources)
+	if err != nil {
+		return err
+	}
+	tmpPath := x.path + ".tmp"
+	if err := os.WriteFile(tmpPath, index, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, x.path)
+}
+
+// scheduleSave writes the index after ThumbnailSourcesSaveDelay, must be called with x.mux locked.
+func (x *ThumbnailSourceIndex) scheduleSave() {
+	if x.path == "" || x.savePending {
+		return
+	}
+	x.savePending = true
+	time.AfterFunc(ThumbnailSourcesSaveDelay, func() {
+		x.mux.Lock()
+		defer x.mux.Unlock()
+		x.savePending = false
+		if err := x.save(); err != nil {
+			BKLog.Printf("%s Failed to save thumbnail index: %v", EmoWarning, err)
+		}
+	})
+}
+
+// Get returns the source of the thumbnail file.
+func (x *ThumbnailSourceIndex) Get(imagePath string) (ThumbnailSource, bool) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	source, ok := x.sources[imagePath]
+	return source, ok
+}
+
+// Set records the source of the downloaded thumbnail file.
+func (x *ThumbnailSourceIndex) Set(imagePath string, source ThumbnailSource) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	if x.sources[imagePath] == source {
+		return
+	}
+	x.sources[imagePath] = source
+	x.scheduleSave()
+}
+
+// Prune drops the entries of thumbnails which are not on disk anymore, returns the number of dropped entries.
+func (x *ThumbnailSourceIndex) Prune() int {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	dropped := 0
+	for imagePath := range x.sources {
+		if _, err := os.Stat(imagePath); errors.Is(err, os.ErrNotExist) {
+			delete(x.sources, imagePath)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		if err := x.save(); err != nil {
+			BKLog.Printf("%s Failed to save thumbnail index: %v", EmoWarning, err)
+		}
+	}
+	return dropped
+}
+
+// isStale reports whether the thumbnail on disk was downloaded from a different URL than the task has.
+// Thumbnails downloaded before the index existed are considered fresh.
+func (x *ThumbnailSourceIndex) isStale(imagePath, url string) (ThumbnailSource, bool) {
+	source, ok := x.Get(imagePath)
+	return source, ok && source.URL != url
+}
@@ -0,0 +1,166 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThumbnailSourceIndex(t *testing.T) {
+	defer func(d time.Duration) { ThumbnailSourcesSaveDelay = d }(ThumbnailSourcesSaveDelay)
+	ThumbnailSourcesSaveDelay = time.Millisecond
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, thumbnailSourcesFilename)
+	kept, removed := filepath.Join(dir, "kept.webp"), filepath.Join(dir, "removed.webp")
+	for _, path := range []string{kept, removed} {
+		if err := os.WriteFile(path, []byte("thumb"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := &ThumbnailSourceIndex{}
+	if err := index.load(indexPath); err != nil {
+		t.Fatalf("load() of missing index failed: %v", err)
+	}
+	index.Set(kept, ThumbnailSource{URL: "https://cdn.blenderkit.com/kept.webp?1", ETag: `"v1"`})
+	index.Set(removed, ThumbnailSource{URL: "https://cdn.blenderkit.com/removed.webp"})
+	deadline := time.Now().Add(time.Second)
+	for {
+		reloaded := &ThumbnailSourceIndex{}
+		if err := reloaded.load(indexPath); err == nil && len(reloaded.sources) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("index was not saved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if source, stale := index.isStale(kept, "https://cdn.blenderkit.com/kept.webp?2"); !stale || source.ETag != `"v1"` {
+		t.Errorf("isStale() with changed query = %+v, %t; want stale with ETag", source, stale)
+	}
+	if _, stale := index.isStale(kept, "https://cdn.blenderkit.com/kept.webp?1"); stale {
+		t.Error("isStale() with recorded URL is stale")
+	}
+	if _, stale := index.isStale(filepath.Join(dir, "unknown.webp"), "https://cdn.blenderkit.com/unknown.webp"); stale {
+		t.Error("isStale() of thumbnail not in the index is stale")
+	}
+
+	os.Remove(removed)
+	if dropped := index.Prune(); dropped != 1 {
+		t.Errorf("Prune() dropped %d entries; want 1", dropped)
+	}
+	reloaded := &ThumbnailSourceIndex{}
+	if err := reloaded.load(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Get(removed); ok || len(reloaded.sources) != 1 {
+		t.Errorf("index after Prune() = %v; want only %s", reloaded.sources, kept)
+	}
+
+	os.WriteFile(indexPath, []byte("{corrupted"), 0o600)
+	if err := reloaded.load(indexPath); err == nil || len(reloaded.sources) != 0 {
+		t.Errorf("load() of corrupted index = %v, %v; want error and empty index", reloaded.sources, err)
+	}
+}
+
+func TestDownloadThumbnailRevalidates(t *testing.T) {
+	var mux sync.Mutex
+	content, etag := "thumb v1", `"v1"`
+	var requests []string // If-None-Match of the requests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		requests = append(requests, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	defer func(c *http.Client, x *ThumbnailSourceIndex) { ClientSmallThumbs, ThumbnailSources = c, x }(ClientSmallThumbs, ThumbnailSources)
+	ClientSmallThumbs, ThumbnailSources = server.Client(), &ThumbnailSourceIndex{sources: map[string]ThumbnailSource{}}
+
+	imagePath := filepath.Join(t.TempDir(), "thumb_d5368c9d-092e-4319-afe1-dd765de6da01.webp")
+	download := func(query string) string {
+		t.Helper()
+		task := NewTask(DownloadThumbnailData{ImagePath: imagePath, ImageURL: server.URL + "/thumb_d5368c9d-092e-4319-afe1-dd765de6da01.webp" + query, ThumbnailType: "small"}, 671, "thumb", "thumbnail_download")
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		DownloadThumbnail(task, wg)
+		for {
+			select {
+			case f := <-TaskFinishCh:
+				if f.AppID == 671 {
+					return f.Message
+				}
+			case e := <-TaskErrorCh:
+				if e.AppID == 671 {
+					t.Fatalf("thumbnail download failed: %v", e.Error)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("thumbnail task not finished")
+			}
+		}
+	}
+	onDisk := func(expected string) {
+		t.Helper()
+		if actual, _ := os.ReadFile(imagePath); string(actual) != expected {
+			t.Errorf("thumbnail on disk = %q; want %q", actual, expected)
+		}
+	}
+
+	if msg := download("?t=1"); msg != "thumbnail downloaded" {
+		t.Errorf("first download: %q", msg)
+	}
+	if msg := download("?t=1"); msg != "thumbnail on disk" {
+		t.Errorf("same URL: %q; want thumbnail on disk", msg)
+	}
+	if msg := download("?t=2"); msg != "thumbnail on disk is fresh" {
+		t.Errorf("changed query, same ETag: %q; want revalidated", msg)
+	}
+	onDisk("thumb v1")
+
+	mux.Lock()
+	content, etag = "thumb v2", `"v2"`
+	mux.Unlock()
+	if msg := download("?t=3"); msg != "thumbnail downloaded" {
+		t.Errorf("changed query, regenerated thumbnail: %q; want downloaded", msg)
+	}
+	onDisk("thumb v2")
+	if source, _ := ThumbnailSources.Get(imagePath); source.ETag != `"v2"` {
+		t.Errorf("recorded ETag = %q; want %q", source.ETag, `"v2"`)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	expected := []string{"", `"v1"`, `"v1"`} // the third request sends the ETag of the file on disk
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("If-None-Match of requests = %q; want %q", requests, expected)
+	}
+}
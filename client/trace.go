@@ -0,0 +1,166 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const SlowRequestThreshold = 10 * time.Second // Successful requests slower than this are logged with their timings
+
+var TraceRequests atomic.Bool // Set by --trace_requests flag and /settings/update, logs timings of every traced request
+
+// RequestTrace collects DNS, connect, TLS and first byte timings of a single HTTP request.
+// Callbacks of httptrace can run concurrently (e.g. parallel dials), so all fields are guarded by mutex.
+type RequestTrace struct {
+	mu           sync.Mutex
+	name         string
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+	remoteAddr   string
+}
+
+// NewRequestTrace creates a new trace, name is used in the summary, e.g. "search".
+func NewRequestTrace(name string) *RequestTrace {
+	return &RequestTrace{name: name}
+}
+
+// Attach returns shallow copy of the request with httptrace hooks recording into this RequestTrace.
+func (t *RequestTrace) Attach(req *http.Request) *http.Request {
+	t.mu.Lock()
+	t.start = time.Now()
+	t.mu.Unlock()
+	record := func(field *time.Time) {
+		t.mu.Lock()
+		if field.IsZero() {
+			*field = time.Now()
+		}
+		t.mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { record(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { record(&t.dnsDone) },
+		ConnectStart:      func(string, string) { record(&t.connectStart) },
+		ConnectDone:       func(string, string, error) { record(&t.connectDone) },
+		TLSHandshakeStart: func() { record(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { record(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.remoteAddr = info.Conn.RemoteAddr().String()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { record(&t.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// Summary returns one line breakdown of the request timings, unfinished phases are marked as such.
+func (t *RequestTrace) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	phase := func(name string, start, done time.Time) string {
+		switch {
+		case start.IsZero():
+			return name + "=-"
+		case done.IsZero():
+			return name + "=unfinished"
+		default:
+			return fmt.Sprintf("%s=%v", name, done.Sub(start).Round(time.Millisecond))
+		}
+	}
+	parts := []string{
+		phase("dns", t.dnsStart, t.dnsDone),
+		phase("connect", t.connectStart, t.connectDone),
+		phase("tls", t.tlsStart, t.tlsDone),
+		phase("first_byte", t.start, t.firstByte),
+		fmt.Sprintf("total=%v", time.Since(t.start).Round(time.Millisecond)),
+	}
+	if t.reused {
+		parts = append(parts, "reused connection")
+	}
+	if t.remoteAddr != "" {
+		parts = append(parts, "remote="+t.remoteAddr)
+	}
+	return fmt.Sprintf("%s request timings: %s", t.name, strings.Join(parts, " "))
+}
+
+// Finish logs the timings of a successful request if it was slow or if --trace_requests is enabled.
+func (t *RequestTrace) Finish() {
+	t.mu.Lock()
+	elapsed := time.Since(t.start)
+	t.mu.Unlock()
+	if elapsed > SlowRequestThreshold {
+		BKLog.Printf("%s Slow %s", EmoWarning, t.Summary())
+		return
+	}
+	if TraceRequests.Load() {
+		BKLog.Printf("%s %s", EmoNetwork, t.Summary())
+	}
+}
+
+// Wrap attaches the trace summary to the error, so it can be later extracted by TraceDetail().
+func (t *RequestTrace) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if TraceRequests.Load() {
+		BKLog.Printf("%s %s", EmoNetwork, t.Summary())
+	}
+	return &TracedError{Err: err, Trace: t}
+}
+
+// TracedError is an error carrying the timings of the request which failed.
+type TracedError struct {
+	Err   error
+	Trace *RequestTrace
+}
+
+func (e *TracedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TracedError) Unwrap() error {
+	return e.Err
+}
+
+// TraceDetail returns the request timings from the error chain, or empty string if there is none.
+// Intended to be used as MessageDetailed of TaskError.
+func TraceDetail(err error) string {
+	var traced *TracedError
+	if errors.As(err, &traced) {
+		return traced.Trace.Summary()
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	TransferRateWindow    = 5 * time.Second        // bytes transferred within this window give the current speed
+	TransferRateMinWindow = 500 * time.Millisecond // speed is not reported until the window is at least this long
+	TransferRateSmoothing = 0.3                    // weight of the current speed in the smoothed one
+	TransferRateSample    = 100 * time.Millisecond // reads closer to the last sample are not recorded, bounds the samples
+)
+
+type transferSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// transferRate estimates speed and remaining time of a download or upload from its transferred bytes.
+// Speed over the rolling TransferRateWindow is smoothed by exponential moving average, so the ETA does not jump with every read.
+// Not safe for concurrent use, each transfer has its own.
+type transferRate struct {
+	samples []transferSample
+	speed   float64 // smoothed bytes per second, 0 until known
+}
+
+// update records that done of total bytes were transferred at the time.
+// Returns smoothed speed in bytes per second and estimated seconds left, both 0 when not yet known.
+func (r *transferRate) update(at time.Time, done, total int64) (speedBPS float64, etaSeconds int) {
+	if n := len(r.samples); n > 0 && at.Sub(r.samples[n-1].at) < TransferRateSample {
+		return r.speed, r.eta(done, total)
+	}
+	r.samples = append(r.samples, transferSample{at: at, bytes: done})
+	for len(r.samples) > 2 && at.Sub(r.samples[1].at) >= TransferRateWindow { // keep one sample at or beyond the window
+		r.samples = r.samples[1:]
+	}
+	first := r.samples[0]
+	elapsed := at.Sub(first.at)
+	if elapsed < TransferRateMinWindow {
+		return r.speed, r.eta(done, total)
+	}
+
+	current := float64(done-first.bytes) / elapsed.Seconds()
+	if r.speed == 0 {
+		r.speed = current
+	} else {
+		r.speed = TransferRateSmoothing*current + (1-TransferRateSmoothing)*r.speed
+	}
+	return r.speed, r.eta(done, total)
+}
+
+func (r *transferRate) eta(done, total int64) int {
+	if r.speed <= 0 || total <= done {
+		return 0
+	}
+	return int(math.Ceil(float64(total-done) / r.speed))
+}
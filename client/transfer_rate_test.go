@@ -0,0 +1,64 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferRate(t *testing.T) {
+	start := time.Now()
+	var rate transferRate
+	if speed, eta := rate.update(start, 0, 10_000_000); speed != 0 || eta != 0 {
+		t.Errorf("first sample: speed %v, eta %d; want both unknown", speed, eta)
+	}
+	if speed, _ := rate.update(start.Add(200*time.Millisecond), 200_000, 10_000_000); speed != 0 {
+		t.Errorf("speed %v before TransferRateMinWindow; want unknown", speed)
+	}
+
+	// 1 MB/s steady
+	var speed float64
+	var eta int
+	for i := 1; i <= 20; i++ {
+		speed, eta = rate.update(start.Add(time.Duration(i)*time.Second), int64(i)*1_000_000, 30_000_000)
+	}
+	if speed < 990_000 || speed > 1_010_000 {
+		t.Errorf("steady speed = %v; want 1 MB/s", speed)
+	}
+	if eta != 10 {
+		t.Errorf("eta = %d; want 10 seconds for 10 MB left", eta)
+	}
+
+	// sudden stall only lowers the smoothed speed gradually
+	speed, _ = rate.update(start.Add(21*time.Second), 20_000_000, 30_000_000)
+	if speed < 500_000 || speed >= 990_000 {
+		t.Errorf("speed after one stalled second = %v; want smoothed between 0.5 and 1 MB/s", speed)
+	}
+
+	// reads closer than TransferRateSample are not recorded
+	samples := len(rate.samples)
+	rate.update(start.Add(21*time.Second+10*time.Millisecond), 20_010_000, 30_000_000)
+	if len(rate.samples) != samples {
+		t.Errorf("sample %v after the last one was recorded", 10*time.Millisecond)
+	}
+	if len(rate.samples) > int(TransferRateWindow/time.Second)+2 {
+		t.Errorf("kept %d samples; want only those within %v", len(rate.samples), TransferRateWindow)
+	}
+}
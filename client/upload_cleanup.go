@@ -0,0 +1,102 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// KeepUploadTemp keeps the packed blend and data.json of uploads in the export TempDir, set by flag for debugging.
+var KeepUploadTemp = false
+
+// Removal of a file which is still open fails on Windows with ERROR_SHARING_VIOLATION. Upload closes the packed file
+// before the cleanup, but antivirus or the indexer may still hold it for a moment, so the removal is retried.
+var (
+	UploadTempRemoveAttempts   = 5
+	UploadTempRemoveRetryDelay = 200 * time.Millisecond
+)
+
+// removeFile removes the file, replaced in tests to simulate locked files.
+var removeFile = os.Remove
+
+// packedUploadFiles returns the files written into the export TempDir by PackBlendFile().
+// HDRs are uploaded directly from HDRFilepath of the user, so nothing is packed for them.
+func packedUploadFiles(data AssetUploadRequestData, metadata AssetsCreateResponse, isMainFileUpload bool) []string {
+	if !isMainFileUpload || metadata.AssetType == "hdr" || data.ExportData.TempDir == "" {
+		return nil
+	}
+	assetBaseID := data.ExportData.AssetBaseID
+	if assetBaseID == "" {
+		assetBaseID = metadata.AssetBaseID
+	}
+	return []string{
+		filepath.Join(data.ExportData.TempDir, assetBaseID+".blend"),
+		filepath.Join(data.ExportData.TempDir, "data.json"),
+		packReportPath(data.ExportData.TempDir),
+	}
+}
+
+// cleanupPackedUpload removes the packed files after the upload task ends, unless KeepUploadTemp is set.
+func cleanupPackedUpload(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if KeepUploadTemp {
+		BKLog.Printf("%s Keeping packed upload files: %v", EmoUpload, paths)
+		return
+	}
+	freed := cleanupUploadTemp(paths)
+	BKLog.Printf("%s Removed packed upload files, freed %s", EmoUpload, formatBytes(freed))
+}
+
+// cleanupUploadTemp removes the files and returns the number of bytes freed. Missing files are skipped,
+// files which cannot be removed even after UploadTempRemoveAttempts are logged and left on disk.
+func cleanupUploadTemp(paths []string) int64 {
+	var freed int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := removeWithRetry(path); err != nil {
+			BKLog.Printf("%s Failed to remove packed upload file: %v", EmoWarning, err)
+			continue
+		}
+		freed += info.Size()
+	}
+	return freed
+}
+
+// removeWithRetry removes the file, retrying while it is locked. Missing file is not an error.
+func removeWithRetry(path string) error {
+	var err error
+	for attempt := 1; attempt <= UploadTempRemoveAttempts; attempt++ {
+		err = removeFile(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if attempt < UploadTempRemoveAttempts {
+			time.Sleep(UploadTempRemoveRetryDelay)
+		}
+	}
+	return fmt.Errorf("removing %s failed after %d attempts: %w", path, UploadTempRemoveAttempts, err)
+}
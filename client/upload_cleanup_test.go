@@ -0,0 +1,122 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPackedUploadFiles(t *testing.T) {
+	data := AssetUploadRequestData{ExportData: AssetUploadExportData{TempDir: "/tmp/export"}}
+	metadata := AssetsCreateResponse{AssetBaseID: "base-id", AssetType: "model"}
+	want := []string{filepath.Join("/tmp/export", "base-id.blend"), filepath.Join("/tmp/export", "data.json"), filepath.Join("/tmp/export", "pack_report.json")}
+	if got := packedUploadFiles(data, metadata, true); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("packedUploadFiles() = %v; want %v", got, want)
+	}
+	if got := packedUploadFiles(data, metadata, false); got != nil {
+		t.Errorf("packedUploadFiles() without main file = %v; want nil", got)
+	}
+	metadata.AssetType = "hdr"
+	if got := packedUploadFiles(data, metadata, true); got != nil {
+		t.Errorf("packedUploadFiles() of HDR = %v; want nil, HDR file of the user must not be removed", got)
+	}
+}
+
+func TestCleanupUploadTempWaitsForFileHandle(t *testing.T) {
+	defer func(remove func(string) error, delay time.Duration) {
+		removeFile, UploadTempRemoveRetryDelay = remove, delay
+	}(removeFile, UploadTempRemoveRetryDelay)
+	UploadTempRemoveRetryDelay = 20 * time.Millisecond
+
+	dir := t.TempDir()
+	blend, datafile := filepath.Join(dir, "base-id.blend"), filepath.Join(dir, "data.json")
+	os.WriteFile(blend, make([]byte, 3000), 0644)
+	os.WriteFile(datafile, make([]byte, 100), 0644)
+	handle, err := os.Open(blend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate Windows, which refuses to remove a file while it is open
+	var mux sync.Mutex
+	open := true
+	errSharingViolation := errors.New("the process cannot access the file because it is being used by another process.")
+	removeFile = func(path string) error {
+		mux.Lock()
+		defer mux.Unlock()
+		if path == blend && open {
+			return errSharingViolation
+		}
+		return os.Remove(path)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond) // upload still reading the file
+		mux.Lock()
+		handle.Close()
+		open = false
+		mux.Unlock()
+	}()
+
+	freed := cleanupUploadTemp([]string{blend, datafile, filepath.Join(dir, "missing.blend")})
+	if freed != 3100 {
+		t.Errorf("freed %d bytes; want 3100", freed)
+	}
+	for _, path := range []string{blend, datafile} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists", path)
+		}
+	}
+}
+
+func TestCleanupUploadTempGivesUp(t *testing.T) {
+	defer func(remove func(string) error, delay time.Duration) {
+		removeFile, UploadTempRemoveRetryDelay = remove, delay
+	}(removeFile, UploadTempRemoveRetryDelay)
+	UploadTempRemoveRetryDelay = time.Millisecond
+	attempts := 0
+	removeFile = func(path string) error {
+		attempts++
+		return errors.New("locked")
+	}
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	os.WriteFile(path, []byte("{}"), 0644)
+	if freed := cleanupUploadTemp([]string{path}); freed != 0 {
+		t.Errorf("freed %d bytes of locked file; want 0", freed)
+	}
+	if attempts != UploadTempRemoveAttempts {
+		t.Errorf("removal attempted %d times; want %d", attempts, UploadTempRemoveAttempts)
+	}
+}
+
+func TestCleanupPackedUploadKeepFlag(t *testing.T) {
+	defer func(keep bool) { KeepUploadTemp = keep }(KeepUploadTemp)
+	KeepUploadTemp = true
+	path := filepath.Join(t.TempDir(), "data.json")
+	os.WriteFile(path, []byte("{}"), 0644)
+	cleanupPackedUpload([]string{path})
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("packed file removed despite KeepUploadTemp: %v", err)
+	}
+}
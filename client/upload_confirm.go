@@ -0,0 +1,149 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Retrying of the final PATCH which marks the asset as uploaded after its files are on S3.
+// The PATCH is idempotent, so it is retried with doubling delay instead of failing the whole upload.
+var (
+	UploadConfirmMaxRetries       = 3
+	UploadConfirmRetryDelay       = 2 * time.Second
+	UploadConfirmBackgroundDelay  = time.Minute // first delay of the background retry once the upload task has finished
+	UploadConfirmBackgroundMaxTry = 10
+)
+
+// ErrUploadConfirmationPending is returned when the files were uploaded, but the asset could not be marked as uploaded.
+var ErrUploadConfirmationPending = errors.New("files uploaded, confirmation pending")
+
+// UploadResult is the result of finished asset_upload task.
+type UploadResult struct {
+	AssetsCreateResponse
+	PackWarnings []PackIssue `json:"pack_warnings,omitempty"`
+}
+
+// UploadConfirmationPendingResult is the result of asset_upload task whose files were uploaded,
+// but the confirmation is left to the background retry which reports as asset_upload_confirmation task.
+type UploadConfirmationPendingResult struct {
+	UploadResult
+	ConfirmationPending bool `json:"confirmation_pending"`
+}
+
+// verificationReturns are the verification statuses from which a reupload of only thumbnail or metadata returns
+// the asset to validators as uploaded, it might have been put to them because of the old thumbnail.
+var verificationReturns = map[string]bool{
+	"on_hold":  true,
+	"deleted":  true,
+	"rejected": true,
+}
+
+// resolveVerificationTransition returns the verification status to which the asset is PATCHed after its files
+// were uploaded. New main file always devalidates the asset to uploaded, reupload of thumbnail or metadata
+// changes only statuses of verificationReturns and keeps the others, e.g. validated.
+func resolveVerificationTransition(prevStatus string, isMainFileUpload bool) (newStatus string, shouldPatch bool) {
+	if isMainFileUpload || verificationReturns[prevStatus] {
+		return "uploaded", true
+	}
+	return prevStatus, false
+}
+
+// setVerificationStatus PATCHes verificationStatus of the asset.
+// Retryable failures (network errors, 5xx) are wrapped in ErrUploadConfirmationPending,
+// other failures return JSON of the error response if available.
+func setVerificationStatus(ctx context.Context, server string, data AssetUploadRequestData, assetID, status string) (json.RawMessage, error) {
+	reqBody, err := json.Marshal(map[string]string{"verificationStatus": status})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/assets/%s/", server, assetID)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.Preferences.APIKey, AddonVersion: data.UploadData.AddonVersion, PlatformVersion: data.UploadData.PlatformVersion, RequestID: data.RequestID})
+
+	resp, err := doAPI(ClientAPI, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUploadConfirmationPending, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg := "asset status update failed"
+		respJSON, respString, respErr := ParseFailedHTTPResponse(resp)
+		if resp.StatusCode >= 500 {
+			return respJSON, fmt.Errorf("%w: %s (%s)", ErrUploadConfirmationPending, msg, resp.Status)
+		}
+		if respErr != nil || respJSON == nil {
+			return nil, fmt.Errorf("%s (%s): failed parsing error response (%v), [URL: %v]", msg, resp.Status, respString, url)
+		}
+		return respJSON, fmt.Errorf("%s (%s)", msg, resp.Status)
+	}
+	return nil, nil
+}
+
+// patchVerificationStatus calls setVerificationStatus, retrying up to maxRetries times with doubling delay.
+// Canceled ctx stops the retrying.
+func patchVerificationStatus(ctx context.Context, server string, data AssetUploadRequestData, assetID, status string, maxRetries int, delay time.Duration) (json.RawMessage, error) {
+	for attempt := 0; ; attempt++ {
+		respJSON, err := setVerificationStatus(ctx, server, data, assetID, status)
+		if !errors.Is(err, ErrUploadConfirmationPending) || attempt >= maxRetries {
+			return respJSON, err
+		}
+		BKLog.Printf("%s Marking asset %s as %s: %v, retrying in %s", EmoWarning, assetID, status, err, delay)
+		select {
+		case <-ctx.Done():
+			return respJSON, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// retryUploadConfirmation keeps confirming the upload in the background after the asset_upload task finished
+// with confirmation pending. The outcome is reported to the add-on as a new asset_upload_confirmation task.
+func retryUploadConfirmation(server string, data AssetUploadRequestData, metadataResp AssetsCreateResponse) {
+	time.Sleep(UploadConfirmBackgroundDelay)
+	respJSON, err := patchVerificationStatus(context.Background(), server, data, metadataResp.ID, "uploaded", UploadConfirmBackgroundMaxTry, UploadConfirmBackgroundDelay)
+
+	taskID := uuid.New().String()
+	sendTask(AddTaskCh, NewTask(data, data.AppID, taskID, "asset_upload_confirmation").WithRequestID(data.RequestID))
+	if err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("marking asset as uploaded: %w", err), Result: respJSON})
+		return
+	}
+	metadataResp.VerificationStatus = "uploaded"
+	UploadHistory.SetVerificationStatus(metadataResp.ID, metadataResp.VerificationStatus)
+	BKLog.Printf("%s Asset %s confirmed as uploaded", EmoUpload, metadataResp.ID)
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Result: metadataResp, Message: "Upload confirmed!"})
+}
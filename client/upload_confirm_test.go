@@ -0,0 +1,208 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// patchServer answers the asset PATCH with failStatus for the first failures requests, then with 200.
+func patchServer(t *testing.T, failures, failStatus int) (*httptest.Server, func() int) {
+	var mux sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" || r.URL.Path != "/api/v1/assets/asset-id/" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		mux.Lock()
+		attempts++
+		attempt := attempts
+		mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if attempt <= failures {
+			w.WriteHeader(failStatus)
+			w.Write([]byte(`{"detail": "failed"}`))
+			return
+		}
+		w.Write([]byte(`{"verificationStatus": "uploaded"}`))
+	}))
+	return server, func() int {
+		mux.Lock()
+		defer mux.Unlock()
+		return attempts
+	}
+}
+
+func TestUploadAssetDataConfirmRetries(t *testing.T) {
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	defer setServer(currentServer())
+	defer func(r int, d time.Duration) { UploadConfirmMaxRetries, UploadConfirmRetryDelay = r, d }(UploadConfirmMaxRetries, UploadConfirmRetryDelay)
+	UploadConfirmMaxRetries, UploadConfirmRetryDelay = 3, time.Millisecond
+
+	tests := []struct {
+		name         string
+		failures     int
+		failStatus   int
+		wantPending  bool
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"succeeds at once", 0, 0, false, false, 1},
+		{"bad gateway twice, then succeeds", 2, http.StatusBadGateway, false, false, 3},
+		{"bad gateway persists", 10, http.StatusBadGateway, true, true, 4},
+		{"bad request is not retried", 10, http.StatusBadRequest, false, true, 1},
+	}
+	for _, test := range tests {
+		server, attempts := patchServer(t, test.failures, test.failStatus)
+		ClientAPI = server.Client()
+		setServer(server.URL)
+
+		_, err := UploadAssetData(context.Background(), currentServer(), nil, AssetUploadRequestData{}, AssetsCreateResponse{ID: "asset-id"}, true, "task")
+		server.Close()
+		if (err != nil) != test.wantErr || errors.Is(err, ErrUploadConfirmationPending) != test.wantPending {
+			t.Errorf("%s: error = %v; want error %t, pending %t", test.name, err, test.wantErr, test.wantPending)
+		}
+		if attempts() != test.wantAttempts {
+			t.Errorf("%s: %d PATCH attempts; want %d", test.name, attempts(), test.wantAttempts)
+		}
+	}
+}
+
+func TestRetryUploadConfirmation(t *testing.T) {
+	server, attempts := patchServer(t, 2, http.StatusBadGateway)
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	defer func(d time.Duration) { UploadConfirmBackgroundDelay = d }(UploadConfirmBackgroundDelay)
+	UploadConfirmBackgroundDelay = time.Millisecond
+	defer func(h *UploadHistoryStore) { UploadHistory = h }(UploadHistory)
+	UploadHistory = &UploadHistoryStore{}
+	UploadHistory.Add(UploadHistoryEntry{TaskID: "upload", AssetID: "asset-id", VerificationStatus: "uploading"})
+
+	go retryUploadConfirmation(currentServer(), AssetUploadRequestData{AppID: 1}, AssetsCreateResponse{ID: "asset-id"})
+
+	var taskID string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case task := <-AddTaskCh:
+			if task.TaskType == "asset_upload_confirmation" {
+				taskID = task.TaskID
+			}
+			continue
+		case f := <-TaskFinishCh:
+			if f.TaskID != taskID {
+				continue
+			}
+			if result, ok := f.Result.(AssetsCreateResponse); !ok || result.VerificationStatus != "uploaded" {
+				t.Errorf("result = %+v; want asset with verificationStatus uploaded", f.Result)
+			}
+		case e := <-TaskErrorCh:
+			t.Fatalf("task %s failed: %v", e.TaskID, e.Error)
+		case <-timeout:
+			t.Fatal("asset_upload_confirmation task not finished")
+		}
+		break
+	}
+	if attempts() != 3 {
+		t.Errorf("%d PATCH attempts; want 3", attempts())
+	}
+	if status := UploadHistory.Recent(1)[0].VerificationStatus; status != "uploaded" {
+		t.Errorf("verification status in history = %q; want uploaded", status)
+	}
+}
+
+func TestResolveVerificationTransition(t *testing.T) {
+	tests := []struct {
+		prevStatus       string
+		isMainFileUpload bool
+		newStatus        string
+		shouldPatch      bool
+	}{
+		// new main file always devalidates
+		{"", true, "uploaded", true},
+		{"uploading", true, "uploaded", true},
+		{"uploaded", true, "uploaded", true},
+		{"validated", true, "uploaded", true},
+		{"ready", true, "uploaded", true},
+		{"on_hold", true, "uploaded", true},
+		{"deleted", true, "uploaded", true},
+		{"rejected", true, "uploaded", true},
+		// thumbnail or metadata returns assets held back by validators, keeps the others
+		{"on_hold", false, "uploaded", true},
+		{"deleted", false, "uploaded", true},
+		{"rejected", false, "uploaded", true},
+		{"", false, "", false},
+		{"uploading", false, "uploading", false},
+		{"uploaded", false, "uploaded", false},
+		{"validated", false, "validated", false},
+		{"ready", false, "ready", false},
+		{"unknown_status", false, "unknown_status", false},
+	}
+	for _, tt := range tests {
+		newStatus, shouldPatch := resolveVerificationTransition(tt.prevStatus, tt.isMainFileUpload)
+		if newStatus != tt.newStatus || shouldPatch != tt.shouldPatch {
+			t.Errorf("resolveVerificationTransition(%q, %t) = %q, %t; want %q, %t", tt.prevStatus, tt.isMainFileUpload, newStatus, shouldPatch, tt.newStatus, tt.shouldPatch)
+		}
+	}
+}
+
+func TestUploadAssetDataKeepsValidated(t *testing.T) {
+	server, attempts := patchServer(t, 0, 0)
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+
+	if _, err := UploadAssetData(context.Background(), currentServer(), nil, AssetUploadRequestData{}, AssetsCreateResponse{ID: "asset-id", VerificationStatus: "validated"}, false, "task"); err != nil {
+		t.Fatalf("metadata upload of validated asset: %v", err)
+	}
+	if attempts() != 0 {
+		t.Errorf("validated asset was PATCHed %d times on metadata upload", attempts())
+	}
+}
+
+func TestPatchVerificationStatusCanceled(t *testing.T) {
+	server, attempts := patchServer(t, 10, http.StatusBadGateway)
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := patchVerificationStatus(ctx, currentServer(), AssetUploadRequestData{}, "asset-id", "uploaded", 10, time.Hour)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("error = %v after %s; want deadline exceeded without waiting for the retry delay", err, time.Since(start))
+	}
+	if attempts() != 1 {
+		t.Errorf("%d PATCH attempts; want 1", attempts())
+	}
+}
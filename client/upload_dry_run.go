@@ -0,0 +1,146 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// DryRunAssetID is the asset ID and asset base ID of a new asset packed in a dry run, the server assigns none.
+const DryRunAssetID = "dry_run"
+
+// DryRunUploadFile is a file which the upload would send.
+type DryRunUploadFile struct {
+	Type     string `json:"type"`
+	FilePath string `json:"file_path"`
+	Size     int64  `json:"size"`
+}
+
+// DryRunUploadResult is the result of asset_upload_dry_run task.
+type DryRunUploadResult struct {
+	Files            []DryRunUploadFile `json:"files"`
+	TotalSize        int64              `json:"total_size"`
+	PackedBlendPath  string             `json:"packed_blend_path,omitempty"` // left in the export TempDir for inspection
+	UploadEstimate   UploadEstimate     `json:"upload_estimate"`
+	PackWarnings     []PackIssue        `json:"pack_warnings,omitempty"`
+	ThumbnailWarning string             `json:"thumbnail_warning,omitempty"` // e.g. thumbnail of public asset below ThumbnailMinSide
+}
+
+// validateUploadMetadata checks locally what the server would reject in the metadata of the asset.
+func validateUploadMetadata(data AssetUploadData) error {
+	if _, ok := data.Parameters.(map[string]interface{}); !ok {
+		return &FieldError{Field: "upload_data.parameters", Message: "must be an object"}
+	}
+	return firstError(validateNotEmpty("upload_data.assetType", data.AssetType), validateNotEmpty("upload_data.displayName", data.DisplayName))
+}
+
+// dryRunMetadata stands in for the server response to the metadata upload, which the dry run skips.
+func dryRunMetadata(data AssetUploadRequestData) AssetsCreateResponse {
+	name := data.UploadData.Name
+	if name == "" { // omitted for reupload
+		name = data.UploadData.DisplayName
+	}
+	metadata := AssetsCreateResponse{
+		AddonVersion:       data.UploadData.AddonVersion,
+		AssetBaseID:        data.ExportData.AssetBaseID,
+		AssetType:          data.UploadData.AssetType,
+		Category:           data.UploadData.Category,
+		Description:        data.UploadData.Description,
+		DisplayName:        data.UploadData.DisplayName,
+		ID:                 data.ExportData.ID,
+		IsFree:             data.UploadData.IsFree,
+		IsPrivate:          data.UploadData.IsPrivate,
+		License:            data.UploadData.License,
+		Name:               name,
+		Parameters:         data.UploadData.Parameters,
+		SourceAppName:      data.UploadData.SourceAppName,
+		SourceAppVersion:   data.UploadData.SourceAppVersion,
+		Tags:               data.UploadData.Tags,
+		VerificationStatus: data.UploadData.VerificationStatus,
+	}
+	if metadata.AssetBaseID == "" {
+		metadata.AssetBaseID, metadata.ID = DryRunAssetID, DryRunAssetID
+	}
+	return metadata
+}
+
+// doDryRunUpload validates the metadata and thumbnail and packs the asset as doAssetUpload does,
+// but creates no metadata on the server and uploads nothing. The packed files are kept, also without KeepUploadTemp.
+func doDryRunUpload(data AssetUploadRequestData) {
+	taskID := uuid.New().String()
+	task := NewTask(data, data.AppID, taskID, "asset_upload_dry_run").WithRequestID(data.RequestID)
+	task.Message = "Upload dry run initiated"
+	sendTask(AddTaskCh, task)
+
+	if err := validateUploadMetadata(data.UploadData); err != nil {
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+		return
+	}
+	var thumbnailWarning string
+	if slices.Contains(data.UploadSet, "THUMBNAIL") {
+		thumbnailPath, converted, warning, err := checkUploadThumbnail(data.ExportData.ThumbnailPath, data.ExportData.TempDir, !data.UploadData.IsPrivate)
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: err})
+			return
+		}
+		thumbnailWarning = warning
+		if converted {
+			defer os.Remove(thumbnailPath)
+			data.ExportData.ThumbnailPath = thumbnailPath
+		}
+	}
+
+	isMainFileUpload := slices.Contains(data.UploadSet, "MAINFILE")
+	metadata := dryRunMetadata(data)
+	sendTaskMessage(&TaskMessageUpdate{AppID: data.AppID, TaskID: taskID, Message: "Packing asset"})
+	files, packReport, err := PackBlendFile(data, metadata, isMainFileUpload)
+	if err != nil {
+		sendTask(TaskErrorCh, packTaskError(data.AppID, taskID, err))
+		return
+	}
+
+	result := DryRunUploadResult{Files: []DryRunUploadFile{}, PackWarnings: packReport.packWarnings(), ThumbnailWarning: thumbnailWarning}
+	if packed := packedUploadFiles(data, metadata, isMainFileUpload); len(packed) > 0 {
+		result.PackedBlendPath = packed[0]
+	}
+	for _, file := range files {
+		info, err := os.Stat(file.FilePath)
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: fmt.Errorf("%s to upload: %w", file.Type, err)})
+			return
+		}
+		result.Files = append(result.Files, DryRunUploadFile{Type: file.Type, FilePath: file.FilePath, Size: info.Size()})
+		result.TotalSize += info.Size()
+	}
+	result.UploadEstimate = UploadSpeed.Estimate(result.TotalSize, data.ConfirmUploadAboveS)
+
+	message := fmt.Sprintf("Dry run: %d files, %.1fMB would be uploaded", len(result.Files), megabytes(result.TotalSize))
+	if len(result.PackWarnings) > 0 {
+		message += fmt.Sprintf(", %d packing warnings", len(result.PackWarnings))
+	}
+	if result.ThumbnailWarning != "" {
+		message += ". " + result.ThumbnailWarning
+	}
+	BKLog.Printf("%s %s", EmoUpload, message)
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: message, MessageDetailed: packIssuesDetail("Packing warnings:", result.PackWarnings), Result: result})
+}
@@ -0,0 +1,123 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dryRunOutcome runs the dry run and returns the result of its task, or the error.
+func dryRunOutcome(t *testing.T, data AssetUploadRequestData) (*DryRunUploadResult, error) {
+	go doDryRunUpload(data)
+	if task := appTask(data.AppID, 5*time.Second); task == nil || task.TaskType != "asset_upload_dry_run" {
+		t.Fatalf("asset_upload_dry_run task not created, got %+v", task)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case f := <-TaskFinishCh:
+			if f.AppID != data.AppID {
+				continue
+			}
+			result, ok := f.Result.(DryRunUploadResult)
+			if !ok {
+				t.Fatalf("result %T, expected DryRunUploadResult", f.Result)
+			}
+			return &result, nil
+		case e := <-TaskErrorCh:
+			if e.AppID != data.AppID {
+				continue
+			}
+			return nil, e.Error
+		case <-timeout:
+			t.Fatal("asset_upload_dry_run task did not finish")
+		}
+	}
+}
+
+func TestDryRunUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("dry run requested %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	thumbnail := writeTestImage(t, "thumbnail.png", 64, 64, false) // below ThumbnailMinSide, only a warning
+	hdr := filepath.Join(t.TempDir(), "sky.exr")
+	if err := os.WriteFile(hdr, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data := AssetUploadRequestData{
+		AppID:      676,
+		DryRun:     true,
+		UploadSet:  []string{"METADATA", "THUMBNAIL", "MAINFILE"},
+		UploadData: AssetUploadData{AssetType: "hdr", DisplayName: "Sky", Parameters: map[string]interface{}{}},
+		ExportData: AssetUploadExportData{ThumbnailPath: thumbnail, HDRFilepath: hdr, TempDir: t.TempDir()},
+	}
+	result, err := dryRunOutcome(t, data)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	thumbnailInfo, _ := os.Stat(thumbnail)
+	want := []DryRunUploadFile{{Type: "thumbnail", FilePath: thumbnail, Size: thumbnailInfo.Size()}, {Type: "blend", FilePath: hdr, Size: 2048}}
+	if len(result.Files) != len(want) || result.Files[0] != want[0] || result.Files[1] != want[1] || result.TotalSize != want[0].Size+want[1].Size {
+		t.Errorf("dry run files %+v, total %d; want %+v", result.Files, result.TotalSize, want)
+	}
+	if !strings.Contains(result.ThumbnailWarning, "64x64 pixels") {
+		t.Errorf("thumbnail warning %q; want the small thumbnail of public asset reported", result.ThumbnailWarning)
+	}
+	if result.PackedBlendPath != "" {
+		t.Errorf("HDR is not packed, got packed blend %q", result.PackedBlendPath)
+	}
+
+	os.Remove(hdr)
+	if _, err := dryRunOutcome(t, data); err == nil {
+		t.Error("dry run accepted missing HDR")
+	}
+
+	data.UploadData.Parameters = nil
+	if _, err := dryRunOutcome(t, data); err == nil {
+		t.Error("dry run accepted metadata without parameters")
+	}
+}
+
+func TestDryRunMetadata(t *testing.T) {
+	data := AssetUploadRequestData{UploadData: AssetUploadData{AssetType: "model", DisplayName: "Chair"}}
+	metadata := dryRunMetadata(data)
+	if metadata.AssetBaseID != DryRunAssetID || metadata.ID != DryRunAssetID || metadata.Name != "Chair" {
+		t.Errorf("new asset packed as %+v", metadata)
+	}
+	if packed := packedUploadFiles(AssetUploadRequestData{ExportData: AssetUploadExportData{TempDir: "/tmp/export"}}, metadata, true); packed[0] != filepath.Join("/tmp/export", DryRunAssetID+".blend") {
+		t.Errorf("packed blend of new asset %v", packed)
+	}
+
+	data.ExportData.AssetBaseID, data.ExportData.ID = "base-id", "asset-id"
+	if metadata := dryRunMetadata(data); metadata.AssetBaseID != "base-id" || metadata.ID != "asset-id" {
+		t.Errorf("reupload packed as %+v", metadata)
+	}
+}
@@ -0,0 +1,205 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const uploadSpeedFilename = "upload_speed.json" // measured upload speed in GetSafeTempPath()
+
+var (
+	UploadSpeedWeight    = 0.3             // weight of the newest measurement in the rolling average
+	UploadSpeedMinBytes  = int64(1 << 20)  // smaller uploads are not measured, their speed is dominated by latency
+	UploadSpeedMinLength = 1 * time.Second // shorter uploads are not measured
+)
+
+// UploadEstimate is the expected duration of the upload, reported before the files are uploaded
+// so the add-on can ask for a confirmation of long uploads.
+type UploadEstimate struct {
+	TotalBytes       int64   `json:"total_bytes"`
+	EstimatedS       float64 `json:"estimated_s"`       // 0 if the upload speed was not measured yet
+	SpeedMbps        float64 `json:"speed_mbps"`        // rolling average of previous uploads
+	ExceedsThreshold bool    `json:"exceeds_threshold"` // estimate is above confirm_upload_above_s of the request
+
+	// FromSourceFiles is set when the estimate is from the sizes of the source files, before packing.
+	// The packed files can be larger or smaller, e.g. when textures are packed in.
+	FromSourceFiles bool `json:"from_source_files"`
+}
+
+// String formats the estimate for the task message, e.g. "Uploading 1.8 GB, estimated 2h 40m at 1.5 Mbps".
+func (e UploadEstimate) String() string {
+	if e.EstimatedS == 0 {
+		return fmt.Sprintf("Uploading %s", formatBytes(e.TotalBytes))
+	}
+	return fmt.Sprintf("Uploading %s, estimated %s at %.1f Mbps", formatBytes(e.TotalBytes), formatEstimate(time.Duration(e.EstimatedS*float64(time.Second))), e.SpeedMbps)
+}
+
+// MetadataUploadResult is the result of asset_metadata_upload task, with the estimate of the files upload which follows.
+type MetadataUploadResult struct {
+	AssetsCreateResponse
+	UploadEstimate *UploadEstimate `json:"upload_estimate,omitempty"` // FromSourceFiles, packing starts after the metadata; nil if only metadata is uploaded
+}
+
+// UploadSpeedStore keeps the rolling average upload speed, persisted so the first upload after a restart has an estimate.
+type UploadSpeedStore struct {
+	mux            sync.Mutex
+	path           string
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	Samples        int     `json:"samples"`
+}
+
+var UploadSpeed = &UploadSpeedStore{}
+
+// LoadUploadSpeed reads the measured upload speed from the safe temp path.
+func LoadUploadSpeed() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return UploadSpeed.load(filepath.Join(tempDir, uploadSpeedFilename))
+}
+
+func (s *UploadSpeedStore) load(path string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.path = path
+	s.BytesPerSecond, s.Samples = 0, 0
+	speed, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(speed, s); err != nil {
+		return fmt.Errorf("corrupted upload speed %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes the speed, must be called with s.mux locked.
+func (s *UploadSpeedStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	speed, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, speed, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// Record adds the upload of size bytes which took elapsed into the rolling average.
+// Uploads below UploadSpeedMinBytes or UploadSpeedMinLength are ignored.
+func (s *UploadSpeedStore) Record(size int64, elapsed time.Duration) {
+	if size < UploadSpeedMinBytes || elapsed < UploadSpeedMinLength {
+		return
+	}
+	speed := float64(size) / elapsed.Seconds()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.Samples == 0 {
+		s.BytesPerSecond = speed
+	} else {
+		s.BytesPerSecond = UploadSpeedWeight*speed + (1-UploadSpeedWeight)*s.BytesPerSecond
+	}
+	s.Samples++
+	if err := s.save(); err != nil {
+		BKLog.Printf("%s Failed to save upload speed: %v", EmoWarning, err)
+	}
+}
+
+// Estimate returns the expected upload of totalBytes. Threshold in seconds above which ExceedsThreshold is set, 0 disables it.
+func (s *UploadSpeedStore) Estimate(totalBytes int64, thresholdS int) UploadEstimate {
+	s.mux.Lock()
+	bytesPerSecond := s.BytesPerSecond
+	s.mux.Unlock()
+	estimate := UploadEstimate{TotalBytes: totalBytes}
+	if bytesPerSecond <= 0 {
+		return estimate
+	}
+	estimate.EstimatedS = float64(totalBytes) / bytesPerSecond
+	estimate.SpeedMbps = bytesPerSecond * 8 / 1e6
+	estimate.ExceedsThreshold = thresholdS > 0 && estimate.EstimatedS > float64(thresholdS)
+	return estimate
+}
+
+// uploadFilesSize returns the total size of the files, missing files are skipped.
+func uploadFilesSize(files []UploadFile) int64 {
+	var total int64
+	for _, file := range files {
+		if info, err := os.Stat(file.FilePath); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// uploadSourceFiles returns the files from which the upload set will be packed, so the upload can be estimated before packing.
+func uploadSourceFiles(data AssetUploadRequestData) []UploadFile {
+	var files []UploadFile
+	for _, fileType := range data.UploadSet {
+		switch fileType {
+		case "THUMBNAIL":
+			files = append(files, UploadFile{Type: "thumbnail", FilePath: data.ExportData.ThumbnailPath})
+		case "MAINFILE":
+			path := data.ExportData.SourceFilePath
+			if data.UploadData.AssetType == "hdr" {
+				path = data.ExportData.HDRFilepath
+			}
+			files = append(files, UploadFile{Type: "blend", FilePath: path})
+		}
+	}
+	return files
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", megabytes(size))
+	default:
+		return fmt.Sprintf("%.0f KB", float64(size)/(1<<10))
+	}
+}
+
+// formatEstimate formats the duration rounded to minutes like "2h 40m", shorter than a minute as "45s".
+func formatEstimate(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Round(time.Second).Seconds()))
+	}
+	d = d.Round(time.Minute)
+	hours, minutes := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
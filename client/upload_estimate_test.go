@@ -0,0 +1,116 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUploadSpeedStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), uploadSpeedFilename)
+	store := &UploadSpeedStore{}
+	if err := store.load(path); err != nil {
+		t.Fatalf("load of missing file: %v", err)
+	}
+	if e := store.Estimate(5<<20, 60); e.EstimatedS != 0 || e.ExceedsThreshold || e.String() != "Uploading 5.0 MB" {
+		t.Errorf("estimate without measured speed = %+v (%s); want size only", e, e)
+	}
+
+	store.Record(100<<10, 10*time.Second)       // too small
+	store.Record(100<<20, 100*time.Millisecond) // too short
+	if store.Samples != 0 {
+		t.Fatalf("got %d samples; small and short uploads must be ignored", store.Samples)
+	}
+	store.Record(20<<20, 10*time.Second) // 2 MiB/s
+	store.Record(10<<20, 10*time.Second) // 1 MiB/s
+	want := UploadSpeedWeight*(1<<20) + (1-UploadSpeedWeight)*(2<<20)
+	if store.Samples != 2 || store.BytesPerSecond != want {
+		t.Errorf("rolling average = %.0f B/s of %d samples; want %.0f B/s of 2", store.BytesPerSecond, store.Samples, want)
+	}
+
+	reloaded := &UploadSpeedStore{}
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.BytesPerSecond != store.BytesPerSecond || reloaded.Samples != 2 {
+		t.Errorf("reloaded %.0f B/s of %d samples; want the saved speed", reloaded.BytesPerSecond, reloaded.Samples)
+	}
+
+	os.WriteFile(path, []byte("{"), 0600)
+	if err := reloaded.load(path); err == nil {
+		t.Errorf("corrupted file loaded without error")
+	}
+}
+
+func TestUploadEstimate(t *testing.T) {
+	store := &UploadSpeedStore{BytesPerSecond: 187500, Samples: 3} // 1.5 Mbps
+	tests := []struct {
+		size      int64
+		threshold int
+		message   string
+		exceeds   bool
+	}{
+		{1932735283, 3600, "Uploading 1.8 GB, estimated 2h 52m at 1.5 Mbps", true},
+		{1932735283, 0, "Uploading 1.8 GB, estimated 2h 52m at 1.5 Mbps", false},
+		{150 << 20, 3600, "Uploading 150.0 MB, estimated 14m at 1.5 Mbps", false},
+		{5 << 20, 10, "Uploading 5.0 MB, estimated 28s at 1.5 Mbps", true},
+		{300 << 10, 10, "Uploading 300 KB, estimated 2s at 1.5 Mbps", false},
+	}
+	for _, tt := range tests {
+		estimate := store.Estimate(tt.size, tt.threshold)
+		if estimate.String() != tt.message || estimate.ExceedsThreshold != tt.exceeds {
+			t.Errorf("Estimate(%d, %d) = %q, exceeds %t; want %q, exceeds %t", tt.size, tt.threshold, estimate, estimate.ExceedsThreshold, tt.message, tt.exceeds)
+		}
+	}
+}
+
+func TestUploadSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	thumbnail, blend, hdr := filepath.Join(dir, "thumb.jpg"), filepath.Join(dir, "asset.blend"), filepath.Join(dir, "sky.exr")
+	os.WriteFile(thumbnail, make([]byte, 100), 0644)
+	os.WriteFile(blend, make([]byte, 1000), 0644)
+	os.WriteFile(hdr, make([]byte, 5000), 0644)
+	export := AssetUploadExportData{ThumbnailPath: thumbnail, SourceFilePath: blend, HDRFilepath: hdr}
+
+	tests := []struct {
+		assetType string
+		uploadSet []string
+		want      []string
+		size      int64
+	}{
+		{"model", []string{"METADATA"}, nil, 0},
+		{"model", []string{"METADATA", "THUMBNAIL", "MAINFILE"}, []string{thumbnail, blend}, 1100},
+		{"hdr", []string{"MAINFILE"}, []string{hdr}, 5000},
+	}
+	for _, tt := range tests {
+		data := AssetUploadRequestData{UploadData: AssetUploadData{AssetType: tt.assetType}, ExportData: export, UploadSet: tt.uploadSet}
+		files := uploadSourceFiles(data)
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.FilePath)
+		}
+		if !reflect.DeepEqual(paths, tt.want) || uploadFilesSize(files) != tt.size {
+			t.Errorf("%s %v: files %v of %d B; want %v of %d B", tt.assetType, tt.uploadSet, paths, uploadFilesSize(files), tt.want, tt.size)
+		}
+	}
+}
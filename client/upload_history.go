@@ -0,0 +1,210 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const uploadHistoryFilename = "upload_history.json" // recent asset uploads in GetSafeTempPath()
+
+// UploadHistoryMaxEntries is how many recent uploads are kept, older ones are dropped.
+var UploadHistoryMaxEntries = 200
+
+// UploadHistoryEntry is the outcome of one asset_upload task.
+type UploadHistoryEntry struct {
+	TaskID             string    `json:"task_id"`
+	AssetName          string    `json:"asset_name"`
+	AssetType          string    `json:"asset_type"`
+	AssetBaseID        string    `json:"asset_base_id"`
+	AssetID            string    `json:"asset_id"`
+	UploadSet          []string  `json:"upload_set"` // what the add-on asked to upload, e.g. METADATA, THUMBNAIL, MAINFILE
+	Files              []string  `json:"files"`      // types of the uploaded files, e.g. blend, thumbnail
+	Status             string    `json:"status"`     // "uploaded" or "error"
+	VerificationStatus string    `json:"verification_status"`
+	Error              string    `json:"error,omitempty"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+// UploadHistoryStore keeps recent uploads newest first, persisted in a JSON file so they survive restarts of Blender and the Client.
+type UploadHistoryStore struct {
+	mux     sync.Mutex
+	path    string
+	entries []UploadHistoryEntry
+}
+
+var UploadHistory = &UploadHistoryStore{}
+
+// LoadUploadHistory reads the upload history from the safe temp path.
+func LoadUploadHistory() error {
+	tempDir, err := GetSafeTempPath()
+	if err != nil {
+		return err
+	}
+	return UploadHistory.load(filepath.Join(tempDir, uploadHistoryFilename))
+}
+
+func (h *UploadHistoryStore) load(path string) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.path = path
+	h.entries = nil
+	history, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(history, &h.entries); err != nil {
+		return fmt.Errorf("corrupted upload history %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes the history, must be called with h.mux locked.
+func (h *UploadHistoryStore) save() error {
+	if h.path == "" {
+		return nil
+	}
+	history, err := json.Marshal(h.entries)
+	if err != nil {
+		return err
+	}
+	tmpPath := h.path + ".tmp"
+	if err := os.WriteFile(tmpPath, history, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, h.path)
+}
+
+// Add records the outcome of an upload as the newest entry.
+func (h *UploadHistoryStore) Add(entry UploadHistoryEntry) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.entries = append([]UploadHistoryEntry{entry}, h.entries...)
+	if len(h.entries) > UploadHistoryMaxEntries {
+		h.entries = h.entries[:UploadHistoryMaxEntries]
+	}
+	if err := h.save(); err != nil {
+		BKLog.Printf("%s Failed to save upload history: %v", EmoWarning, err)
+	}
+}
+
+// Recent returns up to limit newest entries.
+func (h *UploadHistoryStore) Recent(limit int) []UploadHistoryEntry {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if limit > len(h.entries) {
+		limit = len(h.entries)
+	}
+	return append([]UploadHistoryEntry{}, h.entries[:limit]...)
+}
+
+// SetVerificationStatus updates all entries of the asset, returns how many were updated.
+func (h *UploadHistoryStore) SetVerificationStatus(assetID, status string) int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	updated := 0
+	for i := range h.entries {
+		if h.entries[i].AssetID == assetID && h.entries[i].VerificationStatus != status {
+			h.entries[i].VerificationStatus = status
+			updated++
+		}
+	}
+	if updated > 0 {
+		if err := h.save(); err != nil {
+			BKLog.Printf("%s Failed to save upload history: %v", EmoWarning, err)
+		}
+	}
+	return updated
+}
+
+// UploadHistoryHandler returns recent uploads: /asset/upload/history?limit=50
+func UploadHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			writeValidationError(w, &FieldError{Field: "limit", Message: fmt.Sprintf("must be a positive number: %q", l)})
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, map[string]interface{}{"uploads": UploadHistory.Recent(limit)})
+}
+
+// UploadRecheckData is expected from the add-on on /asset/upload/recheck.
+type UploadRecheckData struct {
+	AppID           int    `json:"app_id"`
+	APIKey          string `json:"api_key"`
+	AddonVersion    string `json:"addon_version"`
+	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
+	AssetID         string `json:"asset_id"`
+}
+
+// UploadRecheckHandler fetches the current verification status of the uploaded asset from the server
+// and updates it in the upload history. It is blocking, the result is in the response.
+// API documentation: https://www.blenderkit.com/api/v1/docs/#operation/assets_read
+func UploadRecheckHandler(w http.ResponseWriter, r *http.Request) {
+	var data UploadRecheckData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("asset_id", data.AssetID)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	url := fmt.Sprintf("%s/api/v1/assets/%s/", currentServer(), data.AssetID)
+	req, err := http.NewRequestWithContext(r.Context(), "GET", url, nil)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	resp, err := doAPI(ClientAPI, req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "recheck upload: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, fmt.Sprintf("recheck upload: %s (%s)", respString, resp.Status))
+		return
+	}
+	var asset AssetsCreateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&asset); err != nil {
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "recheck upload - decoding response: "+err.Error())
+		return
+	}
+
+	UploadHistory.SetVerificationStatus(data.AssetID, asset.VerificationStatus)
+	writeJSON(w, map[string]string{"asset_id": data.AssetID, "verification_status": asset.VerificationStatus})
+}
@@ -0,0 +1,111 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadHistoryStore(t *testing.T) {
+	defer func(max int) { UploadHistoryMaxEntries = max }(UploadHistoryMaxEntries)
+	UploadHistoryMaxEntries = 3
+
+	path := filepath.Join(t.TempDir(), uploadHistoryFilename)
+	h := &UploadHistoryStore{}
+	if err := h.load(path); err != nil {
+		t.Fatalf("loading missing history: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		h.Add(UploadHistoryEntry{TaskID: fmt.Sprint(i), AssetID: fmt.Sprintf("asset-%d", i%2), VerificationStatus: "uploaded"})
+	}
+	if updated := h.SetVerificationStatus("asset-1", "validated"); updated != 2 {
+		t.Errorf("SetVerificationStatus updated %d entries; want 2", updated)
+	}
+
+	reloaded := &UploadHistoryStore{}
+	if err := reloaded.load(path); err != nil {
+		t.Fatalf("loading history: %v", err)
+	}
+	tests := []struct {
+		limit    int
+		expected string
+	}{
+		{1, "[3:validated]"},
+		{10, "[3:validated 2:uploaded 1:validated]"},
+	}
+	for _, test := range tests {
+		var actual []string
+		for _, entry := range reloaded.Recent(test.limit) {
+			actual = append(actual, entry.TaskID+":"+entry.VerificationStatus)
+		}
+		if fmt.Sprint(actual) != test.expected {
+			t.Errorf("Recent(%d) = %v; want %s", test.limit, actual, test.expected)
+		}
+	}
+}
+
+func TestUploadRecheckHandler(t *testing.T) {
+	const assetID = "4c9c6a0e-6b3c-4b8a-9f55-4e2d1f6c7a11"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/assets/"+assetID+"/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "` + assetID + `", "verificationStatus": "validated"}`))
+	}))
+	defer server.Close()
+
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	defer func(h *UploadHistoryStore) { UploadHistory = h }(UploadHistory)
+	UploadHistory = &UploadHistoryStore{}
+	UploadHistory.Add(UploadHistoryEntry{TaskID: "t", AssetID: assetID, VerificationStatus: "uploaded"})
+
+	tests := []struct {
+		name       string
+		assetID    string
+		wantStatus int
+	}{
+		{"known asset", assetID, http.StatusOK},
+		{"invalid asset_id", "nope", http.StatusBadRequest},
+		{"missing on server", "0c9c6a0e-6b3c-4b8a-9f55-4e2d1f6c7a11", http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		body, _ := json.Marshal(UploadRecheckData{AppID: 1, AssetID: test.assetID})
+		req := httptest.NewRequest("POST", "/asset/upload/recheck", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		UploadRecheckHandler(rr, req)
+		if rr.Code != test.wantStatus {
+			t.Errorf("%s: status %d; want %d (%s)", test.name, rr.Code, test.wantStatus, rr.Body.String())
+		}
+	}
+	if status := UploadHistory.Recent(1)[0].VerificationStatus; status != "validated" {
+		t.Errorf("verification status in history = %q; want validated", status)
+	}
+}
@@ -0,0 +1,213 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batching and retrying of the asset usage reports sent on scene save.
+var (
+	UsageReportWindow     = 10 * time.Second // Reports of the same scene within the window are sent as one
+	UsageReportMaxRetries = 3
+	UsageReportRetryDelay = 5 * time.Second
+)
+
+// AssetUsage is a count of one asset in the scene, as created by download.py/get_asset_usages().
+type AssetUsage struct {
+	Asset        string        `json:"asset"` // asset base ID
+	UsageCount   int           `json:"usageCount"`
+	ProximitySet []interface{} `json:"proximitySet"`
+}
+
+// UsageReport is sent to the server, it is also the part of ReportUsagesData created by the add-on.
+type UsageReport struct {
+	Scene         string       `json:"scene"`      // scene UUID
+	ReportType    string       `json:"reportType"` // "save"
+	AssetUsageSet []AssetUsage `json:"assetusageSet"`
+}
+
+// ReportUsagesData is expected from the add-on on /report_usages.
+type ReportUsagesData struct {
+	AppID           int    `json:"app_id"`
+	APIKey          string `json:"api_key"`
+	AddonVersion    string `json:"addon_version"`
+	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
+	UsageReport
+}
+
+type pendingUsageReport struct {
+	data  ReportUsagesData
+	tasks []*Task
+}
+
+var (
+	pendingUsageReports    = map[string]*pendingUsageReport{} // key is the scene UUID
+	pendingUsageReportsMux sync.Mutex
+)
+
+func ReportUsagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	var data ReportUsagesData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateUUID("scene", data.Scene), validateAssetUsages(data.AssetUsageSet)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if data.ReportType == "" {
+		data.ReportType = "save"
+	}
+
+	taskID := uuid.New().String()
+	go runTask(data.AppID, taskID, func() { ReportUsages(data, taskID) })
+	writeJSON(w, map[string]string{"task_id": taskID})
+}
+
+func validateAssetUsages(usages []AssetUsage) error {
+	if len(usages) == 0 {
+		return &FieldError{Field: "assetusageSet", Message: "must not be empty"}
+	}
+	for i, usage := range usages {
+		if err := validateUUID(fmt.Sprintf("assetusageSet[%d].asset", i), usage.Asset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReportUsages reports which assets are used in the scene, so authors get statistics of their assets.
+// Reports of the same scene sent within UsageReportWindow are merged and sent once, later counts replace earlier ones.
+// All tasks of the merged reports finish with the result of the single request.
+func ReportUsages(data ReportUsagesData, taskID string) {
+	task := NewTask(data, data.AppID, taskID, "report_usages").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	pendingUsageReportsMux.Lock()
+	defer pendingUsageReportsMux.Unlock()
+	if pending, exists := pendingUsageReports[data.Scene]; exists {
+		pending.data = mergeUsageReports(pending.data, data)
+		pending.tasks = append(pending.tasks, task)
+		return
+	}
+	pendingUsageReports[data.Scene] = &pendingUsageReport{data: data, tasks: []*Task{task}}
+	time.AfterFunc(UsageReportWindow, func() { flushUsageReport(data.Scene) })
+}
+
+// mergeUsageReports adds assets of the newer report to the older one, counts from the newer report win.
+// Credentials and versions are taken from the newer report.
+func mergeUsageReports(older, newer ReportUsagesData) ReportUsagesData {
+	merged := newer
+	merged.AssetUsageSet = append([]AssetUsage{}, older.AssetUsageSet...)
+	for _, usage := range newer.AssetUsageSet {
+		replaced := false
+		for i := range merged.AssetUsageSet {
+			if merged.AssetUsageSet[i].Asset == usage.Asset {
+				merged.AssetUsageSet[i] = usage
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.AssetUsageSet = append(merged.AssetUsageSet, usage)
+		}
+	}
+	return merged
+}
+
+func flushUsageReport(scene string) {
+	pendingUsageReportsMux.Lock()
+	pending := pendingUsageReports[scene]
+	delete(pendingUsageReports, scene)
+	pendingUsageReportsMux.Unlock()
+	if pending == nil {
+		return
+	}
+
+	err := sendUsageReport(pending.data, pending.tasks[0])
+	for _, task := range pending.tasks {
+		if err != nil {
+			sendTask(TaskErrorCh, &TaskError{AppID: task.AppID, TaskID: task.TaskID, Error: err})
+			continue
+		}
+		sendTask(TaskFinishCh, &TaskFinish{
+			AppID:   task.AppID,
+			TaskID:  task.TaskID,
+			Message: fmt.Sprintf("Reported usage of %d assets", len(pending.data.AssetUsageSet)),
+		})
+	}
+}
+
+// sendUsageReport posts the report to the server. Requests failing on network or server error are retried.
+// API documentation: https://www.blenderkit.com/api/v1/docs/#operation/usage_report_create
+func sendUsageReport(data ReportUsagesData, task *Task) error {
+	url := currentServer() + "/api/v1/usage_report/"
+	report := data.UsageReport
+	report.AssetUsageSet = make([]AssetUsage, len(data.AssetUsageSet))
+	for i, usage := range data.AssetUsageSet {
+		if usage.ProximitySet == nil { // server expects a list
+			usage.ProximitySet = []interface{}{}
+		}
+		report.AssetUsageSet[i] = usage
+	}
+	reqBody, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("report usages - encoding: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+		if err != nil {
+			return fmt.Errorf("report usages - making request: %w", err)
+		}
+		req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID})
+		resp, err := doAPI(ClientAPI, req)
+		if err == nil {
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+				resp.Body.Close()
+				return nil
+			}
+			_, respString, _ := ParseFailedHTTPResponse(resp)
+			resp.Body.Close()
+			err = fmt.Errorf("report usages: %s (%s)", respString, resp.Status)
+			if resp.StatusCode < 500 {
+				return err
+			}
+		} else {
+			err = fmt.Errorf("report usages - performing request: %w", err)
+		}
+		if attempt >= UsageReportMaxRetries {
+			return err
+		}
+		BKLog.Printf("%s %v, retrying in %s", EmoWarning, err, UsageReportRetryDelay)
+		time.Sleep(UsageReportRetryDelay)
+	}
+}
@@ -0,0 +1,141 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Example request of the add-on on scene save, written after daemon_lib.report_usages(), not captured.
+const exampleUsageReportRequest = `{
+	"scene": "6f9d1a3c-2b1e-4c55-9a60-0d8f3f7b1e42",
+	"reportType": "save",
+	"assetusageSet": [
+		{"asset": "8a1c2f4e-6b3d-4e2a-9f1c-7d5e3b2a1c0f", "usageCount": 3, "proximitySet": []},
+		{"asset": "0b7e9d2c-4a1f-4c3e-8d2b-6f5a4e3d2c1b", "usageCount": 1, "proximitySet": []}
+	],
+	"app_id": 52413,
+	"api_key": "key",
+	"platform_version": "Linux-6.8.0-x86_64-with-glibc2.39",
+	"addon_version": "3.12.1.240610"
+}`
+
+func TestReportUsages(t *testing.T) {
+	var mux sync.Mutex
+	var received []map[string]interface{}
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/usage_report/" {
+			t.Errorf("request %s %s; want POST /api/v1/usage_report/", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		mux.Lock()
+		received = append(received, payload)
+		auth = r.Header.Get("Authorization")
+		mux.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	defer func(d time.Duration) { UsageReportWindow = d }(UsageReportWindow)
+	UsageReportWindow = 100 * time.Millisecond
+
+	var first ReportUsagesData
+	if err := json.Unmarshal([]byte(exampleUsageReportRequest), &first); err != nil {
+		t.Fatal(err)
+	}
+	second := first
+	second.AssetUsageSet = []AssetUsage{
+		{Asset: "8a1c2f4e-6b3d-4e2a-9f1c-7d5e3b2a1c0f", UsageCount: 4}, // saved again with one more copy
+		{Asset: "c3d2e1f0-1a2b-4c3d-8e4f-5a6b7c8d9e0f", UsageCount: 2},
+	}
+	ReportUsages(first, "usage-task-1")
+	ReportUsages(second, "usage-task-2")
+
+	finished := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(finished) < 2 {
+		select {
+		case f := <-TaskFinishCh:
+			if f.TaskID == "usage-task-1" || f.TaskID == "usage-task-2" {
+				finished[f.TaskID] = true
+			}
+		case e := <-TaskErrorCh:
+			t.Fatalf("task %s failed: %v", e.TaskID, e.Error)
+		case <-timeout:
+			t.Fatalf("tasks not finished, got %v", finished)
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("server received %d reports; want 1 batched report", len(received))
+	}
+	got, _ := json.Marshal(received[0])
+	want := `{"assetusageSet":[` +
+		`{"asset":"8a1c2f4e-6b3d-4e2a-9f1c-7d5e3b2a1c0f","proximitySet":[],"usageCount":4},` +
+		`{"asset":"0b7e9d2c-4a1f-4c3e-8d2b-6f5a4e3d2c1b","proximitySet":[],"usageCount":1},` +
+		`{"asset":"c3d2e1f0-1a2b-4c3d-8e4f-5a6b7c8d9e0f","proximitySet":[],"usageCount":2}],` +
+		`"reportType":"save","scene":"6f9d1a3c-2b1e-4c55-9a60-0d8f3f7b1e42"}`
+	if string(got) != want {
+		t.Errorf("payload = %s\nwant %s", got, want)
+	}
+	if auth != "Bearer key" {
+		t.Errorf("Authorization = %q; want Bearer key", auth)
+	}
+}
+
+func TestSendUsageReportRetries(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	defer func(d time.Duration) { UsageReportRetryDelay = d }(UsageReportRetryDelay)
+	UsageReportRetryDelay = time.Millisecond
+
+	data := ReportUsagesData{UsageReport: UsageReport{Scene: "6f9d1a3c-2b1e-4c55-9a60-0d8f3f7b1e42", ReportType: "save"}}
+	if err := sendUsageReport(data, NewTask(nil, 1, "task", "report_usages")); err != nil {
+		t.Errorf("sendUsageReport() = %v; want success after retries", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d; want 3", attempts)
+	}
+}
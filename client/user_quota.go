@@ -0,0 +1,131 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrCodeQuotaExceeded is set on uploads of private assets larger than the remaining private storage of the user.
+const ErrCodeQuotaExceeded = "quota_exceeded"
+
+// UserQuota is the plan and private storage of the user, result of the user_quota task.
+type UserQuota struct {
+	PlanName                string `json:"plan_name"`
+	PrivateStorageUsed      int64  `json:"private_storage_used"`
+	PrivateStorageTotal     int64  `json:"private_storage_total"`
+	PrivateStorageRemaining int64  `json:"private_storage_remaining"`
+	FullPlanExpiry          string `json:"full_plan_expiry,omitempty"` // empty if the user has no full plan
+}
+
+// profileQuota are the fields of the user in the response of /api/v1/me/ describing the plan and private storage.
+type profileQuota struct {
+	CurrentPlanName          string     `json:"currentPlanName"`
+	SumPrivateAssetFilesSize JSONNumber `json:"sumPrivateAssetFilesSize"`
+	RemainingPrivateQuota    JSONNumber `json:"remainingPrivateQuota"`
+	FullPlanExpiration       string     `json:"fullPlanExpiration"`
+}
+
+// parseUserQuota reads the quota from the profile returned by fetchUserProfile, false if the profile has none.
+func parseUserQuota(profile map[string]interface{}) (UserQuota, bool) {
+	raw, err := json.Marshal(profile["user"])
+	if err != nil {
+		return UserQuota{}, false
+	}
+	var fields profileQuota
+	if err := json.Unmarshal(raw, &fields); err != nil || fields.RemainingPrivateQuota == "" {
+		return UserQuota{}, false
+	}
+	used, remaining := fields.SumPrivateAssetFilesSize.Int64(), fields.RemainingPrivateQuota.Int64()
+	return UserQuota{
+		PlanName:                fields.CurrentPlanName,
+		PrivateStorageUsed:      used,
+		PrivateStorageTotal:     used + remaining,
+		PrivateStorageRemaining: remaining,
+		FullPlanExpiry:          fields.FullPlanExpiration,
+	}, true
+}
+
+// emitUserQuota reports the quota from the profile as finished user_quota task, nothing if the profile has no quota.
+func emitUserQuota(appID int, requestID string, profile map[string]interface{}) {
+	quota, ok := parseUserQuota(profile)
+	if !ok {
+		return
+	}
+	task := NewTask(nil, appID, uuid.New().String(), "user_quota").WithRequestID(requestID)
+	task.Result = quota
+	task.Finish(fmt.Sprintf("%s plan, %s of private storage remaining", quota.PlanName, formatBytes(quota.PrivateStorageRemaining)))
+	sendTask(AddTaskCh, task)
+}
+
+// refreshUserQuota fetches the profile again and reports the current quota, e.g. after an upload used some of it.
+func refreshUserQuota(data MinimalTaskData) {
+	task := NewTask(data, data.AppID, uuid.New().String(), "user_quota/refresh").WithRequestID(data.RequestID)
+	profile, taskErr := fetchUserProfile(data, task)
+	if taskErr != nil {
+		BKLog.Printf("%s Failed to refresh user quota: %v", EmoWarning, taskErr.Error)
+		return
+	}
+	emitUserQuota(data.AppID, data.RequestID, profile)
+}
+
+// checkPrivateQuota fails the upload task of a private asset with ErrCodeQuotaExceeded if the files do not fit the remaining
+// private storage, so gigabytes are not uploaded just to be rejected by the server.
+// If the quota cannot be fetched, the upload goes on and the server decides.
+func checkPrivateQuota(data AssetUploadRequestData, files []UploadFile, task *Task) *TaskError {
+	if !data.UploadData.IsPrivate || len(files) == 0 {
+		return nil
+	}
+	profile, taskErr := fetchUserProfile(uploadProfileData(data), task)
+	if taskErr != nil {
+		BKLog.Printf("%s Private quota not checked before upload: %v", EmoWarning, taskErr.Error)
+		return nil
+	}
+	quota, ok := parseUserQuota(profile)
+	if !ok {
+		return nil
+	}
+	size := uploadFilesSize(files)
+	if size <= quota.PrivateStorageRemaining {
+		return nil
+	}
+	err := fmt.Errorf("private storage quota exceeded: upload needs %s (%d bytes), only %s (%d bytes) of %s remaining",
+		formatBytes(size), size, formatBytes(quota.PrivateStorageRemaining), quota.PrivateStorageRemaining, formatBytes(quota.PrivateStorageTotal))
+	return &TaskError{
+		AppID:     task.AppID,
+		TaskID:    task.TaskID,
+		Error:     err,
+		ErrorCode: ErrCodeQuotaExceeded,
+		Result:    map[string]interface{}{"upload_size": size, "quota": quota},
+	}
+}
+
+// uploadProfileData is the data for fetching the profile of the user uploading the asset.
+func uploadProfileData(data AssetUploadRequestData) MinimalTaskData {
+	return MinimalTaskData{
+		AppID:           data.AppID,
+		APIKey:          data.Preferences.APIKey,
+		AddonVersion:    data.UploadData.AddonVersion,
+		PlatformVersion: data.UploadData.PlatformVersion,
+		RequestID:       data.RequestID,
+	}
+}
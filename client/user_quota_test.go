@@ -0,0 +1,133 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUserQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile map[string]interface{}
+		want    UserQuota
+		wantOK  bool
+	}{
+		{
+			name: "full plan",
+			profile: map[string]interface{}{"user": map[string]interface{}{
+				"currentPlanName": "Full", "sumPrivateAssetFilesSize": 300.0, "remainingPrivateQuota": 700.0, "fullPlanExpiration": "2027-01-01",
+			}},
+			want:   UserQuota{PlanName: "Full", PrivateStorageUsed: 300, PrivateStorageTotal: 1000, PrivateStorageRemaining: 700, FullPlanExpiry: "2027-01-01"},
+			wantOK: true,
+		},
+		{
+			name: "numbers as strings",
+			profile: map[string]interface{}{"user": map[string]interface{}{
+				"currentPlanName": "Free", "sumPrivateAssetFilesSize": "0", "remainingPrivateQuota": "1024",
+			}},
+			want:   UserQuota{PlanName: "Free", PrivateStorageTotal: 1024, PrivateStorageRemaining: 1024},
+			wantOK: true,
+		},
+		{name: "no quota", profile: map[string]interface{}{"user": map[string]interface{}{"id": 1.0}}},
+		{name: "no user", profile: map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseUserQuota(tt.profile)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseUserQuota() = %+v, %t, expected %+v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckPrivateQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"user": {"currentPlanName": "Free", "sumPrivateAssetFilesSize": 900, "remainingPrivateQuota": 100}}`)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	serverURL := server.URL
+	ClientAPI = server.Client()
+	defer setServer(setServer(serverURL))
+
+	dir := t.TempDir()
+	small, large := filepath.Join(dir, "small.blend"), filepath.Join(dir, "large.blend")
+	os.WriteFile(small, make([]byte, 100), 0644)
+	os.WriteFile(large, make([]byte, 101), 0644)
+
+	tests := []struct {
+		name     string
+		private  bool
+		filePath string
+		wantErr  bool
+	}{
+		{"fits remaining quota", true, small, false},
+		{"exceeds remaining quota", true, large, true},
+		{"public asset", false, large, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data AssetUploadRequestData
+			data.AppID = 635
+			data.UploadData.IsPrivate = tt.private
+			task := NewTask(data, data.AppID, "quota-check", "asset_upload")
+			taskErr := checkPrivateQuota(data, []UploadFile{{Type: "blend", FilePath: tt.filePath}}, task)
+			if (taskErr != nil) != tt.wantErr {
+				t.Fatalf("checkPrivateQuota() = %v, expected error %t", taskErr, tt.wantErr)
+			}
+			if taskErr == nil {
+				return
+			}
+			if taskErr.ErrorCode != ErrCodeQuotaExceeded || !strings.Contains(taskErr.Error.Error(), "(101 bytes), only 0 KB (100 bytes) of 1 KB remaining") {
+				t.Errorf("got error %q (%s)", taskErr.Error, taskErr.ErrorCode)
+			}
+		})
+	}
+}
+
+func TestEmitUserQuota(t *testing.T) {
+	profile := map[string]interface{}{"user": map[string]interface{}{"currentPlanName": "Full", "remainingPrivateQuota": 2048.0}}
+	emitUserQuota(635, "login-request", profile)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case task := <-AddTaskCh:
+			if task.AppID != 635 {
+				continue
+			}
+			quota, ok := task.Result.(UserQuota)
+			if task.TaskType != "user_quota" || task.Status != "finished" || task.RequestID != "login-request" || !ok || quota.PrivateStorageRemaining != 2048 {
+				t.Errorf("got %s task %s with result %+v", task.TaskType, task.Status, task.Result)
+			}
+			return
+		case <-timeout:
+			t.Fatal("user_quota task was not added")
+		}
+	}
+}
@@ -19,6 +19,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -26,39 +28,95 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/user"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+)
+
+// RequestMeta identifies the request, its user and system to the server.
+// Empty SystemID, AddonVersion and PlatformVersion are defaulted by getHeaders, so call sites cannot forget them.
+type RequestMeta struct {
+	APIKey          string
+	SystemID        string
+	AddonVersion    string
+	PlatformVersion string
+	RequestID       string
+}
 
-	"github.com/google/uuid"
+var (
+	platformVersion    string // platform_version of the add-on, remembered for requests whose task data has none
+	platformVersionMux sync.Mutex
 )
 
+// GetPlatformVersion returns the platform of this system, as reported by the add-on in platform_version.
+// Before the add-on reported any, operating system and architecture of the Client are returned.
+func GetPlatformVersion() string {
+	platformVersionMux.Lock()
+	defer platformVersionMux.Unlock()
+	if platformVersion == "" {
+		return runtime.GOOS + "-" + runtime.GOARCH
+	}
+	return platformVersion
+}
+
+func rememberPlatformVersion(version string) {
+	platformVersionMux.Lock()
+	defer platformVersionMux.Unlock()
+	if platformVersion == "" {
+		platformVersion = version
+	}
+}
+
 // GetHeaders returns a set of HTTP headers to be used in requests to the server.
 // These are the default headers which should be set to all requests of client to the server.
-func getHeaders(apiKey, systemID, addonVersion, platformVersion string) http.Header {
+// RequestID is sent as X-Request-ID so the server logs can be matched with the add-on and Client logs.
+// User-Agent and X-BK-Addon identify the Client and the add-on install to the server and its WAF.
+func getHeaders(meta RequestMeta) http.Header {
+	if meta.SystemID == "" {
+		meta.SystemID = systemID()
+	}
+	if meta.AddonVersion == "" {
+		meta.AddonVersion = DefaultAddonVersion
+	}
+	if meta.PlatformVersion == "" {
+		meta.PlatformVersion = GetPlatformVersion()
+	} else {
+		rememberPlatformVersion(meta.PlatformVersion)
+	}
+
 	headers := http.Header{}
 	headers.Set("Content-Type", "application/json")
-	headers.Set("Platform-Version", platformVersion)
-	headers.Set("System-ID", systemID)
-	headers.Set("Addon-Version", addonVersion)
+	headers.Set("User-Agent", userAgent(meta.AddonVersion))
+	if AddonModuleName != "" {
+		headers.Set("X-BK-Addon", AddonModuleName)
+	}
+	headers.Set("Platform-Version", meta.PlatformVersion)
+	headers.Set("System-ID", meta.SystemID)
+	headers.Set("Addon-Version", meta.AddonVersion)
 	headers.Set("Client-Version", ClientVersion)
-	if apiKey != "" {
+	if meta.RequestID != "" {
+		headers.Set("X-Request-ID", meta.RequestID)
+	}
+	if apiKey, _ := sanitizeAPIKey(meta.APIKey); apiKey != "" { // malformed key is refused by the API client before sending
 		headers.Set("Authorization", "Bearer "+apiKey)
 	}
 	return headers
 }
 
-// GetSystemID returns the NodeID of the machine as string of 15 integers.
-// It is the same format as platform.platform() produces in Python.
-func getSystemID() *string {
-	var nodeInt uint64
-	for _, b := range uuid.NodeID() {
-		nodeInt = (nodeInt << 8) | uint64(b)
+// userAgent returns the User-Agent of the Client, e.g. "BlenderKit-Client/1.2.0 (linux; amd64) addon/3.13.0".
+// The -user_agent flag replaces it completely.
+func userAgent(addonVersion string) string {
+	if UserAgent != "" {
+		return UserAgent
+	}
+	ua := fmt.Sprintf("BlenderKit-Client/%s (%s; %s)", ClientVersion, runtime.GOOS, runtime.GOARCH)
+	if addonVersion != "" {
+		ua += " addon/" + addonVersion
 	}
-	nodeStr := fmt.Sprintf("%015d", nodeInt)
-	return &nodeStr
+	return ua
 }
 
 func StringToAddonVersion(s string) (*AddonVersion, error) {
@@ -100,8 +158,15 @@ type AddonVersion struct {
 	Patch int
 }
 
+// MaxFilenameLength caps length of the filenames extracted from URLs, extension is always kept.
+const MaxFilenameLength = 128
+
+var uuidInFilenameRegex = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
 // Extract the filename from a URL, used for thumbnails.
-// Mirrors utils.py/extract_filename_from_url()
+// Mirrors paths.py/extract_filename_from_url()
+// Server filenames contain UUID of the file. If not, short hash of the whole URL is added before the extension,
+// so URLs which differ only in the directory or query do not overwrite each other's files.
 func ExtractFilenameFromURL(urlStr string) (string, error) {
 	if urlStr == "" {
 		return "", fmt.Errorf("empty URL")
@@ -111,8 +176,28 @@ func ExtractFilenameFromURL(urlStr string) (string, error) {
 		return "", err
 	}
 	filename := path.Base(parsedURL.Path)
-	escaped := url.QueryEscape(filename) // addon needs files to have %2C instead of ,
-	return escaped, nil
+	if filename == "" || filename == "." || filename == ".." || filename == "/" {
+		return "", fmt.Errorf("URL does not point to a file: %s", urlStr)
+	}
+
+	hash := sha256.Sum256([]byte(urlStr))
+	urlHash := hex.EncodeToString(hash[:4])
+	ext := url.QueryEscape(path.Ext(filename))
+	stem := url.QueryEscape(strings.TrimSuffix(filename, path.Ext(filename))) // addon needs files to have %2C instead of ,
+	if !uuidInFilenameRegex.MatchString(filename) {
+		stem = stem + "_" + urlHash
+	}
+	if len(ext) > MaxFilenameLength/2 {
+		return "", fmt.Errorf("URL has too long file extension: %s", urlStr)
+	}
+	if len(stem)+len(ext) > MaxFilenameLength { // truncated name could lose the UUID, so it ends with the hash
+		stem = stem[:MaxFilenameLength-len(ext)-len(urlHash)-1]
+		if i := strings.LastIndex(stem, "%"); i >= 0 && i >= len(stem)-2 { // do not cut escaped character in half
+			stem = stem[:i]
+		}
+		stem = stem + "_" + urlHash
+	}
+	return stem + ext, nil
 }
 
 // Check if the file exists on the hard drive.
@@ -131,6 +216,28 @@ func FileExists(filePath string) (bool, fs.FileInfo, error) {
 	return true, info, nil
 }
 
+// saveToFile writes the content of r to the path, creating its directory if missing.
+// It writes to .part file first, so partial file is never mistaken for a complete one.
+func saveToFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	partPath := path + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, path)
+}
+
 func DeleteFile(filePath string) error {
 	err := os.Remove(filePath)
 	if err != nil {
@@ -229,34 +336,38 @@ func count_to_parent(parent *Category) {
 	}
 }
 
-// GetSafeTempPath returns a safe, user-specific path in the system's temporary directory.
-// This is the location where thumbnails, gravatars and other temporary files are stored.
-//
-// Contains dirs: bkit_g, brush_search, hdr_search, material_search, model_search, scene_search
-//
-// Contains files: categories.json.
-func GetSafeTempPath() (string, error) {
-	currentUser, err := user.Current()
-	if err != nil {
-		return "", err
-	}
-
-	username := currentUser.Username
-	reg, err := regexp.Compile("[^a-zA-Z0-9]+")
-	if err != nil {
-		return "", err
-	}
-	safeUsername := reg.ReplaceAllString(username, "")
-
-	tempDir := os.TempDir()
-	safeTempPath := filepath.Join(tempDir, "bktemp_"+safeUsername)
-
-	err = os.MkdirAll(safeTempPath, 0700)
-	if err != nil {
-		return "", err
+// categoryIndex flattens categories into entries by slug, counts are expected to be fixed by fix_category_counts.
+// Slugs are unique on the server, for a duplicate slug the first category in depth-first order is kept.
+func categoryIndex(categories []Category) map[string]CategoryIndexEntry {
+	index := make(map[string]CategoryIndexEntry)
+	var add func(categories []Category, parentPath string)
+	add = func(categories []Category, parentPath string) {
+		for _, category := range categories {
+			path := category.Slug
+			if parentPath != "" {
+				path = parentPath + "/" + category.Slug
+			}
+			entry := CategoryIndexEntry{
+				Name:                 category.Name,
+				Path:                 path,
+				AssetCount:           category.AssetCount,
+				AssetCountCumulative: category.AssetCount,
+				Children:             make([]string, 0, len(category.Children)),
+			}
+			for _, child := range category.Children {
+				entry.AssetCount -= child.AssetCount
+				entry.Children = append(entry.Children, child.Slug)
+			}
+			if _, exists := index[category.Slug]; exists {
+				BKLog.Printf("%s Duplicate category slug %q at %s", EmoWarning, category.Slug, path)
+			} else {
+				index[category.Slug] = entry
+			}
+			add(category.Children, path)
+		}
 	}
-
-	return safeTempPath, nil
+	add(categories, "")
+	return index
 }
 
 // FixAssetsUpdateResponse updates the response to contain the asset ID and AssetType.
@@ -298,6 +409,9 @@ func RespIsJSON(resp *http.Response) error {
 	if strings.Contains(bodyString, "error code: 1015") {
 		return fmt.Errorf("API rate limit exceeded, wait for a while (%s)", bodyString)
 	}
+	if isMaintenancePage(resp.StatusCode, bodyString) { // server status was set by serverStatusTransport
+		return ErrServerMaintenance
+	}
 
 	return fmt.Errorf("invalid response Content-Type: %s, resp: %s", contentType, bodyString)
 }
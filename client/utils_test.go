@@ -19,18 +19,151 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 func TestGetSystemID(t *testing.T) {
 	regex := regexp.MustCompile("^[0-9]{15}$")
 	actual := getSystemID()
-	if !regex.MatchString(*actual) {
-		t.Errorf("getSystemID() = %v; want a string of 15 integers", *actual)
+	if !regex.MatchString(actual) {
+		t.Errorf("getSystemID() = %v; want a string of 15 integers", actual)
+	}
+
+	defer func(id *string) { SystemID = id }(SystemID)
+	SystemID = nil
+	if first, second := systemID(), systemID(); first != second || SystemID == nil || *SystemID != first || !regex.MatchString(first) {
+		t.Errorf("systemID() = %q, then %q; want the same 15 integers resolved on first use", first, second)
+	}
+}
+
+func TestGetHeadersRequestID(t *testing.T) {
+	tests := []struct {
+		requestID string
+		expected  string
+	}{
+		{"", ""},
+		{"op-123", "op-123"},
+	}
+
+	for _, test := range tests {
+		headers := getHeaders(RequestMeta{SystemID: "123456789012345", AddonVersion: "3.13.0", PlatformVersion: "4.2.0", RequestID: test.requestID})
+		if actual := headers.Get("X-Request-ID"); actual != test.expected {
+			t.Errorf("getHeaders(requestID=%q) X-Request-ID = %q; want %q", test.requestID, actual, test.expected)
+		}
+	}
+}
+
+func TestGetHeadersIdentification(t *testing.T) {
+	defer func(ua, module string) { UserAgent, AddonModuleName = ua, module }(UserAgent, AddonModuleName)
+	tests := []struct {
+		userAgent    string
+		module       string
+		addonVersion string
+		expectedUA   string
+	}{
+		{"", "", "3.13.0", fmt.Sprintf("BlenderKit-Client/%s (%s; %s) addon/3.13.0", ClientVersion, runtime.GOOS, runtime.GOARCH)},
+		{"", "bl_ext.user_default.blenderkit", "", fmt.Sprintf("BlenderKit-Client/%s (%s; %s)", ClientVersion, runtime.GOOS, runtime.GOARCH)},
+		{"debug-agent/1.0", "blenderkit", "3.13.0", "debug-agent/1.0"},
+	}
+
+	for _, test := range tests {
+		UserAgent, AddonModuleName = test.userAgent, test.module
+		headers := getHeaders(RequestMeta{SystemID: "123456789012345", AddonVersion: test.addonVersion, PlatformVersion: "4.2.0"})
+		if actual := headers.Get("User-Agent"); actual != test.expectedUA {
+			t.Errorf("getHeaders() User-Agent = %q; want %q", actual, test.expectedUA)
+		}
+		if actual := headers.Get("X-BK-Addon"); actual != test.module {
+			t.Errorf("getHeaders() X-BK-Addon = %q; want %q", actual, test.module)
+		}
+	}
+}
+
+func TestOutboundRequestMeta(t *testing.T) {
+	var mux sync.Mutex
+	requests := map[string]http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		requests[r.URL.Path] = r.Header.Clone()
+		mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/thumbs/small.png":
+			w.Write([]byte("png"))
+		case "/api/v1/search/":
+			w.Write([]byte(`{"count": 0, "results": []}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	defer func(c, thumbs *http.Client, id *string, version string) {
+		ClientAPI, ClientBigThumbs, SystemID, DefaultAddonVersion = c, thumbs, id, version
+	}(ClientAPI, ClientBigThumbs, SystemID, DefaultAddonVersion)
+	defer setServer(setServer(server.URL))
+	systemID := "123456789012345"
+	ClientAPI, ClientBigThumbs, SystemID = server.Client(), server.Client(), &systemID
+	DefaultAddonVersion = "3.13.0.1"
+
+	// task data of the add-on always has the versions, data created by the Client itself may miss them
+	tempDir := t.TempDir()
+	thumbnail := NewTask(DownloadThumbnailData{ImagePath: filepath.Join(tempDir, "small.png"), ImageURL: server.URL + "/thumbs/small.png"}, 625, "thumb", "thumbnail_download")
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	DownloadThumbnail(thumbnail, wg)
+	<-AddTaskCh
+	<-TaskFinishCh
+	profileTask := NewTask(nil, 625, "profile", "profiles/get_user_profile")
+	if _, taskErr := fetchUserProfile(MinimalTaskData{APIKey: "key"}, profileTask); taskErr != nil {
+		t.Fatalf("fetchUserProfile() failed: %v", taskErr.Error)
+	}
+	searchTask := NewTask(nil, 625, "search", "search")
+	if _, _, err := fetchSearch(context.Background(), SearchTaskData{URLQuery: server.URL + "/api/v1/search/", AddonVersion: "3.13.0", PlatformVersion: "Linux-6.8"}, searchTask); err != nil {
+		t.Fatalf("fetchSearch() failed: %v", err)
+	}
+
+	for _, path := range []string{"/thumbs/small.png", "/api/v1/me/", "/api/v1/search/"} {
+		headers, ok := requests[path]
+		if !ok {
+			t.Errorf("no request to %s", path)
+			continue
+		}
+		for _, name := range []string{"System-ID", "Addon-Version", "Platform-Version"} {
+			if headers.Get(name) == "" {
+				t.Errorf("request to %s has empty %s header", path, name)
+			}
+		}
+	}
+	if actual := requests["/api/v1/search/"].Get("Platform-Version"); actual != "Linux-6.8" {
+		t.Errorf("search Platform-Version = %q; want the one of the add-on", actual)
+	}
+	if actual := requests["/thumbs/small.png"].Get("Addon-Version"); actual != DefaultAddonVersion {
+		t.Errorf("thumbnail Addon-Version = %q; want default %q", actual, DefaultAddonVersion)
+	}
+}
+
+func TestTaskRequestID(t *testing.T) {
+	task := NewTask(nil, 1, "task-1", "search")
+	if task.RequestID != "task-1" {
+		t.Errorf("NewTask() RequestID = %q; want task ID %q", task.RequestID, "task-1")
+	}
+	if task.WithRequestID("").RequestID != "task-1" {
+		t.Errorf("WithRequestID(\"\") overwrote default RequestID with %q", task.RequestID)
+	}
+	if task.WithRequestID("op-123").LogID() != "task-1, request op-123" {
+		t.Errorf("LogID() = %q; want task and request ID", task.LogID())
 	}
 }
 
@@ -122,20 +255,29 @@ func TestExtractFilenameFromURL(t *testing.T) {
 		expected string
 		err      error
 	}{
-		{"https://example.com/file.txt", "file.txt", nil},
-		{"https://example.com/path/to/file.jpg", "file.jpg", nil},
-		{"https://example.com/path/to/file%2Cwith%2Ccomma.txt", "file%2Cwith%2Ccomma.txt", nil},
+		{"https://example.com/file.txt", "file_7a8c22e5.txt", nil}, // no UUID in the name, hash of URL is added
+		{"https://example.com/path/to/file.jpg", "file_82946edc.jpg", nil},
+		{"https://example.com/path/to/file%2Cwith%2Ccomma.txt", "file%2Cwith%2Ccomma_374f7660.txt", nil},
 		{"https://public.blenderkit.com/thumbnails/assets/57ec74ff91b54b2ca5a540cda907cf7a/files/thumbnail_99e43644-30de-4361-9a7e-605eaf7d6795.jpg.256x256_q85_crop-%2C.jpg.webp?webp_generated=1701166007", "thumbnail_99e43644-30de-4361-9a7e-605eaf7d6795.jpg.256x256_q85_crop-%2C.jpg.webp", nil},
 		{"https://public.blenderkit.com/thumbnails/assets/6144bbda83ca47ec8b9adb813c56f660/files/thumbnail_59686100-7ad0-4b38-b6fd-5158a6192a31.png.256x256_q85_crop-%2C.png.webp?webp_generated=1709019959", "thumbnail_59686100-7ad0-4b38-b6fd-5158a6192a31.png.256x256_q85_crop-%2C.png.webp", nil},
 		{"https://public.blenderkit.com/public-assets/assets/76d2e7eaa0af42a8b33e1498c1da22f8/files/blend_0551adba-93bf-4f0e-aaeb-73927db46f88.blend", "blend_0551adba-93bf-4f0e-aaeb-73927db46f88.blend", nil},
 		{"https://d255qm5a95hvrp.cloudfront.net/assets/0a00681c598c42259f67b69e6642f5dc/files/resolution_2K_02dacc88-532e-4b68-b8cb-4f1b8df1814b.blend?Expires=1709125449&Signature=LO-Gp1BfBe3maWncgvOep4ZNM9DJj0AdtMtjd9IN~OZQ5HPG1Cfy5408Bd0GskRTcgHuXjthLbhS3cWzksJrNrYA2L3zglK1ThSpdTtG4KwgGzlcyj7FXqmaKFul8Kpqu3weQaN1uazSzZSw5dN3Qxq0mb~7mPm6b8s7bJ6YeyUiWyL8qK8T-ff7hkzwb0tCIAyA3~9ZRImiwL0-OePg4I9Jl9LA32v2BuVJVkXp-kQkDb3VFRbhz9WCjFp0al7SqsFcpiIuJoFWp7UjTurqM85VX4jra9LQocA2svRk8fbrhTHkQRvMKJ3onqaA1Ou2Q71~-mL1aXxEfapDNk3euA__&Key-Pair-Id=KHZSXFBGJQRJ3", "resolution_2K_02dacc88-532e-4b68-b8cb-4f1b8df1814b.blend", nil},
 		{"", "", fmt.Errorf("empty URL")},
+		// URLs differing only in query must not collide
+		{"https://cdn.example.com/avatar/thumb.jpg?v=1", "thumb_464d3164.jpg", nil},
+		{"https://cdn.example.com/avatar/thumb.jpg?v=2", "thumb_4d0da839.jpg", nil},
+		// Over-long names are capped, extension is kept and hash replaces the cut part
+		{"https://example.com/" + strings.Repeat("a,", 100) + "_99e43644-30de-4361-9a7e-605eaf7d6795.blend", strings.Repeat("a%2C", 28) + "a_f4594621.blend", nil},
+		// Malformed URLs
+		{"https://example.com/", "", fmt.Errorf("URL does not point to a file: https://example.com/")},
+		{"https://example.com", "", fmt.Errorf("URL does not point to a file: https://example.com")},
+		{"https://example.com/..", "", fmt.Errorf("URL does not point to a file: https://example.com/..")},
 	}
 
 	for _, test := range tests {
 		actual, err := ExtractFilenameFromURL(test.input)
-		if err != nil {
-			if test.err == nil || err.Error() != test.err.Error() {
+		if err != nil || test.err != nil {
+			if err == nil || test.err == nil || err.Error() != test.err.Error() {
 				t.Errorf("ExtractFilenameFromURL(%q) returned error '%v'; want '%v'", test.input, err, test.err)
 			}
 		} else if actual != test.expected {
@@ -165,3 +307,91 @@ func TestServerToLocalFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestCategoryIndex(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "categories.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data CategoriesData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	result := newCategoriesResult(data.Results)
+
+	expected := map[string]CategoryIndexEntry{
+		"model":           {Name: "Model", Path: "model", AssetCount: 1, AssetCountCumulative: 15, Children: []string{"furniture", "model-other"}},
+		"furniture":       {Name: "Furniture", Path: "model/furniture", AssetCount: 2, AssetCountCumulative: 10, Children: []string{"chairs", "furniture-other"}},
+		"chairs":          {Name: "Chairs", Path: "model/furniture/chairs", AssetCount: 5, AssetCountCumulative: 5, Children: []string{}},
+		"furniture-other": {Name: "Other", Path: "model/furniture/furniture-other", AssetCount: 3, AssetCountCumulative: 3, Children: []string{}},
+		"model-other":     {Name: "Other", Path: "model/model-other", AssetCount: 4, AssetCountCumulative: 4, Children: []string{}},
+		"material":        {Name: "Material", Path: "material", AssetCount: 0, AssetCountCumulative: 7, Children: []string{"material-other"}},
+		"material-other":  {Name: "Other", Path: "material/material-other", AssetCount: 7, AssetCountCumulative: 7, Children: []string{}},
+	}
+	if !reflect.DeepEqual(result.Index, expected) {
+		t.Errorf("categoryIndex() =\n%+v\nexpected\n%+v", result.Index, expected)
+	}
+	if result.Categories[0].AssetCount != 15 {
+		t.Errorf("tree count of model = %d, expected cumulative 15", result.Categories[0].AssetCount)
+	}
+}
+
+func TestCategoryIndexDuplicateSlug(t *testing.T) {
+	categories := []Category{
+		{Name: "Model", Slug: "model", Children: []Category{{Name: "Other", Slug: "other", AssetCount: 1}}},
+		{Name: "Material", Slug: "material", Children: []Category{{Name: "Other", Slug: "other", AssetCount: 2}}},
+	}
+	index := newCategoriesResult(categories).Index
+	if len(index) != 3 || index["other"].Path != "model/other" || index["material"].Children[0] != "other" {
+		t.Errorf("categoryIndex() = %+v, expected the first other category kept", index)
+	}
+}
+
+// unwritableDir returns a read-only directory in which nothing can be created.
+// Root ignores the permissions, then a regular file is returned instead, which cannot contain anything either.
+func unwritableDir(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+	probe := filepath.Join(dir, "probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		return dir
+	}
+	os.Remove(probe)
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return notDir
+}
+
+func TestSaveToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "dir", "thumb.png")
+	if err := saveToFile(path, strings.NewReader("image")); err != nil {
+		t.Fatalf("saveToFile() into missing directory: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "image" {
+		t.Errorf("saved %q; want image", content)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part file left after save: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(unwritableDir(t), "thumb.png"), filepath.Join(unwritableDir(t), "sub", "thumb.png")} {
+		if err := saveToFile(path, strings.NewReader("image")); err == nil {
+			t.Errorf("saveToFile(%s) succeeded in unwritable directory", path)
+		}
+	}
+
+	// failed read leaves neither the file nor its .part
+	path = filepath.Join(t.TempDir(), "broken.png")
+	if err := saveToFile(path, iotest.ErrReader(fmt.Errorf("connection reset"))); err == nil {
+		t.Error("saveToFile() succeeded with failing reader")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 0 {
+		t.Errorf("files left after failed save: %v", entries)
+	}
+}
@@ -23,110 +23,275 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/google/uuid"
 )
 
+// WrapperMaxResponseSize caps the upstream responses read into memory by the request wrappers, set by flag.
+var WrapperMaxResponseSize int64 = 50 * 1024 * 1024
+
+// ErrResponseTooLarge is returned when upstream response of a request wrapper exceeds WrapperMaxResponseSize.
+var ErrResponseTooLarge = errors.New("response is too large")
+
+// readLimitedBody reads the whole response body, or fails with ErrResponseTooLarge if it is bigger than limit.
+func readLimitedBody(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: over %d MB", ErrResponseTooLarge, limit/1024/1024)
+	}
+	return data, nil
+}
+
+// validateWrapperURL allows the request wrappers to call only the BlenderKit server,
+// so other local processes cannot use the Client as an open proxy. External hosts need allowExternal.
+func validateWrapperURL(rawURL string, allowExternal bool) error {
+	if err := validateNotEmpty("url", rawURL); err != nil {
+		return err
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return &FieldError{Field: "url", Message: fmt.Sprintf("is not a valid absolute URL: %q", rawURL)}
+	}
+	if allowExternal {
+		return nil
+	}
+	server, err := url.Parse(currentServer())
+	if err != nil || !strings.EqualFold(target.Host, server.Host) {
+		return &FieldError{Field: "url", Message: fmt.Sprintf("host %q is not the BlenderKit server, set allow_external to call it", target.Host)}
+	}
+	return nil
+}
+
 // BlockingFileDownloadTaskData is expected from the add-on.
 type BlockingFileDownloadTaskData struct {
-	AppID    int    `json:"app_id"`
-	APIKey   string `json:"api_key"`
-	URL      string `json:"url"`
-	Filepath string `json:"filepath"`
+	AppID           int    `json:"app_id"`
+	APIKey          string `json:"api_key"`
+	AddonVersion    string `json:"addon_version"`
+	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
+	URL             string `json:"url"`
+	Filepath        string `json:"filepath"`
+	Resume          bool   `json:"resume"` // Continue from the .part file left by previous failed download
+}
+
+// BlockingFileDownloadResponse is returned when the file was downloaded.
+type BlockingFileDownloadResponse struct {
+	BytesWritten int64 `json:"bytes_written"` // Written in this request, without the resumed part
+	Resumed      bool  `json:"resumed"`
 }
 
 // BlockingFileDownloadHandler downloads file from a URL. It is a blocking call by design.
 // It does the download of a single file, and only then returns.
+// File is downloaded into Filepath+".part" which is renamed to Filepath only when the download succeeds,
+// so failed download never leaves incomplete file under the final name. With Resume the .part file is kept on failure.
 func BlockingFileDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	var data BlockingFileDownloadTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateNotEmpty("url", data.URL), resolveRootedPath("filepath", &data.Filepath)); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	partPath := data.Filepath + ".part"
+	var offset int64
+	if data.Resume {
+		if info, err := os.Stat(partPath); err == nil && info.Mode().IsRegular() {
+			offset = info.Size()
+		}
+	}
+	fail := func(status int, code, es string) {
 		log.Print(es)
-		http.Error(w, es, http.StatusBadRequest)
+		writeJSONError(w, status, code, es)
+		if !data.Resume {
+			os.Remove(partPath)
+		}
+	}
+
+	req, err := http.NewRequest("GET", data.URL, nil)
+	if err != nil {
+		fail(http.StatusBadRequest, ErrCodeInvalidField, fmt.Sprintf("error creating request: %v", err))
+		return
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: data.APIKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := ClientDownloads.Do(req)
+	if err != nil {
+		fail(http.StatusBadGateway, ErrCodeUpstream, fmt.Sprintf("error executing request: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent
+	if resp.StatusCode != http.StatusOK && !resumed {
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			os.Remove(partPath) // .part does not match the file on server, next attempt starts from the beginning
+		}
+		_, respString, _ := ParseFailedHTTPResponse(resp)
+		fail(resp.StatusCode, ErrCodeUpstream, fmt.Sprintf("server responded with status: %v, %v", resp.Status, respString))
+		return
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC // server ignoring Range sends whole file
+	if resumed {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		fail(http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("error creating file: %v", err))
+		return
+	}
+	written, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("error writing to file: %v", err))
+		return
+	}
+	if err := os.Rename(partPath, data.Filepath); err != nil {
+		fail(http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("error renaming downloaded file: %v", err))
+		return
+	}
+
+	log.Printf("Downloaded %d bytes (resumed: %v)\n", written, resumed)
+	writeJSON(w, BlockingFileDownloadResponse{BytesWritten: written, Resumed: resumed})
+}
+
+// BlockingFileUploadTaskData is expected from the add-on.
+type BlockingFileUploadTaskData struct {
+	AppID           int    `json:"app_id"`
+	APIKey          string `json:"api_key"`
+	AddonVersion    string `json:"addon_version"`
+	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
+	URL             string `json:"url"`
+	Filepath        string `json:"filepath"`
+}
+
+// BlockingFileUploadHandler uploads file to a URL with PUT. It is a blocking call by design, counterpart of BlockingFileDownloadHandler.
+// Status code and body of the upstream response are passed back to the add-on. The file is kept also when the upload fails.
+func BlockingFileUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var data BlockingFileUploadTaskData
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateNotEmpty("url", data.URL), resolveRootedPath("filepath", &data.Filepath)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
-	file, err := os.Create(data.Filepath)
+	file, err := os.Open(data.Filepath)
 	if err != nil {
-		es := fmt.Sprintf("error creating file: %v", err)
+		es := fmt.Sprintf("error opening file: %v", err)
 		log.Print(es)
-		http.Error(w, es, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidField, es)
 		return
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		es := fmt.Sprintf("error reading file info: %v", err)
+		log.Print(es)
+		writeJSONError(w, http.StatusInternalServerError, ErrCodeInternal, es)
+		return
+	}
 
-	req, err := http.NewRequest("GET", data.URL, nil)
+	req, err := http.NewRequest("PUT", data.URL, file)
 	if err != nil {
 		es := fmt.Sprintf("error creating request: %v", err)
 		log.Print(es)
-		http.Error(w, es, http.StatusInternalServerError)
-		DeleteFileAndParentIfEmpty(data.Filepath)
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidField, es)
 		return
 	}
-	req.Header.Add("Authorization", "Bearer "+data.APIKey)
+	apiKey := data.APIKey
+	if isPresignedURL(req.URL) { // S3 rejects requests with signature in URL and Authorization header at once
+		apiKey = ""
+	}
+	req.Header = getHeaders(RequestMeta{APIKey: apiKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: data.RequestID})
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.ContentLength = info.Size() // S3 signed URLs do not accept chunked transfer encoding
+	if info.Size() == 0 {
+		req.Body = http.NoBody
+	}
 
-	resp, err := ClientDownloads.Do(req)
+	resp, err := ClientUploads.Do(req)
 	if err != nil {
 		es := fmt.Sprintf("error executing request: %v", err)
 		log.Print(es)
-		http.Error(w, es, http.StatusInternalServerError)
-		DeleteFileAndParentIfEmpty(data.Filepath)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, es)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		_, respString, _ := ParseFailedHTTPResponse(resp)
-		es := fmt.Sprintf("server responded with status: %v, %v", resp.Status, respString)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		es := fmt.Sprintf("error reading response body: %v", err)
 		log.Print(es)
-		http.Error(w, es, resp.StatusCode)
-		DeleteFileAndParentIfEmpty(data.Filepath)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, es)
 		return
 	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Upload of %s failed: %v, %s", data.Filepath, resp.Status, respBody)
+	} else {
+		log.Printf("Uploaded %d bytes\n", info.Size())
+	}
 
-	written, err := io.Copy(file, resp.Body)
-	if err != nil {
-		es := fmt.Sprintf("error writing to file: %v", err)
-		log.Print(es)
-		http.Error(w, es, http.StatusInternalServerError)
-		DeleteFileAndParentIfEmpty(data.Filepath)
-		return
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
 	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
 
-	log.Printf("Downloaded %d bytes\n", written)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("File downloaded successfully"))
+// isPresignedURL reports whether the URL carries its own S3 signature (AWS signature version 4 or 2).
+func isPresignedURL(u *url.URL) bool {
+	q := u.Query()
+	return q.Get("X-Amz-Signature") != "" || q.Get("Signature") != ""
 }
 
 // BlockingRequestData represents the expected structure of the incoming request data.
 type BlockingRequestData struct {
-	URL     string            `json:"url"`
-	Method  string            `json:"method"`
-	Headers map[string]string `json:"headers"`
-	JSON    json.RawMessage   `json:"json"`
+	URL           string            `json:"url"`
+	Method        string            `json:"method"`
+	Headers       map[string]string `json:"headers"`
+	JSON          json.RawMessage   `json:"json"`
+	TimeoutS      float64           `json:"timeout_s"`      // Optional timeout of the request, replaces the ClientAPI timeout
+	AllowExternal bool              `json:"allow_external"` // Allow other host than the BlenderKit server
 }
 
 func BlockingRequestHandler(w http.ResponseWriter, r *http.Request) {
 	var data BlockingRequestData
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := validateWrapperURL(data.URL, data.AllowExternal); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
+	ctx, cancel, client := withRequestTimeout(r.Context(), ClientAPI, data.TimeoutS)
+	defer cancel()
 	reqBody := bytes.NewReader(data.JSON)
-	req, err := http.NewRequest(data.Method, data.URL, reqBody)
+	req, err := http.NewRequestWithContext(ctx, data.Method, data.URL, reqBody)
 	if err != nil {
 		log.Printf("Error creating request: %v", err)
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, ErrCodeInvalidField, "failed to create request: "+err.Error())
 		return
 	}
 
@@ -134,18 +299,18 @@ func BlockingRequestHandler(w http.ResponseWriter, r *http.Request) {
 		req.Header.Set(key, value)
 	}
 
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(client, req)
 	if err != nil {
 		log.Printf("Error making request: %v", err)
-		http.Error(w, "Request failed", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "request failed: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err := readLimitedBody(resp.Body, WrapperMaxResponseSize)
 	if err != nil {
 		log.Printf("Error reading response body: %v", err)
-		http.Error(w, "Failed to read response body", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadGateway, ErrCodeUpstream, "failed to read response body: "+err.Error())
 		return
 	}
 
@@ -163,13 +328,17 @@ type NonblockingRequestTaskData struct {
 	SystemID        string `json:"system_id"`
 	AddonVersion    string `json:"addon_version"`
 	PlatformVersion string `json:"platform_version"`
+	RequestID       string `json:"request_id"`
 	ApiKey          string `json:"api_key"`
 	// Data specific to non-blocking request.
-	URL      string                    `json:"url"`
-	Method   string                    `json:"method"`
-	Headers  map[string]string         `json:"headers"`  // Expands default headers, or overwrites them.
-	Messages NonblockingRequestMessage `json:"messages"` // Error and Success messages to be reported back to the Blender UI.
-	JSON     json.RawMessage           `json:"json"`
+	URL           string                    `json:"url"`
+	Method        string                    `json:"method"`
+	Headers       map[string]string         `json:"headers"`  // Expands default headers, or overwrites them.
+	Messages      NonblockingRequestMessage `json:"messages"` // Error and Success messages to be reported back to the Blender UI.
+	JSON          json.RawMessage           `json:"json"`
+	Files         []NonblockingRequestFile  `json:"files"`          // Optional, request is then sent as multipart form with JSON fields as form fields.
+	TimeoutS      float64                   `json:"timeout_s"`      // Optional timeout of the request, replaces the client-wide timeout
+	AllowExternal bool                      `json:"allow_external"` // Allow other host than the BlenderKit server
 }
 
 type NonblockingRequestMessage struct {
@@ -179,15 +348,15 @@ type NonblockingRequestMessage struct {
 
 func NonblockingRequestHandler(w http.ResponseWriter, r *http.Request) {
 	var data NonblockingRequestTaskData
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		es := fmt.Sprintf("error parsing JSON: %v", err)
-		fmt.Println(es)
-		http.Error(w, es, http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &data) {
+		return
+	}
+	if err := firstError(validateAppID(data.AppID), validateWrapperURL(data.URL, data.AllowExternal), validateRequestFiles(data.Files)); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	go NonblockingRequest(data)
+	go runTask(data.AppID, "", func() { NonblockingRequest(data) })
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -195,24 +364,46 @@ func NonblockingRequestHandler(w http.ResponseWriter, r *http.Request) {
 // It makes a request to the specified URL and returns the response as result in the Task.
 func NonblockingRequest(data NonblockingRequestTaskData) {
 	taskID := uuid.New().String()
-	AddTaskCh <- NewTask(data, data.AppID, taskID, "wrappers/nonblocking_request")
-
-	reqBody := bytes.NewBuffer(data.JSON)
-	req, err := http.NewRequest(data.Method, data.URL, reqBody)
+	task := NewTask(data, data.AppID, taskID, "wrappers/nonblocking_request").WithRequestID(data.RequestID)
+	sendTask(AddTaskCh, task)
+
+	var reqBody io.Reader = bytes.NewBuffer(data.JSON)
+	var contentType string
+	var contentLength int64
+	client := ClientAPI
+	if len(data.Files) > 0 {
+		body, ct, size, err := newMultipartBody(data.JSON, data.Files)
+		if err != nil {
+			es := fmt.Errorf("%v: %w", data.Messages.Error, err)
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
+			return
+		}
+		defer body.Close()
+		reqBody = &uploadProgressReader{ReadCloser: body, total: size, appID: data.AppID, taskID: taskID}
+		contentType, contentLength = ct, size
+		client = ClientUploads
+	}
+	ctx, cancel, client := withRequestTimeout(task.Ctx, client, data.TimeoutS)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, data.Method, data.URL, reqBody)
 	if err != nil {
 		es := fmt.Errorf("%v: %w", data.Messages.Error, err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: es}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
 		return
 	}
-	req.Header = getHeaders(data.ApiKey, *SystemID, data.AddonVersion, data.PlatformVersion)
+	req.Header = getHeaders(RequestMeta{APIKey: data.ApiKey, AddonVersion: data.AddonVersion, PlatformVersion: data.PlatformVersion, RequestID: task.RequestID})
 	for key, value := range data.Headers {
 		req.Header.Set(key, value)
 	}
+	if contentType != "" { // boundary must match the body, add-on cannot set it
+		req.Header.Set("Content-Type", contentType)
+		req.ContentLength = contentLength
+	}
 
-	resp, err := ClientAPI.Do(req)
+	resp, err := doAPI(client, req)
 	if err != nil {
 		es := fmt.Errorf("%v: %w", data.Messages.Error, err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: es}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
 		return
 	}
 	defer resp.Body.Close()
@@ -220,14 +411,14 @@ func NonblockingRequest(data NonblockingRequestTaskData) {
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		_, respString, _ := ParseFailedHTTPResponse(resp)
 		es := fmt.Errorf("%v: %v (%v)", data.Messages.Error, respString, resp.Status)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: es}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
 		return
 	}
 
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err := readLimitedBody(resp.Body, WrapperMaxResponseSize)
 	if err != nil {
 		es := fmt.Errorf("%v: %w", data.Messages.Error, err)
-		TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: es}
+		sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
 		return
 	}
 
@@ -238,7 +429,7 @@ func NonblockingRequest(data NonblockingRequestTaskData) {
 		err = json.Unmarshal(respBody, &j)
 		if err != nil {
 			es := fmt.Errorf("%v: %w", data.Messages.Error, err)
-			TaskErrorCh <- &TaskError{AppID: data.AppID, TaskID: taskID, Error: es}
+			sendTask(TaskErrorCh, &TaskError{AppID: data.AppID, TaskID: taskID, Error: es})
 			return
 		}
 		result = j
@@ -246,5 +437,5 @@ func NonblockingRequest(data NonblockingRequestTaskData) {
 		result = string(respBody)
 	}
 
-	TaskFinishCh <- &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: data.Messages.Success, Result: result}
+	sendTask(TaskFinishCh, &TaskFinish{AppID: data.AppID, TaskID: taskID, Message: data.Messages.Success, Result: result})
 }
@@ -0,0 +1,250 @@
+/*##### BEGIN GPL LICENSE BLOCK #####
+
+  This program is free software; you can redistribute it and/or
+  modify it under the terms of the GNU General Public License
+  as published by the Free Software Foundation; either version 2
+  of the License, or (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program; if not, write to the Free Software Foundation,
+  Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301, USA.
+
+##### END GPL LICENSE BLOCK #####*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockingFileDownloadHandler(t *testing.T) {
+	content := "0123456789"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unauthorized":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"detail": "Invalid token."}`)
+		case "/file":
+			if r.Header.Get("Authorization") != "Bearer key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var start int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil {
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, content[start:])
+				return
+			}
+			io.WriteString(w, content)
+		case "/ignores-range":
+			io.WriteString(w, content)
+		}
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientDownloads = c }(ClientDownloads)
+	ClientDownloads = server.Client()
+
+	tests := []struct {
+		name       string
+		path       string
+		resume     bool
+		part       string // content of .part file before the request
+		wantStatus int
+		wantFile   bool
+		wantPart   string // content of .part file after the request, "" if it should not exist
+		wantResp   BlockingFileDownloadResponse
+	}{
+		{"downloaded", "/file", false, "", http.StatusOK, true, "", BlockingFileDownloadResponse{BytesWritten: 10}},
+		{"resumed", "/file", true, "01234", http.StatusOK, true, "", BlockingFileDownloadResponse{BytesWritten: 5, Resumed: true}},
+		{"part ignored without resume", "/file", false, "abc", http.StatusOK, true, "", BlockingFileDownloadResponse{BytesWritten: 10}},
+		{"server ignores range", "/ignores-range", true, "01234", http.StatusOK, true, "", BlockingFileDownloadResponse{BytesWritten: 10}},
+		{"unauthorized leaves no file", "/unauthorized", false, "", http.StatusUnauthorized, false, "", BlockingFileDownloadResponse{}},
+		{"unauthorized keeps part for resume", "/unauthorized", true, "01234", http.StatusUnauthorized, false, "01234", BlockingFileDownloadResponse{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "resolution_2K.blend")
+			if tt.part != "" {
+				if err := os.WriteFile(filePath+".part", []byte(tt.part), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, _ := json.Marshal(BlockingFileDownloadTaskData{AppID: 1234, APIKey: "key", URL: server.URL + tt.path, Filepath: filePath, Resume: tt.resume})
+			req := httptest.NewRequest(http.MethodPost, "/wrappers/blocking_file_download", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			BlockingFileDownloadHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				var got BlockingFileDownloadResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatalf("invalid response: %v", err)
+				}
+				if got != tt.wantResp {
+					t.Errorf("response = %+v; want %+v", got, tt.wantResp)
+				}
+			} else {
+				var envelope ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != ErrCodeUpstream {
+					t.Errorf("response = %s; want %s error", rec.Body.String(), ErrCodeUpstream)
+				}
+			}
+
+			downloaded, err := os.ReadFile(filePath)
+			if tt.wantFile && (err != nil || string(downloaded) != content) {
+				t.Errorf("file = %q, %v; want %q", downloaded, err, content)
+			}
+			if !tt.wantFile && err == nil {
+				t.Errorf("file exists after failed download")
+			}
+			part, err := os.ReadFile(filePath + ".part")
+			if tt.wantPart == "" && err == nil {
+				t.Errorf(".part file exists: %q", part)
+			}
+			if tt.wantPart != "" && string(part) != tt.wantPart {
+				t.Errorf(".part = %q; want %q", part, tt.wantPart)
+			}
+		})
+	}
+}
+
+func TestBlockingFileUploadHandler(t *testing.T) {
+	content := "resolution file content"
+	var gotMethod, gotBody, gotAuth string
+	var gotLength int64
+	var gotEncoding []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotMethod, gotBody, gotAuth = r.Method, string(body), r.Header.Get("Authorization")
+		gotLength, gotEncoding = r.ContentLength, r.TransferEncoding
+		if r.URL.Path == "/denied" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientUploads = c }(ClientUploads)
+	ClientUploads = server.Client()
+
+	filePath := filepath.Join(t.TempDir(), "resolution_2K.blend")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAuth   string
+	}{
+		{"uploaded", "/upload", http.StatusOK, "", "Bearer key"},
+		{"presigned URL without API key", "/upload?X-Amz-Signature=abc", http.StatusOK, "", ""},
+		{"upstream error propagated", "/denied", http.StatusForbidden, "<Error><Code>SignatureDoesNotMatch</Code></Error>", "Bearer key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"app_id": 1234, "api_key": "key", "url": "` + server.URL + tt.path + `", "filepath": ` + strings.ReplaceAll(`"`+filePath+`"`, `\`, `\\`) + `}`
+			req := httptest.NewRequest(http.MethodPost, "/wrappers/blocking_file_upload", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			BlockingFileUploadHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q; want %q", rec.Body.String(), tt.wantBody)
+			}
+			if gotMethod != http.MethodPut {
+				t.Errorf("upstream method = %s; want PUT", gotMethod)
+			}
+			if gotBody != content {
+				t.Errorf("upstream body = %q; want %q", gotBody, content)
+			}
+			if gotLength != int64(len(content)) || len(gotEncoding) != 0 {
+				t.Errorf("upstream Content-Length = %d, Transfer-Encoding = %v; want %d without chunking", gotLength, gotEncoding, len(content))
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("upstream Authorization = %q; want %q", gotAuth, tt.wantAuth)
+			}
+		})
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Errorf("file was removed: %v", err)
+	}
+}
+
+func TestBlockingRequestHandlerLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			io.WriteString(w, strings.Repeat("a", 2048))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+	defer func(c *http.Client) { ClientAPI = c }(ClientAPI)
+	ClientAPI = server.Client()
+	serverURL := server.URL
+	defer setServer(setServer(serverURL))
+	defer func(limit int64) { WrapperMaxResponseSize = limit }(WrapperMaxResponseSize)
+	WrapperMaxResponseSize = 1024
+
+	tests := []struct {
+		name       string
+		data       BlockingRequestData
+		wantStatus int
+		wantCode   string
+	}{
+		{"server host", BlockingRequestData{URL: server.URL + "/ok", Method: "GET"}, http.StatusOK, ""},
+		{"external host", BlockingRequestData{URL: "https://example.com/", Method: "GET"}, http.StatusBadRequest, ErrCodeInvalidField},
+		{"external host allowed", BlockingRequestData{URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/ok", Method: "GET", AllowExternal: true}, http.StatusOK, ""},
+		{"relative URL", BlockingRequestData{URL: "/api/v1/search/", Method: "GET"}, http.StatusBadRequest, ErrCodeInvalidField},
+		{"response too large", BlockingRequestData{URL: server.URL + "/big", Method: "GET"}, http.StatusBadGateway, ErrCodeUpstream},
+		{"timeout", BlockingRequestData{URL: server.URL + "/slow", Method: "GET", TimeoutS: 0.1}, http.StatusBadGateway, ErrCodeUpstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.data)
+			req := httptest.NewRequest(http.MethodPost, "/wrappers/blocking_request", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			BlockingRequestHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var envelope ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != tt.wantCode {
+				t.Errorf("response = %s; want %s error", rec.Body.String(), tt.wantCode)
+			}
+		})
+	}
+}
@@ -30,8 +30,20 @@ def handle_get_comments_task(task: daemon_tasks.Task):
     if task.status == "error":
         return bk_logger.warning(f"failed to get comments: {task.message}")
     if task.status == "finished":
+        asset_id = task.data["asset_id"]
         comments = task.result["results"]
-        store_comments_local(task.data["asset_id"], comments)
+        paging = global_vars.DATA["asset comments paging"].setdefault(asset_id, {})
+        if task.data.get("since"):  # poll got only the new comments, paging of the thread stays
+            comments = merge_comments_local(asset_id, comments)
+            paging["polled_at"] = task.result.get("polled_at")
+        else:
+            if task.data.get("page", 0) > 1:
+                comments = merge_comments_local(asset_id, comments)
+            else:  # next polls continue from the first page, later pages are older
+                paging["polled_at"] = task.result.get("polled_at")
+            paging["count"] = task.result.get("count")
+            paging["next_page"] = task.result.get("next_page")
+        store_comments_local(asset_id, comments)
         return
 
 
@@ -67,10 +79,31 @@ def get_comments_local(asset_id):
     return global_vars.DATA["asset comments"].get(asset_id)
 
 
+def merge_comments_local(asset_id, comments):
+    """Add comments of a next page or of a poll to the stored ones, comments already stored are updated in place."""
+    stored = list(get_comments_local(asset_id) or [])
+    positions = {comment["id"]: i for i, comment in enumerate(stored)}
+    for comment in comments:
+        if comment["id"] in positions:
+            stored[positions[comment["id"]]] = comment
+            continue
+        positions[comment["id"]] = len(stored)
+        stored.append(comment)
+    return stored
+
+
+def get_comments_paging_local(asset_id):
+    """Paging of the stored comments: count, next_page (None on the last page) and polled_at for polling with since."""
+    return global_vars.DATA["asset comments paging"].get(asset_id, {})
+
+
 ### NOTIFICATIONS
 def handle_notifications_task(task: daemon_tasks.Task):
     """Handle incomming task with notifications data."""
     if task.status == "finished":
+        avatars = task.result.get("actor_avatars") or {}
+        for notification in task.result.get("results", []):
+            notification["actor_avatar"] = avatars.get(str(notification["id"]))
         global_vars.DATA["bkit notifications"] = task.result
         return
     if task.status == "error":
@@ -32,6 +32,10 @@ from . import global_vars, reports, utils
 bk_logger = logging.getLogger(__name__)
 NO_PROXIES = {"http": "", "https": ""}
 TIMEOUT = (0.1, 1)
+API_QUALITY_MIN_REQUESTS = 10  # fewer requests are not enough to judge the connection
+API_QUALITY_MAX_ERROR_RATE = 0.1
+API_QUALITY_MAX_SEARCH_P95_MS = 5000
+CLIENT_ALREADY_RUNNING_EXIT_CODE = 3  # Client found compatible Client already serving its port
 
 
 def get_address() -> str:
@@ -125,6 +129,34 @@ def asset_search(data):
         return resp.json()
 
 
+def get_search_history(limit: int = 0):
+    """Get recent searches of the user from the BlenderKit-Client, newest first. Limit 0 returns all kept searches."""
+    data = ensure_minimal_data({"limit": limit})
+    with requests.Session() as session:
+        url = get_address() + "/search/history"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
+def clear_search_history():
+    """Remove recent searches of the user from the BlenderKit-Client."""
+    data = ensure_minimal_data()
+    with requests.Session() as session:
+        url = get_address() + "/search/history/clear"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
+def search_similar(data):
+    """Search for assets similar to the reference image at data["image_path"]."""
+    address = get_address()
+    data = ensure_minimal_data(data)
+    with requests.Session() as session:
+        url = address + "/search_similar"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp.json()
+
+
 # DOWNLOAD
 def asset_download(data):
     """Download specified asset."""
@@ -136,24 +168,57 @@ def asset_download(data):
         return resp.json()
 
 
+def verify_download(data, repair=False):
+    """Compare the asset files in the download directories, with repair sync them.
+    Takes the same data as asset_download().
+    """
+    data = ensure_minimal_data(dict(data, repair=repair))
+    with requests.Session() as session:
+        url = get_address() + "/downloads/verify"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp.json()
+
+
 def cancel_download(task_id):
     """Cancel the specified task with ID on the BlenderKit-Client."""
     address = get_address()
     data = ensure_minimal_data({"task_id": task_id})
     with requests.Session() as session:
         url = address + "/blender/cancel_download"
-        resp = session.get(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
+def prefetch_bookmarks(
+    download_dirs: list, resolution: str, asset_types: list = None, dry_run=False
+):
+    """Download bookmarked assets in background, so they are available offline.
+    With dry_run the result of the task only lists the assets and the total download size.
+    """
+    data = ensure_minimal_data(
+        {
+            "scene_id": utils.get_scene_id(),
+            "download_dirs": download_dirs,
+            "resolution": resolution,
+            "asset_types": asset_types or [],
+            "dry_run": dry_run,
+        }
+    )
+    with requests.Session() as session:
+        url = get_address() + "/downloads/prefetch_bookmarks"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
         return resp
 
 
 # UPLOAD
-def asset_upload(upload_data, export_data, upload_set):
-    """Upload specified asset."""
+def asset_upload(upload_data, export_data, upload_set, dry_run=False):
+    """Upload specified asset. Dry run only checks and packs the asset, nothing is uploaded."""
     data = {
         "PREFS": utils.get_preferences_as_dict(),
         "upload_data": upload_data,
         "export_data": export_data,
         "upload_set": upload_set,
+        "dry_run": dry_run,
     }
     data = ensure_minimal_data(data)
     with requests.Session() as session:
@@ -163,6 +228,34 @@ def asset_upload(upload_data, export_data, upload_set):
         return resp
 
 
+def get_upload_history(limit: int = 50):
+    """Get recent uploads and their verification status from the BlenderKit-Client."""
+    with requests.Session() as session:
+        url = get_address() + "/asset/upload/history"
+        resp = session.get(
+            url, params={"limit": limit}, timeout=TIMEOUT, proxies=NO_PROXIES
+        )
+        return resp
+
+
+def recheck_upload(asset_id: str, api_key: str):
+    """Fetch current verification status of uploaded asset and update it in the upload history."""
+    data = ensure_minimal_data({"asset_id": asset_id, "api_key": api_key})
+    with requests.Session() as session:
+        url = get_address() + "/asset/upload/recheck"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
+def acknowledge_disclaimer(slug: str):
+    """Mark the disclaimer as seen, so BlenderKit-Client does not deliver it again."""
+    data = ensure_minimal_data({"slug": slug})
+    with requests.Session() as session:
+        url = get_address() + "/disclaimer/acknowledge"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
 ### PROFILES
 def download_gravatar_image(
     author_data,
@@ -175,7 +268,7 @@ def download_gravatar_image(
     }
     data = ensure_minimal_data(data)
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/profiles/download_gravatar_image",
             json=data,
             timeout=TIMEOUT,
@@ -189,7 +282,7 @@ def get_user_profile():
     """
     data = ensure_minimal_data()
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/profiles/get_user_profile",
             json=data,
             timeout=TIMEOUT,
@@ -198,9 +291,12 @@ def get_user_profile():
 
 
 ### COMMENTS
-def get_comments(asset_id, api_key=""):
-    """Get all comments on the asset."""
-    data = ensure_minimal_data({"asset_id": asset_id})
+def get_comments(asset_id, api_key="", page=1, since=""):
+    """Get a page of comments on the asset. Since is polled_at of the previous result, to get only new comments."""
+    data = {"asset_id": asset_id, "page": page}
+    if since:
+        data["since"] = since
+    data = ensure_minimal_data(data)
     with requests.Session() as session:
         return session.post(
             f"{get_address()}/comments/get_comments",
@@ -292,7 +388,7 @@ def report_usages(data: dict):
 def get_rating(asset_id: str):
     data = ensure_minimal_data({"asset_id": asset_id})
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/ratings/get_rating",
             json=data,
             timeout=TIMEOUT,
@@ -320,7 +416,7 @@ def send_rating(asset_id: str, rating_type: str, rating_value: str):
 def get_bookmarks():
     data = ensure_minimal_data()
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/ratings/get_bookmarks",
             json=data,
             timeout=TIMEOUT,
@@ -344,7 +440,7 @@ def get_download_url(asset_data, scene_id, api_key):
     }
     data = ensure_minimal_data(data)
     with requests.Session() as session:
-        resp = session.get(
+        resp = session.post(
             f"{get_address()}/wrappers/get_download_url",
             json=data,
             timeout=TIMEOUT,
@@ -354,6 +450,30 @@ def get_download_url(asset_data, scene_id, api_key):
         return (resp["can_download"], resp["download_url"], resp["filename"])
 
 
+def get_asset_resolutions(asset_data, download_dirs):
+    """Get downloadable files of the asset with their sizes and whether they are already on disk.
+    This is a blocking wrapper, will not return until results are available.
+    Returns: list of dicts with file_type, label, file_size, size_label, cached and file_path.
+    """
+    data = {
+        "asset_base_id": asset_data.get("assetBaseId", ""),
+        "id": asset_data.get("id", ""),
+        "name": asset_data.get("name", ""),
+        "files": asset_data.get("files", []),
+        "download_dirs": download_dirs,
+    }
+    data = ensure_minimal_data(data)
+    with requests.Session() as session:
+        resp = session.post(
+            f"{get_address()}/asset/resolutions",
+            json=data,
+            timeout=TIMEOUT,
+            proxies=NO_PROXIES,
+        )
+        resp.raise_for_status()
+        return resp.json()["resolutions"]
+
+
 def complete_upload_file_blocking(
     api_key, asset_id, filepath, filetype: str, fileindex: int
 ) -> bool:
@@ -368,7 +488,7 @@ def complete_upload_file_blocking(
     }
     data = ensure_minimal_data(data)
     with requests.Session() as session:
-        resp = session.get(
+        resp = session.post(
             f"{get_address()}/wrappers/complete_upload_file_blocking",
             json=data,
             timeout=(1, 600),
@@ -379,15 +499,21 @@ def complete_upload_file_blocking(
         return resp.ok
 
 
-def blocking_file_download(url: str, filepath: str, api_key: str) -> requests.Response:
-    """Upload file to server. This is a blocking wrapper, will not return until results are available."""
+def blocking_file_download(
+    url: str, filepath: str, api_key: str, resume: bool = False
+) -> requests.Response:
+    """Download file from server. This is a blocking wrapper, will not return until results are available.
+    On success returns JSON {bytes_written, resumed}. With resume=True the download continues from filepath.part left by failed download.
+    """
     data = {
         "url": url,
         "filepath": filepath,
+        "api_key": api_key,
+        "resume": resume,
     }
     data = ensure_minimal_data(data)
     with requests.Session() as session:
-        resp = session.get(
+        resp = session.post(
             f"{get_address()}/wrappers/blocking_file_download",
             json=data,
             timeout=(1, 600),
@@ -396,6 +522,25 @@ def blocking_file_download(url: str, filepath: str, api_key: str) -> requests.Re
         return resp
 
 
+def blocking_file_upload(url: str, filepath: str, api_key: str) -> requests.Response:
+    """Upload file to the URL with PUT. This is a blocking wrapper, will not return until the upload finishes.
+    Status code and body of the response are the ones returned by the upload URL."""
+    data = {
+        "url": url,
+        "filepath": filepath,
+        "api_key": api_key,
+    }
+    data = ensure_minimal_data(data)
+    with requests.Session() as session:
+        resp = session.post(
+            f"{get_address()}/wrappers/blocking_file_upload",
+            json=data,
+            timeout=(1, 600),
+            proxies=NO_PROXIES,
+        )
+        return resp
+
+
 def blocking_request(
     url: str,
     method: str = "GET",
@@ -415,7 +560,7 @@ def blocking_request(
     if json_data != None:
         data["json"] = json_data
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/wrappers/blocking_request",
             json=data,
             timeout=timeout,
@@ -430,9 +575,12 @@ def nonblocking_request(
     headers: dict = None,
     json_data: dict = None,
     messages: dict = None,
+    files: list = None,
 ) -> requests.Response:
     """Make non-blocking HTTP request through BlenderKit-Client.
     This function will return ASAP, not returning any actual data.
+    Files are list of dicts {field_name, filepath, content_type}, request is then sent as multipart form
+    with json_data as form fields.
     """
     if headers is None:
         headers = {}
@@ -447,8 +595,10 @@ def nonblocking_request(
     data = ensure_minimal_data(data)
     if json_data != None:
         data["json"] = json_data
+    if files:
+        data["files"] = files
     with requests.Session() as session:
-        return session.get(
+        return session.post(
             f"{get_address()}/wrappers/nonblocking_request",
             json=data,
             timeout=TIMEOUT,
@@ -485,7 +635,7 @@ def refresh_token(refresh_token, old_api_key):
     data = ensure_minimal_data({"refresh_token": refresh_token})
     with requests.Session() as session:
         url = get_address() + "/refresh_token"
-        resp = session.get(
+        resp = session.post(
             url,
             json=data,
             timeout=TIMEOUT,
@@ -494,13 +644,22 @@ def refresh_token(refresh_token, old_api_key):
         return resp
 
 
+def manual_login(api_key: str):
+    """Login with API key pasted by the user. BlenderKit-Client validates the key and on success sends login task to all add-ons."""
+    data = ensure_minimal_data({"api_key": api_key})
+    with requests.Session() as session:
+        url = get_address() + "/token/manual_login"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
 def oauth2_logout():
     """Logout from OAUTH2. BlenderKit-Client will revoke the token on the server."""
     data = ensure_minimal_data()
     data["refresh_token"] = global_vars.PREFS["api_key_refresh"]
     with requests.Session() as session:
         url = get_address() + "/oauth2/logout"
-        resp = session.get(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
         return resp
 
 
@@ -510,7 +669,7 @@ def unsubscribe_addon():
     data = ensure_minimal_data()
     with requests.Session() as session:
         url = address + "/blender/unsubscribe_addon"
-        resp = session.get(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
         return resp
 
 
@@ -520,7 +679,54 @@ def shutdown_client():
     data = ensure_minimal_data()
     with requests.Session() as session:
         url = address + "/shutdown"
-        resp = session.get(url, data=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp = session.post(url, data=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        return resp
+
+
+def reload_network_settings():
+    """Send current network preferences to BlenderKit-Client, which rebuilds its HTTP clients without a restart."""
+    data = ensure_minimal_data(
+        {
+            "proxy_which": global_vars.PREFS.get("proxy_which", ""),
+            "proxy_address": global_vars.PREFS.get("proxy_address", ""),
+            "proxy_pac_url": global_vars.PREFS.get("proxy_pac_url", ""),
+            "proxy_bypass": global_vars.PREFS.get("proxy_bypass", ""),
+            "ssl_context": global_vars.PREFS.get("ssl_context", ""),
+            "trusted_ca_certs": global_vars.PREFS.get("trusted_ca_certs", ""),
+        }
+    )
+    with requests.Session() as session:
+        url = get_address() + "/settings/reload_network"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp.raise_for_status()
+        return resp
+
+
+def update_download_dirs():
+    """Send the global directory and the project subdirectory of the saved .blend file to BlenderKit-Client as download directories of this add-on.
+    Client writes files of blocking_file_download only into the download directories of the connected add-ons and its temp directory.
+    """
+    preferences = bpy.context.preferences.addons[__package__].preferences
+    download_dirs = [bpy.path.abspath(preferences.global_dir)]
+    if preferences.directory_behaviour in ("BOTH", "LOCAL") and bpy.data.is_saved:
+        download_dirs.append(bpy.path.abspath(preferences.project_subdir))
+    data = ensure_minimal_data({"download_dirs": download_dirs})
+    with requests.Session() as session:
+        url = get_address() + "/settings/update"
+        resp = session.post(url, json=data, timeout=TIMEOUT, proxies=NO_PROXIES)
+        resp.raise_for_status()
+        return resp
+
+
+def set_server(server: str):
+    """Switch BlenderKit-Client to another BlenderKit server, e.g. staging or enterprise instance.
+    All add-ons connected to the Client then get server_changed task.
+    """
+    data = ensure_minimal_data({"server": server})
+    with requests.Session() as session:
+        url = get_address() + "/settings/set_server"
+        resp = session.post(url, json=data, timeout=(0.1, 15), proxies=NO_PROXIES)
+        resp.raise_for_status()
         return resp
 
 
@@ -528,13 +734,47 @@ def handle_client_status_task(task):
     if global_vars.CLIENT_RUNNING is False:
         wm = bpy.context.window_manager
         wm.blenderkitUI.logo_status = "logo"
+        try:
+            update_download_dirs()
+        except Exception as e:
+            bk_logger.warning(f"Update of download directories failed: {e}")
     global_vars.CLIENT_RUNNING = True
 
+    result = task.result if isinstance(task.result, dict) else {}
+    global_vars.CLIENT_API_QUALITY = result.get("api_quality") or {}
+    temp_path = result.get("temp_path")
+    if not temp_path or temp_path.get("path") == global_vars.CLIENT_TEMP_PATH:
+        return
+    global_vars.CLIENT_TEMP_PATH = temp_path.get("path", "")
+    if temp_path.get("fallback"):
+        reports.add_report(
+            f"System temp directory is unusable ({temp_path.get('reason')}), BlenderKit temp files are in {global_vars.CLIENT_TEMP_PATH}",
+            10,
+            "INFO",
+        )
+
+
+def api_quality_hint() -> str:
+    """Short hint for the header when requests to the server are failing or slow, empty if they are fine."""
+    quality = global_vars.CLIENT_API_QUALITY
+    if quality.get("requests", 0) < API_QUALITY_MIN_REQUESTS:
+        return ""
+    if quality.get("error_rate", 0) > API_QUALITY_MAX_ERROR_RATE:
+        return "Server errors"
+    if quality.get("search_p95_ms", 0) > API_QUALITY_MAX_SEARCH_P95_MS:
+        return "Slow connection"
+    return ""
+
 
 def check_blenderkit_client_exit_code() -> tuple[int, str]:
     exit_code = global_vars.client_process.poll()
     if exit_code is None:
         return exit_code, "BlenderKit-Client process is running."
+    if exit_code == CLIENT_ALREADY_RUNNING_EXIT_CODE:
+        return (
+            exit_code,
+            f"BlenderKit-Client is already running on port {get_port()}, using it.",
+        )
 
     message = f"BlenderKit-Client process exited with code {exit_code}. Please report a bug and paste content of log {get_client_log_path()}"
     return exit_code, message
@@ -567,12 +807,18 @@ def start_blenderkit_client():
                     global_vars.PREFS.get("proxy_which", ""),
                     "--proxy_address",
                     global_vars.PREFS.get("proxy_address", ""),
+                    "--proxy_pac_url",
+                    global_vars.PREFS.get("proxy_pac_url", ""),
+                    "--proxy_bypass",
+                    global_vars.PREFS.get("proxy_bypass", ""),
                     "--trusted_ca_certs",
                     global_vars.PREFS.get("trusted_ca_certs", ""),
                     "--ssl_context",
                     global_vars.PREFS.get("ssl_context", ""),
                     "--version",
                     f"{global_vars.VERSION[0]}.{global_vars.VERSION[1]}.{global_vars.VERSION[2]}.{global_vars.VERSION[3]}",
+                    "--addon_module_name",
+                    __package__,
                 ],
                 stdout=log,
                 stderr=log,
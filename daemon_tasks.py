@@ -33,6 +33,9 @@ class Task:
         progress: int = 0,
         status: str = "created",
         result: dict = None,
+        error_code: str = "",
+        speed_bps: float = 0,
+        eta_s: int = 0,
     ):
         if task_id == "":
             task_id = str(uuid.uuid4())
@@ -46,6 +49,9 @@ class Task:
         self.message_detailed = message_detailed
         self.progress = progress
         self.status = status  # created / finished / error
+        self.error_code = error_code  # machine readable reason of error, e.g. login_required
+        self.speed_bps = speed_bps  # transfer speed of downloads and uploads, 0 if unknown
+        self.eta_s = eta_s  # estimated seconds left of the transfer, 0 if unknown
         if result != None:
             self.result = result.copy()
         else:
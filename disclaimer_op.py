@@ -23,7 +23,7 @@ import time
 import bpy
 from bpy.props import BoolProperty, IntProperty, StringProperty
 
-from . import daemon_tasks, global_vars, paths, reports, tasks_queue, utils
+from . import daemon_lib, daemon_tasks, global_vars, paths, reports, tasks_queue, utils
 from .bl_ui_widgets.bl_ui_button import BL_UI_Button
 from .bl_ui_widgets.bl_ui_drag_panel import BL_UI_Drag_Panel
 from .bl_ui_widgets.bl_ui_draw_op import BL_UI_OT_draw_operator
@@ -284,6 +284,11 @@ def handle_disclaimer_task(task: daemon_tasks.Task):
             (run_disclaimer_task, (disclaimer["message"], disclaimer["url"], False)),
             wait=0,
         )
+        if disclaimer.get("slug"):
+            try:
+                daemon_lib.acknowledge_disclaimer(disclaimer["slug"])
+            except Exception as e:
+                bk_logger.warning(f"Could not acknowledge disclaimer: {e}")
         return
 
     if task.status == "error":
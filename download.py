@@ -692,6 +692,15 @@ def replace_resolution_appended(file_paths, asset_data, resolution):
 #     return .5
 
 
+# what user can do about denied download, by error_code of the task
+DOWNLOAD_ERROR_ACTIONS = {
+    "login_required": "Please log in to download this asset.",
+    "plan_required": "Upgrade to Full Plan at blenderkit.com/plans/pricing/ to download this asset.",
+    "private_asset": "This asset is private, only its author can download it.",
+    "invalid_api_key": "Please log in again or paste your API key again in the add-on preferences.",
+}
+
+
 def handle_download_task(task: daemon_tasks.Task):
     """Handle incoming task information.
     Update progress. Print messages. Fire post-download functions.
@@ -713,6 +722,9 @@ def handle_download_task(task: daemon_tasks.Task):
             task.message = f"Append failed, download_post() returned: {successful}"
 
     if task.status == "error":
+        call_to_action = DOWNLOAD_ERROR_ACTIONS.get(task.error_code)
+        if call_to_action:
+            task.message = f"{task.message} {call_to_action}"
         reports.add_report(task.message, 15, "ERROR")
         download_tasks.pop(task.task_id)
     else:
@@ -734,6 +746,12 @@ def download_write_progress(task_id, task):
         return
     task_addon["progress"] = task.progress
     task_addon["text"] = task.message
+    if task.speed_bps > 0:
+        task_addon["text"] += f" – {task.speed_bps / 1024 / 1024:.1f} MB/s"
+        if task.eta_s > 0:
+            minutes, seconds = divmod(task.eta_s, 60)
+            left = f"{minutes}m {seconds}s" if minutes else f"{seconds}s"
+            task_addon["text"] += f" – {left} left"
 
     # go through search results to write progress to display progress bars
     sr = global_vars.DATA.get("search results")
@@ -1071,6 +1089,33 @@ def asset_in_scene(asset_data):
     return False, None
 
 
+def handle_web_get_asset_task(task: daemon_tasks.Task):
+    """Download the asset requested by the website ("Get this asset in Blender" button) to the 3D cursor."""
+    if task.status != "finished":
+        return
+    asset_data = search.parse_result(task.result["asset_data"])
+    if not has_asset_files(asset_data):
+        reports.add_report(
+            f"Asset {asset_data['displayName']} has no files. Author should reupload the asset.",
+            15,
+            "ERROR",
+        )
+        return
+    preferences = bpy.context.preferences.addons[__package__].preferences
+    reports.add_report(f"Getting {asset_data['displayName']} from the website")
+    kwargs = {
+        "cast_parent": "",
+        "target_object": "",
+        "material_target_slot": 0,
+        "model_location": tuple(bpy.context.scene.cursor.location),
+        "model_rotation": (0, 0, 0),
+        "replace": False,
+        "replace_resolution": False,
+        "resolution": resolutions.resolution_props_to_server[preferences.resolution],
+    }
+    start_download(asset_data, **kwargs)
+
+
 def start_download(asset_data, **kwargs) -> bool:
     """Check if file isn't downloading or is not in scene, then start new download.
     Return true if new download was started.
@@ -1133,10 +1178,50 @@ class BlenderkitKillDownloadOperator(bpy.types.Operator):
         return {"FINISHED"}
 
 
+# Size and cache state of the resolutions of the asset in the resolution popup, keyed by the enum value.
+# Filled in invoke of the download operator, so the dropdown does not call the Client on every redraw.
+resolution_details = {}
+# Blender needs the strings of dynamic enum items to stay referenced, so the last items are kept here.
+_resolution_items = []
+
+
+def update_resolution_details(asset_data):
+    """Get sizes and cache state of the asset resolutions from the Client for the resolution popup."""
+    global resolution_details
+    resolution_details = {}
+    server_to_props = {v: k for k, v in resolutions.resolution_props_to_server.items()}
+    try:
+        asset_resolutions = daemon_lib.get_asset_resolutions(
+            asset_data, paths.get_download_dirs(asset_data["assetType"])
+        )
+    except Exception as e:
+        bk_logger.warning(f"Could not get asset resolutions: {e}")
+        return
+    for res in asset_resolutions:
+        prop = server_to_props.get(res["file_type"])
+        if prop is None:
+            continue
+        details = []
+        if res.get("size_label"):
+            details.append(res["size_label"])
+        if res.get("cached"):
+            details.append("cached")
+        resolution_details[prop] = ", ".join(details)
+
+
+def resolution_item_label(prop, label):
+    """Annotate the label of the resolution with its size and cache state, e.g. "2048 – 48.0 MB, cached"."""
+    details = resolution_details.get(prop)
+    if not details:
+        return label
+    return f"{label} – {details}"
+
+
 def available_resolutions_callback(self, context):
     """Checks active asset for available resolutions and offers only those available
     TODO: this currently returns always the same list of resolutions, make it actually work
     """
+    global _resolution_items
 
     pat_items = (
         ("512", "512", "", 1),
@@ -1148,8 +1233,11 @@ def available_resolutions_callback(self, context):
     items = []
     for item in pat_items:
         if int(self.max_resolution) >= int(item[0]):
-            items.append(item)
-    items.append(("ORIGINAL", "Original", "", 6))
+            items.append(
+                (item[0], resolution_item_label(item[0], item[1]), item[2], item[3])
+            )
+    items.append(("ORIGINAL", resolution_item_label("ORIGINAL", "Original"), "", 6))
+    _resolution_items = items
     return items
 
 
@@ -1384,6 +1472,7 @@ class BlenderkitDownloadOperator(bpy.types.Operator):
         # only make a pop up in case of switching resolutions
         if self.invoke_resolution:
             self.asset_data = self.get_asset_data(context)
+            update_resolution_details(self.asset_data)
             preferences = bpy.context.preferences.addons[__package__].preferences
 
             # set initial resolutions enum activation
@@ -29,12 +29,18 @@ CLIENT_PORTS = ["62485", "65425", "55428", "49452", "35452", "25152", "5152", "1
 """Ports are ordered during the start, and later after malfunction."""
 
 CLIENT_RUNNING = False
+CLIENT_TEMP_PATH = ""
+"""Directory of the Client temp files, reported in client_status task."""
+CLIENT_API_QUALITY = {}
+"""Latency and error rate of the requests to the server in the last hour, reported in client_status task."""
+
 DATA = {
     "images available": {},
     "search history": deque(maxlen=20),
     "bkit notifications": None,
     "bkit authors": {},
     "asset comments": {},
+    "asset comments paging": {},
     "asset ratings": {},
 }
 LOGGING_LEVEL_BLENDERKIT = INFO
@@ -17,6 +17,7 @@
 # ##### END GPL LICENSE BLOCK #####
 
 import getpass
+import hashlib
 import logging
 import os
 import re
@@ -24,6 +25,7 @@ import shutil
 import sys
 import subprocess
 import tempfile
+import urllib.parse
 
 import bpy
 
@@ -49,6 +51,31 @@ BLENDERKIT_LOGIN_URL = f"{global_vars.SERVER}/accounts/login"
 BLENDERKIT_SIGNUP_URL = f"{global_vars.SERVER}/accounts/register"
 
 WINDOWS_PATH_LIMIT = 250
+DOWNLOAD_PATH_LIMIT = 260  # WindowsPathLimit in download.go, MAX_PATH including the terminating null character
+MIN_SHORTENED_SLUG_LEN = 8  # minShortenedSlugLen in download.go, shorter slugs are replaced by a hash
+LEGACY_DIR_SLUG_LEN = 16  # older add-on versions cut the slug in the asset directory name to 16 characters
+
+
+def update_server_urls():
+    """Rebuild the URLs of the server pages after global_vars.SERVER changed at runtime."""
+    global BLENDERKIT_API, BLENDERKIT_OAUTH_LANDING_URL, BLENDERKIT_PLANS_URL
+    global BLENDERKIT_REPORT_URL, BLENDERKIT_USER_ASSETS_URL, BLENDERKIT_LOGIN_URL
+    global BLENDERKIT_SIGNUP_URL, BLENDERKIT_MODEL_UPLOAD_INSTRUCTIONS_URL
+    global BLENDERKIT_MATERIAL_UPLOAD_INSTRUCTIONS_URL, BLENDERKIT_BRUSH_UPLOAD_INSTRUCTIONS_URL
+    global BLENDERKIT_HDR_UPLOAD_INSTRUCTIONS_URL, BLENDERKIT_SCENE_UPLOAD_INSTRUCTIONS_URL
+    server = global_vars.SERVER
+    BLENDERKIT_API = f"{server}/api/v1"
+    BLENDERKIT_OAUTH_LANDING_URL = f"{server}/oauth-landing"
+    BLENDERKIT_PLANS_URL = f"{server}/plans/pricing"
+    BLENDERKIT_REPORT_URL = f"{server}/usage_report"
+    BLENDERKIT_USER_ASSETS_URL = f"{server}/my-assets"
+    BLENDERKIT_MODEL_UPLOAD_INSTRUCTIONS_URL = f"{server}/docs/upload/"
+    BLENDERKIT_MATERIAL_UPLOAD_INSTRUCTIONS_URL = f"{server}/docs/uploading-material/"
+    BLENDERKIT_BRUSH_UPLOAD_INSTRUCTIONS_URL = f"{server}/docs/uploading-brush/"
+    BLENDERKIT_HDR_UPLOAD_INSTRUCTIONS_URL = f"{server}/docs/uploading-hdr/"
+    BLENDERKIT_SCENE_UPLOAD_INSTRUCTIONS_URL = f"{server}/docs/uploading-scene/"
+    BLENDERKIT_LOGIN_URL = f"{server}/accounts/login"
+    BLENDERKIT_SIGNUP_URL = f"{server}/accounts/register"
 
 
 def cleanup_old_directories():
@@ -212,14 +239,46 @@ def slugify(input: str) -> str:
     return slug
 
 
+MAX_FILENAME_LENGTH = 128  # same as MaxFilenameLength in utils.go
+UUID_IN_FILENAME_REGEX = re.compile(
+    r"[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"
+)
+
+
 def extract_filename_from_url(url):
-    """Mirrors utils.go/ExtractFilenameFromURL()"""
-    if url is None:
+    """Mirrors utils.go/ExtractFilenameFromURL(), both must return the same names, so the add-on finds the files downloaded by the Client.
+    Server filenames contain UUID of the file. If not, short hash of the whole URL is added before the extension.
+    Returns empty string where Go returns an error.
+    """
+    if not url:
+        return ""
+
+    path = urllib.parse.unquote(urllib.parse.urlsplit(url).path)
+    stripped = path.rstrip("/")  # path.Base() in Go
+    if path == "" or stripped == "":
+        return ""
+    filename = stripped.rsplit("/", 1)[-1]
+    if filename in (".", ".."):
         return ""
 
-    filename = url.split("/")[-1]
-    filename = filename.split("?")[0]
-    return filename
+    url_hash = hashlib.sha256(url.encode("utf-8")).hexdigest()[:8]
+    dot = filename.rfind(".")  # path.Ext() in Go, also for names starting with the dot
+    ext = filename[dot:] if dot >= 0 else ""
+    stem = filename[: len(filename) - len(ext)]
+    # url.QueryEscape() in Go, addon needs files to have %2C instead of ,
+    ext = urllib.parse.quote_plus(ext, safe="")
+    stem = urllib.parse.quote_plus(stem, safe="")
+    if not UUID_IN_FILENAME_REGEX.search(filename):
+        stem = f"{stem}_{url_hash}"
+    if len(ext) > MAX_FILENAME_LENGTH // 2:
+        return ""
+    if len(stem) + len(ext) > MAX_FILENAME_LENGTH:
+        stem = stem[: MAX_FILENAME_LENGTH - len(ext) - len(url_hash) - 1]
+        i = stem.rfind("%")
+        if i >= 0 and i >= len(stem) - 2:  # do not cut escaped character in half
+            stem = stem[:i]
+        stem = f"{stem}_{url_hash}"
+    return stem + ext
 
 
 resolution_suffix = {
@@ -315,29 +374,76 @@ def get_texture_directory(asset_data, resolution="blend"):
 
 
 def get_asset_directories(asset_data):
-    """Only get path where all asset files are stored."""
+    """Only get path where all asset files are stored.
+    Directory named by older add-on versions is returned if only that one exists.
+    """
     name_slug = slugify(asset_data["name"])
-    if len(name_slug) > 16:
-        name_slug = name_slug[:16]
     asset_dir_name = f"{name_slug}_{asset_data['id']}"
+    legacy_dir_name = f"{name_slug[:LEGACY_DIR_SLUG_LEN]}_{asset_data['id']}"
     dirs = get_download_dirs(asset_data["assetType"])
     asset_dirs = []
     for d in dirs:
         asset_dir_path = os.path.join(d, asset_dir_name)
+        legacy_dir_path = os.path.join(d, legacy_dir_name)
+        if not os.path.exists(asset_dir_path) and os.path.exists(legacy_dir_path):
+            asset_dir_path = legacy_dir_path
         asset_dirs.append(asset_dir_path)
     return asset_dirs
 
 
+def download_filepath(dir, asset_name, asset_id, server_filename, limit):
+    """Path of the asset file in the dir: dir/slug_assetID/slug_resolution_fileID.blend. Mirrors download.go/downloadFilepath(),
+    the Client downloads into the same paths, shortened on Windows if not shorter than limit.
+    Returns the path and whether it was shortened, None if even the shortest path is too long. Limit 0 means no limit.
+    """
+    slug = slugify(asset_name)
+
+    def build(slug, slug_dir):
+        asset_dir_name = f"{slug}_{asset_id}" if slug_dir else asset_id
+        local_filename = server_to_local_filename(server_filename, slug)
+        return os.path.normpath(os.path.join(dir, asset_dir_name, local_filename))
+
+    def length(path):
+        return len(path.encode("utf-8"))  # Go counts bytes
+
+    file_path = build(slug, True)
+    if limit == 0 or length(file_path) < limit:
+        return file_path, False
+
+    # Slug is both in the directory and file name
+    slug_len = (limit - 1 - (length(file_path) - 2 * len(slug))) // 2
+    if slug_len >= MIN_SHORTENED_SLUG_LEN:
+        short = slug[:slug_len].strip("-")
+        if short != "":
+            return build(short, True), True
+
+    name_hash = hashlib.sha256(asset_name.encode("utf-8")).hexdigest()[:6]
+    file_path = build(name_hash, False)
+    if length(file_path) < limit:
+        return file_path, True
+    return None, False
+
+
+def legacy_download_filepath(dir, asset_name, asset_id, server_filename):
+    """Path of the asset file as older add-on versions named it: slug in the directory name was cut to 16 characters.
+    Used only to find the files downloaded before, new files are downloaded into download_filepath().
+    """
+    slug = slugify(asset_name)
+    asset_dir_name = f"{slug[:LEGACY_DIR_SLUG_LEN]}_{asset_id}"
+    local_filename = server_to_local_filename(server_filename, slug)
+    return os.path.normpath(os.path.join(dir, asset_dir_name, local_filename))
+
+
 def get_download_filepaths(asset_data, resolution="blend", can_return_others=False):
-    """Get all possible paths of the asset and resolution. Usually global and local directory."""
+    """Get all possible paths of the asset and resolution. Usually global and local directory.
+    Paths are the same as the Client downloads into, see download_filepath().
+    File downloaded by older add-on versions is returned instead, if only that one exists, see legacy_download_filepath().
+    """
     dirs = get_download_dirs(asset_data["assetType"])
     res_file, resolution = get_res_file(
         asset_data, resolution, find_closest_with_url=can_return_others
     )
-    name_slug = slugify(asset_data["name"])
-    if len(name_slug) > 16:
-        name_slug = name_slug[:16]
-    asset_dir_name = f"{name_slug}_{asset_data['id']}"
+    limit = DOWNLOAD_PATH_LIMIT if sys.platform == "win32" else 0
 
     file_names = []
 
@@ -348,11 +454,16 @@ def get_download_filepaths(asset_data, resolution="blend", can_return_others=Fal
         # Tweak the names a bit:
         # remove resolution and blend words in names
         #
-        serverFilename = extract_filename_from_url(res_file["url"])
-        localFilename = server_to_local_filename(serverFilename, asset_data["name"])
+        server_filename = extract_filename_from_url(res_file["url"])
+        # compressed files are stored decompressed, mirrors download_compression.go/localAssetFilename()
+        for suffix in (".gz", ".zst"):
+            if server_filename.endswith(suffix):
+                server_filename = server_filename[: -len(suffix)]
         for dir in dirs:
-            asset_dir_path = os.path.join(dir, asset_dir_name)
-            if sys.platform == "win32" and len(asset_dir_path) > WINDOWS_PATH_LIMIT:
+            file_name, _ = download_filepath(
+                dir, asset_data["name"], asset_data["id"], server_filename, limit
+            )
+            if file_name is None:
                 reports.add_report(
                     "The path to assets is too long, "
                     "only Global directory can be used. "
@@ -363,25 +474,17 @@ def get_download_filepaths(asset_data, resolution="blend", can_return_others=Fal
                     "ERROR",
                 )
                 continue
+            legacy_file_name = legacy_download_filepath(
+                dir, asset_data["name"], asset_data["id"], server_filename
+            )
+            if not os.path.exists(file_name) and os.path.exists(legacy_file_name):
+                file_name = legacy_file_name
+            asset_dir_path = os.path.dirname(file_name)
             if not os.path.exists(asset_dir_path):
                 os.makedirs(asset_dir_path)
-
-            file_name = os.path.join(asset_dir_path, localFilename)
             file_names.append(file_name)
 
     utils.p("file paths", file_names)
-    for f in file_names:
-        if sys.platform == "win32" and len(f) > WINDOWS_PATH_LIMIT:
-            reports.add_report(
-                "The path to assets is too long, "
-                "only Global directory can be used. "
-                "Move your .blend file to another "
-                "directory with shorter path to "
-                "store assets in a subdirectory of your project.",
-                60,
-                "ERROR",
-            )
-            file_names.remove(f)
     return file_names
 
 
@@ -139,6 +139,12 @@ def load_preferences_from_JSON():
     user_preferences.proxy_address = prefs.get(
         "proxy_address", user_preferences.proxy_address
     )
+    user_preferences.proxy_pac_url = prefs.get(
+        "proxy_pac_url", user_preferences.proxy_pac_url
+    )
+    user_preferences.proxy_bypass = prefs.get(
+        "proxy_bypass", user_preferences.proxy_bypass
+    )
     user_preferences.trusted_ca_certs = prefs.get(
         "trusted_ca_certs", user_preferences.trusted_ca_certs
     )
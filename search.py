@@ -50,6 +50,13 @@ from . import (
 bk_logger = logging.getLogger(__name__)
 search_tasks = {}
 
+# result keys of full thumbnails by asset type, others use thumbnailMiddleUrl
+FULL_THUMBNAIL_KEYS = {
+    "hdr": "thumbnailLargeUrlNonsquared",
+    "scene": "thumbnailLargeUrlNonsquared",
+    "brush": "thumbnailSmallUrlNonsquared",
+}
+
 
 def update_ad(ad):
     if not ad.get("assetBaseId"):
@@ -170,6 +177,13 @@ def check_clipboard():
     search_props.search_keywords = current_clipboard[:asset_type_index].rstrip()
 
 
+def get_thumbnail_url(r, key, use_webp):
+    """Get thumbnail URL in preferred format, or in the other one if missing.
+    Mirrors thumbnailFallbackURL() in the Client."""
+    preferred, other = (f"{key}Webp", key) if use_webp else (key, f"{key}Webp")
+    return r.get(preferred) or r.get(other)
+
+
 def parse_result(r):
     """Needed to generate some extra data in the result(by now)
     Parameters
@@ -195,24 +209,12 @@ def parse_result(r):
         if bpy.app.version < (3, 4, 0) or r.get("webpGeneratedTimestamp", 0) == 0:
             use_webp = False  # WEBP was optimized in Blender 3.4.0
 
-        # BIG THUMB - HDR CASE
-        if r["assetType"] == "hdr":
-            if use_webp:
-                thumb_url = r.get("thumbnailLargeUrlNonsquaredWebp")
-            else:
-                thumb_url = r.get("thumbnailLargeUrlNonsquared")
-        # BIG THUMB - NON HDR CASE
-        else:
-            if use_webp:
-                thumb_url = r.get("thumbnailMiddleUrlWebp")
-            else:
-                thumb_url = r.get("thumbnailMiddleUrl")
+        # BIG THUMB - size by asset type, must match fullThumbnailSizes in the Client
+        thumb_key = FULL_THUMBNAIL_KEYS.get(r["assetType"], "thumbnailMiddleUrl")
+        thumb_url = get_thumbnail_url(r, thumb_key, use_webp)
 
         # SMALL THUMB
-        if use_webp:
-            small_thumb_url = r.get("thumbnailSmallUrlWebp")
-        else:
-            small_thumb_url = r.get("thumbnailSmallUrl")
+        small_thumb_url = get_thumbnail_url(r, "thumbnailSmallUrl", use_webp)
 
         tname = paths.extract_filename_from_url(thumb_url)
         small_tname = paths.extract_filename_from_url(small_thumb_url)
@@ -426,10 +428,14 @@ def handle_search_task(task: daemon_tasks.Task) -> bool:
 
 
 def handle_thumbnail_download_task(task: daemon_tasks.Task) -> None:
+    # finished task identifies the thumbnail in its result, older Clients only in data
+    thumbnail = task.data
+    if task.status == "finished" and "image_path" in task.result:
+        thumbnail = task.result
     if task.status == "finished":
-        global_vars.DATA["images available"][task.data["image_path"]] = True
+        global_vars.DATA["images available"][thumbnail["image_path"]] = True
     elif task.status == "error":
-        global_vars.DATA["images available"][task.data["image_path"]] = False
+        global_vars.DATA["images available"][thumbnail["image_path"]] = False
         if task.message != "":
             reports.add_report(task.message, 3, "ERROR")
     else:
@@ -437,12 +443,31 @@ def handle_thumbnail_download_task(task: daemon_tasks.Task) -> None:
     if asset_bar_op.asset_bar_operator is None:
         return
 
-    if task.data["thumbnail_type"] == "small":
-        asset_bar_op.asset_bar_operator.update_image(task.data["assetBaseId"])
+    # search for other asset type may run at the same time, its previews would land in the wrong grid
+    asset_type = thumbnail.get("asset_type", "")
+    ui_props = bpy.context.window_manager.blenderkitUI
+    if asset_type and asset_type != ui_props.asset_type.lower():
+        return
+
+    if thumbnail["thumbnail_type"] == "small":
+        asset_bar_op.asset_bar_operator.update_image(thumbnail["assetBaseId"])
         return
 
-    if task.data["thumbnail_type"] == "full":
-        asset_bar_op.asset_bar_operator.update_tooltip_image(task.data["assetBaseId"])
+    if thumbnail["thumbnail_type"] == "full":
+        asset_bar_op.asset_bar_operator.update_tooltip_image(thumbnail["assetBaseId"])
+
+
+def handle_web_search_asset_task(task: daemon_tasks.Task) -> None:
+    """Show the asset opened by a deep link on the website in the asset bar."""
+    if task.status != "finished":
+        return
+    asset_data = task.result["asset_data"]
+    reports.add_report(f"Opening {asset_data['displayName']} from the website")
+    # same input as the asset link copied from the website, search_update() switches the asset type and searches
+    sprops = utils.get_search_props()
+    sprops.search_keywords = (
+        f"asset_base_id:{asset_data['assetBaseId']} asset_type:{asset_data['assetType']}"
+    )
 
 
 def load_preview(asset):
@@ -619,13 +644,22 @@ def handle_fetch_gravatar_task(task: daemon_tasks.Task):
         global_vars.DATA["bkit authors"][author_id]["gravatarImg"] = gravatar_path
 
 
+def handle_gravatars_batch_task(task: daemon_tasks.Task):
+    """Handle gravatars_batch task which BlenderKit-Client sends after a search with paths to avatars of its authors."""
+    if task.status != "finished":
+        return
+    authors = global_vars.DATA["bkit authors"]
+    for author_id, gravatar_path in task.result.items():
+        if author_id in authors:
+            authors[author_id]["gravatarImg"] = gravatar_path
+
+
 def generate_author_profile(author_data):
-    """Generate author profile by creating author textblock and fetching gravatar image if needed.
-    Gravatar download is started in BlenderKit-Download and handled later."""
+    """Generate author profile by creating author textblock.
+    Gravatar image is downloaded by BlenderKit-Client after the search and handled in handle_gravatars_batch_task()."""
     author_id = str(author_data["id"])
     if author_id in global_vars.DATA["bkit authors"]:
         return
-    daemon_lib.download_gravatar_image(author_data)
     author_data["tooltip"] = generate_author_textblock(author_data)
     global_vars.DATA["bkit authors"][author_id] = author_data
     return
@@ -644,6 +678,21 @@ def handle_get_user_profile(task: daemon_tasks.Task):
         daemon_lib.download_gravatar_image(user_data["user"])
 
 
+def handle_user_quota(task: daemon_tasks.Task):
+    """Handle user_quota task sent after login, profile fetch and every upload.
+    Keeps the plan and private storage of the stored profile current without fetching the whole profile again.
+    """
+    if task.status != "finished":
+        return
+    profile = global_vars.DATA.get("bkit profile")
+    if profile is None:
+        return
+    quota = task.result
+    profile["user"]["currentPlanName"] = quota["plan_name"]
+    profile["user"]["sumPrivateAssetFilesSize"] = quota["private_storage_used"]
+    profile["user"]["remainingPrivateQuota"] = quota["private_storage_remaining"]
+
+
 def query_to_url(query={}, params={}):
     # build a new request
     url = f"{paths.BLENDERKIT_API}/search/"
@@ -869,12 +918,31 @@ def add_search_process(query, params):
         "tempdir": tempdir,
         "urlquery": urlquery,
         "asset_type": query["asset_type"],
+        "prefetch_next": True,
     }
     data.update(params)
     response = daemon_lib.asset_search(data)
     search_tasks[response["task_id"]] = data
 
 
+def add_similar_search_process(image_path: str, asset_type: str):
+    """Search for assets visually similar to the image, results replace the current results as of normal search."""
+    global search_tasks
+    search_tasks = dict()
+    data = {
+        "PREFS": utils.get_preferences_as_dict(),
+        "tempdir": paths.get_temp_dir("%s_search" % asset_type),
+        "image_path": image_path,
+        "asset_type": asset_type,
+    }
+    response = daemon_lib.search_similar(data)
+    if "task_id" not in response:
+        return reports.add_report(
+            f"Similar search failed: {response.get('error', {}).get('message', response)}", 5, "ERROR"
+        )
+    search_tasks[response["task_id"]] = data
+
+
 def get_search_simple(
     parameters, filepath=None, page_size=100, max_results=100000000, api_key=""
 ):
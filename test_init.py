@@ -68,6 +68,8 @@ class Test01Registration(unittest.TestCase):
             "ssl_context": user_preferences.ssl_context,
             "proxy_which": user_preferences.proxy_which,
             "proxy_address": user_preferences.proxy_address,
+            "proxy_pac_url": user_preferences.proxy_pac_url,
+            "proxy_bypass": user_preferences.proxy_bypass,
             "trusted_ca_certs": user_preferences.trusted_ca_certs,
             # UPDATES
             "auto_check_update": user_preferences.auto_check_update,
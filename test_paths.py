@@ -133,14 +133,14 @@ class TestServerToLocalFilename(unittest.TestCase):
 
 
 class TestExtractFilenameFromUrl(unittest.TestCase):
-    """Same test data as in utils_test.go/TestExtractFilenameFromUrl()"""
+    """Same test data as in utils_test.go/TestExtractFilenameFromURL()"""
 
     data = (
-        ("https://example.com/file.txt", "file.txt"),
-        ("https://example.com/path/to/file.jpg", "file.jpg"),
+        ("https://example.com/file.txt", "file_7a8c22e5.txt"),
+        ("https://example.com/path/to/file.jpg", "file_82946edc.jpg"),
         (
             "https://example.com/path/to/file%2Cwith%2Ccomma.txt",
-            "file%2Cwith%2Ccomma.txt",
+            "file%2Cwith%2Ccomma_374f7660.txt",
         ),
         (
             "https://public.blenderkit.com/thumbnails/assets/57ec74ff91b54b2ca5a540cda907cf7a/files/thumbnail_99e43644-30de-4361-9a7e-605eaf7d6795.jpg.256x256_q85_crop-%2C.jpg.webp?webp_generated=1701166007",
@@ -159,6 +159,20 @@ class TestExtractFilenameFromUrl(unittest.TestCase):
             "resolution_2K_02dacc88-532e-4b68-b8cb-4f1b8df1814b.blend",
         ),
         ("", ""),
+        # URLs differing only in query must not collide
+        ("https://cdn.example.com/avatar/thumb.jpg?v=1", "thumb_464d3164.jpg"),
+        ("https://cdn.example.com/avatar/thumb.jpg?v=2", "thumb_4d0da839.jpg"),
+        # Over-long names are capped, extension is kept and hash replaces the cut part
+        (
+            "https://example.com/"
+            + "a," * 100
+            + "_99e43644-30de-4361-9a7e-605eaf7d6795.blend",
+            "a%2C" * 28 + "a_f4594621.blend",
+        ),
+        # Malformed URLs, Go returns an error
+        ("https://example.com/", ""),
+        ("https://example.com", ""),
+        ("https://example.com/..", ""),
     )
 
     def test_extract_filename_from_url(self):
@@ -169,3 +183,80 @@ class TestExtractFilenameFromUrl(unittest.TestCase):
                 expected,
                 msg=f'extract_filename_from_url("{url}")="{result}"; expected:"{expected}"',
             )
+
+
+class TestDownloadFilepath(unittest.TestCase):
+    """Same test data as in download_test.go/TestDownloadFilepath()"""
+
+    asset_id = "0992088b-fb84-4c69-bb6e-426272970c8b"
+    server = "resolution_2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+    file_tail = "2K_d5368c9d-092e-4319-afe1-dd765de6da01.blend"
+    long_name = "Very Detailed Victorian Wooden Chair With Carved Ornaments And Cushion"
+
+    @staticmethod
+    def dir(length):
+        return "/" + "d" * (length - 1)
+
+    def test_download_filepath(self):
+        data = (
+            (
+                "short path",
+                "/projects",
+                "Kitten",
+                False,
+                f"/projects/kitten_{self.asset_id}/kitten_{self.file_tail}",
+            ),
+            (
+                "truncated slug",
+                self.dir(120),
+                self.long_name,
+                True,
+                f"{self.dir(120)}/very-detailed-victorian-woo_{self.asset_id}/very-detailed-victorian-woo_{self.file_tail}",
+            ),
+            (
+                "hash instead of slug",
+                self.dir(160),
+                self.long_name,
+                True,
+                f"{self.dir(160)}/{self.asset_id}/3186f9_{self.file_tail}",
+            ),
+            (
+                "too long even when shortened",
+                self.dir(250),
+                self.long_name,
+                False,
+                None,
+            ),
+        )
+        for name, dir, asset_name, shortened, expected in data:
+            result, short = paths.download_filepath(
+                dir, asset_name, self.asset_id, self.server, paths.DOWNLOAD_PATH_LIMIT
+            )
+            self.assertEqual(result, expected, msg=name)
+            self.assertEqual(short, shortened, msg=name)
+
+    def test_no_limit(self):
+        result, short = paths.download_filepath(
+            self.dir(200), self.long_name, self.asset_id, self.server, 0
+        )
+        self.assertFalse(short)
+        self.assertTrue(result.endswith(self.file_tail))
+
+    def test_legacy_download_filepath(self):
+        result = paths.legacy_download_filepath(
+            "/projects", self.long_name, self.asset_id, self.server
+        )
+        self.assertEqual(
+            result,
+            f"/projects/very-detailed-vi_{self.asset_id}/very-detailed-victorian-wooden-chair-with-carved-o_{self.file_tail}",
+        )
+        short, _ = paths.download_filepath(
+            "/projects", "Kitten", self.asset_id, self.server, 0
+        )
+        self.assertEqual(
+            paths.legacy_download_filepath(
+                "/projects", "Kitten", self.asset_id, self.server
+            ),
+            short,
+            msg="short names are the same in both namings",
+        )
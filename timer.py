@@ -64,6 +64,10 @@ def handle_failed_reports(exception: Exception) -> float:
 
     bk_logger.warning(f"Could not get reports: {exception}")
     return_code, meaning = daemon_lib.check_blenderkit_client_exit_code()
+    if return_code == daemon_lib.CLIENT_ALREADY_RUNNING_EXIT_CODE:
+        # existing Client answers the reports once it is responsive again, no new start needed
+        bk_logger.info(meaning)
+        return 1.0
     if return_code is None and global_vars.CLIENT_FAILED_REPORTS == 15:
         reports.add_report(
             "Client is not responding, add-on will not work.", 10, "ERROR"
@@ -119,6 +123,9 @@ def daemon_communication_timer():
             progress=task["progress"],
             status=task["status"],
             result=task["result"],
+            error_code=task.get("error_code", ""),
+            speed_bps=task.get("speed_bps", 0),
+            eta_s=task.get("eta_s", 0),
         )
         results_converted_tasks.append(task)
 
@@ -165,10 +172,45 @@ def save_prefs_cancel_all_tasks_and_restart_daemon(user_preferences, context):
         bk_logger.warning(str(e))
 
 
+def save_prefs_and_reload_network_settings(user_preferences, context):
+    """Save preferences and send the network settings to blenderkit-client, so it uses them without a restart.
+    Falls back to restart of the blenderkit-client if the reload fails.
+    """
+    utils.save_prefs(user_preferences, context)
+    if user_preferences.preferences_lock == True:
+        return
+
+    try:
+        daemon_lib.reload_network_settings()
+    except Exception as e:
+        bk_logger.warning(f"Reload of network settings failed, restarting client: {e}")
+        return save_prefs_cancel_all_tasks_and_restart_daemon(user_preferences, context)
+
+
+def save_prefs_and_update_download_dirs(user_preferences, context):
+    """Save preferences and send the download directories to blenderkit-client, so background Blender can download into them."""
+    utils.save_prefs(user_preferences, context)
+    if user_preferences.preferences_lock == True:
+        return
+
+    update_download_dirs()
+
+
+def update_download_dirs():
+    """Send the download directories to running blenderkit-client, e.g. after the .blend file was loaded or saved elsewhere.
+    Client which is not running yet gets them once it reports its status."""
+    if global_vars.CLIENT_RUNNING is False:
+        return
+    try:
+        daemon_lib.update_download_dirs()
+    except Exception as e:
+        bk_logger.warning(f"Update of download directories failed: {e}")
+
+
 def trusted_CA_certs_property_updated(user_preferences, context):
     """Update trusted CA certs environment variables and call save_prefs()."""
     update_trusted_CA_certs(user_preferences.trusted_ca_certs)
-    return save_prefs_cancel_all_tasks_and_restart_daemon(user_preferences, context)
+    return save_prefs_and_reload_network_settings(user_preferences, context)
 
 
 def update_trusted_CA_certs(certs: str):
@@ -193,6 +235,14 @@ def cancel_all_tasks(self, context):
 
 def task_error_overdrive(task: daemon_tasks.Task) -> None:
     """Handle error task - overdrive some error messages, trigger functions common for all errors."""
+    if task.error_code == "internal_panic":
+        reports.add_report(
+            f"BlenderKit-Client internal error in {task.task_type}. Please report a bug and paste content of log {daemon_lib.get_client_log_path()}",
+            10,
+            "ERROR",
+        )
+        return
+
     if task.message.count("Invalid token.") > 0 and utils.user_logged_in():
         preferences = bpy.context.preferences.addons[__package__].preferences
 
@@ -224,10 +274,25 @@ def handle_task(task: daemon_tasks.Task):
     if task.task_type == "asset_download":
         return download.handle_download_task(task)
 
+    if task.task_type == "web_get_asset":
+        return download.handle_web_get_asset_task(task)
+
+    if task.task_type == "web_search_asset":
+        return search.handle_web_search_asset_task(task)
+
     # HANDLE ASSET UPLOAD
     if task.task_type == "asset_upload":
         return upload.handle_asset_upload(task)
 
+    if task.task_type == "asset_upload_file":
+        return upload.handle_asset_upload_file(task)
+
+    if task.task_type == "asset_upload_confirmation":
+        return upload.handle_asset_upload_confirmation(task)
+
+    if task.task_type == "asset_upload_dry_run":
+        return upload.handle_asset_upload_dry_run(task)
+
     if task.task_type == "asset_metadata_upload":
         return upload.handle_asset_metadata_upload(task)
 
@@ -246,6 +311,9 @@ def handle_task(task: daemon_tasks.Task):
     if task.task_type == "login":
         return bkit_oauth.handle_login_task(task)
 
+    if task.task_type == "login/manual":
+        return bkit_oauth.handle_manual_login_task(task)
+
     # HANDLE TOKEN REFRESH
     if task.task_type == "token_refresh":
         return bkit_oauth.handle_token_refresh_task(task)
@@ -254,6 +322,22 @@ def handle_task(task: daemon_tasks.Task):
     if task.task_type == "oauth2/logout":
         return bkit_oauth.handle_logout_task(task)
 
+    # HANDLE RELOAD OF NETWORK SETTINGS
+    if task.task_type == "network_settings_reloaded":
+        return reports.add_report(task.message, 3, "INFO")
+
+    # HANDLE OVERLAPPING DOWNLOAD DIRECTORIES
+    if task.task_type == "download_dirs_overlap":
+        return reports.add_report(task.message, 10, "ERROR", details=task.message_detailed)
+
+    # HANDLE SERVER SWITCHED BY ANY BLENDER
+    if task.task_type == "server_changed":
+        return bkit_oauth.handle_server_changed_task(task)
+
+    # HANDLE CLIENT SETTINGS CHANGED BY ANOTHER BLENDER
+    if task.task_type == "settings_changed":
+        return bk_logger.info(f"{task.message}: {task.result}")
+
     # HANDLE CLIENT STATUS REPORT
     if task.task_type == "client_status":
         return daemon_lib.handle_client_status_task(task)
@@ -283,8 +367,12 @@ def handle_task(task: daemon_tasks.Task):
     # HANDLE PROFILE
     if task.task_type == "profiles/fetch_gravatar_image":
         return search.handle_fetch_gravatar_task(task)
+    if task.task_type == "gravatars_batch":
+        return search.handle_gravatars_batch_task(task)
     if task.task_type == "profiles/get_user_profile":
         return search.handle_get_user_profile(task)
+    if task.task_type == "user_quota":
+        return search.handle_user_quota(task)
 
     # HANDLE RATINGS
     if task.task_type == "ratings/get_rating":
@@ -810,6 +810,34 @@ class SetPrivateComment(bpy.types.Operator):
         return {"FINISHED"}
 
 
+class LoadMoreComments(bpy.types.Operator):
+    """Load next page of comments on the asset"""
+
+    bl_idname = "wm.blenderkit_load_more_comments"
+    bl_label = "Load more comments"
+    bl_options = {"REGISTER", "INTERNAL"}
+
+    asset_id: StringProperty(
+        name="Asset Base Id",
+        description="Unique id of the asset (hidden)",
+        default="",
+        options={"SKIP_SAVE"},
+    )
+
+    @classmethod
+    def poll(cls, context):
+        return True
+
+    def execute(self, context):
+        user_preferences = bpy.context.preferences.addons[__package__].preferences
+        paging = comments_utils.get_comments_paging_local(self.asset_id)
+        if paging.get("next_page"):
+            daemon_lib.get_comments(
+                self.asset_id, user_preferences.api_key, page=paging["next_page"]
+            )
+        return {"FINISHED"}
+
+
 # class DeleteComment(bpy.types.Operator):
 #     """Delete comment on BlenderKit server"""
 #     bl_idname = "wm.blenderkit_delete_comment"
@@ -895,6 +923,13 @@ def draw_notification(self, notification, width=600):
     firstline = f"{actor} {verb} {target_string}"
     # firstline = f"{notification_string}"
     box1 = box.box()
+    avatar_path = notification.get("actor_avatar")
+    if avatar_path:
+        avatar = autothumb.get_texture_ui(
+            avatar_path, f".blenderkit_avatar_{os.path.basename(avatar_path)}"
+        )
+        if avatar and avatar.image:
+            box1.template_icon(icon_value=avatar.image.preview.icon_id, scale=2.0)
     # row = box1.row()
 
     split_last = 0.7
@@ -2927,6 +2962,15 @@ class AssetPopupCard(bpy.types.Operator, ratings_utils.RatingProperties):
                 self.draw_comment(context, layout, comment, width=self.width)
                 if ui_props.reply_id == comment["id"]:
                     self.draw_comment_response(context, layout, comment["id"])
+            paging = comments_utils.get_comments_paging_local(
+                self.asset_data["assetBaseId"]
+            )
+            if paging.get("next_page"):
+                op = layout.operator(
+                    "wm.blenderkit_load_more_comments",
+                    text=f"Load more comments ({len(self.comments)}/{paging.get('count')})",
+                )
+                op.asset_id = self.asset_data["assetBaseId"]
 
     def execute(self, context):
         wm = context.window_manager
@@ -3389,6 +3433,10 @@ def header_search_draw(self, context):
         layout.label(text="Waiting for Client")
         return
 
+    quality_hint = daemon_lib.api_quality_hint()
+    if quality_hint:
+        layout.label(text=quality_hint, icon="ERROR")
+
     layout.prop(
         ui_props,
         "asset_type",
@@ -3524,6 +3572,7 @@ classes = (
     MarkNotificationRead,
     UpvoteComment,
     SetPrivateComment,
+    LoadMoreComments,
     PostComment,
     # DeleteComment,
     ShowNotifications,
@@ -1067,6 +1067,13 @@ class UploadOperator(Operator):
 
     main_file: BoolProperty(name="main file", default=False, options={"SKIP_SAVE"})
 
+    dry_run: BoolProperty(
+        name="dry run",
+        description="Only check and pack the asset, nothing is uploaded. The packed file is kept for inspection",
+        default=False,
+        options={"SKIP_SAVE"},
+    )
+
     @classmethod
     def poll(cls, context):
         return utils.uploadable_asset_poll()
@@ -1100,9 +1107,13 @@ class UploadOperator(Operator):
             return {"CANCELLED"}
 
         props.upload_state = "Upload initiating..."
+        if self.dry_run:
+            props.upload_state = "Upload dry run initiating..."
         props.uploading = True
 
-        daemon_lib.asset_upload(upload_data, export_data, upload_set)
+        daemon_lib.asset_upload(
+            upload_data, export_data, upload_set, dry_run=self.dry_run
+        )
         return {"FINISHED"}
 
     def draw(self, context):
@@ -1118,6 +1129,7 @@ class UploadOperator(Operator):
             # layout.prop(self, 'metadata')
             layout.prop(self, "main_file")
             layout.prop(self, "thumbnail")
+        layout.prop(self, "dry_run")
 
         if props.asset_base_id != "" and not self.reupload:
             utils.label_multiline(
@@ -1333,9 +1345,60 @@ def handle_asset_upload(task: daemon_tasks.Task):
 
     if task.status == "finished":
         asset.uploading = False
+        if task.result.get("confirmation_pending"):
+            return reports.add_report(
+                "Files uploaded, confirmation pending - retrying in background",
+                type="INFO",
+            )
         return reports.add_report("Upload successfull")
 
 
+def handle_asset_upload_dry_run(task: daemon_tasks.Task):
+    """Handle upload dry run, which only checked and packed the asset."""
+    asset = eval(f"{task.data['export_data']['eval_path']}.blenderkit")
+    asset.upload_state = task.message
+    if task.status == "error":
+        asset.uploading = False
+        return reports.add_report(
+            f"Upload dry run failed: {task.message}",
+            type="ERROR",
+            details=task.message_detailed,
+        )
+    if task.status == "finished":
+        asset.uploading = False
+        files = "\n".join(
+            f"{f['type']}: {f['file_path']} ({f['size'] / 1024 / 1024:.1f}MB)"
+            for f in task.result.get("files", [])
+        )
+        packed = task.result.get("packed_blend_path")
+        if packed:
+            files += f"\npacked file kept at {packed}"
+        return reports.add_report(task.message, type="INFO", details=files)
+
+
+def handle_asset_upload_confirmation(task: daemon_tasks.Task):
+    """Handle the background confirmation of upload which finished with confirmation pending."""
+    name = task.data["upload_data"].get("displayName", "")
+    if task.status == "error":
+        return reports.add_report(
+            f"Upload of {name} not confirmed: {task.message}",
+            type="ERROR",
+            details=task.message_detailed,
+        )
+    if task.status == "finished":
+        return reports.add_report(f"Upload of {name} confirmed")
+
+
+def handle_asset_upload_file(task: daemon_tasks.Task):
+    """Handle upload of one file of the asset upload. The error is reported to the user by the parent asset_upload task,
+    its message names the file which failed."""
+    file_type = task.data.get("file_type", "")
+    if task.status == "error":
+        return bk_logger.warning(f"Upload of {file_type} failed: {task.message}")
+    if task.status == "finished":
+        return bk_logger.info(f"Uploaded {file_type}: {task.data.get('file_path', '')}")
+
+
 def handle_asset_metadata_upload(task: daemon_tasks.Task):
     if task.status != "finished":
         return